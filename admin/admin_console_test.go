@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/spirit-labs/tektite/clustmgr"
 	"github.com/spirit-labs/tektite/conf"
+	"github.com/spirit-labs/tektite/expr"
 	"github.com/spirit-labs/tektite/levels"
 	"github.com/spirit-labs/tektite/mem"
 	"github.com/spirit-labs/tektite/opers"
@@ -753,6 +754,18 @@ func (t *testLevelMgrClient) StoreLastFlushedVersion(int64) error {
 	return nil
 }
 
+func (t *testLevelMgrClient) RegisterVersionRetentions([]retention.PrefixRetention) error {
+	return nil
+}
+
+func (t *testLevelMgrClient) StoreVersionTime(int64, int64) error {
+	return nil
+}
+
+func (t *testLevelMgrClient) GetVersionForTime(int64) (int64, error) {
+	return -1, nil
+}
+
 func (t *testLevelMgrClient) LoadLastFlushedVersion() (int64, error) {
 	return 0, nil
 }
@@ -806,10 +819,22 @@ func (t *testStreamManager) GetStream(string) *opers.StreamInfo {
 	panic("not implemented")
 }
 
+func (t *testStreamManager) ResolveTable(string) expr.LookupTable {
+	panic("not implemented")
+}
+
+func (t *testStreamManager) SetClusterMessageHandlers(*remoting.TeeBlockingClusterMessageHandler) {
+	panic("not implemented")
+}
+
 func (t *testStreamManager) GetAllStreams() []*opers.StreamInfo {
 	return t.allStreams
 }
 
+func (t *testStreamManager) HasVersionRetentions() bool {
+	panic("not implemented")
+}
+
 func (t *testStreamManager) GetKafkaEndpoint(string) *opers.KafkaEndpointInfo {
 	panic("not implemented")
 }
@@ -12,11 +12,14 @@ import (
 	"github.com/spirit-labs/tektite/command"
 	"github.com/spirit-labs/tektite/conf"
 	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/errors"
 	"github.com/spirit-labs/tektite/evbatch"
 	"github.com/spirit-labs/tektite/expr"
 	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/opers"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/query"
 	"github.com/spirit-labs/tektite/remoting"
 	"github.com/spirit-labs/tektite/testutils"
 	"github.com/spirit-labs/tektite/types"
@@ -25,10 +28,12 @@ import (
 	"golang.org/x/net/http2"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -90,6 +95,51 @@ test_stream ::=== (bridge from test_topic partitions = 23) -> (store stream)
 	require.NoError(t, err)
 }
 
+func TestExecuteSQLQuery(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+	for i, batch := range createBatches(t, 0, 10, 2) {
+		queryMgr.addBatch(batch, i == 1)
+	}
+
+	uri := fmt.Sprintf("https://%s/tektite/sql", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "SELECT * FROM some_table WHERE f0 > 10")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, createExpectedRows(t, 20), string(bodyBytes))
+
+	require.Equal(t, "(scan all from some_table)->(filter by (f0 > 10))", queryMgr.getDirectQueryTsl())
+}
+
+func TestPrepareSQLQuery(t *testing.T) {
+	server, _, commandMgr, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	uri := fmt.Sprintf("https://%s/tektite/sql", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "PREPARE my_query (int) AS SELECT f1 FROM some_table WHERE f0 = $1")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "prepare my_query($p1:int) := (scan all from some_table)->(filter by (f0 == $p1:int))->(project f1)",
+		commandMgr.getCommand())
+}
+
+func TestSQLParseError(t *testing.T) {
+	testErrorResponse(t, "/tektite/sql", "SELECT * FROM", "TEK1001 - reached end of statement\n",
+		http.StatusBadRequest, true)
+}
+
 func TestExecutePreparedStatementSingleBatch(t *testing.T) {
 	testExecutePreparedStatementWithArgsAllTypes(t, 1, 1, 10)
 }
@@ -187,6 +237,30 @@ func TestExecutePreparedStatementConvertTimestampArg(t *testing.T) {
 	testExecutePreparedStatementArg(t, "$p1:timestamp", types.ColumnTypeTimestamp, int64(123456), types.NewTimestamp(int64(123456)))
 }
 
+func TestConvertNestedArgs(t *testing.T) {
+	structType := &types.StructType{FieldNames: []string{"a", "b"},
+		FieldTypes: []types.ColumnType{types.ColumnTypeInt, &types.ArrayType{ElemType: types.ColumnTypeBool}}}
+	argTypes := []types.ColumnType{&types.ArrayType{ElemType: types.ColumnTypeInt},
+		&types.MapType{ValueType: &types.DecimalType{Precision: 10, Scale: 2}}, structType, structType}
+	args := []any{[]any{float64(1), nil, "3"}, map[string]any{"x": "1.25", "y": nil},
+		map[string]any{"a": float64(7), "b": []any{true, false}}, []any{nil, []any{}}}
+	converted, err := convertPreparedStatementArgs(args, argTypes)
+	require.NoError(t, err)
+	require.Equal(t, []any{int64(1), nil, int64(3)}, converted[0])
+	require.Equal(t, map[string]any{"x": types.Decimal{Num: decimal128.FromI64(125), Precision: 10, Scale: 2},
+		"y": nil}, converted[1])
+	require.Equal(t, []any{int64(7), []any{true, false}}, converted[2])
+	require.Equal(t, []any{nil, []any{}}, converted[3])
+
+	_, err = convertPreparedStatementArgs([]any{[]any{"foo"}}, argTypes[:1])
+	require.Error(t, err)
+	require.Equal(t, "argument 0 ([foo]) of type []interface {} cannot be converted to array<int>", err.Error())
+	_, err = convertPreparedStatementArgs([]any{map[string]any{"c": float64(1)}}, []types.ColumnType{structType})
+	require.Error(t, err)
+	require.Equal(t, "argument 0 (map[c:1]) of type map[string]interface {} cannot be converted to struct<a:int,b:array<bool>>",
+		err.Error())
+}
+
 func TestExecutePreparedStatementNullArg(t *testing.T) {
 	testExecutePreparedStatementArg(t, "$p1:int", types.ColumnTypeInt, nil, nil)
 }
@@ -289,6 +363,59 @@ func TestWasmUnregister(t *testing.T) {
 	require.Equal(t, "test_mod23", modName)
 }
 
+func TestProtobufRegister(t *testing.T) {
+	descriptorManager := &testProtobufDescriptorManager{}
+	server, _, _, _ := startServerWithDescriptorManager(t, descriptorManager)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	uri := fmt.Sprintf("https://%s/tektite/protobuf-register", server.ListenAddress())
+	descriptorSet := []byte("descriptorsetbytes")
+	registration := &ProtobufRegistration{
+		Name:          "orders",
+		DescriptorSet: base64.StdEncoding.EncodeToString(descriptorSet),
+	}
+	buff, err := json.Marshal(registration)
+	require.NoError(t, err)
+	resp := sendPostRequest(t, client, uri, string(buff))
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	name, setBytes := descriptorManager.getRegistrationInfo()
+	require.Equal(t, "orders", name)
+	require.Equal(t, descriptorSet, setBytes)
+
+	// Errors from the descriptor manager are returned
+	descriptorManager.setError(errors.NewTektiteError(errors.ProtobufError, "descriptor set 'orders' already registered"))
+	resp2 := sendPostRequest(t, client, uri, string(buff))
+	defer closeRespBody(t, resp2)
+	require.Equal(t, http.StatusBadRequest, resp2.StatusCode)
+	bodyBytes, err := io.ReadAll(resp2.Body)
+	require.NoError(t, err)
+	require.Equal(t, "TEK1005 - descriptor set 'orders' already registered\n", string(bodyBytes))
+}
+
+func TestProtobufUnregister(t *testing.T) {
+	descriptorManager := &testProtobufDescriptorManager{}
+	server, _, _, _ := startServerWithDescriptorManager(t, descriptorManager)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	uri := fmt.Sprintf("https://%s/tektite/protobuf-unregister", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "orders")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "orders", descriptorManager.getUnregistrationInfo())
+}
+
 func sendPostRequest(t *testing.T, client *http.Client, uri string, body string) *http.Response {
 	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewBufferString(body))
 	require.NoError(t, err)
@@ -528,6 +655,56 @@ func decodeReceivedBatches(buff []byte) []*evbatch.Batch {
 	return batches
 }
 
+func createNestedBatch() *evbatch.Batch {
+	schema := evbatch.NewEventSchema([]string{"f0", "f1", "f2", "f3"}, []types.ColumnType{types.ColumnTypeInt,
+		&types.ArrayType{ElemType: types.ColumnTypeString}, &types.MapType{ValueType: types.ColumnTypeInt},
+		&types.StructType{FieldNames: []string{"b", "a"}, FieldTypes: []types.ColumnType{
+			&types.DecimalType{Precision: 10, Scale: 2}, types.ColumnTypeBytes}}})
+	builders := evbatch.CreateColBuilders(schema.ColumnTypes())
+	builders[0].(*evbatch.IntColBuilder).Append(1)
+	builders[1].(*evbatch.NestedColBuilder).Append([]any{"x", nil})
+	builders[2].(*evbatch.NestedColBuilder).Append(map[string]any{"k1": int64(10), "k2": nil})
+	builders[3].(*evbatch.NestedColBuilder).Append([]any{types.Decimal{Num: decimal128.FromI64(1234), Precision: 10,
+		Scale: 2}, []byte("abc")})
+	builders[0].(*evbatch.IntColBuilder).Append(2)
+	builders[1].AppendNull()
+	builders[2].(*evbatch.NestedColBuilder).Append(map[string]any{})
+	builders[3].(*evbatch.NestedColBuilder).Append([]any{nil, nil})
+	return evbatch.NewBatchFromBuilders(schema, builders...)
+}
+
+func TestJSONLinesWriterNestedColumns(t *testing.T) {
+	batch := createNestedBatch()
+	writer := &jsonLinesBatchWriter{}
+	recorder := httptest.NewRecorder()
+	err := writer.WriteHeaders(batch.Schema.ColumnNames(), batch.Schema.ColumnTypes(), recorder)
+	require.NoError(t, err)
+	err = writer.WriteBatch(batch, recorder)
+	require.NoError(t, err)
+	expected := `["f0","f1","f2","f3"]
+["int","array\u003cstring\u003e","map\u003cstring,int\u003e","struct\u003cb:decimal(10,2),a:bytes\u003e"]
+[1,["x",null],{"k1":10,"k2":null},{"b":"12.34","a":"abc"}]
+[2,null,{},{"b":null,"a":null}]
+`
+	require.Equal(t, expected, recorder.Body.String())
+}
+
+func TestArrowWriterNestedColumns(t *testing.T) {
+	batch := createNestedBatch()
+	writer := &ArrowBatchWriter{}
+	recorder := httptest.NewRecorder()
+	err := writer.WriteHeaders(batch.Schema.ColumnNames(), batch.Schema.ColumnTypes(), recorder)
+	require.NoError(t, err)
+	err = writer.WriteBatch(batch, recorder)
+	require.NoError(t, err)
+	receivedBatches := decodeReceivedBatches(recorder.Body.Bytes())
+	require.Equal(t, 1, len(receivedBatches))
+	for i, ft := range batch.Schema.ColumnTypes() {
+		require.True(t, types.ColumnTypesEqual(ft, receivedBatches[0].Schema.ColumnTypes()[i]))
+	}
+	require.True(t, batch.Equal(receivedBatches[0]))
+}
+
 func TestHttp2Only(t *testing.T) {
 	testErrorResponse(t, "/tektite/query", "",
 		"the tektite HTTP API supports HTTP2 only\n", http.StatusHTTPVersionNotSupported, false)
@@ -612,6 +789,95 @@ func testExecutePreparedStatementInvalidArg(t *testing.T, paramType types.Column
 	require.NoError(t, err)
 }
 
+func TestExecutePreparedStatementWithCursor(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	batches := createBatches(t, 0, 10, 1)
+	queryMgr.addBatch(batches[0], true)
+	queryMgr.setParamMetaData([]string{}, []types.ColumnType{})
+	queryMgr.limit = 10
+
+	uri := fmt.Sprintf("https://%s/tektite/exec", server.ListenAddress())
+	execPage := func(cursor string) string {
+		invocation := &PreparedStatementInvocation{
+			QueryName: "test_query",
+			Cursor:    cursor,
+		}
+		buff, err := json.Marshal(&invocation)
+		require.NoError(t, err)
+		resp := sendPostRequest(t, client, uri, string(buff))
+		defer closeRespBody(t, resp)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, createExpectedRows(t, 10), string(bodyBytes))
+		return resp.Header.Get(CursorHeaderName)
+	}
+
+	// The first page is executed at the last completed version
+	cursor := execPage("")
+	require.NotEqual(t, "", cursor)
+	highestVersion, pageOffset := queryMgr.getPageState()
+	require.Equal(t, int64(23), highestVersion)
+	require.Equal(t, 0, pageOffset)
+
+	// And subsequent pages at the same version
+	cursor = execPage(cursor)
+	require.NotEqual(t, "", cursor)
+	highestVersion, pageOffset = queryMgr.getPageState()
+	require.Equal(t, int64(23), highestVersion)
+	require.Equal(t, 10, pageOffset)
+
+	cursor = execPage(cursor)
+	highestVersion, pageOffset = queryMgr.getPageState()
+	require.Equal(t, int64(23), highestVersion)
+	require.Equal(t, 20, pageOffset)
+
+	// No cursor is returned when the page is not full
+	queryMgr.limit = 20
+	cursor = execPage(cursor)
+	require.Equal(t, "", cursor)
+}
+
+func TestExecutePreparedStatementInvalidCursor(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	queryMgr.setParamMetaData([]string{}, []types.ColumnType{})
+
+	uri := fmt.Sprintf("https://%s/tektite/exec", server.ListenAddress())
+	execWithCursor := func(cursor string) string {
+		invocation := &PreparedStatementInvocation{
+			QueryName: "test_query",
+			Cursor:    cursor,
+		}
+		buff, err := json.Marshal(&invocation)
+		require.NoError(t, err)
+		resp := sendPostRequest(t, client, uri, string(buff))
+		defer closeRespBody(t, resp)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		bodyBytes, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(bodyBytes)
+	}
+
+	require.Equal(t, "TEK1003 - prepared query 'test_query' does not have a limit so cannot be executed with a cursor\n",
+		execWithCursor("foo"))
+	queryMgr.limit = 10
+	require.Equal(t, "TEK1003 - invalid cursor 'foo'\n", execWithCursor("foo"))
+}
+
 func TestUnknownQuery(t *testing.T) {
 	invocation := &PreparedStatementInvocation{
 		QueryName: "unknown_query",
@@ -621,6 +887,128 @@ func TestUnknownQuery(t *testing.T) {
 	testErrorResponse(t, "/tektite/exec", string(body), "TEK1003 - unknown prepared query 'unknown_query'\n", http.StatusBadRequest, true)
 }
 
+func TestExecuteQueryWithLimits(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	uri := fmt.Sprintf("https://%s/tektite/query?timeout=30s&max_rows_scanned=1000&max_memory_bytes=2000",
+		server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "(scan all from some_table)")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, query.Limits{Timeout: 30 * time.Second, MaxRowsScanned: 1000, MaxMemoryBytes: 2000},
+		queryMgr.getLimits())
+}
+
+func TestExecuteQueryInvalidLimits(t *testing.T) {
+	testErrorResponse(t, "/tektite/query?timeout=foo", "(scan all from some_table)",
+		"TEK1003 - invalid timeout 'foo'\n", http.StatusBadRequest, true)
+	testErrorResponse(t, "/tektite/query?max_rows_scanned=foo", "(scan all from some_table)",
+		"TEK1003 - invalid max_rows_scanned 'foo'\n", http.StatusBadRequest, true)
+	testErrorResponse(t, "/tektite/query?max_memory_bytes=1.5", "(scan all from some_table)",
+		"TEK1003 - invalid max_memory_bytes '1.5'\n", http.StatusBadRequest, true)
+}
+
+func TestExecuteQueryFailsWhileExecuting(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	batches := createBatches(t, 0, 5, 2)
+	for i, batch := range batches {
+		queryMgr.addBatch(batch, i == len(batches)-1)
+	}
+	queryMgr.queryErr = errors.NewTektiteErrorf(errors.ExecuteQueryError,
+		"query exceeded the maximum of 10 rows scanned and has been cancelled")
+
+	uri := fmt.Sprintf("https://%s/tektite/query", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "(scan all from some_table)")
+	defer closeRespBody(t, resp)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	// The rows received before the query failed are followed by the error
+	expected := createExpectedRows(t, 5) +
+		"TEK1003 - query exceeded the maximum of 10 rows scanned and has been cancelled\n"
+	require.Equal(t, expected, string(bodyBytes))
+}
+
+func TestListRunningQueries(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	uri := fmt.Sprintf("https://%s/tektite/queries", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "")
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "[]", string(bodyBytes))
+
+	startTime := time.Date(2024, 3, 14, 10, 11, 12, 0, time.UTC)
+	queryMgr.runningQueries = []query.RunningQuery{
+		{ID: "query1", TSL: "(scan all from some_table)", Version: 23, StartTime: startTime},
+		{ID: "query2", QueryName: "some_query", Version: 24, StartTime: startTime.Add(time.Second)},
+	}
+	resp = sendPostRequest(t, client, uri, "")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var infos []RunningQueryInfo
+	err = json.NewDecoder(resp.Body).Decode(&infos)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(infos))
+	require.Equal(t, "query1", infos[0].ID)
+	require.Equal(t, "(scan all from some_table)", infos[0].TSL)
+	require.Equal(t, "", infos[0].QueryName)
+	require.Equal(t, int64(23), infos[0].Version)
+	require.Equal(t, "2024-03-14T10:11:12Z", infos[0].StartTime)
+	require.Greater(t, infos[0].RunningMs, int64(0))
+	require.Equal(t, "query2", infos[1].ID)
+	require.Equal(t, "some_query", infos[1].QueryName)
+	require.Equal(t, "", infos[1].TSL)
+	require.Equal(t, int64(24), infos[1].Version)
+	require.Equal(t, "2024-03-14T10:11:13Z", infos[1].StartTime)
+}
+
+func TestKillQuery(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	defer client.CloseIdleConnections()
+
+	queryMgr.runningQueries = []query.RunningQuery{
+		{ID: "query1", TSL: "(scan all from some_table)", StartTime: time.Now()},
+	}
+	uri := fmt.Sprintf("https://%s/tektite/kill-query", server.ListenAddress())
+	resp := sendPostRequest(t, client, uri, "query1")
+	closeRespBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 0, len(queryMgr.RunningQueries()))
+
+	resp = sendPostRequest(t, client, uri, "query1")
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "TEK1003 - unknown query 'query1'\n", string(bodyBytes))
+}
+
 func testErrorResponse(t *testing.T, path string, body string, errorMsg string, statusCode int, http2 bool) {
 	server, _, _, _ := startServer(t)
 	defer func() {
@@ -648,17 +1036,24 @@ func testErrorResponse(t *testing.T, path string, body string, errorMsg string,
 }
 
 func startServer(t *testing.T) (*HTTPAPIServer, *testQueryManager, *testCommandManager, *testWasmModuleManager) {
+	t.Helper()
+	return startServerWithDescriptorManager(t, &testProtobufDescriptorManager{})
+}
+
+func startServerWithDescriptorManager(t *testing.T, descriptorManager *testProtobufDescriptorManager) (*HTTPAPIServer,
+	*testQueryManager, *testCommandManager, *testWasmModuleManager) {
 	t.Helper()
 	tlsConf := conf.TLSConfig{
 		Enabled:  true,
 		KeyPath:  serverKeyPath,
 		CertPath: serverCertPath,
 	}
-	queryMgr := &testQueryManager{}
+	queryMgr := &testQueryManager{lastCompletedVersion: 23, versionListeners: map[string]func(int64){}}
 	commandMgr := &testCommandManager{}
 	moduleManager := &testWasmModuleManager{}
 	address := fmt.Sprintf("localhost:%d", testutils.PortProvider.GetPort(t))
-	server := NewHTTPAPIServer(address, "/tektite", queryMgr, commandMgr, parser.NewParser(nil), moduleManager, tlsConf)
+	server := NewHTTPAPIServer(address, "/tektite", queryMgr, commandMgr, parser.NewParser(nil), moduleManager,
+		descriptorManager, tlsConf)
 	err := server.Activate()
 	require.NoError(t, err)
 	return server, queryMgr, commandMgr, moduleManager
@@ -809,13 +1204,182 @@ type testQueryManager struct {
 	numLast int
 
 	paramSchema *evbatch.EventSchema
+	limit       int
+
+	highestVersion int64
+	page           opers.PageStart
 
 	receiverPrepareQueryDesc *parser.PrepareQueryDesc
 	directQueryTsl           string
+
+	lastCompletedVersion int64
+	versionBatches       map[int64][]*evbatch.Batch
+	versionListeners     map[string]func(int64)
+	executedVersions     []int64
+	// keyedResults are the results of the query executed by key as of each version, and changedKeyResults the results
+	// for the keys changed as of each version. Queries are only executed by key when keyedResults is not nil.
+	keyedResults      map[int64]*query.KeyedResults
+	changedKeyResults map[int64]*query.KeyedResults
+	executedKeyed     []string
+
+	limits         query.Limits
+	queryErr       error
+	runningQueries []query.RunningQuery
 }
 
 func (t *testQueryManager) GetLastCompletedVersion() int {
-	return 0
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return int(t.lastCompletedVersion)
+}
+
+// setVersionBatches sets the results of a direct query executed as of the version. The tables are considered changed
+// only in the versions that have results set.
+func (t *testQueryManager) setVersionBatches(version int64, batches ...*evbatch.Batch) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.versionBatches == nil {
+		t.versionBatches = map[int64][]*evbatch.Batch{}
+	}
+	t.versionBatches[version] = batches
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithArgs(string, parser.PrepareQueryDesc, []any, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithHighestVersion(tsl string, _ parser.QueryDesc, highestVersion int64, _ query.Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.executeDirect(tsl, highestVersion, outputFunc)
+	return nil
+}
+
+func (t *testQueryManager) ExecuteQueryDirectIfChanged(tsl string, _ parser.QueryDesc, _ int64, highestVersion int64,
+	_ query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.versionBatches[highestVersion]; !ok {
+		return false, nil
+	}
+	t.executeDirect(tsl, highestVersion, outputFunc)
+	return true, nil
+}
+
+func (t *testQueryManager) ExecuteQueryDirectByKey(_ string, _ parser.QueryDesc, highestVersion int64,
+	_ query.Limits) (*query.KeyedResults, bool, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.keyedResults == nil {
+		return nil, false, nil
+	}
+	t.executedKeyed = append(t.executedKeyed, fmt.Sprintf("all:%d", highestVersion))
+	return t.keyedResults[highestVersion], true, nil
+}
+
+func (t *testQueryManager) ExecuteQueryDirectForChangedKeys(_ parser.QueryDesc, changedAfterVersion int64,
+	highestVersion int64) (*query.KeyedResults, bool, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.keyedResults == nil {
+		return nil, false, nil
+	}
+	results, ok := t.changedKeyResults[highestVersion]
+	if !ok {
+		return nil, false, nil
+	}
+	t.executedKeyed = append(t.executedKeyed, fmt.Sprintf("changed:%d-%d", changedAfterVersion, highestVersion))
+	return results, true, nil
+}
+
+// setKeyedResults sets the results of the query executed by key as of the version, and if changed is true, the
+// results for the keys changed as of the version
+func (t *testQueryManager) setKeyedResults(version int64, changed bool, rows map[string]*evbatch.Batch) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.keyedResults == nil {
+		t.keyedResults = map[int64]*query.KeyedResults{}
+		t.changedKeyResults = map[int64]*query.KeyedResults{}
+	}
+	results := &query.KeyedResults{Schema: subscribeSchema, Rows: rows}
+	if changed {
+		t.changedKeyResults[version] = results
+	} else {
+		t.keyedResults[version] = results
+	}
+}
+
+func (t *testQueryManager) getExecutedKeyed() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.executedKeyed
+}
+
+func (t *testQueryManager) getExecutedVersions() []int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.executedVersions
+}
+
+func (t *testQueryManager) executeDirect(tsl string, highestVersion int64,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) {
+	t.directQueryTsl = tsl
+	t.executedVersions = append(t.executedVersions, highestVersion)
+	batches := t.versionBatches[highestVersion]
+	go func() {
+		if len(batches) == 0 {
+			if err := outputFunc(true, 1, nil, nil); err != nil {
+				panic(err)
+			}
+			return
+		}
+		for _, batch := range batches {
+			if err := outputFunc(true, len(batches), batch, nil); err != nil {
+				panic(err)
+			}
+		}
+	}()
+}
+
+func (t *testQueryManager) RegisterCompletedVersionListener(listenerName string, listener func(version int64)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.versionListeners[listenerName] = listener
+}
+
+func (t *testQueryManager) UnregisterCompletedVersionListener(listenerName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.versionListeners, listenerName)
+}
+
+func (t *testQueryManager) RunningQueries() []query.RunningQuery {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.runningQueries
+}
+
+func (t *testQueryManager) CancelQuery(queryID string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i, q := range t.runningQueries {
+		if q.ID == queryID {
+			t.runningQueries = append(t.runningQueries[:i], t.runningQueries[i+1:]...)
+			return nil
+		}
+	}
+	return errors.NewTektiteErrorf(errors.ExecuteQueryError, "unknown query '%s'", queryID)
+}
+
+func (t *testQueryManager) CancelRemoteQuery(*clustermsgs.QueryCancelMessage) {
+}
+
+func (t *testQueryManager) numVersionListeners() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.versionListeners)
 }
 
 func (t *testQueryManager) GetLastFlushedVersion() int {
@@ -836,10 +1400,11 @@ func (t *testQueryManager) Stop() error {
 	return nil
 }
 
-func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) error {
+func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, limits query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	t.directQueryTsl = tsl
+	t.limits = limits
 	t.sendBatches(outputFunc)
 	return nil
 }
@@ -888,26 +1453,40 @@ func (t *testQueryManager) PrepareQuery(prepareQuery parser.PrepareQueryDesc) er
 	return nil
 }
 
-func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, limits query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
+	t.limits = limits
 	t.queryName = queryName
 	t.args = args
 	t.sendBatches(outputFunc)
 	return 0, nil
 }
 
-func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) {
+func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) {
+	queryErr := t.queryErr
 	go func() {
+		if queryErr != nil {
+			// The query fails after sending the first batch
+			if len(t.batches) > 0 {
+				if err := outputFunc(false, t.numLast, t.batches[0].batch, nil); err != nil {
+					panic(err)
+				}
+			}
+			if err := outputFunc(true, 1, nil, queryErr); err != nil {
+				panic(err)
+			}
+			return
+		}
 		if len(t.batches) == 0 {
-			err := outputFunc(true, 1, nil)
+			err := outputFunc(true, 1, nil, nil)
 			if err != nil {
 				panic(err)
 			}
 			return
 		}
 		for _, info := range t.batches {
-			err := outputFunc(info.last, t.numLast, info.batch)
+			err := outputFunc(info.last, t.numLast, info.batch, nil)
 			if err != nil {
 				panic(err)
 			}
@@ -915,11 +1494,38 @@ func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches
 	}()
 }
 
-func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(queryName string, args []any, highestVersion int64,
+	page opers.PageStart, limits query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.limits = limits
+	t.queryName = queryName
+	t.args = args
+	t.highestVersion = highestVersion
+	t.page = page
+	t.sendBatches(outputFunc)
 	return 0, nil
 }
 
-func (t *testQueryManager) SetLastCompletedVersion(int64) {
+func (t *testQueryManager) getLimits() query.Limits {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.limits
+}
+
+func (t *testQueryManager) getPageState() (int64, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.highestVersion, t.page.Offset
+}
+
+func (t *testQueryManager) SetLastCompletedVersion(version int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lastCompletedVersion = version
+	for _, listener := range t.versionListeners {
+		listener(version)
+	}
 }
 
 func (t *testQueryManager) SetLastCompletedVersionAllNodes() {
@@ -936,6 +1542,25 @@ func (t *testQueryManager) GetPreparedQueryParamSchema(string) *evbatch.EventSch
 	return t.paramSchema
 }
 
+func (t *testQueryManager) GetPreparedQueryLimit(string) int {
+	return t.limit
+}
+
+func (t *testQueryManager) GetPreparedQueryNextPage(_ string, page opers.PageStart, batch *evbatch.Batch) *opers.PageStart {
+	if batch.RowCount < t.limit {
+		return nil
+	}
+	return &opers.PageStart{Offset: page.Offset + t.limit}
+}
+
+func (t *testQueryManager) GetPreparedQueryResultSchema(string) *evbatch.EventSchema {
+	return nil
+}
+
+func (t *testQueryManager) GetQueryResultSchema(parser.QueryDesc, []parser.PreparedStatementParam) (*evbatch.EventSchema, error) {
+	return nil, nil
+}
+
 type testCommandManager struct {
 	lock    sync.Mutex
 	command string
@@ -1006,6 +1631,50 @@ func (t *testWasmModuleManager) getUnregistrationInfo() string {
 	return t.unregName
 }
 
+type testProtobufDescriptorManager struct {
+	lock          sync.Mutex
+	name          string
+	descriptorSet []byte
+	unregName     string
+	err           error
+}
+
+func (t *testProtobufDescriptorManager) RegisterDescriptors(name string, descriptorSetBytes []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.name = name
+	t.descriptorSet = descriptorSetBytes
+	return nil
+}
+
+func (t *testProtobufDescriptorManager) getRegistrationInfo() (string, []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.name, t.descriptorSet
+}
+
+func (t *testProtobufDescriptorManager) UnregisterDescriptors(name string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.unregName = name
+	return nil
+}
+
+func (t *testProtobufDescriptorManager) getUnregistrationInfo() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.unregName
+}
+
+func (t *testProtobufDescriptorManager) setError(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.err = err
+}
+
 func createDecimal(str string, prec int, scale int) types.Decimal {
 	num, err := decimal128.FromString(str, int32(prec), int32(scale))
 	if err != nil {
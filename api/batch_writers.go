@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"github.com/spirit-labs/tektite/encoding"
 	"github.com/spirit-labs/tektite/evbatch"
 	"github.com/spirit-labs/tektite/types"
@@ -37,41 +38,9 @@ func (j *jsonLinesBatchWriter) WriteHeaders(columnNames []string, columnTypes []
 }
 
 func (j *jsonLinesBatchWriter) WriteBatch(batch *evbatch.Batch, writer http.ResponseWriter) error {
-	columnTypes := batch.Schema.ColumnTypes()
-	columnCount := len(columnTypes)
-	arr := make([]interface{}, columnCount)
+	arr := make([]any, len(batch.Schema.ColumnTypes()))
 	for i := 0; i < batch.RowCount; i++ {
-		for j, fType := range columnTypes {
-			col := batch.Columns[j]
-			var val interface{}
-			if col.IsNull(i) {
-				val = nil
-			} else {
-				switch fType.ID() {
-				case types.ColumnTypeIDInt:
-					val = col.(*evbatch.IntColumn).Get(i)
-				case types.ColumnTypeIDFloat:
-					val = col.(*evbatch.FloatColumn).Get(i)
-				case types.ColumnTypeIDBool:
-					val = col.(*evbatch.BoolColumn).Get(i)
-				case types.ColumnTypeIDDecimal:
-					// decimals are converted to strings to preserve precision
-					d := col.(*evbatch.DecimalColumn).Get(i)
-					val = d.Num.ToString(int32(d.Scale))
-				case types.ColumnTypeIDString:
-					val = col.(*evbatch.StringColumn).Get(i)
-				case types.ColumnTypeIDBytes:
-					// bytes are converted to strings
-					val = string(col.(*evbatch.BytesColumn).Get(i))
-				case types.ColumnTypeIDTimestamp:
-					// timestamps are converted to unix millis past epoch
-					val = col.(*evbatch.TimestampColumn).Get(i).Val
-				default:
-					panic("unknown type")
-				}
-			}
-			arr[j] = val
-		}
+		jsonRowValues(batch, i, arr)
 		if err := j.writeRow(arr, writer); err != nil {
 			return err
 		}
@@ -79,6 +48,45 @@ func (j *jsonLinesBatchWriter) WriteBatch(batch *evbatch.Batch, writer http.Resp
 	return nil
 }
 
+// jsonRowValues sets the values of the row at rowIndex into arr, converted to the types used when they are encoded as
+// JSON
+func jsonRowValues(batch *evbatch.Batch, rowIndex int, arr []any) {
+	for j, fType := range batch.Schema.ColumnTypes() {
+		col := batch.Columns[j]
+		var val any
+		if col.IsNull(rowIndex) {
+			val = nil
+		} else {
+			switch fType.ID() {
+			case types.ColumnTypeIDInt:
+				val = col.(*evbatch.IntColumn).Get(rowIndex)
+			case types.ColumnTypeIDFloat:
+				val = col.(*evbatch.FloatColumn).Get(rowIndex)
+			case types.ColumnTypeIDBool:
+				val = col.(*evbatch.BoolColumn).Get(rowIndex)
+			case types.ColumnTypeIDDecimal:
+				// decimals are converted to strings to preserve precision
+				d := col.(*evbatch.DecimalColumn).Get(rowIndex)
+				val = d.Num.ToString(int32(d.Scale))
+			case types.ColumnTypeIDString:
+				val = col.(*evbatch.StringColumn).Get(rowIndex)
+			case types.ColumnTypeIDBytes:
+				// bytes are converted to strings
+				val = string(col.(*evbatch.BytesColumn).Get(rowIndex))
+			case types.ColumnTypeIDTimestamp:
+				// timestamps are converted to unix millis past epoch
+				val = col.(*evbatch.TimestampColumn).Get(rowIndex).Val
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				// nested values are converted to JSON arrays and objects, with their elements converted as above
+				val = types.ToJSONValue(fType, col.(*evbatch.NestedColumn).Get(rowIndex))
+			default:
+				panic("unknown type")
+			}
+		}
+		arr[j] = val
+	}
+}
+
 func (j *jsonLinesBatchWriter) writeRow(row any, writer http.ResponseWriter) error {
 	bytes, err := json.Marshal(row)
 	if err != nil {
@@ -130,6 +138,14 @@ func DecodeArrowSchema(buff []byte) (*evbatch.EventSchema, int) {
 			fTypes[i] = types.ColumnTypeBytes
 		case types.ColumnTypeIDTimestamp:
 			fTypes[i] = types.ColumnTypeTimestamp
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			var sType string
+			sType, off = encoding.ReadStringFromBufferLE(buff, off)
+			nestedType, err := types.StringToColumnType(sType)
+			if err != nil {
+				panic(fmt.Sprintf("invalid nested type %s", sType))
+			}
+			fTypes[i] = nestedType
 		default:
 			panic("unexpected type")
 		}
@@ -175,6 +191,9 @@ func (b *ArrowBatchWriter) WriteHeaders(columnNames []string, columnTypes []type
 			dt := fType.(*types.DecimalType)
 			buff = encoding.AppendUint32ToBufferLE(buff, uint32(dt.Precision))
 			buff = encoding.AppendUint32ToBufferLE(buff, uint32(dt.Scale))
+		} else if types.IsNestedType(fType) {
+			// nested types are written as their string form, e.g. array<int>
+			buff = encoding.AppendStringToBufferLE(buff, fType.String())
 		}
 	}
 	binary.LittleEndian.PutUint64(buff, uint64(len(buff)-8))
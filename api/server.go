@@ -11,6 +11,7 @@ import (
 	"github.com/spirit-labs/tektite/errors"
 	"github.com/spirit-labs/tektite/evbatch"
 	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/opers"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/query"
 	"github.com/spirit-labs/tektite/types"
@@ -38,8 +39,10 @@ type HTTPAPIServer struct {
 	commandManager   command.Manager
 	parser           *parser.Parser
 	moduleManager    wasmModuleManager
+	descriptors      protobufDescriptorManager
 	tlsConf          conf.TLSConfig
 	wasmRegisterPath string
+	subscriptions    sync.Map
 }
 
 type wasmModuleManager interface {
@@ -47,8 +50,14 @@ type wasmModuleManager interface {
 	UnregisterModule(name string) error
 }
 
+type protobufDescriptorManager interface {
+	RegisterDescriptors(name string, descriptorSetBytes []byte) error
+	UnregisterDescriptors(name string) error
+}
+
 func NewHTTPAPIServer(listenAddress string, apiPath string, queryManager query.Manager, commandManager command.Manager,
-	parser *parser.Parser, moduleManager wasmModuleManager, tlsConf conf.TLSConfig) *HTTPAPIServer {
+	parser *parser.Parser, moduleManager wasmModuleManager, descriptors protobufDescriptorManager,
+	tlsConf conf.TLSConfig) *HTTPAPIServer {
 	return &HTTPAPIServer{
 		listenAddress:    listenAddress,
 		apiPath:          apiPath,
@@ -56,6 +65,7 @@ func NewHTTPAPIServer(listenAddress string, apiPath string, queryManager query.M
 		commandManager:   commandManager,
 		parser:           parser,
 		moduleManager:    moduleManager,
+		descriptors:      descriptors,
 		tlsConf:          tlsConf,
 		wasmRegisterPath: fmt.Sprintf("%s/%s", apiPath, "wasm-register"),
 	}
@@ -81,10 +91,16 @@ func (s *HTTPAPIServer) start() error {
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc(fmt.Sprintf("%s/query", s.apiPath), s.handleQuery)
+	mux.HandleFunc(fmt.Sprintf("%s/sql", s.apiPath), s.handleSQL)
 	mux.HandleFunc(fmt.Sprintf("%s/exec", s.apiPath), s.handleExecPreparedStatement)
 	mux.HandleFunc(fmt.Sprintf("%s/statement", s.apiPath), s.handleStatement)
 	mux.HandleFunc(fmt.Sprintf("%s/wasm-register", s.apiPath), s.handleWasmRegister)
 	mux.HandleFunc(fmt.Sprintf("%s/wasm-unregister", s.apiPath), s.handleWasmUnregister)
+	mux.HandleFunc(fmt.Sprintf("%s/protobuf-register", s.apiPath), s.handleProtobufRegister)
+	mux.HandleFunc(fmt.Sprintf("%s/protobuf-unregister", s.apiPath), s.handleProtobufUnregister)
+	mux.HandleFunc(fmt.Sprintf("%s/subscribe", s.apiPath), s.handleSubscribe)
+	mux.HandleFunc(fmt.Sprintf("%s/queries", s.apiPath), s.handleQueries)
+	mux.HandleFunc(fmt.Sprintf("%s/kill-query", s.apiPath), s.handleKillQuery)
 	s.httpServer = &http.Server{
 		Handler:     mux,
 		IdleTimeout: 0,
@@ -109,6 +125,8 @@ func (s *HTTPAPIServer) start() error {
 func (s *HTTPAPIServer) Stop() error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	// Subscriptions don't end until the subscriber goes away, so we must end them or the server can't shut down
+	s.stopSubscriptions()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := s.httpServer.Shutdown(ctx); err != nil {
@@ -127,6 +145,10 @@ func (s *HTTPAPIServer) handleQuery(writer http.ResponseWriter, request *http.Re
 	if u == nil {
 		return
 	}
+	limits, ok := getQueryLimits(writer, u)
+	if !ok {
+		return
+	}
 	batchWriter := getBatchWriter(writer, request)
 	includeHeader := getIncludeHeader(u)
 	queryString, ok := getBodyAsString(writer, request)
@@ -138,8 +160,43 @@ func (s *HTTPAPIServer) handleQuery(writer http.ResponseWriter, request *http.Re
 		writeInvalidStatementError(err.Error(), writer)
 		return
 	}
-	execQuery(writer, batchWriter, includeHeader, func(o outFunc) error {
-		return s.queryManager.ExecuteQueryDirect(queryString, *queryDesc, o)
+	execQuery(writer, batchWriter, includeHeader, nil, func(o outFunc) error {
+		return s.queryManager.ExecuteQueryDirect(queryString, *queryDesc, limits, o)
+	})
+}
+
+// handleSQL executes a SQL query, or prepares a SQL query. The SQL is compiled to TSL, and it's the TSL that is executed
+// or prepared, so the query is executed in exactly the same way as the equivalent TSL query
+func (s *HTTPAPIServer) handleSQL(writer http.ResponseWriter, request *http.Request) {
+	defer common.PanicHandler()
+	u := s.checkRequest(writer, request)
+	if u == nil {
+		return
+	}
+	limits, ok := getQueryLimits(writer, u)
+	if !ok {
+		return
+	}
+	sql, ok := getBodyAsString(writer, request)
+	if !ok {
+		return
+	}
+	sqlDesc, err := s.parser.ParseSQL(sql)
+	if err != nil {
+		writeInvalidStatementError(err.Error(), writer)
+		return
+	}
+	if sqlDesc.PrepareQuery != nil {
+		// Prepared queries are created on all nodes, like prepare statements in TSL
+		if err := s.commandManager.ExecuteCommand(sqlDesc.TSL); err != nil {
+			maybeConvertAndSendError(sqlDesc.MapError(err), writer)
+		}
+		return
+	}
+	batchWriter := getBatchWriter(writer, request)
+	includeHeader := getIncludeHeader(u)
+	execQuery(writer, batchWriter, includeHeader, nil, func(o outFunc) error {
+		return sqlDesc.MapError(s.queryManager.ExecuteQueryDirect(sqlDesc.TSL, *sqlDesc.Query, limits, o))
 	})
 }
 
@@ -149,6 +206,10 @@ func (s *HTTPAPIServer) handleExecPreparedStatement(writer http.ResponseWriter,
 	if u == nil {
 		return
 	}
+	limits, ok := getQueryLimits(writer, u)
+	if !ok {
+		return
+	}
 	batchWriter := getBatchWriter(writer, request)
 	includeHeader := getIncludeHeader(u)
 	body, ok := getBody(writer, request)
@@ -175,8 +236,41 @@ func (s *HTTPAPIServer) handleExecPreparedStatement(writer http.ResponseWriter,
 		writeError(err.Error(), writer, errors.ExecuteQueryError)
 		return
 	}
-	execQuery(writer, batchWriter, includeHeader, func(o outFunc) error {
-		_, err := s.queryManager.ExecutePreparedQuery(invocation.QueryName, args, o)
+	limit := s.queryManager.GetPreparedQueryLimit(invocation.QueryName)
+	if limit == 0 {
+		if invocation.Cursor != "" {
+			writeError(fmt.Sprintf("prepared query '%s' does not have a limit so cannot be executed with a cursor",
+				invocation.QueryName), writer, errors.ExecuteQueryError)
+			return
+		}
+		execQuery(writer, batchWriter, includeHeader, nil, func(o outFunc) error {
+			_, err := s.queryManager.ExecutePreparedQuery(invocation.QueryName, args, limits, o)
+			return err
+		})
+		return
+	}
+	// The query has a limit, so the results are paged. Each page is executed at the same version as the first page,
+	// so the client sees a consistent snapshot as it pages through the results. Once the tables read no longer have the
+	// rows as of that version, the cursor has expired and the query manager returns an error.
+	var cursor queryCursor
+	if invocation.Cursor != "" {
+		cursor, err = decodeQueryCursor(invocation.Cursor)
+		if err != nil {
+			writeError(err.Error(), writer, errors.ExecuteQueryError)
+			return
+		}
+	} else {
+		cursor.Version = int64(s.queryManager.GetLastCompletedVersion())
+	}
+	beforeWrite := func(batch *evbatch.Batch) {
+		if next := s.queryManager.GetPreparedQueryNextPage(invocation.QueryName, cursor.Page, batch); next != nil {
+			nextCursor := queryCursor{Version: cursor.Version, Page: *next}
+			writer.Header().Set(CursorHeaderName, nextCursor.encode())
+		}
+	}
+	execQuery(writer, batchWriter, includeHeader, beforeWrite, func(o outFunc) error {
+		_, err := s.queryManager.ExecutePreparedQueryWithHighestVersion(invocation.QueryName, args, cursor.Version,
+			cursor.Page, limits, o)
 		return err
 	})
 }
@@ -186,131 +280,250 @@ func convertPreparedStatementArgs(args []any, argTypes []types.ColumnType) ([]an
 		if arg == nil {
 			continue
 		}
-		ok := true
-		argType := argTypes[i]
-		switch argType.ID() {
-		case types.ColumnTypeIDInt:
-			switch v := arg.(type) {
-			case int:
-				args[i] = int64(v)
-			case float64:
-				args[i] = int64(v)
-			case string:
-				iVal, err := strconv.Atoi(v)
-				if err == nil {
-					args[i] = int64(iVal)
-				} else {
-					ok = false
-				}
-			default:
+		res, ok, err := convertArg(i, arg, argTypes[i])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.Errorf("argument %d (%v) of type %s cannot be converted to %s", i, arg,
+				reflect.TypeOf(arg).String(), argTypes[i].String())
+		}
+		args[i] = res
+	}
+	return args, nil
+}
+
+// convertArg converts the JSON value of argument i to the Go type used for argType. It returns false if the value
+// cannot be converted.
+func convertArg(i int, arg any, argType types.ColumnType) (any, bool, error) {
+	res := arg
+	ok := true
+	switch argType.ID() {
+	case types.ColumnTypeIDInt:
+		switch v := arg.(type) {
+		case int:
+			res = int64(v)
+		case float64:
+			res = int64(v)
+		case string:
+			iVal, err := strconv.Atoi(v)
+			if err == nil {
+				res = int64(iVal)
+			} else {
 				ok = false
 			}
-		case types.ColumnTypeIDFloat:
-			switch v := arg.(type) {
-			case int:
-				args[i] = float64(v)
-			case float64:
-				// OK
-			case string:
-				fVal, err := strconv.ParseFloat(v, 64)
-				if err == nil {
-					args[i] = fVal
-				} else {
-					ok = false
-				}
-			default:
+		default:
+			ok = false
+		}
+	case types.ColumnTypeIDFloat:
+		switch v := arg.(type) {
+		case int:
+			res = float64(v)
+		case float64:
+			// OK
+		case string:
+			fVal, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				res = fVal
+			} else {
 				ok = false
 			}
-		case types.ColumnTypeIDBool:
-			switch v := arg.(type) {
-			case bool:
-				// OK
-			case string:
-				switch v {
-				case "true", "TRUE":
-					args[i] = true
-				case "false", "FALSE":
-					args[i] = false
-				default:
-					ok = false
-				}
+		default:
+			ok = false
+		}
+	case types.ColumnTypeIDBool:
+		switch v := arg.(type) {
+		case bool:
+			// OK
+		case string:
+			switch v {
+			case "true", "TRUE":
+				res = true
+			case "false", "FALSE":
+				res = false
 			default:
 				ok = false
 			}
-		case types.ColumnTypeIDDecimal:
-			decType := argType.(*types.DecimalType)
-			switch v := arg.(type) {
-			case string:
-				dec, err := types.NewDecimalFromString(v, decType.Precision, decType.Scale)
-				if err == nil {
-					args[i] = dec
-				} else {
-					ok = false
-				}
-			case float64:
-				dec, err := types.NewDecimalFromFloat64(v, decType.Precision, decType.Scale)
-				if err == nil {
-					args[i] = dec
-				} else {
-					ok = false
-				}
-			case int:
-				dec := types.NewDecimalFromInt64(int64(v), decType.Precision, decType.Scale)
-				args[i] = dec
-			default:
+		default:
+			ok = false
+		}
+	case types.ColumnTypeIDDecimal:
+		decType := argType.(*types.DecimalType)
+		switch v := arg.(type) {
+		case string:
+			dec, err := types.NewDecimalFromString(v, decType.Precision, decType.Scale)
+			if err == nil {
+				res = dec
+			} else {
 				ok = false
 			}
-		case types.ColumnTypeIDString:
-			switch v := arg.(type) {
-			case string:
-				// OK
-			case float64:
-				args[i] = strconv.FormatFloat(v, 'g', 6, 64)
-			case int:
-				args[i] = strconv.Itoa(v)
-			case bool:
-				if v {
-					args[i] = "true"
-				} else {
-					args[i] = "false"
-				}
-			default:
+		case float64:
+			dec, err := types.NewDecimalFromFloat64(v, decType.Precision, decType.Scale)
+			if err == nil {
+				res = dec
+			} else {
 				ok = false
 			}
-		case types.ColumnTypeIDBytes:
-			var sVal string
-			sVal, ok = arg.(string)
-			if ok {
-				bytes, err := base64.StdEncoding.DecodeString(sVal)
-				if err != nil {
-					return nil, errors.Errorf("argument %d (%v) of type %s cannot be base64 decoded", i, arg,
-						reflect.TypeOf(arg).String())
-				}
-				args[i] = bytes
+		case int:
+			dec := types.NewDecimalFromInt64(int64(v), decType.Precision, decType.Scale)
+			res = dec
+		default:
+			ok = false
+		}
+	case types.ColumnTypeIDString:
+		switch v := arg.(type) {
+		case string:
+			// OK
+		case float64:
+			res = strconv.FormatFloat(v, 'g', 6, 64)
+		case int:
+			res = strconv.Itoa(v)
+		case bool:
+			if v {
+				res = "true"
+			} else {
+				res = "false"
 			}
-		case types.ColumnTypeIDTimestamp:
-			switch v := arg.(type) {
-			case int:
-				args[i] = types.NewTimestamp(int64(v))
-			case float64:
-				args[i] = types.NewTimestamp(int64(v))
-			default:
-				ok = false
+		default:
+			ok = false
+		}
+	case types.ColumnTypeIDBytes:
+		var sVal string
+		sVal, ok = arg.(string)
+		if ok {
+			bytes, err := base64.StdEncoding.DecodeString(sVal)
+			if err != nil {
+				return nil, false, errors.Errorf("argument %d (%v) of type %s cannot be base64 decoded", i, arg,
+					reflect.TypeOf(arg).String())
 			}
+			res = bytes
+		}
+	case types.ColumnTypeIDTimestamp:
+		switch v := arg.(type) {
+		case int:
+			res = types.NewTimestamp(int64(v))
+		case float64:
+			res = types.NewTimestamp(int64(v))
 		default:
-			panic("unexpected type")
+			ok = false
 		}
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return convertNestedArg(i, arg, argType)
+	default:
+		panic("unexpected type")
+	}
+	return res, ok, nil
+}
+
+// convertNestedArg converts a JSON array or object to an array, map or struct argument, converting the elements with
+// convertArg. Structs can be provided either as an object with the field names as keys or as an array in field order.
+func convertNestedArg(i int, arg any, argType types.ColumnType) (any, bool, error) {
+	convertElem := func(elem any, elemType types.ColumnType) (any, bool, error) {
+		if elem == nil {
+			return nil, true, nil
+		}
+		return convertArg(i, elem, elemType)
+	}
+	switch t := argType.(type) {
+	case *types.ArrayType:
+		arr, ok := arg.([]any)
 		if !ok {
-			return nil, errors.Errorf("argument %d (%v) of type %s cannot be converted to %s", i, arg,
-				reflect.TypeOf(arg).String(), argType.String())
+			return nil, false, nil
 		}
+		res := make([]any, len(arr))
+		for j, elem := range arr {
+			v, ok, err := convertElem(elem, t.ElemType)
+			if err != nil || !ok {
+				return nil, ok, err
+			}
+			res[j] = v
+		}
+		return res, true, nil
+	case *types.MapType:
+		m, ok := arg.(map[string]any)
+		if !ok {
+			return nil, false, nil
+		}
+		res := make(map[string]any, len(m))
+		for k, elem := range m {
+			v, ok, err := convertElem(elem, t.ValueType)
+			if err != nil || !ok {
+				return nil, ok, err
+			}
+			res[k] = v
+		}
+		return res, true, nil
+	case *types.StructType:
+		res := make([]any, len(t.FieldNames))
+		switch v := arg.(type) {
+		case map[string]any:
+			for name := range v {
+				if t.FieldIndex(name) == -1 {
+					return nil, false, nil
+				}
+			}
+			for j, name := range t.FieldNames {
+				fv, ok, err := convertElem(v[name], t.FieldTypes[j])
+				if err != nil || !ok {
+					return nil, ok, err
+				}
+				res[j] = fv
+			}
+		case []any:
+			if len(v) != len(t.FieldNames) {
+				return nil, false, nil
+			}
+			for j, elem := range v {
+				fv, ok, err := convertElem(elem, t.FieldTypes[j])
+				if err != nil || !ok {
+					return nil, ok, err
+				}
+				res[j] = fv
+			}
+		default:
+			return nil, false, nil
+		}
+		return res, true, nil
+	default:
+		panic("unexpected type")
 	}
-	return args, nil
 }
 
 type PreparedStatementInvocation struct {
 	QueryName string
 	Args      []any
+	Cursor    string
+}
+
+// CursorHeaderName is the name of the response header containing the cursor for the next page of results of a
+// prepared query with a limit. It is not set if there are no more results.
+const CursorHeaderName = "x-tektite-cursor"
+
+// queryCursor identifies a page of the results of a prepared query with a limit - the version the query is executed
+// at, and where the page starts, which is usually just after the last row of the previous page
+type queryCursor struct {
+	Version int64
+	Page    opers.PageStart
+}
+
+func (q *queryCursor) encode() string {
+	buff, err := json.Marshal(q)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+func decodeQueryCursor(sCursor string) (queryCursor, error) {
+	var cursor queryCursor
+	buff, err := base64.RawURLEncoding.DecodeString(sCursor)
+	if err == nil {
+		err = json.Unmarshal(buff, &cursor)
+	}
+	if err != nil || cursor.Page.Offset < 0 {
+		return queryCursor{}, errors.Errorf("invalid cursor '%s'", sCursor)
+	}
+	return cursor, nil
 }
 
 func getBatchWriter(writer http.ResponseWriter, request *http.Request) BatchWriter {
@@ -325,6 +538,42 @@ func getBatchWriter(writer http.ResponseWriter, request *http.Request) BatchWrit
 	return batchWriter
 }
 
+// getQueryLimits returns the limits the request sets for the query. Any limit the request doesn't set is the limit
+// configured for the server.
+func getQueryLimits(writer http.ResponseWriter, u *url.URL) (query.Limits, bool) {
+	var limits query.Limits
+	params := u.Query()
+	if sTimeout := params.Get("timeout"); sTimeout != "" {
+		timeout, err := time.ParseDuration(sTimeout)
+		if err != nil {
+			writeError(fmt.Sprintf("invalid timeout '%s'", sTimeout), writer, errors.ExecuteQueryError)
+			return limits, false
+		}
+		limits.Timeout = timeout
+	}
+	var ok bool
+	if limits.MaxRowsScanned, ok = getIntParam(writer, params, "max_rows_scanned"); !ok {
+		return limits, false
+	}
+	if limits.MaxMemoryBytes, ok = getIntParam(writer, params, "max_memory_bytes"); !ok {
+		return limits, false
+	}
+	return limits, true
+}
+
+func getIntParam(writer http.ResponseWriter, params url.Values, name string) (int64, bool) {
+	sVal := params.Get(name)
+	if sVal == "" {
+		return 0, true
+	}
+	val, err := strconv.ParseInt(sVal, 10, 64)
+	if err != nil {
+		writeError(fmt.Sprintf("invalid %s '%s'", name, sVal), writer, errors.ExecuteQueryError)
+		return 0, false
+	}
+	return val, true
+}
+
 func getIncludeHeader(u *url.URL) bool {
 	sIncludeHeader := u.Query().Get("col_headers")
 	if sIncludeHeader != "" && strings.ToLower(sIncludeHeader) == "true" {
@@ -435,18 +684,134 @@ func (s *HTTPAPIServer) handleWasmUnregister(writer http.ResponseWriter, request
 	}
 }
 
+// ProtobufRegistration registers a FileDescriptorSet under a name. DescriptorSet is the base64 encoded set.
+type ProtobufRegistration struct {
+	Name          string
+	DescriptorSet string
+}
+
+func (s *HTTPAPIServer) handleProtobufRegister(writer http.ResponseWriter, request *http.Request) {
+	u := s.checkRequest(writer, request)
+	if u == nil {
+		return
+	}
+	body, ok := getBody(writer, request)
+	if !ok {
+		return
+	}
+	registration := &ProtobufRegistration{}
+	if err := json.Unmarshal(body, registration); err != nil {
+		writeError(fmt.Sprintf("failed to parse JSON: %v", err), writer, errors.ProtobufError)
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(registration.DescriptorSet)
+	if err != nil {
+		writeError(fmt.Sprintf("failed to base64 decode descriptor set: %v", err), writer, errors.ProtobufError)
+		return
+	}
+	if err := s.descriptors.RegisterDescriptors(registration.Name, decoded); err != nil {
+		maybeConvertAndSendError(err, writer)
+	}
+}
+
+func (s *HTTPAPIServer) handleProtobufUnregister(writer http.ResponseWriter, request *http.Request) {
+	u := s.checkRequest(writer, request)
+	if u == nil {
+		return
+	}
+	name, ok := getBodyAsString(writer, request)
+	if !ok {
+		return
+	}
+	if err := s.descriptors.UnregisterDescriptors(name); err != nil {
+		maybeConvertAndSendError(err, writer)
+	}
+}
+
+// RunningQueryInfo describes a query that is executing, as returned by the queries endpoint
+type RunningQueryInfo struct {
+	ID        string `json:"id"`
+	QueryName string `json:"query_name,omitempty"`
+	TSL       string `json:"tsl,omitempty"`
+	Version   int64  `json:"version"`
+	StartTime string `json:"start_time"`
+	RunningMs int64  `json:"running_ms"`
+}
+
+// handleQueries returns the queries that are executing, that were received by this node
+func (s *HTTPAPIServer) handleQueries(writer http.ResponseWriter, request *http.Request) {
+	defer common.PanicHandler()
+	u := s.checkRequest(writer, request)
+	if u == nil {
+		return
+	}
+	now := time.Now()
+	infos := []RunningQueryInfo{}
+	for _, q := range s.queryManager.RunningQueries() {
+		infos = append(infos, RunningQueryInfo{
+			ID:        q.ID,
+			QueryName: q.QueryName,
+			TSL:       q.TSL,
+			Version:   q.Version,
+			StartTime: q.StartTime.UTC().Format(time.RFC3339Nano),
+			RunningMs: now.Sub(q.StartTime).Milliseconds(),
+		})
+	}
+	buff, err := json.Marshal(infos)
+	if err != nil {
+		maybeConvertAndSendError(err, writer)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if _, err := writer.Write(buff); err != nil {
+		log.Errorf("failed to write response %v", err)
+	}
+}
+
+// handleKillQuery cancels a query that is executing, that was received by this node. The body is the id of the query.
+func (s *HTTPAPIServer) handleKillQuery(writer http.ResponseWriter, request *http.Request) {
+	defer common.PanicHandler()
+	u := s.checkRequest(writer, request)
+	if u == nil {
+		return
+	}
+	queryID, ok := getBodyAsString(writer, request)
+	if !ok {
+		return
+	}
+	if err := s.queryManager.CancelQuery(strings.TrimSpace(queryID)); err != nil {
+		maybeConvertAndSendError(err, writer)
+	}
+}
+
 func (s *HTTPAPIServer) ListenAddress() string {
 	return s.listenAddress
 }
 
-type outFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error
+type outFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error
 
-func execQuery(writer http.ResponseWriter, batchWriter BatchWriter, includeHeader bool, outFuncFunc func(outFunc) error) {
+// execQuery executes a query and writes the results. If beforeWrite is not nil it is called before each batch is
+// written, which allows response headers to be set
+func execQuery(writer http.ResponseWriter, batchWriter BatchWriter, includeHeader bool,
+	beforeWrite func(batch *evbatch.Batch), outFuncFunc func(outFunc) error) {
 	lastCount := uint64(0)
 	batchCh := make(chan *evbatch.Batch, 10)
-	outFunc := func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	// done is closed when we stop writing results, so the query is cancelled if it is still executing
+	done := make(chan struct{})
+	defer close(done)
+	var queryErr error
+	outFunc := func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		if err != nil {
+			queryErr = err
+			close(batchCh)
+			return nil
+		}
 		if batch != nil {
-			batchCh <- batch
+			select {
+			case batchCh <- batch:
+			case <-done:
+				return errors.NewTektiteErrorf(errors.ExecuteQueryError, "query results are no longer being received")
+			}
 		}
 		if last && atomic.AddUint64(&lastCount, 1) == uint64(numLastBatches) {
 			close(batchCh)
@@ -460,6 +825,9 @@ func execQuery(writer http.ResponseWriter, batchWriter BatchWriter, includeHeade
 	}
 	headersWritten := !includeHeader
 	for batch := range batchCh {
+		if beforeWrite != nil {
+			beforeWrite(batch)
+		}
 		if !headersWritten {
 			if err := batchWriter.WriteHeaders(batch.Schema.ColumnNames(), batch.Schema.ColumnTypes(), writer); err != nil {
 				maybeConvertAndSendError(err, writer)
@@ -472,6 +840,10 @@ func execQuery(writer http.ResponseWriter, batchWriter BatchWriter, includeHeade
 			return
 		}
 	}
+	if queryErr != nil {
+		// The query failed after it started executing, for example it exceeded one of its limits
+		maybeConvertAndSendError(queryErr, writer)
+	}
 }
 
 func writeError(msg string, writer http.ResponseWriter, errorCode errors.ErrorCode) {
@@ -0,0 +1,607 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/query"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// SubscriptionEventSnapshot is the type of the first event sent to a subscriber - it contains all the rows of the
+	// results of the query
+	SubscriptionEventSnapshot = "snapshot"
+	// SubscriptionEventChanges is the type of the events containing the rows that have been added to and removed from
+	// the results of the query since the previous event. An updated row is removed and then added.
+	SubscriptionEventChanges = "changes"
+	// SubscriptionEventError is the type of the event sent when the subscription fails. It is the last event sent.
+	SubscriptionEventError = "error"
+)
+
+// SubscriptionEvent is sent to a subscriber each time the results of the query change. Version is the version of the
+// data that the results are as of - a subscriber that reconnects can provide it to carry on from where it left off.
+type SubscriptionEvent struct {
+	Type    string   `json:"type"`
+	Version int64    `json:"version"`
+	Columns []string `json:"columns,omitempty"`
+	Types   []string `json:"types,omitempty"`
+	Rows    [][]any  `json:"rows,omitempty"`
+	Inserts [][]any  `json:"inserts,omitempty"`
+	Deletes [][]any  `json:"deletes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// handleSubscribe handles a continuous query. The results of the query are sent, and then the changes to them are sent
+// each time a version completes, until the subscriber disconnects. The events are sent with server-sent events, or
+// over a WebSocket if the request is a WebSocket upgrade. The query is provided in the 'query' URL parameter, or in the
+// body of a POST. To carry on from a previous subscription the version of the last event received is provided in the
+// 'from_version' URL parameter or the Last-Event-ID header. This fails if the tables no longer have the rows as of
+// that version - older versions are only kept by tables created with a versions_retention.
+func (s *HTTPAPIServer) handleSubscribe(writer http.ResponseWriter, request *http.Request) {
+	defer common.PanicHandler()
+	u, err := url.ParseRequestURI(request.RequestURI)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var queryString string
+	switch request.Method {
+	case http.MethodGet:
+		queryString = u.Query().Get("query")
+	case http.MethodPost:
+		var ok bool
+		queryString, ok = getBodyAsString(writer, request)
+		if !ok {
+			return
+		}
+	default:
+		http.Error(writer, "the HTTP method must be a GET or a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	queryDesc, err := s.parser.ParseQuery(queryString)
+	if err != nil {
+		writeInvalidStatementError(err.Error(), writer)
+		return
+	}
+	// The limits apply to each execution of the query
+	limits, ok := getQueryLimits(writer, u)
+	if !ok {
+		return
+	}
+	fromVersion := int64(-1)
+	sFromVersion := u.Query().Get("from_version")
+	if sFromVersion == "" {
+		sFromVersion = request.Header.Get("Last-Event-ID")
+	}
+	if sFromVersion != "" {
+		fromVersion, err = strconv.ParseInt(sFromVersion, 10, 64)
+		if err != nil {
+			writeError(fmt.Sprintf("invalid version '%s'", sFromVersion), writer, errors.ExecuteQueryError)
+			return
+		}
+		if fromVersion < 0 {
+			// The previous subscription was started before any version had completed
+			fromVersion = -1
+		}
+	}
+	sub := s.newSubscription(queryString, queryDesc, limits)
+	if strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+		wsServer := websocket.Server{Handler: func(conn *websocket.Conn) {
+			common.Go(func() {
+				// We don't expect any messages from the subscriber, but we must read to find out when it has gone away
+				var msg []byte
+				for {
+					if err := websocket.Message.Receive(conn, &msg); err != nil {
+						sub.stop("")
+						return
+					}
+				}
+			})
+			sub.run(&wsEventSender{conn: conn}, fromVersion)
+		}}
+		wsServer.ServeHTTP(writer, request)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		writeError("streaming is not supported by the connection", writer, errors.InternalError)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	common.Go(func() {
+		select {
+		case <-request.Context().Done():
+			sub.stop("")
+		case <-sub.stopCh:
+		}
+	})
+	sub.run(&sseEventSender{writer: writer, flusher: flusher}, fromVersion)
+}
+
+// StreamChanged is called when a stream is deployed or undeployed. Subscriptions to queries on streams that have been
+// undeployed are ended.
+func (s *HTTPAPIServer) StreamChanged(streamName string, deployed bool) {
+	if deployed {
+		return
+	}
+	s.subscriptions.Range(func(_, value any) bool {
+		sub := value.(*subscription)
+		for _, name := range sub.streamNames {
+			if name == streamName {
+				sub.stop(fmt.Sprintf("stream '%s' has been deleted", streamName))
+				break
+			}
+		}
+		return true
+	})
+}
+
+func (s *HTTPAPIServer) stopSubscriptions() {
+	s.subscriptions.Range(func(_, value any) bool {
+		value.(*subscription).stop("server is shutting down")
+		return true
+	})
+}
+
+type eventSender interface {
+	send(event *SubscriptionEvent) error
+}
+
+type sseEventSender struct {
+	writer  http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseEventSender) send(event *SubscriptionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Version, event.Type, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+type wsEventSender struct {
+	conn *websocket.Conn
+}
+
+func (w *wsEventSender) send(event *SubscriptionEvent) error {
+	return websocket.JSON.Send(w.conn, event)
+}
+
+// errSubscriptionStopped is returned when the subscription stops while it is waiting
+var errSubscriptionStopped = errors.New("subscription stopped")
+
+type subscription struct {
+	id          string
+	server      *HTTPAPIServer
+	tsl         string
+	queryDesc   *parser.QueryDesc
+	limits      query.Limits
+	streamNames []string
+	versionCh   chan struct{}
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	stopReason  atomic.Value
+	version     int64
+	// keyed is set when the results of the query each come from a single row of its table, and are held by the key of
+	// that row. The changes to the results are then found by reading just the rows of the table that have been written.
+	keyed bool
+	// keyRows holds the current results of a keyed query, by the key of the row of the table they come from
+	keyRows map[string]*keyedRow
+	// rows holds the current results of any other query, by the JSON encoding of the row
+	rows map[string]*resultRow
+}
+
+type resultRow struct {
+	values []any
+	count  int
+}
+
+type keyedRow struct {
+	values [][]any
+	// encoded is the JSON encoding of the values, to compare them with
+	encoded string
+}
+
+func (s *HTTPAPIServer) newSubscription(tsl string, queryDesc *parser.QueryDesc, limits query.Limits) *subscription {
+	var streamNames []string
+	for _, desc := range queryDesc.OperatorDescs {
+		switch op := desc.(type) {
+		case *parser.ScanDesc:
+			streamNames = append(streamNames, op.TableName)
+		case *parser.GetDesc:
+			streamNames = append(streamNames, op.TableName)
+		case *parser.QueryJoinDesc:
+			streamNames = append(streamNames, op.TableName)
+		}
+	}
+	return &subscription{
+		id:          uuid.New().String(),
+		server:      s,
+		tsl:         tsl,
+		queryDesc:   queryDesc,
+		limits:      limits,
+		streamNames: streamNames,
+		versionCh:   make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		version:     -1,
+	}
+}
+
+// stop ends the subscription. If reason is not empty it is sent to the subscriber in an error event.
+func (s *subscription) stop(reason string) {
+	s.stopOnce.Do(func() {
+		s.stopReason.Store(reason)
+		close(s.stopCh)
+	})
+}
+
+func (s *subscription) versionCompleted(int64) {
+	select {
+	case s.versionCh <- struct{}{}:
+	default:
+		// Already signalled
+	}
+}
+
+func (s *subscription) run(sender eventSender, fromVersion int64) {
+	queryManager := s.server.queryManager
+	s.server.subscriptions.Store(s.id, s)
+	queryManager.RegisterCompletedVersionListener(s.id, s.versionCompleted)
+	defer func() {
+		queryManager.UnregisterCompletedVersionListener(s.id)
+		s.server.subscriptions.Delete(s.id)
+		s.stop("")
+	}()
+	err := s.start(sender, fromVersion)
+	for err == nil {
+		select {
+		case <-s.stopCh:
+			err = errSubscriptionStopped
+		case <-s.versionCh:
+			err = s.sendChanges(sender)
+		}
+	}
+	if err != errSubscriptionStopped {
+		s.sendError(sender, err)
+	} else if reason := s.stopReason.Load().(string); reason != "" {
+		s.sendError(sender, errors.NewTektiteErrorf(errors.ExecuteQueryError, reason))
+	}
+}
+
+// start loads the results of the query, and sends them to the subscriber, or if the subscriber is carrying on from
+// a previous subscription, the changes since the version it last received
+func (s *subscription) start(sender eventSender, fromVersion int64) error {
+	if fromVersion == -1 {
+		version := int64(s.server.queryManager.GetLastCompletedVersion())
+		schema, rows, err := s.loadResults(version)
+		if err != nil {
+			return err
+		}
+		event := &SubscriptionEvent{Type: SubscriptionEventSnapshot, Version: version, Rows: rows}
+		if schema != nil {
+			event.Columns = schema.ColumnNames()
+			for _, ct := range schema.ColumnTypes() {
+				event.Types = append(event.Types, ct.String())
+			}
+		}
+		s.version = version
+		return sender.send(event)
+	}
+	// We can't load the results as of a version which has not completed yet on this node, so we wait for it
+	for int64(s.server.queryManager.GetLastCompletedVersion()) < fromVersion {
+		select {
+		case <-s.stopCh:
+			return errSubscriptionStopped
+		case <-s.versionCh:
+		}
+	}
+	// This fails if the tables no longer have the rows as of the version
+	if _, _, err := s.loadResults(fromVersion); err != nil {
+		return err
+	}
+	s.version = fromVersion
+	return s.sendChanges(sender)
+}
+
+// loadResults executes the whole query as of the version, and makes its results the current results. It returns the
+// schema of the results, and the rows in the order of the results, as the query might sort them.
+func (s *subscription) loadResults(version int64) (*evbatch.EventSchema, [][]any, error) {
+	results, keyed, err := s.server.queryManager.ExecuteQueryDirectByKey(s.tsl, *s.queryDesc, version, s.limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := [][]any{}
+	if keyed {
+		keyRows, err := keyedRowsOf(results)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.keyed = true
+		s.keyRows = map[string]*keyedRow{}
+		for key, row := range keyRows {
+			if row != nil {
+				s.keyRows[key] = row
+				rows = append(rows, row.values...)
+			}
+		}
+		return results.Schema, rows, nil
+	}
+	batches, err := s.executeQuery(version)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.rows, err = rowsOf(batches)
+	if err != nil {
+		return nil, nil, err
+	}
+	var schema *evbatch.EventSchema
+	for _, batch := range batches {
+		schema = batch.Schema
+		rows = append(rows, batchRows(batch)...)
+	}
+	return schema, rows, nil
+}
+
+// sendChanges finds the changes to the results of the query as of the last completed version, and sends the rows which
+// have been added and removed since the previous version that was sent
+func (s *subscription) sendChanges(sender eventSender) error {
+	version := int64(s.server.queryManager.GetLastCompletedVersion())
+	if version <= s.version {
+		return nil
+	}
+	var event *SubscriptionEvent
+	var err error
+	if s.keyed {
+		event, err = s.keyedChanges(version)
+	} else {
+		event, err = s.queryChanges(version)
+	}
+	if err != nil {
+		if common.IsUnavailableError(err) {
+			// This can occur while a node is failing over - we try again when the next version completes
+			log.Debugf("failed to load changes for subscription %s, will retry %v", s.id, err)
+			return nil
+		}
+		return err
+	}
+	s.version = version
+	if len(event.Inserts) == 0 && len(event.Deletes) == 0 {
+		return nil
+	}
+	event.Type = SubscriptionEventChanges
+	event.Version = version
+	return sender.send(event)
+}
+
+// keyedChanges finds the changes to the results of a keyed query as of the version, by reading just the rows of the
+// table that have been written since the previous version. If the rows written are not known, e.g. because a node has
+// restarted, the whole query is executed again.
+func (s *subscription) keyedChanges(version int64) (*SubscriptionEvent, error) {
+	queryManager := s.server.queryManager
+	results, known, err := queryManager.ExecuteQueryDirectForChangedKeys(*s.queryDesc, s.version, version)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		var keyed bool
+		results, keyed, err = queryManager.ExecuteQueryDirectByKey(s.tsl, *s.queryDesc, version, s.limits)
+		if err != nil {
+			return nil, err
+		}
+		if !keyed {
+			return nil, errors.NewTektiteErrorf(errors.ExecuteQueryError, "query can no longer be executed by key")
+		}
+	}
+	changed, err := keyedRowsOf(results)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		// All the results have been loaded, so any row that is not in them has been removed
+		for key := range s.keyRows {
+			if _, ok := changed[key]; !ok {
+				changed[key] = nil
+			}
+		}
+	}
+	event := &SubscriptionEvent{}
+	for key, row := range changed {
+		prevRow := s.keyRows[key]
+		if prevRow != nil && row != nil && prevRow.encoded == row.encoded {
+			continue
+		}
+		if prevRow != nil {
+			event.Deletes = append(event.Deletes, prevRow.values...)
+		}
+		if row != nil {
+			event.Inserts = append(event.Inserts, row.values...)
+			s.keyRows[key] = row
+		} else {
+			delete(s.keyRows, key)
+		}
+	}
+	return event, nil
+}
+
+// queryChanges executes the query as of the version, if the tables it reads have been written to since the previous
+// version, and finds the changes by comparing its results with the previous results. This is used for queries that
+// are not keyed, e.g. ones with a join, an aggregate or a sort.
+func (s *subscription) queryChanges(version int64) (*SubscriptionEvent, error) {
+	event := &SubscriptionEvent{}
+	batches, changed, err := s.executeQueryIfChanged(s.version, version)
+	if err != nil || !changed {
+		return event, err
+	}
+	rows, err := rowsOf(batches)
+	if err != nil {
+		return nil, err
+	}
+	for key, row := range rows {
+		prevCount := 0
+		if prevRow, ok := s.rows[key]; ok {
+			prevCount = prevRow.count
+		}
+		for i := prevCount; i < row.count; i++ {
+			event.Inserts = append(event.Inserts, row.values)
+		}
+	}
+	for key, prevRow := range s.rows {
+		count := 0
+		if row, ok := rows[key]; ok {
+			count = row.count
+		}
+		for i := count; i < prevRow.count; i++ {
+			event.Deletes = append(event.Deletes, prevRow.values)
+		}
+	}
+	s.rows = rows
+	return event, nil
+}
+
+// rowsOf returns the rows of the batches by their JSON encoding, along with how many times each one occurs
+func rowsOf(batches []*evbatch.Batch) (map[string]*resultRow, error) {
+	rows := map[string]*resultRow{}
+	for _, batch := range batches {
+		for _, values := range batchRows(batch) {
+			bytes, err := json.Marshal(values)
+			if err != nil {
+				return nil, errors.NewTektiteErrorf(errors.ExecuteQueryError, "failed to encode result row: %v", err)
+			}
+			key := string(bytes)
+			row, ok := rows[key]
+			if !ok {
+				row = &resultRow{values: values}
+				rows[key] = row
+			}
+			row.count++
+		}
+	}
+	return rows, nil
+}
+
+// keyedRowsOf returns the rows of the keyed results by key, with a nil row for a key with no results
+func keyedRowsOf(results *query.KeyedResults) (map[string]*keyedRow, error) {
+	rows := make(map[string]*keyedRow, len(results.Rows))
+	for key, batch := range results.Rows {
+		if batch == nil || batch.RowCount == 0 {
+			rows[key] = nil
+			continue
+		}
+		values := batchRows(batch)
+		bytes, err := json.Marshal(values)
+		if err != nil {
+			return nil, errors.NewTektiteErrorf(errors.ExecuteQueryError, "failed to encode result row: %v", err)
+		}
+		rows[key] = &keyedRow{values: values, encoded: string(bytes)}
+	}
+	return rows, nil
+}
+
+func batchRows(batch *evbatch.Batch) [][]any {
+	rows := make([][]any, batch.RowCount)
+	for i := 0; i < batch.RowCount; i++ {
+		rows[i] = make([]any, len(batch.Schema.ColumnTypes()))
+		jsonRowValues(batch, i, rows[i])
+	}
+	return rows
+}
+
+// executeQuery executes the query as of the version and waits for all the results
+func (s *subscription) executeQuery(version int64) ([]*evbatch.Batch, error) {
+	batches, _, err := s.gatherResults(func(outputFunc func(bool, int, *evbatch.Batch, error) error) (bool, error) {
+		return true, s.server.queryManager.ExecuteQueryDirectWithHighestVersion(s.tsl, *s.queryDesc, version,
+			s.limits, outputFunc)
+	})
+	return batches, err
+}
+
+// executeQueryIfChanged executes the query as of the version, if the tables it reads have been written to after
+// changedAfterVersion, and waits for all the results
+func (s *subscription) executeQueryIfChanged(changedAfterVersion int64, version int64) ([]*evbatch.Batch, bool, error) {
+	return s.gatherResults(func(outputFunc func(bool, int, *evbatch.Batch, error) error) (bool, error) {
+		return s.server.queryManager.ExecuteQueryDirectIfChanged(s.tsl, *s.queryDesc, changedAfterVersion, version,
+			s.limits, outputFunc)
+	})
+}
+
+// gatherResults calls execute to execute the query, and if it was executed waits for all the results, or for the
+// subscription to stop
+func (s *subscription) gatherResults(execute func(outputFunc func(bool, int, *evbatch.Batch, error) error) (bool, error)) ([]*evbatch.Batch, bool, error) {
+	var lock sync.Mutex
+	var batches []*evbatch.Batch
+	lastCount := 0
+	// Only the first error, or the completion, is waited for - anything sent after that is dropped rather than
+	// blocking the query
+	ch := make(chan error, 1)
+	complete := func(err error) {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+	executed, err := execute(
+		func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+			if err != nil {
+				complete(err)
+				return nil
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if batch != nil {
+				batches = append(batches, batch)
+			}
+			if last {
+				lastCount++
+				if lastCount == numLastBatches {
+					complete(nil)
+				}
+			}
+			return nil
+		})
+	if err != nil || !executed {
+		return nil, false, err
+	}
+	select {
+	case err := <-ch:
+		if err != nil {
+			return nil, false, err
+		}
+	case <-s.stopCh:
+		return nil, false, errSubscriptionStopped
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return batches, true, nil
+}
+
+func (s *subscription) sendError(sender eventSender, err error) {
+	perr := maybeConvertError(err)
+	event := &SubscriptionEvent{
+		Type:    SubscriptionEventError,
+		Version: s.version,
+		Error:   fmt.Sprintf("TEK%04d - %s", perr.Code, perr.Msg),
+	}
+	if err := sender.send(event); err != nil {
+		log.Debugf("failed to send error to subscriber %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/testutils"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const subscribeQuery = "(scan all from test_table)"
+
+var subscribeSchema = evbatch.NewEventSchema([]string{"id", "name"},
+	[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+
+func TestSubscribeSSE(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+
+	queryMgr.setVersionBatches(23, createSubscribeBatch(1, "foo", 2, "bar"))
+	queryMgr.setVersionBatches(24, createSubscribeBatch(1, "foo", 2, "baz"), createSubscribeBatch(3, "qux"))
+	queryMgr.setVersionBatches(26, createSubscribeBatch(2, "baz"))
+
+	resp, events := subscribeSSE(t, server, "", "")
+	defer closeRespBody(t, resp)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	id, event := nextSSEEvent(t, events)
+	require.Equal(t, "23", id)
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventSnapshot,
+		Version: 23,
+		Columns: []string{"id", "name"},
+		Types:   []string{"int", "string"},
+		Rows:    [][]any{{float64(1), "foo"}, {float64(2), "bar"}},
+	}, event)
+
+	queryMgr.SetLastCompletedVersion(24)
+	id, event = nextSSEEvent(t, events)
+	require.Equal(t, "24", id)
+	require.Equal(t, SubscriptionEventChanges, event.Type)
+	require.Equal(t, int64(24), event.Version)
+	require.ElementsMatch(t, [][]any{{float64(2), "baz"}, {float64(3), "qux"}}, event.Inserts)
+	require.Equal(t, [][]any{{float64(2), "bar"}}, event.Deletes)
+
+	// The table isn't written to in version 25, so the query isn't executed and nothing is sent
+	queryMgr.SetLastCompletedVersion(25)
+	queryMgr.SetLastCompletedVersion(26)
+	id, event = nextSSEEvent(t, events)
+	require.Equal(t, "26", id)
+	require.Equal(t, []int64{23, 24, 26}, queryMgr.getExecutedVersions())
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventChanges,
+		Version: 26,
+		Deletes: [][]any{{float64(1), "foo"}, {float64(3), "qux"}},
+	}, sortDeletes(event))
+
+	// Deleting another stream has no effect, deleting the table ends the subscription
+	server.StreamChanged("other_stream", false)
+	server.StreamChanged("test_table", false)
+	_, event = nextSSEEvent(t, events)
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventError,
+		Version: 26,
+		Error:   "TEK1003 - stream 'test_table' has been deleted",
+	}, event)
+	require.False(t, events.Scan())
+	waitForNoSubscriptions(t, queryMgr)
+}
+
+func TestSubscribeSSEFromVersion(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+
+	queryMgr.setVersionBatches(21, createSubscribeBatch(1, "foo", 2, "bar"))
+	queryMgr.setVersionBatches(23, createSubscribeBatch(1, "foo", 2, "baz"))
+
+	// The changes since the version are sent instead of a snapshot
+	resp, events := subscribeSSE(t, server, "", "21")
+	defer closeRespBody(t, resp)
+	id, event := nextSSEEvent(t, events)
+	require.Equal(t, "23", id)
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventChanges,
+		Version: 23,
+		Inserts: [][]any{{float64(2), "baz"}},
+		Deletes: [][]any{{float64(2), "bar"}},
+	}, event)
+
+	resp2, events2 := subscribeSSE(t, server, "from_version=21", "")
+	defer closeRespBody(t, resp2)
+	id, _ = nextSSEEvent(t, events2)
+	require.Equal(t, "23", id)
+
+	// Carrying on from a version which has not completed yet waits for it to complete
+	queryMgr.setVersionBatches(25, createSubscribeBatch(1, "foo"))
+	queryMgr.setVersionBatches(26, createSubscribeBatch(1, "foo", 5, "quux"))
+	resp3, events3 := subscribeSSE(t, server, "from_version=25", "")
+	defer closeRespBody(t, resp3)
+	queryMgr.SetLastCompletedVersion(25)
+	queryMgr.SetLastCompletedVersion(26)
+	id, event = nextSSEEvent(t, events3)
+	require.Equal(t, "26", id)
+	require.Equal(t, [][]any{{float64(5), "quux"}}, event.Inserts)
+}
+
+func TestSubscribeWebSocket(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+
+	queryMgr.setVersionBatches(23, createSubscribeBatch(1, "foo"))
+	queryMgr.setVersionBatches(24, createSubscribeBatch(1, "foo", 2, "bar"))
+
+	wsURL := fmt.Sprintf("wss://%s/tektite/subscribe?query=%s", server.ListenAddress(), url.QueryEscape(subscribeQuery))
+	config, err := websocket.NewConfig(wsURL, "https://localhost")
+	require.NoError(t, err)
+	config.TlsConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	conn, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+
+	var event SubscriptionEvent
+	err = websocket.JSON.Receive(conn, &event)
+	require.NoError(t, err)
+	require.Equal(t, SubscriptionEventSnapshot, event.Type)
+	require.Equal(t, [][]any{{float64(1), "foo"}}, event.Rows)
+
+	queryMgr.SetLastCompletedVersion(24)
+	event = SubscriptionEvent{}
+	err = websocket.JSON.Receive(conn, &event)
+	require.NoError(t, err)
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventChanges,
+		Version: 24,
+		Inserts: [][]any{{float64(2), "bar"}},
+	}, event)
+
+	// When the subscriber goes away, the subscription ends
+	err = conn.Close()
+	require.NoError(t, err)
+	waitForNoSubscriptions(t, queryMgr)
+}
+
+func TestSubscribeInvalidQuery(t *testing.T) {
+	server, _, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	client := createClient(t, true)
+	resp, err := client.Get(fmt.Sprintf("https://%s/tektite/subscribe?query=%s", server.ListenAddress(),
+		url.QueryEscape("(scran all from test_table)")))
+	require.NoError(t, err)
+	defer closeRespBody(t, resp)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp2, err := client.Get(fmt.Sprintf("https://%s/tektite/subscribe?from_version=foo&query=%s",
+		server.ListenAddress(), url.QueryEscape(subscribeQuery)))
+	require.NoError(t, err)
+	defer closeRespBody(t, resp2)
+	require.Equal(t, http.StatusBadRequest, resp2.StatusCode)
+}
+
+func subscribeSSE(t *testing.T, server *HTTPAPIServer, params string, lastEventID string) (*http.Response, *bufio.Scanner) {
+	client := createClient(t, true)
+	uri := fmt.Sprintf("https://%s/tektite/subscribe?query=%s", server.ListenAddress(), url.QueryEscape(subscribeQuery))
+	if params != "" {
+		uri = fmt.Sprintf("%s&%s", uri, params)
+	}
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp, bufio.NewScanner(resp.Body)
+}
+
+func nextSSEEvent(t *testing.T, scanner *bufio.Scanner) (string, SubscriptionEvent) {
+	var id, eventType string
+	var event SubscriptionEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			require.Equal(t, event.Type, eventType)
+			return id, event
+		}
+		field, value, ok := strings.Cut(line, ": ")
+		require.True(t, ok)
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			eventType = value
+		case "data":
+			err := json.Unmarshal([]byte(value), &event)
+			require.NoError(t, err)
+		default:
+			require.Fail(t, "unexpected field", field)
+		}
+	}
+	require.Fail(t, "no event received")
+	return "", event
+}
+
+func createSubscribeBatch(vals ...any) *evbatch.Batch {
+	builders := evbatch.CreateColBuilders(subscribeSchema.ColumnTypes())
+	for i := 0; i < len(vals); i += 2 {
+		builders[0].(*evbatch.IntColBuilder).Append(int64(vals[i].(int)))
+		builders[1].(*evbatch.StringColBuilder).Append(vals[i+1].(string))
+	}
+	return evbatch.NewBatchFromBuilders(subscribeSchema, builders...)
+}
+
+func sortDeletes(event SubscriptionEvent) SubscriptionEvent {
+	if len(event.Deletes) > 1 && event.Deletes[0][0].(float64) > event.Deletes[1][0].(float64) {
+		event.Deletes[0], event.Deletes[1] = event.Deletes[1], event.Deletes[0]
+	}
+	return event
+}
+
+func waitForNoSubscriptions(t *testing.T, queryMgr *testQueryManager) {
+	start := time.Now()
+	for queryMgr.numVersionListeners() > 0 {
+		require.True(t, time.Since(start) < 5*time.Second, "subscription did not end")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscribeSSEByKey(t *testing.T) {
+	server, queryMgr, _, _ := startServer(t)
+	defer func() {
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+
+	queryMgr.setKeyedResults(23, false, map[string]*evbatch.Batch{
+		"k1": createSubscribeBatch(1, "foo"),
+		"k2": createSubscribeBatch(2, "bar"),
+	})
+	// Only the rows with the keys written are read - the row with k1 is written but its results don't change, and the
+	// row with k4 has no results
+	queryMgr.setKeyedResults(24, true, map[string]*evbatch.Batch{
+		"k1": createSubscribeBatch(1, "foo"),
+		"k2": createSubscribeBatch(2, "baz"),
+		"k3": createSubscribeBatch(3, "qux"),
+		"k4": nil,
+	})
+	queryMgr.setKeyedResults(25, true, map[string]*evbatch.Batch{})
+	// The keys written as of version 26 are not known, so all the results are loaded again
+	queryMgr.setKeyedResults(26, false, map[string]*evbatch.Batch{
+		"k2": createSubscribeBatch(2, "baz"),
+	})
+
+	resp, events := subscribeSSE(t, server, "", "")
+	defer closeRespBody(t, resp)
+	id, event := nextSSEEvent(t, events)
+	require.Equal(t, "23", id)
+	require.Equal(t, SubscriptionEventSnapshot, event.Type)
+	require.Equal(t, []string{"id", "name"}, event.Columns)
+	require.ElementsMatch(t, [][]any{{float64(1), "foo"}, {float64(2), "bar"}}, event.Rows)
+
+	queryMgr.SetLastCompletedVersion(24)
+	id, event = nextSSEEvent(t, events)
+	require.Equal(t, "24", id)
+	require.ElementsMatch(t, [][]any{{float64(2), "baz"}, {float64(3), "qux"}}, event.Inserts)
+	require.Equal(t, [][]any{{float64(2), "bar"}}, event.Deletes)
+
+	// Nothing is written in version 25, so nothing is sent
+	queryMgr.SetLastCompletedVersion(25)
+	testutils.WaitUntil(t, func() (bool, error) {
+		return len(queryMgr.getExecutedKeyed()) == 3, nil
+	})
+	queryMgr.SetLastCompletedVersion(26)
+	id, event = nextSSEEvent(t, events)
+	require.Equal(t, "26", id)
+	require.Equal(t, SubscriptionEvent{
+		Type:    SubscriptionEventChanges,
+		Version: 26,
+		Deletes: [][]any{{float64(1), "foo"}, {float64(3), "qux"}},
+	}, sortDeletes(event))
+	require.Equal(t, []string{"all:23", "changed:23-24", "changed:24-25", "all:26"}, queryMgr.getExecutedKeyed())
+	// The query is never executed in full to find the changes
+	require.Empty(t, queryMgr.getExecutedVersions())
+}
+
+func TestSubscriptionGatherResults(t *testing.T) {
+	sub := &subscription{stopCh: make(chan struct{})}
+	testErr := fmt.Errorf("test error")
+	_, _, err := sub.gatherResults(func(outputFunc func(bool, int, *evbatch.Batch, error) error) (bool, error) {
+		// The results are only waited for after this returns, so this would block if sending any of them blocked
+		require.NoError(t, outputFunc(false, 1, nil, testErr))
+		require.NoError(t, outputFunc(false, 1, nil, testErr))
+		require.NoError(t, outputFunc(true, 1, createSubscribeBatch(1, "foo"), nil))
+		return true, nil
+	})
+	require.Equal(t, testErr, err)
+
+	// When the subscription stops it no longer waits for the results
+	close(sub.stopCh)
+	_, _, err = sub.gatherResults(func(func(bool, int, *evbatch.Batch, error) error) (bool, error) {
+		return true, nil
+	})
+	require.Equal(t, errSubscriptionStopped, err)
+}
@@ -0,0 +1,382 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+const testRecordSchema = `{
+	"type": "record",
+	"name": "Order",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "quantity", "type": "int"},
+		{"name": "price", "type": "double"},
+		{"name": "discount", "type": "float"},
+		{"name": "paid", "type": "boolean"},
+		{"name": "customer", "type": "string"},
+		{"name": "payload", "type": "bytes"},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "SHIPPED"]}},
+		{"name": "checksum", "type": {"type": "fixed", "name": "Checksum", "size": 4}},
+		{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "updated", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+		{"name": "shipDate", "type": {"type": "int", "logicalType": "date"}},
+		{"name": "notes", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attributes", "type": {"type": "map", "values": "long"}},
+		{"name": "address", "type": ["null", {"type": "record", "name": "Address", "fields": [
+			{"name": "street", "type": "string"},
+			{"name": "city", "type": "string"}
+		]}]},
+		{"name": "region", "type": "string", "default": "emea"}
+	]
+}`
+
+func testOrder() map[string]any {
+	return map[string]any{
+		"id":         int64(1234567890123),
+		"quantity":   int64(-7),
+		"price":      float64(23.25),
+		"discount":   float64(0.5),
+		"paid":       true,
+		"customer":   "alice",
+		"payload":    []byte("some-bytes"),
+		"status":     "SHIPPED",
+		"checksum":   []byte{1, 2, 3, 4},
+		"amount":     mustDecimal("-12345.67", 10, 2),
+		"created":    types.NewTimestamp(1710400000123),
+		"updated":    types.NewTimestamp(1710400000456),
+		"shipDate":   types.NewTimestamp(19797 * 24 * 60 * 60 * 1000),
+		"notes":      nil,
+		"tags":       []any{"a", "b"},
+		"attributes": map[string]any{"x": int64(1), "y": int64(-2)},
+		"address":    map[string]any{"street": "main st", "city": "london"},
+		"region":     "apac",
+	}
+}
+
+func mustDecimal(s string, precision int, scale int) types.Decimal {
+	d, err := types.NewDecimalFromString(s, precision, scale)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	require.Equal(t, TypeRecord, schema.Type)
+	require.Equal(t, "com.example.Order", schema.Name)
+	require.Equal(t, 18, len(schema.Fields))
+
+	status, index := schema.FieldByName("status")
+	require.Equal(t, 7, index)
+	require.Equal(t, TypeEnum, status.Schema.Type)
+	require.Equal(t, "com.example.Status", status.Schema.Name)
+	require.Equal(t, []string{"NEW", "SHIPPED"}, status.Schema.Symbols)
+
+	amount, _ := schema.FieldByName("amount")
+	require.Equal(t, LogicalTypeDecimal, amount.Schema.LogicalType)
+	require.Equal(t, 10, amount.Schema.Precision)
+	require.Equal(t, 2, amount.Schema.Scale)
+
+	notes, _ := schema.FieldByName("notes")
+	require.True(t, notes.HasDefault)
+	require.Nil(t, notes.Default)
+	require.Equal(t, TypeString, notes.Schema.NonNullBranch().Type)
+
+	address, _ := schema.FieldByName("address")
+	require.Equal(t, "com.example.Address", address.Schema.NonNullBranch().Name)
+
+	field, index := schema.FieldByName("unknown")
+	require.Nil(t, field)
+	require.Equal(t, -1, index)
+}
+
+func TestParseRecursiveSchema(t *testing.T) {
+	schema, err := ParseSchema(`{"type": "record", "name": "Node", "fields": [
+		{"name": "value", "type": "int"},
+		{"name": "next", "type": ["null", "Node"]}
+	]}`)
+	require.NoError(t, err)
+	next, _ := schema.FieldByName("next")
+	require.Same(t, schema, next.Schema.NonNullBranch())
+
+	value := map[string]any{"value": int64(1), "next": map[string]any{"value": int64(2), "next": nil}}
+	encoded, err := Encode(schema, value, nil)
+	require.NoError(t, err)
+	decoded, rest, err := DecodeValue(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	require.Equal(t, value, decoded)
+}
+
+func TestParseSchemaPrimitives(t *testing.T) {
+	for name, typ := range primitiveTypes {
+		schema, err := ParseSchema(`"` + name + `"`)
+		require.NoError(t, err)
+		require.Equal(t, typ, schema.Type)
+		schema, err = ParseSchema(`{"type": "` + name + `"}`)
+		require.NoError(t, err)
+		require.Equal(t, typ, schema.Type)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	testParseSchemaError(t, `{`, "invalid avro schema: unexpected end of JSON input")
+	testParseSchemaError(t, `"foo"`, "invalid avro schema: unknown type 'foo'")
+	testParseSchemaError(t, `{"name": "foo"}`, "invalid avro schema: missing 'type'")
+	testParseSchemaError(t, `{"type": "record", "fields": []}`, "invalid avro schema: record must have a 'name'")
+	testParseSchemaError(t, `{"type": "record", "name": "foo"}`, "invalid avro schema: record 'foo' must have 'fields'")
+	testParseSchemaError(t, `{"type": "record", "name": "foo", "fields": [{"type": "int"}]}`,
+		"invalid avro schema: field in record 'foo' must have a 'name'")
+	testParseSchemaError(t, `{"type": "enum", "name": "foo"}`, "invalid avro schema: enum 'foo' must have 'symbols'")
+	testParseSchemaError(t, `{"type": "fixed", "name": "foo"}`, "invalid avro schema: fixed 'foo' must have a 'size'")
+	testParseSchemaError(t, `[]`, "invalid avro schema: union must have at least one branch")
+	testParseSchemaError(t, `["int", ["string"]]`, "invalid avro schema: unions cannot directly contain other unions")
+	testParseSchemaError(t, `{"type": "record", "name": "foo", "fields": [
+		{"name": "a", "type": {"type": "fixed", "name": "foo", "size": 1}}]}`,
+		"invalid avro schema: type 'foo' is defined more than once")
+	testParseSchemaError(t, `{"type": "bytes", "logicalType": "decimal", "precision": 2, "scale": 3}`,
+		"invalid avro schema: decimal must have a 'precision' > 0 and a 'scale' <= precision")
+}
+
+func testParseSchemaError(t *testing.T, schema string, errMsg string) {
+	_, err := ParseSchema(schema)
+	require.Error(t, err)
+	require.Equal(t, errMsg, err.Error())
+}
+
+func TestUnknownLogicalTypeIgnored(t *testing.T) {
+	schema, err := ParseSchema(`{"type": "string", "logicalType": "uuid"}`)
+	require.NoError(t, err)
+	require.Equal(t, "", schema.LogicalType)
+	schema, err = ParseSchema(`{"type": "string", "logicalType": "timestamp-millis"}`)
+	require.NoError(t, err)
+	require.Equal(t, "", schema.LogicalType)
+}
+
+func TestEncodeDecodeRecord(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	order := testOrder()
+	encoded, err := Encode(schema, order, nil)
+	require.NoError(t, err)
+
+	decoded, rest, err := DecodeValue(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	require.Equal(t, order, decoded)
+
+	rest, err = Skip(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+}
+
+func TestEncodingMatchesSpec(t *testing.T) {
+	// Examples from the Avro specification
+	testEncoding(t, `"long"`, int64(0), []byte{0x00})
+	testEncoding(t, `"long"`, int64(-1), []byte{0x01})
+	testEncoding(t, `"long"`, int64(1), []byte{0x02})
+	testEncoding(t, `"long"`, int64(-64), []byte{0x7f})
+	testEncoding(t, `"long"`, int64(64), []byte{0x80, 0x01})
+	testEncoding(t, `"string"`, "foo", []byte{0x06, 0x66, 0x6f, 0x6f})
+	testEncoding(t, `["null", "string"]`, nil, []byte{0x00})
+	testEncoding(t, `["null", "string"]`, "a", []byte{0x02, 0x02, 0x61})
+	testEncoding(t, `{"type": "array", "items": "long"}`, []any{int64(3), int64(27)}, []byte{0x04, 0x06, 0x36, 0x00})
+	testEncoding(t, `{"type": "record", "name": "test", "fields": [{"name": "a", "type": "long"}, {"name": "b", "type": "string"}]}`,
+		map[string]any{"a": int64(27), "b": "foo"}, []byte{0x36, 0x06, 0x66, 0x6f, 0x6f})
+	testEncoding(t, `"boolean"`, true, []byte{0x01})
+	testEncoding(t, `"float"`, float64(1), []byte{0x00, 0x00, 0x80, 0x3f})
+	testEncoding(t, `"double"`, float64(1), []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f})
+}
+
+func testEncoding(t *testing.T, schemaJson string, value any, expected []byte) {
+	schema, err := ParseSchema(schemaJson)
+	require.NoError(t, err)
+	encoded, err := Encode(schema, value, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, encoded)
+	decoded, rest, err := DecodeValue(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	require.Equal(t, value, decoded)
+}
+
+func TestDecodeBlocksWithByteSize(t *testing.T) {
+	schema, err := ParseSchema(`{"type": "array", "items": "long"}`)
+	require.NoError(t, err)
+	// A negative count is followed by the size of the block in bytes
+	encoded := []byte{0x03, 0x04, 0x06, 0x36, 0x02, 0x02, 0x00}
+	decoded, rest, err := DecodeValue(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	require.Equal(t, []any{int64(3), int64(27), int64(1)}, decoded)
+	rest, err = Skip(schema, encoded)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+}
+
+func TestEncodeDecimals(t *testing.T) {
+	bytesSchema := `{"type": "bytes", "logicalType": "decimal", "precision": 20, "scale": 3}`
+	testEncoding(t, bytesSchema, mustDecimal("0", 20, 3), []byte{0x02, 0x00})
+	// 1.000 is 1000 unscaled, 0x03e8
+	testEncoding(t, bytesSchema, mustDecimal("1", 20, 3), []byte{0x04, 0x03, 0xe8})
+	// -0.128 is -128 unscaled, which fits in one byte
+	testEncoding(t, bytesSchema, mustDecimal("-0.128", 20, 3), []byte{0x02, 0x80})
+	// -0.129 is -129 unscaled, which needs two bytes
+	testEncoding(t, bytesSchema, mustDecimal("-0.129", 20, 3), []byte{0x04, 0xff, 0x7f})
+	// 0.128 needs a leading zero byte, so it is not read as negative
+	testEncoding(t, bytesSchema, mustDecimal("0.128", 20, 3), []byte{0x04, 0x00, 0x80})
+	testEncoding(t, bytesSchema, mustDecimal("-123456789012345.678", 20, 3),
+		[]byte{0x10, 0xfe, 0x49, 0x64, 0xb4, 0x59, 0xcf, 0x0c, 0xb2})
+
+	fixedSchema := `{"type": "fixed", "name": "dec", "size": 8, "logicalType": "decimal", "precision": 18, "scale": 2}`
+	testEncoding(t, fixedSchema, mustDecimal("-1.00", 18, 2), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x9c})
+	testEncoding(t, fixedSchema, mustDecimal("1.00", 18, 2), []byte{0, 0, 0, 0, 0, 0, 0, 0x64})
+}
+
+func TestEncodeFromJSON(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	// Values unmarshalled from JSON are converted to the types of the schema. Missing fields are given their defaults.
+	doc := `{"id": 1234567890123, "quantity": -7, "price": 23.25, "discount": 0.5, "paid": true, "customer": "alice",
+		"payload": "some-bytes", "status": "SHIPPED", "checksum": "\u0001\u0002\u0003\u0004", "amount": "-12345.67",
+		"created": 1710400000123, "updated": 1710400000456000, "shipDate": 19797, "tags": ["a", "b"],
+		"attributes": {"x": 1, "y": -2}, "address": {"street": "main st", "city": "london"}}`
+	var value any
+	require.NoError(t, json.Unmarshal([]byte(doc), &value))
+	encoded, err := Encode(schema, value, nil)
+	require.NoError(t, err)
+	decoded, _, err := DecodeValue(schema, encoded)
+	require.NoError(t, err)
+	expected := testOrder()
+	expected["region"] = "emea"
+	require.Equal(t, expected, decoded)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	testEncodeError(t, `"int"`, "foo", "cannot encode value of type string as avro int")
+	testEncodeError(t, `"int"`, int64(math.MaxInt32+1), "cannot encode 2147483648 as avro int - it is out of range")
+	testEncodeError(t, `"long"`, 1.5, "cannot encode value of type float64 as avro long")
+	testEncodeError(t, `"string"`, nil, "cannot encode null as avro string")
+	testEncodeError(t, `{"type": "enum", "name": "e", "symbols": ["A"]}`, "B",
+		"cannot encode 'B' as avro enum 'e' - it is not one of its symbols")
+	testEncodeError(t, `{"type": "fixed", "name": "f", "size": 2}`, []byte{1},
+		"cannot encode 1 bytes as avro fixed 'f' of size 2")
+	testEncodeError(t, `["null", "int"]`, "foo", "cannot encode value of type string as avro union<null, int>")
+	testEncodeError(t, `{"type": "record", "name": "r", "fields": [{"name": "a", "type": "int"}]}`,
+		map[string]any{}, "cannot encode field 'a' of avro record 'r': cannot encode null as avro int")
+}
+
+func testEncodeError(t *testing.T, schemaJson string, value any, errMsg string) {
+	schema, err := ParseSchema(schemaJson)
+	require.NoError(t, err)
+	_, err = Encode(schema, value, nil)
+	require.Error(t, err)
+	require.Equal(t, errMsg, err.Error())
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	encoded, err := Encode(schema, testOrder(), nil)
+	require.NoError(t, err)
+	for i := 0; i < len(encoded); i++ {
+		_, _, err := DecodeValue(schema, encoded[:i])
+		require.Error(t, err)
+		_, err = Skip(schema, encoded[:i])
+		require.Error(t, err)
+	}
+}
+
+func TestFindField(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	order := testOrder()
+	encoded, err := Encode(schema, order, nil)
+	require.NoError(t, err)
+
+	for _, f := range schema.Fields {
+		fieldSchema, buff, found, err := FindField(schema, encoded, []string{f.Name})
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Same(t, f.Schema, fieldSchema)
+		v, _, err := DecodeValue(fieldSchema, buff)
+		require.NoError(t, err)
+		require.Equal(t, order[f.Name], v)
+	}
+
+	fieldSchema, buff, found, err := FindField(schema, encoded, []string{"address", "city"})
+	require.NoError(t, err)
+	require.True(t, found)
+	v, _, err := DecodeValue(fieldSchema, buff)
+	require.NoError(t, err)
+	require.Equal(t, "london", v)
+
+	_, _, found, err = FindField(schema, encoded, []string{"unknown"})
+	require.NoError(t, err)
+	require.False(t, found)
+	_, _, found, err = FindField(schema, encoded, []string{"customer", "name"})
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// If an optional record on the path is null, the value is null
+	order["address"] = nil
+	encoded, err = Encode(schema, order, nil)
+	require.NoError(t, err)
+	fieldSchema, _, found, err = FindField(schema, encoded, []string{"address", "city"})
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, TypeNull, fieldSchema.Type)
+}
+
+func TestAppendJSON(t *testing.T) {
+	schema, err := ParseSchema(testRecordSchema)
+	require.NoError(t, err)
+	encoded, err := Encode(schema, testOrder(), nil)
+	require.NoError(t, err)
+	out, rest, err := AppendJSON(schema, encoded, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	expected := `{"id":1234567890123,"quantity":-7,"price":23.25,"discount":0.5,"paid":true,"customer":"alice",` +
+		`"payload":"some-bytes","status":"SHIPPED","checksum":"\u0001\u0002\u0003\u0004","amount":"-12345.67",` +
+		`"created":1710400000123,"updated":1710400000456,"shipDate":1710460800000,"notes":null,"tags":["a","b"],` +
+		`"attributes":{"x":1,"y":-2},"address":{"street":"main st","city":"london"},"region":"apac"}`
+	require.Equal(t, expected, string(out))
+	require.True(t, json.Valid(out))
+}
+
+func TestDecodeBlockCountOutOfRange(t *testing.T) {
+	// The count is larger than the remaining data, so the items cannot all be present
+	schema, err := ParseSchema(`{"type": "array", "items": "long"}`)
+	require.NoError(t, err)
+	encoded := binary.AppendVarint(nil, 1<<40)
+	encoded = append(encoded, 0x02, 0x04)
+	_, _, err = DecodeValue(schema, encoded)
+	require.Equal(t, errTruncated, err)
+	_, err = Skip(schema, encoded)
+	require.Equal(t, errTruncated, err)
+
+	// Null items take no bytes, so the number of them is limited
+	schema, err = ParseSchema(`{"type": "array", "items": "null"}`)
+	require.NoError(t, err)
+	decoded, rest, err := DecodeValue(schema, []byte{0x06, 0x00})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rest))
+	require.Equal(t, []any{nil, nil, nil}, decoded)
+	encoded = binary.AppendVarint(nil, 1<<40)
+	encoded = append(encoded, 0x00)
+	_, _, err = DecodeValue(schema, encoded)
+	require.Error(t, err)
+	require.Equal(t, "invalid avro data: more than 1048576 items with no data", err.Error())
+	_, err = Skip(schema, encoded)
+	require.Error(t, err)
+}
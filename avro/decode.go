@@ -0,0 +1,436 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/apache/arrow/go/v11/arrow/decimal128"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+var errTruncated = errors.New("invalid avro data: value is truncated")
+
+// DecodeValue decodes the value at the start of buff, and returns the remainder of buff. Values are decoded as:
+// null as nil, boolean as bool, int and long as int64, float and double as float64, bytes and fixed as []byte, string and
+// enum as string, decimal as types.Decimal, date and timestamps as types.Timestamp, records and maps as map[string]any,
+// and arrays as []any. Unions are decoded as the value of the branch that is present.
+func DecodeValue(schema *Schema, buff []byte) (any, []byte, error) {
+	switch schema.Type {
+	case TypeNull:
+		return nil, buff, nil
+	case TypeBoolean:
+		if len(buff) < 1 {
+			return nil, nil, errTruncated
+		}
+		return buff[0] != 0, buff[1:], nil
+	case TypeInt, TypeLong:
+		v, buff, err := readLong(buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch schema.LogicalType {
+		case LogicalTypeDate:
+			return types.NewTimestamp(v * 24 * 60 * 60 * 1000), buff, nil
+		case LogicalTypeTimestampMillis:
+			return types.NewTimestamp(v), buff, nil
+		case LogicalTypeTimestampMicros:
+			return types.NewTimestamp(v / 1000), buff, nil
+		}
+		return v, buff, nil
+	case TypeFloat:
+		if len(buff) < 4 {
+			return nil, nil, errTruncated
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(buff))), buff[4:], nil
+	case TypeDouble:
+		if len(buff) < 8 {
+			return nil, nil, errTruncated
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(buff)), buff[8:], nil
+	case TypeBytes, TypeFixed:
+		var b []byte
+		var err error
+		if schema.Type == TypeBytes {
+			b, buff, err = readBytes(buff)
+		} else {
+			b, buff, err = readFixed(buff, schema.Size)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if schema.LogicalType == LogicalTypeDecimal {
+			return decodeDecimal(b, schema), buff, nil
+		}
+		return b, buff, nil
+	case TypeString:
+		b, buff, err := readBytes(buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(b), buff, nil
+	case TypeEnum:
+		index, buff, err := readLong(buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		if index < 0 || int(index) >= len(schema.Symbols) {
+			return nil, nil, errors.Errorf("invalid avro data: enum index %d out of range for '%s'", index, schema.Name)
+		}
+		return schema.Symbols[index], buff, nil
+	case TypeUnion:
+		branch, buff, err := readUnionBranch(schema, buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		return DecodeValue(branch, buff)
+	case TypeRecord:
+		rec := make(map[string]any, len(schema.Fields))
+		for _, f := range schema.Fields {
+			var v any
+			var err error
+			v, buff, err = DecodeValue(f.Schema, buff)
+			if err != nil {
+				return nil, nil, err
+			}
+			rec[f.Name] = v
+		}
+		return rec, buff, nil
+	case TypeArray:
+		var arr []any
+		err := readBlocks(buff, isZeroWidth(schema.Items), func(b []byte) ([]byte, error) {
+			v, b, err := DecodeValue(schema.Items, b)
+			arr = append(arr, v)
+			return b, err
+		}, &buff)
+		return arr, buff, err
+	case TypeMap:
+		m := map[string]any{}
+		err := readBlocks(buff, false, func(b []byte) ([]byte, error) {
+			key, b, err := readBytes(b)
+			if err != nil {
+				return nil, err
+			}
+			v, b, err := DecodeValue(schema.Values, b)
+			m[string(key)] = v
+			return b, err
+		}, &buff)
+		return m, buff, err
+	default:
+		panic("unknown avro type")
+	}
+}
+
+// Skip skips the value at the start of buff, and returns the remainder of buff
+func Skip(schema *Schema, buff []byte) ([]byte, error) {
+	switch schema.Type {
+	case TypeNull:
+		return buff, nil
+	case TypeBoolean:
+		if len(buff) < 1 {
+			return nil, errTruncated
+		}
+		return buff[1:], nil
+	case TypeInt, TypeLong, TypeEnum:
+		_, buff, err := readLong(buff)
+		return buff, err
+	case TypeFloat:
+		if len(buff) < 4 {
+			return nil, errTruncated
+		}
+		return buff[4:], nil
+	case TypeDouble:
+		if len(buff) < 8 {
+			return nil, errTruncated
+		}
+		return buff[8:], nil
+	case TypeBytes, TypeString:
+		_, buff, err := readBytes(buff)
+		return buff, err
+	case TypeFixed:
+		_, buff, err := readFixed(buff, schema.Size)
+		return buff, err
+	case TypeUnion:
+		branch, buff, err := readUnionBranch(schema, buff)
+		if err != nil {
+			return nil, err
+		}
+		return Skip(branch, buff)
+	case TypeRecord:
+		var err error
+		for _, f := range schema.Fields {
+			if buff, err = Skip(f.Schema, buff); err != nil {
+				return nil, err
+			}
+		}
+		return buff, nil
+	case TypeArray:
+		err := readBlocks(buff, isZeroWidth(schema.Items), func(b []byte) ([]byte, error) {
+			return Skip(schema.Items, b)
+		}, &buff)
+		return buff, err
+	case TypeMap:
+		err := readBlocks(buff, false, func(b []byte) ([]byte, error) {
+			_, b, err := readBytes(b)
+			if err != nil {
+				return nil, err
+			}
+			return Skip(schema.Values, b)
+		}, &buff)
+		return buff, err
+	default:
+		panic("unknown avro type")
+	}
+}
+
+// FindField locates the value of the field with the given path in the record at the start of buff, skipping the fields
+// before it, and returns the schema of the value and the buffer positioned at it. The path can refer to fields of nested
+// records. Optional records on the path are followed if present - if one is null, the returned schema is the null
+// schema. found is false if the path does not exist in the schema.
+func FindField(schema *Schema, buff []byte, path []string) (fieldSchema *Schema, fieldBuff []byte, found bool, err error) {
+	for _, name := range path {
+		if schema.Type == TypeUnion {
+			if schema, buff, err = readUnionBranch(schema, buff); err != nil {
+				return nil, nil, false, err
+			}
+			if schema.Type == TypeNull {
+				return schema, buff, true, nil
+			}
+		}
+		if schema.Type != TypeRecord {
+			return nil, nil, false, nil
+		}
+		field, index := schema.FieldByName(name)
+		if field == nil {
+			return nil, nil, false, nil
+		}
+		for _, f := range schema.Fields[:index] {
+			if buff, err = Skip(f.Schema, buff); err != nil {
+				return nil, nil, false, err
+			}
+		}
+		schema = field.Schema
+	}
+	return schema, buff, true, nil
+}
+
+// AppendJSON decodes the value at the start of buff and appends it to out as JSON, and returns the remainder of buff.
+// Values are represented in the same way Tektite represents them in JSON results: unions as the value of the branch
+// that is present, bytes and fixed as strings, decimals as strings to preserve their precision, and dates and
+// timestamps as milliseconds past the epoch.
+func AppendJSON(schema *Schema, buff []byte, out []byte) ([]byte, []byte, error) {
+	switch schema.Type {
+	case TypeRecord:
+		out = append(out, '{')
+		for i, f := range schema.Fields {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = appendJSONString(out, f.Name)
+			out = append(out, ':')
+			var err error
+			out, buff, err = AppendJSON(f.Schema, buff, out)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return append(out, '}'), buff, nil
+	case TypeArray:
+		out = append(out, '[')
+		first := true
+		err := readBlocks(buff, isZeroWidth(schema.Items), func(b []byte) ([]byte, error) {
+			if !first {
+				out = append(out, ',')
+			}
+			first = false
+			var err error
+			out, b, err = AppendJSON(schema.Items, b, out)
+			return b, err
+		}, &buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(out, ']'), buff, nil
+	case TypeMap:
+		out = append(out, '{')
+		first := true
+		err := readBlocks(buff, false, func(b []byte) ([]byte, error) {
+			if !first {
+				out = append(out, ',')
+			}
+			first = false
+			key, b, err := readBytes(b)
+			if err != nil {
+				return nil, err
+			}
+			out = appendJSONString(out, string(key))
+			out = append(out, ':')
+			out, b, err = AppendJSON(schema.Values, b, out)
+			return b, err
+		}, &buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		return append(out, '}'), buff, nil
+	case TypeUnion:
+		branch, buff, err := readUnionBranch(schema, buff)
+		if err != nil {
+			return nil, nil, err
+		}
+		return AppendJSON(branch, buff, out)
+	}
+	v, buff, err := DecodeValue(schema, buff)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch tv := v.(type) {
+	case nil:
+		out = append(out, "null"...)
+	case bool:
+		out = strconv.AppendBool(out, tv)
+	case int64:
+		out = strconv.AppendInt(out, tv, 10)
+	case float64:
+		if math.IsNaN(tv) || math.IsInf(tv, 0) {
+			// Not representable in JSON
+			out = append(out, "null"...)
+		} else {
+			out = strconv.AppendFloat(out, tv, 'g', -1, 64)
+		}
+	case []byte:
+		out = appendJSONString(out, string(tv))
+	case string:
+		out = appendJSONString(out, tv)
+	case types.Decimal:
+		out = appendJSONString(out, tv.Num.ToString(int32(tv.Scale)))
+	case types.Timestamp:
+		out = strconv.AppendInt(out, tv.Val, 10)
+	default:
+		panic("unexpected avro value")
+	}
+	return out, buff, nil
+}
+
+func appendJSONString(out []byte, s string) []byte {
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(err) // marshalling a string cannot fail
+	}
+	return append(out, b...)
+}
+
+func decodeDecimal(b []byte, schema *Schema) types.Decimal {
+	// The unscaled value is a big-endian two's-complement integer
+	unscaled := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return types.Decimal{
+		Num:       decimal128.FromBigInt(unscaled),
+		Precision: schema.Precision,
+		Scale:     schema.Scale,
+	}
+}
+
+func readLong(buff []byte) (int64, []byte, error) {
+	// Avro longs are zig-zag encoded varints, which is the encoding binary.Varint reads
+	v, n := binary.Varint(buff)
+	if n <= 0 {
+		return 0, nil, errTruncated
+	}
+	return v, buff[n:], nil
+}
+
+func readBytes(buff []byte) ([]byte, []byte, error) {
+	l, buff, err := readLong(buff)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l < 0 || l > int64(len(buff)) {
+		return nil, nil, errTruncated
+	}
+	return buff[:l], buff[l:], nil
+}
+
+func readFixed(buff []byte, size int) ([]byte, []byte, error) {
+	if len(buff) < size {
+		return nil, nil, errTruncated
+	}
+	return buff[:size], buff[size:], nil
+}
+
+func readUnionBranch(schema *Schema, buff []byte) (*Schema, []byte, error) {
+	index, buff, err := readLong(buff)
+	if err != nil {
+		return nil, nil, err
+	}
+	if index < 0 || int(index) >= len(schema.Branches) {
+		return nil, nil, errors.Errorf("invalid avro data: union index %d out of range", index)
+	}
+	return schema.Branches[index], buff, nil
+}
+
+// readBlocks reads the blocks of an array or map, calling readItem for each item, and updates buff to the remainder
+// maxZeroWidthItems is the maximum number of items in an array of an item type that takes no bytes to encode, such as
+// null. The number of items of other types is limited by the size of the data.
+const maxZeroWidthItems = 1 << 20
+
+func readBlocks(b []byte, zeroWidth bool, readItem func([]byte) ([]byte, error), buff *[]byte) error {
+	var total int64
+	for {
+		count, rest, err := readLong(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		if count == 0 {
+			*buff = b
+			return nil
+		}
+		if count < 0 {
+			if count == math.MinInt64 {
+				return errors.Errorf("invalid avro data: block count %d out of range", count)
+			}
+			// A negative count is followed by the size of the block in bytes
+			count = -count
+			if _, b, err = readLong(b); err != nil {
+				return err
+			}
+		}
+		if zeroWidth {
+			total += count
+			if count > maxZeroWidthItems || total > maxZeroWidthItems {
+				return errors.Errorf("invalid avro data: more than %d items with no data", maxZeroWidthItems)
+			}
+		} else if count > int64(len(b)) {
+			// Each item takes at least one byte
+			return errTruncated
+		}
+		for i := int64(0); i < count; i++ {
+			if b, err = readItem(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isZeroWidth returns true if values of the schema take no bytes to encode
+func isZeroWidth(schema *Schema) bool {
+	switch schema.Type {
+	case TypeNull:
+		return true
+	case TypeFixed:
+		return schema.Size == 0
+	case TypeRecord:
+		for _, f := range schema.Fields {
+			if !isZeroWidth(f.Schema) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
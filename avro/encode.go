@@ -0,0 +1,288 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// Encode appends the Avro binary encoding of value to out. It accepts the values DecodeValue returns, and also the
+// values produced by unmarshalling JSON with encoding/json, so JSON documents can be encoded: numbers as float64 or
+// json.Number, and decimals, dates and timestamps as strings or numbers. Missing record fields are encoded with their
+// default value, or as null if they are optional.
+func Encode(schema *Schema, value any, out []byte) ([]byte, error) {
+	switch schema.Type {
+	case TypeNull:
+		if value != nil {
+			return nil, encodeTypeError(schema, value)
+		}
+		return out, nil
+	case TypeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		if b {
+			return append(out, 1), nil
+		}
+		return append(out, 0), nil
+	case TypeInt, TypeLong:
+		v, ok := longValue(schema, value)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		if schema.Type == TypeInt && (v < math.MinInt32 || v > math.MaxInt32) {
+			return nil, errors.Errorf("cannot encode %d as avro int - it is out of range", v)
+		}
+		return binary.AppendVarint(out, v), nil
+	case TypeFloat, TypeDouble:
+		f, ok := doubleValue(value)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		if schema.Type == TypeFloat {
+			return binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(f))), nil
+		}
+		return binary.LittleEndian.AppendUint64(out, math.Float64bits(f)), nil
+	case TypeBytes, TypeFixed:
+		var b []byte
+		if schema.LogicalType == LogicalTypeDecimal {
+			d, ok := decimalValue(schema, value)
+			if !ok {
+				return nil, encodeTypeError(schema, value)
+			}
+			b = encodeDecimal(d, schema)
+		} else {
+			switch v := value.(type) {
+			case []byte:
+				b = v
+			case string:
+				b = []byte(v)
+			default:
+				return nil, encodeTypeError(schema, value)
+			}
+		}
+		if schema.Type == TypeFixed {
+			if len(b) != schema.Size {
+				return nil, errors.Errorf("cannot encode %d bytes as avro fixed '%s' of size %d", len(b), schema.Name,
+					schema.Size)
+			}
+			return append(out, b...), nil
+		}
+		out = binary.AppendVarint(out, int64(len(b)))
+		return append(out, b...), nil
+	case TypeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		out = binary.AppendVarint(out, int64(len(s)))
+		return append(out, s...), nil
+	case TypeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		for i, sym := range schema.Symbols {
+			if sym == s {
+				return binary.AppendVarint(out, int64(i)), nil
+			}
+		}
+		return nil, errors.Errorf("cannot encode '%s' as avro enum '%s' - it is not one of its symbols", s, schema.Name)
+	case TypeUnion:
+		for i, branch := range schema.Branches {
+			// We choose the first branch the value can be encoded as
+			encoded, err := Encode(branch, value, binary.AppendVarint(nil, int64(i)))
+			if err == nil {
+				return append(out, encoded...), nil
+			}
+		}
+		return nil, encodeTypeError(schema, value)
+	case TypeRecord:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		for _, f := range schema.Fields {
+			v, present := m[f.Name]
+			if !present && f.HasDefault {
+				v = f.Default
+				if f.Schema.Type == TypeUnion && v != nil {
+					// The default of a union field is a value of its first branch
+					var err error
+					if out, err = Encode(f.Schema.Branches[0], v, binary.AppendVarint(out, 0)); err != nil {
+						return nil, err
+					}
+					continue
+				}
+			}
+			var err error
+			if out, err = Encode(f.Schema, v, out); err != nil {
+				return nil, errors.Errorf("cannot encode field '%s' of avro record '%s': %v", f.Name, schema.Name,
+					err)
+			}
+		}
+		return out, nil
+	case TypeArray:
+		arr, ok := value.([]any)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		if len(arr) > 0 {
+			out = binary.AppendVarint(out, int64(len(arr)))
+			for _, item := range arr {
+				var err error
+				if out, err = Encode(schema.Items, item, out); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(out, 0), nil
+	case TypeMap:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, encodeTypeError(schema, value)
+		}
+		if len(m) > 0 {
+			// We encode the entries in key order, so the encoding is deterministic
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			out = binary.AppendVarint(out, int64(len(keys)))
+			for _, k := range keys {
+				out = binary.AppendVarint(out, int64(len(k)))
+				out = append(out, k...)
+				var err error
+				if out, err = Encode(schema.Values, m[k], out); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(out, 0), nil
+	default:
+		panic("unknown avro type")
+	}
+}
+
+func encodeTypeError(schema *Schema, value any) error {
+	if value == nil {
+		return errors.Errorf("cannot encode null as avro %s", schema.String())
+	}
+	return errors.Errorf("cannot encode value of type %T as avro %s", value, schema.String())
+}
+
+func longValue(schema *Schema, value any) (int64, bool) {
+	if ts, ok := value.(types.Timestamp); ok {
+		switch schema.LogicalType {
+		case LogicalTypeDate:
+			return ts.Val / (24 * 60 * 60 * 1000), true
+		case LogicalTypeTimestampMillis:
+			return ts.Val, true
+		case LogicalTypeTimestampMicros:
+			return ts.Val * 1000, true
+		default:
+			return 0, false
+		}
+	}
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func doubleValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func decimalValue(schema *Schema, value any) (types.Decimal, bool) {
+	var d types.Decimal
+	var err error
+	switch v := value.(type) {
+	case types.Decimal:
+		return v.ConvertPrecisionAndScale(schema.Precision, schema.Scale), true
+	case string:
+		d, err = types.NewDecimalFromString(v, schema.Precision, schema.Scale)
+	case json.Number:
+		d, err = types.NewDecimalFromString(v.String(), schema.Precision, schema.Scale)
+	case float64:
+		d, err = types.NewDecimalFromFloat64(v, schema.Precision, schema.Scale)
+	case int64:
+		d = types.NewDecimalFromInt64(v, schema.Precision, schema.Scale)
+	default:
+		return types.Decimal{}, false
+	}
+	return d, err == nil
+}
+
+func encodeDecimal(d types.Decimal, schema *Schema) []byte {
+	unscaled := d.Num.BigInt()
+	var b []byte
+	if unscaled.Sign() >= 0 {
+		b = unscaled.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			// Add a leading zero byte so the value is not read as negative
+			b = append([]byte{0}, b...)
+		}
+	} else {
+		// The two's complement of a negative value of n bytes is 2^(8n) + value
+		n := len(new(big.Int).Neg(unscaled).Bytes())
+		if n == 0 {
+			n = 1
+		}
+		twos := new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), uint(n*8)), unscaled)
+		b = twos.Bytes()
+		if len(b) < n {
+			b = append(make([]byte, n-len(b)), b...)
+		}
+		if b[0]&0x80 == 0 {
+			b = append([]byte{0xff}, b...)
+		}
+	}
+	if schema.Type == TypeFixed && len(b) < schema.Size {
+		// Sign extend to the size of the fixed
+		pad := byte(0)
+		if unscaled.Sign() < 0 {
+			pad = 0xff
+		}
+		padded := make([]byte, schema.Size-len(b), schema.Size)
+		for i := range padded {
+			padded[i] = pad
+		}
+		b = append(padded, b...)
+	}
+	return b
+}
@@ -0,0 +1,359 @@
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"strings"
+)
+
+type Type int
+
+const (
+	TypeNull Type = iota
+	TypeBoolean
+	TypeInt
+	TypeLong
+	TypeFloat
+	TypeDouble
+	TypeBytes
+	TypeString
+	TypeRecord
+	TypeEnum
+	TypeArray
+	TypeMap
+	TypeUnion
+	TypeFixed
+)
+
+var primitiveTypes = map[string]Type{
+	"null":    TypeNull,
+	"boolean": TypeBoolean,
+	"int":     TypeInt,
+	"long":    TypeLong,
+	"float":   TypeFloat,
+	"double":  TypeDouble,
+	"bytes":   TypeBytes,
+	"string":  TypeString,
+}
+
+func (t Type) String() string {
+	switch t {
+	case TypeNull:
+		return "null"
+	case TypeBoolean:
+		return "boolean"
+	case TypeInt:
+		return "int"
+	case TypeLong:
+		return "long"
+	case TypeFloat:
+		return "float"
+	case TypeDouble:
+		return "double"
+	case TypeBytes:
+		return "bytes"
+	case TypeString:
+		return "string"
+	case TypeRecord:
+		return "record"
+	case TypeEnum:
+		return "enum"
+	case TypeArray:
+		return "array"
+	case TypeMap:
+		return "map"
+	case TypeUnion:
+		return "union"
+	case TypeFixed:
+		return "fixed"
+	default:
+		panic("unknown avro type")
+	}
+}
+
+const (
+	LogicalTypeDecimal         = "decimal"
+	LogicalTypeDate            = "date"
+	LogicalTypeTimestampMillis = "timestamp-millis"
+	LogicalTypeTimestampMicros = "timestamp-micros"
+)
+
+// Schema is a parsed Avro schema. Named types that are referenced more than once, including recursively, are
+// represented by the same *Schema.
+type Schema struct {
+	Type Type
+	// Name is the full name of a record, enum or fixed
+	Name string
+	// Fields are the fields of a record
+	Fields []*Field
+	// Symbols are the symbols of an enum
+	Symbols []string
+	// Items is the schema of the elements of an array
+	Items *Schema
+	// Values is the schema of the values of a map
+	Values *Schema
+	// Branches are the schemas of a union
+	Branches []*Schema
+	// Size is the size of a fixed
+	Size        int
+	LogicalType string
+	Precision   int
+	Scale       int
+}
+
+type Field struct {
+	Name       string
+	Schema     *Schema
+	HasDefault bool
+	Default    any
+}
+
+// FieldByName returns the field of a record with the given name, and its position
+func (s *Schema) FieldByName(name string) (*Field, int) {
+	for i, f := range s.Fields {
+		if f.Name == name {
+			return f, i
+		}
+	}
+	return nil, -1
+}
+
+// NonNullBranch returns the single branch of a union of null and another type, such as ["null", "string"], which is how
+// Avro represents an optional value. Otherwise, it returns nil.
+func (s *Schema) NonNullBranch() *Schema {
+	if s.Type != TypeUnion || len(s.Branches) != 2 {
+		return nil
+	}
+	if s.Branches[0].Type == TypeNull {
+		return s.Branches[1]
+	}
+	if s.Branches[1].Type == TypeNull {
+		return s.Branches[0]
+	}
+	return nil
+}
+
+func (s *Schema) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if s.LogicalType != "" {
+		return fmt.Sprintf("%s(%s)", s.Type.String(), s.LogicalType)
+	}
+	switch s.Type {
+	case TypeArray:
+		return fmt.Sprintf("array<%s>", s.Items.String())
+	case TypeMap:
+		return fmt.Sprintf("map<%s>", s.Values.String())
+	case TypeUnion:
+		names := make([]string, len(s.Branches))
+		for i, b := range s.Branches {
+			names[i] = b.String()
+		}
+		return fmt.Sprintf("union<%s>", strings.Join(names, ", "))
+	default:
+		return s.Type.String()
+	}
+}
+
+// ParseSchema parses an Avro schema from its JSON representation
+func ParseSchema(schemaJson string) (*Schema, error) {
+	var v any
+	if err := json.Unmarshal([]byte(schemaJson), &v); err != nil {
+		return nil, errors.Errorf("invalid avro schema: %v", err)
+	}
+	p := &schemaParser{named: map[string]*Schema{}}
+	return p.parse(v, "")
+}
+
+type schemaParser struct {
+	named map[string]*Schema
+}
+
+func (p *schemaParser) parse(v any, namespace string) (*Schema, error) {
+	switch sv := v.(type) {
+	case string:
+		return p.parseTypeName(sv, namespace)
+	case []any:
+		union := &Schema{Type: TypeUnion}
+		for _, b := range sv {
+			branch, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if branch.Type == TypeUnion {
+				return nil, errors.New("invalid avro schema: unions cannot directly contain other unions")
+			}
+			union.Branches = append(union.Branches, branch)
+		}
+		if len(union.Branches) == 0 {
+			return nil, errors.New("invalid avro schema: union must have at least one branch")
+		}
+		return union, nil
+	case map[string]any:
+		return p.parseComplex(sv, namespace)
+	default:
+		return nil, errors.Errorf("invalid avro schema: unexpected %v", v)
+	}
+}
+
+func (p *schemaParser) parseTypeName(name string, namespace string) (*Schema, error) {
+	if t, ok := primitiveTypes[name]; ok {
+		return &Schema{Type: t}, nil
+	}
+	if s, ok := p.named[fullName(name, namespace)]; ok {
+		return s, nil
+	}
+	if s, ok := p.named[name]; ok {
+		return s, nil
+	}
+	return nil, errors.Errorf("invalid avro schema: unknown type '%s'", name)
+}
+
+func (p *schemaParser) parseComplex(m map[string]any, namespace string) (*Schema, error) {
+	typeName, ok := m["type"]
+	if !ok {
+		return nil, errors.New("invalid avro schema: missing 'type'")
+	}
+	sTypeName, ok := typeName.(string)
+	if !ok {
+		// The type is itself a schema, e.g. {"type": {"type": "array", "items": "int"}}
+		return p.parse(typeName, namespace)
+	}
+	var schema *Schema
+	switch sTypeName {
+	case "record", "error":
+		schema = &Schema{Type: TypeRecord}
+		if err := p.registerNamed(schema, m, &namespace); err != nil {
+			return nil, err
+		}
+		fields, ok := m["fields"].([]any)
+		if !ok {
+			return nil, errors.Errorf("invalid avro schema: record '%s' must have 'fields'", schema.Name)
+		}
+		for _, f := range fields {
+			fm, ok := f.(map[string]any)
+			if !ok {
+				return nil, errors.Errorf("invalid avro schema: invalid field in record '%s'", schema.Name)
+			}
+			fieldName, ok := fm["name"].(string)
+			if !ok {
+				return nil, errors.Errorf("invalid avro schema: field in record '%s' must have a 'name'", schema.Name)
+			}
+			fieldSchema, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			field := &Field{Name: fieldName, Schema: fieldSchema}
+			field.Default, field.HasDefault = fm["default"]
+			schema.Fields = append(schema.Fields, field)
+		}
+	case "enum":
+		schema = &Schema{Type: TypeEnum}
+		if err := p.registerNamed(schema, m, &namespace); err != nil {
+			return nil, err
+		}
+		symbols, ok := m["symbols"].([]any)
+		if !ok {
+			return nil, errors.Errorf("invalid avro schema: enum '%s' must have 'symbols'", schema.Name)
+		}
+		for _, sym := range symbols {
+			s, ok := sym.(string)
+			if !ok {
+				return nil, errors.Errorf("invalid avro schema: enum '%s' symbols must be strings", schema.Name)
+			}
+			schema.Symbols = append(schema.Symbols, s)
+		}
+	case "fixed":
+		schema = &Schema{Type: TypeFixed}
+		if err := p.registerNamed(schema, m, &namespace); err != nil {
+			return nil, err
+		}
+		size, ok := m["size"].(float64)
+		if !ok || size < 0 {
+			return nil, errors.Errorf("invalid avro schema: fixed '%s' must have a 'size'", schema.Name)
+		}
+		schema.Size = int(size)
+	case "array":
+		items, err := p.parse(m["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		schema = &Schema{Type: TypeArray, Items: items}
+	case "map":
+		values, err := p.parse(m["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		schema = &Schema{Type: TypeMap, Values: values}
+	default:
+		t, ok := primitiveTypes[sTypeName]
+		if !ok {
+			return p.parseTypeName(sTypeName, namespace)
+		}
+		schema = &Schema{Type: t}
+	}
+	return schema, p.parseLogicalType(schema, m)
+}
+
+func (p *schemaParser) registerNamed(schema *Schema, m map[string]any, namespace *string) error {
+	name, ok := m["name"].(string)
+	if !ok || name == "" {
+		return errors.Errorf("invalid avro schema: %s must have a 'name'", schema.Type.String())
+	}
+	if ns, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+		*namespace = ns
+	}
+	schema.Name = fullName(name, *namespace)
+	if lastDot := strings.LastIndex(schema.Name, "."); lastDot != -1 {
+		// Names within a named type are relative to the namespace of the type
+		*namespace = schema.Name[:lastDot]
+	}
+	if _, exists := p.named[schema.Name]; exists {
+		return errors.Errorf("invalid avro schema: type '%s' is defined more than once", schema.Name)
+	}
+	// We register the type before parsing its contents, so it can refer to itself
+	p.named[schema.Name] = schema
+	return nil
+}
+
+func (p *schemaParser) parseLogicalType(schema *Schema, m map[string]any) error {
+	logicalType, ok := m["logicalType"].(string)
+	if !ok {
+		return nil
+	}
+	// As the spec requires, logical types that are unknown, or that annotate the wrong type, are ignored
+	switch logicalType {
+	case LogicalTypeDecimal:
+		if schema.Type != TypeBytes && schema.Type != TypeFixed {
+			return nil
+		}
+		precision, _ := m["precision"].(float64)
+		scale, _ := m["scale"].(float64)
+		if precision < 1 || scale < 0 || scale > precision {
+			return errors.New("invalid avro schema: decimal must have a 'precision' > 0 and a 'scale' <= precision")
+		}
+		schema.Precision = int(precision)
+		schema.Scale = int(scale)
+	case LogicalTypeDate:
+		if schema.Type != TypeInt {
+			return nil
+		}
+	case LogicalTypeTimestampMillis, LogicalTypeTimestampMicros:
+		if schema.Type != TypeLong {
+			return nil
+		}
+	default:
+		return nil
+	}
+	schema.LogicalType = logicalType
+	return nil
+}
+
+func fullName(name string, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/spirit-labs/tektite/common"
 	log "github.com/spirit-labs/tektite/logger"
@@ -149,6 +150,22 @@ func (c *Cli) handleUnregisterWasm(statement string) error {
 	return c.client.UnregisterWasmModule(moduleName)
 }
 
+func (c *Cli) handleRegisterProtobuf(statement string) error {
+	if !strings.HasPrefix(statement, `register_protobuf("`) || !strings.HasSuffix(statement, `")`) {
+		return errors.Errorf(`Invalid register_protobuf command. Must be of form 'register_protobuf("/path/to/my_descriptors.desc")'`)
+	}
+	descriptorSetPath := statement[19 : len(statement)-2]
+	return c.client.RegisterProtobufDescriptors(descriptorSetPath)
+}
+
+func (c *Cli) handleUnregisterProtobuf(statement string) error {
+	if !strings.HasPrefix(statement, `unregister_protobuf("`) || !strings.HasSuffix(statement, `")`) {
+		return errors.Errorf(`Invalid unregister_protobuf command. Must be of form 'unregister_protobuf("my_descriptors")'`)
+	}
+	name := statement[21 : len(statement)-2]
+	return c.client.UnregisterProtobufDescriptors(name)
+}
+
 func (c *Cli) doExecuteStatementWithError(statement string, out chan string) (int, bool, error) {
 	lowerStat := strings.ToLower(statement)
 	if lowerStat == "set" || strings.HasPrefix(lowerStat, "set ") {
@@ -160,6 +177,15 @@ func (c *Cli) doExecuteStatementWithError(statement string, out chan string) (in
 	if strings.HasPrefix(lowerStat, "unregister_wasm(") {
 		return -1, true, c.handleUnregisterWasm(lowerStat)
 	}
+	if strings.HasPrefix(lowerStat, "register_protobuf(") {
+		return -1, true, c.handleRegisterProtobuf(statement)
+	}
+	if strings.HasPrefix(lowerStat, "unregister_protobuf(") {
+		return -1, true, c.handleUnregisterProtobuf(statement)
+	}
+	if lowerStat == "sql" || strings.HasPrefix(lowerStat, "sql ") {
+		return c.handleSQL(strings.TrimSpace(statement[3:]), out)
+	}
 	if strings.HasPrefix(statement, "(") {
 		ch, err := c.client.StreamExecuteQuery(statement)
 		if err != nil {
@@ -212,6 +238,18 @@ func (c *Cli) doExecuteStatementWithError(statement string, out chan string) (in
 	return -1, true, err
 }
 
+// handleSQL executes a SQL statement - these are prefixed with 'sql' in the CLI, e.g. 'sql select * from my_table'
+func (c *Cli) handleSQL(sql string, out chan string) (int, bool, error) {
+	if strings.HasPrefix(strings.ToLower(sql), "prepare") {
+		return -1, true, c.client.ExecuteSQLStatement(sql)
+	}
+	ch, err := c.client.StreamExecuteSQL(sql)
+	if err != nil {
+		return 0, true, err
+	}
+	return c.streamToOut(out, ch, true), true, nil
+}
+
 func (c *Cli) streamToOut(out chan string, ch chan tekclient.StreamChunk, isQuery bool) int {
 	rowCount := 0
 	first := true
@@ -318,6 +356,14 @@ func formatLine(res tekclient.QueryResult, rowIndex int, colWidths []int) (strin
 			case types.ColumnTypeIDTimestamp:
 				ts := row.TimestampVal(i)
 				v = convertUnixMillisToDateString(ts.Val)
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				// nested values are displayed as JSON
+				colType := res.Meta().ColumnTypes()[i]
+				bytes, err := json.Marshal(types.ToJSONValue(colType, row.NestedVal(i)))
+				if err != nil {
+					return "", err
+				}
+				v = string(bytes)
 			default:
 				panic("unexpected type")
 			}
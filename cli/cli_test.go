@@ -8,8 +8,10 @@ import (
 	"github.com/spirit-labs/tektite/command"
 	"github.com/spirit-labs/tektite/conf"
 	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/opers"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/query"
 	"github.com/spirit-labs/tektite/remoting"
 	"github.com/spirit-labs/tektite/tekclient"
 	"github.com/spirit-labs/tektite/testutils"
@@ -143,7 +145,7 @@ func startServer(t *testing.T, serverAddress string, tlsConf conf.TLSConfig) (*a
 	commandMgr := &testCommandManager{}
 	moduleManager := &testWasmModuleManager{}
 	server := api.NewHTTPAPIServer(serverAddress, "/tektite", queryMgr, commandMgr,
-		parser.NewParser(nil), moduleManager, tlsConf)
+		parser.NewParser(nil), moduleManager, &testProtobufDescriptorManager{}, tlsConf)
 	err := server.Activate()
 	require.NoError(t, err)
 	return server, queryMgr, commandMgr, moduleManager
@@ -229,6 +231,25 @@ func testCli(t *testing.T, clientTLSConfig tekclient.TLSConfig, serverTLSConfig
 	require.NoError(t, err)
 	require.Equal(t, stmt, queryMgr.getDirectQueryState())
 
+	batches = createBatches(t, 41, 2, 1)
+	queryMgr.clearBatches()
+	queryMgr.addBatch(batches[0], true)
+
+	stmt = "sql SELECT f0, f4 FROM test_stream WHERE f0 > 1000040"
+	out.WriteString(stmt)
+	out.WriteRune('\n')
+	err = execStatement(stmt, cli, &out)
+	require.NoError(t, err)
+	require.Equal(t, "(scan all from test_stream)->(filter by (f0 > 1000040))->(project f0, f4)", queryMgr.getDirectQueryState())
+
+	stmt = "sql PREPARE test_query (int) AS SELECT * FROM test_stream WHERE f0 = $1"
+	out.WriteString(stmt)
+	out.WriteRune('\n')
+	err = execStatement(stmt, cli, &out)
+	require.NoError(t, err)
+	require.Equal(t, "prepare test_query($p1:int) := (scan all from test_stream)->(filter by (f0 == $p1:int))",
+		commandMgr.getTsl())
+
 	stmt = "delete(test_stream)"
 	out.WriteString(stmt)
 	out.WriteRune('\n')
@@ -320,6 +341,16 @@ OK
 | 1000049              | 49.123450                  | null                       | 49123456789.9876           | foobar-49                  | null                       | 1970-01-01 00:33:20.049000 |
 +----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
 50 rows returned
+sql SELECT f0, f4 FROM test_stream WHERE f0 > 1000040
++----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| f0                   | f1                         | f2                         | f3                         | f4                         | f5                         | f6                         |
++----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| 1000041              | null                       | false                      | 41123456789.9876           | null                       | quux-41                    | 1970-01-01 00:33:20.041000 |
+| null                 | 42.123450                  | true                       | null                       | foobar-42                  | quux-42                    | null                       |
++----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+2 rows returned
+sql PREPARE test_query (int) AS SELECT * FROM test_stream WHERE f0 = $1
+OK
 delete(test_stream)
 OK
 register_wasm("testdata/wasm/test_mod1.wasm")
@@ -373,7 +404,47 @@ func (t *testQueryManager) GetLastFlushedVersion() int {
 	return 0
 }
 
-func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) error {
+func (t *testQueryManager) ExecuteQueryDirectWithArgs(string, parser.PrepareQueryDesc, []any, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithHighestVersion(string, parser.QueryDesc, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectIfChanged(string, parser.QueryDesc, int64, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectByKey(string, parser.QueryDesc, int64, query.Limits) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectForChangedKeys(parser.QueryDesc, int64, int64) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) RegisterCompletedVersionListener(string, func(version int64)) {
+}
+
+func (t *testQueryManager) UnregisterCompletedVersionListener(string) {
+}
+
+func (t *testQueryManager) RunningQueries() []query.RunningQuery {
+	return nil
+}
+
+func (t *testQueryManager) CancelQuery(string) error {
+	return nil
+}
+
+func (t *testQueryManager) CancelRemoteQuery(*clustermsgs.QueryCancelMessage) {
+}
+
+func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, _ query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	t.directQueryTsl = tsl
@@ -437,7 +508,7 @@ func (t *testQueryManager) PrepareQuery(parser.PrepareQueryDesc) error {
 	return nil
 }
 
-func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, _ query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	t.queryName = queryName
@@ -446,12 +517,12 @@ func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, ou
 	return 0, nil
 }
 
-func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) {
+func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) {
 	go func() {
 		t.lock.Lock()
 		defer t.lock.Unlock()
 		for _, info := range t.batches {
-			err := outputFunc(info.last, t.numLast, info.batch)
+			err := outputFunc(info.last, t.numLast, info.batch, nil)
 			if err != nil {
 				panic(err)
 			}
@@ -459,7 +530,7 @@ func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches
 	}()
 }
 
-func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, opers.PageStart, query.Limits, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	return 0, nil
 }
 
@@ -491,6 +562,22 @@ func (t *testQueryManager) GetPreparedQueryParamSchema(string) *evbatch.EventSch
 	return nil
 }
 
+func (t *testQueryManager) GetPreparedQueryLimit(string) int {
+	return 0
+}
+
+func (t *testQueryManager) GetPreparedQueryNextPage(string, opers.PageStart, *evbatch.Batch) *opers.PageStart {
+	return nil
+}
+
+func (t *testQueryManager) GetPreparedQueryResultSchema(string) *evbatch.EventSchema {
+	return nil
+}
+
+func (t *testQueryManager) GetQueryResultSchema(parser.QueryDesc, []parser.PreparedStatementParam) (*evbatch.EventSchema, error) {
+	return nil, nil
+}
+
 type testCommandManager struct {
 	lock sync.Mutex
 	tsl  string
@@ -592,6 +679,17 @@ func (t *testCommandManager) Stop() error {
 	return nil
 }
 
+type testProtobufDescriptorManager struct {
+}
+
+func (t *testProtobufDescriptorManager) RegisterDescriptors(string, []byte) error {
+	return nil
+}
+
+func (t *testProtobufDescriptorManager) UnregisterDescriptors(string) error {
+	return nil
+}
+
 type testWasmModuleManager struct {
 	called atomic.Bool
 }
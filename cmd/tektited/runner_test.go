@@ -75,7 +75,10 @@ func createConfigWithAllFields() conf.Config {
 		ClientType:                     conf.KafkaClientTypeConfluent,
 		ForwardResendDelay:             876 * time.Millisecond,
 
-		QueryMaxBatchRows: 999,
+		QueryMaxBatchRows:   999,
+		QueryTimeout:        3 * time.Minute,
+		QueryMaxRowsScanned: 98765,
+		QueryMaxMemoryBytes: 64 * 1024 * 1024,
 
 		HttpApiPath: "/wibble",
 
@@ -122,8 +125,26 @@ func createConfigWithAllFields() conf.Config {
 		KafkaNewMemberJoinTimeout:   4 * time.Second,
 		KafkaFetchCacheMaxSizeBytes: 7654321,
 
+		PostgresServerEnabled:   true,
+		PostgresServerAddresses: []string{"pg1:5432", "pg2:5432", "pg3:5432", "pg4:5432", "pg5:5432"},
+		PostgresServerTLSConfig: conf.TLSConfig{
+			Enabled:  true,
+			KeyPath:  "pg-key-path",
+			CertPath: "pg-cert-path",
+		},
+
 		CommandCompactionInterval: 3 * time.Second,
 
+		SchemaRegistryType:          "confluent",
+		SchemaRegistryURL:           "http://registry:8081",
+		SchemaRegistryUsername:      "reg-user",
+		SchemaRegistryPassword:      "reg-password",
+		SchemaRegistryCacheTTL:      90 * time.Second,
+		SchemaRegistryListenAddress: "localhost:8082",
+
+		LookupCacheMaxSize: 2345,
+		LookupCacheTTL:     3 * time.Second,
+
 		DDProfilerTypes:           "HEAP,CPU",
 		DDProfilerServiceName:     "my-service",
 		DDProfilerEnvironmentName: "playing",
@@ -164,6 +185,7 @@ func createConfigWithAllFields() conf.Config {
 
 		VersionCompletedBroadcastInterval:  2 * time.Second,
 		VersionManagerStoreFlushedInterval: 23 * time.Second,
+		VersionTimeIndexInterval:           7 * time.Second,
 
 		WasmModuleInstances: 23,
 	}
@@ -21,6 +21,9 @@ client-type = 1
 forward-resend-delay = "876ms"
 
 query-max-batch-rows = 999
+query-timeout = "3m"
+query-max-rows-scanned = 98765
+query-max-memory-bytes = "67108864"
 
 http-api-path = "/wibble"
 
@@ -115,6 +118,28 @@ kafka-max-session-timeout = "25s"
 kafka-new-member-join-timeout = "4s"
 kafka-fetch-cache-max-size-bytes = "7654321"
 
+postgres-server-enabled       = true
+postgres-server-addresses  = [
+  "pg1:5432",
+  "pg2:5432",
+  "pg3:5432",
+  "pg4:5432",
+  "pg5:5432"
+]
+postgres-server-tls-enabled       = true
+postgres-server-tls-key-path      = "pg-key-path"
+postgres-server-tls-cert-path     = "pg-cert-path"
+
+schema-registry-type = "confluent"
+schema-registry-url = "http://registry:8081"
+schema-registry-username = "reg-user"
+schema-registry-password = "reg-password"
+schema-registry-cache-ttl = "90s"
+schema-registry-listen-address = "localhost:8082"
+
+lookup-cache-max-size = 2345
+lookup-cache-ttl = "3s"
+
 dd-profiler-types                 = "HEAP,CPU"
 dd-profiler-service-name          = "my-service"
 dd-profiler-environment-name      = "playing"
@@ -133,5 +158,6 @@ log-level = "debug"
 
 version-completed-broadcast-interval = "2s"
 version-manager-store-flushed-interval = "23s"
+version-time-index-interval = "7s"
 
 wasm-module-instances = 23
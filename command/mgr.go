@@ -243,8 +243,13 @@ func (m *manager) processCommands(batch *evbatch.Batch) (int64, error) {
 
 func (m *manager) executeQuerySingleResultBatch(queryName string, args []any) (*evbatch.Batch, error) {
 	ch := make(chan *evbatch.Batch, 1)
-	_, err := m.queryManager.ExecutePreparedQueryWithHighestVersion(queryName, args, math.MaxInt64,
-		func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	errCh := make(chan error, 1)
+	_, err := m.queryManager.ExecutePreparedQueryWithHighestVersion(queryName, args, math.MaxInt64, opers.PageStart{}, query.NoLimits,
+		func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+			if err != nil {
+				errCh <- err
+				return nil
+			}
 			if numLastBatches != 1 {
 				panic("sys query must have 1 partition")
 			}
@@ -257,7 +262,12 @@ func (m *manager) executeQuerySingleResultBatch(queryName string, args []any) (*
 	if err != nil {
 		return nil, err
 	}
-	return <-ch, nil
+	select {
+	case batch := <-ch:
+		return batch, nil
+	case err := <-errCh:
+		return nil, err
+	}
 }
 
 func (m *manager) LastProcessedCommandID() int64 {
@@ -476,6 +486,10 @@ func calcSequencesCountForStream(cp *parser.CreateStreamDesc) (receiverCount int
 			slabCount++ // dedup slab
 		case *parser.BackfillDesc:
 			receiverCount++
+		case *parser.DedupDesc:
+			slabCount++ // seen keys slab
+		case *parser.TopNDesc:
+			slabCount++ // ranked rows slab
 		case *parser.AggregateDesc:
 			slabCount += 3
 			receiverCount++
@@ -493,6 +507,9 @@ func calcSequencesCountForStream(cp *parser.CreateStreamDesc) (receiverCount int
 			receiverCount++
 		}
 	}
+	if cp.OnError == parser.OnErrorDeadLetter {
+		slabCount += 2 // dead-letter topic and its offsets slab
+	}
 	return
 }
 
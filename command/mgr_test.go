@@ -430,7 +430,7 @@ func setUpManager(signaller Signaller, remoting *testRemoting, st *store2.Store,
 	parser := parser2.NewParser(nil)
 
 	qMgr := query.NewManager(npp, &tppm.TestClustVersionProvider{ClustVersion: 1234}, cfg.NodeID, pMgr, st, st,
-		remoting, addresses, 100, &expr.ExpressionFactory{}, parser)
+		remoting, addresses, 100, query.Limits{}, &expr.ExpressionFactory{}, parser, nil)
 	// Set the last completed versions to be less than the write version. Normally this would make the written commands
 	// invisible. However, when reading commands we execute the query with highest version = 0 so we should see them
 	// immediately. This is important so when a command is written from one node it is visible straight away from another
@@ -577,6 +577,20 @@ func (t *testRemoting) SendQueryResponse(msg *clustermsgs.QueryResponse, serverA
 	return nil
 }
 
+func (t *testRemoting) SendQueryCancel(msg *clustermsgs.QueryCancelMessage, serverAddress string) error {
+	mgr, ok := t.mgrsMap[serverAddress]
+	if !ok {
+		panic("can't find manager")
+	}
+	mgr.CancelRemoteQuery(msg)
+	return nil
+}
+
+func (t *testRemoting) SendQueryChangedMessageAsync(func(remoting.ClusterMessage, error),
+	*clustermsgs.QueryChangedMessage, string) {
+	panic("not implemented")
+}
+
 func (t *testRemoting) Close() {
 }
 
@@ -647,3 +661,6 @@ type dummyPrefixRetention struct {
 
 func (d *dummyPrefixRetention) AddPrefixRetention(retention.PrefixRetention) {
 }
+
+func (d *dummyPrefixRetention) AddVersionRetention(retention.PrefixRetention) {
+}
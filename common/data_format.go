@@ -10,4 +10,6 @@ type MetadataFormat byte
 
 const (
 	MetadataFormatV1 MetadataFormat = 1
+	// MetadataFormatV2 adds the version retentions and version times to the master record
+	MetadataFormatV2 MetadataFormat = 2
 )
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Murmur3Hash32 computes the 32 bit x86 variant of the MurmurHash3 hash of the data with the provided seed.
+func Murmur3Hash32(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h := seed
+	length := len(data)
+
+	// Mix 4 bytes at a time into the hash
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+		data = data[4:]
+	}
+
+	// Handle the last few bytes of the input array
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	// Finalization mix - force all bits of the hash to avalanche
+	h ^= uint32(length)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMurmur3Hash32(t *testing.T) {
+	testCases := []struct {
+		data     []byte
+		seed     uint32
+		expected uint32
+	}{
+		{data: []byte{}, seed: 0, expected: 0},
+		{data: []byte{}, seed: 1, expected: 0x514e28b7},
+		{data: []byte{}, seed: 0xffffffff, expected: 0x81f16f39},
+		{data: []byte{0xff, 0xff, 0xff, 0xff}, seed: 0, expected: 0x76293b50},
+		{data: []byte{0x21, 0x43, 0x65, 0x87}, seed: 0, expected: 0xf55b516b},
+		{data: []byte{0x21, 0x43, 0x65, 0x87}, seed: 0x5082edee, expected: 0x2362f9de},
+		{data: []byte{0x21, 0x43, 0x65}, seed: 0, expected: 0x7e4a8634},
+		{data: []byte{0x21, 0x43}, seed: 0, expected: 0xa0f7b07a},
+		{data: []byte{0x21}, seed: 0, expected: 0x72661cf4},
+		{data: []byte("Hello, world!"), seed: 0x9747b28c, expected: 0x24884cba},
+		{data: []byte("aaaa"), seed: 0x9747b28c, expected: 0x5a97808a},
+		{data: []byte("abc"), seed: 0x9747b28c, expected: 0xc84a62dd},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, Murmur3Hash32(tc.data, tc.seed), "data %v seed %d", tc.data, tc.seed)
+	}
+}
@@ -23,6 +23,9 @@ const (
 	DefaultBatchFlushCheckInterval        = 1 * time.Second
 	DefaultConsumerRetryInterval          = 2 * time.Second
 	DefaultQueryMaxBatchRows              = 1000
+	DefaultQueryTimeout                   = 5 * time.Minute
+	DefaultQueryMaxRowsScanned            = 100000000
+	DefaultQueryMaxMemoryBytes            = 256 * 1024 * 1024
 	DefaultMaxBackfillBatchSize           = 1000
 	DefaultForwardResendDelay             = 250 * time.Millisecond
 
@@ -31,7 +34,7 @@ const (
 	DefaultLevelManagerFlushInterval      = 5 * time.Second
 	DefaultMasterRecordRegistryID         = "tektite_master"
 	DefaultMaxRegistrySegmentTableEntries = 50000
-	DefaultRegistryFormat                 = common.MetadataFormatV1
+	DefaultRegistryFormat                 = common.MetadataFormatV2
 	DefaultSegmentCacheMaxSize            = 100
 	DefaultClusterName                    = "tektite_cluster"
 	DefaultLevelManagerRetryDelay         = 250 * time.Millisecond
@@ -67,6 +70,7 @@ const (
 
 	DefaultVersionCompletedBroadcastInterval  = 500 * time.Millisecond
 	DefaultVersionManagerStoreFlushedInterval = 1 * time.Second
+	DefaultVersionTimeIndexInterval           = 5 * time.Second
 
 	DefaultDevObjectStoreAddress = "127.0.0.1:6690"
 
@@ -87,6 +91,14 @@ const (
 	MinioObjectStoreType    = "minio"
 
 	DefaultWasmModuleInstances = 8
+
+	DefaultSchemaRegistryCacheTTL = 1 * time.Minute
+
+	DefaultLookupCacheMaxSize = 10000
+	DefaultLookupCacheTTL     = 5 * time.Second
+
+	ConfluentSchemaRegistryType = "confluent"
+	EmbeddedSchemaRegistryType  = "embedded"
 )
 
 var DefaultClusterManagerAddresses = []string{"localhost:2379"}
@@ -176,6 +188,13 @@ type Config struct {
 
 	// query manager config
 	QueryMaxBatchRows int
+	// QueryTimeout, QueryMaxRowsScanned and QueryMaxMemoryBytes are the limits applied to a query, unless the request
+	// executing the query sets its own. A negative value means there is no limit. QueryMaxRowsScanned applies to the
+	// rows scanned on all the nodes, and QueryMaxMemoryBytes to the results held in memory while they are sorted, the
+	// groups of an aggregation and the rows of the tables of broadcast joins.
+	QueryTimeout        time.Duration
+	QueryMaxRowsScanned int
+	QueryMaxMemoryBytes parseableInt
 
 	// Http-API config
 	HttpApiEnabled   bool      `name:"http-api-enabled"`
@@ -200,6 +219,11 @@ type Config struct {
 	KafkaNewMemberJoinTimeout   time.Duration
 	KafkaFetchCacheMaxSizeBytes parseableInt
 
+	// PostgreSQL wire protocol config
+	PostgresServerEnabled   bool      `name:"postgres-server-enabled"`
+	PostgresServerAddresses []string  `name:"postgres-server-addresses"`
+	PostgresServerTLSConfig TLSConfig `embed:"" prefix:"postgres-server-tls-"`
+
 	LifeCycleEndpointEnabled bool
 	LifeCycleAddress         string
 	StartupEndpointPath      string
@@ -211,10 +235,29 @@ type Config struct {
 	// Version manager config
 	VersionCompletedBroadcastInterval  time.Duration
 	VersionManagerStoreFlushedInterval time.Duration
+	// VersionTimeIndexInterval is how often the time at which versions completed is recorded, which determines how
+	// precisely a time-travel query can choose the data it sees
+	VersionTimeIndexInterval time.Duration
 
 	// Wasm module manager config
 	WasmModuleInstances int
 
+	// Schema registry config. SchemaRegistryType is empty if there is no schema registry, "confluent" for a registry
+	// that implements the Confluent schema registry REST API at SchemaRegistryURL, or "embedded" for an in-memory
+	// registry for local development, which serves the same API on SchemaRegistryListenAddress if it is set.
+	// SchemaRegistryCacheTTL is how long the latest schema of a subject is cached for.
+	SchemaRegistryType          string
+	SchemaRegistryURL           string `name:"schema-registry-url"`
+	SchemaRegistryUsername      string
+	SchemaRegistryPassword      string
+	SchemaRegistryCacheTTL      time.Duration `name:"schema-registry-cache-ttl"`
+	SchemaRegistryListenAddress string
+
+	// Table lookup config. Each lookup expression caches up to LookupCacheMaxSize values read from the table for each
+	// processor, for up to LookupCacheTTL, which is how stale the value returned by a lookup can be.
+	LookupCacheMaxSize int           `name:"lookup-cache-max-size"`
+	LookupCacheTTL     time.Duration `name:"lookup-cache-ttl"`
+
 	// Datadog profiling
 	DDProfilerTypes           string
 	DDProfilerHostEnvVarName  string
@@ -427,6 +470,15 @@ func (c *Config) ApplyDefaults() {
 	if c.QueryMaxBatchRows == 0 {
 		c.QueryMaxBatchRows = DefaultQueryMaxBatchRows
 	}
+	if c.QueryTimeout == 0 {
+		c.QueryTimeout = DefaultQueryTimeout
+	}
+	if c.QueryMaxRowsScanned == 0 {
+		c.QueryMaxRowsScanned = DefaultQueryMaxRowsScanned
+	}
+	if c.QueryMaxMemoryBytes == 0 {
+		c.QueryMaxMemoryBytes = DefaultQueryMaxMemoryBytes
+	}
 
 	if c.VersionCompletedBroadcastInterval == 0 {
 		c.VersionCompletedBroadcastInterval = DefaultVersionCompletedBroadcastInterval
@@ -434,6 +486,9 @@ func (c *Config) ApplyDefaults() {
 	if c.VersionManagerStoreFlushedInterval == 0 {
 		c.VersionManagerStoreFlushedInterval = DefaultVersionManagerStoreFlushedInterval
 	}
+	if c.VersionTimeIndexInterval == 0 {
+		c.VersionTimeIndexInterval = DefaultVersionTimeIndexInterval
+	}
 
 	if c.ClientType == 0 {
 		c.ClientType = KafkaClientTypeConfluent
@@ -470,6 +525,18 @@ func (c *Config) ApplyDefaults() {
 	if c.WasmModuleInstances == 0 {
 		c.WasmModuleInstances = DefaultWasmModuleInstances
 	}
+
+	if c.SchemaRegistryCacheTTL == 0 {
+		c.SchemaRegistryCacheTTL = DefaultSchemaRegistryCacheTTL
+	}
+
+	if c.LookupCacheMaxSize == 0 {
+		c.LookupCacheMaxSize = DefaultLookupCacheMaxSize
+	}
+
+	if c.LookupCacheTTL == 0 {
+		c.LookupCacheTTL = DefaultLookupCacheTTL
+	}
 }
 
 func (c *Config) Validate() error { //nolint:gocyclo
@@ -496,6 +563,38 @@ func (c *Config) Validate() error { //nolint:gocyclo
 			return errors.NewInvalidConfigurationError("http-api-tls-client-certs-path must be provided if client auth is enabled")
 		}
 	}
+	if c.PostgresServerEnabled {
+		if len(c.PostgresServerAddresses) == 0 {
+			return errors.NewInvalidConfigurationError("postgres-server-addresses must be specified")
+		}
+		if c.PostgresServerTLSConfig.Enabled {
+			if c.PostgresServerTLSConfig.CertPath == "" {
+				return errors.NewInvalidConfigurationError("postgres-server-tls-cert-path must be specified if postgres-server-tls-enabled is true")
+			}
+			if c.PostgresServerTLSConfig.KeyPath == "" {
+				return errors.NewInvalidConfigurationError("postgres-server-tls-key-path must be specified if postgres-server-tls-enabled is true")
+			}
+		}
+	}
+	switch c.SchemaRegistryType {
+	case "":
+	case ConfluentSchemaRegistryType:
+		if c.SchemaRegistryURL == "" {
+			return errors.NewInvalidConfigurationError("schema-registry-url must be specified if schema-registry-type is confluent")
+		}
+	case EmbeddedSchemaRegistryType:
+		if len(c.ClusterAddresses) > 1 {
+			return errors.NewInvalidConfigurationError("schema-registry-type embedded can only be used with a single node")
+		}
+	default:
+		return errors.NewInvalidConfigurationError("schema-registry-type must be one of confluent, embedded")
+	}
+	if c.LookupCacheMaxSize < 1 {
+		return errors.NewInvalidConfigurationError("lookup-cache-max-size must be > 0")
+	}
+	if c.LookupCacheTTL < 1*time.Millisecond {
+		return errors.NewInvalidConfigurationError("lookup-cache-ttl must be >= 1ms")
+	}
 	if c.AdminConsoleEnabled {
 		if len(c.AdminConsoleAddresses) == 0 {
 			return errors.NewInvalidConfigurationError("admin-console-addresses must be specified")
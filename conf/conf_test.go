@@ -4,6 +4,7 @@ import (
 	"github.com/spirit-labs/tektite/errors"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 type configPair struct {
@@ -120,6 +121,47 @@ func httpAPIServerNoClientCerts() Config {
 	return cnf
 }
 
+func invalidPostgresServerListenAddress() Config {
+	cnf := validConf()
+	cnf.PostgresServerEnabled = true
+	cnf.PostgresServerAddresses = nil
+	return cnf
+}
+
+func postgresServerTLSKeyPathNotSpecifiedConfig() Config {
+	cnf := validConf()
+	cnf.PostgresServerEnabled = true
+	cnf.PostgresServerAddresses = []string{"addr13", "addr14", "addr15"}
+	cnf.PostgresServerTLSConfig = TLSConfig{Enabled: true, CertPath: "pg_cert_path"}
+	return cnf
+}
+
+func postgresServerTLSCertPathNotSpecifiedConfig() Config {
+	cnf := validConf()
+	cnf.PostgresServerEnabled = true
+	cnf.PostgresServerAddresses = []string{"addr13", "addr14", "addr15"}
+	cnf.PostgresServerTLSConfig = TLSConfig{Enabled: true, KeyPath: "pg_key_path"}
+	return cnf
+}
+
+func confluentSchemaRegistryNoURLConfig() Config {
+	cnf := validConf()
+	cnf.SchemaRegistryType = ConfluentSchemaRegistryType
+	return cnf
+}
+
+func embeddedSchemaRegistryMultipleNodesConfig() Config {
+	cnf := validConf()
+	cnf.SchemaRegistryType = EmbeddedSchemaRegistryType
+	return cnf
+}
+
+func invalidSchemaRegistryTypeConfig() Config {
+	cnf := validConf()
+	cnf.SchemaRegistryType = "foo"
+	return cnf
+}
+
 func intraClusterTLSCertPathNotSpecifiedConfig() Config {
 	cnf := validConf()
 	cnf.ClusterTlsConfig.CertPath = ""
@@ -191,6 +233,18 @@ func invalidSegmentCacheMaxSize() Config {
 	return cnf
 }
 
+func invalidLookupCacheMaxSizeConf() Config {
+	cnf := validConf()
+	cnf.LookupCacheMaxSize = -1
+	return cnf
+}
+
+func invalidLookupCacheTTLConf() Config {
+	cnf := validConf()
+	cnf.LookupCacheTTL = -1 * time.Second
+	return cnf
+}
+
 func invalidLevelManagerFlushInterval() Config {
 	cnf := validConf()
 	cnf.LevelManagerFlushInterval = 0
@@ -236,6 +290,17 @@ var invalidConfigs = []configPair{
 	{"invalid configuration: http-api-tls-enabled must be true if http-api-enabled is true", httpAPIServerTLSNotEnabled()},
 	{"invalid configuration: http-api-tls-client-certs-path must be provided if client auth is enabled", httpAPIServerNoClientCerts()},
 
+	{"invalid configuration: postgres-server-addresses must be specified", invalidPostgresServerListenAddress()},
+	{"invalid configuration: postgres-server-tls-key-path must be specified if postgres-server-tls-enabled is true", postgresServerTLSKeyPathNotSpecifiedConfig()},
+	{"invalid configuration: postgres-server-tls-cert-path must be specified if postgres-server-tls-enabled is true", postgresServerTLSCertPathNotSpecifiedConfig()},
+
+	{"invalid configuration: schema-registry-url must be specified if schema-registry-type is confluent", confluentSchemaRegistryNoURLConfig()},
+	{"invalid configuration: schema-registry-type embedded can only be used with a single node", embeddedSchemaRegistryMultipleNodesConfig()},
+	{"invalid configuration: schema-registry-type must be one of confluent, embedded", invalidSchemaRegistryTypeConfig()},
+
+	{"invalid configuration: lookup-cache-max-size must be > 0", invalidLookupCacheMaxSizeConf()},
+	{"invalid configuration: lookup-cache-ttl must be >= 1ms", invalidLookupCacheTTLConf()},
+
 	{"invalid configuration: cluster-tls-key-path must be specified if cluster-tls-enabled is true", intraClusterTLSKeyPathNotSpecifiedConfig()},
 	{"invalid configuration: cluster-tls-cert-path must be specified if cluster-tls-enabled is true", intraClusterTLSCertPathNotSpecifiedConfig()},
 	{"invalid configuration: cluster-tls-client-certs-path must be specified if cluster-tls-enabled is true", intraClusterTLSCAPathNotSpecifiedConfig()},
@@ -53,6 +53,12 @@ func DecodeKeyToSlice(buffer []byte, offset int, columnTypes []types.ColumnType)
 			}
 		case types.ColumnTypeIDTimestamp:
 			key[i], offset = KeyDecodeTimestamp(buffer, offset)
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			var err error
+			key[i], offset, err = KeyDecodeNested(buffer, offset, keyColType)
+			if err != nil {
+				return nil, 0, err
+			}
 		default:
 			panic("unknown type")
 		}
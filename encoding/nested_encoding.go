@@ -0,0 +1,388 @@
+package encoding
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/types"
+	"sort"
+)
+
+var errInsufficientBytes = errors.New("insufficient bytes to decode value")
+
+/*
+EncodeNestedValue encodes an array, map or struct value. Elements are encoded in the same way as row columns - a null
+byte followed, if not null, by the value:
+
+	array  - uint32 element count, then the elements
+	map    - uint32 entry count, then for each entry in key order the key as a string followed by the value
+	struct - the fields in declaration order
+*/
+func EncodeNestedValue(buffer []byte, colType types.ColumnType, val any) []byte {
+	switch colType.ID() {
+	case types.ColumnTypeIDArray:
+		elemType := colType.(*types.ArrayType).ElemType
+		arr := val.([]any)
+		buffer = AppendUint32ToBufferLE(buffer, uint32(len(arr)))
+		for _, elem := range arr {
+			buffer = appendNestedElement(buffer, elemType, elem)
+		}
+	case types.ColumnTypeIDMap:
+		valueType := colType.(*types.MapType).ValueType
+		m := val.(map[string]any)
+		buffer = AppendUint32ToBufferLE(buffer, uint32(len(m)))
+		for _, k := range SortedMapKeys(m) {
+			buffer = AppendStringToBufferLE(buffer, k)
+			buffer = appendNestedElement(buffer, valueType, m[k])
+		}
+	case types.ColumnTypeIDStruct:
+		structType := colType.(*types.StructType)
+		fields := val.([]any)
+		for i, fieldType := range structType.FieldTypes {
+			buffer = appendNestedElement(buffer, fieldType, fields[i])
+		}
+	default:
+		panic(fmt.Sprintf("not a nested column type %d", colType.ID()))
+	}
+	return buffer
+}
+
+func appendNestedElement(buffer []byte, colType types.ColumnType, val any) []byte {
+	if val == nil {
+		return append(buffer, 0)
+	}
+	buffer = append(buffer, 1)
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		buffer = AppendUint64ToBufferLE(buffer, uint64(val.(int64)))
+	case types.ColumnTypeIDFloat:
+		buffer = AppendFloat64ToBufferLE(buffer, val.(float64))
+	case types.ColumnTypeIDBool:
+		buffer = AppendBoolToBuffer(buffer, val.(bool))
+	case types.ColumnTypeIDDecimal:
+		buffer = AppendDecimalToBuffer(buffer, val.(types.Decimal))
+	case types.ColumnTypeIDString:
+		buffer = AppendStringToBufferLE(buffer, val.(string))
+	case types.ColumnTypeIDBytes:
+		buffer = AppendBytesToBufferLE(buffer, val.([]byte))
+	case types.ColumnTypeIDTimestamp:
+		buffer = AppendUint64ToBufferLE(buffer, uint64(val.(types.Timestamp).Val))
+	default:
+		buffer = EncodeNestedValue(buffer, colType, val)
+	}
+	return buffer
+}
+
+// DecodeNestedValue decodes a value encoded with EncodeNestedValue.
+func DecodeNestedValue(buffer []byte, offset int, colType types.ColumnType) (any, int, error) {
+	var err error
+	switch colType.ID() {
+	case types.ColumnTypeIDArray:
+		elemType := colType.(*types.ArrayType).ElemType
+		var l int
+		l, offset, err = readNestedLength(buffer, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr := make([]any, l)
+		for i := range arr {
+			arr[i], offset, err = readNestedElement(buffer, offset, elemType)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return arr, offset, nil
+	case types.ColumnTypeIDMap:
+		valueType := colType.(*types.MapType).ValueType
+		var l int
+		l, offset, err = readNestedLength(buffer, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		m := make(map[string]any, l)
+		for i := 0; i < l; i++ {
+			var b []byte
+			b, offset, err = readNestedBytes(buffer, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[string(b)], offset, err = readNestedElement(buffer, offset, valueType)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case types.ColumnTypeIDStruct:
+		structType := colType.(*types.StructType)
+		fields := make([]any, len(structType.FieldTypes))
+		for i, fieldType := range structType.FieldTypes {
+			fields[i], offset, err = readNestedElement(buffer, offset, fieldType)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return fields, offset, nil
+	default:
+		panic(fmt.Sprintf("not a nested column type %d", colType.ID()))
+	}
+}
+
+// readNestedLength reads the number of elements of an array or map. Each element takes at least one byte, so a length
+// greater than the remaining bytes is invalid.
+func readNestedLength(buffer []byte, offset int) (int, int, error) {
+	if offset+4 > len(buffer) {
+		return 0, 0, errInsufficientBytes
+	}
+	lu, offset := ReadUint32FromBufferLE(buffer, offset)
+	l := int(lu)
+	if l > len(buffer)-offset {
+		return 0, 0, errInsufficientBytes
+	}
+	return l, offset, nil
+}
+
+func readNestedBytes(buffer []byte, offset int) ([]byte, int, error) {
+	if offset+4 > len(buffer) {
+		return nil, 0, errInsufficientBytes
+	}
+	lu, offset := ReadUint32FromBufferLE(buffer, offset)
+	l := int(lu)
+	if l > len(buffer)-offset {
+		return nil, 0, errInsufficientBytes
+	}
+	return buffer[offset : offset+l], offset + l, nil
+}
+
+func readNestedElement(buffer []byte, offset int, colType types.ColumnType) (any, int, error) {
+	if offset >= len(buffer) {
+		return nil, 0, errInsufficientBytes
+	}
+	if buffer[offset] == 0 {
+		return nil, offset + 1, nil
+	}
+	offset++
+	var size int
+	switch colType.ID() {
+	case types.ColumnTypeIDInt, types.ColumnTypeIDFloat, types.ColumnTypeIDTimestamp:
+		size = 8
+	case types.ColumnTypeIDBool:
+		size = 1
+	case types.ColumnTypeIDDecimal:
+		size = 16
+	}
+	if offset+size > len(buffer) {
+		return nil, 0, errInsufficientBytes
+	}
+	var val any
+	var err error
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		var u uint64
+		u, offset = ReadUint64FromBufferLE(buffer, offset)
+		val = int64(u)
+	case types.ColumnTypeIDFloat:
+		val, offset = ReadFloat64FromBufferLE(buffer, offset)
+	case types.ColumnTypeIDBool:
+		val, offset = ReadBoolFromBuffer(buffer, offset)
+	case types.ColumnTypeIDDecimal:
+		decType := colType.(*types.DecimalType)
+		var dec types.Decimal
+		dec, offset = ReadDecimalFromBuffer(buffer, offset)
+		dec.Precision = decType.Precision
+		dec.Scale = decType.Scale
+		val = dec
+	case types.ColumnTypeIDString:
+		// Copy the string, the nested value can outlive the buffer
+		var b []byte
+		b, offset, err = readNestedBytes(buffer, offset)
+		val = string(b)
+	case types.ColumnTypeIDBytes:
+		var b []byte
+		b, offset, err = readNestedBytes(buffer, offset)
+		val = append([]byte{}, b...)
+	case types.ColumnTypeIDTimestamp:
+		var u uint64
+		u, offset = ReadUint64FromBufferLE(buffer, offset)
+		val = types.NewTimestamp(int64(u))
+	default:
+		val, offset, err = DecodeNestedValue(buffer, offset, colType)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return val, offset, nil
+}
+
+/*
+KeyEncodeNested encodes an array, map or struct value so that encoded values compare byte-wise in the same order as
+the values. Each element is encoded as a null byte followed, if not null, by the key encoding of the element:
+
+	array  - for each element a continuation byte of 1 followed by the element, then a terminating byte of 0, so an
+	         array sorts before any longer array that it is a prefix of
+	map    - as an array of key, value entries in key order
+	struct - the fields in declaration order
+*/
+func KeyEncodeNested(buffer []byte, colType types.ColumnType, val any) []byte {
+	switch colType.ID() {
+	case types.ColumnTypeIDArray:
+		elemType := colType.(*types.ArrayType).ElemType
+		for _, elem := range val.([]any) {
+			buffer = append(buffer, 1)
+			buffer = keyEncodeNestedElement(buffer, elemType, elem)
+		}
+		buffer = append(buffer, 0)
+	case types.ColumnTypeIDMap:
+		valueType := colType.(*types.MapType).ValueType
+		m := val.(map[string]any)
+		for _, k := range SortedMapKeys(m) {
+			buffer = append(buffer, 1)
+			buffer = KeyEncodeString(buffer, k)
+			buffer = keyEncodeNestedElement(buffer, valueType, m[k])
+		}
+		buffer = append(buffer, 0)
+	case types.ColumnTypeIDStruct:
+		structType := colType.(*types.StructType)
+		fields := val.([]any)
+		for i, fieldType := range structType.FieldTypes {
+			buffer = keyEncodeNestedElement(buffer, fieldType, fields[i])
+		}
+	default:
+		panic(fmt.Sprintf("not a nested column type %d", colType.ID()))
+	}
+	return buffer
+}
+
+func keyEncodeNestedElement(buffer []byte, colType types.ColumnType, val any) []byte {
+	if val == nil {
+		return append(buffer, 0)
+	}
+	buffer = append(buffer, 1)
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		buffer = KeyEncodeInt(buffer, val.(int64))
+	case types.ColumnTypeIDFloat:
+		buffer = KeyEncodeFloat(buffer, val.(float64))
+	case types.ColumnTypeIDBool:
+		buffer = AppendBoolToBuffer(buffer, val.(bool))
+	case types.ColumnTypeIDDecimal:
+		buffer = KeyEncodeDecimal(buffer, val.(types.Decimal))
+	case types.ColumnTypeIDString:
+		buffer = KeyEncodeString(buffer, val.(string))
+	case types.ColumnTypeIDBytes:
+		buffer = KeyEncodeBytes(buffer, val.([]byte))
+	case types.ColumnTypeIDTimestamp:
+		buffer = KeyEncodeTimestamp(buffer, val.(types.Timestamp))
+	default:
+		buffer = KeyEncodeNested(buffer, colType, val)
+	}
+	return buffer
+}
+
+// KeyDecodeNested decodes a value encoded with KeyEncodeNested.
+func KeyDecodeNested(buffer []byte, offset int, colType types.ColumnType) (any, int, error) {
+	var err error
+	switch colType.ID() {
+	case types.ColumnTypeIDArray:
+		elemType := colType.(*types.ArrayType).ElemType
+		arr := make([]any, 0)
+		for {
+			if offset >= len(buffer) {
+				return nil, 0, errInsufficientBytes
+			}
+			more := buffer[offset] == 1
+			offset++
+			if !more {
+				return arr, offset, nil
+			}
+			var elem any
+			elem, offset, err = keyDecodeNestedElement(buffer, offset, elemType)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, elem)
+		}
+	case types.ColumnTypeIDMap:
+		valueType := colType.(*types.MapType).ValueType
+		m := map[string]any{}
+		for {
+			if offset >= len(buffer) {
+				return nil, 0, errInsufficientBytes
+			}
+			more := buffer[offset] == 1
+			offset++
+			if !more {
+				return m, offset, nil
+			}
+			var k string
+			k, offset, err = KeyDecodeString(buffer, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k], offset, err = keyDecodeNestedElement(buffer, offset, valueType)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	case types.ColumnTypeIDStruct:
+		structType := colType.(*types.StructType)
+		fields := make([]any, len(structType.FieldTypes))
+		for i, fieldType := range structType.FieldTypes {
+			fields[i], offset, err = keyDecodeNestedElement(buffer, offset, fieldType)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return fields, offset, nil
+	default:
+		panic(fmt.Sprintf("not a nested column type %d", colType.ID()))
+	}
+}
+
+func keyDecodeNestedElement(buffer []byte, offset int, colType types.ColumnType) (any, int, error) {
+	if offset >= len(buffer) {
+		return nil, 0, errInsufficientBytes
+	}
+	isNull := buffer[offset] == 0
+	offset++
+	if isNull {
+		return nil, offset, nil
+	}
+	var val any
+	var err error
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		val, offset = KeyDecodeInt(buffer, offset)
+	case types.ColumnTypeIDFloat:
+		val, offset = KeyDecodeFloat(buffer, offset)
+	case types.ColumnTypeIDBool:
+		val, offset = DecodeBool(buffer, offset)
+	case types.ColumnTypeIDDecimal:
+		decType := colType.(*types.DecimalType)
+		var dec types.Decimal
+		dec, offset = KeyDecodeDecimal(buffer, offset)
+		dec.Precision = decType.Precision
+		dec.Scale = decType.Scale
+		val = dec
+	case types.ColumnTypeIDString:
+		val, offset, err = KeyDecodeString(buffer, offset)
+	case types.ColumnTypeIDBytes:
+		val, offset, err = KeyDecodeBytes(buffer, offset)
+	case types.ColumnTypeIDTimestamp:
+		val, offset = KeyDecodeTimestamp(buffer, offset)
+	default:
+		val, offset, err = KeyDecodeNested(buffer, offset, colType)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return val, offset, nil
+}
+
+// SortedMapKeys returns the keys of the map in ascending order.
+func SortedMapKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
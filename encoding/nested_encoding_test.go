@@ -0,0 +1,172 @@
+package encoding
+
+import (
+	"github.com/apache/arrow/go/v11/arrow/decimal128"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+var nestedTestStructType = &types.StructType{
+	FieldNames: []string{"i", "f", "b", "d", "s", "by", "ts", "arr", "m"},
+	FieldTypes: []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat, types.ColumnTypeBool,
+		&types.DecimalType{Precision: 10, Scale: 2}, types.ColumnTypeString, types.ColumnTypeBytes,
+		types.ColumnTypeTimestamp, &types.ArrayType{ElemType: types.ColumnTypeInt},
+		&types.MapType{ValueType: types.ColumnTypeString}},
+}
+
+func nestedTestStruct() []any {
+	return []any{int64(-23), 1.25, true,
+		types.Decimal{Num: decimal128.FromI64(12345), Precision: 10, Scale: 2}, "foo", []byte("bar"),
+		types.NewTimestamp(1234567), []any{int64(1), nil, int64(3)}, map[string]any{"x": "y", "z": nil}}
+}
+
+func TestEncodeDecodeNestedValue(t *testing.T) {
+	testEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: types.ColumnTypeInt}, []any{int64(1), nil, int64(-3)})
+	testEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: types.ColumnTypeString}, []any{})
+	testEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: &types.ArrayType{ElemType: types.ColumnTypeFloat}},
+		[]any{[]any{1.1, 2.2}, nil, []any{}})
+	testEncodeDecodeNestedValue(t, &types.MapType{ValueType: types.ColumnTypeBool},
+		map[string]any{"a": true, "b": false, "c": nil})
+	testEncodeDecodeNestedValue(t, &types.MapType{ValueType: types.ColumnTypeInt}, map[string]any{})
+	testEncodeDecodeNestedValue(t, nestedTestStructType, nestedTestStruct())
+	testEncodeDecodeNestedValue(t, nestedTestStructType, []any{nil, nil, nil, nil, nil, nil, nil, nil, nil})
+	testEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: nestedTestStructType}, []any{nestedTestStruct(), nil})
+}
+
+func testEncodeDecodeNestedValue(t *testing.T, colType types.ColumnType, val any) {
+	buff := EncodeNestedValue([]byte("prefix"), colType, val)
+	res, off, err := DecodeNestedValue(buff, 6, colType)
+	require.NoError(t, err)
+	require.Equal(t, len(buff), off)
+	require.Equal(t, val, res)
+}
+
+func TestDecodeNestedValueTruncated(t *testing.T) {
+	colType := &types.ArrayType{ElemType: nestedTestStructType}
+	buff := EncodeNestedValue(nil, colType, []any{nestedTestStruct(), nil})
+	for i := 0; i < len(buff); i++ {
+		_, _, err := DecodeNestedValue(buff[:i], 0, colType)
+		require.Equal(t, errInsufficientBytes, err)
+	}
+
+	// A length that is greater than the remaining bytes is rejected before anything is allocated
+	buff = AppendUint32ToBufferLE(nil, math.MaxUint32)
+	_, _, err := DecodeNestedValue(buff, 0, &types.ArrayType{ElemType: types.ColumnTypeInt})
+	require.Equal(t, errInsufficientBytes, err)
+
+	// The error is returned when decoding a row containing the value
+	var row []byte
+	row = append(row, 1)
+	row = AppendBytesToBufferLE(row, buff)
+	_, _, err = DecodeRowToSlice(row, 0, []types.ColumnType{&types.ArrayType{ElemType: types.ColumnTypeInt}})
+	require.Equal(t, errInsufficientBytes, err)
+}
+
+func TestKeyEncodeDecodeNestedValue(t *testing.T) {
+	testKeyEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: types.ColumnTypeInt}, []any{int64(1), nil, int64(-3)})
+	testKeyEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: types.ColumnTypeString}, []any{})
+	testKeyEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: &types.ArrayType{ElemType: types.ColumnTypeFloat}},
+		[]any{[]any{1.1, 2.2}, nil, []any{}})
+	testKeyEncodeDecodeNestedValue(t, &types.MapType{ValueType: types.ColumnTypeBool},
+		map[string]any{"a": true, "b": false, "c": nil})
+	testKeyEncodeDecodeNestedValue(t, &types.MapType{ValueType: types.ColumnTypeInt}, map[string]any{})
+	testKeyEncodeDecodeNestedValue(t, nestedTestStructType, nestedTestStruct())
+	testKeyEncodeDecodeNestedValue(t, &types.ArrayType{ElemType: nestedTestStructType}, []any{nestedTestStruct(), nil})
+}
+
+func testKeyEncodeDecodeNestedValue(t *testing.T, colType types.ColumnType, val any) {
+	buff := KeyEncodeNested([]byte("prefix"), colType, val)
+	res, off, err := KeyDecodeNested(buff, 6, colType)
+	require.NoError(t, err)
+	require.Equal(t, len(buff), off)
+	require.Equal(t, val, res)
+}
+
+func TestKeyEncodeNestedOrdering(t *testing.T) {
+	arrType := &types.ArrayType{ElemType: types.ColumnTypeInt}
+	arrs := [][]any{
+		{},
+		{nil},
+		{int64(-10)},
+		{int64(-10), int64(1)},
+		{int64(1)},
+		{int64(1), nil},
+		{int64(1), int64(2)},
+		{int64(2)},
+	}
+	for i := 0; i < len(arrs)-1; i++ {
+		checkLessThan(t, KeyEncodeNested(nil, arrType, arrs[i]), KeyEncodeNested(nil, arrType, arrs[i+1]))
+	}
+	strArrType := &types.ArrayType{ElemType: types.ColumnTypeString}
+	strArrs := [][]any{
+		{"a"},
+		{"a", "b"},
+		{"aa"},
+		{"b"},
+	}
+	for i := 0; i < len(strArrs)-1; i++ {
+		checkLessThan(t, KeyEncodeNested(nil, strArrType, strArrs[i]), KeyEncodeNested(nil, strArrType, strArrs[i+1]))
+	}
+	mapType := &types.MapType{ValueType: types.ColumnTypeInt}
+	maps := []map[string]any{
+		{},
+		{"a": int64(1)},
+		{"a": int64(1), "b": int64(1)},
+		{"a": int64(2)},
+		{"b": int64(0)},
+	}
+	for i := 0; i < len(maps)-1; i++ {
+		checkLessThan(t, KeyEncodeNested(nil, mapType, maps[i]), KeyEncodeNested(nil, mapType, maps[i+1]))
+	}
+	structType := &types.StructType{FieldNames: []string{"a", "b"},
+		FieldTypes: []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}}
+	structs := [][]any{
+		{nil, "z"},
+		{int64(1), nil},
+		{int64(1), "a"},
+		{int64(1), "b"},
+		{int64(2), "a"},
+	}
+	for i := 0; i < len(structs)-1; i++ {
+		checkLessThan(t, KeyEncodeNested(nil, structType, structs[i]), KeyEncodeNested(nil, structType, structs[i+1]))
+	}
+}
+
+func TestKeyDecodeNestedInsufficientBytes(t *testing.T) {
+	arrType := &types.ArrayType{ElemType: types.ColumnTypeInt}
+	buff := KeyEncodeNested(nil, arrType, []any{int64(1), int64(2)})
+	_, _, err := KeyDecodeNested(buff[:len(buff)-1], 0, arrType)
+	require.Error(t, err)
+	require.Equal(t, "insufficient bytes to decode value", err.Error())
+}
+
+func TestDecodeNestedRowAndKeyToSlice(t *testing.T) {
+	colTypes := []types.ColumnType{types.ColumnTypeInt, &types.ArrayType{ElemType: types.ColumnTypeString},
+		&types.MapType{ValueType: types.ColumnTypeFloat}}
+	arr := []any{"a", nil, "c"}
+	m := map[string]any{"x": 1.5}
+
+	var row []byte
+	row = append(row, 1)
+	row = AppendUint64ToBufferLE(row, 23)
+	row = append(row, 1)
+	row = AppendBytesToBufferLE(row, EncodeNestedValue(nil, colTypes[1], arr))
+	row = append(row, 0)
+	vals, off, err := DecodeRowToSlice(row, 0, colTypes)
+	require.NoError(t, err)
+	require.Equal(t, len(row), off)
+	require.Equal(t, []any{int64(23), arr, nil}, vals)
+
+	var key []byte
+	key = append(key, 0)
+	key = append(key, 1)
+	key = KeyEncodeNested(key, colTypes[1], arr)
+	key = append(key, 1)
+	key = KeyEncodeNested(key, colTypes[2], m)
+	vals, off, err = DecodeKeyToSlice(key, 0, colTypes)
+	require.NoError(t, err)
+	require.Equal(t, len(key), off)
+	require.Equal(t, []any{nil, arr, m}, vals)
+}
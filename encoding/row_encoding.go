@@ -5,7 +5,7 @@ import (
 	"github.com/spirit-labs/tektite/types"
 )
 
-func DecodeRowToSlice(buffer []byte, offset int, columnTypes []types.ColumnType) ([]any, int) {
+func DecodeRowToSlice(buffer []byte, offset int, columnTypes []types.ColumnType) ([]any, int, error) {
 	row := make([]any, len(columnTypes))
 	for i, colType := range columnTypes {
 		if buffer[offset] == 0 {
@@ -37,11 +37,19 @@ func DecodeRowToSlice(buffer []byte, offset int, columnTypes []types.ColumnType)
 				var u uint64
 				u, offset = ReadUint64FromBufferLE(buffer, offset)
 				val = types.NewTimestamp(int64(u))
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				// Nested values are stored as length prefixed bytes
+				var b []byte
+				b, offset = ReadBytesFromBufferLE(buffer, offset)
+				var err error
+				if val, _, err = DecodeNestedValue(b, 0, colType); err != nil {
+					return nil, 0, err
+				}
 			default:
 				panic(fmt.Sprintf("unexpected column type %d", colType))
 			}
 			row[i] = val
 		}
 	}
-	return row, offset
+	return row, offset, nil
 }
@@ -24,6 +24,9 @@ const (
 	InternalError        = iota + 5000
 )
 
+// ProtobufError is declared outside the block above so the codes that follow WasmError are unchanged
+const ProtobufError = WasmError + 1
+
 func NewInternalError(errReference string) TektiteError {
 	return NewTektiteErrorf(InternalError, "internal error - reference: %s please consult server logs for details", errReference)
 }
@@ -70,6 +70,9 @@ func NewBatchFromBytes(schema *EventSchema, rowCount int, bytes [][]byte) *Batch
 		case types.ColumnTypeIDTimestamp:
 			cols[i] = NewTimestampColumnFromBytes(bytes[buffPos:buffPos+2], rowCount)
 			buffPos += 2
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			cols[i] = NewNestedColumnFromBytes(bytes[buffPos:buffPos+3], rowCount, columnType)
+			buffPos += 3
 		default:
 			panic("unexpected type")
 		}
@@ -96,6 +99,8 @@ func (b *Batch) ToBytes() [][]byte {
 			mBuffs = c.array.Data().Buffers()
 		case *TimestampColumn:
 			mBuffs = c.array.Data().Buffers()
+		case *NestedColumn:
+			mBuffs = c.array.Data().Buffers()
 		default:
 			panic("unknown type")
 		}
@@ -174,6 +179,10 @@ func (b *Batch) GetTimestampColumn(colIndex int) *TimestampColumn {
 	return b.Columns[colIndex].(*TimestampColumn)
 }
 
+func (b *Batch) GetNestedColumn(colIndex int) *NestedColumn {
+	return b.Columns[colIndex].(*NestedColumn)
+}
+
 type Column interface {
 	IsNull(row int) bool
 	Len() int
@@ -666,6 +675,8 @@ func CreateColBuilders(columnTypes []types.ColumnType) []ColumnBuilder {
 			colBuilders[colIndex] = NewBytesColBuilder()
 		case types.ColumnTypeIDTimestamp:
 			colBuilders[colIndex] = NewTimestampColBuilder()
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			colBuilders[colIndex] = NewNestedColBuilder(ft)
 		default:
 			panic(fmt.Sprintf("unknown column type %d", ft.ID()))
 		}
@@ -703,6 +714,9 @@ func CopyColumnEntryWithCol(ft types.ColumnType, col Column, colBuilder ColumnBu
 		colBuilder.(*BytesColBuilder).Append(col.(*BytesColumn).Get(rowIndex))
 	case types.ColumnTypeIDTimestamp:
 		colBuilder.(*TimestampColBuilder).Append(col.(*TimestampColumn).Get(rowIndex))
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		// No need to decode the value
+		colBuilder.(*NestedColBuilder).AppendEncoded(col.(*NestedColumn).GetEncoded(rowIndex))
 	default:
 		panic(fmt.Sprintf("unknown column type %d", ft.ID()))
 	}
@@ -768,6 +782,11 @@ func (b *Batch) Equal(other *Batch) bool {
 				if col1.(*TimestampColumn).Get(i).Val != col2.(*TimestampColumn).Get(i).Val {
 					return false
 				}
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				// Map entries are encoded in key order so equal values have equal encodings
+				if !col1.IsNull(i) && !bytes.Equal(col1.(*NestedColumn).GetEncoded(i), col2.(*NestedColumn).GetEncoded(i)) {
+					return false
+				}
 			default:
 				panic("unexpected type")
 			}
@@ -812,6 +831,10 @@ func (b *Batch) Dump() {
 				builder.WriteString(fmt.Sprintf("%v", col.(*BytesColumn).Get(i)))
 			case types.ColumnTypeIDTimestamp:
 				builder.WriteString(fmt.Sprintf("%d", col.(*TimestampColumn).Get(i).Val))
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				if !col.IsNull(i) {
+					builder.WriteString(fmt.Sprintf("%v", col.(*NestedColumn).Get(i)))
+				}
 			}
 			if j != len(b.Columns)-1 {
 				builder.WriteString(", ")
@@ -0,0 +1,99 @@
+package evbatch
+
+import (
+	"fmt"
+	"github.com/apache/arrow/go/v11/arrow"
+	"github.com/apache/arrow/go/v11/arrow/array"
+	"github.com/apache/arrow/go/v11/arrow/memory"
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/types"
+)
+
+// NewNestedColBuilder creates a builder for an array, map or struct column. Values are stored in an arrow binary array
+// using encoding.EncodeNestedValue.
+func NewNestedColBuilder(colType types.ColumnType) *NestedColBuilder {
+	allocator := memory.NewGoAllocator()
+	builder := array.NewBinaryBuilder(allocator, arrow.BinaryTypes.Binary)
+	return &NestedColBuilder{
+		colType: colType,
+		builder: builder,
+	}
+}
+
+type NestedColBuilder struct {
+	colType types.ColumnType
+	builder *array.BinaryBuilder
+	buff    []byte
+}
+
+func (nb *NestedColBuilder) AppendNull() {
+	nb.builder.AppendNull()
+}
+
+func (nb *NestedColBuilder) Append(val any) {
+	nb.buff = encoding.EncodeNestedValue(nb.buff[:0], nb.colType, val)
+	nb.builder.Append(nb.buff)
+}
+
+// AppendEncoded appends a value that has already been encoded with encoding.EncodeNestedValue.
+func (nb *NestedColBuilder) AppendEncoded(val []byte) {
+	nb.builder.Append(val)
+}
+
+func (nb *NestedColBuilder) BuildNestedColumn() *NestedColumn {
+	return &NestedColumn{colType: nb.colType, array: nb.builder.NewBinaryArray()}
+}
+
+func (nb *NestedColBuilder) Build() Column {
+	return nb.BuildNestedColumn()
+}
+
+var _ Column = &NestedColumn{}
+
+type NestedColumn struct {
+	colType types.ColumnType
+	array   *array.Binary
+}
+
+func NewNestedColumnFromBytes(bytes [][]byte, length int, colType types.ColumnType) *NestedColumn {
+	mbs := bytesToMBuffs(bytes)
+	data := array.NewData(&arrow.BinaryType{}, length, mbs, nil, 0, 0)
+	arr := array.NewBinaryData(data)
+	return &NestedColumn{colType: colType, array: arr}
+}
+
+func (nc *NestedColumn) ColumnType() types.ColumnType {
+	return nc.colType
+}
+
+func (nc *NestedColumn) Retain() {
+	nc.array.Retain()
+}
+
+func (nc *NestedColumn) Release() {
+	nc.array.Release()
+}
+
+// Get decodes the value at the row. See types.ArrayType, types.MapType and types.StructType for how values are
+// represented.
+func (nc *NestedColumn) Get(row int) any {
+	val, _, err := encoding.DecodeNestedValue(nc.array.Value(row), 0, nc.colType)
+	if err != nil {
+		// Values are only added to the column by the column builder, which encodes them
+		panic(fmt.Sprintf("invalid encoded %s value: %v", nc.colType.String(), err))
+	}
+	return val
+}
+
+// GetEncoded returns the encoded value at the row without decoding it.
+func (nc *NestedColumn) GetEncoded(row int) []byte {
+	return nc.array.Value(row)
+}
+
+func (nc *NestedColumn) IsNull(row int) bool {
+	return nc.array.IsNull(row)
+}
+
+func (nc *NestedColumn) Len() int {
+	return nc.array.Len()
+}
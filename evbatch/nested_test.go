@@ -0,0 +1,86 @@
+package evbatch
+
+import (
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var nestedTestSchema = NewEventSchema([]string{"f0", "f1", "f2", "f3"},
+	[]types.ColumnType{types.ColumnTypeInt, &types.ArrayType{ElemType: types.ColumnTypeString},
+		&types.MapType{ValueType: types.ColumnTypeInt},
+		&types.StructType{FieldNames: []string{"a", "b"},
+			FieldTypes: []types.ColumnType{types.ColumnTypeFloat, &types.ArrayType{ElemType: types.ColumnTypeInt}}}})
+
+func createNestedBatch() *Batch {
+	builders := CreateColBuilders(nestedTestSchema.ColumnTypes())
+	builders[0].(*IntColBuilder).Append(1)
+	builders[1].(*NestedColBuilder).Append([]any{"a", nil, "c"})
+	builders[2].(*NestedColBuilder).Append(map[string]any{"x": int64(1), "y": nil})
+	builders[3].(*NestedColBuilder).Append([]any{1.5, []any{int64(1), int64(2)}})
+	builders[0].(*IntColBuilder).Append(2)
+	builders[1].(*NestedColBuilder).Append([]any{})
+	builders[2].AppendNull()
+	builders[3].(*NestedColBuilder).Append([]any{nil, nil})
+	return NewBatchFromBuilders(nestedTestSchema, builders...)
+}
+
+func TestNestedColumns(t *testing.T) {
+	batch := createNestedBatch()
+	require.Equal(t, 2, batch.RowCount)
+	require.Equal(t, []any{"a", nil, "c"}, batch.GetNestedColumn(1).Get(0))
+	require.Equal(t, map[string]any{"x": int64(1), "y": nil}, batch.GetNestedColumn(2).Get(0))
+	require.Equal(t, []any{1.5, []any{int64(1), int64(2)}}, batch.GetNestedColumn(3).Get(0))
+	require.Equal(t, []any{}, batch.GetNestedColumn(1).Get(1))
+	require.True(t, batch.Columns[2].IsNull(1))
+	require.Equal(t, []any{nil, nil}, batch.GetNestedColumn(3).Get(1))
+	require.True(t, types.ColumnTypesEqual(nestedTestSchema.ColumnTypes()[1], batch.GetNestedColumn(1).ColumnType()))
+}
+
+func TestNestedBatchBytes(t *testing.T) {
+	batch := createNestedBatch()
+	batch2 := NewBatchFromBytes(nestedTestSchema, batch.RowCount, batch.ToBytes())
+	require.True(t, batch.Equal(batch2))
+	batch3 := NewBatchFromSingleBuff(nestedTestSchema, batch.Serialize(nil))
+	require.True(t, batch.Equal(batch3))
+}
+
+func TestCopyNestedColumnEntry(t *testing.T) {
+	batch := createNestedBatch()
+	builders := CreateColBuilders(nestedTestSchema.ColumnTypes())
+	for row := batch.RowCount - 1; row >= 0; row-- {
+		for i, ft := range nestedTestSchema.ColumnTypes() {
+			CopyColumnEntry(ft, builders, i, row, batch)
+		}
+	}
+	copied := NewBatchFromBuilders(nestedTestSchema, builders...)
+	require.Equal(t, []any{}, copied.GetNestedColumn(1).Get(0))
+	require.True(t, copied.Columns[2].IsNull(0))
+	require.Equal(t, []any{"a", nil, "c"}, copied.GetNestedColumn(1).Get(1))
+	require.Equal(t, map[string]any{"x": int64(1), "y": nil}, copied.GetNestedColumn(2).Get(1))
+	require.False(t, batch.Equal(copied))
+}
+
+func TestEncodeNestedRowAndKeyCols(t *testing.T) {
+	batch := createNestedBatch()
+	colTypes := nestedTestSchema.ColumnTypes()
+	for row := 0; row < batch.RowCount; row++ {
+		buff := EncodeRowCols(batch, row, []int{0, 1, 2, 3}, nil)
+		vals, off, err := encoding.DecodeRowToSlice(buff, 0, colTypes)
+		require.NoError(t, err)
+		require.Equal(t, len(buff), off)
+		buff = EncodeKeyCols(batch, row, []int{0, 1, 2, 3}, nil)
+		keyVals, off, err := encoding.DecodeKeyToSlice(buff, 0, colTypes)
+		require.NoError(t, err)
+		require.Equal(t, len(buff), off)
+		require.Equal(t, vals, keyVals)
+		for i := range colTypes {
+			if batch.Columns[i].IsNull(row) {
+				require.Nil(t, vals[i])
+			} else if i > 0 {
+				require.Equal(t, batch.GetNestedColumn(i).Get(row), vals[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/avro"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/schemareg"
+	"github.com/spirit-labs/tektite/types"
+	"strings"
+	"sync"
+)
+
+// avroSchemas resolves the Avro schemas used by a function from the schema registry, and caches them parsed. Schemas
+// are immutable once registered, so schemas fetched by id are cached indefinitely. Evaluation must not wait for the
+// registry, so the schemas that messages can be written with are prefetched when the function is created. A schema that
+// is not cached, such as one registered after the function was created, is fetched in the background, and evaluation
+// fails until it has been fetched.
+type avroSchemas struct {
+	registry schemareg.Registry
+	byID     sync.Map
+	fetching sync.Map
+}
+
+// getByID returns the cached schema with the id. If it is not cached it is fetched in the background and an error is
+// returned.
+func (a *avroSchemas) getByID(id int32) (*avro.Schema, error) {
+	s, ok := a.byID.Load(id)
+	if ok {
+		return s.(*avro.Schema), nil
+	}
+	if _, fetching := a.fetching.LoadOrStore(id, struct{}{}); !fetching {
+		go func() {
+			defer a.fetching.Delete(id)
+			if _, err := a.fetchByID(id); err != nil {
+				log.Warnf("failed to fetch avro schema with id %d from schema registry: %v", id, err)
+			}
+		}()
+	}
+	return nil, errors.New("schema is not cached - it is being fetched from the schema registry")
+}
+
+// fetchByID returns the schema with the id, fetching it from the registry if it is not cached
+func (a *avroSchemas) fetchByID(id int32) (*avro.Schema, error) {
+	s, ok := a.byID.Load(id)
+	if ok {
+		return s.(*avro.Schema), nil
+	}
+	regSchema, err := a.registry.GetSchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := parseAvroSchema(regSchema)
+	if err != nil {
+		return nil, err
+	}
+	a.byID.Store(id, schema)
+	return schema, nil
+}
+
+// prefetch caches all the Avro schemas registered under the subject
+func (a *avroSchemas) prefetch(subject string) error {
+	regSchemas, err := a.registry.GetSubjectSchemas(subject)
+	if err != nil {
+		return err
+	}
+	for _, regSchema := range regSchemas {
+		if regSchema.SchemaType != schemareg.SchemaTypeAvro {
+			continue
+		}
+		if _, ok := a.byID.Load(regSchema.ID); ok {
+			continue
+		}
+		schema, err := avro.ParseSchema(regSchema.Schema)
+		if err != nil {
+			return err
+		}
+		a.byID.Store(regSchema.ID, schema)
+	}
+	return nil
+}
+
+func (a *avroSchemas) getLatest(subject string) (int32, *avro.Schema, error) {
+	regSchema, err := a.registry.GetLatestSchema(subject)
+	if err != nil {
+		return 0, nil, err
+	}
+	schema, err := a.fetchByID(regSchema.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return regSchema.ID, schema, nil
+}
+
+func parseAvroSchema(regSchema *schemareg.Schema) (*avro.Schema, error) {
+	if regSchema.SchemaType != schemareg.SchemaTypeAvro {
+		return nil, errors.Errorf("schema with id %d is a %s schema, not an avro schema", regSchema.ID,
+			regSchema.SchemaType)
+	}
+	return avro.ParseSchema(regSchema.Schema)
+}
+
+func newAvroSchemas(registry schemareg.Registry, desc *parser.FunctionExprDesc) (*avroSchemas, error) {
+	if registry == nil {
+		return nil, desc.ErrorAtPosition("'%s' requires a schema registry - schema-registry-type must be configured",
+			desc.FunctionName)
+	}
+	return &avroSchemas{registry: registry}, nil
+}
+
+func getAvroSubject(argExpr Expression, argDesc parser.ExprDesc, desc *parser.FunctionExprDesc,
+	schemas *avroSchemas) (int32, *avro.Schema, error) {
+	if _, ok := argExpr.(*StringConstantExpr); !ok {
+		return 0, nil, argDesc.ErrorAtPosition("'%s' subject argument must be a string literal", desc.FunctionName)
+	}
+	subject, _, _ := argExpr.EvalString(0, nil)
+	id, schema, err := schemas.getLatest(subject)
+	if err != nil {
+		return 0, nil, argDesc.ErrorAtPosition("'%s' cannot get avro schema for subject '%s': %v", desc.FunctionName,
+			subject, err)
+	}
+	return id, schema, nil
+}
+
+// avroColumnType returns the column type that values of the Avro schema are converted to. Optional values have the
+// type of the value. Records, arrays, maps and other unions are converted to JSON strings.
+func avroColumnType(schema *avro.Schema) (types.ColumnType, bool) {
+	if branch := schema.NonNullBranch(); branch != nil {
+		schema = branch
+	}
+	switch schema.Type {
+	case avro.TypeBoolean:
+		return types.ColumnTypeBool, false
+	case avro.TypeInt, avro.TypeLong:
+		if schema.LogicalType != "" {
+			return types.ColumnTypeTimestamp, false
+		}
+		return types.ColumnTypeInt, false
+	case avro.TypeFloat, avro.TypeDouble:
+		return types.ColumnTypeFloat, false
+	case avro.TypeBytes, avro.TypeFixed:
+		if schema.LogicalType == avro.LogicalTypeDecimal {
+			return &types.DecimalType{Precision: schema.Precision, Scale: schema.Scale}, false
+		}
+		return types.ColumnTypeBytes, false
+	case avro.TypeString, avro.TypeEnum:
+		return types.ColumnTypeString, false
+	default:
+		return types.ColumnTypeString, true
+	}
+}
+
+// AvroDecodeFunction decodes a Kafka message encoded in Avro in the schema registry wire format. With two arguments it
+// returns the message as JSON. With three it returns the value of a field of the message, with a type determined by
+// the latest schema of the subject. Messages are decoded with the schema they were written with, so messages written
+// with older versions of the schema can be decoded, as long as the field has the same type.
+type AvroDecodeFunction struct {
+	valArg       Expression
+	schemas      *avroSchemas
+	fieldPath    []string
+	resultType   types.ColumnType
+	jsonEncoded  bool
+	defaultValue any
+}
+
+func NewAvroDecodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc,
+	registry schemareg.Registry) (*AvroDecodeFunction, error) {
+	if len(argExprs) != 2 && len(argExprs) != 3 {
+		return nil, desc.ErrorAtPosition("'avro_decode' requires 2 or 3 arguments")
+	}
+	if argExprs[0].ResultType() != types.ColumnTypeBytes {
+		return nil, desc.ErrorAtPosition("'avro_decode' first argument must be of type bytes - it is %s",
+			argExprs[0].ResultType().String())
+	}
+	schemas, err := newAvroSchemas(registry, desc)
+	if err != nil {
+		return nil, err
+	}
+	_, schema, err := getAvroSubject(argExprs[1], desc.ArgExprs[1], desc, schemas)
+	if err != nil {
+		return nil, err
+	}
+	// Messages can be written with any version of the schema of the subject
+	subject, _, _ := argExprs[1].EvalString(0, nil)
+	if err := schemas.prefetch(subject); err != nil {
+		return nil, desc.ArgExprs[1].ErrorAtPosition("'avro_decode' cannot get avro schemas for subject '%s': %v",
+			subject, err)
+	}
+	fn := &AvroDecodeFunction{
+		valArg:      argExprs[0],
+		schemas:     schemas,
+		resultType:  types.ColumnTypeString,
+		jsonEncoded: true,
+	}
+	if len(argExprs) == 2 {
+		return fn, nil
+	}
+	if _, ok := argExprs[2].(*StringConstantExpr); !ok {
+		return nil, desc.ArgExprs[2].ErrorAtPosition("'avro_decode' field argument must be a string literal")
+	}
+	fieldName, _, _ := argExprs[2].EvalString(0, nil)
+	fn.fieldPath = strings.Split(fieldName, ".")
+	fieldSchema := schema
+	var field *avro.Field
+	for _, name := range fn.fieldPath {
+		if branch := fieldSchema.NonNullBranch(); branch != nil {
+			fieldSchema = branch
+		}
+		field = nil
+		if fieldSchema.Type == avro.TypeRecord {
+			field, _ = fieldSchema.FieldByName(name)
+		}
+		if field == nil {
+			return nil, desc.ArgExprs[2].ErrorAtPosition("'avro_decode' field '%s' does not exist in avro schema '%s'",
+				fieldName, schema.String())
+		}
+		fieldSchema = field.Schema
+	}
+	fn.resultType, fn.jsonEncoded = avroColumnType(fieldSchema)
+	if decType, ok := fn.resultType.(*types.DecimalType); ok && decType.Precision > types.DefaultDecimalPrecision {
+		return nil, desc.ArgExprs[2].ErrorAtPosition("'avro_decode' field '%s' has decimal precision %d - the maximum is %d",
+			fieldName, decType.Precision, types.DefaultDecimalPrecision)
+	}
+	if field.HasDefault {
+		// A field that was added to the schema with a default has that value in messages written before it existed
+		fn.defaultValue, err = avroDefaultValue(field)
+		if err != nil {
+			return nil, desc.ArgExprs[2].ErrorAtPosition("'avro_decode' field '%s' has invalid default: %v",
+				fieldName, err)
+		}
+	}
+	return fn, nil
+}
+
+func avroDefaultValue(field *avro.Field) (any, error) {
+	if field.Default == nil {
+		return nil, nil
+	}
+	schema := field.Schema
+	if schema.Type == avro.TypeUnion {
+		schema = schema.Branches[0]
+	}
+	// We convert the default from JSON to its decoded value by encoding it and decoding it again
+	encoded, err := avro.Encode(schema, field.Default, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, jsonEncoded := avroColumnType(schema); jsonEncoded {
+		out, _, err := avro.AppendJSON(schema, encoded, nil)
+		return string(out), err
+	}
+	v, _, err := avro.DecodeValue(schema, encoded)
+	return v, err
+}
+
+func (a *AvroDecodeFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := a.valArg.EvalBytes(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	id, payload, err := schemareg.ParseWireHeader(val)
+	if err != nil {
+		return nil, false, errors.Errorf("function 'avro_decode' - %v", err)
+	}
+	schema, err := a.schemas.getByID(id)
+	if err != nil {
+		return nil, false, errors.Errorf("function 'avro_decode' - cannot get avro schema with id %d: %v", id, err)
+	}
+	if a.fieldPath != nil {
+		fieldSchema, fieldBuff, found, err := avro.FindField(schema, payload, a.fieldPath)
+		if err != nil {
+			return nil, false, errors.Errorf("function 'avro_decode' - %v", err)
+		}
+		if !found {
+			return a.defaultValue, a.defaultValue == nil, nil
+		}
+		colType, _ := avroColumnType(fieldSchema)
+		if fieldSchema.Type != avro.TypeNull && colType.ID() != a.resultType.ID() {
+			return nil, false, errors.Errorf("function 'avro_decode' - field '%s' has type %s in avro schema with id %d, but %s is required",
+				strings.Join(a.fieldPath, "."), colType.String(), id, a.resultType.String())
+		}
+		schema = fieldSchema
+		payload = fieldBuff
+	}
+	if a.jsonEncoded {
+		out, _, err := avro.AppendJSON(schema, payload, nil)
+		if err != nil {
+			return nil, false, errors.Errorf("function 'avro_decode' - %v", err)
+		}
+		return string(out), false, nil
+	}
+	v, _, err := avro.DecodeValue(schema, payload)
+	if err != nil {
+		return nil, false, errors.Errorf("function 'avro_decode' - %v", err)
+	}
+	return v, v == nil, nil
+}
+
+func (a *AvroDecodeFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return v.(int64), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return v.(float64), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalBool(rowIndex int, batch *evbatch.Batch) (bool, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return false, null, err
+	}
+	return v.(bool), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalDecimal(rowIndex int, batch *evbatch.Batch) (types.Decimal, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return types.Decimal{}, null, err
+	}
+	d := v.(types.Decimal)
+	decType := a.resultType.(*types.DecimalType)
+	// The message may have been written with a schema with a different precision and scale
+	return d.ConvertPrecisionAndScale(decType.Precision, decType.Scale), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	return v.(string), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	return v.([]byte), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	v, null, err := a.eval(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	return v.(types.Timestamp), false, nil
+}
+
+func (a *AvroDecodeFunction) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
+func (a *AvroDecodeFunction) ResultType() types.ColumnType {
+	return a.resultType
+}
+
+// AvroEncodeFunction encodes its arguments in Avro with the latest schema of a subject, in the schema registry wire
+// format. If the schema is a record, there is an argument for each of its fields, in order, otherwise there is a single
+// argument. Fields that are records, arrays, maps or unions of more than one type are provided as JSON strings.
+type AvroEncodeFunction struct {
+	baseExpr
+	schemaID   int32
+	schema     *avro.Schema
+	fieldExprs []Expression
+	isRecord   bool
+}
+
+func NewAvroEncodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc,
+	registry schemareg.Registry) (*AvroEncodeFunction, error) {
+	if len(argExprs) < 2 {
+		return nil, desc.ErrorAtPosition("'avro_encode' requires at least 2 arguments")
+	}
+	schemas, err := newAvroSchemas(registry, desc)
+	if err != nil {
+		return nil, err
+	}
+	id, schema, err := getAvroSubject(argExprs[0], desc.ArgExprs[0], desc, schemas)
+	if err != nil {
+		return nil, err
+	}
+	fieldExprs := argExprs[1:]
+	var fieldSchemas []*avro.Schema
+	var fieldNames []string
+	isRecord := schema.Type == avro.TypeRecord
+	if isRecord {
+		for _, field := range schema.Fields {
+			fieldSchemas = append(fieldSchemas, field.Schema)
+			fieldNames = append(fieldNames, field.Name)
+		}
+	} else {
+		fieldSchemas = []*avro.Schema{schema}
+		fieldNames = []string{"value"}
+	}
+	if len(fieldExprs) != len(fieldSchemas) {
+		return nil, desc.ErrorAtPosition("'avro_encode' requires %d value arguments for avro schema '%s' - %d found",
+			len(fieldSchemas), schema.String(), len(fieldExprs))
+	}
+	for i, fieldExpr := range fieldExprs {
+		required, _ := avroColumnType(fieldSchemas[i])
+		actual := fieldExpr.ResultType()
+		if actual.ID() != required.ID() && !(actual.ID() == types.ColumnTypeIDInt && required.ID() == types.ColumnTypeIDFloat) {
+			return nil, desc.ArgExprs[i+1].ErrorAtPosition("'avro_encode' value for '%s' must be of type %s - it is %s",
+				fieldNames[i], required.String(), actual.String())
+		}
+	}
+	return &AvroEncodeFunction{
+		schemaID:   id,
+		schema:     schema,
+		fieldExprs: fieldExprs,
+		isRecord:   isRecord,
+	}, nil
+}
+
+func (a *AvroEncodeFunction) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	var value any
+	if a.isRecord {
+		rec := make(map[string]any, len(a.fieldExprs))
+		for i, fieldExpr := range a.fieldExprs {
+			v, err := evalAvroValue(fieldExpr, a.schema.Fields[i].Schema, rowIndex, batch)
+			if err != nil {
+				return nil, false, err
+			}
+			rec[a.schema.Fields[i].Name] = v
+		}
+		value = rec
+	} else {
+		var err error
+		value, err = evalAvroValue(a.fieldExprs[0], a.schema, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	out := schemareg.AppendWireHeader(nil, a.schemaID)
+	out, err := avro.Encode(a.schema, value, out)
+	if err != nil {
+		return nil, false, errors.Errorf("function 'avro_encode' - %v", err)
+	}
+	return out, false, nil
+}
+
+func evalAvroValue(e Expression, schema *avro.Schema, rowIndex int, batch *evbatch.Batch) (any, error) {
+	var v any
+	var null bool
+	var err error
+	switch e.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		v, null, err = e.EvalInt(rowIndex, batch)
+	case types.ColumnTypeIDFloat:
+		v, null, err = e.EvalFloat(rowIndex, batch)
+	case types.ColumnTypeIDBool:
+		v, null, err = e.EvalBool(rowIndex, batch)
+	case types.ColumnTypeIDDecimal:
+		v, null, err = e.EvalDecimal(rowIndex, batch)
+	case types.ColumnTypeIDString:
+		v, null, err = e.EvalString(rowIndex, batch)
+	case types.ColumnTypeIDBytes:
+		v, null, err = e.EvalBytes(rowIndex, batch)
+	case types.ColumnTypeIDTimestamp:
+		v, null, err = e.EvalTimestamp(rowIndex, batch)
+	default:
+		panic("unknown type")
+	}
+	if err != nil || null {
+		return nil, err
+	}
+	if _, jsonEncoded := avroColumnType(schema); jsonEncoded {
+		dec := json.NewDecoder(bytes.NewReader([]byte(v.(string))))
+		dec.UseNumber()
+		var jv any
+		if err := dec.Decode(&jv); err != nil {
+			return nil, errors.Errorf("function 'avro_encode' - invalid json for avro %s: %v", schema.String(), err)
+		}
+		return jv, nil
+	}
+	return v, nil
+}
+
+func (a *AvroEncodeFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeBytes
+}
@@ -0,0 +1,317 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/avro"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/schemareg"
+	"github.com/spirit-labs/tektite/testutils"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const ordersSchemaV1 = `{"type": "record", "name": "Order", "fields": [
+	{"name": "id", "type": "long"},
+	{"name": "customer", "type": "string"},
+	{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+	{"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "notes", "type": ["null", "string"], "default": null},
+	{"name": "address", "type": {"type": "record", "name": "Address", "fields": [{"name": "city", "type": "string"}]}}
+]}`
+
+// Version 2 adds fields with defaults
+const ordersSchemaV2 = `{"type": "record", "name": "Order", "fields": [
+	{"name": "id", "type": "long"},
+	{"name": "customer", "type": "string"},
+	{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+	{"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "notes", "type": ["null", "string"], "default": null},
+	{"name": "address", "type": {"type": "record", "name": "Address", "fields": [{"name": "city", "type": "string"}]}},
+	{"name": "priority", "type": "int", "default": 3},
+	{"name": "valid", "type": "boolean", "default": true},
+	{"name": "price", "type": ["null", "double"], "default": null}
+]}`
+
+func setupAvroRegistry(t *testing.T) (*schemareg.EmbeddedRegistry, int32, int32) {
+	registry := schemareg.NewEmbeddedRegistry("")
+	id1, err := registry.Register("orders-value", schemareg.SchemaTypeAvro, ordersSchemaV1)
+	require.NoError(t, err)
+	id2, err := registry.Register("orders-value", schemareg.SchemaTypeAvro, ordersSchemaV2)
+	require.NoError(t, err)
+	return registry, id1, id2
+}
+
+func encodeAvroMessage(t *testing.T, schemaID int32, schemaJson string, value map[string]any) string {
+	schema, err := avro.ParseSchema(schemaJson)
+	require.NoError(t, err)
+	buff := schemareg.AppendWireHeader(nil, schemaID)
+	buff, err = avro.Encode(schema, value, buff)
+	require.NoError(t, err)
+	return string(buff)
+}
+
+func createAvroTestBatch(t *testing.T, id1 int32, id2 int32) *evbatch.Batch {
+	amount, err := types.NewDecimalFromString("123.45", 10, 2)
+	require.NoError(t, err)
+	msg1 := encodeAvroMessage(t, id1, ordersSchemaV1, map[string]any{
+		"id": int64(1), "customer": "alice", "amount": amount, "created": types.NewTimestamp(1000),
+		"notes": "fragile", "address": map[string]any{"city": "london"},
+	})
+	msg2 := encodeAvroMessage(t, id2, ordersSchemaV2, map[string]any{
+		"id": int64(2), "customer": "bob", "amount": amount, "created": types.NewTimestamp(2000),
+		"notes": nil, "address": map[string]any{"city": "paris"}, "priority": int64(1), "valid": false,
+		"price": 12.5,
+	})
+	valCol := createBytesCol([]bool{false, false, true}, []string{msg1, msg2, ""})
+	schema := evbatch.NewEventSchema([]string{"val"}, []types.ColumnType{types.ColumnTypeBytes})
+	return evbatch.NewBatch(schema, valCol)
+}
+
+func createAvroExpr(registry schemareg.Registry, exprStr string, schema *evbatch.EventSchema) (Expression, error) {
+	tokens, err := parser.Lex(exprStr, true)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewParser(nil)
+	desc, err := p.ParseExpression(parser.NewParseContext(p, exprStr, tokens))
+	if err != nil {
+		return nil, err
+	}
+	factory := &ExpressionFactory{SchemaRegistry: registry}
+	return factory.CreateExpression(desc, schema)
+}
+
+func testAvroDecodeField(t *testing.T, field string, expectedType types.ColumnType, expected evbatch.Column) {
+	registry, id1, id2 := setupAvroRegistry(t)
+	batch := createAvroTestBatch(t, id1, id2)
+	e, err := createAvroExpr(registry, `avro_decode(val, "orders-value", "`+field+`")`, batch.Schema)
+	require.NoError(t, err)
+	require.Equal(t, expectedType, e.ResultType())
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	colsEqual(t, expected, res)
+}
+
+func TestAvroDecodeInt(t *testing.T) {
+	testAvroDecodeField(t, "id", types.ColumnTypeInt, createIntCol([]bool{false, false, true}, []int64{1, 2, 0}))
+}
+
+func TestAvroDecodeString(t *testing.T) {
+	testAvroDecodeField(t, "customer", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{"alice", "bob", ""}))
+}
+
+func TestAvroDecodeDecimal(t *testing.T) {
+	testAvroDecodeField(t, "amount", &types.DecimalType{Precision: 10, Scale: 2},
+		createDecimalCol(10, 2, []bool{false, false, true}, []string{"123.45", "123.45", ""}))
+}
+
+func TestAvroDecodeTimestamp(t *testing.T) {
+	testAvroDecodeField(t, "created", types.ColumnTypeTimestamp,
+		createTimestampCol([]bool{false, false, true}, []int64{1000, 2000, 0}))
+}
+
+func TestAvroDecodeOptional(t *testing.T) {
+	testAvroDecodeField(t, "notes", types.ColumnTypeString,
+		createStringCol([]bool{false, true, true}, []string{"fragile", "", ""}))
+	testAvroDecodeField(t, "price", types.ColumnTypeFloat,
+		createFloatCol([]bool{true, false, true}, []float64{0, 12.5, 0}))
+}
+
+func TestAvroDecodeNestedField(t *testing.T) {
+	testAvroDecodeField(t, "address.city", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{"london", "paris", ""}))
+}
+
+func TestAvroDecodeRecordFieldAsJSON(t *testing.T) {
+	testAvroDecodeField(t, "address", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{`{"city":"london"}`, `{"city":"paris"}`, ""}))
+}
+
+func TestAvroDecodeFieldMissingFromWriterSchema(t *testing.T) {
+	// The first message was written with version 1 of the schema, before the fields existed, so they have their
+	// defaults
+	testAvroDecodeField(t, "priority", types.ColumnTypeInt, createIntCol([]bool{false, false, true}, []int64{3, 1, 0}))
+	testAvroDecodeField(t, "valid", types.ColumnTypeBool,
+		createBoolCol([]bool{false, false, true}, []bool{true, false, false}))
+}
+
+func TestAvroDecodeJSON(t *testing.T) {
+	registry, id1, id2 := setupAvroRegistry(t)
+	batch := createAvroTestBatch(t, id1, id2)
+	e, err := createAvroExpr(registry, `avro_decode(val, "orders-value")`, batch.Schema)
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeString, e.ResultType())
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	// Each message is decoded with the schema it was written with
+	expected := createStringCol([]bool{false, false, true}, []string{
+		`{"id":1,"customer":"alice","amount":"123.45","created":1000,"notes":"fragile","address":{"city":"london"}}`,
+		`{"id":2,"customer":"bob","amount":"123.45","created":2000,"notes":null,"address":{"city":"paris"},"priority":1,"valid":false,"price":12.5}`,
+		""})
+	colsEqual(t, expected, res)
+
+	// The JSON can be used with the json functions
+	e, err = createAvroExpr(registry, `json_string("address.city", avro_decode(val, "orders-value"))`, batch.Schema)
+	require.NoError(t, err)
+	res, err = EvalColumn(e, batch)
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, true}, []string{"london", "paris", ""}), res)
+}
+
+func TestAvroEncode(t *testing.T) {
+	registry, _, id2 := setupAvroRegistry(t)
+	schema := evbatch.NewEventSchema([]string{"id", "customer", "amount", "created", "notes", "address", "priority", "valid", "price"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, &types.DecimalType{Precision: 20, Scale: 4},
+			types.ColumnTypeTimestamp, types.ColumnTypeString, types.ColumnTypeString, types.ColumnTypeInt,
+			types.ColumnTypeBool, types.ColumnTypeInt})
+	batch := evbatch.NewBatch(schema,
+		createIntCol([]bool{false, false}, []int64{1, 2}),
+		createStringCol([]bool{false, false}, []string{"alice", "bob"}),
+		createDecimalCol(20, 4, []bool{false, false}, []string{"123.4500", "-0.0100"}),
+		createTimestampCol([]bool{false, false}, []int64{1000, 2000}),
+		createStringCol([]bool{false, true}, []string{"fragile", ""}),
+		createStringCol([]bool{false, false}, []string{`{"city": "london"}`, `{"city": "paris"}`}),
+		createIntCol([]bool{false, false}, []int64{1, 2}),
+		createBoolCol([]bool{false, false}, []bool{true, false}),
+		createIntCol([]bool{false, true}, []int64{10, 0}),
+	)
+	e, err := createAvroExpr(registry,
+		`avro_encode("orders-value", id, customer, amount, created, notes, address, priority, valid, price)`, schema)
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeBytes, e.ResultType())
+	encoded, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+
+	// The messages are encoded with the latest schema
+	for i := 0; i < 2; i++ {
+		id, _, err := schemareg.ParseWireHeader(encoded.(*evbatch.BytesColumn).Get(i))
+		require.NoError(t, err)
+		require.Equal(t, id2, id)
+	}
+
+	decodeSchema := evbatch.NewEventSchema([]string{"val"}, []types.ColumnType{types.ColumnTypeBytes})
+	decodeBatch := evbatch.NewBatch(decodeSchema, encoded)
+	e, err = createAvroExpr(registry, `avro_decode(val, "orders-value")`, decodeSchema)
+	require.NoError(t, err)
+	res, err := EvalColumn(e, decodeBatch)
+	require.NoError(t, err)
+	expected := createStringCol([]bool{false, false}, []string{
+		`{"id":1,"customer":"alice","amount":"123.45","created":1000,"notes":"fragile","address":{"city":"london"},"priority":1,"valid":true,"price":10}`,
+		`{"id":2,"customer":"bob","amount":"-0.01","created":2000,"notes":null,"address":{"city":"paris"},"priority":2,"valid":false,"price":null}`,
+	})
+	colsEqual(t, expected, res)
+}
+
+func TestAvroEncodeNonRecord(t *testing.T) {
+	registry := schemareg.NewEmbeddedRegistry("")
+	id, err := registry.Register("names", schemareg.SchemaTypeAvro, `"string"`)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"name"}, []types.ColumnType{types.ColumnTypeString})
+	batch := evbatch.NewBatch(schema, createStringCol([]bool{false}, []string{"foo"}))
+	e, err := createAvroExpr(registry, `avro_encode("names", name)`, schema)
+	require.NoError(t, err)
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	expected := string(schemareg.AppendWireHeader(nil, id)) + "\x06foo"
+	colsEqual(t, createBytesCol([]bool{false}, []string{expected}), res)
+}
+
+func TestAvroEncodeErrors(t *testing.T) {
+	registry, _, _ := setupAvroRegistry(t)
+	schema := evbatch.NewEventSchema([]string{"i", "s", "f", "b"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeFloat, types.ColumnTypeBytes})
+	testAvroExprError(t, registry, `avro_encode("orders-value")`, schema,
+		"'avro_encode' requires at least 2 arguments")
+	testAvroExprError(t, registry, `avro_encode(s, i)`, schema,
+		"'avro_encode' subject argument must be a string literal")
+	testAvroExprError(t, registry, `avro_encode("orders-value", i, s)`, schema,
+		"'avro_encode' requires 9 value arguments for avro schema 'Order' - 2 found")
+	testAvroExprError(t, registry, `avro_encode("orders-value", s, s, f, i, s, s, i, i, f)`, schema,
+		"'avro_encode' value for 'id' must be of type int - it is string")
+
+	// Values that are not valid for the schema fail when the expression is evaluated
+	e, err := createAvroExpr(registry, `avro_encode("orders-value", i, s, to_decimal(i, 10, 2), to_timestamp(i), s, s, i, i < 0, f)`, schema)
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(schema,
+		createIntCol([]bool{false}, []int64{1}),
+		createStringCol([]bool{false}, []string{"not json"}),
+		createFloatCol([]bool{false}, []float64{1.5}),
+		createBytesCol([]bool{false}, []string{""}))
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "function 'avro_encode' - invalid json for avro Address: invalid character 'o' in literal null (expecting 'u')", err.Error())
+}
+
+func TestAvroDecodeErrors(t *testing.T) {
+	registry, _, _ := setupAvroRegistry(t)
+	schema := evbatch.NewEventSchema([]string{"val", "s"}, []types.ColumnType{types.ColumnTypeBytes, types.ColumnTypeString})
+	testAvroExprError(t, registry, `avro_decode(val)`, schema, "'avro_decode' requires 2 or 3 arguments")
+	testAvroExprError(t, registry, `avro_decode(s, "orders-value")`, schema,
+		"'avro_decode' first argument must be of type bytes - it is string")
+	testAvroExprError(t, registry, `avro_decode(val, s)`, schema,
+		"'avro_decode' subject argument must be a string literal")
+	testAvroExprError(t, registry, `avro_decode(val, "unknown")`, schema,
+		"'avro_decode' cannot get avro schema for subject 'unknown': subject 'unknown' not found")
+	testAvroExprError(t, registry, `avro_decode(val, "orders-value", s)`, schema,
+		"'avro_decode' field argument must be a string literal")
+	testAvroExprError(t, registry, `avro_decode(val, "orders-value", "foo")`, schema,
+		"'avro_decode' field 'foo' does not exist in avro schema 'Order'")
+	testAvroExprError(t, registry, `avro_decode(val, "orders-value", "id.foo")`, schema,
+		"'avro_decode' field 'id.foo' does not exist in avro schema 'Order'")
+	testAvroExprError(t, nil, `avro_decode(val, "orders-value")`, schema,
+		"'avro_decode' requires a schema registry - schema-registry-type must be configured")
+
+	// Messages that are not in the wire format, or that have a schema with a different type for the field, fail when
+	// the expression is evaluated
+	e, err := createAvroExpr(registry, `avro_decode(val, "orders-value", "customer")`, schema)
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{"foo"}),
+		createStringCol([]bool{false}, []string{""}))
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "function 'avro_decode' - message is not in the schema registry wire format", err.Error())
+
+	otherID, err := registry.Register("other", schemareg.SchemaTypeAvro,
+		`{"type": "record", "name": "Other", "fields": [{"name": "customer", "type": "long"}]}`)
+	require.NoError(t, err)
+	msg := encodeAvroMessage(t, otherID, `{"type": "record", "name": "Other", "fields": [{"name": "customer", "type": "long"}]}`,
+		map[string]any{"customer": int64(23)})
+	batch = evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{msg}),
+		createStringCol([]bool{false}, []string{""}))
+	// The schema was registered after the expression was created, so evaluation fails fast while it is fetched
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "function 'avro_decode' - cannot get avro schema with id 3: schema is not cached - it is being fetched from the schema registry", err.Error())
+	testutils.WaitUntil(t, func() (bool, error) {
+		_, err = EvalColumn(e, batch)
+		return err.Error() == "function 'avro_decode' - field 'customer' has type int in avro schema with id 3, but string is required", nil
+	})
+
+	batch = evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{string(schemareg.AppendWireHeader(nil, 100))}),
+		createStringCol([]bool{false}, []string{""}))
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "function 'avro_decode' - cannot get avro schema with id 100: schema is not cached - it is being fetched from the schema registry", err.Error())
+}
+
+func TestAvroDecodePrefetchesSubjectSchemas(t *testing.T) {
+	registry, id1, id2 := setupAvroRegistry(t)
+	batch := createAvroTestBatch(t, id1, id2)
+	e, err := createAvroExpr(registry, `avro_decode(val, "orders-value", "customer")`, batch.Schema)
+	require.NoError(t, err)
+	// Messages written with any version of the subject are decoded without calling the registry
+	for i, expected := range []string{"alice", "bob"} {
+		res, null, err := e.EvalString(i, batch)
+		require.NoError(t, err)
+		require.False(t, null)
+		require.Equal(t, expected, res)
+	}
+}
+
+func testAvroExprError(t *testing.T, registry schemareg.Registry, exprStr string, schema *evbatch.EventSchema, errMsg string) {
+	_, err := createAvroExpr(registry, exprStr, schema)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errMsg)
+}
@@ -0,0 +1,449 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	// Embed the time zone database so that to_timezone works on hosts without one installed
+	_ "time/tzdata"
+)
+
+// Date arithmetic, truncation and time zone functions. Timestamps are UTC milliseconds since the epoch, and intervals,
+// e.g. 1h or 7d in the expression grammar, are int milliseconds.
+
+type dateUnit int
+
+const (
+	dateUnitMillisecond dateUnit = iota
+	dateUnitSecond
+	dateUnitMinute
+	dateUnitHour
+	dateUnitDay
+	dateUnitWeek
+	dateUnitMonth
+	dateUnitQuarter
+	dateUnitYear
+)
+
+var dateUnits = map[string]dateUnit{
+	"millisecond": dateUnitMillisecond,
+	"second":      dateUnitSecond,
+	"minute":      dateUnitMinute,
+	"hour":        dateUnitHour,
+	"day":         dateUnitDay,
+	"week":        dateUnitWeek,
+	"month":       dateUnitMonth,
+	"quarter":     dateUnitQuarter,
+	"year":        dateUnitYear,
+}
+
+// fixedUnitMillis is the length of the units which always have the same length in UTC
+var fixedUnitMillis = map[dateUnit]int64{
+	dateUnitMillisecond: 1,
+	dateUnitSecond:      int64(time.Second / time.Millisecond),
+	dateUnitMinute:      int64(time.Minute / time.Millisecond),
+	dateUnitHour:        int64(time.Hour / time.Millisecond),
+	dateUnitDay:         int64(24 * time.Hour / time.Millisecond),
+	dateUnitWeek:        int64(7 * 24 * time.Hour / time.Millisecond),
+}
+
+var errDateAddOutOfRange = errors.New("function 'date_add' - result is out of range")
+
+func parseDateUnitArg(functionName string, argIndex int, argExprs []Expression,
+	desc *parser.FunctionExprDesc) (dateUnit, error) {
+	if _, ok := argExprs[argIndex].(*StringConstantExpr); !ok {
+		return 0, desc.ErrorAtPosition("'%s' %s argument must be a string literal", functionName,
+			ordinals[argIndex])
+	}
+	sUnit, _, _ := argExprs[argIndex].EvalString(0, nil)
+	unit, ok := dateUnits[strings.ToLower(sUnit)]
+	if !ok {
+		return 0, desc.ArgExprs[argIndex].ErrorAtPosition("'%s' %s argument must be one of 'millisecond', 'second', 'minute', 'hour', 'day', 'week', 'month', 'quarter' or 'year'",
+			functionName, ordinals[argIndex])
+	}
+	return unit, nil
+}
+
+func checkTimestampArg(functionName string, argIndex int, argExprs []Expression, desc *parser.FunctionExprDesc) error {
+	if argExprs[argIndex].ResultType() != types.ColumnTypeTimestamp {
+		return desc.ErrorAtPosition("'%s' %s argument must be of type timestamp - it is of type %s", functionName,
+			ordinals[argIndex], argExprs[argIndex].ResultType().String())
+	}
+	return nil
+}
+
+func truncateTime(t time.Time, unit dateUnit) time.Time {
+	switch unit {
+	case dateUnitWeek:
+		// Weeks start on Monday, as in ISO 8601
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+	case dateUnitMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case dateUnitQuarter:
+		month := ((t.Month()-1)/3)*3 + 1
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	case dateUnitYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		unitMillis := fixedUnitMillis[unit]
+		millis := t.UnixMilli()
+		truncated := millis - millis%unitMillis
+		if millis%unitMillis < 0 {
+			truncated -= unitMillis
+		}
+		return time.UnixMilli(truncated).UTC()
+	}
+}
+
+// DateTruncFunction truncates a timestamp to the start of the specified unit, e.g. date_trunc("hour", ts). Weeks
+// start on Monday.
+type DateTruncFunction struct {
+	baseExpr
+	unit      dateUnit
+	tsOperand Expression
+}
+
+func NewDateTruncFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*DateTruncFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'date_trunc' requires 2 arguments - %d found", len(argExprs))
+	}
+	unit, err := parseDateUnitArg("date_trunc", 0, argExprs, desc)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTimestampArg("date_trunc", 1, argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &DateTruncFunction{
+		unit:      unit,
+		tsOperand: argExprs[1],
+	}, nil
+}
+
+func (d *DateTruncFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	ts, null, err := d.tsOperand.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	t := truncateTime(time.UnixMilli(ts.Val).UTC(), d.unit)
+	return types.NewTimestamp(t.UnixMilli()), false, nil
+}
+
+func (d *DateTruncFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeTimestamp
+}
+
+// maxCalendarMonths is the maximum number of months that date_add adds, which is far beyond the range of a timestamp,
+// so the number of months can be converted to an int without overflowing
+const maxCalendarMonths = 12 * 1_000_000_000
+
+var (
+	minTime = time.UnixMilli(math.MinInt64).UTC()
+	maxTime = time.UnixMilli(math.MaxInt64).UTC()
+)
+
+// DateAddFunction adds an interval to a timestamp. With two arguments, e.g. date_add(ts, 1h30m), the interval is an
+// int number of milliseconds. With three, e.g. date_add("month", 1, ts), it is a number of units. Months, quarters and
+// years are calendar units added with time.AddDate, so a day of the month that does not exist in the resulting month
+// overflows into the next, e.g. adding a month to 31st January gives 2nd or 3rd March. The interval can be negative.
+type DateAddFunction struct {
+	baseExpr
+	unit            dateUnit
+	tsOperand       Expression
+	intervalOperand Expression
+}
+
+func NewDateAddFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*DateAddFunction, error) {
+	if len(argExprs) == 3 {
+		unit, err := parseDateUnitArg("date_add", 0, argExprs, desc)
+		if err != nil {
+			return nil, err
+		}
+		if argExprs[1].ResultType() != types.ColumnTypeInt {
+			return nil, desc.ErrorAtPosition("'date_add' second argument must be of type int - it is of type %s",
+				argExprs[1].ResultType().String())
+		}
+		if err := checkTimestampArg("date_add", 2, argExprs, desc); err != nil {
+			return nil, err
+		}
+		return &DateAddFunction{
+			unit:            unit,
+			tsOperand:       argExprs[2],
+			intervalOperand: argExprs[1],
+		}, nil
+	}
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'date_add' requires 2 or 3 arguments - %d found", len(argExprs))
+	}
+	if err := checkTimestampArg("date_add", 0, argExprs, desc); err != nil {
+		return nil, err
+	}
+	if argExprs[1].ResultType() != types.ColumnTypeInt {
+		return nil, desc.ErrorAtPosition("'date_add' second argument must be an interval or of type int - it is of type %s",
+			argExprs[1].ResultType().String())
+	}
+	return &DateAddFunction{
+		unit:            dateUnitMillisecond,
+		tsOperand:       argExprs[0],
+		intervalOperand: argExprs[1],
+	}, nil
+}
+
+func (d *DateAddFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	ts, null, err := d.tsOperand.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	n, null, err := d.intervalOperand.EvalInt(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	if unitMillis, ok := fixedUnitMillis[d.unit]; ok {
+		if n > math.MaxInt64/unitMillis || n < math.MinInt64/unitMillis {
+			return types.Timestamp{}, false, errDateAddOutOfRange
+		}
+		interval := n * unitMillis
+		if (interval > 0 && ts.Val > math.MaxInt64-interval) || (interval < 0 && ts.Val < math.MinInt64-interval) {
+			return types.Timestamp{}, false, errDateAddOutOfRange
+		}
+		return types.NewTimestamp(ts.Val + interval), false, nil
+	}
+	months := n
+	switch d.unit {
+	case dateUnitQuarter:
+		months = n * 3
+	case dateUnitYear:
+		months = n * 12
+	}
+	if n > maxCalendarMonths || n < -maxCalendarMonths || months > maxCalendarMonths || months < -maxCalendarMonths {
+		return types.Timestamp{}, false, errDateAddOutOfRange
+	}
+	t := time.UnixMilli(ts.Val).UTC().AddDate(0, int(months), 0)
+	if t.Before(minTime) || t.After(maxTime) {
+		return types.Timestamp{}, false, errDateAddOutOfRange
+	}
+	return types.NewTimestamp(t.UnixMilli()), false, nil
+}
+
+func (d *DateAddFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeTimestamp
+}
+
+// DateDiffFunction returns the number of whole units from the second timestamp to the third, e.g.
+// date_diff("day", start, end). The result is negative if the third timestamp is before the second.
+type DateDiffFunction struct {
+	baseExpr
+	unit         dateUnit
+	startOperand Expression
+	endOperand   Expression
+}
+
+func NewDateDiffFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*DateDiffFunction, error) {
+	if len(argExprs) != 3 {
+		return nil, desc.ErrorAtPosition("'date_diff' requires 3 arguments - %d found", len(argExprs))
+	}
+	unit, err := parseDateUnitArg("date_diff", 0, argExprs, desc)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTimestampArg("date_diff", 1, argExprs, desc); err != nil {
+		return nil, err
+	}
+	if err := checkTimestampArg("date_diff", 2, argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &DateDiffFunction{
+		unit:         unit,
+		startOperand: argExprs[1],
+		endOperand:   argExprs[2],
+	}, nil
+}
+
+func (d *DateDiffFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	start, null, err := d.startOperand.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	end, null, err := d.endOperand.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	if unitMillis, ok := fixedUnitMillis[d.unit]; ok {
+		return (end.Val - start.Val) / unitMillis, false, nil
+	}
+	months := monthsBetween(time.UnixMilli(start.Val).UTC(), time.UnixMilli(end.Val).UTC())
+	switch d.unit {
+	case dateUnitQuarter:
+		return months / 3, false, nil
+	case dateUnitYear:
+		return months / 12, false, nil
+	default:
+		return months, false, nil
+	}
+}
+
+// monthsBetween returns the number of whole calendar months from start to end. A month is only counted once end has
+// reached the same point in the month as start.
+func monthsBetween(start time.Time, end time.Time) int64 {
+	months := int64(end.Year()-start.Year())*12 + int64(end.Month()-start.Month())
+	startOffset := start.Sub(truncateTime(start, dateUnitMonth))
+	endOffset := end.Sub(truncateTime(end, dateUnitMonth))
+	if months > 0 && endOffset < startOffset {
+		months--
+	} else if months < 0 && endOffset > startOffset {
+		months++
+	}
+	return months
+}
+
+func (d *DateDiffFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+// ToTimezoneFunction converts a UTC timestamp to the wall clock time in the specified IANA time zone, e.g.
+// to_timezone(ts, "Europe/London"). The result is a timestamp whose UTC fields are the local fields in that zone, so
+// it can be passed to functions such as hour or format_date. If the zone is not a constant, the zones are loaded when
+// they are first seen and cached.
+type ToTimezoneFunction struct {
+	baseExpr
+	tsOperand   Expression
+	zoneOperand Expression
+	location    *time.Location
+	locations   sync.Map
+}
+
+func NewToTimezoneFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ToTimezoneFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'to_timezone' requires 2 arguments - %d found", len(argExprs))
+	}
+	if err := checkTimestampArg("to_timezone", 0, argExprs, desc); err != nil {
+		return nil, err
+	}
+	if argExprs[1].ResultType() != types.ColumnTypeString {
+		return nil, desc.ErrorAtPosition("'to_timezone' second argument must be of type string - it is of type %s",
+			argExprs[1].ResultType().String())
+	}
+	var location *time.Location
+	if _, ok := argExprs[1].(*StringConstantExpr); ok {
+		zone, _, _ := argExprs[1].EvalString(0, nil)
+		var err error
+		location, err = time.LoadLocation(zone)
+		if err != nil {
+			return nil, desc.ArgExprs[1].ErrorAtPosition("unknown time zone '%s'", zone)
+		}
+	}
+	return &ToTimezoneFunction{
+		tsOperand:   argExprs[0],
+		zoneOperand: argExprs[1],
+		location:    location,
+	}, nil
+}
+
+func (t *ToTimezoneFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	ts, null, err := t.tsOperand.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	location := t.location
+	if location == nil {
+		zone, null, err := t.zoneOperand.EvalString(rowIndex, batch)
+		if err != nil || null {
+			return types.Timestamp{}, null, err
+		}
+		location, err = t.loadLocation(zone)
+		if err != nil {
+			return types.Timestamp{}, false, err
+		}
+	}
+	_, offsetSecs := time.UnixMilli(ts.Val).In(location).Zone()
+	return types.NewTimestamp(ts.Val + int64(offsetSecs)*1000), false, nil
+}
+
+func (t *ToTimezoneFunction) loadLocation(zone string) (*time.Location, error) {
+	l, ok := t.locations.Load(zone)
+	if ok {
+		return l.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, errors.Errorf("unknown time zone '%s'", zone)
+	}
+	// Only known zones are cached, so the number cached is limited by the number of zones
+	t.locations.Store(zone, location)
+	return location, nil
+}
+
+func (t *ToTimezoneFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeTimestamp
+}
+
+// DayOfWeekFunction returns the ISO 8601 day of the week of a timestamp, from 1 for Monday to 7 for Sunday.
+type DayOfWeekFunction struct {
+	baseExpr
+	arg Expression
+}
+
+func NewDayOfWeekFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*DayOfWeekFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'day_of_week' requires 1 argument - %d found", len(argExprs))
+	}
+	if err := checkTimestampArg("day_of_week", 0, argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &DayOfWeekFunction{
+		arg: argExprs[0],
+	}, nil
+}
+
+func (d *DayOfWeekFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	ts, null, err := d.arg.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	weekday := time.UnixMilli(ts.Val).UTC().Weekday()
+	if weekday == time.Sunday {
+		return 7, false, nil
+	}
+	return int64(weekday), false, nil
+}
+
+func (d *DayOfWeekFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+// WeekOfYearFunction returns the ISO 8601 week number of a timestamp, from 1 to 53.
+type WeekOfYearFunction struct {
+	baseExpr
+	arg Expression
+}
+
+func NewWeekOfYearFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*WeekOfYearFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'week_of_year' requires 1 argument - %d found", len(argExprs))
+	}
+	if err := checkTimestampArg("week_of_year", 0, argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &WeekOfYearFunction{
+		arg: argExprs[0],
+	}, nil
+}
+
+func (w *WeekOfYearFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	ts, null, err := w.arg.EvalTimestamp(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	_, week := time.UnixMilli(ts.Val).UTC().ISOWeek()
+	return int64(week), false, nil
+}
+
+func (w *WeekOfYearFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
@@ -0,0 +1,246 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+func millis(year int, month time.Month, day, hour, min, sec, ms int) int64 {
+	return time.Date(year, month, day, hour, min, sec, ms*1000000, time.UTC).UnixMilli()
+}
+
+func TestDateTruncFunction(t *testing.T) {
+	// Thursday
+	ts := millis(2024, 8, 15, 13, 47, 29, 123)
+	argCol := createTimestampCol([]bool{false, true}, []int64{ts, 0})
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	expected := map[string]int64{
+		"millisecond": ts,
+		"second":      millis(2024, 8, 15, 13, 47, 29, 0),
+		"minute":      millis(2024, 8, 15, 13, 47, 0, 0),
+		"HOUR":        millis(2024, 8, 15, 13, 0, 0, 0),
+		"day":         millis(2024, 8, 15, 0, 0, 0, 0),
+		"week":        millis(2024, 8, 12, 0, 0, 0, 0),
+		"month":       millis(2024, 8, 1, 0, 0, 0, 0),
+		"quarter":     millis(2024, 7, 1, 0, 0, 0, 0),
+		"year":        millis(2024, 1, 1, 0, 0, 0, 0),
+	}
+	for unit, exp := range expected {
+		testStringFunction(t, NewDateTruncFunction, argCol, []Expression{NewStringConstantExpr(unit), tsArg},
+			createTimestampCol([]bool{false, true}, []int64{exp, 0}))
+	}
+}
+
+func TestDateTruncFunctionBeforeEpoch(t *testing.T) {
+	argCol := createTimestampCol([]bool{false}, []int64{millis(1969, 12, 31, 23, 30, 0, 0)})
+	testStringFunction(t, NewDateTruncFunction, argCol, []Expression{NewStringConstantExpr("hour"),
+		&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}},
+		createTimestampCol([]bool{false}, []int64{millis(1969, 12, 31, 23, 0, 0, 0)}))
+}
+
+func TestDateTruncFunctionArgs(t *testing.T) {
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	_, err := NewDateTruncFunction([]Expression{tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_trunc' requires 2 arguments - 1 found")
+	_, err = NewDateTruncFunction([]Expression{&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeString}, tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_trunc' first argument must be a string literal")
+	_, err = NewDateTruncFunction([]Expression{NewStringConstantExpr("fortnight"), tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_trunc' first argument must be one of 'millisecond', 'second', 'minute', 'hour', 'day', 'week', 'month', 'quarter' or 'year'")
+	_, err = NewDateTruncFunction([]Expression{NewStringConstantExpr("day"), NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'date_trunc' second argument must be of type timestamp - it is of type int")
+}
+
+func TestDateAddFunction(t *testing.T) {
+	ts := millis(2024, 2, 28, 22, 0, 0, 0)
+	argCol := createTimestampCol([]bool{false, true}, []int64{ts, 0})
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{tsArg, NewIntegerConstantExpr((1*24*time.Hour + 3*time.Hour).Milliseconds())},
+		createTimestampCol([]bool{false, true}, []int64{millis(2024, 3, 1, 1, 0, 0, 0), 0}))
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{tsArg, NewIntegerConstantExpr(-time.Hour.Milliseconds())},
+		createTimestampCol([]bool{false, true}, []int64{millis(2024, 2, 28, 21, 0, 0, 0), 0}))
+
+	_, err := NewDateAddFunction([]Expression{tsArg, NewStringConstantExpr("1h")}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' second argument must be an interval or of type int - it is of type string")
+	_, err = NewDateAddFunction([]Expression{NewIntegerConstantExpr(1), NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' first argument must be of type timestamp - it is of type int")
+	_, err = NewDateAddFunction([]Expression{tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' requires 2 or 3 arguments - 1 found")
+}
+
+func TestDateAddFunctionWithUnit(t *testing.T) {
+	argCol := createTimestampCol([]bool{false, false, true}, []int64{millis(2024, 1, 31, 10, 0, 0, 0),
+		millis(2023, 11, 15, 10, 0, 0, 0), 0})
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{NewStringConstantExpr("day"), NewIntegerConstantExpr(7), tsArg},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2024, 2, 7, 10, 0, 0, 0), millis(2023, 11, 22, 10, 0, 0, 0), 0}))
+	// Calendar units overflow into the next month if the day does not exist in the month
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{NewStringConstantExpr("month"), NewIntegerConstantExpr(1), tsArg},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2024, 3, 2, 10, 0, 0, 0), millis(2023, 12, 15, 10, 0, 0, 0), 0}))
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{NewStringConstantExpr("quarter"), NewIntegerConstantExpr(-1), tsArg},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2023, 10, 31, 10, 0, 0, 0), millis(2023, 8, 15, 10, 0, 0, 0), 0}))
+	testStringFunction(t, NewDateAddFunction, argCol, []Expression{NewStringConstantExpr("YEAR"), NewIntegerConstantExpr(2), tsArg},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2026, 1, 31, 10, 0, 0, 0), millis(2025, 11, 15, 10, 0, 0, 0), 0}))
+
+	_, err := NewDateAddFunction([]Expression{NewStringConstantExpr("fortnight"), NewIntegerConstantExpr(1), tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' first argument must be one of 'millisecond', 'second', 'minute', 'hour', 'day', 'week', 'month', 'quarter' or 'year'")
+	_, err = NewDateAddFunction([]Expression{NewStringConstantExpr("day"), NewStringConstantExpr("1"), tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' second argument must be of type int - it is of type string")
+	_, err = NewDateAddFunction([]Expression{NewStringConstantExpr("day"), NewIntegerConstantExpr(1), NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'date_add' third argument must be of type timestamp - it is of type int")
+}
+
+func TestDateAddFunctionOutOfRange(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeTimestamp})
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	for _, args := range [][]Expression{
+		{tsArg, NewIntegerConstantExpr(math.MaxInt64)},
+		{NewStringConstantExpr("day"), NewIntegerConstantExpr(math.MaxInt64 / 1000), tsArg},
+		{NewStringConstantExpr("week"), NewIntegerConstantExpr(math.MinInt64/(7*24*time.Hour).Milliseconds() - 1), tsArg},
+		{NewStringConstantExpr("year"), NewIntegerConstantExpr(300_000_000), tsArg},
+		{NewStringConstantExpr("month"), NewIntegerConstantExpr(math.MinInt64), tsArg},
+	} {
+		fun, err := NewDateAddFunction(args, stringFuncDesc)
+		require.NoError(t, err)
+		_, err = EvalColumn(fun, evbatch.NewBatch(schema, createTimestampCol([]bool{false}, []int64{millis(2024, 1, 1, 0, 0, 0, 0)})))
+		require.Error(t, err)
+		require.Equal(t, "function 'date_add' - result is out of range", err.Error())
+	}
+}
+
+func TestDateAddFunctionWithIntervalLiteral(t *testing.T) {
+	input := "date_add(f0, 1h30m)"
+	tokens, err := parser.Lex(input, true)
+	require.NoError(t, err)
+	p := parser.NewParser(nil)
+	desc, err := p.ParseExpression(parser.NewParseContext(p, input, tokens))
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"f0"}, []types.ColumnType{types.ColumnTypeTimestamp})
+	expr, err := (&ExpressionFactory{}).CreateExpression(desc, schema)
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(schema, createTimestampCol([]bool{false}, []int64{millis(2024, 1, 1, 23, 0, 0, 0)}))
+	res, err := EvalColumn(expr, batch)
+	require.NoError(t, err)
+	colsEqual(t, createTimestampCol([]bool{false}, []int64{millis(2024, 1, 2, 0, 30, 0, 0)}), res)
+}
+
+func TestDateDiffFunction(t *testing.T) {
+	start := millis(2024, 1, 31, 12, 0, 0, 0)
+	ends := []int64{
+		millis(2024, 1, 31, 12, 0, 0, 0),
+		millis(2024, 2, 29, 12, 0, 0, 0),
+		millis(2024, 3, 31, 12, 0, 0, 0),
+		millis(2025, 1, 31, 11, 0, 0, 0),
+		millis(2023, 12, 31, 11, 59, 59, 999),
+		0,
+	}
+	nulls := []bool{false, false, false, false, false, true}
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeTimestamp})
+	startCol := createTimestampCol(nulls, []int64{start, start, start, start, start, start})
+	endCol := createTimestampCol(nulls, ends)
+	expected := map[string][]int64{
+		"second":  {0, 2505600, 5184000, 31618800, -2678400, 0},
+		"hour":    {0, 696, 1440, 8783, -744, 0},
+		"day":     {0, 29, 60, 365, -31, 0},
+		"week":    {0, 4, 8, 52, -4, 0},
+		"month":   {0, 0, 2, 11, -1, 0},
+		"quarter": {0, 0, 0, 3, 0, 0},
+		"year":    {0, 0, 0, 0, 0, 0},
+	}
+	for unit, exp := range expected {
+		fun, err := NewDateDiffFunction([]Expression{NewStringConstantExpr(unit),
+			&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp},
+			&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeTimestamp}}, stringFuncDesc)
+		require.NoError(t, err)
+		res, err := EvalColumn(fun, evbatch.NewBatch(schema, startCol, endCol))
+		require.NoError(t, err)
+		colsEqual(t, createIntCol(nulls, exp), res)
+	}
+}
+
+func TestDateDiffFunctionArgs(t *testing.T) {
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	_, err := NewDateDiffFunction([]Expression{NewStringConstantExpr("day"), tsArg}, stringFuncDesc)
+	requireStatementError(t, err, "'date_diff' requires 3 arguments - 2 found")
+	_, err = NewDateDiffFunction([]Expression{NewStringConstantExpr("day"), tsArg, NewStringConstantExpr("x")}, stringFuncDesc)
+	requireStatementError(t, err, "'date_diff' third argument must be of type timestamp - it is of type string")
+}
+
+func TestToTimezoneFunction(t *testing.T) {
+	winter := millis(2024, 1, 15, 12, 0, 0, 0)
+	summer := millis(2024, 7, 15, 12, 0, 0, 0)
+	argCol := createTimestampCol([]bool{false, false, true}, []int64{winter, summer, 0})
+	tsArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp}
+	fun, err := NewToTimezoneFunction([]Expression{tsArg, NewStringConstantExpr("Europe/London")}, stringFuncDesc)
+	require.NoError(t, err)
+	require.NotNil(t, fun.location)
+	testStringFunction(t, NewToTimezoneFunction, argCol, []Expression{tsArg, NewStringConstantExpr("Europe/London")},
+		createTimestampCol([]bool{false, false, true}, []int64{winter, millis(2024, 7, 15, 13, 0, 0, 0), 0}))
+	testStringFunction(t, NewToTimezoneFunction, argCol, []Expression{tsArg, NewStringConstantExpr("America/New_York")},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2024, 1, 15, 7, 0, 0, 0), millis(2024, 7, 15, 8, 0, 0, 0), 0}))
+	testStringFunction(t, NewToTimezoneFunction, argCol, []Expression{tsArg, NewStringConstantExpr("Asia/Kolkata")},
+		createTimestampCol([]bool{false, false, true}, []int64{millis(2024, 1, 15, 17, 30, 0, 0), millis(2024, 7, 15, 17, 30, 0, 0), 0}))
+
+	_, err = NewToTimezoneFunction([]Expression{tsArg, NewStringConstantExpr("Mars/Olympus_Mons")}, stringFuncDesc)
+	requireStatementError(t, err, "unknown time zone 'Mars/Olympus_Mons'")
+}
+
+func TestToTimezoneFunctionNonConstantZone(t *testing.T) {
+	fun, err := NewToTimezoneFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeTimestamp},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeString}}, stringFuncDesc)
+	require.NoError(t, err)
+	require.Nil(t, fun.location)
+	ts := millis(2024, 7, 15, 12, 0, 0, 0)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeString})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, createTimestampCol([]bool{false, false, false}, []int64{ts, ts, ts}),
+		createStringCol([]bool{false, false, true}, []string{"UTC", "Europe/Paris", ""})))
+	require.NoError(t, err)
+	colsEqual(t, createTimestampCol([]bool{false, false, true}, []int64{ts, millis(2024, 7, 15, 14, 0, 0, 0), 0}), res)
+
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createTimestampCol([]bool{false}, []int64{ts}),
+		createStringCol([]bool{false}, []string{"Nowhere"})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown time zone 'Nowhere'")
+
+	// Known zones are cached once loaded
+	_, ok := fun.locations.Load("Europe/Paris")
+	require.True(t, ok)
+	_, ok = fun.locations.Load("Nowhere")
+	require.False(t, ok)
+}
+
+func TestDayOfWeekFunction(t *testing.T) {
+	// 2024-01-01 was a Monday
+	var vals []int64
+	var exp []int64
+	for i := 0; i < 7; i++ {
+		vals = append(vals, millis(2024, 1, 1+i, 10, 0, 0, 0))
+		exp = append(exp, int64(i+1))
+	}
+	nulls := make([]bool, 8)
+	nulls[7] = true
+	testMathFunction(t, NewDayOfWeekFunction, createTimestampCol(nulls, append(vals, 0)), types.ColumnTypeTimestamp, nil,
+		createIntCol(nulls, append(exp, 0)))
+
+	_, err := NewDayOfWeekFunction([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'day_of_week' first argument must be of type timestamp - it is of type int")
+}
+
+func TestWeekOfYearFunction(t *testing.T) {
+	argCol := createTimestampCol([]bool{false, false, false, false, true}, []int64{
+		millis(2024, 1, 1, 0, 0, 0, 0),
+		millis(2024, 12, 30, 0, 0, 0, 0),
+		millis(2021, 1, 3, 0, 0, 0, 0),
+		millis(2020, 12, 31, 0, 0, 0, 0),
+		0,
+	})
+	testMathFunction(t, NewWeekOfYearFunction, argCol, types.ColumnTypeTimestamp, nil,
+		createIntCol([]bool{false, false, false, false, true}, []int64{1, 1, 53, 53, 0}))
+
+	_, err := NewWeekOfYearFunction(nil, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'week_of_year' requires 1 argument - 0 found")
+}
@@ -21,6 +21,8 @@ func EvalColumn(expr Expression, batch *evbatch.Batch) (evbatch.Column, error) {
 		return evalBytesOnBatch(expr, batch)
 	case types.ColumnTypeIDTimestamp:
 		return evalTimestampOnBatch(expr, batch)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return evalNestedOnBatch(expr, batch)
 	default:
 		panic("unexpected column type")
 	}
@@ -144,3 +146,20 @@ func evalTimestampOnBatch(expr Expression, batch *evbatch.Batch) (evbatch.Column
 	}
 	return builder.BuildTimestampColumn(), nil
 }
+
+func evalNestedOnBatch(expr Expression, batch *evbatch.Batch) (evbatch.Column, error) {
+	builder := evbatch.NewNestedColBuilder(expr.ResultType())
+	rc := batch.RowCount
+	for i := 0; i < rc; i++ {
+		val, null, err := expr.EvalNested(i, batch)
+		if err != nil {
+			return nil, err
+		}
+		if null {
+			builder.AppendNull()
+		} else {
+			builder.Append(val)
+		}
+	}
+	return builder.BuildNestedColumn(), nil
+}
@@ -5,8 +5,10 @@ import (
 	"github.com/spirit-labs/tektite/errors"
 	"github.com/spirit-labs/tektite/evbatch"
 	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/schemareg"
 	"github.com/spirit-labs/tektite/types"
 	"strings"
+	"time"
 )
 
 type Expression interface {
@@ -17,6 +19,9 @@ type Expression interface {
 	EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error)
 	EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error)
 	EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error)
+	// EvalNested evaluates an expression with an array, map or struct result type. See types.ArrayType,
+	// types.MapType and types.StructType for how values are represented.
+	EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error)
 	ResultType() types.ColumnType
 }
 
@@ -68,7 +73,7 @@ func (f *FunctionMetadata) UnmarshalJSON(buff []byte) error {
 		if !ok {
 			return errors.Error("paramTypes must be strings")
 		}
-		paramType, err := types.StringToColumnType(pTypeStr)
+		paramType, err := stringToExternalFunctionType(pTypeStr)
 		if err != nil {
 			return err
 		}
@@ -82,7 +87,7 @@ func (f *FunctionMetadata) UnmarshalJSON(buff []byte) error {
 	if !ok {
 		return errors.Error("'returnType' field must contain a string")
 	}
-	returnType, err := types.StringToColumnType(retTypeStr)
+	returnType, err := stringToExternalFunctionType(retTypeStr)
 	if err != nil {
 		return err
 	}
@@ -91,12 +96,51 @@ func (f *FunctionMetadata) UnmarshalJSON(buff []byte) error {
 	return nil
 }
 
+// stringToExternalFunctionType parses a parameter or return type of an external function. Array, map and struct
+// types cannot be passed to or returned from external functions.
+func stringToExternalFunctionType(sType string) (types.ColumnType, error) {
+	colType, err := types.StringToColumnType(sType)
+	if err != nil {
+		return nil, err
+	}
+	if types.IsNestedType(colType) {
+		return nil, errors.Errorf("external functions do not support type '%s'", sType)
+	}
+	return colType, nil
+}
+
 type ExternalInvoker interface {
 	Invoke(args []any) (any, error)
 }
 
+// ExternalAggregateInvokerFactory is implemented by providers of user defined aggregate functions, e.g. WASM modules.
+// Aggregate function metadata has exactly one parameter type - the type of the aggregated expression.
+type ExternalAggregateInvokerFactory interface {
+	GetAggregateFunctionMetadata(fullFunctionName string) (FunctionMetadata, bool)
+	CreateExternalAggregateInvoker(fullFunctionName string) (ExternalAggregateInvoker, error)
+}
+
+// ExternalAggregateInvoker invokes a user defined aggregate function. The aggregation state is opaque to Tektite, it is
+// created by Init, updated by Accumulate and Merge, and converted to the aggregate result by Finish.
+type ExternalAggregateInvoker interface {
+	Init() ([]byte, error)
+	Accumulate(state []byte, arg any) ([]byte, error)
+	Merge(state1 []byte, state2 []byte) ([]byte, error)
+	Finish(state []byte) (any, error)
+}
+
 type ExpressionFactory struct {
 	ExternalInvokerFactory ExternalInvokerFactory
+	// SchemaRegistry provides the schemas for the functions that decode and encode Avro. It is nil if no schema
+	// registry is configured.
+	SchemaRegistry schemareg.Registry
+	// ProtobufMessages provides the message types for the functions that decode and encode protobuf.
+	ProtobufMessages ProtobufMessageResolver
+	// TableResolver provides the tables read by the lookup function. LookupCacheMaxSize and LookupCacheTTL configure
+	// the cache of values read by each lookup expression.
+	TableResolver      TableResolver
+	LookupCacheMaxSize int
+	LookupCacheTTL     time.Duration
 }
 
 func (f *ExpressionFactory) CreateExpression(desc parser.ExprDesc, schema *evbatch.EventSchema) (Expression, error) {
@@ -105,6 +149,9 @@ func (f *ExpressionFactory) CreateExpression(desc parser.ExprDesc, schema *evbat
 		return NewIntegerConstantExpr(int64(op.Value)), nil
 	case *parser.FloatConstExprDesc:
 		return NewFloatConstantExpr(op.Value), nil
+	case *parser.DurationConstExprDesc:
+		// Durations are represented as an int number of milliseconds
+		return NewIntegerConstantExpr(op.Value.Milliseconds()), nil
 	case *parser.BoolConstExprDesc:
 		return NewBoolConstantExpr(op.Value), nil
 	case *parser.StringConstExprDesc:
@@ -191,15 +238,7 @@ func (f *ExpressionFactory) createUnaryOperator(desc *parser.UnaryOperatorExprDe
 }
 
 func resultTypesEqual(leftType types.ColumnType, rightType types.ColumnType) bool {
-	if leftType.ID() != rightType.ID() {
-		return false
-	}
-	if leftType.ID() == types.ColumnTypeIDDecimal {
-		ld := leftType.(*types.DecimalType)
-		rd := rightType.(*types.DecimalType)
-		return ld.Scale == rd.Scale && ld.Precision == rd.Precision
-	}
-	return true
+	return types.ColumnTypesEqual(leftType, rightType)
 }
 
 func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc, schema *evbatch.EventSchema) (Expression, error) {
@@ -211,6 +250,10 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		}
 		args[i] = argExpr
 	}
+	if desc.Aggregate {
+		return nil, desc.ErrorAtPosition("aggregate function '%s' can only be used in an 'aggregate' operator",
+			desc.FunctionName)
+	}
 	switch desc.FunctionName {
 	case "if":
 		return NewIfFunction(args, desc)
@@ -220,6 +263,10 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		return NewIsNotNullFunction(args, desc)
 	case "in":
 		return NewInFunction(args, desc)
+	case "coalesce":
+		return NewCoalesceFunction(args, desc)
+	case "nullif":
+		return NewNullIfFunction(args, desc)
 	case "case":
 		return NewCaseFunction(args, desc)
 	case "decimal_shift":
@@ -250,6 +297,22 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		return NewReplaceFunction(args, desc)
 	case "sprintf":
 		return NewSprintfFunction(args, desc)
+	case "regexp_extract":
+		return NewRegexpExtractFunction(args, desc)
+	case "regexp_replace":
+		return NewRegexpReplaceFunction(args, desc)
+	case "split":
+		return NewSplitFunction(args, desc)
+	case "split_part":
+		return NewSplitPartFunction(args, desc)
+	case "lpad":
+		return NewLPadFunction(args, desc)
+	case "rpad":
+		return NewRPadFunction(args, desc)
+	case "index_of":
+		return NewIndexOfFunction(args, desc)
+	case "url_parse":
+		return NewURLParseFunction(args, desc)
 	case "to_int":
 		return NewToIntFunction(args, desc)
 	case "to_float":
@@ -282,6 +345,18 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		return NewMillisFunction(args, desc)
 	case "now":
 		return NewNowFunction(args, desc)
+	case "date_trunc":
+		return NewDateTruncFunction(args, desc)
+	case "date_add":
+		return NewDateAddFunction(args, desc)
+	case "date_diff":
+		return NewDateDiffFunction(args, desc)
+	case "to_timezone":
+		return NewToTimezoneFunction(args, desc)
+	case "day_of_week":
+		return NewDayOfWeekFunction(args, desc)
+	case "week_of_year":
+		return NewWeekOfYearFunction(args, desc)
 	case "json_int":
 		return NewJsonIntFunction(args, desc)
 	case "json_float":
@@ -296,6 +371,16 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		return NewJsonIsNullFunction(args, desc)
 	case "json_type":
 		return NewJsonTypeFunction(args, desc)
+	case "json_object":
+		return NewJsonObjectFunction(args, desc)
+	case "json_array":
+		return NewJsonArrayFunction(args, desc)
+	case "to_json":
+		return NewToJsonFunction(args, desc)
+	case "json_query":
+		return NewJsonQueryFunction(args, desc)
+	case "parse_json":
+		return NewParseJsonFunction(args, desc)
 	case "kafka_build_headers":
 		return NewKafkaBuildHeadersFunction(args, desc)
 	case "kafka_header":
@@ -308,6 +393,71 @@ func (f *ExpressionFactory) createFunctionOperator(desc *parser.FunctionExprDesc
 		return NewUint64LEFunction(args, desc)
 	case "abs":
 		return NewAbsFunction(args, desc)
+	case "round":
+		return NewRoundFunction(args, desc)
+	case "floor":
+		return NewFloorFunction(args, desc)
+	case "ceil":
+		return NewCeilFunction(args, desc)
+	case "pow":
+		return NewPowFunction(args, desc)
+	case "sqrt":
+		return NewSqrtFunction(args, desc)
+	case "log":
+		return NewLogFunction(args, desc)
+	case "exp":
+		return NewExpFunction(args, desc)
+	case "sign":
+		return NewSignFunction(args, desc)
+	case "greatest":
+		return NewGreatestFunction(args, desc)
+	case "least":
+		return NewLeastFunction(args, desc)
+	case "md5":
+		return NewMd5Function(args, desc)
+	case "sha256":
+		return NewSha256Function(args, desc)
+	case "murmur3":
+		return NewMurmur3Function(args, desc)
+	case "xxhash":
+		return NewXxHashFunction(args, desc)
+	case "base64_encode":
+		return NewBase64EncodeFunction(args, desc)
+	case "base64_decode":
+		return NewBase64DecodeFunction(args, desc)
+	case "hex":
+		return NewHexFunction(args, desc)
+	case "uuid_v4":
+		return NewUuidV4Function(args, desc)
+	case "avro_decode":
+		return NewAvroDecodeFunction(args, desc, f.SchemaRegistry)
+	case "avro_encode":
+		return NewAvroEncodeFunction(args, desc, f.SchemaRegistry)
+	case "protobuf_decode":
+		return NewProtobufDecodeFunction(args, desc, f.ProtobufMessages)
+	case "protobuf_encode":
+		return NewProtobufEncodeFunction(args, desc, f.ProtobufMessages)
+	case "lookup":
+		return NewLookupFunction(args, desc, f.TableResolver, f.LookupCacheMaxSize, f.LookupCacheTTL)
+	case "array":
+		return NewArrayFunction(args, desc)
+	case "map":
+		return NewMapFunction(args, desc)
+	case "named_struct":
+		return NewNamedStructFunction(args, desc)
+	case "element_at":
+		return NewElementAtFunction(args, desc)
+	case "get_field":
+		return NewGetFieldFunction(args, desc)
+	case "size":
+		return NewSizeFunction(args, desc)
+	case "map_keys":
+		return NewMapKeysFunction(args, desc)
+	case "map_values":
+		return NewMapValuesFunction(args, desc)
+	case "explode":
+		// explode changes the number of rows so it is provided by the unnest operator rather than as a function
+		return nil, desc.ErrorAtPosition("'explode' cannot be used in an expression as it produces multiple rows - use the 'unnest' operator instead, e.g. (unnest line_items as item)")
 	default:
 		// External function
 		return NewExternalFunction(args, desc, f.ExternalInvokerFactory)
@@ -379,6 +529,19 @@ func (c *ColumnExpr) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Ti
 	return col.Get(rowIndex), false, nil
 }
 
+func (c *ColumnExpr) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	col := batch.GetNestedColumn(c.colIndex)
+	if col.IsNull(rowIndex) {
+		return nil, true, nil
+	}
+	return col.Get(rowIndex), false, nil
+}
+
+// ColIndex returns the index of the column in the incoming schema.
+func (c *ColumnExpr) ColIndex() int {
+	return c.colIndex
+}
+
 func (c *ColumnExpr) ResultType() types.ColumnType {
 	return c.exprType
 }
@@ -489,3 +652,7 @@ func (b *baseExpr) EvalBytes(_ int, _ *evbatch.Batch) ([]byte, bool, error) {
 func (b *baseExpr) EvalTimestamp(_ int, _ *evbatch.Batch) (types.Timestamp, bool, error) {
 	panic("not supported")
 }
+
+func (b *baseExpr) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
@@ -129,6 +129,10 @@ func (e *ExternalFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (ty
 	return r.(types.Timestamp), false, nil
 }
 
+func (e *ExternalFunction) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
 func (e *ExternalFunction) ResultType() types.ColumnType {
 	return e.returnType
 }
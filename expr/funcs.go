@@ -248,6 +248,20 @@ func (i *IfFunction) EvalTimestamp(rowIndex int, inBatch *evbatch.Batch) (types.
 	}
 }
 
+func (i *IfFunction) EvalNested(rowIndex int, inBatch *evbatch.Batch) (any, bool, error) {
+	testVal, null, err := i.testExpr.EvalBool(rowIndex, inBatch)
+	if err != nil {
+		return nil, false, err
+	}
+	if null {
+		return nil, true, nil
+	}
+	if testVal {
+		return i.trueExpr.EvalNested(rowIndex, inBatch)
+	}
+	return i.falseExpr.EvalNested(rowIndex, inBatch)
+}
+
 func (i *IfFunction) Eval() (evbatch.Column, error) {
 	panic("not supported")
 }
@@ -290,6 +304,8 @@ func (in *IsNullFunction) EvalBool(rowIndex int, inBatch *evbatch.Batch) (bool,
 		_, null, err = in.operand.EvalBytes(rowIndex, inBatch)
 	case types.ColumnTypeIDTimestamp:
 		_, null, err = in.operand.EvalTimestamp(rowIndex, inBatch)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		_, null, err = in.operand.EvalNested(rowIndex, inBatch)
 	default:
 		panic("unexpected column type")
 	}
@@ -337,6 +353,8 @@ func (in *IsNotNullFunction) EvalBool(rowIndex int, inBatch *evbatch.Batch) (boo
 		_, null, err = in.operand.EvalBytes(rowIndex, inBatch)
 	case types.ColumnTypeIDTimestamp:
 		_, null, err = in.operand.EvalTimestamp(rowIndex, inBatch)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		_, null, err = in.operand.EvalNested(rowIndex, inBatch)
 	default:
 		panic("unexpected column type")
 	}
@@ -350,6 +368,95 @@ func (in *IsNotNullFunction) ResultType() types.ColumnType {
 	return types.ColumnTypeBool
 }
 
+// CoalesceFunction returns the first of its arguments which is not null, or null if they are all null.
+type CoalesceFunction struct {
+	nestedElementExpr
+	argExprs []Expression
+}
+
+func NewCoalesceFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*CoalesceFunction, error) {
+	if len(argExprs) < 1 {
+		return nil, desc.ErrorAtPosition("'coalesce' requires at least 1 argument - 0 found")
+	}
+	resultType := argExprs[0].ResultType()
+	for i := 1; i < len(argExprs); i++ {
+		if !types.ColumnTypesEqual(resultType, argExprs[i].ResultType()) {
+			return nil, desc.ErrorAtPosition("'coalesce' arguments must have same type - first arg has type %s - arg at position %d has type %s",
+				resultType.String(), i, argExprs[i].ResultType().String())
+		}
+	}
+	c := &CoalesceFunction{argExprs: argExprs}
+	c.elemType = resultType
+	c.evalElement = c.eval
+	return c, nil
+}
+
+func (c *CoalesceFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	for _, argExpr := range c.argExprs {
+		val, null, err := evalAny(argExpr, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		if !null {
+			return val, false, nil
+		}
+	}
+	return nil, true, nil
+}
+
+// NullIfFunction returns null if its two arguments are equal, otherwise it returns the first argument.
+type NullIfFunction struct {
+	nestedElementExpr
+	operand1 Expression
+	operand2 Expression
+}
+
+func NewNullIfFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*NullIfFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'nullif' requires 2 arguments - %d found", len(argExprs))
+	}
+	resultType := argExprs[0].ResultType()
+	if !types.ColumnTypesEqual(resultType, argExprs[1].ResultType()) {
+		return nil, desc.ErrorAtPosition("'nullif' arguments must be of same type - first is %s and second is %s",
+			resultType.String(), argExprs[1].ResultType().String())
+	}
+	if types.IsNestedType(resultType) {
+		return nil, desc.ErrorAtPosition("'nullif' arguments cannot be of type %s", resultType.String())
+	}
+	n := &NullIfFunction{operand1: argExprs[0], operand2: argExprs[1]}
+	n.elemType = resultType
+	n.evalElement = n.eval
+	return n, nil
+}
+
+func (n *NullIfFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val1, null, err := evalAny(n.operand1, rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	val2, null, err := evalAny(n.operand2, rowIndex, batch)
+	if err != nil {
+		return nil, false, err
+	}
+	if null {
+		return val1, false, nil
+	}
+	var equal bool
+	switch v1 := val1.(type) {
+	case types.Decimal:
+		v2 := val2.(types.Decimal)
+		equal = v1.Equals(&v2)
+	case []byte:
+		equal = bytes.Equal(v1, val2.([]byte))
+	default:
+		equal = val1 == val2
+	}
+	if equal {
+		return nil, true, nil
+	}
+	return val1, false, nil
+}
+
 type InFunction struct {
 	baseExpr
 	testOperand Expression
@@ -362,6 +469,9 @@ func NewInFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*InFun
 		return nil, desc.ErrorAtPosition("'in' function requires at least 3 arguments - %d found", len(argExprs))
 	}
 	operType := argExprs[0].ResultType()
+	if types.IsNestedType(operType) {
+		return nil, desc.ErrorAtPosition("'in' function arguments cannot be of type %s", operType.String())
+	}
 	for i, argExpr := range argExprs[1:] {
 		if argExpr.ResultType().ID() != operType.ID() {
 			return nil, desc.ErrorAtPosition("'in' function arguments must have same type - first arg has type %s - arg at position %d has type %s",
@@ -560,6 +670,9 @@ func NewCaseFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*Cas
 		return nil, desc.ErrorAtPosition("'case' function requires an even number of arguments - %d found", len(argExprs))
 	}
 	testExpr := argExprs[0]
+	if types.IsNestedType(testExpr.ResultType()) {
+		return nil, desc.ErrorAtPosition("'case' function test expression cannot be of type %s", testExpr.ResultType().String())
+	}
 	defaultExpr := argExprs[len(argExprs)-1]
 	var caseExprs []Expression
 	var retExprs []Expression
@@ -570,7 +683,8 @@ func NewCaseFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*Cas
 				testExpr.ResultType().String(), len(caseExprs), caseExpr.ResultType().String())
 		}
 		retExpr := argExprs[i+1]
-		if retExpr.ResultType().ID() != defaultExpr.ResultType().ID() {
+		if retExpr.ResultType().ID() != defaultExpr.ResultType().ID() ||
+			(types.IsNestedType(retExpr.ResultType()) && !types.ColumnTypesEqual(retExpr.ResultType(), defaultExpr.ResultType())) {
 			return nil, desc.ErrorAtPosition("'case' function return expressions must have same type as default expression - default expression has type %s - arg at position %d has type %s",
 				defaultExpr.ResultType().String(), len(retExprs), retExpr.ResultType().String())
 		}
@@ -927,6 +1041,20 @@ func (c *CaseFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.
 	return retVal, null, nil
 }
 
+func (c *CaseFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	matchingIndex, null, err := c.getMatchingIndex(rowIndex, batch)
+	if err != nil {
+		return nil, false, err
+	}
+	if null {
+		return nil, true, nil
+	}
+	if matchingIndex == -1 {
+		return c.defaultExpr.EvalNested(rowIndex, batch)
+	}
+	return c.retExprs[matchingIndex].EvalNested(rowIndex, batch)
+}
+
 func (c *CaseFunction) ResultType() types.ColumnType {
 	return c.resultType
 }
@@ -1689,6 +1817,9 @@ func (d *SprintfFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string
 			var vt types.Timestamp
 			vt, null, err = argExpr.EvalTimestamp(rowIndex, batch)
 			val = vt.Val
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			// nested values are formatted as JSON
+			val, null, err = evalNestedJSON(argExpr, rowIndex, batch)
 		default:
 			panic("unknown type")
 		}
@@ -1971,6 +2102,8 @@ func (d *ToStringFunction) EvalString(rowIndex int, batch *evbatch.Batch) (strin
 			return "", true, nil
 		}
 		return strconv.Itoa(int(t.Val)), false, nil
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return evalNestedJSON(d.oper, rowIndex, batch)
 	default:
 		panic("unknown type")
 	}
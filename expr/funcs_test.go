@@ -3640,6 +3640,85 @@ func TestAbsFunctionArgs(t *testing.T) {
 	require.True(t, strings.Contains(err.Error(), "'abs' argument must be of type int, float or decimal - it is of type string"))
 }
 
+func TestCoalesceFunction(t *testing.T) {
+	col0 := createIntCol([]bool{false, true, true, true}, []int64{1, 0, 0, 0})
+	col1 := createIntCol([]bool{false, false, true, true}, []int64{2, 3, 0, 0})
+	col2 := createIntCol([]bool{false, false, false, true}, []int64{4, 5, 6, 0})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeInt},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeInt},
+		&ColumnExpr{colIndex: 2, exprType: types.ColumnTypeInt}}
+	fun, err := NewCoalesceFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeInt, fun.ResultType())
+	schema := evbatch.NewEventSchema([]string{"c0", "c1", "c2"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt, types.ColumnTypeInt})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, col0, col1, col2))
+	require.NoError(t, err)
+	colsEqual(t, createIntCol([]bool{false, false, false, true}, []int64{1, 3, 6, 0}), res)
+}
+
+func TestCoalesceFunctionString(t *testing.T) {
+	col0 := createStringCol([]bool{false, true}, []string{"a", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewStringConstantExpr("default")}
+	fun, err := NewCoalesceFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeString})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, col0))
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false}, []string{"a", "default"}), res)
+}
+
+func TestCoalesceFunctionArgs(t *testing.T) {
+	_, err := NewCoalesceFunction(nil, &parser.FunctionExprDesc{})
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.StatementError))
+	require.True(t, strings.Contains(err.Error(), "'coalesce' requires at least 1 argument - 0 found"))
+
+	_, err = NewCoalesceFunction([]Expression{NewIntegerConstantExpr(1), NewStringConstantExpr("a")}, &parser.FunctionExprDesc{})
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.StatementError))
+	require.True(t, strings.Contains(err.Error(), "'coalesce' arguments must have same type - first arg has type int - arg at position 1 has type string"))
+}
+
+func TestNullIfFunction(t *testing.T) {
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	col0 := createDecimalCol(10, 2, []bool{false, false, true, false}, []string{"1.5", "2", "0", "3"})
+	col1 := createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"1.5", "2.5", "1", "0"})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: decType}, &ColumnExpr{colIndex: 1, exprType: decType}}
+	fun, err := NewNullIfFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	require.Equal(t, decType, fun.ResultType())
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{decType, decType})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, col0, col1))
+	require.NoError(t, err)
+	colsEqual(t, createDecimalCol(10, 2, []bool{true, false, true, false}, []string{"0", "2", "0", "3"}), res)
+}
+
+func TestNullIfFunctionBytes(t *testing.T) {
+	col0 := createBytesCol([]bool{false, false}, []string{"abc", "def"})
+	col1 := createBytesCol([]bool{false, false}, []string{"abc", "abc"})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeBytes},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeBytes}}
+	fun, err := NewNullIfFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeBytes, types.ColumnTypeBytes})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, col0, col1))
+	require.NoError(t, err)
+	colsEqual(t, createBytesCol([]bool{true, false}, []string{"", "def"}), res)
+}
+
+func TestNullIfFunctionArgs(t *testing.T) {
+	_, err := NewNullIfFunction([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.StatementError))
+	require.True(t, strings.Contains(err.Error(), "'nullif' requires 2 arguments - 1 found"))
+
+	_, err = NewNullIfFunction([]Expression{NewIntegerConstantExpr(1), NewFloatConstantExpr(1)}, &parser.FunctionExprDesc{})
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.StatementError))
+	require.True(t, strings.Contains(err.Error(), "'nullif' arguments must be of same type - first is int and second is float"))
+}
+
 func createIntCol(nulls []bool, values []int64) *evbatch.IntColumn {
 	builder := evbatch.NewIntColBuilder()
 	for i, val := range values {
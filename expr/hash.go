@@ -0,0 +1,345 @@
+package expr
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/cespare/xxhash/v2"
+	"github.com/google/uuid"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"strconv"
+)
+
+// Hashing and encoding functions
+
+// stringOrBytesArg is an argument which can be of type string or bytes. It is evaluated as bytes.
+type stringOrBytesArg struct {
+	argExpr  Expression
+	isString bool
+}
+
+func newStringOrBytesArg(functionName string, argDescription string, argExpr Expression,
+	desc *parser.FunctionExprDesc) (stringOrBytesArg, error) {
+	argType := argExpr.ResultType()
+	if argType != types.ColumnTypeString && argType != types.ColumnTypeBytes {
+		return stringOrBytesArg{}, desc.ErrorAtPosition("'%s' %s must be of type string or bytes - it is of type %s",
+			functionName, argDescription, argType.String())
+	}
+	return stringOrBytesArg{
+		argExpr:  argExpr,
+		isString: argType == types.ColumnTypeString,
+	}, nil
+}
+
+func (s *stringOrBytesArg) evalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	if s.isString {
+		str, null, err := s.argExpr.EvalString(rowIndex, batch)
+		return []byte(str), null, err
+	}
+	return s.argExpr.EvalBytes(rowIndex, batch)
+}
+
+// digestFunction is the basis of the md5 and sha256 functions. The result is the digest as a hex encoded string.
+type digestFunction struct {
+	baseExpr
+	operand stringOrBytesArg
+	digest  func([]byte) []byte
+}
+
+func newDigestFunction(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc,
+	digest func([]byte) []byte) (digestFunction, error) {
+	if len(argExprs) != 1 {
+		return digestFunction{}, desc.ErrorAtPosition("'%s' requires 1 argument - %d found", functionName, len(argExprs))
+	}
+	operand, err := newStringOrBytesArg(functionName, "argument", argExprs[0], desc)
+	if err != nil {
+		return digestFunction{}, err
+	}
+	return digestFunction{
+		operand: operand,
+		digest:  digest,
+	}, nil
+}
+
+func (d *digestFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	val, null, err := d.operand.evalBytes(rowIndex, batch)
+	if err != nil {
+		return "", false, err
+	}
+	if null {
+		return "", true, nil
+	}
+	return hex.EncodeToString(d.digest(val)), false, nil
+}
+
+func (d *digestFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+type Md5Function struct {
+	digestFunction
+}
+
+func NewMd5Function(argExprs []Expression, desc *parser.FunctionExprDesc) (*Md5Function, error) {
+	d, err := newDigestFunction("md5", argExprs, desc, func(data []byte) []byte {
+		sum := md5.Sum(data)
+		return sum[:]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Md5Function{digestFunction: d}, nil
+}
+
+type Sha256Function struct {
+	digestFunction
+}
+
+func NewSha256Function(argExprs []Expression, desc *parser.FunctionExprDesc) (*Sha256Function, error) {
+	d, err := newDigestFunction("sha256", argExprs, desc, func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Sha256Function{digestFunction: d}, nil
+}
+
+// Murmur3Function returns the 32 bit MurmurHash3 of its operand, as a non-negative int. An optional int seed can be
+// provided, which defaults to zero.
+type Murmur3Function struct {
+	baseExpr
+	operand  stringOrBytesArg
+	seedExpr Expression
+}
+
+func NewMurmur3Function(argExprs []Expression, desc *parser.FunctionExprDesc) (*Murmur3Function, error) {
+	if len(argExprs) < 1 || len(argExprs) > 2 {
+		return nil, desc.ErrorAtPosition("'murmur3' requires 1 or 2 arguments - %d found", len(argExprs))
+	}
+	operand, err := newStringOrBytesArg("murmur3", "first argument", argExprs[0], desc)
+	if err != nil {
+		return nil, err
+	}
+	var seedExpr Expression
+	if len(argExprs) == 2 {
+		seedExpr = argExprs[1]
+		if seedExpr.ResultType() != types.ColumnTypeInt {
+			return nil, desc.ErrorAtPosition("'murmur3' second argument must be of type int - it is of type %s",
+				seedExpr.ResultType().String())
+		}
+	}
+	return &Murmur3Function{
+		operand:  operand,
+		seedExpr: seedExpr,
+	}, nil
+}
+
+func (m *Murmur3Function) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	val, null, err := m.operand.evalBytes(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	var seed int64
+	if m.seedExpr != nil {
+		seed, null, err = m.seedExpr.EvalInt(rowIndex, batch)
+		if err != nil {
+			return 0, false, err
+		}
+		if null {
+			return 0, true, nil
+		}
+	}
+	return int64(common.Murmur3Hash32(val, uint32(seed))), false, nil
+}
+
+func (m *Murmur3Function) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+// XxHashFunction returns the 64 bit xxHash of its operand.
+type XxHashFunction struct {
+	baseExpr
+	operand stringOrBytesArg
+}
+
+func NewXxHashFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*XxHashFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'xxhash' requires 1 argument - %d found", len(argExprs))
+	}
+	operand, err := newStringOrBytesArg("xxhash", "argument", argExprs[0], desc)
+	if err != nil {
+		return nil, err
+	}
+	return &XxHashFunction{
+		operand: operand,
+	}, nil
+}
+
+func (x *XxHashFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	val, null, err := x.operand.evalBytes(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	// Note - this returns the uint64 hash cast to an int64, so hashes > max int64 will be -ve
+	return int64(xxhash.Sum64(val)), false, nil
+}
+
+func (x *XxHashFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+type Base64EncodeFunction struct {
+	baseExpr
+	operand stringOrBytesArg
+}
+
+func NewBase64EncodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*Base64EncodeFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'base64_encode' requires 1 argument - %d found", len(argExprs))
+	}
+	operand, err := newStringOrBytesArg("base64_encode", "argument", argExprs[0], desc)
+	if err != nil {
+		return nil, err
+	}
+	return &Base64EncodeFunction{
+		operand: operand,
+	}, nil
+}
+
+func (b *Base64EncodeFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	val, null, err := b.operand.evalBytes(rowIndex, batch)
+	if err != nil {
+		return "", false, err
+	}
+	if null {
+		return "", true, nil
+	}
+	return base64.StdEncoding.EncodeToString(val), false, nil
+}
+
+func (b *Base64EncodeFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+type Base64DecodeFunction struct {
+	baseExpr
+	operand stringOrBytesArg
+}
+
+func NewBase64DecodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*Base64DecodeFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'base64_decode' requires 1 argument - %d found", len(argExprs))
+	}
+	operand, err := newStringOrBytesArg("base64_decode", "argument", argExprs[0], desc)
+	if err != nil {
+		return nil, err
+	}
+	return &Base64DecodeFunction{
+		operand: operand,
+	}, nil
+}
+
+func (b *Base64DecodeFunction) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	val, null, err := b.operand.evalBytes(rowIndex, batch)
+	if err != nil {
+		return nil, false, err
+	}
+	if null {
+		return nil, true, nil
+	}
+	res := make([]byte, base64.StdEncoding.DecodedLen(len(val)))
+	n, err := base64.StdEncoding.Decode(res, val)
+	if err != nil {
+		return nil, false, errors.Errorf("'base64_decode' failed to decode value: %v", err)
+	}
+	return res[:n], false, nil
+}
+
+func (b *Base64DecodeFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeBytes
+}
+
+// HexFunction returns the lower case hex encoding of a string or bytes value, or of an int, treated as an unsigned
+// 64 bit number.
+type HexFunction struct {
+	baseExpr
+	operandExpr Expression
+}
+
+func NewHexFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*HexFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'hex' requires 1 argument - %d found", len(argExprs))
+	}
+	argType := argExprs[0].ResultType()
+	if argType != types.ColumnTypeString && argType != types.ColumnTypeBytes && argType != types.ColumnTypeInt {
+		return nil, desc.ErrorAtPosition("'hex' argument must be of type string, bytes or int - it is of type %s",
+			argType.String())
+	}
+	return &HexFunction{
+		operandExpr: argExprs[0],
+	}, nil
+}
+
+func (h *HexFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	switch h.operandExpr.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		val, null, err := h.operandExpr.EvalInt(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+		return strconv.FormatUint(uint64(val), 16), false, nil
+	case types.ColumnTypeIDString:
+		val, null, err := h.operandExpr.EvalString(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+		return hex.EncodeToString([]byte(val)), false, nil
+	default:
+		val, null, err := h.operandExpr.EvalBytes(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+		return hex.EncodeToString(val), false, nil
+	}
+}
+
+func (h *HexFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// UuidV4Function returns a new random (version 4) UUID as a string, each time it is evaluated.
+type UuidV4Function struct {
+	baseExpr
+}
+
+func NewUuidV4Function(argExprs []Expression, desc *parser.FunctionExprDesc) (*UuidV4Function, error) {
+	if len(argExprs) > 0 {
+		return nil, desc.ErrorAtPosition("'uuid_v4' does not take any arguments")
+	}
+	return &UuidV4Function{}, nil
+}
+
+func (u *UuidV4Function) EvalString(_ int, _ *evbatch.Batch) (string, bool, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", false, errors.Errorf("failed to generate uuid: %v", err)
+	}
+	return id.String(), false, nil
+}
+
+func (u *UuidV4Function) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
@@ -0,0 +1,103 @@
+package expr
+
+import (
+	"github.com/google/uuid"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMd5Function(t *testing.T) {
+	expected := createStringCol([]bool{false, false, true}, []string{"5d41402abc4b2a76b9719d911017c592", "d41d8cd98f00b204e9800998ecf8427e", ""})
+	testMathFunction(t, NewMd5Function, createStringCol([]bool{false, false, true}, []string{"hello", "", ""}),
+		types.ColumnTypeString, nil, expected)
+	testMathFunction(t, NewMd5Function, createBytesCol([]bool{false, false, true}, []string{"hello", "", ""}),
+		types.ColumnTypeBytes, nil, expected)
+}
+
+func TestSha256Function(t *testing.T) {
+	expected := createStringCol([]bool{false, true}, []string{"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", ""})
+	testMathFunction(t, NewSha256Function, createStringCol([]bool{false, true}, []string{"hello", ""}),
+		types.ColumnTypeString, nil, expected)
+	testMathFunction(t, NewSha256Function, createBytesCol([]bool{false, true}, []string{"hello", ""}),
+		types.ColumnTypeBytes, nil, expected)
+}
+
+func TestMurmur3Function(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, true}, []string{"hello", "", ""})
+	testMathFunction(t, NewMurmur3Function, argCol, types.ColumnTypeString, nil,
+		createIntCol([]bool{false, false, true}, []int64{613153351, 0, 0}))
+	testMathFunction(t, NewMurmur3Function, argCol, types.ColumnTypeString, NewIntegerConstantExpr(42),
+		createIntCol([]bool{false, false, true}, []int64{3806057185, 142593372, 0}))
+}
+
+func TestXxHashFunction(t *testing.T) {
+	argCol := createBytesCol([]bool{false, false, true}, []string{"hello", "", ""})
+	testMathFunction(t, NewXxHashFunction, argCol, types.ColumnTypeBytes, nil,
+		createIntCol([]bool{false, false, true}, []int64{2794345569481354659, -1205034819632174695, 0}))
+}
+
+func TestHashFunctionArgs(t *testing.T) {
+	_, err := NewMd5Function(nil, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'md5' requires 1 argument - 0 found")
+	_, err = NewSha256Function([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'sha256' argument must be of type string or bytes - it is of type int")
+	_, err = NewMurmur3Function([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'murmur3' first argument must be of type string or bytes - it is of type int")
+	_, err = NewMurmur3Function([]Expression{NewStringConstantExpr("a"), NewStringConstantExpr("b")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'murmur3' second argument must be of type int - it is of type string")
+	_, err = NewXxHashFunction([]Expression{NewFloatConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'xxhash' argument must be of type string or bytes - it is of type float")
+}
+
+func TestBase64Functions(t *testing.T) {
+	encoded := createStringCol([]bool{false, false, true}, []string{"aGVsbG8gd29ybGQ=", "", ""})
+	testMathFunction(t, NewBase64EncodeFunction, createStringCol([]bool{false, false, true}, []string{"hello world", "", ""}),
+		types.ColumnTypeString, nil, encoded)
+	testMathFunction(t, NewBase64EncodeFunction, createBytesCol([]bool{false, false, true}, []string{"hello world", "", ""}),
+		types.ColumnTypeBytes, nil, encoded)
+	testMathFunction(t, NewBase64DecodeFunction, encoded, types.ColumnTypeString, nil,
+		createBytesCol([]bool{false, false, true}, []string{"hello world", "", ""}))
+}
+
+func TestBase64DecodeInvalid(t *testing.T) {
+	fun, err := NewBase64DecodeFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}},
+		&parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeString})
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createStringCol([]bool{false}, []string{"not base64!"})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'base64_decode' failed to decode value")
+}
+
+func TestHexFunction(t *testing.T) {
+	testMathFunction(t, NewHexFunction, createStringCol([]bool{false, true}, []string{"hello", ""}),
+		types.ColumnTypeString, nil, createStringCol([]bool{false, true}, []string{"68656c6c6f", ""}))
+	testMathFunction(t, NewHexFunction, createBytesCol([]bool{false, true}, []string{"\x00\xff", ""}),
+		types.ColumnTypeBytes, nil, createStringCol([]bool{false, true}, []string{"00ff", ""}))
+	testMathFunction(t, NewHexFunction, createIntCol([]bool{false, false, true}, []int64{255, -1, 0}),
+		types.ColumnTypeInt, nil, createStringCol([]bool{false, false, true}, []string{"ff", "ffffffffffffffff", ""}))
+
+	_, err := NewHexFunction([]Expression{NewFloatConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'hex' argument must be of type string, bytes or int - it is of type float")
+}
+
+func TestUuidV4Function(t *testing.T) {
+	fun, err := NewUuidV4Function(nil, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeString, fun.ResultType())
+	s1, null, err := fun.EvalString(0, nil)
+	require.NoError(t, err)
+	require.False(t, null)
+	id, err := uuid.Parse(s1)
+	require.NoError(t, err)
+	require.Equal(t, uuid.Version(4), id.Version())
+	s2, _, err := fun.EvalString(0, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, s1, s2)
+
+	_, err = NewUuidV4Function([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'uuid_v4' does not take any arguments")
+}
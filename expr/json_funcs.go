@@ -0,0 +1,560 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/tidwall/gjson"
+	"strconv"
+	"strings"
+)
+
+// Functions that build JSON, query it with JSONPath and convert it to native types
+
+// appendJSONValue evaluates the expression and appends its value, encoded as JSON, to the buffer. Null is encoded as
+// JSON null.
+func appendJSONValue(buff *bytes.Buffer, e Expression, rowIndex int, batch *evbatch.Batch) error {
+	val, null, err := evalAny(e, rowIndex, batch)
+	if err != nil {
+		return err
+	}
+	if null {
+		buff.WriteString("null")
+		return nil
+	}
+	encoded, err := json.Marshal(types.ToJSONValue(e.ResultType(), val))
+	if err != nil {
+		return errors.Errorf("cannot encode value as JSON: %v", err)
+	}
+	buff.Write(encoded)
+	return nil
+}
+
+// JsonObjectFunction builds a JSON object from pairs of key and value arguments, e.g. json_object("id", id,
+// "tags", tags). Fields are in the order of the arguments and null values are encoded as JSON null.
+type JsonObjectFunction struct {
+	baseExpr
+	keyExprs   []Expression
+	valueExprs []Expression
+}
+
+func NewJsonObjectFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*JsonObjectFunction, error) {
+	if len(argExprs)%2 == 1 {
+		return nil, desc.ErrorAtPosition("'json_object' requires an even number of arguments - %d found", len(argExprs))
+	}
+	var keyExprs, valueExprs []Expression
+	for i := 0; i < len(argExprs); i += 2 {
+		if argExprs[i].ResultType() != types.ColumnTypeString {
+			return nil, desc.ErrorAtPosition("'json_object' key argument at position %d must be of type string - it is of type %s",
+				i, argExprs[i].ResultType().String())
+		}
+		keyExprs = append(keyExprs, argExprs[i])
+		valueExprs = append(valueExprs, argExprs[i+1])
+	}
+	return &JsonObjectFunction{
+		keyExprs:   keyExprs,
+		valueExprs: valueExprs,
+	}, nil
+}
+
+func (j *JsonObjectFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	var buff bytes.Buffer
+	buff.WriteByte('{')
+	for i, keyExpr := range j.keyExprs {
+		key, null, err := keyExpr.EvalString(rowIndex, batch)
+		if err != nil {
+			return "", false, err
+		}
+		if null {
+			return "", false, errors.New("'json_object' key cannot be null")
+		}
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		buff.Write(encodedKey)
+		buff.WriteByte(':')
+		if err := appendJSONValue(&buff, j.valueExprs[i], rowIndex, batch); err != nil {
+			return "", false, err
+		}
+	}
+	buff.WriteByte('}')
+	return buff.String(), false, nil
+}
+
+func (j *JsonObjectFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// JsonArrayFunction builds a JSON array from its arguments, which can be of different types.
+type JsonArrayFunction struct {
+	baseExpr
+	elemExprs []Expression
+}
+
+func NewJsonArrayFunction(argExprs []Expression, _ *parser.FunctionExprDesc) (*JsonArrayFunction, error) {
+	return &JsonArrayFunction{
+		elemExprs: argExprs,
+	}, nil
+}
+
+func (j *JsonArrayFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	var buff bytes.Buffer
+	buff.WriteByte('[')
+	for i, elemExpr := range j.elemExprs {
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		if err := appendJSONValue(&buff, elemExpr, rowIndex, batch); err != nil {
+			return "", false, err
+		}
+	}
+	buff.WriteByte(']')
+	return buff.String(), false, nil
+}
+
+func (j *JsonArrayFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// ToJsonFunction encodes its argument as JSON, e.g. a struct is encoded as a JSON object. With no arguments, it encodes
+// the whole row as a JSON object with a field for each column.
+type ToJsonFunction struct {
+	baseExpr
+	operand Expression
+}
+
+func NewToJsonFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ToJsonFunction, error) {
+	if len(argExprs) > 1 {
+		return nil, desc.ErrorAtPosition("'to_json' requires 0 or 1 arguments - %d found", len(argExprs))
+	}
+	var operand Expression
+	if len(argExprs) == 1 {
+		operand = argExprs[0]
+	}
+	return &ToJsonFunction{
+		operand: operand,
+	}, nil
+}
+
+func (t *ToJsonFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	var buff bytes.Buffer
+	if t.operand != nil {
+		if _, null, err := evalAny(t.operand, rowIndex, batch); err != nil || null {
+			return "", null, err
+		}
+		if err := appendJSONValue(&buff, t.operand, rowIndex, batch); err != nil {
+			return "", false, err
+		}
+		return buff.String(), false, nil
+	}
+	buff.WriteByte('{')
+	for i, columnName := range batch.Schema.ColumnNames() {
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		encodedName, _ := json.Marshal(columnName)
+		buff.Write(encodedName)
+		buff.WriteByte(':')
+		colExpr := &ColumnExpr{colIndex: i, exprType: batch.Schema.ColumnTypes()[i]}
+		if err := appendJSONValue(&buff, colExpr, rowIndex, batch); err != nil {
+			return "", false, err
+		}
+	}
+	buff.WriteByte('}')
+	return buff.String(), false, nil
+}
+
+func (t *ToJsonFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// JsonQueryFunction evaluates a JSONPath query, e.g. json_query("$.items[*].sku", payload), and returns the result as
+// JSON. If the path can only select a single value then the result is that value, or null if there is no such value.
+// If the path contains wildcards, recursive descent, slices or lists of indexes, the result is a JSON array of all
+// the values selected, in document order.
+type JsonQueryFunction struct {
+	baseExpr
+	pathArg jsonPathArg
+	jsonArg stringOrBytesArg
+}
+
+func NewJsonQueryFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*JsonQueryFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'json_query' requires 2 arguments - %d found", len(argExprs))
+	}
+	pathArg, err := newJsonPathArg(argExprs, desc)
+	if err != nil {
+		return nil, err
+	}
+	jsonArg, err := newStringOrBytesArg("json_query", "second argument", argExprs[1], desc)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonQueryFunction{
+		pathArg: pathArg,
+		jsonArg: jsonArg,
+	}, nil
+}
+
+func (j *JsonQueryFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	path, null, err := j.pathArg.eval(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	jsonBytes, null, err := j.jsonArg.evalBytes(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	results := path.query(gjson.ParseBytes(bytes.TrimSpace(jsonBytes)))
+	if !path.indefinite {
+		if len(results) == 0 {
+			return "", true, nil
+		}
+		return results[0].Raw, false, nil
+	}
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, res := range results {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(res.Raw)
+	}
+	sb.WriteByte(']')
+	return sb.String(), false, nil
+}
+
+func (j *JsonQueryFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// jsonPathArg is a JSONPath argument of a function. As with regexArg, a string literal path is compiled once when the
+// function is created, otherwise it is compiled each time it is evaluated.
+type jsonPathArg struct {
+	argExpr Expression
+	path    *jsonPath
+}
+
+func newJsonPathArg(argExprs []Expression, desc *parser.FunctionExprDesc) (jsonPathArg, error) {
+	argExpr := argExprs[0]
+	if argExpr.ResultType() != types.ColumnTypeString {
+		return jsonPathArg{}, desc.ErrorAtPosition("'json_query' first argument must be of type string - it is of type %s",
+			argExpr.ResultType().String())
+	}
+	if _, ok := argExpr.(*StringConstantExpr); !ok {
+		return jsonPathArg{argExpr: argExpr}, nil
+	}
+	sPath, _, _ := argExpr.EvalString(0, nil)
+	path, err := compileJsonPath(sPath)
+	if err != nil {
+		return jsonPathArg{}, desc.ArgExprs[0].ErrorAtPosition("%v", err)
+	}
+	return jsonPathArg{argExpr: argExpr, path: path}, nil
+}
+
+func (j *jsonPathArg) eval(rowIndex int, batch *evbatch.Batch) (*jsonPath, bool, error) {
+	if j.path != nil {
+		return j.path, false, nil
+	}
+	sPath, null, err := j.argExpr.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	path, err := compileJsonPath(sPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return path, false, nil
+}
+
+type jsonPathSelectorKind int
+
+const (
+	jsonPathSelectName jsonPathSelectorKind = iota
+	jsonPathSelectWildcard
+	jsonPathSelectIndexes
+	jsonPathSelectSlice
+)
+
+type jsonPathSelector struct {
+	kind       jsonPathSelectorKind
+	recursive  bool
+	name       string
+	indexes    []int
+	sliceStart *int
+	sliceEnd   *int
+}
+
+// jsonPath is a compiled JSONPath. The supported syntax is: the root $, child members .name and ['name'], wildcards
+// .* and [*], array indexes [n] which count from the end if negative, lists of indexes [n,m], slices [start:end] and
+// recursive descent with .. before any of these. Filter expressions are not supported.
+type jsonPath struct {
+	selectors  []jsonPathSelector
+	indefinite bool
+}
+
+func compileJsonPath(sPath string) (*jsonPath, error) {
+	invalid := func(reason string) error {
+		return errors.Errorf("invalid JSON path '%s' - %s", sPath, reason)
+	}
+	if !strings.HasPrefix(sPath, "$") {
+		return nil, invalid("it must start with '$'")
+	}
+	path := &jsonPath{}
+	pos := 1
+	for pos < len(sPath) {
+		var sel jsonPathSelector
+		switch sPath[pos] {
+		case '.':
+			pos++
+			if pos < len(sPath) && sPath[pos] == '.' {
+				sel.recursive = true
+				pos++
+			}
+			if pos < len(sPath) && sPath[pos] == '[' && sel.recursive {
+				end, err := parseJsonPathBracket(sPath, pos, &sel)
+				if err != nil {
+					return nil, invalid(err.Error())
+				}
+				pos = end
+			} else if pos < len(sPath) && sPath[pos] == '*' {
+				sel.kind = jsonPathSelectWildcard
+				pos++
+			} else {
+				end := pos
+				for end < len(sPath) && sPath[end] != '.' && sPath[end] != '[' {
+					end++
+				}
+				if end == pos {
+					return nil, invalid("member name is missing")
+				}
+				sel.kind = jsonPathSelectName
+				sel.name = sPath[pos:end]
+				pos = end
+			}
+		case '[':
+			end, err := parseJsonPathBracket(sPath, pos, &sel)
+			if err != nil {
+				return nil, invalid(err.Error())
+			}
+			pos = end
+		default:
+			return nil, invalid("unexpected character '" + string(sPath[pos]) + "'")
+		}
+		if sel.recursive || sel.kind != jsonPathSelectName && !(sel.kind == jsonPathSelectIndexes && len(sel.indexes) == 1) {
+			path.indefinite = true
+		}
+		path.selectors = append(path.selectors, sel)
+	}
+	return path, nil
+}
+
+// parseJsonPathBracket parses the bracketed selector starting at pos, returning the position after the closing bracket.
+func parseJsonPathBracket(sPath string, pos int, sel *jsonPathSelector) (int, error) {
+	start := pos + 1
+	end := start
+	var quote byte
+	for ; end < len(sPath); end++ {
+		c := sPath[end]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+		} else if c == ']' {
+			break
+		}
+	}
+	if end == len(sPath) {
+		return 0, errors.New("missing ']'")
+	}
+	content := strings.TrimSpace(sPath[start:end])
+	switch {
+	case content == "*":
+		sel.kind = jsonPathSelectWildcard
+	case len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0]:
+		sel.kind = jsonPathSelectName
+		sel.name = content[1 : len(content)-1]
+	case strings.Contains(content, ":"):
+		sel.kind = jsonPathSelectSlice
+		parts := strings.Split(content, ":")
+		if len(parts) != 2 {
+			return 0, errors.Errorf("invalid slice '%s'", content)
+		}
+		var err error
+		if sel.sliceStart, err = parseOptionalJsonPathIndex(parts[0]); err != nil {
+			return 0, err
+		}
+		if sel.sliceEnd, err = parseOptionalJsonPathIndex(parts[1]); err != nil {
+			return 0, err
+		}
+	default:
+		sel.kind = jsonPathSelectIndexes
+		for _, sIndex := range strings.Split(content, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(sIndex))
+			if err != nil {
+				return 0, errors.Errorf("invalid array index '%s'", strings.TrimSpace(sIndex))
+			}
+			sel.indexes = append(sel.indexes, index)
+		}
+	}
+	return end + 1, nil
+}
+
+func parseOptionalJsonPathIndex(s string) (*int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid array index '%s'", s)
+	}
+	return &index, nil
+}
+
+func (j *jsonPath) query(root gjson.Result) []gjson.Result {
+	nodes := []gjson.Result{root}
+	for _, sel := range j.selectors {
+		if sel.recursive {
+			var descendants []gjson.Result
+			for _, node := range nodes {
+				descendants = appendJsonDescendants(descendants, node)
+			}
+			nodes = descendants
+		}
+		var next []gjson.Result
+		for _, node := range nodes {
+			next = sel.apply(next, node)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// appendJsonDescendants appends the node and all the values nested within it, in document order.
+func appendJsonDescendants(results []gjson.Result, node gjson.Result) []gjson.Result {
+	results = append(results, node)
+	if node.IsObject() || node.IsArray() {
+		node.ForEach(func(_, value gjson.Result) bool {
+			results = appendJsonDescendants(results, value)
+			return true
+		})
+	}
+	return results
+}
+
+func (s *jsonPathSelector) apply(results []gjson.Result, node gjson.Result) []gjson.Result {
+	switch s.kind {
+	case jsonPathSelectName:
+		if node.IsObject() {
+			node.ForEach(func(key, value gjson.Result) bool {
+				if key.Str == s.name {
+					results = append(results, value)
+					return false
+				}
+				return true
+			})
+		}
+	case jsonPathSelectWildcard:
+		if node.IsObject() || node.IsArray() {
+			node.ForEach(func(_, value gjson.Result) bool {
+				results = append(results, value)
+				return true
+			})
+		}
+	case jsonPathSelectIndexes:
+		if node.IsArray() {
+			arr := node.Array()
+			for _, index := range s.indexes {
+				if index < 0 {
+					index += len(arr)
+				}
+				if index >= 0 && index < len(arr) {
+					results = append(results, arr[index])
+				}
+			}
+		}
+	case jsonPathSelectSlice:
+		if node.IsArray() {
+			arr := node.Array()
+			start := sliceBound(s.sliceStart, 0, len(arr))
+			end := sliceBound(s.sliceEnd, len(arr), len(arr))
+			if start < end {
+				results = append(results, arr[start:end]...)
+			}
+		}
+	}
+	return results
+}
+
+func sliceBound(bound *int, def int, length int) int {
+	if bound == nil {
+		return def
+	}
+	b := *bound
+	if b < 0 {
+		b += length
+	}
+	if b < 0 {
+		return 0
+	}
+	if b > length {
+		return length
+	}
+	return b
+}
+
+// ParseJsonFunction parses JSON to a value of the type given by its second argument, which must be a string literal,
+// e.g. parse_json(payload, "struct<id:int,tags:array<string>>"). See types.FromJSONValue for how JSON values are
+// converted. The result is null if the JSON is null, and an error is returned if the JSON is invalid or cannot be
+// converted to the type.
+type ParseJsonFunction struct {
+	nestedElementExpr
+	jsonArg stringOrBytesArg
+}
+
+func NewParseJsonFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ParseJsonFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'parse_json' requires 2 arguments - %d found", len(argExprs))
+	}
+	jsonArg, err := newStringOrBytesArg("parse_json", "first argument", argExprs[0], desc)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := argExprs[1].(*StringConstantExpr); !ok {
+		return nil, desc.ErrorAtPosition("'parse_json' second argument must be a string literal")
+	}
+	sType, _, _ := argExprs[1].EvalString(0, nil)
+	resultType, err := types.StringToColumnType(sType)
+	if err != nil {
+		return nil, desc.ArgExprs[1].ErrorAtPosition("'parse_json' second argument must be a valid type - %v", err)
+	}
+	p := &ParseJsonFunction{jsonArg: jsonArg}
+	p.elemType = resultType
+	p.evalElement = p.eval
+	return p, nil
+}
+
+func (p *ParseJsonFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	jsonBytes, null, err := p.jsonArg.evalBytes(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	var jv any
+	if err := dec.Decode(&jv); err != nil {
+		return nil, false, errors.Errorf("'parse_json' invalid JSON: %v", err)
+	}
+	val, err := types.FromJSONValue(p.elemType, jv)
+	if err != nil {
+		return nil, false, errors.Errorf("'parse_json' %v", err)
+	}
+	return val, val == nil, nil
+}
@@ -0,0 +1,243 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestJsonObjectFunction(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"c0", "c1", "c2"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, &types.DecimalType{Precision: 10, Scale: 2}})
+	batch := evbatch.NewBatch(schema, createIntCol([]bool{false, true}, []int64{23, 0}),
+		createStringCol([]bool{false, false}, []string{`say "hi"`, "bar"}),
+		createDecimalCol(10, 2, []bool{false, false}, []string{"1.50", "-2.25"}))
+	fun, err := NewJsonObjectFunction([]Expression{
+		NewStringConstantExpr("id"), &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeInt},
+		NewStringConstantExpr("msg"), &ColumnExpr{colIndex: 1, exprType: types.ColumnTypeString},
+		NewStringConstantExpr("price"), &ColumnExpr{colIndex: 2, exprType: schema.ColumnTypes()[2]},
+		NewStringConstantExpr("ok"), NewBoolConstantExpr(true),
+	}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	res, err := EvalColumn(fun, batch)
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false}, []string{
+		`{"id":23,"msg":"say \"hi\"","price":"1.50","ok":true}`,
+		`{"id":null,"msg":"bar","price":"-2.25","ok":true}`,
+	}), res)
+
+	fun, err = NewJsonObjectFunction(nil, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	s, null, err := fun.EvalString(0, nil)
+	require.NoError(t, err)
+	require.False(t, null)
+	require.Equal(t, "{}", s)
+}
+
+func TestJsonObjectFunctionNested(t *testing.T) {
+	arr, err := NewArrayFunction([]Expression{NewIntegerConstantExpr(1), NewIntegerConstantExpr(2)}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	st, err := NewNamedStructFunction([]Expression{NewStringConstantExpr("b"), NewStringConstantExpr("x"),
+		NewStringConstantExpr("a"), arr}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	fun, err := NewJsonObjectFunction([]Expression{NewStringConstantExpr("s"), st}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	s, _, err := fun.EvalString(0, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"s":{"b":"x","a":[1,2]}}`, s)
+}
+
+func TestJsonObjectFunctionArgs(t *testing.T) {
+	_, err := NewJsonObjectFunction([]Expression{NewStringConstantExpr("a")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'json_object' requires an even number of arguments - 1 found")
+	_, err = NewJsonObjectFunction([]Expression{NewIntegerConstantExpr(1), NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'json_object' key argument at position 0 must be of type string - it is of type int")
+}
+
+func TestJsonArrayFunction(t *testing.T) {
+	argCol := createFloatCol([]bool{false, true}, []float64{1.25, 0})
+	testStringFunction(t, NewJsonArrayFunction, argCol, []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeFloat},
+		NewStringConstantExpr("foo"), NewIntegerConstantExpr(3)},
+		createStringCol([]bool{false, false}, []string{`[1.25,"foo",3]`, `[null,"foo",3]`}))
+	testStringFunction(t, NewJsonArrayFunction, argCol, nil, createStringCol([]bool{false, false}, []string{`[]`, `[]`}))
+}
+
+func TestToJsonFunction(t *testing.T) {
+	mapFun, err := NewMapFunction([]Expression{NewStringConstantExpr("k"), NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	fun, err := NewToJsonFunction([]Expression{mapFun}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	s, null, err := fun.EvalString(0, nil)
+	require.NoError(t, err)
+	require.False(t, null)
+	require.Equal(t, `{"k":1}`, s)
+
+	testStringFunction(t, NewToJsonFunction, createStringCol([]bool{false, true}, []string{"foo", ""}),
+		[]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}},
+		createStringCol([]bool{false, true}, []string{`"foo"`, ""}))
+
+	_, err = NewToJsonFunction([]Expression{mapFun, mapFun}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'to_json' requires 0 or 1 arguments - 2 found")
+}
+
+func TestToJsonFunctionRow(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"id", "name", "ts"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeTimestamp})
+	batch := evbatch.NewBatch(schema, createIntCol([]bool{false, false}, []int64{1, 2}),
+		createStringCol([]bool{false, true}, []string{"a", ""}),
+		createTimestampCol([]bool{false, false}, []int64{1000, 2000}))
+	fun, err := NewToJsonFunction(nil, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	res, err := EvalColumn(fun, batch)
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false}, []string{
+		`{"id":1,"name":"a","ts":1000}`,
+		`{"id":2,"name":null,"ts":2000}`,
+	}), res)
+}
+
+const jsonQueryDoc = `{
+	"order": {"id": 7, "customer": {"name": "jo"}},
+	"items": [
+		{"sku": "a1", "qty": 2, "tags": ["x"]},
+		{"sku": "b2", "qty": 1},
+		{"sku": "c3", "qty": 5, "tags": ["y", "z"]}
+	]
+}`
+
+func TestJsonQueryFunction(t *testing.T) {
+	testJsonQuery(t, "$", `{"a":1}`, `{"a":1}`, false)
+	testJsonQuery(t, "$.order.id", jsonQueryDoc, `7`, false)
+	testJsonQuery(t, "$.order.customer", jsonQueryDoc, `{"name": "jo"}`, false)
+	testJsonQuery(t, "$['order']['customer'].name", jsonQueryDoc, `"jo"`, false)
+	testJsonQuery(t, "$.items[1].sku", jsonQueryDoc, `"b2"`, false)
+	testJsonQuery(t, "$.items[-1].qty", jsonQueryDoc, `5`, false)
+	testJsonQuery(t, "$.items[*].sku", jsonQueryDoc, `["a1","b2","c3"]`, false)
+	testJsonQuery(t, "$.items[0,2].qty", jsonQueryDoc, `[2,5]`, false)
+	testJsonQuery(t, "$.items[1:].sku", jsonQueryDoc, `["b2","c3"]`, false)
+	testJsonQuery(t, "$.items[:-1].sku", jsonQueryDoc, `["a1","b2"]`, false)
+	testJsonQuery(t, "$.items[*].tags[*]", jsonQueryDoc, `["x","y","z"]`, false)
+	testJsonQuery(t, "$.order.*", jsonQueryDoc, `[7,{"name": "jo"}]`, false)
+	testJsonQuery(t, "$..name", jsonQueryDoc, `["jo"]`, false)
+	testJsonQuery(t, "$..[0]", jsonQueryDoc, `[{"sku": "a1", "qty": 2, "tags": ["x"]},"x","y"]`, false)
+	// Wildcards which match nothing give an empty array, but definite paths which match nothing give null
+	testJsonQuery(t, "$.missing[*]", jsonQueryDoc, `[]`, false)
+	testJsonQuery(t, "$.order.missing", jsonQueryDoc, "", true)
+	testJsonQuery(t, "$.items[10]", jsonQueryDoc, "", true)
+	testJsonQuery(t, "$.order[0]", jsonQueryDoc, "", true)
+}
+
+func testJsonQuery(t *testing.T, path string, json string, expected string, expectedNull bool) {
+	argCol := createStringCol([]bool{false}, []string{json})
+	testStringFunction(t, NewJsonQueryFunction, argCol,
+		[]Expression{NewStringConstantExpr(path), &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}},
+		createStringCol([]bool{expectedNull}, []string{expected}))
+}
+
+func TestJsonQueryFunctionNonConstantPath(t *testing.T) {
+	fun, err := NewJsonQueryFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeBytes}}, stringFuncDesc)
+	require.NoError(t, err)
+	require.Nil(t, fun.pathArg.path)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeBytes})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, createStringCol([]bool{false, false, true}, []string{"$.a", "$.b[*]", ""}),
+		createBytesCol([]bool{false, false, false}, []string{`{"a":1}`, `{"b":[true,false]}`, `{}`})))
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, true}, []string{"1", "[true,false]", ""}), res)
+
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createStringCol([]bool{false}, []string{"a.b"}),
+		createBytesCol([]bool{false}, []string{`{}`})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid JSON path 'a.b' - it must start with '$'")
+}
+
+func TestJsonQueryFunctionInvalidPath(t *testing.T) {
+	testJsonQueryInvalidPath(t, "foo", "invalid JSON path 'foo' - it must start with '$'")
+	testJsonQueryInvalidPath(t, "$.a[1", "invalid JSON path '$.a[1' - missing ']'")
+	testJsonQueryInvalidPath(t, "$.a[x]", "invalid JSON path '$.a[x]' - invalid array index 'x'")
+	testJsonQueryInvalidPath(t, "$.a[1:2:3]", "invalid JSON path '$.a[1:2:3]' - invalid slice '1:2:3'")
+	testJsonQueryInvalidPath(t, "$.", "invalid JSON path '$.' - member name is missing")
+	testJsonQueryInvalidPath(t, "$a", "invalid JSON path '$a' - unexpected character 'a'")
+
+	_, err := NewJsonQueryFunction([]Expression{NewIntegerConstantExpr(1), NewStringConstantExpr("{}")}, stringFuncDesc)
+	requireStatementError(t, err, "'json_query' first argument must be of type string - it is of type int")
+	_, err = NewJsonQueryFunction([]Expression{NewStringConstantExpr("$"), NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'json_query' second argument must be of type string or bytes - it is of type int")
+}
+
+func testJsonQueryInvalidPath(t *testing.T, path string, expectedMsg string) {
+	_, err := NewJsonQueryFunction([]Expression{NewStringConstantExpr(path), NewStringConstantExpr("{}")}, stringFuncDesc)
+	requireStatementError(t, err, expectedMsg)
+}
+
+func TestParseJsonFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, true}, []string{
+		`{"id": 1, "tags": ["a", "b"], "price": "12.30", "extra": true}`,
+		`{"id": 2, "tags": []}`,
+		`null`,
+		"",
+	})
+	fun, err := NewParseJsonFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		NewStringConstantExpr("struct<id:int,tags:array<string>,price:decimal(10,2)>")}, stringFuncDesc)
+	require.NoError(t, err)
+	require.Equal(t, "struct<id:int,tags:array<string>,price:decimal(10,2)>", fun.ResultType().String())
+	price, err := types.NewDecimalFromString("12.30", 10, 2)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeString})
+	batch := evbatch.NewBatch(schema, argCol)
+	var vals []any
+	for i := 0; i < batch.RowCount; i++ {
+		val, null, err := fun.EvalNested(i, batch)
+		require.NoError(t, err)
+		require.Equal(t, i >= 2, null)
+		vals = append(vals, val)
+	}
+	require.Equal(t, []any{
+		[]any{int64(1), []any{"a", "b"}, price},
+		[]any{int64(2), []any{}, nil},
+		nil,
+		nil,
+	}, vals)
+}
+
+func TestParseJsonFunctionScalar(t *testing.T) {
+	testStringFunction(t, NewParseJsonFunction, createStringCol([]bool{false, false}, []string{"42", "null"}),
+		[]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewStringConstantExpr("int")},
+		createIntCol([]bool{false, true}, []int64{42, 0}))
+}
+
+func TestParseJsonFunctionWithJsonQuery(t *testing.T) {
+	query, err := NewJsonQueryFunction([]Expression{NewStringConstantExpr("$.items[*].qty"),
+		NewStringConstantExpr(jsonQueryDoc)}, stringFuncDesc)
+	require.NoError(t, err)
+	fun, err := NewParseJsonFunction([]Expression{query, NewStringConstantExpr("array<int>")}, stringFuncDesc)
+	require.NoError(t, err)
+	val, null, err := fun.EvalNested(0, nil)
+	require.NoError(t, err)
+	require.False(t, null)
+	require.Equal(t, []any{int64(2), int64(1), int64(5)}, val)
+}
+
+func TestParseJsonFunctionErrors(t *testing.T) {
+	fun, err := NewParseJsonFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeBytes},
+		NewStringConstantExpr("map<string,int>")}, stringFuncDesc)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeBytes})
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{`{"a":`})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'parse_json' invalid JSON")
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{`{"a":"x"}`})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `'parse_json' cannot convert JSON string "x" to int`)
+
+	_, err = NewParseJsonFunction([]Expression{NewStringConstantExpr("{}")}, stringFuncDesc)
+	requireStatementError(t, err, "'parse_json' requires 2 arguments - 1 found")
+	_, err = NewParseJsonFunction([]Expression{NewStringConstantExpr("{}"), &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}}, stringFuncDesc)
+	requireStatementError(t, err, "'parse_json' second argument must be a string literal")
+	_, err = NewParseJsonFunction([]Expression{NewStringConstantExpr("{}"), NewStringConstantExpr("array<foo>")}, stringFuncDesc)
+	requireStatementError(t, err, "'parse_json' second argument must be a valid type")
+	_, err = NewParseJsonFunction([]Expression{NewIntegerConstantExpr(1), NewStringConstantExpr("int")}, stringFuncDesc)
+	requireStatementError(t, err, "'parse_json' first argument must be of type string or bytes - it is of type int")
+}
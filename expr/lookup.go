@@ -0,0 +1,154 @@
+package expr
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"sync"
+	"time"
+)
+
+// TableResolver resolves the tables which can be read by the 'lookup' function.
+type TableResolver interface {
+	// ResolveTable returns the table with the specified name, or nil if there is no such table.
+	ResolveTable(tableName string) LookupTable
+}
+
+// LookupTable reads the current rows of a table by key.
+type LookupTable interface {
+	// KeyColumnTypes returns the types of the key columns of the table.
+	KeyColumnTypes() []types.ColumnType
+	// ColumnIndex returns the index of the non-key column with the specified name, as passed to Get, and its type.
+	// It returns false if the table has no such column, or the column is a key column.
+	ColumnIndex(colName string) (int, types.ColumnType, bool)
+	// CreateKey returns the key in the store of the row with the specified key column values, and the ID of the
+	// processor which owns the partition of the row.
+	CreateKey(keyVals []any) ([]byte, int)
+	// Get returns the value of the column with the specified index in the row with the specified key, as of the last
+	// completed version. It returns nil if there is no such row or the value is null. It returns an error if the
+	// processor which owns the partition of the row is not on this node.
+	Get(key []byte, processorID int, colIndex int) (any, error)
+}
+
+// LookupFunction reads a column of a table row by key, e.g. lookup("customers", customer_id, "name"). The first
+// argument is the table name and the last is the column name, both must be string literals, and the arguments in
+// between are the key values, which must have the same types as the key columns of the table. The result is null if
+// there is no row with the key.
+//
+// Rows are read as of the last completed version, and only from partitions whose processor is on this node. Values
+// read from the table are cached, including misses, for up to cacheTTL, so a value can be stale by that long. There is
+// a cache of up to cacheMaxSize values for each processor, which holds the values of the rows in its partitions.
+type LookupFunction struct {
+	nestedElementExpr
+	table        LookupTable
+	keyExprs     []Expression
+	colIndex     int
+	caches       sync.Map // processor ID -> *lru.Cache
+	cacheMaxSize int
+	cacheTTL     time.Duration
+}
+
+type lookupCacheEntry struct {
+	val     any
+	expires time.Time
+}
+
+func NewLookupFunction(argExprs []Expression, desc *parser.FunctionExprDesc, resolver TableResolver,
+	cacheMaxSize int, cacheTTL time.Duration) (*LookupFunction, error) {
+	if len(argExprs) < 3 {
+		return nil, desc.ErrorAtPosition("'lookup' requires at least 3 arguments - %d found", len(argExprs))
+	}
+	if _, ok := argExprs[0].(*StringConstantExpr); !ok {
+		return nil, desc.ArgExprs[0].ErrorAtPosition("'lookup' table name argument must be a string literal")
+	}
+	lastArg := len(argExprs) - 1
+	if _, ok := argExprs[lastArg].(*StringConstantExpr); !ok {
+		return nil, desc.ArgExprs[lastArg].ErrorAtPosition("'lookup' column name argument must be a string literal")
+	}
+	tableName, _, _ := argExprs[0].EvalString(0, nil)
+	var table LookupTable
+	if resolver != nil {
+		table = resolver.ResolveTable(tableName)
+	}
+	if table == nil {
+		return nil, desc.ArgExprs[0].ErrorAtPosition("'lookup' unknown table '%s'", tableName)
+	}
+	keyExprs := argExprs[1:lastArg]
+	keyColTypes := table.KeyColumnTypes()
+	if len(keyExprs) != len(keyColTypes) {
+		return nil, desc.ErrorAtPosition("'lookup' table '%s' has %d key columns - %d key values found", tableName,
+			len(keyColTypes), len(keyExprs))
+	}
+	for i, keyExpr := range keyExprs {
+		if !types.ColumnTypesEqual(keyExpr.ResultType(), keyColTypes[i]) {
+			return nil, desc.ArgExprs[i+1].ErrorAtPosition("'lookup' key value must be of type %s - it is of type %s",
+				keyColTypes[i].String(), keyExpr.ResultType().String())
+		}
+	}
+	colName, _, _ := argExprs[lastArg].EvalString(0, nil)
+	colIndex, colType, ok := table.ColumnIndex(colName)
+	if !ok {
+		return nil, desc.ArgExprs[lastArg].ErrorAtPosition("'lookup' table '%s' has no non-key column '%s'", tableName,
+			colName)
+	}
+	l := &LookupFunction{
+		table:        table,
+		keyExprs:     keyExprs,
+		colIndex:     colIndex,
+		cacheMaxSize: cacheMaxSize,
+		cacheTTL:     cacheTTL,
+	}
+	l.elemType = colType
+	l.evalElement = l.eval
+	return l, nil
+}
+
+func (l *LookupFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	keyVals := make([]any, len(l.keyExprs))
+	for i, keyExpr := range l.keyExprs {
+		val, _, err := evalAny(keyExpr, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		keyVals[i] = val
+	}
+	key, processorID := l.table.CreateKey(keyVals)
+	cache, err := l.processorCache(processorID)
+	if err != nil {
+		return nil, false, err
+	}
+	if cache != nil {
+		if o, ok := cache.Get(common.ByteSliceToStringZeroCopy(key)); ok {
+			entry := o.(*lookupCacheEntry)
+			if time.Now().Before(entry.expires) {
+				return entry.val, entry.val == nil, nil
+			}
+		}
+	}
+	val, err := l.table.Get(key, processorID, l.colIndex)
+	if err != nil {
+		return nil, false, err
+	}
+	if cache != nil {
+		cache.Add(string(key), &lookupCacheEntry{val: val, expires: time.Now().Add(l.cacheTTL)})
+	}
+	return val, val == nil, nil
+}
+
+// processorCache returns the cache for the processor, or nil if values are not cached.
+func (l *LookupFunction) processorCache(processorID int) (*lru.Cache, error) {
+	if l.cacheMaxSize <= 0 || l.cacheTTL <= 0 {
+		return nil, nil
+	}
+	if o, ok := l.caches.Load(processorID); ok {
+		return o.(*lru.Cache), nil
+	}
+	cache, err := lru.New(l.cacheMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	o, _ := l.caches.LoadOrStore(processorID, cache)
+	return o.(*lru.Cache), nil
+}
@@ -0,0 +1,207 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var lookupFuncDesc = &parser.FunctionExprDesc{ArgExprs: []parser.ExprDesc{&parser.StringConstExprDesc{},
+	&parser.IdentifierExprDesc{}, &parser.IdentifierExprDesc{}, &parser.StringConstExprDesc{}}}
+
+type testTableResolver struct {
+	tables map[string]*testLookupTable
+}
+
+func (t *testTableResolver) ResolveTable(tableName string) LookupTable {
+	table, ok := t.tables[tableName]
+	if !ok {
+		return nil
+	}
+	return table
+}
+
+type testLookupTable struct {
+	keyColTypes []types.ColumnType
+	colNames    []string
+	colTypes    []types.ColumnType
+	rows        map[string][]any
+	gets        int64
+	// remoteProcessors are the processors which are not on this node
+	remoteProcessors map[int]struct{}
+}
+
+func (t *testLookupTable) KeyColumnTypes() []types.ColumnType {
+	return t.keyColTypes
+}
+
+func (t *testLookupTable) ColumnIndex(colName string) (int, types.ColumnType, bool) {
+	for i, name := range t.colNames {
+		if name == colName {
+			return i, t.colTypes[i], true
+		}
+	}
+	return 0, nil, false
+}
+
+func (t *testLookupTable) CreateKey(keyVals []any) ([]byte, int) {
+	// The rows with odd keys are owned by processor 1, and the others by processor 0
+	return []byte(fmt.Sprint(keyVals...)), int(keyVals[1].(int64) % 2)
+}
+
+func (t *testLookupTable) Get(key []byte, processorID int, colIndex int) (any, error) {
+	if _, ok := t.remoteProcessors[processorID]; ok {
+		return nil, errors.Errorf("processor %d is not on this node", processorID)
+	}
+	atomic.AddInt64(&t.gets, 1)
+	row, ok := t.rows[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return row[colIndex], nil
+}
+
+func createTestTableResolver() (*testTableResolver, *testLookupTable) {
+	table := &testLookupTable{
+		keyColTypes: []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt},
+		colNames:    []string{"name", "tags"},
+		colTypes:    []types.ColumnType{types.ColumnTypeString, &types.ArrayType{ElemType: types.ColumnTypeString}},
+		rows: map[string][]any{
+			"a1": {"foo", []any{"x", "y"}},
+			"b2": {"bar", nil},
+		},
+	}
+	return &testTableResolver{tables: map[string]*testLookupTable{"customers": table}}, table
+}
+
+func createLookupTestBatch() *evbatch.Batch {
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt})
+	return evbatch.NewBatch(schema, createStringCol([]bool{false, false, false, false}, []string{"a", "b", "a", "c"}),
+		createIntCol([]bool{false, false, false, false}, []int64{1, 2, 1, 3}))
+}
+
+func lookupArgs(colName string) []Expression {
+	return []Expression{NewStringConstantExpr("customers"), &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeInt}, NewStringConstantExpr(colName)}
+}
+
+func TestLookupFunction(t *testing.T) {
+	resolver, table := createTestTableResolver()
+	fun, err := NewLookupFunction(lookupArgs("name"), lookupFuncDesc, resolver, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeString, fun.ResultType())
+	res, err := EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, false, true}, []string{"foo", "bar", "foo", ""}), res)
+	// With no cache, every row is read from the table
+	require.Equal(t, int64(4), table.gets)
+
+	fun, err = NewLookupFunction(lookupArgs("tags"), lookupFuncDesc, resolver, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, "array<string>", fun.ResultType().String())
+	var vals []any
+	batch := createLookupTestBatch()
+	for i := 0; i < batch.RowCount; i++ {
+		val, null, err := fun.EvalNested(i, batch)
+		require.NoError(t, err)
+		require.Equal(t, val == nil, null)
+		vals = append(vals, val)
+	}
+	require.Equal(t, []any{[]any{"x", "y"}, nil, []any{"x", "y"}, nil}, vals)
+}
+
+func TestLookupFunctionCache(t *testing.T) {
+	resolver, table := createTestTableResolver()
+	fun, err := NewLookupFunction(lookupArgs("name"), lookupFuncDesc, resolver, 10, time.Hour)
+	require.NoError(t, err)
+	_, err = EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	// The repeated key is read from the cache
+	require.Equal(t, int64(3), table.gets)
+
+	// Misses are cached too, so changes to the table are not seen until the entries expire
+	table.rows["c3"] = []any{"baz", nil}
+	table.rows["a1"] = []any{"foo2", nil}
+	res, err := EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, false, true}, []string{"foo", "bar", "foo", ""}), res)
+	require.Equal(t, int64(3), table.gets)
+
+	fun, err = NewLookupFunction(lookupArgs("name"), lookupFuncDesc, resolver, 10, time.Millisecond)
+	require.NoError(t, err)
+	_, err = EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	res, err = EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, false, false}, []string{"foo2", "bar", "foo2", "baz"}), res)
+}
+
+func TestLookupFunctionCachePerProcessor(t *testing.T) {
+	resolver, table := createTestTableResolver()
+	fun, err := NewLookupFunction(lookupArgs("name"), lookupFuncDesc, resolver, 1, time.Hour)
+	require.NoError(t, err)
+	_, err = EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	// "b2" is cached by processor 0, so it doesn't evict "a1" from the cache of processor 1, but "c3" does
+	require.Equal(t, int64(3), table.gets)
+	_, err = EvalColumn(fun, createLookupTestBatch())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), table.gets)
+}
+
+func TestLookupFunctionRemoteProcessor(t *testing.T) {
+	resolver, table := createTestTableResolver()
+	table.remoteProcessors = map[int]struct{}{1: {}}
+	fun, err := NewLookupFunction(lookupArgs("name"), lookupFuncDesc, resolver, 10, time.Hour)
+	require.NoError(t, err)
+	batch := createLookupTestBatch()
+	val, _, err := fun.EvalString(1, batch)
+	require.NoError(t, err)
+	require.Equal(t, "bar", val)
+	_, _, err = fun.EvalString(0, batch)
+	require.Error(t, err)
+	require.Equal(t, "processor 1 is not on this node", err.Error())
+}
+
+func TestLookupFunctionErrors(t *testing.T) {
+	resolver, _ := createTestTableResolver()
+	_, err := NewLookupFunction([]Expression{NewStringConstantExpr("customers"), NewStringConstantExpr("name")},
+		lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' requires at least 3 arguments - 2 found")
+
+	args := lookupArgs("name")
+	args[0] = &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}
+	_, err = NewLookupFunction(args, lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' table name argument must be a string literal")
+
+	args = lookupArgs("name")
+	args[3] = &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}
+	_, err = NewLookupFunction(args, lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' column name argument must be a string literal")
+
+	args = lookupArgs("name")
+	args[0] = NewStringConstantExpr("orders")
+	_, err = NewLookupFunction(args, lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' unknown table 'orders'")
+	_, err = NewLookupFunction(lookupArgs("name"), lookupFuncDesc, nil, 0, 0)
+	requireStatementError(t, err, "'lookup' unknown table 'customers'")
+
+	_, err = NewLookupFunction([]Expression{NewStringConstantExpr("customers"), NewStringConstantExpr("a"),
+		NewStringConstantExpr("name")}, lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' table 'customers' has 2 key columns - 1 key values found")
+
+	args = lookupArgs("name")
+	args[2] = NewStringConstantExpr("1")
+	_, err = NewLookupFunction(args, lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' key value must be of type int - it is of type string")
+
+	_, err = NewLookupFunction(lookupArgs("foo"), lookupFuncDesc, resolver, 0, 0)
+	requireStatementError(t, err, "'lookup' table 'customers' has no non-key column 'foo'")
+}
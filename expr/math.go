@@ -0,0 +1,615 @@
+package expr
+
+import (
+	"github.com/apache/arrow/go/v11/arrow/decimal128"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"math/big"
+)
+
+// Numeric functions
+
+func isNumericType(columnType types.ColumnType) bool {
+	return columnType == types.ColumnTypeInt || columnType == types.ColumnTypeFloat ||
+		columnType.ID() == types.ColumnTypeIDDecimal
+}
+
+func checkNumericArg(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc) error {
+	if len(argExprs) != 1 {
+		return desc.ErrorAtPosition("'%s' requires 1 argument - %d found", functionName, len(argExprs))
+	}
+	if !isNumericType(argExprs[0].ResultType()) {
+		return desc.ErrorAtPosition("'%s' argument must be of type int, float or decimal - it is of type %s",
+			functionName, argExprs[0].ResultType().String())
+	}
+	return nil
+}
+
+// evalAsFloat evaluates an int, float or decimal expression and converts the result to a float.
+func evalAsFloat(e Expression, rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	switch e.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		val, null, err := e.EvalInt(rowIndex, batch)
+		return float64(val), null, err
+	case types.ColumnTypeIDDecimal:
+		val, null, err := e.EvalDecimal(rowIndex, batch)
+		return val.ToFloat64(), null, err
+	default:
+		return e.EvalFloat(rowIndex, batch)
+	}
+}
+
+type roundingMode int
+
+const (
+	roundingModeHalfUp roundingMode = iota
+	roundingModeFloor
+	roundingModeCeil
+)
+
+// roundInt rounds an int to the specified number of decimal places. Only a negative number of places has any effect,
+// e.g. rounding 1250 to -2 places gives 1300. An error is returned if the result does not fit in an int64.
+func roundInt(val int64, places int64, mode roundingMode) (int64, error) {
+	if places >= 0 {
+		return val, nil
+	}
+	if places < -18 {
+		// The multiplier does not fit in an int64, so the result is either zero or, if the value rounds away from zero,
+		// a multiple of at least 10^19, which does not fit
+		var away bool
+		switch mode {
+		case roundingModeHalfUp:
+			// Only 10^19 is small enough for a value to be half way to it
+			away = places == -19 && (val >= 5e18 || val <= -5e18)
+		case roundingModeFloor:
+			away = val < 0
+		case roundingModeCeil:
+			away = val > 0
+		}
+		if away {
+			return 0, errors.Errorf("result of rounding %d does not fit in an int", val)
+		}
+		return 0, nil
+	}
+	mult := int64(math.Pow10(int(-places)))
+	quo, rem := val/mult, val%mult
+	switch mode {
+	case roundingModeHalfUp:
+		if rem >= (mult+1)/2 {
+			quo++
+		} else if -rem >= (mult+1)/2 {
+			quo--
+		}
+	case roundingModeFloor:
+		if rem < 0 {
+			quo--
+		}
+	case roundingModeCeil:
+		if rem > 0 {
+			quo++
+		}
+	}
+	if quo > math.MaxInt64/mult || quo < math.MinInt64/mult {
+		return 0, errors.Errorf("result of rounding %d does not fit in an int", val)
+	}
+	return quo * mult, nil
+}
+
+func roundFloat(val float64, places int64, mode roundingMode) float64 {
+	var f func(float64) float64
+	switch mode {
+	case roundingModeHalfUp:
+		f = math.Round
+	case roundingModeFloor:
+		f = math.Floor
+	default:
+		f = math.Ceil
+	}
+	if math.IsInf(val, 0) || math.IsNaN(val) {
+		return val
+	}
+	if places == 0 {
+		return f(val)
+	}
+	if places > 0 {
+		mult := math.Pow10(int(min(places, 400)))
+		scaled := val * mult
+		if math.IsInf(mult, 1) || math.IsInf(scaled, 0) || math.Abs(scaled) >= 1<<52 {
+			// A float64 has no digits beyond this many places, so rounding has no effect
+			return val
+		}
+		return f(scaled) / mult
+	}
+	div := math.Pow10(int(-max(places, -400)))
+	scaled := val / div
+	if scaled == 0 {
+		// The value is too small relative to the divisor to be represented once scaled. It rounds to zero unless
+		// rounded away from it, when the result is the divisor, which may be infinite.
+		switch {
+		case mode == roundingModeFloor && val < 0:
+			return -div
+		case mode == roundingModeCeil && val > 0:
+			return div
+		default:
+			return math.Copysign(0, val)
+		}
+	}
+	return f(scaled) * div
+}
+
+// roundDecimal rounds a decimal to the specified number of decimal places. The result has the same precision and
+// scale as the value.
+func roundDecimal(val types.Decimal, places int64, mode roundingMode) (types.Decimal, error) {
+	// Clamp places so that reduceBy cannot overflow
+	reduceBy := int64(val.Scale) - max(places, -2*types.DefaultDecimalPrecision)
+	if reduceBy <= 0 {
+		return val, nil
+	}
+	if reduceBy > 2*types.DefaultDecimalPrecision {
+		// The value is less than the multiplier whatever its precision, so the result is the same
+		reduceBy = 2 * types.DefaultDecimalPrecision
+	}
+	mult := new(big.Int).Exp(big.NewInt(10), big.NewInt(reduceBy), nil)
+	quo, rem := new(big.Int).QuoRem(val.Num.BigInt(), mult, new(big.Int))
+	switch mode {
+	case roundingModeHalfUp:
+		twiceRem := new(big.Int).Mul(rem, big.NewInt(2))
+		if twiceRem.CmpAbs(mult) >= 0 {
+			quo.Add(quo, big.NewInt(int64(rem.Sign())))
+		}
+	case roundingModeFloor:
+		if rem.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		}
+	case roundingModeCeil:
+		if rem.Sign() > 0 {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	res := quo.Mul(quo, mult)
+	if res.BitLen() > 127 {
+		return types.Decimal{}, errors.Errorf("result of rounding %s does not fit in precision %d", val.String(),
+			val.Precision)
+	}
+	num := decimal128.FromBigInt(res)
+	if !num.FitsInPrecision(int32(val.Precision)) {
+		return types.Decimal{}, errors.Errorf("result of rounding %s does not fit in precision %d", val.String(),
+			val.Precision)
+	}
+	return types.Decimal{
+		Num:       num,
+		Precision: val.Precision,
+		Scale:     val.Scale,
+	}, nil
+}
+
+// roundingFunction is the basis of the round, floor and ceil functions. The result has the same type as the operand.
+type roundingFunction struct {
+	baseExpr
+	operandExpr Expression
+	placesExpr  Expression
+	mode        roundingMode
+}
+
+func newRoundingFunction(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc,
+	mode roundingMode) (roundingFunction, error) {
+	if len(argExprs) < 1 || len(argExprs) > 2 {
+		return roundingFunction{}, desc.ErrorAtPosition("'%s' requires 1 or 2 arguments - %d found", functionName,
+			len(argExprs))
+	}
+	operandExpr := argExprs[0]
+	if !isNumericType(operandExpr.ResultType()) {
+		return roundingFunction{}, desc.ErrorAtPosition("'%s' first argument must be of type int, float or decimal - it is of type %s",
+			functionName, operandExpr.ResultType().String())
+	}
+	var placesExpr Expression
+	if len(argExprs) == 2 {
+		placesExpr = argExprs[1]
+		if placesExpr.ResultType() != types.ColumnTypeInt {
+			return roundingFunction{}, desc.ErrorAtPosition("'%s' second argument must be of type int - it is of type %s",
+				functionName, placesExpr.ResultType().String())
+		}
+	}
+	return roundingFunction{
+		operandExpr: operandExpr,
+		placesExpr:  placesExpr,
+		mode:        mode,
+	}, nil
+}
+
+func (r *roundingFunction) evalPlaces(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	if r.placesExpr == nil {
+		return 0, false, nil
+	}
+	return r.placesExpr.EvalInt(rowIndex, batch)
+}
+
+func (r *roundingFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	val, null, err := r.operandExpr.EvalInt(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	places, null, err := r.evalPlaces(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	res, err := roundInt(val, places, r.mode)
+	if err != nil {
+		return 0, false, err
+	}
+	return res, false, nil
+}
+
+func (r *roundingFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	val, null, err := r.operandExpr.EvalFloat(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	places, null, err := r.evalPlaces(rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	return roundFloat(val, places, r.mode), false, nil
+}
+
+func (r *roundingFunction) EvalDecimal(rowIndex int, batch *evbatch.Batch) (types.Decimal, bool, error) {
+	val, null, err := r.operandExpr.EvalDecimal(rowIndex, batch)
+	if err != nil {
+		return types.Decimal{}, false, err
+	}
+	if null {
+		return types.Decimal{}, true, nil
+	}
+	places, null, err := r.evalPlaces(rowIndex, batch)
+	if err != nil {
+		return types.Decimal{}, false, err
+	}
+	if null {
+		return types.Decimal{}, true, nil
+	}
+	res, err := roundDecimal(val, places, r.mode)
+	if err != nil {
+		return types.Decimal{}, false, err
+	}
+	return res, false, nil
+}
+
+func (r *roundingFunction) ResultType() types.ColumnType {
+	return r.operandExpr.ResultType()
+}
+
+// RoundFunction rounds half away from zero, to an optional number of decimal places.
+type RoundFunction struct {
+	roundingFunction
+}
+
+func NewRoundFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*RoundFunction, error) {
+	r, err := newRoundingFunction("round", argExprs, desc, roundingModeHalfUp)
+	if err != nil {
+		return nil, err
+	}
+	return &RoundFunction{roundingFunction: r}, nil
+}
+
+type FloorFunction struct {
+	roundingFunction
+}
+
+func NewFloorFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*FloorFunction, error) {
+	r, err := newRoundingFunction("floor", argExprs, desc, roundingModeFloor)
+	if err != nil {
+		return nil, err
+	}
+	return &FloorFunction{roundingFunction: r}, nil
+}
+
+type CeilFunction struct {
+	roundingFunction
+}
+
+func NewCeilFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*CeilFunction, error) {
+	r, err := newRoundingFunction("ceil", argExprs, desc, roundingModeCeil)
+	if err != nil {
+		return nil, err
+	}
+	return &CeilFunction{roundingFunction: r}, nil
+}
+
+type PowFunction struct {
+	baseExpr
+	baseArg     Expression
+	exponentArg Expression
+}
+
+func NewPowFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*PowFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'pow' requires 2 arguments - %d found", len(argExprs))
+	}
+	if !isNumericType(argExprs[0].ResultType()) {
+		return nil, desc.ErrorAtPosition("'pow' first argument must be of type int, float or decimal - it is of type %s",
+			argExprs[0].ResultType().String())
+	}
+	if !isNumericType(argExprs[1].ResultType()) {
+		return nil, desc.ErrorAtPosition("'pow' second argument must be of type int, float or decimal - it is of type %s",
+			argExprs[1].ResultType().String())
+	}
+	return &PowFunction{
+		baseArg:     argExprs[0],
+		exponentArg: argExprs[1],
+	}, nil
+}
+
+func (p *PowFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	base, null, err := evalAsFloat(p.baseArg, rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	exponent, null, err := evalAsFloat(p.exponentArg, rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	return math.Pow(base, exponent), false, nil
+}
+
+func (p *PowFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeFloat
+}
+
+// floatMathFunction applies a float function to an int, float or decimal operand. The result is always a float.
+type floatMathFunction struct {
+	baseExpr
+	operandExpr Expression
+	f           func(float64) float64
+}
+
+func newFloatMathFunction(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc,
+	f func(float64) float64) (floatMathFunction, error) {
+	if err := checkNumericArg(functionName, argExprs, desc); err != nil {
+		return floatMathFunction{}, err
+	}
+	return floatMathFunction{
+		operandExpr: argExprs[0],
+		f:           f,
+	}, nil
+}
+
+func (m *floatMathFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	val, null, err := evalAsFloat(m.operandExpr, rowIndex, batch)
+	if err != nil {
+		return 0, false, err
+	}
+	if null {
+		return 0, true, nil
+	}
+	return m.f(val), false, nil
+}
+
+func (m *floatMathFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeFloat
+}
+
+type SqrtFunction struct {
+	floatMathFunction
+}
+
+func NewSqrtFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*SqrtFunction, error) {
+	m, err := newFloatMathFunction("sqrt", argExprs, desc, math.Sqrt)
+	if err != nil {
+		return nil, err
+	}
+	return &SqrtFunction{floatMathFunction: m}, nil
+}
+
+// LogFunction returns the natural logarithm of its operand.
+type LogFunction struct {
+	floatMathFunction
+}
+
+func NewLogFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*LogFunction, error) {
+	m, err := newFloatMathFunction("log", argExprs, desc, math.Log)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFunction{floatMathFunction: m}, nil
+}
+
+type ExpFunction struct {
+	floatMathFunction
+}
+
+func NewExpFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ExpFunction, error) {
+	m, err := newFloatMathFunction("exp", argExprs, desc, math.Exp)
+	if err != nil {
+		return nil, err
+	}
+	return &ExpFunction{floatMathFunction: m}, nil
+}
+
+// SignFunction returns -1, 0 or 1 as an int, depending on whether the operand is negative, zero or positive.
+type SignFunction struct {
+	baseExpr
+	operandExpr Expression
+}
+
+func NewSignFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*SignFunction, error) {
+	if err := checkNumericArg("sign", argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &SignFunction{
+		operandExpr: argExprs[0],
+	}, nil
+}
+
+func (s *SignFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	switch s.operandExpr.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		val, null, err := s.operandExpr.EvalInt(rowIndex, batch)
+		if err != nil || null {
+			return 0, null, err
+		}
+		if val < 0 {
+			return -1, false, nil
+		} else if val > 0 {
+			return 1, false, nil
+		}
+		return 0, false, nil
+	case types.ColumnTypeIDFloat:
+		val, null, err := s.operandExpr.EvalFloat(rowIndex, batch)
+		if err != nil || null {
+			return 0, null, err
+		}
+		if val < 0 {
+			return -1, false, nil
+		} else if val > 0 {
+			return 1, false, nil
+		}
+		return 0, false, nil
+	default:
+		val, null, err := s.operandExpr.EvalDecimal(rowIndex, batch)
+		if err != nil || null {
+			return 0, null, err
+		}
+		return int64(val.Num.Sign()), false, nil
+	}
+}
+
+func (s *SignFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+// extremeFunction is the basis of the greatest and least functions. Null arguments are ignored, the result is only
+// null if all arguments are null.
+type extremeFunction struct {
+	baseExpr
+	argExprs []Expression
+	greatest bool
+}
+
+func newExtremeFunction(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc,
+	greatest bool) (extremeFunction, error) {
+	if len(argExprs) < 2 {
+		return extremeFunction{}, desc.ErrorAtPosition("'%s' requires at least 2 arguments - %d found", functionName,
+			len(argExprs))
+	}
+	firstType := argExprs[0].ResultType()
+	if !isNumericType(firstType) {
+		return extremeFunction{}, desc.ErrorAtPosition("'%s' arguments must be of type int, float or decimal - first arg has type %s",
+			functionName, firstType.String())
+	}
+	for i := 1; i < len(argExprs); i++ {
+		if !types.ColumnTypesEqual(firstType, argExprs[i].ResultType()) {
+			return extremeFunction{}, desc.ErrorAtPosition("'%s' arguments must have same type - first arg has type %s - arg at position %d has type %s",
+				functionName, firstType.String(), i, argExprs[i].ResultType().String())
+		}
+	}
+	return extremeFunction{
+		argExprs: argExprs,
+		greatest: greatest,
+	}, nil
+}
+
+func (e *extremeFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	var res int64
+	found := false
+	for _, argExpr := range e.argExprs {
+		val, null, err := argExpr.EvalInt(rowIndex, batch)
+		if err != nil {
+			return 0, false, err
+		}
+		if null {
+			continue
+		}
+		if !found || (e.greatest && val > res) || (!e.greatest && val < res) {
+			res = val
+			found = true
+		}
+	}
+	return res, !found, nil
+}
+
+func (e *extremeFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	var res float64
+	found := false
+	for _, argExpr := range e.argExprs {
+		val, null, err := argExpr.EvalFloat(rowIndex, batch)
+		if err != nil {
+			return 0, false, err
+		}
+		if null {
+			continue
+		}
+		if !found || (e.greatest && val > res) || (!e.greatest && val < res) {
+			res = val
+			found = true
+		}
+	}
+	return res, !found, nil
+}
+
+func (e *extremeFunction) EvalDecimal(rowIndex int, batch *evbatch.Batch) (types.Decimal, bool, error) {
+	var res types.Decimal
+	found := false
+	for _, argExpr := range e.argExprs {
+		val, null, err := argExpr.EvalDecimal(rowIndex, batch)
+		if err != nil {
+			return types.Decimal{}, false, err
+		}
+		if null {
+			continue
+		}
+		if !found || (e.greatest && val.GreaterThan(&res)) || (!e.greatest && val.LessThan(&res)) {
+			res = val
+			found = true
+		}
+	}
+	return res, !found, nil
+}
+
+func (e *extremeFunction) ResultType() types.ColumnType {
+	return e.argExprs[0].ResultType()
+}
+
+type GreatestFunction struct {
+	extremeFunction
+}
+
+func NewGreatestFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*GreatestFunction, error) {
+	e, err := newExtremeFunction("greatest", argExprs, desc, true)
+	if err != nil {
+		return nil, err
+	}
+	return &GreatestFunction{extremeFunction: e}, nil
+}
+
+type LeastFunction struct {
+	extremeFunction
+}
+
+func NewLeastFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*LeastFunction, error) {
+	e, err := newExtremeFunction("least", argExprs, desc, false)
+	if err != nil {
+		return nil, err
+	}
+	return &LeastFunction{extremeFunction: e}, nil
+}
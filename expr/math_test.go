@@ -0,0 +1,342 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRoundFunctionInt(t *testing.T) {
+	argCol := createIntCol([]bool{false, false, false, false, true}, []int64{1249, 1250, -1250, 7, 0})
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeInt, nil, argCol)
+	expected := createIntCol([]bool{false, false, false, false, true}, []int64{1200, 1300, -1300, 0, 0})
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeInt, NewIntegerConstantExpr(-2), expected)
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeInt, NewIntegerConstantExpr(-20),
+		createIntCol([]bool{false, false, false, false, true}, []int64{0, 0, 0, 0, 0}))
+}
+
+func TestRoundFunctionFloat(t *testing.T) {
+	argCol := createFloatCol([]bool{false, false, false, false, true}, []float64{2.5, -2.5, 1.25, 1234.5, 0})
+	expected := createFloatCol([]bool{false, false, false, false, true}, []float64{3, -3, 1, 1235, 0})
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeFloat, nil, expected)
+	expected = createFloatCol([]bool{false, false, false, false, true}, []float64{2.5, -2.5, 1.3, 1234.5, 0})
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeFloat, NewIntegerConstantExpr(1), expected)
+	expected = createFloatCol([]bool{false, false, false, false, true}, []float64{0, 0, 0, 1200, 0})
+	testMathFunction(t, NewRoundFunction, argCol, types.ColumnTypeFloat, NewIntegerConstantExpr(-2), expected)
+}
+
+func TestRoundFunctionDecimal(t *testing.T) {
+	decType := &types.DecimalType{Precision: 10, Scale: 3}
+	argCol := createDecimalCol(10, 3, []bool{false, false, false, false, true}, []string{"1.245", "-1.245", "1.244", "99.5", "0"})
+	expected := createDecimalCol(10, 3, []bool{false, false, false, false, true}, []string{"1", "-1", "1", "100", "0"})
+	testMathFunction(t, NewRoundFunction, argCol, decType, nil, expected)
+	expected = createDecimalCol(10, 3, []bool{false, false, false, false, true}, []string{"1.25", "-1.25", "1.24", "99.5", "0"})
+	testMathFunction(t, NewRoundFunction, argCol, decType, NewIntegerConstantExpr(2), expected)
+	testMathFunction(t, NewRoundFunction, argCol, decType, NewIntegerConstantExpr(5), argCol)
+	expected = createDecimalCol(10, 3, []bool{false, false, false, false, true}, []string{"0", "0", "0", "100", "0"})
+	testMathFunction(t, NewRoundFunction, argCol, decType, NewIntegerConstantExpr(-1), expected)
+	expected = createDecimalCol(10, 3, []bool{false, false, false, false, true}, []string{"0", "0", "0", "0", "0"})
+	testMathFunction(t, NewRoundFunction, argCol, decType, NewIntegerConstantExpr(math.MinInt64), expected)
+}
+
+func TestRoundFunctionDecimalOverflow(t *testing.T) {
+	decType := &types.DecimalType{Precision: 4, Scale: 2}
+	argCol := createDecimalCol(4, 2, []bool{false}, []string{"99.99"})
+	fun, err := NewRoundFunction([]Expression{&ColumnExpr{colIndex: 0, exprType: decType}}, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{decType}), argCol)
+	_, err = EvalColumn(fun, batch)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "result of rounding 99.99 does not fit in precision 4"))
+}
+
+func TestFloorFunction(t *testing.T) {
+	intCol := createIntCol([]bool{false, false, true}, []int64{-15, 15, 0})
+	testMathFunction(t, NewFloorFunction, intCol, types.ColumnTypeInt, nil, intCol)
+	testMathFunction(t, NewFloorFunction, intCol, types.ColumnTypeInt, NewIntegerConstantExpr(-1),
+		createIntCol([]bool{false, false, true}, []int64{-20, 10, 0}))
+
+	floatCol := createFloatCol([]bool{false, false, true}, []float64{-1.5, 1.5, 0})
+	testMathFunction(t, NewFloorFunction, floatCol, types.ColumnTypeFloat, nil,
+		createFloatCol([]bool{false, false, true}, []float64{-2, 1, 0}))
+
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	decCol := createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-1.01", "1.99", "3", "0"})
+	testMathFunction(t, NewFloorFunction, decCol, decType, nil,
+		createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-2", "1", "3", "0"}))
+	testMathFunction(t, NewFloorFunction, decCol, decType, NewIntegerConstantExpr(1),
+		createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-1.1", "1.9", "3", "0"}))
+}
+
+func TestCeilFunction(t *testing.T) {
+	intCol := createIntCol([]bool{false, false, true}, []int64{-15, 15, 0})
+	testMathFunction(t, NewCeilFunction, intCol, types.ColumnTypeInt, nil, intCol)
+	testMathFunction(t, NewCeilFunction, intCol, types.ColumnTypeInt, NewIntegerConstantExpr(-1),
+		createIntCol([]bool{false, false, true}, []int64{-10, 20, 0}))
+
+	floatCol := createFloatCol([]bool{false, false, true}, []float64{-1.5, 1.5, 0})
+	testMathFunction(t, NewCeilFunction, floatCol, types.ColumnTypeFloat, nil,
+		createFloatCol([]bool{false, false, true}, []float64{-1, 2, 0}))
+
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	decCol := createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-1.01", "1.01", "3", "0"})
+	testMathFunction(t, NewCeilFunction, decCol, decType, nil,
+		createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-1", "2", "3", "0"}))
+}
+
+func TestRoundIntBounds(t *testing.T) {
+	testRoundInt(t, math.MaxInt64, -18, roundingModeHalfUp, 9000000000000000000)
+	testRoundInt(t, math.MinInt64, -18, roundingModeHalfUp, -9000000000000000000)
+	testRoundInt(t, math.MaxInt64, -18, roundingModeFloor, 9000000000000000000)
+	testRoundInt(t, math.MinInt64, -18, roundingModeCeil, -9000000000000000000)
+	testRoundInt(t, 4999999999999999999, -19, roundingModeHalfUp, 0)
+	testRoundInt(t, math.MinInt64, -20, roundingModeHalfUp, 0)
+	testRoundInt(t, math.MaxInt64, -20, roundingModeHalfUp, 0)
+	testRoundInt(t, math.MaxInt64, -20, roundingModeFloor, 0)
+	testRoundInt(t, math.MinInt64, -20, roundingModeCeil, 0)
+	testRoundInt(t, math.MaxInt64, math.MinInt64, roundingModeHalfUp, 0)
+	testRoundInt(t, math.MinInt64, 0, roundingModeFloor, math.MinInt64)
+
+	// Results that do not fit in an int64 are errors
+	testRoundIntOverflow(t, math.MaxInt64, -1, roundingModeHalfUp)
+	testRoundIntOverflow(t, math.MinInt64, -1, roundingModeHalfUp)
+	testRoundIntOverflow(t, math.MaxInt64, -1, roundingModeCeil)
+	testRoundIntOverflow(t, math.MinInt64, -1, roundingModeFloor)
+	testRoundIntOverflow(t, math.MaxInt64, -19, roundingModeHalfUp)
+	testRoundIntOverflow(t, math.MinInt64, -19, roundingModeHalfUp)
+	testRoundIntOverflow(t, 1, -20, roundingModeCeil)
+	testRoundIntOverflow(t, -1, -20, roundingModeFloor)
+	testRoundIntOverflow(t, -1, math.MinInt64, roundingModeFloor)
+}
+
+func testRoundInt(t *testing.T, val int64, places int64, mode roundingMode, expected int64) {
+	res, err := roundInt(val, places, mode)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+}
+
+func testRoundIntOverflow(t *testing.T, val int64, places int64, mode roundingMode) {
+	_, err := roundInt(val, places, mode)
+	require.Error(t, err)
+	require.Equal(t, fmt.Sprintf("result of rounding %d does not fit in an int", val), err.Error())
+}
+
+func TestRoundFloatBounds(t *testing.T) {
+	// Rounding to more places than a float64 has digits has no effect
+	require.Equal(t, 2.5, roundFloat(2.5, 400, roundingModeHalfUp))
+	require.Equal(t, 2.5, roundFloat(2.5, math.MaxInt64, roundingModeFloor))
+	require.Equal(t, 0.1, roundFloat(0.1, 17, roundingModeCeil))
+	require.Equal(t, 0.0, roundFloat(0, 400, roundingModeHalfUp))
+	require.Equal(t, 1e300, roundFloat(1e300, 10, roundingModeHalfUp))
+
+	// Rounding to very negative places gives zero, with the sign of the value, unless rounding away from zero
+	require.Equal(t, 0.0, roundFloat(2.5, -400, roundingModeHalfUp))
+	require.True(t, math.Signbit(roundFloat(-2.5, -400, roundingModeHalfUp)))
+	require.Equal(t, 0.0, roundFloat(2.5, math.MinInt64, roundingModeFloor))
+	require.True(t, math.IsInf(roundFloat(-2.5, -400, roundingModeFloor), -1))
+	require.True(t, math.IsInf(roundFloat(2.5, -400, roundingModeCeil), 1))
+	require.Equal(t, -1e300, roundFloat(-1e-300, -300, roundingModeFloor))
+	require.Equal(t, 1e300, roundFloat(1e-300, -300, roundingModeCeil))
+
+	require.True(t, math.IsNaN(roundFloat(math.NaN(), 2, roundingModeHalfUp)))
+	require.True(t, math.IsInf(roundFloat(math.Inf(1), -2, roundingModeHalfUp), 1))
+}
+
+func TestRoundingFunctionIntOverflow(t *testing.T) {
+	for _, factory := range []func([]Expression, *parser.FunctionExprDesc) (Expression, error){
+		func(args []Expression, desc *parser.FunctionExprDesc) (Expression, error) {
+			return NewRoundFunction(args, desc)
+		},
+		func(args []Expression, desc *parser.FunctionExprDesc) (Expression, error) {
+			return NewCeilFunction(args, desc)
+		},
+	} {
+		fun, err := factory([]Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeInt},
+			NewIntegerConstantExpr(-1)}, &parser.FunctionExprDesc{})
+		require.NoError(t, err)
+		schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeInt})
+		_, err = EvalColumn(fun, evbatch.NewBatch(schema, createIntCol([]bool{false}, []int64{math.MaxInt64})))
+		require.Error(t, err)
+		require.Equal(t, "result of rounding 9223372036854775807 does not fit in an int", err.Error())
+	}
+}
+
+func TestRoundingFunctionNullPlaces(t *testing.T) {
+	argCol := createIntCol([]bool{false}, []int64{1250})
+	placesCol := createIntCol([]bool{true}, []int64{0})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeInt},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeInt}}
+	fun, err := NewRoundFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, argCol, placesCol))
+	require.NoError(t, err)
+	colsEqual(t, createIntCol([]bool{true}, []int64{0}), res)
+}
+
+func TestRoundingFunctionArgs(t *testing.T) {
+	for _, factory := range []func([]Expression, *parser.FunctionExprDesc) (Expression, error){
+		func(args []Expression, desc *parser.FunctionExprDesc) (Expression, error) {
+			return NewRoundFunction(args, desc)
+		},
+		func(args []Expression, desc *parser.FunctionExprDesc) (Expression, error) {
+			return NewFloorFunction(args, desc)
+		},
+		func(args []Expression, desc *parser.FunctionExprDesc) (Expression, error) {
+			return NewCeilFunction(args, desc)
+		},
+	} {
+		_, err := factory(nil, &parser.FunctionExprDesc{})
+		requireStatementError(t, err, "requires 1 or 2 arguments - 0 found")
+		_, err = factory([]Expression{NewStringConstantExpr("foo")}, &parser.FunctionExprDesc{})
+		requireStatementError(t, err, "first argument must be of type int, float or decimal - it is of type string")
+		_, err = factory([]Expression{NewFloatConstantExpr(1.5), NewFloatConstantExpr(1)}, &parser.FunctionExprDesc{})
+		requireStatementError(t, err, "second argument must be of type int - it is of type float")
+	}
+}
+
+func TestPowFunction(t *testing.T) {
+	baseCol := createIntCol([]bool{false, false, true, false}, []int64{2, 9, 0, 4})
+	expCol := createFloatCol([]bool{false, false, false, true}, []float64{10, 0.5, 2, 0})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeInt},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeFloat}}
+	fun, err := NewPowFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeFloat, fun.ResultType())
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, baseCol, expCol))
+	require.NoError(t, err)
+	colsEqual(t, createFloatCol([]bool{false, false, true, true}, []float64{1024, 3, 0, 0}), res)
+
+	_, err = NewPowFunction(args[:1], &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'pow' requires 2 arguments - 1 found")
+	_, err = NewPowFunction([]Expression{args[0], NewStringConstantExpr("x")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'pow' second argument must be of type int, float or decimal - it is of type string")
+}
+
+func TestSqrtFunction(t *testing.T) {
+	argCol := createIntCol([]bool{false, false, true}, []int64{16, 2, 0})
+	expected := createFloatCol([]bool{false, false, true}, []float64{4, math.Sqrt(2), 0})
+	testMathFunction(t, NewSqrtFunction, argCol, types.ColumnTypeInt, nil, expected)
+}
+
+func TestLogFunction(t *testing.T) {
+	argCol := createFloatCol([]bool{false, false, true}, []float64{1, math.E, 0})
+	expected := createFloatCol([]bool{false, false, true}, []float64{0, 1, 0})
+	testMathFunction(t, NewLogFunction, argCol, types.ColumnTypeFloat, nil, expected)
+}
+
+func TestExpFunction(t *testing.T) {
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	argCol := createDecimalCol(10, 2, []bool{false, false, true}, []string{"0", "1", "0"})
+	expected := createFloatCol([]bool{false, false, true}, []float64{1, math.E, 0})
+	testMathFunction(t, NewExpFunction, argCol, decType, nil, expected)
+}
+
+func TestFloatMathFunctionArgs(t *testing.T) {
+	_, err := NewSqrtFunction(nil, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'sqrt' requires 1 argument - 0 found")
+	_, err = NewLogFunction([]Expression{NewStringConstantExpr("x")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'log' argument must be of type int, float or decimal - it is of type string")
+	_, err = NewExpFunction([]Expression{NewBoolConstantExpr(true)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'exp' argument must be of type int, float or decimal - it is of type bool")
+}
+
+func TestSignFunction(t *testing.T) {
+	expected := createIntCol([]bool{false, false, false, true}, []int64{-1, 0, 1, 0})
+	testMathFunction(t, NewSignFunction, createIntCol([]bool{false, false, false, true}, []int64{-10, 0, 3, 0}),
+		types.ColumnTypeInt, nil, expected)
+	testMathFunction(t, NewSignFunction, createFloatCol([]bool{false, false, false, true}, []float64{-0.5, 0, 3.5, 0}),
+		types.ColumnTypeFloat, nil, expected)
+	testMathFunction(t, NewSignFunction, createDecimalCol(10, 2, []bool{false, false, false, true}, []string{"-0.01", "0", "3.5", "0"}),
+		&types.DecimalType{Precision: 10, Scale: 2}, nil, expected)
+
+	_, err := NewSignFunction([]Expression{NewStringConstantExpr("x")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'sign' argument must be of type int, float or decimal - it is of type string")
+}
+
+func TestGreatestAndLeastFunctionInt(t *testing.T) {
+	col0 := createIntCol([]bool{false, true, false, true}, []int64{1, 0, 7, 0})
+	col1 := createIntCol([]bool{false, false, false, true}, []int64{5, 3, -2, 0})
+	col2 := createIntCol([]bool{false, false, true, true}, []int64{3, 4, 0, 0})
+	testExtremeFunction(t, types.ColumnTypeInt, []evbatch.Column{col0, col1, col2},
+		createIntCol([]bool{false, false, false, true}, []int64{5, 4, 7, 0}),
+		createIntCol([]bool{false, false, false, true}, []int64{1, 3, -2, 0}))
+}
+
+func TestGreatestAndLeastFunctionFloat(t *testing.T) {
+	col0 := createFloatCol([]bool{false, true, false}, []float64{1.5, 0, 7.25})
+	col1 := createFloatCol([]bool{false, false, false}, []float64{-5.5, 3.5, 7.5})
+	testExtremeFunction(t, types.ColumnTypeFloat, []evbatch.Column{col0, col1},
+		createFloatCol([]bool{false, false, false}, []float64{1.5, 3.5, 7.5}),
+		createFloatCol([]bool{false, false, false}, []float64{-5.5, 3.5, 7.25}))
+}
+
+func TestGreatestAndLeastFunctionDecimal(t *testing.T) {
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	col0 := createDecimalCol(10, 2, []bool{false, true, false}, []string{"1.01", "0", "-7.25"})
+	col1 := createDecimalCol(10, 2, []bool{false, false, false}, []string{"1.02", "3.5", "-7.5"})
+	testExtremeFunction(t, decType, []evbatch.Column{col0, col1},
+		createDecimalCol(10, 2, []bool{false, false, false}, []string{"1.02", "3.5", "-7.25"}),
+		createDecimalCol(10, 2, []bool{false, false, false}, []string{"1.01", "3.5", "-7.5"}))
+}
+
+func TestGreatestAndLeastFunctionArgs(t *testing.T) {
+	_, err := NewGreatestFunction([]Expression{NewIntegerConstantExpr(1)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'greatest' requires at least 2 arguments - 1 found")
+	_, err = NewLeastFunction([]Expression{NewStringConstantExpr("a"), NewStringConstantExpr("b")}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'least' arguments must be of type int, float or decimal - first arg has type string")
+	_, err = NewGreatestFunction([]Expression{NewIntegerConstantExpr(1), NewFloatConstantExpr(2)}, &parser.FunctionExprDesc{})
+	requireStatementError(t, err, "'greatest' arguments must have same type - first arg has type int - arg at position 1 has type float")
+}
+
+func testExtremeFunction(t *testing.T, colType types.ColumnType, cols []evbatch.Column, expectedGreatest evbatch.Column,
+	expectedLeast evbatch.Column) {
+	var args []Expression
+	var names []string
+	var colTypes []types.ColumnType
+	for i := range cols {
+		args = append(args, &ColumnExpr{colIndex: i, exprType: colType})
+		names = append(names, "c")
+		colTypes = append(colTypes, colType)
+	}
+	batch := evbatch.NewBatch(evbatch.NewEventSchema(names, colTypes), cols...)
+	greatest, err := NewGreatestFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	require.Equal(t, colType, greatest.ResultType())
+	res, err := EvalColumn(greatest, batch)
+	require.NoError(t, err)
+	colsEqual(t, expectedGreatest, res)
+	least, err := NewLeastFunction(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	res, err = EvalColumn(least, batch)
+	require.NoError(t, err)
+	colsEqual(t, expectedLeast, res)
+}
+
+func testMathFunction[E Expression](t *testing.T, factory func([]Expression, *parser.FunctionExprDesc) (E, error),
+	argCol evbatch.Column, argType types.ColumnType, secondArg Expression, expected evbatch.Column) {
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: argType}}
+	if secondArg != nil {
+		args = append(args, secondArg)
+	}
+	fun, err := factory(args, &parser.FunctionExprDesc{})
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{argType})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, argCol))
+	require.NoError(t, err)
+	colsEqual(t, expected, res)
+}
+
+func requireStatementError(t *testing.T, err error, msg string) {
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.StatementError))
+	require.True(t, strings.Contains(err.Error(), msg), err.Error())
+}
@@ -0,0 +1,480 @@
+package expr
+
+import (
+	"encoding/json"
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+)
+
+// Functions that create and access array, map and struct values
+
+// evalAny evaluates the expression, returning the value as the Go type used for its result type.
+func evalAny(e Expression, rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	var val any
+	var null bool
+	var err error
+	switch e.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		val, null, err = e.EvalInt(rowIndex, batch)
+	case types.ColumnTypeIDFloat:
+		val, null, err = e.EvalFloat(rowIndex, batch)
+	case types.ColumnTypeIDBool:
+		val, null, err = e.EvalBool(rowIndex, batch)
+	case types.ColumnTypeIDDecimal:
+		val, null, err = e.EvalDecimal(rowIndex, batch)
+	case types.ColumnTypeIDString:
+		val, null, err = e.EvalString(rowIndex, batch)
+	case types.ColumnTypeIDBytes:
+		val, null, err = e.EvalBytes(rowIndex, batch)
+	case types.ColumnTypeIDTimestamp:
+		val, null, err = e.EvalTimestamp(rowIndex, batch)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		val, null, err = e.EvalNested(rowIndex, batch)
+	default:
+		panic("unknown type")
+	}
+	if err != nil || null {
+		return nil, null, err
+	}
+	return val, false, nil
+}
+
+// evalNestedJSON evaluates an expression with a nested result type and returns the value as a JSON string.
+func evalNestedJSON(e Expression, rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	val, null, err := e.EvalNested(rowIndex, batch)
+	if err != nil {
+		return "", false, err
+	}
+	if null {
+		return "", true, nil
+	}
+	buff, err := json.Marshal(types.ToJSONValue(e.ResultType(), val))
+	if err != nil {
+		return "", false, err
+	}
+	return string(buff), false, nil
+}
+
+// nestedElementExpr implements the Eval methods of a function that returns an element of a nested value, and so can
+// have any result type. The function provides evalElement, which returns the element as the Go type used for its type.
+type nestedElementExpr struct {
+	elemType    types.ColumnType
+	evalElement func(rowIndex int, batch *evbatch.Batch) (any, bool, error)
+}
+
+func (n *nestedElementExpr) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return val.(int64), false, nil
+}
+
+func (n *nestedElementExpr) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return val.(float64), false, nil
+}
+
+func (n *nestedElementExpr) EvalBool(rowIndex int, batch *evbatch.Batch) (bool, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return false, null, err
+	}
+	return val.(bool), false, nil
+}
+
+func (n *nestedElementExpr) EvalDecimal(rowIndex int, batch *evbatch.Batch) (types.Decimal, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return types.Decimal{}, null, err
+	}
+	return val.(types.Decimal), false, nil
+}
+
+func (n *nestedElementExpr) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	return val.(string), false, nil
+}
+
+func (n *nestedElementExpr) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	return val.([]byte), false, nil
+}
+
+func (n *nestedElementExpr) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	return val.(types.Timestamp), false, nil
+}
+
+func (n *nestedElementExpr) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := n.evalElement(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	return val, false, nil
+}
+
+func (n *nestedElementExpr) ResultType() types.ColumnType {
+	return n.elemType
+}
+
+type ArrayFunction struct {
+	baseExpr
+	elemExprs  []Expression
+	resultType types.ColumnType
+}
+
+func NewArrayFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ArrayFunction, error) {
+	if len(argExprs) == 0 {
+		return nil, desc.ErrorAtPosition("'array' requires at least 1 argument - 0 found")
+	}
+	elemType := argExprs[0].ResultType()
+	for i, argExpr := range argExprs[1:] {
+		if !types.ColumnTypesEqual(elemType, argExpr.ResultType()) {
+			return nil, desc.ErrorAtPosition("'array' arguments must have same type - first arg has type %s - arg at position %d has type %s",
+				elemType.String(), i+1, argExpr.ResultType().String())
+		}
+	}
+	return &ArrayFunction{
+		elemExprs:  argExprs,
+		resultType: &types.ArrayType{ElemType: elemType},
+	}, nil
+}
+
+func (a *ArrayFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	arr := make([]any, len(a.elemExprs))
+	for i, elemExpr := range a.elemExprs {
+		val, _, err := evalAny(elemExpr, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		arr[i] = val
+	}
+	return arr, false, nil
+}
+
+func (a *ArrayFunction) ResultType() types.ColumnType {
+	return a.resultType
+}
+
+type MapFunction struct {
+	baseExpr
+	keyExprs   []Expression
+	valueExprs []Expression
+	resultType types.ColumnType
+}
+
+func NewMapFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*MapFunction, error) {
+	if len(argExprs) == 0 || len(argExprs)%2 == 1 {
+		return nil, desc.ErrorAtPosition("'map' requires an even number of arguments, at least 2 - %d found", len(argExprs))
+	}
+	valueType := argExprs[1].ResultType()
+	var keyExprs, valueExprs []Expression
+	for i := 0; i < len(argExprs); i += 2 {
+		keyExpr := argExprs[i]
+		if keyExpr.ResultType() != types.ColumnTypeString {
+			return nil, desc.ErrorAtPosition("'map' key argument at position %d must be of type string - it is of type %s",
+				i, keyExpr.ResultType().String())
+		}
+		valueExpr := argExprs[i+1]
+		if !types.ColumnTypesEqual(valueType, valueExpr.ResultType()) {
+			return nil, desc.ErrorAtPosition("'map' values must have same type - first value has type %s - arg at position %d has type %s",
+				valueType.String(), i+1, valueExpr.ResultType().String())
+		}
+		keyExprs = append(keyExprs, keyExpr)
+		valueExprs = append(valueExprs, valueExpr)
+	}
+	return &MapFunction{
+		keyExprs:   keyExprs,
+		valueExprs: valueExprs,
+		resultType: &types.MapType{ValueType: valueType},
+	}, nil
+}
+
+func (m *MapFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	res := make(map[string]any, len(m.keyExprs))
+	for i, keyExpr := range m.keyExprs {
+		key, null, err := keyExpr.EvalString(rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		if null {
+			return nil, false, errors.New("'map' key cannot be null")
+		}
+		val, _, err := evalAny(m.valueExprs[i], rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		res[key] = val
+	}
+	return res, false, nil
+}
+
+func (m *MapFunction) ResultType() types.ColumnType {
+	return m.resultType
+}
+
+type NamedStructFunction struct {
+	baseExpr
+	fieldExprs []Expression
+	resultType types.ColumnType
+}
+
+func NewNamedStructFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*NamedStructFunction, error) {
+	if len(argExprs) == 0 || len(argExprs)%2 == 1 {
+		return nil, desc.ErrorAtPosition("'named_struct' requires an even number of arguments, at least 2 - %d found", len(argExprs))
+	}
+	structType := &types.StructType{}
+	var fieldExprs []Expression
+	for i := 0; i < len(argExprs); i += 2 {
+		nameExpr, ok := argExprs[i].(*StringConstantExpr)
+		if !ok {
+			return nil, desc.ArgExprs[i].ErrorAtPosition("'named_struct' field name at position %d must be a string literal", i)
+		}
+		if structType.FieldIndex(nameExpr.val) != -1 {
+			return nil, desc.ArgExprs[i].ErrorAtPosition("'named_struct' field '%s' is duplicated", nameExpr.val)
+		}
+		structType.FieldNames = append(structType.FieldNames, nameExpr.val)
+		structType.FieldTypes = append(structType.FieldTypes, argExprs[i+1].ResultType())
+		fieldExprs = append(fieldExprs, argExprs[i+1])
+	}
+	return &NamedStructFunction{
+		fieldExprs: fieldExprs,
+		resultType: structType,
+	}, nil
+}
+
+func (n *NamedStructFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	fields := make([]any, len(n.fieldExprs))
+	for i, fieldExpr := range n.fieldExprs {
+		val, _, err := evalAny(fieldExpr, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		fields[i] = val
+	}
+	return fields, false, nil
+}
+
+func (n *NamedStructFunction) ResultType() types.ColumnType {
+	return n.resultType
+}
+
+type ElementAtFunction struct {
+	nestedElementExpr
+	operand  Expression
+	keyExpr  Expression
+	arrayArg bool
+}
+
+// NewElementAtFunction creates a function that returns the element of an array at a 1-based index, or the value of a
+// map for a key. A negative index counts back from the end of the array. If there is no such element the result is null.
+func NewElementAtFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*ElementAtFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'element_at' requires 2 arguments - %d found", len(argExprs))
+	}
+	operand := argExprs[0]
+	keyExpr := argExprs[1]
+	f := &ElementAtFunction{operand: operand, keyExpr: keyExpr}
+	switch operandType := operand.ResultType().(type) {
+	case *types.ArrayType:
+		if keyExpr.ResultType() != types.ColumnTypeInt {
+			return nil, desc.ErrorAtPosition("'element_at' second argument must be of type int when first argument is an array - it is of type %s",
+				keyExpr.ResultType().String())
+		}
+		f.elemType = operandType.ElemType
+		f.arrayArg = true
+	case *types.MapType:
+		if keyExpr.ResultType() != types.ColumnTypeString {
+			return nil, desc.ErrorAtPosition("'element_at' second argument must be of type string when first argument is a map - it is of type %s",
+				keyExpr.ResultType().String())
+		}
+		f.elemType = operandType.ValueType
+	default:
+		return nil, desc.ErrorAtPosition("'element_at' first argument must be an array or a map - it is of type %s",
+			operand.ResultType().String())
+	}
+	f.evalElement = f.eval
+	return f, nil
+}
+
+func (e *ElementAtFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := e.operand.EvalNested(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	if e.arrayArg {
+		index, null, err := e.keyExpr.EvalInt(rowIndex, batch)
+		if err != nil || null {
+			return nil, null, err
+		}
+		arr := val.([]any)
+		if index < 0 {
+			index += int64(len(arr)) + 1
+		}
+		if index < 1 || index > int64(len(arr)) {
+			return nil, true, nil
+		}
+		elem := arr[index-1]
+		return elem, elem == nil, nil
+	}
+	key, null, err := e.keyExpr.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	elem, ok := val.(map[string]any)[key]
+	if !ok || elem == nil {
+		return nil, true, nil
+	}
+	return elem, false, nil
+}
+
+type GetFieldFunction struct {
+	nestedElementExpr
+	operand    Expression
+	fieldIndex int
+}
+
+func NewGetFieldFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*GetFieldFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'get_field' requires 2 arguments - %d found", len(argExprs))
+	}
+	operand := argExprs[0]
+	structType, ok := operand.ResultType().(*types.StructType)
+	if !ok {
+		return nil, desc.ErrorAtPosition("'get_field' first argument must be a struct - it is of type %s",
+			operand.ResultType().String())
+	}
+	nameExpr, ok := argExprs[1].(*StringConstantExpr)
+	if !ok {
+		return nil, desc.ErrorAtPosition("'get_field' second argument must be a string literal")
+	}
+	fieldIndex := structType.FieldIndex(nameExpr.val)
+	if fieldIndex == -1 {
+		return nil, desc.ArgExprs[1].ErrorAtPosition("'get_field' unknown field '%s' - struct has type %s",
+			nameExpr.val, structType.String())
+	}
+	f := &GetFieldFunction{operand: operand, fieldIndex: fieldIndex}
+	f.elemType = structType.FieldTypes[fieldIndex]
+	f.evalElement = f.eval
+	return f, nil
+}
+
+func (g *GetFieldFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := g.operand.EvalNested(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	field := val.([]any)[g.fieldIndex]
+	return field, field == nil, nil
+}
+
+type SizeFunction struct {
+	baseExpr
+	operand Expression
+}
+
+func NewSizeFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*SizeFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'size' requires 1 argument - %d found", len(argExprs))
+	}
+	operandType := argExprs[0].ResultType().ID()
+	if operandType != types.ColumnTypeIDArray && operandType != types.ColumnTypeIDMap {
+		return nil, desc.ErrorAtPosition("'size' argument must be an array or a map - it is of type %s",
+			argExprs[0].ResultType().String())
+	}
+	return &SizeFunction{operand: argExprs[0]}, nil
+}
+
+func (s *SizeFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	val, null, err := s.operand.EvalNested(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	switch v := val.(type) {
+	case []any:
+		return int64(len(v)), false, nil
+	default:
+		return int64(len(v.(map[string]any))), false, nil
+	}
+}
+
+func (s *SizeFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+type MapKeysFunction struct {
+	baseExpr
+	operand    Expression
+	values     bool
+	resultType types.ColumnType
+}
+
+func NewMapKeysFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*MapKeysFunction, error) {
+	return newMapKeysOrValuesFunction(argExprs, desc, false)
+}
+
+func NewMapValuesFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*MapKeysFunction, error) {
+	return newMapKeysOrValuesFunction(argExprs, desc, true)
+}
+
+// newMapKeysOrValuesFunction creates a function that returns the keys of a map as an array, in ascending order, or
+// the values of a map as an array, in the order of their keys.
+func newMapKeysOrValuesFunction(argExprs []Expression, desc *parser.FunctionExprDesc, values bool) (*MapKeysFunction, error) {
+	if len(argExprs) != 1 {
+		return nil, desc.ErrorAtPosition("'%s' requires 1 argument - %d found", desc.FunctionName, len(argExprs))
+	}
+	mapType, ok := argExprs[0].ResultType().(*types.MapType)
+	if !ok {
+		return nil, desc.ErrorAtPosition("'%s' argument must be a map - it is of type %s", desc.FunctionName,
+			argExprs[0].ResultType().String())
+	}
+	var resultType types.ColumnType
+	if values {
+		resultType = &types.ArrayType{ElemType: mapType.ValueType}
+	} else {
+		resultType = &types.ArrayType{ElemType: types.ColumnTypeString}
+	}
+	return &MapKeysFunction{
+		operand:    argExprs[0],
+		values:     values,
+		resultType: resultType,
+	}, nil
+}
+
+func (m *MapKeysFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := m.operand.EvalNested(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	mapVal := val.(map[string]any)
+	keys := encoding.SortedMapKeys(mapVal)
+	res := make([]any, len(keys))
+	for i, k := range keys {
+		if m.values {
+			res[i] = mapVal[k]
+		} else {
+			res[i] = k
+		}
+	}
+	return res, false, nil
+}
+
+func (m *MapKeysFunction) ResultType() types.ColumnType {
+	return m.resultType
+}
@@ -0,0 +1,227 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var nestedTestArrayType = &types.ArrayType{ElemType: types.ColumnTypeInt}
+var nestedTestMapType = &types.MapType{ValueType: types.ColumnTypeString}
+var nestedTestStructType = &types.StructType{FieldNames: []string{"name", "tags"},
+	FieldTypes: []types.ColumnType{types.ColumnTypeString, &types.ArrayType{ElemType: types.ColumnTypeString}}}
+
+func createNestedTestBatch() *evbatch.Batch {
+	schema := evbatch.NewEventSchema([]string{"arr", "m", "s", "idx", "key"},
+		[]types.ColumnType{nestedTestArrayType, nestedTestMapType, nestedTestStructType, types.ColumnTypeInt,
+			types.ColumnTypeString})
+	builders := evbatch.CreateColBuilders(schema.ColumnTypes())
+	builders[0].(*evbatch.NestedColBuilder).Append([]any{int64(10), nil, int64(30)})
+	builders[1].(*evbatch.NestedColBuilder).Append(map[string]any{"b": "x", "a": "y", "c": nil})
+	builders[2].(*evbatch.NestedColBuilder).Append([]any{"alice", []any{"t1", "t2"}})
+	builders[3].(*evbatch.IntColBuilder).Append(1)
+	builders[4].(*evbatch.StringColBuilder).Append("a")
+
+	builders[0].(*evbatch.NestedColBuilder).Append([]any{})
+	builders[1].(*evbatch.NestedColBuilder).Append(map[string]any{})
+	builders[2].(*evbatch.NestedColBuilder).Append([]any{nil, nil})
+	builders[3].(*evbatch.IntColBuilder).Append(-1)
+	builders[4].(*evbatch.StringColBuilder).Append("b")
+
+	for _, builder := range builders {
+		builder.AppendNull()
+	}
+	return evbatch.NewBatchFromBuilders(schema, builders...)
+}
+
+func createNestedExpr(exprStr string, schema *evbatch.EventSchema) (Expression, error) {
+	tokens, err := parser.Lex(exprStr, true)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewParser(nil)
+	desc, err := p.ParseExpression(parser.NewParseContext(p, exprStr, tokens))
+	if err != nil {
+		return nil, err
+	}
+	return (&ExpressionFactory{}).CreateExpression(desc, schema)
+}
+
+func evalNestedExpr(t *testing.T, exprStr string, expectedType types.ColumnType) evbatch.Column {
+	batch := createNestedTestBatch()
+	e, err := createNestedExpr(exprStr, batch.Schema)
+	require.NoError(t, err)
+	require.True(t, types.ColumnTypesEqual(expectedType, e.ResultType()),
+		"expected %s actual %s", expectedType.String(), e.ResultType().String())
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	require.Equal(t, batch.RowCount, res.Len())
+	return res
+}
+
+func testNestedExpr(t *testing.T, exprStr string, expectedType types.ColumnType, expected ...any) {
+	res := evalNestedExpr(t, exprStr, expectedType)
+	nestedCol := res.(*evbatch.NestedColumn)
+	for i, exp := range expected {
+		if exp == nil {
+			require.True(t, res.IsNull(i), "row %d", i)
+		} else {
+			require.False(t, res.IsNull(i), "row %d", i)
+			require.Equal(t, exp, nestedCol.Get(i), "row %d", i)
+		}
+	}
+}
+
+func testNestedExprError(t *testing.T, exprStr string, expectedMsg string) {
+	batch := createNestedTestBatch()
+	_, err := createNestedExpr(exprStr, batch.Schema)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), expectedMsg)
+}
+
+func TestNestedColumnExpr(t *testing.T) {
+	testNestedExpr(t, "arr", nestedTestArrayType, []any{int64(10), nil, int64(30)}, []any{}, nil)
+	testNestedExpr(t, "m", nestedTestMapType, map[string]any{"b": "x", "a": "y", "c": nil}, map[string]any{}, nil)
+	testNestedExpr(t, "s", nestedTestStructType, []any{"alice", []any{"t1", "t2"}}, []any{nil, nil}, nil)
+}
+
+func TestArrayFunction(t *testing.T) {
+	testNestedExpr(t, "array(idx, 2, idx * 3)", nestedTestArrayType,
+		[]any{int64(1), int64(2), int64(3)}, []any{int64(-1), int64(2), int64(-3)}, []any{nil, int64(2), nil})
+	testNestedExpr(t, "array(arr, array(1))", &types.ArrayType{ElemType: nestedTestArrayType},
+		[]any{[]any{int64(10), nil, int64(30)}, []any{int64(1)}}, []any{[]any{}, []any{int64(1)}},
+		[]any{nil, []any{int64(1)}})
+	testNestedExprError(t, "array()", "'array' requires at least 1 argument - 0 found")
+	testNestedExprError(t, `array(1, "foo")`,
+		"'array' arguments must have same type - first arg has type int - arg at position 1 has type string")
+}
+
+func TestMapFunction(t *testing.T) {
+	testNestedExpr(t, `map("k1", idx, "k2", 23)`, &types.MapType{ValueType: types.ColumnTypeInt},
+		map[string]any{"k1": int64(1), "k2": int64(23)}, map[string]any{"k1": int64(-1), "k2": int64(23)},
+		map[string]any{"k1": nil, "k2": int64(23)})
+	testNestedExprError(t, `map("k1")`, "'map' requires an even number of arguments, at least 2 - 1 found")
+	testNestedExprError(t, `map(1, 2)`, "'map' key argument at position 0 must be of type string - it is of type int")
+	testNestedExprError(t, `map("a", 1, "b", "c")`,
+		"'map' values must have same type - first value has type int - arg at position 3 has type string")
+
+	// Null key
+	batch := createNestedTestBatch()
+	e, err := createNestedExpr(`map(key, 1)`, batch.Schema)
+	require.NoError(t, err)
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "'map' key cannot be null", err.Error())
+}
+
+func TestNamedStructFunction(t *testing.T) {
+	structType := &types.StructType{FieldNames: []string{"x", "y"},
+		FieldTypes: []types.ColumnType{types.ColumnTypeInt, nestedTestMapType}}
+	testNestedExpr(t, `named_struct("x", idx, "y", m)`, structType,
+		[]any{int64(1), map[string]any{"b": "x", "a": "y", "c": nil}}, []any{int64(-1), map[string]any{}},
+		[]any{nil, nil})
+	testNestedExprError(t, `named_struct("x")`, "'named_struct' requires an even number of arguments, at least 2 - 1 found")
+	testNestedExprError(t, `named_struct(key, 1)`, "'named_struct' field name at position 0 must be a string literal")
+	testNestedExprError(t, `named_struct("x", 1, "x", 2)`, "'named_struct' field 'x' is duplicated")
+}
+
+func TestElementAtArray(t *testing.T) {
+	res := evalNestedExpr(t, "element_at(arr, 1)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{false, true, true}, []int64{10, 0, 0}), res)
+	res = evalNestedExpr(t, "element_at(arr, 2)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{true, true, true}, []int64{0, 0, 0}), res)
+	res = evalNestedExpr(t, "element_at(arr, -1)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{false, true, true}, []int64{30, 0, 0}), res)
+	res = evalNestedExpr(t, "element_at(arr, 0)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{true, true, true}, []int64{0, 0, 0}), res)
+	res = evalNestedExpr(t, "element_at(arr, 4)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{true, true, true}, []int64{0, 0, 0}), res)
+	res = evalNestedExpr(t, "element_at(arr, idx)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{false, true, true}, []int64{10, 0, 0}), res)
+	res = evalNestedExpr(t, `element_at(get_field(s, "tags"), 2)`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, true, true}, []string{"t2", "", ""}), res)
+	testNestedExpr(t, "element_at(array(arr, arr), 1)", nestedTestArrayType,
+		[]any{int64(10), nil, int64(30)}, []any{}, nil)
+}
+
+func TestElementAtMap(t *testing.T) {
+	res := evalNestedExpr(t, `element_at(m, "a")`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, true, true}, []string{"y", "", ""}), res)
+	res = evalNestedExpr(t, `element_at(m, "c")`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{true, true, true}, []string{"", "", ""}), res)
+	res = evalNestedExpr(t, `element_at(m, key)`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, true, true}, []string{"y", "", ""}), res)
+}
+
+func TestElementAtArgs(t *testing.T) {
+	testNestedExprError(t, "element_at(arr)", "'element_at' requires 2 arguments - 1 found")
+	testNestedExprError(t, "element_at(idx, 1)", "'element_at' first argument must be an array or a map - it is of type int")
+	testNestedExprError(t, `element_at(arr, "a")`,
+		"'element_at' second argument must be of type int when first argument is an array - it is of type string")
+	testNestedExprError(t, `element_at(m, 1)`,
+		"'element_at' second argument must be of type string when first argument is a map - it is of type int")
+}
+
+func TestGetField(t *testing.T) {
+	res := evalNestedExpr(t, `get_field(s, "name")`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, true, true}, []string{"alice", "", ""}), res)
+	testNestedExpr(t, `get_field(s, "tags")`, &types.ArrayType{ElemType: types.ColumnTypeString},
+		[]any{"t1", "t2"}, nil, nil)
+	testNestedExprError(t, `get_field(arr, "name")`, "'get_field' first argument must be a struct - it is of type array<int>")
+	testNestedExprError(t, `get_field(s, key)`, "'get_field' second argument must be a string literal")
+	testNestedExprError(t, `get_field(s, "foo")`,
+		"'get_field' unknown field 'foo' - struct has type struct<name:string,tags:array<string>>")
+}
+
+func TestSizeFunction(t *testing.T) {
+	res := evalNestedExpr(t, "size(arr)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{false, false, true}, []int64{3, 0, 0}), res)
+	res = evalNestedExpr(t, "size(m)", types.ColumnTypeInt)
+	colsEqual(t, createIntCol([]bool{false, false, true}, []int64{3, 0, 0}), res)
+	testNestedExprError(t, "size(s)", "'size' argument must be an array or a map - it is of type struct<name:string,tags:array<string>>")
+}
+
+func TestMapKeysAndValues(t *testing.T) {
+	testNestedExpr(t, "map_keys(m)", &types.ArrayType{ElemType: types.ColumnTypeString},
+		[]any{"a", "b", "c"}, []any{}, nil)
+	testNestedExpr(t, "map_values(m)", &types.ArrayType{ElemType: types.ColumnTypeString},
+		[]any{"y", "x", nil}, []any{}, nil)
+	testNestedExprError(t, "map_keys(arr)", "'map_keys' argument must be a map - it is of type array<int>")
+	testNestedExprError(t, "map_values(arr)", "'map_values' argument must be a map - it is of type array<int>")
+}
+
+func TestExplodeFunction(t *testing.T) {
+	testNestedExprError(t, "explode(arr)",
+		"'explode' cannot be used in an expression as it produces multiple rows - use the 'unnest' operator instead, e.g. (unnest line_items as item)")
+}
+
+func TestNestedToString(t *testing.T) {
+	res := evalNestedExpr(t, "to_string(s)", types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, false, true},
+		[]string{`{"name":"alice","tags":["t1","t2"]}`, `{"name":null,"tags":null}`, ""}), res)
+	res = evalNestedExpr(t, "to_string(m)", types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, false, true}, []string{`{"a":"y","b":"x","c":null}`, `{}`, ""}), res)
+	res = evalNestedExpr(t, `sprintf("%s-%d", arr, idx)`, types.ColumnTypeString)
+	colsEqual(t, createStringCol([]bool{false, false, false}, []string{"[10,null,30]-1", "[]--1", "%!s(<nil>)-%!d(<nil>)"}), res)
+}
+
+func TestNestedIsNullAndIf(t *testing.T) {
+	res := evalNestedExpr(t, "is_null(arr)", types.ColumnTypeBool)
+	colsEqual(t, createBoolCol([]bool{false, false, false}, []bool{false, false, true}), res)
+	res = evalNestedExpr(t, "is_not_null(m)", types.ColumnTypeBool)
+	colsEqual(t, createBoolCol([]bool{false, false, false}, []bool{true, true, false}), res)
+	testNestedExpr(t, "if(idx > 0, arr, array(5))", nestedTestArrayType,
+		[]any{int64(10), nil, int64(30)}, []any{int64(5)}, nil)
+	testNestedExpr(t, "case(idx, 1, arr, -1, array(7), array(8))", nestedTestArrayType,
+		[]any{int64(10), nil, int64(30)}, []any{int64(7)}, nil)
+	testNestedExprError(t, `if(idx > 0, arr, array("a"))`,
+		"'if' function second and third arguments must be of same type - found array<int> and array<string>")
+	testNestedExprError(t, `case(idx, 1, arr, array("a"))`,
+		"'case' function return expressions must have same type as default expression")
+	testNestedExprError(t, `case(arr, arr, 1, 2)`, "'case' function test expression cannot be of type array<int>")
+	testNestedExprError(t, `in(arr, arr, arr)`, "'in' function arguments cannot be of type array<int>")
+	testNestedExprError(t, `arr == arr`, "operator '==' has left operand with unsupported type 'array<int>'")
+	testNestedExprError(t, `arr > arr`, "operator '>' has left operand with unsupported type 'array<int>'")
+}
@@ -143,6 +143,10 @@ func (a *AddOperator) EvalTimestamp(rowIndex int, inBatch *evbatch.Batch) (types
 	return types.NewTimestamp(left.Val + right.Val), false, nil
 }
 
+func (a *AddOperator) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
 func (a *AddOperator) ResultType() types.ColumnType {
 	return a.exprType
 }
@@ -261,6 +265,10 @@ func (s *SubtractOperator) EvalTimestamp(rowIndex int, inBatch *evbatch.Batch) (
 	return types.NewTimestamp(left.Val - right.Val), false, nil
 }
 
+func (s *SubtractOperator) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
 func (s *SubtractOperator) ResultType() types.ColumnType {
 	return s.exprType
 }
@@ -396,6 +404,10 @@ func (m *MultiplyOperator) EvalTimestamp(rowIndex int, inBatch *evbatch.Batch) (
 	return types.NewTimestamp(left.Val * right.Val), false, nil
 }
 
+func (m *MultiplyOperator) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
 func (m *MultiplyOperator) ResultType() types.ColumnType {
 	return m.exprType
 }
@@ -544,6 +556,10 @@ func (d *DivideOperator) EvalTimestamp(rowIndex int, inBatch *evbatch.Batch) (ty
 	return types.NewTimestamp(left.Val / right.Val), false, nil
 }
 
+func (d *DivideOperator) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
 func (d *DivideOperator) ResultType() types.ColumnType {
 	return d.exprType
 }
@@ -643,6 +659,11 @@ type EqualsOperator struct {
 
 func NewEqualsOperator(left Expression, right Expression, desc *parser.BinaryOperatorExprDesc) (*EqualsOperator, error) {
 
+	if types.IsNestedType(left.ResultType()) {
+		return nil, desc.ErrorAtPosition("operator '%s' has left operand with unsupported type '%s'", desc.Op,
+			left.ResultType().String())
+	}
+
 	if left.ResultType().ID() != right.ResultType().ID() {
 		return nil, desc.ErrorAtPosition("operator '%s' left operand type '%s' and right operand type '%s' are not the same.", desc.Op,
 			left.ResultType().String(), right.ResultType().String())
@@ -818,6 +839,11 @@ type NotEqualsOperator struct {
 
 func NewNotEqualsOperator(left Expression, right Expression, desc *parser.BinaryOperatorExprDesc) (*NotEqualsOperator, error) {
 
+	if types.IsNestedType(left.ResultType()) {
+		return nil, desc.ErrorAtPosition("operator '%s' has left operand with unsupported type '%s'", desc.Op,
+			left.ResultType().String())
+	}
+
 	if left.ResultType().ID() != right.ResultType().ID() {
 		return nil, desc.ErrorAtPosition("operator '%s' left operand type '%s' and right operand type '%s' are not the same.", desc.Op,
 			left.ResultType().String(), right.ResultType().String())
@@ -0,0 +1,507 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ProtobufMessageResolver resolves protobuf message types by their fully qualified name
+type ProtobufMessageResolver interface {
+	FindMessage(fullName string) (protoreflect.MessageDescriptor, error)
+}
+
+const timestampMessageName = "google.protobuf.Timestamp"
+
+// wrapperMessageNames are the well known types that wrap a single value, so it can be distinguished from the default
+var wrapperMessageNames = map[protoreflect.FullName]struct{}{
+	"google.protobuf.DoubleValue": {},
+	"google.protobuf.FloatValue":  {},
+	"google.protobuf.Int64Value":  {},
+	"google.protobuf.UInt64Value": {},
+	"google.protobuf.Int32Value":  {},
+	"google.protobuf.UInt32Value": {},
+	"google.protobuf.BoolValue":   {},
+	"google.protobuf.StringValue": {},
+	"google.protobuf.BytesValue":  {},
+}
+
+var protoJSONMarshalOptions = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+func getProtobufMessageType(argExpr Expression, argDesc parser.ExprDesc, desc *parser.FunctionExprDesc,
+	resolver ProtobufMessageResolver) (protoreflect.MessageDescriptor, error) {
+	if _, ok := argExpr.(*StringConstantExpr); !ok {
+		return nil, argDesc.ErrorAtPosition("'%s' message type argument must be a string literal", desc.FunctionName)
+	}
+	messageType, _, _ := argExpr.EvalString(0, nil)
+	if resolver == nil {
+		return nil, argDesc.ErrorAtPosition("'%s' unknown protobuf message type '%s'", desc.FunctionName, messageType)
+	}
+	md, err := resolver.FindMessage(messageType)
+	if err != nil {
+		return nil, argDesc.ErrorAtPosition("'%s' %v", desc.FunctionName, err)
+	}
+	return md, nil
+}
+
+func wrappedValueField(md protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	if _, ok := wrapperMessageNames[md.FullName()]; ok {
+		return md.Fields().ByName("value")
+	}
+	return nil
+}
+
+// protobufColumnType returns the column type that values of the field are converted to. Enums are converted to the
+// name of the value, google.protobuf.Timestamp to a timestamp and wrapper types to the type of the value they wrap.
+// Unsigned 64-bit integers are converted to int, so values larger than math.MaxInt64 are negative. Other messages,
+// repeated fields and maps are converted to JSON strings.
+func protobufColumnType(fd protoreflect.FieldDescriptor) (types.ColumnType, bool) {
+	if fd.IsList() || fd.IsMap() {
+		return types.ColumnTypeString, true
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return types.ColumnTypeBool, false
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.Int64Kind,
+		protoreflect.Sint64Kind, protoreflect.Sfixed64Kind, protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return types.ColumnTypeInt, false
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return types.ColumnTypeFloat, false
+	case protoreflect.StringKind, protoreflect.EnumKind:
+		return types.ColumnTypeString, false
+	case protoreflect.BytesKind:
+		return types.ColumnTypeBytes, false
+	default:
+		md := fd.Message()
+		if md.FullName() == timestampMessageName {
+			return types.ColumnTypeTimestamp, false
+		}
+		if valueField := wrappedValueField(md); valueField != nil {
+			return protobufColumnType(valueField)
+		}
+		return types.ColumnTypeString, true
+	}
+}
+
+// ProtobufDecodeFunction decodes a protobuf message of a registered message type. With two arguments it returns the
+// message as JSON. With three it returns the value of a field of the message, which may be a path through nested
+// messages. Fields that are not set are null if the field tracks presence, otherwise they have their default value.
+type ProtobufDecodeFunction struct {
+	valArg      Expression
+	md          protoreflect.MessageDescriptor
+	fieldPath   []protoreflect.FieldDescriptor
+	resultType  types.ColumnType
+	jsonEncoded bool
+}
+
+func NewProtobufDecodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc,
+	resolver ProtobufMessageResolver) (*ProtobufDecodeFunction, error) {
+	if len(argExprs) != 2 && len(argExprs) != 3 {
+		return nil, desc.ErrorAtPosition("'protobuf_decode' requires 2 or 3 arguments")
+	}
+	if argExprs[0].ResultType() != types.ColumnTypeBytes {
+		return nil, desc.ErrorAtPosition("'protobuf_decode' first argument must be of type bytes - it is %s",
+			argExprs[0].ResultType().String())
+	}
+	md, err := getProtobufMessageType(argExprs[1], desc.ArgExprs[1], desc, resolver)
+	if err != nil {
+		return nil, err
+	}
+	fn := &ProtobufDecodeFunction{
+		valArg:      argExprs[0],
+		md:          md,
+		resultType:  types.ColumnTypeString,
+		jsonEncoded: true,
+	}
+	if len(argExprs) == 2 {
+		return fn, nil
+	}
+	if _, ok := argExprs[2].(*StringConstantExpr); !ok {
+		return nil, desc.ArgExprs[2].ErrorAtPosition("'protobuf_decode' field argument must be a string literal")
+	}
+	fieldName, _, _ := argExprs[2].EvalString(0, nil)
+	fieldMD := md
+	for _, name := range strings.Split(fieldName, ".") {
+		var fd protoreflect.FieldDescriptor
+		if fieldMD != nil {
+			fd = fieldMD.Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return nil, desc.ArgExprs[2].ErrorAtPosition("'protobuf_decode' field '%s' does not exist in protobuf message type '%s'",
+				fieldName, md.FullName())
+		}
+		fn.fieldPath = append(fn.fieldPath, fd)
+		fieldMD = nil
+		if fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap() {
+			fieldMD = fd.Message()
+		}
+	}
+	fn.resultType, fn.jsonEncoded = protobufColumnType(fn.fieldPath[len(fn.fieldPath)-1])
+	return fn, nil
+}
+
+func (p *ProtobufDecodeFunction) eval(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	val, null, err := p.valArg.EvalBytes(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	msg := dynamicpb.NewMessage(p.md)
+	if err := proto.Unmarshal(val, msg); err != nil {
+		return nil, false, errors.Errorf("function 'protobuf_decode' - invalid %s message: %v", p.md.FullName(), err)
+	}
+	if p.fieldPath == nil {
+		s, err := marshalProtobufJSON(protoJSONMarshalOptions, msg)
+		return s, false, err
+	}
+	var m protoreflect.Message = msg
+	last := len(p.fieldPath) - 1
+	for _, fd := range p.fieldPath[:last] {
+		if !m.Has(fd) {
+			return nil, true, nil
+		}
+		m = m.Get(fd).Message()
+	}
+	fd := p.fieldPath[last]
+	if fd.HasPresence() && !m.Has(fd) {
+		return nil, true, nil
+	}
+	if p.jsonEncoded {
+		s, err := protobufFieldToJSON(m, fd)
+		return s, false, err
+	}
+	return protobufValueToColumnValue(fd, m.Get(fd)), false, nil
+}
+
+// protobufFieldToJSON converts the value of the field to JSON. protojson can only marshal messages, so repeated fields
+// and maps are marshalled as the only field of a message, and extracted.
+func protobufFieldToJSON(m protoreflect.Message, fd protoreflect.FieldDescriptor) (string, error) {
+	if !fd.IsList() && !fd.IsMap() {
+		return marshalProtobufJSON(protoJSONMarshalOptions, m.Get(fd).Message().Interface())
+	}
+	if !m.Has(fd) {
+		if fd.IsList() {
+			return "[]", nil
+		}
+		return "{}", nil
+	}
+	holder := dynamicpb.NewMessage(m.Descriptor())
+	holder.Set(fd, m.Get(fd))
+	out, err := marshalProtobufJSON(protojson.MarshalOptions{UseProtoNames: true}, holder)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out), &fields); err != nil {
+		return "", errors.Errorf("function 'protobuf_decode' - %v", err)
+	}
+	return string(fields[string(fd.Name())]), nil
+}
+
+// marshalProtobufJSON marshals the message to JSON. protojson deliberately varies the whitespace in its output, so we
+// compact it to make it stable.
+func marshalProtobufJSON(opts protojson.MarshalOptions, m proto.Message) (string, error) {
+	out, err := opts.Marshal(m)
+	if err != nil {
+		return "", errors.Errorf("function 'protobuf_decode' - %v", err)
+	}
+	var buff bytes.Buffer
+	if err := json.Compact(&buff, out); err != nil {
+		return "", errors.Errorf("function 'protobuf_decode' - %v", err)
+	}
+	return buff.String(), nil
+}
+
+func protobufValueToColumnValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.Int64Kind,
+		protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return int64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return v.Bytes()
+	case protoreflect.EnumKind:
+		ev := fd.Enum().Values().ByNumber(v.Enum())
+		if ev == nil {
+			// An enum value added after the descriptors were registered
+			return strconv.Itoa(int(v.Enum()))
+		}
+		return string(ev.Name())
+	default:
+		m := v.Message()
+		md := m.Descriptor()
+		if md.FullName() == timestampMessageName {
+			fields := md.Fields()
+			seconds := m.Get(fields.ByName("seconds")).Int()
+			nanos := m.Get(fields.ByName("nanos")).Int()
+			return types.NewTimestamp(seconds*1000 + nanos/1000000)
+		}
+		valueField := wrappedValueField(md)
+		return protobufValueToColumnValue(valueField, m.Get(valueField))
+	}
+}
+
+func (p *ProtobufDecodeFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return v.(int64), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalFloat(rowIndex int, batch *evbatch.Batch) (float64, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return v.(float64), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalBool(rowIndex int, batch *evbatch.Batch) (bool, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return false, null, err
+	}
+	return v.(bool), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalDecimal(int, *evbatch.Batch) (types.Decimal, bool, error) {
+	panic("not supported")
+}
+
+func (p *ProtobufDecodeFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	return v.(string), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	return v.([]byte), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalTimestamp(rowIndex int, batch *evbatch.Batch) (types.Timestamp, bool, error) {
+	v, null, err := p.eval(rowIndex, batch)
+	if err != nil || null {
+		return types.Timestamp{}, null, err
+	}
+	return v.(types.Timestamp), false, nil
+}
+
+func (p *ProtobufDecodeFunction) EvalNested(_ int, _ *evbatch.Batch) (any, bool, error) {
+	panic("not supported")
+}
+
+func (p *ProtobufDecodeFunction) ResultType() types.ColumnType {
+	return p.resultType
+}
+
+// ProtobufEncodeFunction encodes its arguments as a protobuf message of a registered message type. There is an argument
+// for each field of the message, in the order they are declared. Fields with null values are not set. Enums are
+// provided as the name of the value. Other messages, repeated fields and maps are provided as JSON strings.
+type ProtobufEncodeFunction struct {
+	baseExpr
+	md         protoreflect.MessageDescriptor
+	fieldExprs []Expression
+}
+
+func NewProtobufEncodeFunction(argExprs []Expression, desc *parser.FunctionExprDesc,
+	resolver ProtobufMessageResolver) (*ProtobufEncodeFunction, error) {
+	if len(argExprs) < 2 {
+		return nil, desc.ErrorAtPosition("'protobuf_encode' requires at least 2 arguments")
+	}
+	md, err := getProtobufMessageType(argExprs[0], desc.ArgExprs[0], desc, resolver)
+	if err != nil {
+		return nil, err
+	}
+	fieldExprs := argExprs[1:]
+	fields := md.Fields()
+	if len(fieldExprs) != fields.Len() {
+		return nil, desc.ErrorAtPosition("'protobuf_encode' requires %d value arguments for protobuf message type '%s' - %d found",
+			fields.Len(), md.FullName(), len(fieldExprs))
+	}
+	for i, fieldExpr := range fieldExprs {
+		fd := fields.Get(i)
+		required, _ := protobufColumnType(fd)
+		actual := fieldExpr.ResultType()
+		if actual.ID() != required.ID() && !(actual.ID() == types.ColumnTypeIDInt && required.ID() == types.ColumnTypeIDFloat) {
+			return nil, desc.ArgExprs[i+1].ErrorAtPosition("'protobuf_encode' value for '%s' must be of type %s - it is %s",
+				fd.Name(), required.String(), actual.String())
+		}
+	}
+	return &ProtobufEncodeFunction{
+		md:         md,
+		fieldExprs: fieldExprs,
+	}, nil
+}
+
+func (p *ProtobufEncodeFunction) EvalBytes(rowIndex int, batch *evbatch.Batch) ([]byte, bool, error) {
+	msg := dynamicpb.NewMessage(p.md)
+	fields := p.md.Fields()
+	for i, fieldExpr := range p.fieldExprs {
+		fd := fields.Get(i)
+		v, null, err := evalProtobufArg(fieldExpr, rowIndex, batch)
+		if err != nil {
+			return nil, false, err
+		}
+		if null {
+			continue
+		}
+		if _, jsonEncoded := protobufColumnType(fd); jsonEncoded {
+			err = setProtobufFieldFromJSON(msg, fd, v.(string))
+		} else {
+			var pv protoreflect.Value
+			pv, err = columnValueToProtobufValue(fd, v, func() protoreflect.Message {
+				return msg.NewField(fd).Message()
+			})
+			if err == nil {
+				msg.Set(fd, pv)
+			}
+		}
+		if err != nil {
+			return nil, false, errors.Errorf("function 'protobuf_encode' - cannot encode field '%s': %v", fd.Name(), err)
+		}
+	}
+	out, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, false, errors.Errorf("function 'protobuf_encode' - %v", err)
+	}
+	return out, false, nil
+}
+
+func evalProtobufArg(e Expression, rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	switch e.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		return e.EvalInt(rowIndex, batch)
+	case types.ColumnTypeIDFloat:
+		return e.EvalFloat(rowIndex, batch)
+	case types.ColumnTypeIDBool:
+		return e.EvalBool(rowIndex, batch)
+	case types.ColumnTypeIDString:
+		return e.EvalString(rowIndex, batch)
+	case types.ColumnTypeIDBytes:
+		return e.EvalBytes(rowIndex, batch)
+	case types.ColumnTypeIDTimestamp:
+		return e.EvalTimestamp(rowIndex, batch)
+	default:
+		panic("unexpected type")
+	}
+}
+
+func setProtobufFieldFromJSON(msg *dynamicpb.Message, fd protoreflect.FieldDescriptor, value string) error {
+	holder := dynamicpb.NewMessage(msg.Descriptor())
+	holderJSON := `{"` + string(fd.Name()) + `":` + value + `}`
+	if err := protojson.Unmarshal([]byte(holderJSON), holder); err != nil {
+		return errors.Errorf("invalid json: %v", err)
+	}
+	if holder.Has(fd) {
+		msg.Set(fd, holder.Get(fd))
+	}
+	return nil
+}
+
+func columnValueToProtobufValue(fd protoreflect.FieldDescriptor, v any,
+	newMessage func() protoreflect.Message) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(v.(bool)), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i := v.(int64)
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return protoreflect.Value{}, errors.Errorf("value %d is out of range for %s", i, fd.Kind())
+		}
+		return protoreflect.ValueOfInt32(int32(i)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(v.(int64)), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i := v.(int64)
+		if i < 0 || i > math.MaxUint32 {
+			return protoreflect.Value{}, errors.Errorf("value %d is out of range for %s", i, fd.Kind())
+		}
+		return protoreflect.ValueOfUint32(uint32(i)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(v.(int64))), nil
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(toFloat(v))), nil
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(toFloat(v)), nil
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v.(string)), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes(v.([]byte)), nil
+	case protoreflect.EnumKind:
+		ev := fd.Enum().Values().ByName(protoreflect.Name(v.(string)))
+		if ev == nil {
+			return protoreflect.Value{}, errors.Errorf("'%s' is not a value of enum %s", v, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(ev.Number()), nil
+	default:
+		m := newMessage()
+		md := m.Descriptor()
+		if md.FullName() == timestampMessageName {
+			ts := v.(types.Timestamp).Val
+			fields := md.Fields()
+			m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(floorDiv(ts, 1000)))
+			m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(floorMod(ts, 1000)*1000000)))
+			return protoreflect.ValueOfMessage(m), nil
+		}
+		valueField := wrappedValueField(md)
+		pv, err := columnValueToProtobufValue(valueField, v, nil)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		m.Set(valueField, pv)
+		return protoreflect.ValueOfMessage(m), nil
+	}
+}
+
+func toFloat(v any) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// floorDiv and floorMod split a timestamp before the epoch into negative seconds and positive nanos, as
+// google.protobuf.Timestamp requires
+func floorDiv(x int64, y int64) int64 {
+	q := x / y
+	if x%y < 0 {
+		q--
+	}
+	return q
+}
+
+func floorMod(x int64, y int64) int64 {
+	m := x % y
+	if m < 0 {
+		m += y
+	}
+	return m
+}
+
+func (p *ProtobufEncodeFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeBytes
+}
@@ -0,0 +1,343 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+type testProtobufMessages struct {
+	files *protoregistry.Files
+}
+
+func (t *testProtobufMessages) FindMessage(fullName string) (protoreflect.MessageDescriptor, error) {
+	desc, err := t.files.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return nil, errors.Errorf("unknown protobuf message type '%s'", fullName)
+	}
+	return desc.(protoreflect.MessageDescriptor), nil
+}
+
+func protoField(name string, number int32, fieldType descriptorpb.FieldDescriptorProto_Type,
+	typeName string) *descriptorpb.FieldDescriptorProto {
+	fdp := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     fieldType.Enum(),
+	}
+	if typeName != "" {
+		fdp.TypeName = proto.String(typeName)
+	}
+	return fdp
+}
+
+// createTestProtobufMessages creates the descriptors of:
+//
+//	syntax = "proto3";
+//	package test;
+//	enum Status { UNKNOWN = 0; ACTIVE = 1; CLOSED = 2; }
+//	message Address { string city = 1; }
+//	message Order {
+//	  int64 id = 1;
+//	  string customer = 2;
+//	  double amount = 3;
+//	  bool paid = 4;
+//	  Status status = 5;
+//	  google.protobuf.Timestamp created = 6;
+//	  Address address = 7;
+//	  repeated string tags = 8;
+//	  map<string, int32> counts = 9;
+//	  optional string notes = 10;
+//	  google.protobuf.Int32Value priority = 11;
+//	  bytes payload = 12;
+//	  uint32 quantity = 13;
+//	}
+func createTestProtobufMessages(t *testing.T) *testProtobufMessages {
+	tags := protoField("tags", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	counts := protoField("counts", 9, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Order.CountsEntry")
+	counts.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	notes := protoField("notes", 10, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+	notes.Proto3Optional = proto.Bool(true)
+	notes.OneofIndex = proto.Int32(0)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/order.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto", "google/protobuf/wrappers.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+				{Name: proto.String("CLOSED"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Address"),
+				Field: []*descriptorpb.FieldDescriptorProto{protoField("city", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")},
+			},
+			{
+				Name: proto.String("Order"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					protoField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					protoField("customer", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					protoField("amount", 3, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					protoField("paid", 4, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+					protoField("status", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status"),
+					protoField("created", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+					protoField("address", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Address"),
+					tags,
+					counts,
+					notes,
+					protoField("priority", 11, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Int32Value"),
+					protoField("payload", 12, descriptorpb.FieldDescriptorProto_TYPE_BYTES, ""),
+					protoField("quantity", 13, descriptorpb.FieldDescriptorProto_TYPE_UINT32, ""),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("CountsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						protoField("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						protoField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_notes")}},
+			},
+		},
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+		file,
+	}}
+	files, err := protodesc.NewFiles(set)
+	require.NoError(t, err)
+	return &testProtobufMessages{files: files}
+}
+
+func encodeProtobufMessage(t *testing.T, messages *testProtobufMessages, messageType string, json string) string {
+	md, err := messages.FindMessage(messageType)
+	require.NoError(t, err)
+	msg := dynamicpb.NewMessage(md)
+	err = protojson.Unmarshal([]byte(json), msg)
+	require.NoError(t, err)
+	buff, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return string(buff)
+}
+
+func createProtobufTestBatch(t *testing.T, messages *testProtobufMessages) *evbatch.Batch {
+	msg1 := encodeProtobufMessage(t, messages, "test.Order", `{"id": 1, "customer": "alice", "amount": 12.5,
+		"paid": true, "status": "ACTIVE", "created": "1970-01-01T00:00:01.500Z", "address": {"city": "london"},
+		"tags": ["a", "b"], "counts": {"x": 1}, "notes": "fragile", "priority": 7, "payload": "AQI=", "quantity": 3}`)
+	// All fields have their defaults
+	msg2 := encodeProtobufMessage(t, messages, "test.Order", `{}`)
+	valCol := createBytesCol([]bool{false, false, true}, []string{msg1, msg2, ""})
+	schema := evbatch.NewEventSchema([]string{"val"}, []types.ColumnType{types.ColumnTypeBytes})
+	return evbatch.NewBatch(schema, valCol)
+}
+
+func createProtobufExpr(messages ProtobufMessageResolver, exprStr string, schema *evbatch.EventSchema) (Expression, error) {
+	tokens, err := parser.Lex(exprStr, true)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewParser(nil)
+	desc, err := p.ParseExpression(parser.NewParseContext(p, exprStr, tokens))
+	if err != nil {
+		return nil, err
+	}
+	factory := &ExpressionFactory{ProtobufMessages: messages}
+	return factory.CreateExpression(desc, schema)
+}
+
+func testProtobufDecodeField(t *testing.T, field string, expectedType types.ColumnType, expected evbatch.Column) {
+	messages := createTestProtobufMessages(t)
+	batch := createProtobufTestBatch(t, messages)
+	e, err := createProtobufExpr(messages, `protobuf_decode(val, "test.Order", "`+field+`")`, batch.Schema)
+	require.NoError(t, err)
+	require.Equal(t, expectedType, e.ResultType())
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	colsEqual(t, expected, res)
+}
+
+func TestProtobufDecodeScalarFields(t *testing.T) {
+	testProtobufDecodeField(t, "id", types.ColumnTypeInt, createIntCol([]bool{false, false, true}, []int64{1, 0, 0}))
+	testProtobufDecodeField(t, "customer", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{"alice", "", ""}))
+	testProtobufDecodeField(t, "amount", types.ColumnTypeFloat,
+		createFloatCol([]bool{false, false, true}, []float64{12.5, 0, 0}))
+	testProtobufDecodeField(t, "paid", types.ColumnTypeBool,
+		createBoolCol([]bool{false, false, true}, []bool{true, false, false}))
+	testProtobufDecodeField(t, "payload", types.ColumnTypeBytes,
+		createBytesCol([]bool{false, false, true}, []string{"\x01\x02", "", ""}))
+	testProtobufDecodeField(t, "quantity", types.ColumnTypeInt, createIntCol([]bool{false, false, true}, []int64{3, 0, 0}))
+}
+
+func TestProtobufDecodeEnum(t *testing.T) {
+	testProtobufDecodeField(t, "status", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{"ACTIVE", "UNKNOWN", ""}))
+}
+
+func TestProtobufDecodeTimestamp(t *testing.T) {
+	testProtobufDecodeField(t, "created", types.ColumnTypeTimestamp,
+		createTimestampCol([]bool{false, true, true}, []int64{1500, 0, 0}))
+}
+
+func TestProtobufDecodeFieldsWithPresence(t *testing.T) {
+	testProtobufDecodeField(t, "notes", types.ColumnTypeString,
+		createStringCol([]bool{false, true, true}, []string{"fragile", "", ""}))
+	testProtobufDecodeField(t, "priority", types.ColumnTypeInt, createIntCol([]bool{false, true, true}, []int64{7, 0, 0}))
+}
+
+func TestProtobufDecodeNestedField(t *testing.T) {
+	testProtobufDecodeField(t, "address.city", types.ColumnTypeString,
+		createStringCol([]bool{false, true, true}, []string{"london", "", ""}))
+}
+
+func TestProtobufDecodeFieldsAsJSON(t *testing.T) {
+	testProtobufDecodeField(t, "address", types.ColumnTypeString,
+		createStringCol([]bool{false, true, true}, []string{`{"city":"london"}`, "", ""}))
+	testProtobufDecodeField(t, "tags", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{`["a","b"]`, "[]", ""}))
+	testProtobufDecodeField(t, "counts", types.ColumnTypeString,
+		createStringCol([]bool{false, false, true}, []string{`{"x":1}`, "{}", ""}))
+}
+
+func TestProtobufDecodeJSON(t *testing.T) {
+	messages := createTestProtobufMessages(t)
+	batch := createProtobufTestBatch(t, messages)
+	e, err := createProtobufExpr(messages, `protobuf_decode(val, "test.Order")`, batch.Schema)
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeString, e.ResultType())
+	res, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+	expected := createStringCol([]bool{false, false, true}, []string{
+		`{"id":"1","customer":"alice","amount":12.5,"paid":true,"status":"ACTIVE","created":"1970-01-01T00:00:01.500Z","address":{"city":"london"},"tags":["a","b"],"counts":{"x":1},"notes":"fragile","priority":7,"payload":"AQI=","quantity":3}`,
+		`{"id":"0","customer":"","amount":0,"paid":false,"status":"UNKNOWN","created":null,"address":null,"tags":[],"counts":{},"priority":null,"payload":"","quantity":0}`,
+		""})
+	colsEqual(t, expected, res)
+}
+
+func TestProtobufEncode(t *testing.T) {
+	messages := createTestProtobufMessages(t)
+	schema := evbatch.NewEventSchema([]string{"id", "customer", "amount", "paid", "status", "created", "address",
+		"tags", "counts", "notes", "priority", "payload", "quantity"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeInt, types.ColumnTypeBool,
+			types.ColumnTypeString, types.ColumnTypeTimestamp, types.ColumnTypeString, types.ColumnTypeString,
+			types.ColumnTypeString, types.ColumnTypeString, types.ColumnTypeInt, types.ColumnTypeBytes,
+			types.ColumnTypeInt})
+	batch := evbatch.NewBatch(schema,
+		createIntCol([]bool{false, false}, []int64{1, 2}),
+		createStringCol([]bool{false, false}, []string{"alice", "bob"}),
+		createIntCol([]bool{false, false}, []int64{12, 0}),
+		createBoolCol([]bool{false, false}, []bool{true, false}),
+		createStringCol([]bool{false, true}, []string{"CLOSED", ""}),
+		createTimestampCol([]bool{false, false}, []int64{1500, -1500}),
+		createStringCol([]bool{false, true}, []string{`{"city": "london"}`, ""}),
+		createStringCol([]bool{false, false}, []string{`["a", "b"]`, `[]`}),
+		createStringCol([]bool{false, true}, []string{`{"x": 1}`, ""}),
+		createStringCol([]bool{false, true}, []string{"fragile", ""}),
+		createIntCol([]bool{false, true}, []int64{7, 0}),
+		createBytesCol([]bool{false, false}, []string{"\x01\x02", ""}),
+		createIntCol([]bool{false, false}, []int64{3, 0}),
+	)
+	e, err := createProtobufExpr(messages, `protobuf_encode("test.Order", id, customer, amount, paid, status, created,
+		address, tags, counts, notes, priority, payload, quantity)`, schema)
+	require.NoError(t, err)
+	require.Equal(t, types.ColumnTypeBytes, e.ResultType())
+	encoded, err := EvalColumn(e, batch)
+	require.NoError(t, err)
+
+	decodeSchema := evbatch.NewEventSchema([]string{"val"}, []types.ColumnType{types.ColumnTypeBytes})
+	decodeBatch := evbatch.NewBatch(decodeSchema, encoded)
+	e, err = createProtobufExpr(messages, `protobuf_decode(val, "test.Order")`, decodeSchema)
+	require.NoError(t, err)
+	res, err := EvalColumn(e, decodeBatch)
+	require.NoError(t, err)
+	expected := createStringCol([]bool{false, false}, []string{
+		`{"id":"1","customer":"alice","amount":12,"paid":true,"status":"CLOSED","created":"1970-01-01T00:00:01.500Z","address":{"city":"london"},"tags":["a","b"],"counts":{"x":1},"notes":"fragile","priority":7,"payload":"AQI=","quantity":3}`,
+		`{"id":"2","customer":"bob","amount":0,"paid":false,"status":"UNKNOWN","created":"1969-12-31T23:59:58.500Z","address":null,"tags":[],"counts":{},"priority":null,"payload":"","quantity":0}`,
+	})
+	colsEqual(t, expected, res)
+}
+
+func TestProtobufEncodeErrors(t *testing.T) {
+	messages := createTestProtobufMessages(t)
+	schema := evbatch.NewEventSchema([]string{"i", "s", "f", "b"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeFloat, types.ColumnTypeBool})
+	testProtobufExprError(t, messages, `protobuf_encode("test.Address")`, schema,
+		"'protobuf_encode' requires at least 2 arguments")
+	testProtobufExprError(t, messages, `protobuf_encode(s, s)`, schema,
+		"'protobuf_encode' message type argument must be a string literal")
+	testProtobufExprError(t, messages, `protobuf_encode("test.Unknown", s)`, schema,
+		"'protobuf_encode' unknown protobuf message type 'test.Unknown'")
+	testProtobufExprError(t, messages, `protobuf_encode("test.Address", s, s)`, schema,
+		"'protobuf_encode' requires 1 value arguments for protobuf message type 'test.Address' - 2 found")
+	testProtobufExprError(t, messages, `protobuf_encode("test.Address", i)`, schema,
+		"'protobuf_encode' value for 'city' must be of type string - it is int")
+
+	// Values that are not valid for the message type fail when the expression is evaluated
+	e, err := createProtobufExpr(messages, `protobuf_encode("test.Order", i, s, f, b, s, to_timestamp(i), s, s, s, s, i, to_bytes(s), i)`, schema)
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(schema,
+		createIntCol([]bool{false}, []int64{1}),
+		createStringCol([]bool{false}, []string{"foo"}),
+		createFloatCol([]bool{false}, []float64{1.5}),
+		createBoolCol([]bool{false}, []bool{true}))
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Equal(t, "function 'protobuf_encode' - cannot encode field 'status': 'foo' is not a value of enum test.Status", err.Error())
+}
+
+func TestProtobufDecodeErrors(t *testing.T) {
+	messages := createTestProtobufMessages(t)
+	schema := evbatch.NewEventSchema([]string{"val", "s"}, []types.ColumnType{types.ColumnTypeBytes, types.ColumnTypeString})
+	testProtobufExprError(t, messages, `protobuf_decode(val)`, schema, "'protobuf_decode' requires 2 or 3 arguments")
+	testProtobufExprError(t, messages, `protobuf_decode(s, "test.Order")`, schema,
+		"'protobuf_decode' first argument must be of type bytes - it is string")
+	testProtobufExprError(t, messages, `protobuf_decode(val, s)`, schema,
+		"'protobuf_decode' message type argument must be a string literal")
+	testProtobufExprError(t, messages, `protobuf_decode(val, "test.Unknown")`, schema,
+		"'protobuf_decode' unknown protobuf message type 'test.Unknown'")
+	testProtobufExprError(t, messages, `protobuf_decode(val, "test.Order", s)`, schema,
+		"'protobuf_decode' field argument must be a string literal")
+	testProtobufExprError(t, messages, `protobuf_decode(val, "test.Order", "foo")`, schema,
+		"'protobuf_decode' field 'foo' does not exist in protobuf message type 'test.Order'")
+	testProtobufExprError(t, messages, `protobuf_decode(val, "test.Order", "id.foo")`, schema,
+		"'protobuf_decode' field 'id.foo' does not exist in protobuf message type 'test.Order'")
+	testProtobufExprError(t, nil, `protobuf_decode(val, "test.Order")`, schema,
+		"'protobuf_decode' unknown protobuf message type 'test.Order'")
+
+	e, err := createProtobufExpr(messages, `protobuf_decode(val, "test.Order", "customer")`, schema)
+	require.NoError(t, err)
+	batch := evbatch.NewBatch(schema, createBytesCol([]bool{false}, []string{"\xff\xff"}),
+		createStringCol([]bool{false}, []string{""}))
+	_, err = EvalColumn(e, batch)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "function 'protobuf_decode' - invalid test.Order message")
+}
+
+func testProtobufExprError(t *testing.T, messages ProtobufMessageResolver, exprStr string, schema *evbatch.EventSchema,
+	errMsg string) {
+	_, err := createProtobufExpr(messages, exprStr, schema)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errMsg)
+}
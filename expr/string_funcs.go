@@ -0,0 +1,546 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// regexArg is a regular expression argument of a function. When the argument is a string literal, the regular
+// expression is compiled once, when the function is created, otherwise it is compiled each time it is evaluated.
+type regexArg struct {
+	argExpr Expression
+	re      *regexp.Regexp
+}
+
+func newRegexArg(functionName string, argDescription string, argIndex int, argExprs []Expression,
+	desc *parser.FunctionExprDesc) (regexArg, error) {
+	argExpr := argExprs[argIndex]
+	if argExpr.ResultType() != types.ColumnTypeString {
+		return regexArg{}, desc.ErrorAtPosition("'%s' %s must be of type string - it is of type %s", functionName,
+			argDescription, argExpr.ResultType().String())
+	}
+	if _, ok := argExpr.(*StringConstantExpr); !ok {
+		return regexArg{argExpr: argExpr}, nil
+	}
+	reString, _, _ := argExpr.EvalString(0, nil)
+	re, err := regexp.Compile(reString)
+	if err != nil {
+		return regexArg{}, desc.ArgExprs[argIndex].ErrorAtPosition("invalid regex syntax")
+	}
+	return regexArg{argExpr: argExpr, re: re}, nil
+}
+
+func (r *regexArg) eval(rowIndex int, batch *evbatch.Batch) (*regexp.Regexp, bool, error) {
+	if r.re != nil {
+		return r.re, false, nil
+	}
+	reString, null, err := r.argExpr.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	re, err := regexp.Compile(reString)
+	if err != nil {
+		return nil, false, errors.Errorf("invalid regex syntax '%s': %v", reString, err)
+	}
+	return re, false, nil
+}
+
+// RegexpExtractFunction returns the text matched by a capturing group of a regular expression, or the whole match if
+// the group is zero or is not specified. The result is null if the regular expression does not match, or the group
+// did not participate in the match.
+type RegexpExtractFunction struct {
+	baseExpr
+	strOperand   Expression
+	regex        regexArg
+	groupOperand Expression
+}
+
+func NewRegexpExtractFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*RegexpExtractFunction, error) {
+	if len(argExprs) < 2 || len(argExprs) > 3 {
+		return nil, desc.ErrorAtPosition("'regexp_extract' requires 2 or 3 arguments - %d found", len(argExprs))
+	}
+	strOperand := argExprs[0]
+	if strOperand.ResultType() != types.ColumnTypeString {
+		return nil, desc.ErrorAtPosition("'regexp_extract' first argument must be of type string - it is of type %s",
+			strOperand.ResultType().String())
+	}
+	regex, err := newRegexArg("regexp_extract", "second argument", 1, argExprs, desc)
+	if err != nil {
+		return nil, err
+	}
+	var groupOperand Expression
+	if len(argExprs) == 3 {
+		groupOperand = argExprs[2]
+		if groupOperand.ResultType() != types.ColumnTypeInt {
+			return nil, desc.ErrorAtPosition("'regexp_extract' third argument must be of type int - it is of type %s",
+				groupOperand.ResultType().String())
+		}
+		if _, ok := groupOperand.(*IntegerConstantExpr); ok && regex.re != nil {
+			group, _, _ := groupOperand.EvalInt(0, nil)
+			if group < 0 || group > int64(regex.re.NumSubexp()) {
+				return nil, desc.ArgExprs[2].ErrorAtPosition("'regexp_extract' group %d is out of range - the regex has %d groups",
+					group, regex.re.NumSubexp())
+			}
+		}
+	}
+	return &RegexpExtractFunction{
+		strOperand:   strOperand,
+		regex:        regex,
+		groupOperand: groupOperand,
+	}, nil
+}
+
+func (r *RegexpExtractFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	str, null, err := r.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	re, null, err := r.regex.eval(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	var group int64
+	if r.groupOperand != nil {
+		group, null, err = r.groupOperand.EvalInt(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+	}
+	if group < 0 || group > int64(re.NumSubexp()) {
+		return "", false, errors.Errorf("'regexp_extract' group %d is out of range - the regex has %d groups",
+			group, re.NumSubexp())
+	}
+	match := re.FindStringSubmatchIndex(str)
+	if match == nil || match[2*group] < 0 {
+		return "", true, nil
+	}
+	return str[match[2*group]:match[2*group+1]], false, nil
+}
+
+func (r *RegexpExtractFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// RegexpReplaceFunction replaces all matches of a regular expression. The replacement can refer to capturing groups,
+// e.g. '$1' or '${name}'.
+type RegexpReplaceFunction struct {
+	baseExpr
+	strOperand         Expression
+	regex              regexArg
+	replacementOperand Expression
+}
+
+func NewRegexpReplaceFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*RegexpReplaceFunction, error) {
+	if len(argExprs) != 3 {
+		return nil, desc.ErrorAtPosition("'regexp_replace' requires 3 arguments - %d found", len(argExprs))
+	}
+	strOperand := argExprs[0]
+	if strOperand.ResultType() != types.ColumnTypeString {
+		return nil, desc.ErrorAtPosition("'regexp_replace' first argument must be of type string - it is of type %s",
+			strOperand.ResultType().String())
+	}
+	regex, err := newRegexArg("regexp_replace", "second argument", 1, argExprs, desc)
+	if err != nil {
+		return nil, err
+	}
+	replacementOperand := argExprs[2]
+	if replacementOperand.ResultType() != types.ColumnTypeString {
+		return nil, desc.ErrorAtPosition("'regexp_replace' third argument must be of type string - it is of type %s",
+			replacementOperand.ResultType().String())
+	}
+	return &RegexpReplaceFunction{
+		strOperand:         strOperand,
+		regex:              regex,
+		replacementOperand: replacementOperand,
+	}, nil
+}
+
+func (r *RegexpReplaceFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	str, null, err := r.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	re, null, err := r.regex.eval(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	replacement, null, err := r.replacementOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	return re.ReplaceAllString(str, replacement), false, nil
+}
+
+func (r *RegexpReplaceFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// SplitFunction splits a string around a separator and returns the parts as an array<string>. An empty separator
+// splits the string into its characters.
+type SplitFunction struct {
+	baseExpr
+	strOperand Expression
+	sepOperand Expression
+}
+
+var splitResultType = &types.ArrayType{ElemType: types.ColumnTypeString}
+
+func NewSplitFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*SplitFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'split' requires 2 arguments - %d found", len(argExprs))
+	}
+	if err := checkStringArgs("split", argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &SplitFunction{
+		strOperand: argExprs[0],
+		sepOperand: argExprs[1],
+	}, nil
+}
+
+func (s *SplitFunction) EvalNested(rowIndex int, batch *evbatch.Batch) (any, bool, error) {
+	str, null, err := s.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	sep, null, err := s.sepOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, null, err
+	}
+	parts := strings.Split(str, sep)
+	res := make([]any, len(parts))
+	for i, part := range parts {
+		res[i] = part
+	}
+	return res, false, nil
+}
+
+func (s *SplitFunction) ResultType() types.ColumnType {
+	return splitResultType
+}
+
+// SplitPartFunction splits a string around a separator and returns the part at a 1-based index. A negative index
+// counts back from the last part. If there is no such part the result is null.
+type SplitPartFunction struct {
+	baseExpr
+	strOperand   Expression
+	sepOperand   Expression
+	indexOperand Expression
+}
+
+func NewSplitPartFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*SplitPartFunction, error) {
+	if len(argExprs) != 3 {
+		return nil, desc.ErrorAtPosition("'split_part' requires 3 arguments - %d found", len(argExprs))
+	}
+	if err := checkStringArgs("split_part", argExprs[:2], desc); err != nil {
+		return nil, err
+	}
+	if argExprs[2].ResultType() != types.ColumnTypeInt {
+		return nil, desc.ErrorAtPosition("'split_part' third argument must be of type int - it is of type %s",
+			argExprs[2].ResultType().String())
+	}
+	return &SplitPartFunction{
+		strOperand:   argExprs[0],
+		sepOperand:   argExprs[1],
+		indexOperand: argExprs[2],
+	}, nil
+}
+
+func (s *SplitPartFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	str, null, err := s.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	sep, null, err := s.sepOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	index, null, err := s.indexOperand.EvalInt(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	parts := strings.Split(str, sep)
+	if index < 0 {
+		index += int64(len(parts)) + 1
+	}
+	if index < 1 || index > int64(len(parts)) {
+		return "", true, nil
+	}
+	return parts[index-1], false, nil
+}
+
+func (s *SplitPartFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+// maxPadLength is the maximum length in characters that lpad and rpad pad to, so that a large length cannot exhaust
+// memory
+const maxPadLength = 1024 * 1024
+
+// padFunction is the basis of the lpad and rpad functions. The string is padded to the specified length in characters,
+// by repeating the pad string, which defaults to a single space. If the string is already longer than the length it is
+// truncated.
+type padFunction struct {
+	baseExpr
+	functionName  string
+	strOperand    Expression
+	lengthOperand Expression
+	padOperand    Expression
+	left          bool
+}
+
+func newPadFunction(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc, left bool) (padFunction, error) {
+	if len(argExprs) < 2 || len(argExprs) > 3 {
+		return padFunction{}, desc.ErrorAtPosition("'%s' requires 2 or 3 arguments - %d found", functionName,
+			len(argExprs))
+	}
+	if argExprs[0].ResultType() != types.ColumnTypeString {
+		return padFunction{}, desc.ErrorAtPosition("'%s' first argument must be of type string - it is of type %s",
+			functionName, argExprs[0].ResultType().String())
+	}
+	if argExprs[1].ResultType() != types.ColumnTypeInt {
+		return padFunction{}, desc.ErrorAtPosition("'%s' second argument must be of type int - it is of type %s",
+			functionName, argExprs[1].ResultType().String())
+	}
+	if c, ok := argExprs[1].(*IntegerConstantExpr); ok && c.val > maxPadLength {
+		return padFunction{}, desc.ArgExprs[1].ErrorAtPosition("'%s' length %d exceeds the maximum of %d",
+			functionName, c.val, maxPadLength)
+	}
+	var padOperand Expression
+	if len(argExprs) == 3 {
+		padOperand = argExprs[2]
+		if padOperand.ResultType() != types.ColumnTypeString {
+			return padFunction{}, desc.ErrorAtPosition("'%s' third argument must be of type string - it is of type %s",
+				functionName, padOperand.ResultType().String())
+		}
+	}
+	return padFunction{
+		functionName:  functionName,
+		strOperand:    argExprs[0],
+		lengthOperand: argExprs[1],
+		padOperand:    padOperand,
+		left:          left,
+	}, nil
+}
+
+func (p *padFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	str, null, err := p.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	length, null, err := p.lengthOperand.EvalInt(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	pad := " "
+	if p.padOperand != nil {
+		pad, null, err = p.padOperand.EvalString(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+	}
+	if length <= 0 {
+		return "", false, nil
+	}
+	if length > maxPadLength {
+		return "", false, errors.Errorf("function '%s' - length %d exceeds the maximum of %d", p.functionName, length,
+			maxPadLength)
+	}
+	strLen := int64(utf8.RuneCountInString(str))
+	if strLen >= length {
+		return string([]rune(str)[:length]), false, nil
+	}
+	if pad == "" {
+		return str, false, nil
+	}
+	padRunes := []rune(pad)
+	padding := make([]rune, length-strLen)
+	for i := range padding {
+		padding[i] = padRunes[i%len(padRunes)]
+	}
+	if p.left {
+		return string(padding) + str, false, nil
+	}
+	return str + string(padding), false, nil
+}
+
+func (p *padFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+type LPadFunction struct {
+	padFunction
+}
+
+func NewLPadFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*LPadFunction, error) {
+	p, err := newPadFunction("lpad", argExprs, desc, true)
+	if err != nil {
+		return nil, err
+	}
+	return &LPadFunction{padFunction: p}, nil
+}
+
+type RPadFunction struct {
+	padFunction
+}
+
+func NewRPadFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*RPadFunction, error) {
+	p, err := newPadFunction("rpad", argExprs, desc, false)
+	if err != nil {
+		return nil, err
+	}
+	return &RPadFunction{padFunction: p}, nil
+}
+
+// IndexOfFunction returns the index of the first occurrence of a substring, or -1 if it is not present. Like
+// sub_str, the index is the zero-based byte offset in the string.
+type IndexOfFunction struct {
+	baseExpr
+	strOperand    Expression
+	substrOperand Expression
+}
+
+func NewIndexOfFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*IndexOfFunction, error) {
+	if len(argExprs) != 2 {
+		return nil, desc.ErrorAtPosition("'index_of' requires 2 arguments - %d found", len(argExprs))
+	}
+	if err := checkStringArgs("index_of", argExprs, desc); err != nil {
+		return nil, err
+	}
+	return &IndexOfFunction{
+		strOperand:    argExprs[0],
+		substrOperand: argExprs[1],
+	}, nil
+}
+
+func (i *IndexOfFunction) EvalInt(rowIndex int, batch *evbatch.Batch) (int64, bool, error) {
+	str, null, err := i.strOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	substr, null, err := i.substrOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return 0, null, err
+	}
+	return int64(strings.Index(str, substr)), false, nil
+}
+
+func (i *IndexOfFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeInt
+}
+
+var urlParts = map[string]struct{}{
+	"protocol": {}, "userinfo": {}, "host": {}, "port": {}, "path": {}, "query": {}, "fragment": {},
+}
+
+// URLParseFunction returns a component of a URL - one of 'protocol', 'userinfo', 'host', 'port', 'path', 'query' or
+// 'fragment'. When the component is 'query' an optional third argument selects the value of a single query parameter.
+// The result is null if the URL cannot be parsed or does not have the component.
+type URLParseFunction struct {
+	baseExpr
+	urlOperand Expression
+	part       string
+	keyOperand Expression
+}
+
+func NewURLParseFunction(argExprs []Expression, desc *parser.FunctionExprDesc) (*URLParseFunction, error) {
+	if len(argExprs) < 2 || len(argExprs) > 3 {
+		return nil, desc.ErrorAtPosition("'url_parse' requires 2 or 3 arguments - %d found", len(argExprs))
+	}
+	if argExprs[0].ResultType() != types.ColumnTypeString {
+		return nil, desc.ErrorAtPosition("'url_parse' first argument must be of type string - it is of type %s",
+			argExprs[0].ResultType().String())
+	}
+	if _, ok := argExprs[1].(*StringConstantExpr); !ok {
+		return nil, desc.ErrorAtPosition("'url_parse' second argument must be a string literal")
+	}
+	part, _, _ := argExprs[1].EvalString(0, nil)
+	part = strings.ToLower(part)
+	if _, ok := urlParts[part]; !ok {
+		return nil, desc.ArgExprs[1].ErrorAtPosition("'url_parse' second argument must be one of 'protocol', 'userinfo', 'host', 'port', 'path', 'query' or 'fragment'")
+	}
+	var keyOperand Expression
+	if len(argExprs) == 3 {
+		if part != "query" {
+			return nil, desc.ErrorAtPosition("'url_parse' third argument can only be specified when extracting 'query'")
+		}
+		keyOperand = argExprs[2]
+		if keyOperand.ResultType() != types.ColumnTypeString {
+			return nil, desc.ErrorAtPosition("'url_parse' third argument must be of type string - it is of type %s",
+				keyOperand.ResultType().String())
+		}
+	}
+	return &URLParseFunction{
+		urlOperand: argExprs[0],
+		part:       part,
+		keyOperand: keyOperand,
+	}, nil
+}
+
+func (u *URLParseFunction) EvalString(rowIndex int, batch *evbatch.Batch) (string, bool, error) {
+	urlStr, null, err := u.urlOperand.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return "", null, err
+	}
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return "", true, nil
+	}
+	var res string
+	switch u.part {
+	case "protocol":
+		res = parsed.Scheme
+	case "userinfo":
+		if parsed.User != nil {
+			res = parsed.User.String()
+		}
+	case "host":
+		res = parsed.Hostname()
+	case "port":
+		res = parsed.Port()
+	case "path":
+		res = parsed.EscapedPath()
+	case "query":
+		if u.keyOperand == nil {
+			res = parsed.RawQuery
+			break
+		}
+		key, null, err := u.keyOperand.EvalString(rowIndex, batch)
+		if err != nil || null {
+			return "", null, err
+		}
+		values, ok := parsed.Query()[key]
+		if !ok {
+			return "", true, nil
+		}
+		return values[0], false, nil
+	case "fragment":
+		res = parsed.EscapedFragment()
+	}
+	if res == "" {
+		return "", true, nil
+	}
+	return res, false, nil
+}
+
+func (u *URLParseFunction) ResultType() types.ColumnType {
+	return types.ColumnTypeString
+}
+
+func checkStringArgs(functionName string, argExprs []Expression, desc *parser.FunctionExprDesc) error {
+	for i, argExpr := range argExprs {
+		if argExpr.ResultType() != types.ColumnTypeString {
+			return desc.ErrorAtPosition("'%s' %s argument must be of type string - it is of type %s", functionName,
+				ordinals[i], argExpr.ResultType().String())
+		}
+	}
+	return nil
+}
+
+var ordinals = []string{"first", "second", "third"}
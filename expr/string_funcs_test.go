@@ -0,0 +1,206 @@
+package expr
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var stringFuncDesc = &parser.FunctionExprDesc{ArgExprs: []parser.ExprDesc{&parser.IdentifierExprDesc{},
+	&parser.StringConstExprDesc{}, &parser.IntegerConstExprDesc{}}}
+
+func TestRegexpExtractFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, true}, []string{"GET /index.html 200", "POST /api 500", "garbage", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		NewStringConstantExpr(`^(\w+) (\S+) (\d+)$`)}
+	testStringFunction(t, NewRegexpExtractFunction, argCol, args,
+		createStringCol([]bool{false, false, true, true}, []string{"GET /index.html 200", "POST /api 500", "", ""}))
+	testStringFunction(t, NewRegexpExtractFunction, argCol, append(args, NewIntegerConstantExpr(2)),
+		createStringCol([]bool{false, false, true, true}, []string{"/index.html", "/api", "", ""}))
+}
+
+func TestRegexpExtractFunctionOptionalGroup(t *testing.T) {
+	argCol := createStringCol([]bool{false, false}, []string{"a1", "b"})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		NewStringConstantExpr(`^(\w)(\d)?$`), NewIntegerConstantExpr(2)}
+	testStringFunction(t, NewRegexpExtractFunction, argCol, args, createStringCol([]bool{false, true}, []string{"1", ""}))
+}
+
+func TestRegexpExtractFunctionNonConstantPattern(t *testing.T) {
+	strCol := createStringCol([]bool{false, false, false}, []string{"abc123", "abc123", "abc123"})
+	patternCol := createStringCol([]bool{false, false, true}, []string{`\d+`, `[a-z]+`, ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeString}}
+	fun, err := NewRegexpExtractFunction(args, stringFuncDesc)
+	require.NoError(t, err)
+	require.Nil(t, fun.regex.re)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeString})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, strCol, patternCol))
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, true}, []string{"123", "abc", ""}), res)
+
+	patternCol = createStringCol([]bool{false}, []string{`(`})
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createStringCol([]bool{false}, []string{"x"}), patternCol))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid regex syntax '('")
+}
+
+func TestRegexpExtractFunctionArgs(t *testing.T) {
+	strArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}
+	_, err := NewRegexpExtractFunction([]Expression{strArg}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_extract' requires 2 or 3 arguments - 1 found")
+	_, err = NewRegexpExtractFunction([]Expression{NewIntegerConstantExpr(1), NewStringConstantExpr("x")}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_extract' first argument must be of type string - it is of type int")
+	_, err = NewRegexpExtractFunction([]Expression{strArg, NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_extract' second argument must be of type string - it is of type int")
+	_, err = NewRegexpExtractFunction([]Expression{strArg, NewStringConstantExpr("(")}, stringFuncDesc)
+	requireStatementError(t, err, "invalid regex syntax")
+	_, err = NewRegexpExtractFunction([]Expression{strArg, NewStringConstantExpr("(a)"), NewStringConstantExpr("1")}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_extract' third argument must be of type int - it is of type string")
+	_, err = NewRegexpExtractFunction([]Expression{strArg, NewStringConstantExpr("(a)"), NewIntegerConstantExpr(2)}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_extract' group 2 is out of range - the regex has 1 groups")
+}
+
+func TestRegexpReplaceFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, true}, []string{"user=joe id=23", "nothing", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString},
+		NewStringConstantExpr(`(\w+)=(\w+)`), NewStringConstantExpr("$2:$1")}
+	testStringFunction(t, NewRegexpReplaceFunction, argCol, args,
+		createStringCol([]bool{false, false, true}, []string{"joe:user 23:id", "nothing", ""}))
+
+	_, err := NewRegexpReplaceFunction(args[:2], stringFuncDesc)
+	requireStatementError(t, err, "'regexp_replace' requires 3 arguments - 2 found")
+	_, err = NewRegexpReplaceFunction([]Expression{args[0], args[1], NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'regexp_replace' third argument must be of type string - it is of type int")
+}
+
+func TestSplitFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, true}, []string{"a,b,,c", "abc", "", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewStringConstantExpr(",")}
+	fun, err := NewSplitFunction(args, stringFuncDesc)
+	require.NoError(t, err)
+	require.True(t, types.ColumnTypesEqual(&types.ArrayType{ElemType: types.ColumnTypeString}, fun.ResultType()))
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeString})
+	batch := evbatch.NewBatch(schema, argCol)
+	var res []any
+	for i := 0; i < batch.RowCount; i++ {
+		val, null, err := fun.EvalNested(i, batch)
+		require.NoError(t, err)
+		if null {
+			val = nil
+		}
+		res = append(res, val)
+	}
+	require.Equal(t, []any{[]any{"a", "b", "", "c"}, []any{"abc"}, []any{""}, nil}, res)
+
+	_, err = NewSplitFunction([]Expression{args[0], NewIntegerConstantExpr(1)}, stringFuncDesc)
+	requireStatementError(t, err, "'split' second argument must be of type string - it is of type int")
+}
+
+func TestSplitPartFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, false, true}, []string{"a,b,c", "a,b,c", "a,b,c", "a,b,c", ""})
+	indexCol := createIntCol([]bool{false, false, false, true, false}, []int64{2, -1, 4, 0, 1})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewStringConstantExpr(","),
+		&ColumnExpr{colIndex: 1, exprType: types.ColumnTypeInt}}
+	fun, err := NewSplitPartFunction(args, stringFuncDesc)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, argCol, indexCol))
+	require.NoError(t, err)
+	colsEqual(t, createStringCol([]bool{false, false, true, true, true}, []string{"b", "c", "", "", ""}), res)
+
+	_, err = NewSplitPartFunction(args[:2], stringFuncDesc)
+	requireStatementError(t, err, "'split_part' requires 3 arguments - 2 found")
+	_, err = NewSplitPartFunction([]Expression{args[0], args[1], NewStringConstantExpr("1")}, stringFuncDesc)
+	requireStatementError(t, err, "'split_part' third argument must be of type int - it is of type string")
+}
+
+func TestPadFunctions(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, true}, []string{"7", "héllo", "", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewIntegerConstantExpr(4)}
+	testStringFunction(t, NewLPadFunction, argCol, args,
+		createStringCol([]bool{false, false, false, true}, []string{"   7", "héll", "    ", ""}))
+	testStringFunction(t, NewRPadFunction, argCol, append(args, NewStringConstantExpr("ab")),
+		createStringCol([]bool{false, false, false, true}, []string{"7aba", "héll", "abab", ""}))
+	testStringFunction(t, NewLPadFunction, argCol, append(args, NewStringConstantExpr("0")),
+		createStringCol([]bool{false, false, false, true}, []string{"0007", "héll", "0000", ""}))
+
+	_, err := NewLPadFunction(args[:1], stringFuncDesc)
+	requireStatementError(t, err, "'lpad' requires 2 or 3 arguments - 1 found")
+	_, err = NewRPadFunction([]Expression{args[0], NewStringConstantExpr("4")}, stringFuncDesc)
+	requireStatementError(t, err, "'rpad' second argument must be of type int - it is of type string")
+	_, err = NewRPadFunction([]Expression{args[0], args[1], NewIntegerConstantExpr(0)}, stringFuncDesc)
+	requireStatementError(t, err, "'rpad' third argument must be of type string - it is of type int")
+	_, err = NewLPadFunction([]Expression{args[0], NewIntegerConstantExpr(maxPadLength + 1)}, stringFuncDesc)
+	requireStatementError(t, err, "'lpad' length 1048577 exceeds the maximum of 1048576")
+
+	// A length that is not a constant is checked when the function is evaluated
+	lengthCol := createIntCol([]bool{false}, []int64{maxPadLength + 1})
+	fun, err := NewRPadFunction([]Expression{args[0], &ColumnExpr{colIndex: 1, exprType: types.ColumnTypeInt}},
+		stringFuncDesc)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0", "c1"}, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt})
+	_, err = EvalColumn(fun, evbatch.NewBatch(schema, createStringCol([]bool{false}, []string{"7"}), lengthCol))
+	require.Error(t, err)
+	require.Equal(t, "function 'rpad' - length 1048577 exceeds the maximum of 1048576", err.Error())
+}
+
+func TestIndexOfFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, true}, []string{"foobar", "foo", ""})
+	args := []Expression{&ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}, NewStringConstantExpr("bar")}
+	testStringFunction(t, NewIndexOfFunction, argCol, args, createIntCol([]bool{false, false, true}, []int64{3, -1, 0}))
+
+	_, err := NewIndexOfFunction([]Expression{NewIntegerConstantExpr(1), args[1]}, stringFuncDesc)
+	requireStatementError(t, err, "'index_of' first argument must be of type string - it is of type int")
+}
+
+func TestURLParseFunction(t *testing.T) {
+	argCol := createStringCol([]bool{false, false, false, true},
+		[]string{"https://joe:pw@example.com:8443/a/b?x=1&y=two#frag", "http://example.com", "::not a url", ""})
+	urlArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}
+	testCases := []struct {
+		part     string
+		expected []string
+	}{
+		{part: "protocol", expected: []string{"https", "http"}},
+		{part: "userinfo", expected: []string{"joe:pw", ""}},
+		{part: "HOST", expected: []string{"example.com", "example.com"}},
+		{part: "port", expected: []string{"8443", ""}},
+		{part: "path", expected: []string{"/a/b", ""}},
+		{part: "query", expected: []string{"x=1&y=two", ""}},
+		{part: "fragment", expected: []string{"frag", ""}},
+	}
+	for _, tc := range testCases {
+		args := []Expression{urlArg, NewStringConstantExpr(tc.part)}
+		nulls := []bool{tc.expected[0] == "", tc.expected[1] == "", true, true}
+		testStringFunction(t, NewURLParseFunction, argCol, args,
+			createStringCol(nulls, []string{tc.expected[0], tc.expected[1], "", ""}))
+	}
+	args := []Expression{urlArg, NewStringConstantExpr("query"), NewStringConstantExpr("y")}
+	testStringFunction(t, NewURLParseFunction, argCol, args,
+		createStringCol([]bool{false, true, true, true}, []string{"two", "", "", ""}))
+}
+
+func TestURLParseFunctionArgs(t *testing.T) {
+	urlArg := &ColumnExpr{colIndex: 0, exprType: types.ColumnTypeString}
+	_, err := NewURLParseFunction([]Expression{urlArg}, stringFuncDesc)
+	requireStatementError(t, err, "'url_parse' requires 2 or 3 arguments - 1 found")
+	_, err = NewURLParseFunction([]Expression{urlArg, urlArg}, stringFuncDesc)
+	requireStatementError(t, err, "'url_parse' second argument must be a string literal")
+	_, err = NewURLParseFunction([]Expression{urlArg, NewStringConstantExpr("foo")}, stringFuncDesc)
+	requireStatementError(t, err, "'url_parse' second argument must be one of 'protocol', 'userinfo', 'host', 'port', 'path', 'query' or 'fragment'")
+	_, err = NewURLParseFunction([]Expression{urlArg, NewStringConstantExpr("host"), NewStringConstantExpr("x")}, stringFuncDesc)
+	requireStatementError(t, err, "'url_parse' third argument can only be specified when extracting 'query'")
+}
+
+func testStringFunction[E Expression](t *testing.T, factory func([]Expression, *parser.FunctionExprDesc) (E, error),
+	argCol evbatch.Column, args []Expression, expected evbatch.Column) {
+	fun, err := factory(args, stringFuncDesc)
+	require.NoError(t, err)
+	schema := evbatch.NewEventSchema([]string{"c0"}, []types.ColumnType{types.ColumnTypeString})
+	res, err := EvalColumn(fun, evbatch.NewBatch(schema, argCol))
+	require.NoError(t, err)
+	colsEqual(t, expected, res)
+}
@@ -29,6 +29,7 @@ require (
 require (
 	github.com/alexflint/go-filemutex v1.3.0
 	github.com/apache/arrow/go/v11 v11.0.0
+	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/chzyer/readline v1.5.1
 	github.com/dgraph-io/ristretto v0.1.0
 	github.com/docker/docker v25.0.4+incompatible
@@ -55,7 +56,6 @@ require (
 	github.com/apparentlymart/go-textseg v1.0.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
-	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/containerd/containerd v1.7.12 // indirect
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/coreos/go-semver v0.3.0 // indirect
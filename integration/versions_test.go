@@ -512,6 +512,18 @@ func (t *testLevelMgrClient) StoreLastFlushedVersion(version int64) error {
 	return nil
 }
 
+func (t *testLevelMgrClient) RegisterVersionRetentions([]retention.PrefixRetention) error {
+	return nil
+}
+
+func (t *testLevelMgrClient) StoreVersionTime(int64, int64) error {
+	return nil
+}
+
+func (t *testLevelMgrClient) GetVersionForTime(int64) (int64, error) {
+	return -1, nil
+}
+
 func (t *testLevelMgrClient) LoadLastFlushedVersion() (int64, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
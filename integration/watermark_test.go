@@ -695,3 +695,6 @@ type dummyPrefixRetention struct {
 
 func (d *dummyPrefixRetention) AddPrefixRetention(retention.PrefixRetention) {
 }
+
+func (d *dummyPrefixRetention) AddVersionRetention(retention.PrefixRetention) {
+}
@@ -15,9 +15,17 @@ type MergingIterator struct {
 	current                  common.KV
 	currIndex                int
 	minNonCompactableVersion uint64
+	versionRetentions        []VersionRetention
 	noDropOnNext             bool
 }
 
+// VersionRetention prevents a compaction from removing older versions of keys that start with Prefix, unless the version
+// that replaces them is lower than MinVersion. It is used to keep the history of a table for time-travel queries.
+type VersionRetention struct {
+	Prefix     []byte
+	MinVersion uint64
+}
+
 func NewMergingIterator(iters []Iterator, preserveTombstones bool, highestVersion uint64) (*MergingIterator, error) {
 	mi := &MergingIterator{
 		highestVersion:           highestVersion,
@@ -28,10 +36,12 @@ func NewMergingIterator(iters []Iterator, preserveTombstones bool, highestVersio
 	return mi, nil
 }
 
-func NewCompactionMergingIterator(iters []Iterator, preserveTombstones bool, minNonCompactableVersion uint64) (*MergingIterator, error) {
+func NewCompactionMergingIterator(iters []Iterator, preserveTombstones bool, minNonCompactableVersion uint64,
+	versionRetentions []VersionRetention) (*MergingIterator, error) {
 	mi := &MergingIterator{
 		highestVersion:           math.MaxUint64,
 		minNonCompactableVersion: minNonCompactableVersion,
+		versionRetentions:        versionRetentions,
 		iters:                    iters,
 		preserveTombstones:       preserveTombstones,
 	}
@@ -108,7 +118,7 @@ func (m *MergingIterator) IsValid() (bool, error) {
 						// note we can only drop the previous highest if *this* version is compactable as dropping it
 						// will leave this version, and if its non compactable it means that snapshot rollback could
 						// remove it, which would leave nothing.
-						if ver < m.minNonCompactableVersion {
+						if ver < m.minNonCompactableVersionForKey(keyNoVersion) {
 							if log.DebugEnabled {
 								log.Debugf("%p mi: dropping as key version %d less than minnoncompactable (1) %d highestVersionSameKey %d: key %v (%s) value %v (%s)",
 									m, highestVersionSameKey, m.minNonCompactableVersion, highestVersionSameKey, chosenKey, string(chosenKey), chosenValue, string(chosenValue))
@@ -125,7 +135,7 @@ func (m *MergingIterator) IsValid() (bool, error) {
 					} else if ver < highestVersionSameKey {
 						// the previous highest version is higher than this version, so we can remove this version
 						// as long as previous highest is compactable
-						if highestVersionSameKey < m.minNonCompactableVersion {
+						if highestVersionSameKey < m.minNonCompactableVersionForKey(keyNoVersion) {
 							// drop this entry if the version is compactable
 							if err := iter.Next(); err != nil { // Advance iter as not the highest version
 								return false, err
@@ -154,11 +164,19 @@ func (m *MergingIterator) IsValid() (bool, error) {
 			return false, nil
 		}
 
-		if len(chosenValue) == 0 && !m.preserveTombstones {
+		if len(chosenValue) == 0 && !m.preserveTombstones && !m.isTombstoneRetained(smallestKeyNoVersion, highestVersionSameKey) {
 			// Tombstone - advance the iter
 			if err := m.iters[smallestIndex].Next(); err != nil {
 				return false, err
 			}
+			if highestVersionSameKey < m.minNonCompactableVersionForKey(smallestKeyNoVersion) {
+				// The key has been deleted, so we must also skip past any older versions of it, otherwise they would
+				// appear as if they had not been deleted
+				m.noDropOnNext = false
+				if err := m.skipKey(smallestKeyNoVersion); err != nil {
+					return false, err
+				}
+			}
 			// We will repeat the loop
 		} else {
 			m.current.Key = chosenKey
@@ -182,7 +200,7 @@ func (m *MergingIterator) Next() error {
 		return err
 	}
 
-	if lastKeyVersion >= m.minNonCompactableVersion {
+	if lastKeyVersion >= m.minNonCompactableVersionForKey(lastKeyNoVersion) {
 		// Cannot compact it
 		// We set this flag to mark that we cannot drop any other proceeding same keys with lower versions either
 		// If the first one is >= minNonCompactable but proceeding lower keys are < minNonCompactable they can't be
@@ -196,6 +214,11 @@ func (m *MergingIterator) Next() error {
 		isValid() below to see if key is same, and if not, isValid() will be called again in loop by user.
 		We can move the logic of skipping past same key from here into the isValid method
 	*/
+	return m.skipKey(lastKeyNoVersion)
+}
+
+// skipKey advances the iterators past any remaining versions of the key, unless noDropOnNext is set
+func (m *MergingIterator) skipKey(lastKeyNoVersion []byte) error {
 	for _, iter := range m.iters {
 		var c common.KV
 		for {
@@ -212,11 +235,8 @@ func (m *MergingIterator) Next() error {
 			if bytes.Equal(lastKeyNoVersion, c.Key[:len(c.Key)-8]) {
 				if !m.noDropOnNext {
 					if log.DebugEnabled {
-						lastKey := m.current.Key
-						lastValue := m.current.Value
-						lastVersion := math.MaxUint64 - binary.BigEndian.Uint64(lastKey[len(lastKey)-8:])
-						log.Debugf("%p mi: dropping key in next as same key: key %v (%s) value %v (%s) version:%d last key: %v (%s) last value %v (%s) last version %d minnoncompactableversion:%d",
-							m, c.Key, string(c.Key), c.Value, string(c.Value), ver, lastKey, string(lastKey), lastValue, string(lastValue), lastVersion, m.minNonCompactableVersion)
+						log.Debugf("%p mi: dropping key as same key: key %v (%s) value %v (%s) version:%d last key: %v (%s) minnoncompactableversion:%d",
+							m, c.Key, string(c.Key), c.Value, string(c.Value), ver, lastKeyNoVersion, string(lastKeyNoVersion), m.minNonCompactableVersion)
 					}
 					if err := iter.Next(); err != nil {
 						return err
@@ -232,6 +252,29 @@ func (m *MergingIterator) Next() error {
 	return nil
 }
 
+// minNonCompactableVersionForKey returns the lowest version of the key that cannot be compacted, which is lower than
+// minNonCompactableVersion if the key has a version retention
+func (m *MergingIterator) minNonCompactableVersionForKey(keyNoVersion []byte) uint64 {
+	minNonCompactable := m.minNonCompactableVersion
+	for _, vr := range m.versionRetentions {
+		if vr.MinVersion < minNonCompactable && bytes.HasPrefix(keyNoVersion, vr.Prefix) {
+			minNonCompactable = vr.MinVersion
+		}
+	}
+	return minNonCompactable
+}
+
+// isTombstoneRetained returns true if a tombstone must be kept by a compaction, even when tombstones are not being
+// preserved, as it is part of the retained history of the key
+func (m *MergingIterator) isTombstoneRetained(keyNoVersion []byte, version uint64) bool {
+	for _, vr := range m.versionRetentions {
+		if version >= vr.MinVersion && bytes.HasPrefix(keyNoVersion, vr.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *MergingIterator) Close() {
 	for _, iter := range m.iters {
 		iter.Close()
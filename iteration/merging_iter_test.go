@@ -5,6 +5,7 @@ import (
 	"github.com/spirit-labs/tektite/encoding"
 	log "github.com/spirit-labs/tektite/logger"
 	"github.com/stretchr/testify/require"
+	"math"
 	"testing"
 )
 
@@ -336,7 +337,7 @@ func TestCompactionMergingIteratorDontCompactIfNonCompactable(t *testing.T) {
 	iter2 := createIterWithVersions(0, 20, 1, 1, 21, 6, 2, 22, 8, 3, 23, 10)
 	iter3 := createIterWithVersions(0, 30, 2, 1, 31, 5, 2, 32, 9, 3, 33, 12)
 	iters := []Iterator{iter1, iter2, iter3}
-	mi, err := NewCompactionMergingIterator(iters, false, 7)
+	mi, err := NewCompactionMergingIterator(iters, false, 7, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 1, 21, 2, 32, 2, 22, 2, 12, 3, 33, 3, 13, 3, 23)
 }
@@ -346,7 +347,7 @@ func TestCompactionMergingIteratorCompactWhenSameKeySameVersionEveIfNonCompactab
 	iter2 := createIterWithVersions(0, 20, 1, 1, 21, 6, 2, 22, 8, 3, 23, 10)
 	iter3 := createIterWithVersions(0, 30, 2, 1, 31, 5, 2, 32, 8, 3, 33, 12)
 	iters := []Iterator{iter1, iter2, iter3}
-	mi, err := NewCompactionMergingIterator(iters, false, 7)
+	mi, err := NewCompactionMergingIterator(iters, false, 7, nil)
 	require.NoError(t, err)
 	// When key and version is same, the one in the leftmost iterator is chosen
 	expectEntries(t, mi, 0, 10, 1, 21, 2, 22, 2, 12, 3, 33, 3, 13)
@@ -357,7 +358,7 @@ func TestCompactionMergingIteratorAllCompactable(t *testing.T) {
 	iter2 := createIterWithVersions(0, 20, 1, 1, 21, 6, 2, 22, 8, 3, 23, 10)
 	iter3 := createIterWithVersions(0, 30, 2, 1, 31, 5, 2, 32, 9, 3, 33, 12)
 	iters := []Iterator{iter1, iter2, iter3}
-	mi, err := NewCompactionMergingIterator(iters, false, 100)
+	mi, err := NewCompactionMergingIterator(iters, false, 100, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 1, 21, 2, 32, 3, 33)
 }
@@ -365,7 +366,7 @@ func TestCompactionMergingIteratorAllCompactable(t *testing.T) {
 func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey1(t *testing.T) {
 	iter1 := createIterWithVersions(0, 10, 20, 0, 11, 19, 0, 12, 17, 0, 13, 12)
 	iters := []Iterator{iter1}
-	mi, err := NewCompactionMergingIterator(iters, false, 30)
+	mi, err := NewCompactionMergingIterator(iters, false, 30, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10)
 }
@@ -373,7 +374,7 @@ func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey1(t *testing.
 func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey2(t *testing.T) {
 	iter1 := createIterWithVersions(0, 10, 20, 0, 11, 19, 0, 12, 17, 0, 13, 12)
 	iters := []Iterator{iter1}
-	mi, err := NewCompactionMergingIterator(iters, false, 20)
+	mi, err := NewCompactionMergingIterator(iters, false, 20, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 0, 11, 0, 12, 0, 13)
 }
@@ -381,7 +382,7 @@ func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey2(t *testing.
 func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey3(t *testing.T) {
 	iter1 := createIterWithVersions(0, 10, 20, 0, 11, 19, 0, 12, 17, 0, 13, 12)
 	iters := []Iterator{iter1}
-	mi, err := NewCompactionMergingIterator(iters, false, 18)
+	mi, err := NewCompactionMergingIterator(iters, false, 18, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 0, 11, 0, 12, 0, 13)
 }
@@ -390,7 +391,7 @@ func TestCompactionMergingIteratorSkipPastCompactableEntriesSameKey4(t *testing.
 	iter1 := createIterWithVersions(0, 10, 20, 0, 11, 19, 0, 12, 17, 0, 13, 12,
 		1, 10, 20, 1, 11, 19, 1, 12, 17, 1, 13, 12)
 	iters := []Iterator{iter1}
-	mi, err := NewCompactionMergingIterator(iters, false, 18)
+	mi, err := NewCompactionMergingIterator(iters, false, 18, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 0, 11, 0, 12, 0, 13, 1, 10, 1, 11, 1, 12, 1, 13)
 }
@@ -400,7 +401,7 @@ func TestCompactionMergingIteratorWithTombstonesNotPreserved(t *testing.T) {
 	iter2 := createIterWithVersions(0, 20, 1, 1, 21, 6, 2, -1, 8, 3, -1, 10)
 	iter3 := createIterWithVersions(0, 30, 2, 1, 31, 5, 2, 32, 9, 3, 33, 12)
 	iters := []Iterator{iter1, iter2, iter3}
-	mi, err := NewCompactionMergingIterator(iters, false, 7)
+	mi, err := NewCompactionMergingIterator(iters, false, 7, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 1, 21, 2, 32, 2, 12, 3, 33, 3, 13)
 }
@@ -410,11 +411,55 @@ func TestCompactionMergingIteratorWithTombstonesPreserved(t *testing.T) {
 	iter2 := createIterWithVersions(0, 20, 1, 1, 21, 6, 2, -1, 8, 3, -1, 10)
 	iter3 := createIterWithVersions(0, 30, 2, 1, 31, 5, 2, 32, 9, 3, 33, 12)
 	iters := []Iterator{iter1, iter2, iter3}
-	mi, err := NewCompactionMergingIterator(iters, true, 7)
+	mi, err := NewCompactionMergingIterator(iters, true, 7, nil)
 	require.NoError(t, err)
 	expectEntries(t, mi, 0, 10, 1, 21, 2, 32, 2, -1, 2, 12, 3, 33, 3, 13, 3, -1)
 }
 
+func TestMergingIteratorTombstoneHidesOlderVersionsInSameIterator(t *testing.T) {
+	iter1 := createIterWithVersions(0, -1, 5, 0, 10, 3, 1, 11, 1)
+	iter2 := createIterWithVersions(0, 20, 2)
+	iters := []Iterator{iter1, iter2}
+	mi, err := NewMergingIterator(iters, false, math.MaxUint64)
+	require.NoError(t, err)
+	expectEntries(t, mi, 1, 11)
+}
+
+func TestCompactionMergingIteratorVersionRetention(t *testing.T) {
+	iter1 := createIterWithVersions(0, 10, 12, 0, 11, 8, 0, 12, 5, 0, 13, 3, 1, 10, 12, 1, 11, 8, 1, 12, 5)
+	iters := []Iterator{iter1}
+	retentions := []VersionRetention{{Prefix: []byte("key-0000000000"), MinVersion: 6}}
+	mi, err := NewCompactionMergingIterator(iters, false, 100, retentions)
+	require.NoError(t, err)
+	// Older versions are kept as long as a newer version of the key is retained
+	expectEntries(t, mi, 0, 10, 0, 11, 0, 12, 0, 13, 1, 10)
+
+	// Once the latest version is older than the retention min version, the older versions can be removed
+	iter1 = createIterWithVersions(0, 10, 12, 0, 11, 8, 0, 12, 5, 0, 13, 3, 1, 10, 12, 1, 11, 8, 1, 12, 5)
+	iters = []Iterator{iter1}
+	retentions = []VersionRetention{{Prefix: []byte("key-0000000000"), MinVersion: 13}}
+	mi, err = NewCompactionMergingIterator(iters, false, 100, retentions)
+	require.NoError(t, err)
+	expectEntries(t, mi, 0, 10, 1, 10)
+}
+
+func TestCompactionMergingIteratorVersionRetentionTombstones(t *testing.T) {
+	iter1 := createIterWithVersions(0, -1, 10, 0, 11, 7, 0, 12, 2, 1, 10, 3)
+	iters := []Iterator{iter1}
+	retentions := []VersionRetention{{Prefix: []byte("key-0000000000"), MinVersion: 6}}
+	mi, err := NewCompactionMergingIterator(iters, false, 100, retentions)
+	require.NoError(t, err)
+	expectEntries(t, mi, 0, -1, 0, 11, 0, 12, 1, 10)
+
+	// Once the tombstone is older than the retention min version, the key can be removed entirely
+	iter1 = createIterWithVersions(0, -1, 10, 0, 11, 7, 0, 12, 2, 1, 10, 3)
+	iters = []Iterator{iter1}
+	retentions = []VersionRetention{{Prefix: []byte("key-0000000000"), MinVersion: 11}}
+	mi, err = NewCompactionMergingIterator(iters, false, 100, retentions)
+	require.NoError(t, err)
+	expectEntries(t, mi, 1, 10)
+}
+
 func expectEntry(t *testing.T, iter Iterator, expKey int, expVal int) {
 	t.Helper()
 	curr := iter.Current()
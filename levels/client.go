@@ -32,6 +32,12 @@ type Client interface {
 
 	LoadLastFlushedVersion() (int64, error)
 
+	RegisterVersionRetentions(versionRetentions []retention.PrefixRetention) error
+
+	StoreVersionTime(version int64, timeMs int64) error
+
+	GetVersionForTime(timeMs int64) (int64, error)
+
 	GetStats() (Stats, error)
 
 	Start() error
@@ -156,6 +162,30 @@ func (c *externalClient) LoadLastFlushedVersion() (int64, error) {
 	return resp.LastFlushedVersion, nil
 }
 
+func (c *externalClient) RegisterVersionRetentions(versionRetentions []retention.PrefixRetention) error {
+	bytes := make([]byte, 0, 256)
+	bytes = retention.SerializePrefixRetentions(bytes, versionRetentions)
+	req := &clustermsgs.LevelManagerRegisterVersionRetentionsRequest{Payload: bytes}
+	_, err := c.sendRpcWithRetryOnNoLeader(req)
+	return err
+}
+
+func (c *externalClient) StoreVersionTime(version int64, timeMs int64) error {
+	req := &clustermsgs.LevelManagerStoreVersionTimeMessage{Version: version, Time: timeMs}
+	_, err := c.sendRpcWithRetryOnNoLeader(req)
+	return err
+}
+
+func (c *externalClient) GetVersionForTime(timeMs int64) (int64, error) {
+	req := &clustermsgs.LevelManagerGetVersionForTimeMessage{Time: timeMs}
+	r, err := c.sendRpcWithRetryOnNoLeader(req)
+	if err != nil {
+		return 0, err
+	}
+	resp := r.(*clustermsgs.LevelManagerGetVersionForTimeResponse)
+	return resp.Version, nil
+}
+
 func (c *externalClient) GetStats() (Stats, error) {
 	req := &clustermsgs.LevelManagerGetStatsMessage{}
 	r, err := c.sendRpcWithRetryOnNoLeader(req)
@@ -8,6 +8,7 @@ import (
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/encoding"
 	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/iteration"
 	log "github.com/spirit-labs/tektite/logger"
 	"github.com/spirit-labs/tektite/retention"
 	"github.com/spirit-labs/tektite/sst"
@@ -132,6 +133,7 @@ func (lm *LevelManager) scheduleCompaction(level int, tables []*TableEntry, dead
 			preserveTombstones: preserveTombstones,
 			scheduleTime:       common.NanoTime(),
 			lastFlushedVersion: lm.masterRecord.lastFlushedVersion,
+			versionRetentions:  lm.calcVersionRetentions(tablesToCompact),
 		}
 		log.Debugf("created compaction job %s with tables %v from level %d last level is %d, preserve tombstones is %t",
 			id, tableIDs, level, lm.getLastLevel(), preserveTombstones)
@@ -219,6 +221,7 @@ func (lm *LevelManager) scheduleCompaction(level int, tables []*TableEntry, dead
 				preserveTombstones: preserveTombstones,
 				scheduleTime:       common.NanoTime(),
 				lastFlushedVersion: lm.masterRecord.lastFlushedVersion,
+				versionRetentions:  lm.calcVersionRetentions(tablesToCompact),
 			}
 			jobs = append(jobs, job)
 			// lock the tables
@@ -295,6 +298,40 @@ func (lm *LevelManager) calcExpiredOverlappingPrefixes(rangeStart []byte, rangeE
 	return expired
 }
 
+// calcVersionRetentions returns the version retentions of the prefixes that overlap the tables of a job. A version of a
+// key can only be removed if the version that replaced it had completed before the start of the retention period, as
+// until then a time-travel query could still need it.
+func (lm *LevelManager) calcVersionRetentions(tables [][]tableToCompact) []iteration.VersionRetention {
+	if len(lm.masterRecord.versionRetentions) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().UnixMilli()
+	var versionRetentions []iteration.VersionRetention
+	for prefix, ret := range lm.masterRecord.versionRetentions {
+		bPrefix := []byte(prefix)
+		overlaps := false
+		for _, overlapping := range tables {
+			for _, t := range overlapping {
+				if retention.DoesPrefixApplyToTable(bPrefix, t.table.RangeStart, t.table.RangeEnd) {
+					overlaps = true
+					break
+				}
+			}
+		}
+		if !overlaps {
+			continue
+		}
+		// If no version is known to have completed before the start of the retention period then this is -1, and
+		// no versions can be removed
+		minVersion := lm.versionForTime(now-int64(ret)) + 1
+		versionRetentions = append(versionRetentions, iteration.VersionRetention{
+			Prefix:     bPrefix,
+			MinVersion: uint64(minVersion),
+		})
+	}
+	return versionRetentions
+}
+
 func (lm *LevelManager) lockTablesForJob(job CompactionJob) {
 	for _, overlapping := range job.tables {
 		for _, st := range overlapping {
@@ -748,6 +785,7 @@ type CompactionJob struct {
 	preserveTombstones bool
 	scheduleTime       uint64
 	lastFlushedVersion int64
+	versionRetentions  []iteration.VersionRetention
 }
 
 func (c *CompactionJob) Serialize(buff []byte) []byte {
@@ -773,6 +811,12 @@ func (c *CompactionJob) Serialize(buff []byte) []byte {
 	buff = encoding.AppendBoolToBuffer(buff, c.preserveTombstones)
 	buff = encoding.AppendUint64ToBufferLE(buff, c.scheduleTime)
 	buff = encoding.AppendUint64ToBufferLE(buff, uint64(c.lastFlushedVersion))
+	buff = encoding.AppendUint32ToBufferLE(buff, uint32(len(c.versionRetentions)))
+	for _, vr := range c.versionRetentions {
+		buff = encoding.AppendUint32ToBufferLE(buff, uint32(len(vr.Prefix)))
+		buff = append(buff, vr.Prefix...)
+		buff = encoding.AppendUint64ToBufferLE(buff, vr.MinVersion)
+	}
 	return buff
 }
 
@@ -829,6 +873,18 @@ func (c *CompactionJob) Deserialize(buff []byte, offset int) int {
 	var lfv uint64
 	lfv, offset = encoding.ReadUint64FromBufferLE(buff, offset)
 	c.lastFlushedVersion = int64(lfv)
+	var nvr uint32
+	nvr, offset = encoding.ReadUint32FromBufferLE(buff, offset)
+	if nvr > 0 {
+		c.versionRetentions = make([]iteration.VersionRetention, nvr)
+		for i := 0; i < int(nvr); i++ {
+			var lp uint32
+			lp, offset = encoding.ReadUint32FromBufferLE(buff, offset)
+			c.versionRetentions[i].Prefix = common.CopyByteSlice(buff[offset : offset+int(lp)])
+			offset += int(lp)
+			c.versionRetentions[i].MinVersion, offset = encoding.ReadUint64FromBufferLE(buff, offset)
+		}
+	}
 	return offset
 }
 
@@ -1069,7 +1069,7 @@ func TestMergeInterleaved(t *testing.T) {
 
 	res, err := mergeSSTables(common.DataFormatV1,
 		[][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}}, true,
-		1300, math.MaxInt64, "")
+		1300, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 4, len(res))
 	for i := 0; i < 4; i++ {
@@ -1113,7 +1113,7 @@ func TestMergeNoOverlap(t *testing.T) {
 
 	res, err := mergeSSTables(common.DataFormatV1,
 		[][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}}, true,
-		1300, math.MaxInt64, "")
+		1300, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 4, len(res))
 	for i := 0; i < 4; i++ {
@@ -1157,7 +1157,7 @@ func TestOverwriteEntriesWithLaterVersionFirst(t *testing.T) {
 
 	res, err := mergeSSTables(common.DataFormatV1,
 		[][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}}, true,
-		maxTableSize, math.MaxInt64, "")
+		maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 3, len(res))
 	for i := 0; i < 3; i++ {
@@ -1199,7 +1199,7 @@ func TestOverwriteEntriesWithLaterVersionLast(t *testing.T) {
 	require.NoError(t, err)
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}},
-		true, maxTableSize, math.MaxInt64, "")
+		true, maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 3, len(res))
 	for i := 0; i < 3; i++ {
@@ -1238,7 +1238,7 @@ func TestMergePreserveTombstones(t *testing.T) {
 
 	res, err := mergeSSTables(common.DataFormatV1,
 		[][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}}, true, maxTableSize,
-		math.MaxInt64, "")
+		math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 	checkKVs(t, res[0].sst, "val", 0, 0, 1, -1, 2, 2, 3, -1)
@@ -1270,7 +1270,7 @@ func TestMergePreserveTombstonesAllEntriesRemoved(t *testing.T) {
 	require.NoError(t, err)
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}},
-		true, maxTableSize, math.MaxInt64, "")
+		true, maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 
@@ -1304,7 +1304,7 @@ func TestMergePreserveTombstonesNotAllEntriesRemoved(t *testing.T) {
 	require.NoError(t, err)
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}},
-		true, maxTableSize, math.MaxInt64, "")
+		true, maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 
@@ -1337,7 +1337,7 @@ func TestMergeNotPreserveTombstonesAllEntriesRemoved(t *testing.T) {
 	require.NoError(t, err)
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}},
-		false, maxTableSize, math.MaxInt64, "")
+		false, maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 0, len(res))
 }
@@ -1368,7 +1368,7 @@ func TestMergeNotPreserveTombstonesNotAllEntriesRemoved(t *testing.T) {
 	require.NoError(t, err)
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{{sst: sst1}, {sst: sst2}}, {{sst: sst3}, {sst: sst4}}},
-		false, maxTableSize, math.MaxInt64, "")
+		false, maxTableSize, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 
@@ -1410,7 +1410,7 @@ func TestMergeAndDeletePrefixes(t *testing.T) {
 	}
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{tableToMerge1}, {tableToMerge2}},
-		false, 3500, math.MaxInt64, "")
+		false, 3500, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 2, len(res))
 
@@ -1472,7 +1472,7 @@ func TestMergeDeadVersions(t *testing.T) {
 	}
 
 	res, err := mergeSSTables(common.DataFormatV1, [][]tableToMerge{{tableToMerge1}, {tableToMerge2}},
-		false, 3500, math.MaxInt64, "")
+		false, 3500, math.MaxInt64, nil, "")
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 
@@ -1772,6 +1772,10 @@ func TestSerializeDeserializeCompactionJob(t *testing.T) {
 		isMove:             true,
 		preserveTombstones: true,
 		scheduleTime:       123456,
+		versionRetentions: []iteration.VersionRetention{
+			{Prefix: []byte("prefix1"), MinVersion: 23},
+			{Prefix: []byte("prefix2"), MinVersion: 0},
+		},
 	}
 
 	buff := job1.Serialize(nil)
@@ -2566,3 +2570,34 @@ func TestJobCreatedWithLastFlushedVersion(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, int64(100), job.lastFlushedVersion)
 }
+
+func TestJobCreatedWithVersionRetentions(t *testing.T) {
+	lm, tearDown := setupLevelManagerWithConfigSetter(t, true, func(cfg *conf.Config) {
+		cfg.L1CompactionTrigger = 1
+		cfg.CompactionJobTimeout = time.Hour
+	})
+	defer tearDown(t)
+
+	err := lm.RegisterVersionRetentions([]retention.PrefixRetention{
+		{Prefix: []byte("key000"), Retention: uint64(time.Hour.Milliseconds())},
+		{Prefix: []byte("zzz"), Retention: uint64(time.Hour.Milliseconds())},
+	}, false, 0)
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	err = lm.StoreVersionTime(50, now.Add(-2*time.Hour).UnixMilli(), false, 0)
+	require.NoError(t, err)
+	err = lm.StoreVersionTime(60, now.Add(-30*time.Minute).UnixMilli(), false, 0)
+	require.NoError(t, err)
+
+	sst1 := createTableEntryWithDeleteRatio("sst1", 0, 9, 0.5)
+	sst2 := createTableEntryWithDeleteRatio("sst2", 10, 19, 0.5)
+	populateLevel(t, lm, 1, sst1, sst2)
+
+	err = lm.MaybeScheduleCompaction()
+	require.NoError(t, err)
+
+	job, err := getJob(lm)
+	require.NoError(t, err)
+	// Only versions later than the last one completed before the start of the retention period can be removed
+	require.Equal(t, []iteration.VersionRetention{{Prefix: []byte("key000"), MinVersion: 51}}, job.versionRetentions)
+}
@@ -192,7 +192,7 @@ func (c *compactionWorker) processJob(job *CompactionJob) ([]RegistrationEntry,
 	}
 	mergeStart := time.Now()
 	infos, err := mergeSSTables(common.DataFormatV1, tablesToMerge, job.preserveTombstones,
-		c.cws.cfg.CompactionMaxSSTableSize, job.lastFlushedVersion, job.id)
+		c.cws.cfg.CompactionMaxSSTableSize, job.lastFlushedVersion, job.versionRetentions, job.id)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -323,7 +323,7 @@ type tableToMerge struct {
 }
 
 func mergeSSTables(format common.DataFormat, tables [][]tableToMerge, preserveTombstones bool, maxTableSize int,
-	lastFlushedVersion int64, jobID string) ([]ssTableInfo, error) {
+	lastFlushedVersion int64, versionRetentions []iteration2.VersionRetention, jobID string) ([]ssTableInfo, error) {
 
 	totEntries := 0
 	totDataSize := 0
@@ -373,7 +373,8 @@ func mergeSSTables(format common.DataFormat, tables [][]tableToMerge, preserveTo
 		// This ensures we don't lose any keys that we need after rolling back to lastFlushedVersion on failure
 		minNonCompactableVersion = uint64(lastFlushedVersion)
 	}
-	mi, err := iteration2.NewCompactionMergingIterator(chainIters, preserveTombstones, minNonCompactableVersion)
+	mi, err := iteration2.NewCompactionMergingIterator(chainIters, preserveTombstones, minNonCompactableVersion,
+		versionRetentions)
 	if err != nil {
 		return nil, err
 	}
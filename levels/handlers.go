@@ -152,6 +152,55 @@ func (l *storeLastFlushedVersionHandler) HandleMessage(holder remoting.MessageHo
 	return nil, l.ms.callCommandBatchIngestorSync(bytes)
 }
 
+type registerVersionRetentionsHandler struct {
+	ms *LevelManagerService
+}
+
+func (r *registerVersionRetentionsHandler) HandleMessage(holder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	r.ms.lock.RLock()
+	defer r.ms.lock.RUnlock()
+	if r.ms.levelManager == nil {
+		return nil, createNotLeaderError(r.ms)
+	}
+	msg := holder.Message.(*clustermsgs.LevelManagerRegisterVersionRetentionsRequest)
+	buff := make([]byte, 0, 1+len(msg.Payload))
+	buff = append(buff, RegisterVersionRetentionsCommand)
+	buff = append(buff, msg.Payload...)
+	return nil, r.ms.callCommandBatchIngestorSync(buff)
+}
+
+type storeVersionTimeHandler struct {
+	ms *LevelManagerService
+}
+
+func (s *storeVersionTimeHandler) HandleMessage(holder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	s.ms.lock.RLock()
+	defer s.ms.lock.RUnlock()
+	if s.ms.levelManager == nil {
+		return nil, createNotLeaderError(s.ms)
+	}
+	msg := holder.Message.(*clustermsgs.LevelManagerStoreVersionTimeMessage)
+	return nil, s.ms.callCommandBatchIngestorSync(EncodeStoreVersionTimeCommand(msg.Version, msg.Time))
+}
+
+type getVersionForTimeHandler struct {
+	ms *LevelManagerService
+}
+
+func (g *getVersionForTimeHandler) HandleMessage(holder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	g.ms.lock.RLock()
+	defer g.ms.lock.RUnlock()
+	if g.ms.levelManager == nil {
+		return nil, createNotLeaderError(g.ms)
+	}
+	msg := holder.Message.(*clustermsgs.LevelManagerGetVersionForTimeMessage)
+	version, err := g.ms.levelManager.GetVersionForTime(msg.Time)
+	if err != nil {
+		return nil, err
+	}
+	return &clustermsgs.LevelManagerGetVersionForTimeResponse{Version: version}, nil
+}
+
 type getStatsHandler struct {
 	ms *LevelManagerService
 }
@@ -208,6 +257,12 @@ func (l *LevelManagerService) SetClusterMessageHandlers(remotingServer remoting.
 		&loadLastFlushedVersionHandler{ms: l})
 	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageLevelManagerGetStatsMessage,
 		&getStatsHandler{ms: l})
+	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageLevelManagerRegisterVersionRetentionsMessage,
+		&registerVersionRetentionsHandler{ms: l})
+	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageLevelManagerStoreVersionTimeMessage,
+		&storeVersionTimeHandler{ms: l})
+	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageLevelManagerGetVersionForTimeMessage,
+		&getVersionForTimeHandler{ms: l})
 	remotingServer.RegisterConnectionClosedHandler(l.connectionClosed)
 }
 
@@ -229,6 +284,21 @@ func EncodeRegisterPrefixRetentionsCommand(prefixRetentions []retention.PrefixRe
 	return buff
 }
 
+func EncodeRegisterVersionRetentionsCommand(versionRetentions []retention.PrefixRetention) []byte {
+	buff := make([]byte, 0, 256)
+	buff = append(buff, RegisterVersionRetentionsCommand)
+	buff = retention.SerializePrefixRetentions(buff, versionRetentions)
+	return buff
+}
+
+func EncodeStoreVersionTimeCommand(version int64, timeMs int64) []byte {
+	buff := make([]byte, 0, 17)
+	buff = append(buff, StoreVersionTimeCommand)
+	buff = binary.LittleEndian.AppendUint64(buff, uint64(version))
+	buff = binary.LittleEndian.AppendUint64(buff, uint64(timeMs))
+	return buff
+}
+
 func createNotLeaderError(lms *LevelManagerService) error {
 	leaderNode, err := lms.leaderNodeProvider.GetLeaderNode(lms.cfg.ProcessorCount)
 	if err != nil {
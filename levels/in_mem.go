@@ -42,6 +42,18 @@ func (c *InMemClient) RegisterPrefixRetentions(prefixRetentions []retention.Pref
 	return c.LevelManager.RegisterPrefixRetentions(prefixRetentions, false, -1)
 }
 
+func (c *InMemClient) RegisterVersionRetentions(versionRetentions []retention.PrefixRetention) error {
+	return c.LevelManager.RegisterVersionRetentions(versionRetentions, false, -1)
+}
+
+func (c *InMemClient) StoreVersionTime(version int64, timeMs int64) error {
+	return c.LevelManager.StoreVersionTime(version, timeMs, false, -1)
+}
+
+func (c *InMemClient) GetVersionForTime(timeMs int64) (int64, error) {
+	return c.LevelManager.GetVersionForTime(timeMs)
+}
+
 func (c *InMemClient) PollForJob() (*CompactionJob, error) {
 	type pollRes struct {
 		job *CompactionJob
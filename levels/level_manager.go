@@ -13,6 +13,7 @@ import (
 	"github.com/spirit-labs/tektite/retention"
 	"github.com/spirit-labs/tektite/sst"
 	"github.com/spirit-labs/tektite/tabcache"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -147,12 +148,17 @@ func (lm *LevelManager) initialiseMasterRecord() (*masterRecord, error) {
 		if buff != nil {
 			mr = &masterRecord{}
 			mr.deserialize(buff, 0)
+			if mr.format < lm.conf.RegistryFormat {
+				// The record is upgraded to the configured format the next time it is written
+				mr.format = lm.conf.RegistryFormat
+			}
 			log.Debugf("level manager initialised with last flushed version: %d %v", mr.lastFlushedVersion, mr)
 		} else {
 			mr = &masterRecord{
 				format:               lm.conf.RegistryFormat,
 				levelTableCounts:     map[int]int{},
 				prefixRetentions:     map[string]uint64{},
+				versionRetentions:    map[string]uint64{},
 				lastFlushedVersion:   -1,
 				lastProcessedReplSeq: -1,
 				stats:                &Stats{LevelStats: map[int]*LevelStats{}},
@@ -1457,6 +1463,10 @@ func (lm *LevelManager) RegisterPrefixRetentions(prefixRetentions []retention.Pr
 	defer lm.updateReplSeq(replSeq)
 	for _, prefixRetention := range prefixRetentions {
 		lm.masterRecord.prefixRetentions[string(prefixRetention.Prefix)] = prefixRetention.Retention
+		if prefixRetention.Retention == 0 {
+			// The prefix has been deleted, so there is no longer any history to keep
+			delete(lm.masterRecord.versionRetentions, string(prefixRetention.Prefix))
+		}
 	}
 	lm.masterRecord.version++
 	lm.hasChanges = true
@@ -1508,6 +1518,92 @@ func (lm *LevelManager) StoreLastFlushedVersion(version int64, reprocess bool, r
 	return nil
 }
 
+// RegisterVersionRetentions registers how long older versions of keys with each prefix are kept for, so that the
+// history of a table can be queried. The Retention of each PrefixRetention is the time in ms that history is kept for.
+func (lm *LevelManager) RegisterVersionRetentions(versionRetentions []retention.PrefixRetention, reprocess bool, replSeq int) error {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	log.Debugf("in levelmanager RegisterVersionRetentions. replseq %d", replSeq)
+	if err := lm.checkStateForCommand(reprocess); err != nil {
+		return err
+	}
+	if !lm.checkDuplicate(replSeq, reprocess) {
+		return nil
+	}
+	defer lm.updateReplSeq(replSeq)
+	for _, versionRetention := range versionRetentions {
+		lm.masterRecord.versionRetentions[string(versionRetention.Prefix)] = versionRetention.Retention
+	}
+	lm.masterRecord.version++
+	lm.hasChanges = true
+	return nil
+}
+
+// StoreVersionTime records that version had completed at timeMs (in ms since the epoch). Times are only kept for as long
+// as the longest version retention, as there is no history to query before that.
+func (lm *LevelManager) StoreVersionTime(version int64, timeMs int64, reprocess bool, replSeq int) error {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	log.Debugf("in levelmanager StoreVersionTime. version %d time %d reprocess %t replseq %d", version, timeMs,
+		reprocess, replSeq)
+	if err := lm.checkStateForCommand(reprocess); err != nil {
+		return err
+	}
+	if !lm.checkDuplicate(replSeq, reprocess) {
+		return nil
+	}
+	defer lm.updateReplSeq(replSeq)
+	versionTimes := lm.masterRecord.versionTimes
+	if len(versionTimes) > 0 && version <= versionTimes[len(versionTimes)-1].version {
+		// Already recorded
+		return nil
+	}
+	versionTimes = append(versionTimes, versionTime{version: version, time: timeMs})
+	var maxRetention uint64
+	for _, ret := range lm.masterRecord.versionRetentions {
+		if ret > maxRetention {
+			maxRetention = ret
+		}
+	}
+	// We keep the last time before the start of the longest retention period, so a time at the start of the period
+	// can still be mapped to a version
+	cutOff := timeMs - int64(maxRetention)
+	first := 0
+	for i, vt := range versionTimes {
+		if vt.time > cutOff {
+			break
+		}
+		first = i
+	}
+	lm.masterRecord.versionTimes = versionTimes[first:]
+	lm.masterRecord.version++
+	lm.hasChanges = true
+	return nil
+}
+
+// GetVersionForTime returns the highest version that had completed at timeMs (in ms since the epoch), or -1 if no
+// version is known to have completed by then
+func (lm *LevelManager) GetVersionForTime(timeMs int64) (int64, error) {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	if lm.state != stateActive {
+		return 0, errors.NewTektiteErrorf(errors.Unavailable, "levelManager not active")
+	}
+	return lm.versionForTime(timeMs), nil
+}
+
+func (lm *LevelManager) versionForTime(timeMs int64) int64 {
+	versionTimes := lm.masterRecord.versionTimes
+	// find the first entry after the time - the entry before it is the one we want
+	pos := sort.Search(len(versionTimes), func(i int) bool {
+		return versionTimes[i].time > timeMs
+	})
+	if pos == 0 {
+		return -1
+	}
+	return versionTimes[pos-1].version
+}
+
 func (lm *LevelManager) LoadLastFlushedVersion() (int64, error) {
 	lm.lock.Lock()
 	defer lm.lock.Unlock()
@@ -380,7 +380,7 @@ func TestAddRemove_L0(t *testing.T) {
 	defer tearDown(t)
 
 	mr := levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(0), mr.version)
 	require.Equal(t, 0, len(mr.levelSegmentEntries))
 
@@ -390,7 +390,7 @@ func TestAddRemove_L0(t *testing.T) {
 		12, 17, 3, 9, 1, 2, 10, 15, 4, 20, 7, 30)
 
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(1), mr.version)
 	require.Equal(t, 1, len(mr.levelSegmentEntries))
 
@@ -407,7 +407,7 @@ func TestAddRemove_L0(t *testing.T) {
 		11, 13, 3, 9, 0, 35, 7, 12)
 
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(2), mr.version)
 	require.Equal(t, 1, len(mr.levelSegmentEntries))
 
@@ -424,7 +424,7 @@ func TestAddRemove_L0(t *testing.T) {
 		15, 19, 45, 47, 12, 13, 88, 89, 45, 40)
 
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(3), mr.version)
 	require.Equal(t, 1, len(mr.levelSegmentEntries))
 
@@ -440,7 +440,7 @@ func TestAddRemove_L0(t *testing.T) {
 	removeTables(t, levelManager, 0, tableIDs3, 15, 19, 45, 47, 12, 13, 88, 89, 45, 40)
 
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(4), mr.version)
 	require.Equal(t, 1, len(mr.levelSegmentEntries))
 
@@ -454,7 +454,7 @@ func TestAddRemove_L0(t *testing.T) {
 	removeTables(t, levelManager, 0, tableIDs2, 11, 13, 3, 9, 0, 35, 7, 12)
 
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(5), mr.version)
 	require.Equal(t, 1, len(mr.levelSegmentEntries))
 
@@ -469,7 +469,7 @@ func TestAddRemove_L0(t *testing.T) {
 
 	// Should be all gone
 	mr = levelManager.getMasterRecord()
-	require.Equal(t, common.MetadataFormatV1, mr.format)
+	require.Equal(t, common.MetadataFormatV2, mr.format)
 	require.Equal(t, uint64(6), mr.version)
 	require.Equal(t, 0, len(mr.levelSegmentEntries[0].segmentEntries))
 
@@ -1599,6 +1599,78 @@ func TestLastFlushedVersion(t *testing.T) {
 	require.Equal(t, int64(9), lfv)
 }
 
+func TestVersionTimes(t *testing.T) {
+	lm, tearDown := setupLevelManager(t)
+	defer tearDown(t)
+
+	err := lm.RegisterVersionRetentions([]retention.PrefixRetention{
+		{Prefix: []byte("prefix1"), Retention: 1500},
+		{Prefix: []byte("prefix2"), Retention: 500},
+	}, false, 0)
+	require.NoError(t, err)
+
+	version, err := lm.GetVersionForTime(1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), version)
+
+	for i := 1; i <= 3; i++ {
+		err = lm.StoreVersionTime(int64(10*i), int64(1000*i), false, 0)
+		require.NoError(t, err)
+	}
+	// An older version is ignored
+	err = lm.StoreVersionTime(15, 3500, false, 0)
+	require.NoError(t, err)
+
+	expected := map[int64]int64{500: -1, 1000: 10, 1999: 10, 2000: 20, 2500: 20, 3500: 30, 5000: 30}
+	for timeMs, expectedVersion := range expected {
+		version, err = lm.GetVersionForTime(timeMs)
+		require.NoError(t, err)
+		require.Equal(t, expectedVersion, version)
+	}
+
+	// Times before the longest retention are removed, apart from the last one before it
+	err = lm.StoreVersionTime(40, 4000, false, 0)
+	require.NoError(t, err)
+	require.Equal(t, []versionTime{{version: 20, time: 2000}, {version: 30, time: 3000}, {version: 40, time: 4000}},
+		lm.masterRecord.versionTimes)
+
+	// flush, stop and restart Level manager
+	_, _, err = lm.Flush(false)
+	require.NoError(t, err)
+	err = lm.Stop()
+	require.NoError(t, err)
+	lm.reset()
+	err = lm.Start(true)
+	require.NoError(t, err)
+	err = lm.Activate()
+	require.NoError(t, err)
+
+	version, err = lm.GetVersionForTime(1500)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), version)
+	version, err = lm.GetVersionForTime(3999)
+	require.NoError(t, err)
+	require.Equal(t, int64(30), version)
+	require.Equal(t, map[string]uint64{"prefix1": 1500, "prefix2": 500}, lm.masterRecord.versionRetentions)
+
+	// When the prefix is deleted its version retention is removed
+	err = lm.RegisterPrefixRetentions([]retention.PrefixRetention{{Prefix: []byte("prefix1"), Retention: 0}}, false, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{"prefix2": 500}, lm.masterRecord.versionRetentions)
+}
+
+func TestVersionTimesWithoutVersionRetentions(t *testing.T) {
+	lm, tearDown := setupLevelManager(t)
+	defer tearDown(t)
+
+	// Only the latest time is kept, as there is no history to query
+	for i := 1; i <= 3; i++ {
+		err := lm.StoreVersionTime(int64(10*i), int64(1000*i), false, 0)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []versionTime{{version: 30, time: 3000}}, lm.masterRecord.versionTimes)
+}
+
 func TestDedupApplyChanges(t *testing.T) {
 	lm, tearDown := setupLevelManagerWithDedup(t, false, false, true, func(cfg *conf.Config) {
 	})
@@ -29,6 +29,8 @@ const (
 	RegisterPrefixRetentionsCommand
 	RegisterDeadVersionRangeCommand
 	StoreLastFlushedVersionCommand
+	RegisterVersionRetentionsCommand
+	StoreVersionTimeCommand
 )
 
 var CommandColumnTypes = []types.ColumnType{types.ColumnTypeBytes}
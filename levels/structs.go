@@ -362,9 +362,18 @@ type masterRecord struct {
 	deadVersionRanges    []VersionRange
 	lastFlushedVersion   int64
 	lastProcessedReplSeq int
+	versionRetentions    map[string]uint64 // the time in ms that history is kept for, by prefix
+	versionTimes         []versionTime     // in ascending version order
 	stats                *Stats
 }
 
+// versionTime records the time at which a version had been completed. It is used to map a time to a version for
+// time-travel queries.
+type versionTime struct {
+	version int64
+	time    int64
+}
+
 func (mr *masterRecord) copy() *masterRecord {
 	lseCopy := make([]levelEntries, len(mr.levelSegmentEntries))
 	for i, entries := range mr.levelSegmentEntries {
@@ -387,6 +396,12 @@ func (mr *masterRecord) copy() *masterRecord {
 	}
 	deadVersionRangesCopy := make([]VersionRange, len(mr.deadVersionRanges))
 	copy(deadVersionRangesCopy, mr.deadVersionRanges)
+	versionRetentionsCopy := make(map[string]uint64, len(mr.versionRetentions))
+	for prefix, retention := range mr.versionRetentions {
+		versionRetentionsCopy[prefix] = retention
+	}
+	versionTimesCopy := make([]versionTime, len(mr.versionTimes))
+	copy(versionTimesCopy, mr.versionTimes)
 	return &masterRecord{
 		format:               mr.format,
 		version:              mr.version,
@@ -396,6 +411,8 @@ func (mr *masterRecord) copy() *masterRecord {
 		deadVersionRanges:    deadVersionRangesCopy,
 		lastFlushedVersion:   mr.lastFlushedVersion,
 		lastProcessedReplSeq: mr.lastProcessedReplSeq,
+		versionRetentions:    versionRetentionsCopy,
+		versionTimes:         versionTimesCopy,
 		stats:                mr.stats.copy(),
 	}
 }
@@ -428,6 +445,19 @@ func (mr *masterRecord) serialize(buff []byte) []byte {
 	}
 	buff = encoding.AppendUint64ToBufferLE(buff, uint64(mr.lastFlushedVersion))
 	buff = encoding.AppendUint64ToBufferLE(buff, uint64(mr.lastProcessedReplSeq))
+	if mr.format >= common.MetadataFormatV2 {
+		buff = encoding.AppendUint32ToBufferLE(buff, uint32(len(mr.versionRetentions)))
+		for prefix, retention := range mr.versionRetentions {
+			buff = encoding.AppendUint32ToBufferLE(buff, uint32(len(prefix)))
+			buff = append(buff, prefix...)
+			buff = encoding.AppendUint64ToBufferLE(buff, retention)
+		}
+		buff = encoding.AppendUint32ToBufferLE(buff, uint32(len(mr.versionTimes)))
+		for _, vt := range mr.versionTimes {
+			buff = encoding.AppendUint64ToBufferLE(buff, uint64(vt.version))
+			buff = encoding.AppendUint64ToBufferLE(buff, uint64(vt.time))
+		}
+	}
 	return mr.stats.Serialize(buff)
 }
 
@@ -487,6 +517,32 @@ func (mr *masterRecord) deserialize(buff []byte, offset int) int {
 	var lpr uint64
 	lpr, offset = encoding.ReadUint64FromBufferLE(buff, offset)
 	mr.lastProcessedReplSeq = int(lpr)
+	if mr.format >= common.MetadataFormatV2 {
+		var nvr uint32
+		nvr, offset = encoding.ReadUint32FromBufferLE(buff, offset)
+		mr.versionRetentions = make(map[string]uint64, nvr)
+		for i := 0; i < int(nvr); i++ {
+			var lp uint32
+			lp, offset = encoding.ReadUint32FromBufferLE(buff, offset)
+			prefix := buff[offset : offset+int(lp)]
+			offset += int(lp)
+			var retention uint64
+			retention, offset = encoding.ReadUint64FromBufferLE(buff, offset)
+			mr.versionRetentions[string(prefix)] = retention
+		}
+		var nvt uint32
+		nvt, offset = encoding.ReadUint32FromBufferLE(buff, offset)
+		mr.versionTimes = make([]versionTime, nvt)
+		for i := 0; i < int(nvt); i++ {
+			var ver, tm uint64
+			ver, offset = encoding.ReadUint64FromBufferLE(buff, offset)
+			tm, offset = encoding.ReadUint64FromBufferLE(buff, offset)
+			mr.versionTimes[i] = versionTime{version: int64(ver), time: int64(tm)}
+		}
+	} else {
+		// Records written before MetadataFormatV2 have no version retentions
+		mr.versionRetentions = map[string]uint64{}
+	}
 	mr.stats = &Stats{}
 	return mr.stats.Deserialize(buff, offset)
 }
@@ -2,6 +2,8 @@ package levels
 
 import (
 	"github.com/google/uuid"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/encoding"
 	"github.com/spirit-labs/tektite/sst"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -220,6 +222,10 @@ func TestSerializeDeserializeMasterRecord(t *testing.T) {
 		}},
 		lastFlushedVersion:   1234,
 		lastProcessedReplSeq: 5432,
+		versionRetentions: map[string]uint64{
+			"prefix6": 10000,
+		},
+		versionTimes: []versionTime{{version: 100, time: 1000}, {version: 200, time: 2000}},
 		stats: &Stats{
 			TotBytes:       23232,
 			TotEntries:     2132,
@@ -249,3 +255,63 @@ func TestSerializeDeserializeMasterRecord(t *testing.T) {
 
 	require.Equal(t, mr, mrAfter)
 }
+
+func TestDeserializeMasterRecordFormatV1(t *testing.T) {
+	stats := &Stats{LevelStats: map[int]*LevelStats{0: {Bytes: 100, Entries: 10, Tables: 1}}}
+	// A master record in the layout written before MetadataFormatV2
+	var buff []byte
+	buff = append(buff, byte(common.MetadataFormatV1))
+	buff = encoding.AppendUint64ToBufferLE(buff, 23)
+	// one level with one segment entry
+	buff = encoding.AppendUint32ToBufferLE(buff, 1)
+	buff = encoding.AppendUint32ToBufferLE(buff, 1)
+	entry := segmentEntry{format: 1, segmentID: []byte("segmentid1"), rangeStart: []byte("rangestart1"),
+		rangeEnd: []byte("rangeend1")}
+	buff = entry.serialize(buff)
+	buff = encoding.AppendUint64ToBufferLE(buff, 7)
+	// level table counts
+	buff = encoding.AppendUint32ToBufferLE(buff, 1)
+	buff = encoding.AppendUint32ToBufferLE(buff, 0)
+	buff = encoding.AppendUint64ToBufferLE(buff, 1)
+	// prefix retentions
+	buff = encoding.AppendUint32ToBufferLE(buff, 1)
+	buff = encoding.AppendUint32ToBufferLE(buff, uint32(len("prefix1")))
+	buff = append(buff, "prefix1"...)
+	buff = encoding.AppendUint64ToBufferLE(buff, 1000)
+	// dead version ranges
+	buff = encoding.AppendUint32ToBufferLE(buff, 1)
+	deadRange := VersionRange{VersionStart: 3, VersionEnd: 5}
+	buff = deadRange.Serialize(buff)
+	buff = encoding.AppendUint64ToBufferLE(buff, 20)
+	buff = encoding.AppendUint64ToBufferLE(buff, 30)
+	buff = stats.Serialize(buff)
+
+	mr := &masterRecord{}
+	offset := mr.deserialize(buff, 0)
+	require.Equal(t, len(buff), offset)
+	require.Equal(t, &masterRecord{
+		format:  common.MetadataFormatV1,
+		version: 23,
+		levelSegmentEntries: []levelEntries{{
+			segmentEntries: []segmentEntry{entry},
+			maxVersion:     7,
+		}},
+		levelTableCounts:     map[int]int{0: 1},
+		prefixRetentions:     map[string]uint64{"prefix1": 1000},
+		deadVersionRanges:    []VersionRange{{VersionStart: 3, VersionEnd: 5}},
+		lastFlushedVersion:   20,
+		lastProcessedReplSeq: 30,
+		versionRetentions:    map[string]uint64{},
+		stats:                stats,
+	}, mr)
+	// It is written in the same format it was read in
+	require.Equal(t, buff, mr.serialize(nil))
+
+	// Once upgraded, the version retentions are written too
+	mr.format = common.MetadataFormatV2
+	mr.versionRetentions["prefix1"] = 5000
+	mr.versionTimes = []versionTime{{version: 10, time: 1000}}
+	mrAfter := &masterRecord{}
+	mrAfter.deserialize(mr.serialize(nil), 0)
+	require.Equal(t, mr, mrAfter)
+}
@@ -3,9 +3,13 @@ package opers
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/apache/arrow/go/v11/arrow/decimal128"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/expr"
 	"github.com/spirit-labs/tektite/types"
 	"math"
+	"sort"
 	"strings"
 )
 
@@ -33,6 +37,21 @@ var aggFuncsMap = map[string]AggFunc{
 	"avg":   avg,
 }
 
+// aggFuncsList returns the names of the built-in aggregate functions, each formatted with the specified format, as a
+// sorted list for use in error messages, e.g. "'avg', 'count', 'max', 'min' or 'sum'".
+func aggFuncsList(format string) string {
+	names := make([]string, 0, len(aggFuncsMap))
+	for name := range aggFuncsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = "'" + fmt.Sprintf(format, name) + "'"
+	}
+	last := len(names) - 1
+	return strings.Join(names[:last], ", ") + " or " + names[last]
+}
+
 var saf = &SumAggFunc{}
 var caf = &CountAggFunc{}
 var min = &MinAggFunc{}
@@ -86,16 +105,23 @@ func (a AvgAggFunc) ComputeDecimal(_ any, extraData []byte, vals []types.Decimal
 	if err != nil {
 		return nil, nil, err
 	}
-	num, err := decimal128.FromFloat64(fRes, types.DefaultDecimalPrecision, types.DefaultDecimalScale)
+	res, err := avgDecimal(fRes)
 	if err != nil {
 		return nil, nil, err
 	}
-	res := types.Decimal{
+	return res, extra, nil
+}
+
+func avgDecimal(avg float64) (types.Decimal, error) {
+	num, err := decimal128.FromFloat64(avg, types.DefaultDecimalPrecision, types.DefaultDecimalScale)
+	if err != nil {
+		return types.Decimal{}, err
+	}
+	return types.Decimal{
 		Num:       num,
 		Precision: types.DefaultDecimalPrecision,
 		Scale:     types.DefaultDecimalScale,
-	}
-	return res, extra, nil
+	}, nil
 }
 
 func computeAvg(extraData []byte, valsTot float64, valsCount int) (float64, []byte, error) {
@@ -480,3 +506,123 @@ func (d *dummyAggFunc) ComputeTimestamp(_ any, _ []byte, _ []types.Timestamp) (a
 func (d *dummyAggFunc) RequiresExtraData() bool {
 	return false
 }
+
+// ExternalAggFunc is an aggregate function implemented outside Tektite, e.g. in a WASM module. The aggregation state is
+// opaque to Tektite and is persisted as extra data alongside the aggregate result.
+type ExternalAggFunc struct {
+	functionName   string
+	paramType      types.ColumnType
+	returnType     types.ColumnType
+	invokerFactory expr.ExternalAggregateInvokerFactory
+	grLocal        common.GRLocal
+}
+
+func NewExternalAggFunc(functionName string, invokerFactory expr.ExternalAggregateInvokerFactory) (*ExternalAggFunc, bool) {
+	meta, ok := invokerFactory.GetAggregateFunctionMetadata(functionName)
+	if !ok {
+		return nil, false
+	}
+	return &ExternalAggFunc{
+		functionName:   functionName,
+		paramType:      meta.ParamTypes[0],
+		returnType:     meta.ReturnType,
+		invokerFactory: invokerFactory,
+		grLocal:        common.NewGRLocal(),
+	}, true
+}
+
+func (e *ExternalAggFunc) ComputeInt(_ any, extraData []byte, vals []int64) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeFloat(_ any, extraData []byte, vals []float64) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeBool(_ any, extraData []byte, vals []bool) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeDecimal(_ any, extraData []byte, vals []types.Decimal) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeString(_ any, extraData []byte, vals []string) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeBytes(_ any, extraData []byte, vals [][]byte) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ComputeTimestamp(_ any, extraData []byte, vals []types.Timestamp) (any, []byte, error) {
+	return computeExternalAgg(e, extraData, vals)
+}
+
+func (e *ExternalAggFunc) ReturnTypeForExpressionType(types.ColumnType) types.ColumnType {
+	return e.returnType
+}
+
+func (e *ExternalAggFunc) RequiresExtraData() bool {
+	return true
+}
+
+func (e *ExternalAggFunc) ParamType() types.ColumnType {
+	return e.paramType
+}
+
+func (e *ExternalAggFunc) getInvoker() (expr.ExternalAggregateInvoker, error) {
+	// As with external functions, invokers are cached per goroutine as they cannot be used concurrently
+	o, ok := e.grLocal.Get()
+	if ok {
+		return o.(expr.ExternalAggregateInvoker), nil
+	}
+	invoker, err := e.invokerFactory.CreateExternalAggregateInvoker(e.functionName)
+	if err != nil {
+		return nil, err
+	}
+	e.grLocal.Set(invoker)
+	return invoker, nil
+}
+
+func computeExternalAgg[T TektiteTypes](e *ExternalAggFunc, state []byte, vals []T) (any, []byte, error) {
+	invoker, err := e.getInvoker()
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == nil {
+		state, err = invoker.Init()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, val := range vals {
+		state, err = invoker.Accumulate(state, val)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	res, err := invoker.Finish(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, state, nil
+}
+
+// mergeStates merges two aggregation states, computed independently, e.g. on different partitions, and returns the
+// result for the merged state along with the merged state.
+func (e *ExternalAggFunc) mergeStates(state1 []byte, state2 []byte) (any, []byte, error) {
+	invoker, err := e.getInvoker()
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := invoker.Merge(state1, state2)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := invoker.Finish(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, state, nil
+}
@@ -75,49 +75,17 @@ func NewAggregateOperator(inSchema *OperatorSchema, aggDesc *parser.AggregateDes
 	}
 
 	createAggFunc := func(index int, desc parser.ExprDesc, aggExprStr string, allowReservedAlias bool) error {
-
-		ok, aggExprDesc, alias, aliasExprDesc := parser.ExtractAlias(desc)
-		if !ok {
-			return desc.ErrorAtPosition("invalid alias - must be an identifier")
-		}
-		fo, ok := aggExprDesc.(*parser.FunctionExprDesc)
-		if !ok {
-			return aggExprDesc.ErrorAtPosition(
-				"'%s' is not a valid aggregate expression. must be one of 'count(<expr>)', 'sum(<expr>)', 'min(<expr>)', 'max(<expr)' or 'avg(<expr>)'",
-				aggExprStr)
-		}
-		aggFuncName := fo.FunctionName
-		aggFunc, ok := aggFuncsMap[aggFuncName]
-		if !ok {
-			return aggExprDesc.ErrorAtPosition("unknown aggregate function '%s'. must be one of 'count', 'sum', 'min' or 'avg'", aggFuncName)
-		}
-		innerExpr := fo.ArgExprs[0]
-
-		if alias != "" {
-			if !allowReservedAlias {
-				if isReservedIdentifierName(alias) {
-					return aliasExprDesc.ErrorAtPosition("cannot use column alias '%s', it is a reserved name", alias)
-				}
-			}
-			aggStateColumnNames[index] = alias
-		} else {
-			aggStateColumnNames[index] = aggExprStr
-		}
-
-		e, err := expressionFactory.CreateExpression(innerExpr, processSchema.EventSchema)
+		holder, colName, rt, err := createAggFuncHolder(desc, aggExprStr, processSchema.EventSchema, allowReservedAlias,
+			expressionFactory)
 		if err != nil {
 			return err
 		}
-		aggFuncHolders = append(aggFuncHolders, aggFuncHolder{
-			aggFunc:   aggFunc,
-			innerExpr: e,
-			colIndex:  index,
-		})
-		if aggFunc.RequiresExtraData() {
+		holder.colIndex = index
+		aggStateColumnNames[index] = colName
+		aggFuncHolders = append(aggFuncHolders, holder)
+		if holder.aggFunc.RequiresExtraData() {
 			extraStateAggs = append(extraStateAggs, len(aggFuncHolders)-1)
 		}
-
-		rt := aggFunc.ReturnTypeForExpressionType(e.ResultType())
 		aggStateColumnTypes[index] = rt
 		aggColIndexes = append(aggColIndexes, index)
 		aggColTypes = append(aggColTypes, rt)
@@ -239,6 +207,65 @@ func NewAggregateOperator(inSchema *OperatorSchema, aggDesc *parser.AggregateDes
 	}, nil
 }
 
+// createAggFuncHolder creates the aggregate function and the expression it aggregates for an aggregate expression such
+// as 'sum(f1) as tot'. It also returns the name and type of the resulting column.
+func createAggFuncHolder(desc parser.ExprDesc, aggExprStr string, schema *evbatch.EventSchema, allowReservedAlias bool,
+	expressionFactory *expr.ExpressionFactory) (aggFuncHolder, string, types.ColumnType, error) {
+	ok, aggExprDesc, alias, aliasExprDesc := parser.ExtractAlias(desc)
+	if !ok {
+		return aggFuncHolder{}, "", nil, desc.ErrorAtPosition("invalid alias - must be an identifier")
+	}
+	fo, ok := aggExprDesc.(*parser.FunctionExprDesc)
+	if !ok {
+		return aggFuncHolder{}, "", nil, aggExprDesc.ErrorAtPosition(
+			"'%s' is not a valid aggregate expression. must be one of %s", aggExprStr, aggFuncsList("%s(<expr>)"))
+	}
+	aggFuncName := fo.FunctionName
+	aggFunc, ok := aggFuncsMap[aggFuncName]
+	var externalAggFunc *ExternalAggFunc
+	if !ok {
+		externalFactory, isExternal := expressionFactory.ExternalInvokerFactory.(expr.ExternalAggregateInvokerFactory)
+		if isExternal {
+			externalAggFunc, ok = NewExternalAggFunc(aggFuncName, externalFactory)
+		}
+		if !ok {
+			return aggFuncHolder{}, "", nil, aggExprDesc.ErrorAtPosition(
+				"unknown aggregate function '%s'. must be one of %s, or a user defined aggregate function", aggFuncName,
+				aggFuncsList("%s"))
+		}
+		aggFunc = externalAggFunc
+	}
+	innerExpr := fo.ArgExprs[0]
+
+	colName := aggExprStr
+	if alias != "" {
+		if !allowReservedAlias {
+			if isReservedIdentifierName(alias) {
+				return aggFuncHolder{}, "", nil, aliasExprDesc.ErrorAtPosition("cannot use column alias '%s', it is a reserved name", alias)
+			}
+		}
+		colName = alias
+	}
+
+	e, err := expressionFactory.CreateExpression(innerExpr, schema)
+	if err != nil {
+		return aggFuncHolder{}, "", nil, err
+	}
+	if types.IsNestedType(e.ResultType()) {
+		return aggFuncHolder{}, "", nil, aggExprDesc.ErrorAtPosition("aggregate function '%s' cannot be applied to an argument of type %s",
+			aggFuncName, e.ResultType().String())
+	}
+	if externalAggFunc != nil && !types.ColumnTypesEqual(e.ResultType(), externalAggFunc.ParamType()) {
+		return aggFuncHolder{}, "", nil, aggExprDesc.ErrorAtPosition("aggregate function '%s' requires an argument of type %s but receives an argument of type %s",
+			aggFuncName, externalAggFunc.ParamType().String(), e.ResultType().String())
+	}
+	holder := aggFuncHolder{
+		aggFunc:   aggFunc,
+		innerExpr: e,
+	}
+	return holder, colName, aggFunc.ReturnTypeForExpressionType(e.ResultType()), nil
+}
+
 const windowStartColName = "ws"
 const windowEndColName = "we"
 
@@ -660,26 +687,29 @@ func (a *AggregateOperator) groupDataForAggFunc(cols []evbatch.Column, rc int, k
 		if col.IsNull(row) {
 			continue
 		}
-		vals := gArr[aggIndex]
-		switch ftID {
-		case types.ColumnTypeIDInt:
-			vals = groupIntData(col, row, vals)
-		case types.ColumnTypeIDFloat:
-			vals = groupFloatData(col, row, vals)
-		case types.ColumnTypeIDBool:
-			vals = groupBoolData(col, row, vals)
-		case types.ColumnTypeIDDecimal:
-			vals = groupDecimalData(col, row, vals)
-		case types.ColumnTypeIDString:
-			vals = groupStringData(col, row, vals)
-		case types.ColumnTypeIDBytes:
-			vals = groupBytesData(col, row, vals)
-		case types.ColumnTypeIDTimestamp:
-			vals = groupTimestampData(col, row, vals)
-		default:
-			panic("unknown type")
-		}
-		gArr[aggIndex] = vals
+		gArr[aggIndex] = groupColData(ftID, col, row, gArr[aggIndex])
+	}
+}
+
+// groupColData appends the value at row in col to vals, which is a slice of the Go type corresponding to ftID, or nil.
+func groupColData(ftID types.ColumnTypeID, col evbatch.Column, row int, vals any) any {
+	switch ftID {
+	case types.ColumnTypeIDInt:
+		return groupIntData(col, row, vals)
+	case types.ColumnTypeIDFloat:
+		return groupFloatData(col, row, vals)
+	case types.ColumnTypeIDBool:
+		return groupBoolData(col, row, vals)
+	case types.ColumnTypeIDDecimal:
+		return groupDecimalData(col, row, vals)
+	case types.ColumnTypeIDString:
+		return groupStringData(col, row, vals)
+	case types.ColumnTypeIDBytes:
+		return groupBytesData(col, row, vals)
+	case types.ColumnTypeIDTimestamp:
+		return groupTimestampData(col, row, vals)
+	default:
+		panic("unknown type")
 	}
 }
 
@@ -786,54 +816,7 @@ func (a *AggregateOperator) computeAggs(grouped map[string][]any, execCtx Stream
 			if a.hasExtraStateAggs {
 				extra = state.extraData[i]
 			}
-			var res any
-			var extraRes []byte
-			switch aggHolder.innerExpr.ResultType().ID() {
-			case types.ColumnTypeIDInt:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeInt(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeInt(prev, extra, v.([]int64))
-				}
-			case types.ColumnTypeIDFloat:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeFloat(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeFloat(prev, extra, v.([]float64))
-				}
-			case types.ColumnTypeIDBool:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeBool(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeBool(prev, extra, v.([]bool))
-				}
-			case types.ColumnTypeIDDecimal:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeDecimal(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeDecimal(prev, extra, v.([]types.Decimal))
-				}
-			case types.ColumnTypeIDString:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeString(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeString(prev, extra, v.([]string))
-				}
-			case types.ColumnTypeIDBytes:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeBytes(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeBytes(prev, extra, v.([][]byte))
-				}
-			case types.ColumnTypeIDTimestamp:
-				if v == nil {
-					res, extraRes, err = aggHolder.aggFunc.ComputeTimestamp(prev, extra, nil)
-				} else {
-					res, extraRes, err = aggHolder.aggFunc.ComputeTimestamp(prev, extra, v.([]types.Timestamp))
-				}
-			default:
-				panic("unknown type")
-			}
+			res, extraRes, err := computeAgg(aggHolder.aggFunc, aggHolder.innerExpr.ResultType().ID(), prev, extra, v)
 			if err != nil {
 				return nil, err
 			}
@@ -865,6 +848,50 @@ func (a *AggregateOperator) computeAggs(grouped map[string][]any, execCtx Stream
 	return writtenEntries, nil
 }
 
+// computeAgg applies the aggregate function to the grouped values, v, which is a slice of the Go type corresponding to
+// ftID, or nil if there are no non-null values.
+func computeAgg(aggFunc AggFunc, ftID types.ColumnTypeID, prev any, extra []byte, v any) (any, []byte, error) {
+	switch ftID {
+	case types.ColumnTypeIDInt:
+		if v == nil {
+			return aggFunc.ComputeInt(prev, extra, nil)
+		}
+		return aggFunc.ComputeInt(prev, extra, v.([]int64))
+	case types.ColumnTypeIDFloat:
+		if v == nil {
+			return aggFunc.ComputeFloat(prev, extra, nil)
+		}
+		return aggFunc.ComputeFloat(prev, extra, v.([]float64))
+	case types.ColumnTypeIDBool:
+		if v == nil {
+			return aggFunc.ComputeBool(prev, extra, nil)
+		}
+		return aggFunc.ComputeBool(prev, extra, v.([]bool))
+	case types.ColumnTypeIDDecimal:
+		if v == nil {
+			return aggFunc.ComputeDecimal(prev, extra, nil)
+		}
+		return aggFunc.ComputeDecimal(prev, extra, v.([]types.Decimal))
+	case types.ColumnTypeIDString:
+		if v == nil {
+			return aggFunc.ComputeString(prev, extra, nil)
+		}
+		return aggFunc.ComputeString(prev, extra, v.([]string))
+	case types.ColumnTypeIDBytes:
+		if v == nil {
+			return aggFunc.ComputeBytes(prev, extra, nil)
+		}
+		return aggFunc.ComputeBytes(prev, extra, v.([][]byte))
+	case types.ColumnTypeIDTimestamp:
+		if v == nil {
+			return aggFunc.ComputeTimestamp(prev, extra, nil)
+		}
+		return aggFunc.ComputeTimestamp(prev, extra, v.([]types.Timestamp))
+	default:
+		panic("unknown type")
+	}
+}
+
 func encodeAggResult(aggColType types.ColumnType, rowBytes []byte, res any) []byte {
 	rowBytes = append(rowBytes, 1) // Not null
 	switch aggColType.ID() {
@@ -896,7 +923,10 @@ func (a *AggregateOperator) maybeLoadState(key []byte, execCtx StreamExecContext
 	if v == nil {
 		return nil, nil
 	}
-	data, offset := encoding.DecodeRowToSlice(v, 0, a.aggColTypes)
+	data, offset, err := encoding.DecodeRowToSlice(v, 0, a.aggColTypes)
+	if err != nil {
+		return nil, err
+	}
 	var extraData [][]byte
 	if a.hasExtraStateAggs {
 		extraData = make([][]byte, len(a.aggFuncHolders))
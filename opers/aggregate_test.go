@@ -1123,13 +1123,142 @@ func TestAggregateWithColumnAliases(t *testing.T) {
 	testAggregate(t, inColumnNames, inColumnTypes, aggExprs, keyExprs, inData, outColumnNames, outColumnTypes, outData)
 }
 
+func TestAggregateExternalAggFunc(t *testing.T) {
+	inColumnNames := []string{"offset", "event_time", "kc", "string_col"}
+	inColumnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeTimestamp, types.ColumnTypeString, types.ColumnTypeString}
+	inData := [][]any{
+		{int64(1), types.NewTimestamp(1000), "k1", "a"},
+		{int64(2), types.NewTimestamp(1001), "k1", "b"},
+		{int64(3), types.NewTimestamp(1002), "k1", nil},
+		{int64(4), types.NewTimestamp(1003), "k2", "c"},
+	}
+	aggExprs := []string{"test_mod.concat_agg(string_col)"}
+	keyExprs := []string{"kc"}
+	outColumnNames := []string{"event_time", "kc", "test_mod.concat_agg(string_col)"}
+	outColumnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeString, types.ColumnTypeString}
+	outData := [][]any{
+		{types.NewTimestamp(1002), "k1", "a|b"},
+		{types.NewTimestamp(1003), "k2", "c"},
+	}
+	invokerFactory := &testAggInvokerFactory{}
+	stored := testAggregateWithInvokerFactory(t, inColumnNames, inColumnTypes, aggExprs, keyExprs, inData, outColumnNames,
+		outColumnTypes, outData, nil, invokerFactory)
+
+	// Now add more data - the opaque state must be reloaded from storage
+	inData = [][]any{
+		{int64(5), types.NewTimestamp(1004), "k1", "d"},
+		{int64(6), types.NewTimestamp(1005), "k2", "e"},
+	}
+	outData = [][]any{
+		{types.NewTimestamp(1004), "k1", "a|b|d"},
+		{types.NewTimestamp(1005), "k2", "c|e"},
+	}
+	testAggregateWithInvokerFactory(t, inColumnNames, inColumnTypes, aggExprs, keyExprs, inData, outColumnNames,
+		outColumnTypes, outData, stored, invokerFactory)
+}
+
+func TestAggregateExternalAggFuncInvalidArgType(t *testing.T) {
+	aggExprs, err := toExprsWithChecker(&testAggInvokerFactory{}, "test_mod.concat_agg(int_col)")
+	require.NoError(t, err)
+	keyExprs, err := toExprs("kc")
+	require.NoError(t, err)
+	aggDesc := &parser.AggregateDesc{
+		AggregateExprs:       aggExprs,
+		KeyExprs:             keyExprs,
+		AggregateExprStrings: []string{"test_mod.concat_agg(int_col)"},
+		KeyExprsStrings:      []string{"kc"},
+	}
+	inSchema := evbatch.NewEventSchema([]string{"offset", "event_time", "kc", "int_col"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeTimestamp, types.ColumnTypeString, types.ColumnTypeInt})
+	_, err = NewAggregateOperator(&OperatorSchema{EventSchema: inSchema}, aggDesc, 1001,
+		-1, -1, -1, 0, 0, nil, 0, false, false,
+		&expr.ExpressionFactory{ExternalInvokerFactory: &testAggInvokerFactory{}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "aggregate function 'test_mod.concat_agg' requires an argument of type string but receives an argument of type int")
+}
+
+func TestAggregateUnknownAggFunc(t *testing.T) {
+	inSchema := evbatch.NewEventSchema([]string{"int_col"}, []types.ColumnType{types.ColumnTypeInt})
+	desc := &parser.FunctionExprDesc{FunctionName: "foo", ArgExprs: []parser.ExprDesc{&parser.IdentifierExprDesc{IdentifierName: "int_col"}}}
+	_, _, _, err := createAggFuncHolder(desc, "foo(int_col)", inSchema, false,
+		&expr.ExpressionFactory{ExternalInvokerFactory: &testAggInvokerFactory{}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown aggregate function 'foo'. must be one of 'avg', 'count', 'max', 'min' or 'sum', or a user defined aggregate function")
+
+	_, _, _, err = createAggFuncHolder(&parser.IdentifierExprDesc{IdentifierName: "int_col"}, "int_col", inSchema, false,
+		&expr.ExpressionFactory{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'int_col' is not a valid aggregate expression. must be one of 'avg(<expr>)', 'count(<expr>)', 'max(<expr>)', 'min(<expr>)' or 'sum(<expr>)'")
+}
+
+// testAggInvokerFactory provides an external aggregate function which concatenates strings, separated by '|'
+type testAggInvokerFactory struct {
+}
+
+func (t *testAggInvokerFactory) FunctionExists(string) bool {
+	return false
+}
+
+func (t *testAggInvokerFactory) AggregateFunctionExists(functionName string) bool {
+	return functionName == "test_mod.concat_agg"
+}
+
+func (t *testAggInvokerFactory) GetFunctionMetadata(string) (expr.FunctionMetadata, bool) {
+	return expr.FunctionMetadata{}, false
+}
+
+func (t *testAggInvokerFactory) CreateExternalInvoker(string) (expr.ExternalInvoker, error) {
+	panic("not implemented")
+}
+
+func (t *testAggInvokerFactory) GetAggregateFunctionMetadata(functionName string) (expr.FunctionMetadata, bool) {
+	if functionName != "test_mod.concat_agg" {
+		return expr.FunctionMetadata{}, false
+	}
+	return expr.FunctionMetadata{
+		ParamTypes: []types.ColumnType{types.ColumnTypeString},
+		ReturnType: types.ColumnTypeString,
+	}, true
+}
+
+func (t *testAggInvokerFactory) CreateExternalAggregateInvoker(string) (expr.ExternalAggregateInvoker, error) {
+	return &testConcatAggInvoker{}, nil
+}
+
+type testConcatAggInvoker struct {
+}
+
+func (t *testConcatAggInvoker) Init() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (t *testConcatAggInvoker) Accumulate(state []byte, arg any) ([]byte, error) {
+	return t.Merge(state, []byte(arg.(string)))
+}
+
+func (t *testConcatAggInvoker) Merge(state1 []byte, state2 []byte) ([]byte, error) {
+	if len(state1) == 0 {
+		return state2, nil
+	}
+	res := append(common.CopyByteSlice(state1), '|')
+	return append(res, state2...), nil
+}
+
+func (t *testConcatAggInvoker) Finish(state []byte) (any, error) {
+	return string(state), nil
+}
+
 func testAggregate(t *testing.T, inColumnNames []string, inColumnTypes []types.ColumnType, aggExprs []string, keyExprs []string, inData [][]any,
 	outColumnNames []string, outColumnTypes []types.ColumnType, outData [][]any) {
 	testAggregateWithStoredData(t, inColumnNames, inColumnTypes, aggExprs, keyExprs, inData, outColumnNames, outColumnTypes, outData, nil)
 }
 
 func toExprs(exprStrs ...string) ([]parser.ExprDesc, error) {
-	p := parser.NewParser(nil)
+	return toExprsWithChecker(nil, exprStrs...)
+}
+
+func toExprsWithChecker(checker parser.ExternalFunctionChecker, exprStrs ...string) ([]parser.ExprDesc, error) {
+	p := parser.NewParser(checker)
 	var exprs []parser.ExprDesc
 	for _, str := range exprStrs {
 		tokens, err := parser.Lex(str, true)
@@ -1148,13 +1277,26 @@ func toExprs(exprStrs ...string) ([]parser.ExprDesc, error) {
 
 func testAggregateWithStoredData(t *testing.T, inColumnNames []string, inColumnTypes []types.ColumnType, aggExprStrs []string,
 	keyExprStrs []string, inData [][]any, outColumnNames []string, outColumnTypes []types.ColumnType, outData [][]any, stored []common.KV) []common.KV {
+	return testAggregateWithInvokerFactory(t, inColumnNames, inColumnTypes, aggExprStrs, keyExprStrs, inData, outColumnNames,
+		outColumnTypes, outData, stored, nil)
+}
+
+func testAggregateWithInvokerFactory(t *testing.T, inColumnNames []string, inColumnTypes []types.ColumnType, aggExprStrs []string,
+	keyExprStrs []string, inData [][]any, outColumnNames []string, outColumnTypes []types.ColumnType, outData [][]any, stored []common.KV,
+	invokerFactory *testAggInvokerFactory) []common.KV {
 	batch := createEventBatch(inColumnNames, inColumnTypes, inData)
 	inSchema := evbatch.NewEventSchema(inColumnNames, inColumnTypes)
 	tableID := 1001
 
-	aggExprs, err := toExprs(aggExprStrs...)
+	exprFactory := &expr.ExpressionFactory{}
+	var checker parser.ExternalFunctionChecker
+	if invokerFactory != nil {
+		exprFactory.ExternalInvokerFactory = invokerFactory
+		checker = invokerFactory
+	}
+	aggExprs, err := toExprsWithChecker(checker, aggExprStrs...)
 	require.NoError(t, err)
-	keyExprs, err := toExprs(keyExprStrs...)
+	keyExprs, err := toExprsWithChecker(checker, keyExprStrs...)
 	require.NoError(t, err)
 
 	aggDesc := &parser.AggregateDesc{
@@ -1167,7 +1309,7 @@ func testAggregateWithStoredData(t *testing.T, inColumnNames []string, inColumnT
 
 	agg, err := NewAggregateOperator(&OperatorSchema{EventSchema: inSchema}, aggDesc, tableID,
 		-1, -1, -1, 0, 0, nil, 0, false, false,
-		&expr.ExpressionFactory{})
+		exprFactory)
 	require.NoError(t, err)
 
 	require.Equal(t, outColumnNames, agg.aggStateSchema.ColumnNames())
@@ -1197,7 +1339,8 @@ func verifyOutDataFromEntries(t *testing.T, entries []common.KV, outData [][]any
 	require.Equal(t, len(outData), len(entries))
 	var actualOutData [][]any
 	for _, entry := range entries {
-		outValue, _ := encoding.DecodeRowToSlice(entry.Value, 0, agg.aggColTypes)
+		outValue, _, err := encoding.DecodeRowToSlice(entry.Value, 0, agg.aggColTypes)
+		require.NoError(t, err)
 		outKey := make([]any, len(agg.keyColHolders))
 		tabID, _ := encoding.ReadUint64FromBufferBE(entry.Key, 0)
 		partID, _ := encoding.ReadUint64FromBufferBE(entry.Key, 8)
@@ -1207,7 +1350,7 @@ func verifyOutDataFromEntries(t *testing.T, entries []common.KV, outData [][]any
 		require.Equal(t, partitionID, int(partID))
 		require.Equal(t, version, int(ver))
 		key := entry.Key[16:]
-		outKey, _, err := encoding.DecodeKeyToSlice(key, 0, agg.keyColTypes)
+		outKey, _, err = encoding.DecodeKeyToSlice(key, 0, agg.keyColTypes)
 		require.NoError(t, err)
 		actualOut := make([]any, len(agg.aggStateSchema.ColumnTypes()))
 		for i, keyCol := range agg.keyColHolders {
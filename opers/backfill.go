@@ -438,6 +438,10 @@ func (b *BackfillOperator) loadBatchForPartition(partID int) (*evbatch.Batch, er
 				u, byteOff = encoding.ReadUint64FromBufferLE(buff, byteOff)
 				ts := types.NewTimestamp(int64(u))
 				colBuilders[rowCol].(*evbatch.TimestampColBuilder).Append(ts)
+			case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+				var b []byte
+				b, byteOff = encoding.ReadBytesFromBufferLE(buff, byteOff)
+				colBuilders[rowCol].(*evbatch.NestedColBuilder).AppendEncoded(b)
 			default:
 				panic("unknown type")
 			}
@@ -0,0 +1,150 @@
+package opers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/kafka"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"time"
+)
+
+const (
+	DeadLetterErrorHeader    = "tektite_error"
+	DeadLetterOperatorHeader = "tektite_operator"
+)
+
+// DeadLetterSchema is the schema of the rows written to a dead-letter topic, before the offset is added. The val column
+// contains the failed row encoded as a JSON object.
+var DeadLetterSchema = evbatch.NewEventSchema(KafkaSchema.ColumnNames()[1:], KafkaSchema.ColumnTypes()[1:])
+
+// DeadLetterTopicName returns the name of the Kafka endpoint which exposes the dead-letter topic for a stream. Stream
+// names cannot contain '-' so this can never clash with the name of a user stream.
+func DeadLetterTopicName(streamName string) string {
+	return fmt.Sprintf("%s-dead-letter", streamName)
+}
+
+type failedRow struct {
+	rowIndex int
+	err      error
+}
+
+// rowErrorHandler handles rows for which an expression fails to evaluate in a stream with 'on_error' set to 'skip' or
+// 'dead_letter'. When 'on_error' is 'fail' (the default) operators don't have a handler and the error fails the batch.
+type rowErrorHandler struct {
+	onError    string
+	deadLetter *KafkaOutOperator
+}
+
+func (r *rowErrorHandler) handleFailedRows(operatorName string, batch *evbatch.Batch, failed []failedRow,
+	execCtx StreamExecContext) error {
+	switch r.onError {
+	case parser.OnErrorSkip:
+		return nil
+	case parser.OnErrorDeadLetter:
+		deadLetterBatch, err := createDeadLetterBatch(operatorName, batch, failed)
+		if err != nil {
+			return err
+		}
+		_, err = r.deadLetter.HandleStreamBatch(deadLetterBatch, execCtx)
+		return err
+	default:
+		return failed[0].err
+	}
+}
+
+func createDeadLetterBatch(operatorName string, batch *evbatch.Batch, failed []failedRow) (*evbatch.Batch, error) {
+	colBuilders := evbatch.CreateColBuilders(DeadLetterSchema.ColumnTypes())
+	eventTimeColIndex := -1
+	for i, colName := range batch.Schema.ColumnNames() {
+		if colName == EventTimeColName {
+			eventTimeColIndex = i
+			break
+		}
+	}
+	for _, f := range failed {
+		if eventTimeColIndex != -1 && !batch.Columns[eventTimeColIndex].IsNull(f.rowIndex) {
+			colBuilders[0].(*evbatch.TimestampColBuilder).Append(batch.GetTimestampColumn(eventTimeColIndex).Get(f.rowIndex))
+		} else {
+			colBuilders[0].(*evbatch.TimestampColBuilder).Append(types.NewTimestamp(time.Now().UTC().UnixMilli()))
+		}
+		colBuilders[1].AppendNull()
+		hdrs := createMessageHeaders([]kafka.MessageHeader{
+			{Key: DeadLetterErrorHeader, Value: []byte(f.err.Error())},
+			{Key: DeadLetterOperatorHeader, Value: []byte(operatorName)},
+		})
+		colBuilders[2].(*evbatch.BytesColBuilder).Append(hdrs)
+		val, err := encodeRowAsJson(batch, f.rowIndex)
+		if err != nil {
+			return nil, err
+		}
+		colBuilders[3].(*evbatch.BytesColBuilder).Append(val)
+	}
+	return evbatch.NewBatchFromBuilders(DeadLetterSchema, colBuilders...), nil
+}
+
+func encodeRowAsJson(batch *evbatch.Batch, rowIndex int) ([]byte, error) {
+	row := make(map[string]any, len(batch.Columns))
+	for colIndex, colType := range batch.Schema.ColumnTypes() {
+		colName := batch.Schema.ColumnNames()[colIndex]
+		col := batch.Columns[colIndex]
+		if col.IsNull(rowIndex) {
+			row[colName] = nil
+			continue
+		}
+		switch colType.ID() {
+		case types.ColumnTypeIDInt:
+			row[colName] = col.(*evbatch.IntColumn).Get(rowIndex)
+		case types.ColumnTypeIDFloat:
+			row[colName] = col.(*evbatch.FloatColumn).Get(rowIndex)
+		case types.ColumnTypeIDBool:
+			row[colName] = col.(*evbatch.BoolColumn).Get(rowIndex)
+		case types.ColumnTypeIDDecimal:
+			// decimals are converted to strings to preserve precision
+			d := col.(*evbatch.DecimalColumn).Get(rowIndex)
+			row[colName] = d.Num.ToString(int32(d.Scale))
+		case types.ColumnTypeIDString:
+			row[colName] = col.(*evbatch.StringColumn).Get(rowIndex)
+		case types.ColumnTypeIDBytes:
+			// bytes are converted to strings
+			row[colName] = string(col.(*evbatch.BytesColumn).Get(rowIndex))
+		case types.ColumnTypeIDTimestamp:
+			// timestamps are converted to unix millis past epoch
+			row[colName] = col.(*evbatch.TimestampColumn).Get(rowIndex).Val
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			row[colName] = types.ToJSONValue(colType, col.(*evbatch.NestedColumn).Get(rowIndex))
+		default:
+			panic("unknown type")
+		}
+	}
+	return json.Marshal(row)
+}
+
+// evalRow evaluates the expression for a single row, discarding the result. It is used to find which rows of a batch
+// fail evaluation.
+func evalRow(e expr.Expression, rowIndex int, batch *evbatch.Batch) error {
+	var err error
+	switch e.ResultType().ID() {
+	case types.ColumnTypeIDInt:
+		_, _, err = e.EvalInt(rowIndex, batch)
+	case types.ColumnTypeIDFloat:
+		_, _, err = e.EvalFloat(rowIndex, batch)
+	case types.ColumnTypeIDBool:
+		_, _, err = e.EvalBool(rowIndex, batch)
+	case types.ColumnTypeIDDecimal:
+		_, _, err = e.EvalDecimal(rowIndex, batch)
+	case types.ColumnTypeIDString:
+		_, _, err = e.EvalString(rowIndex, batch)
+	case types.ColumnTypeIDBytes:
+		_, _, err = e.EvalBytes(rowIndex, batch)
+	case types.ColumnTypeIDTimestamp:
+		_, _, err = e.EvalTimestamp(rowIndex, batch)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		_, _, err = e.EvalNested(rowIndex, batch)
+	default:
+		panic("unexpected column type")
+	}
+	return err
+}
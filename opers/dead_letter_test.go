@@ -0,0 +1,152 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/kafka"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var onErrorColumnNames = []string{"offset", "event_time", "f1", "f2"}
+var onErrorColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeTimestamp, types.ColumnTypeString,
+	types.ColumnTypeBytes}
+
+func onErrorInData() [][]any {
+	return [][]any{
+		{int64(0), types.NewTimestamp(1000), "1", []byte("b0")},
+		{int64(1), types.NewTimestamp(1001), "foo", []byte("b1")},
+		{int64(2), types.NewTimestamp(1002), "3", []byte("b2")},
+		{int64(3), types.NewTimestamp(1003), "bar", nil},
+	}
+}
+
+func TestFilterFailsBatchWithoutHandler(t *testing.T) {
+	filter := createOnErrorFilter(t, nil)
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	_, err := filter.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot convert foo to int")
+}
+
+func TestFilterSkipsFailedRows(t *testing.T) {
+	filter := createOnErrorFilter(t, &rowErrorHandler{onError: parser.OnErrorSkip})
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	out, err := filter.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	expectedOut := [][]any{
+		{int64(2), types.NewTimestamp(1002), "3", []byte("b2")},
+	}
+	require.Equal(t, expectedOut, convertBatchToAnyArray(out))
+}
+
+func TestProjectFailsBatchWithoutHandler(t *testing.T) {
+	project := createOnErrorProject(t, nil)
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	_, err := project.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot convert foo to int")
+}
+
+func TestProjectSkipsFailedRows(t *testing.T) {
+	project := createOnErrorProject(t, &rowErrorHandler{onError: parser.OnErrorSkip})
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	out, err := project.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	expectedOut := [][]any{
+		{int64(0), types.NewTimestamp(1000), int64(2), []byte("b0")},
+		{int64(2), types.NewTimestamp(1002), int64(4), []byte("b2")},
+	}
+	require.Equal(t, expectedOut, convertBatchToAnyArray(out))
+}
+
+func TestSplitFailsBatchWithoutHandler(t *testing.T) {
+	split, _ := createOnErrorSplit(t, nil)
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	_, err := split.HandleStreamBatch(batch, &testExecCtx{partitionID: 1, processor: &testProcessor{id: 0}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot convert foo to int")
+}
+
+func TestSplitSkipsFailedRows(t *testing.T) {
+	split, sinks := createOnErrorSplit(t, &rowErrorHandler{onError: parser.OnErrorSkip})
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	_, err := split.HandleStreamBatch(batch, &testExecCtx{partitionID: 1, processor: &testProcessor{id: 0}})
+	require.NoError(t, err)
+	// The failed rows aren't sent to any branch, including the else branch
+	expectedOut := [][][]any{
+		{{int64(2), types.NewTimestamp(1002), "3", []byte("b2")}},
+		{{int64(0), types.NewTimestamp(1000), "1", []byte("b0")}},
+	}
+	for i, sink := range sinks {
+		batches := sink.GetPartitionBatches()[1]
+		require.Equal(t, 1, len(batches))
+		require.Equal(t, expectedOut[i], convertBatchToAnyArray(batches[0]))
+	}
+}
+
+func TestCreateDeadLetterBatch(t *testing.T) {
+	batch := createEventBatch(onErrorColumnNames, onErrorColumnTypes, onErrorInData())
+	failed := []failedRow{
+		{rowIndex: 1, err: errors.New("bad row 1")},
+		{rowIndex: 3, err: errors.New("bad row 3")},
+	}
+	deadLetterBatch, err := createDeadLetterBatch("filter", batch, failed)
+	require.NoError(t, err)
+	expectedOut := [][]any{
+		{types.NewTimestamp(1001), nil, createMessageHeaders([]kafka.MessageHeader{
+			{Key: DeadLetterErrorHeader, Value: []byte("bad row 1")},
+			{Key: DeadLetterOperatorHeader, Value: []byte("filter")},
+		}), []byte(`{"event_time":1001,"f1":"foo","f2":"b1","offset":1}`)},
+		{types.NewTimestamp(1003), nil, createMessageHeaders([]kafka.MessageHeader{
+			{Key: DeadLetterErrorHeader, Value: []byte("bad row 3")},
+			{Key: DeadLetterOperatorHeader, Value: []byte("filter")},
+		}), []byte(`{"event_time":1003,"f1":"bar","f2":null,"offset":3}`)},
+	}
+	require.Equal(t, expectedOut, convertBatchToAnyArray(deadLetterBatch))
+}
+
+func createOnErrorFilter(t *testing.T, handler *rowErrorHandler) *FilterOperator {
+	exprs, err := toExprs("to_int(f1) > 2")
+	require.NoError(t, err)
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(onErrorColumnNames, onErrorColumnTypes)}
+	filter, err := NewFilterOperator(schema, exprs[0], &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	filter.rowErrHandler = handler
+	return filter
+}
+
+func createOnErrorSplit(t *testing.T, handler *rowErrorHandler) (*SplitOperator, []*testSinkOper) {
+	exprs, err := toExprs("to_int(f1) > 2")
+	require.NoError(t, err)
+	desc := &parser.SplitDesc{
+		Branches: []*parser.SplitBranchDesc{
+			{StreamName: "brancha", Expr: exprs[0]},
+			{StreamName: "branchb"},
+		},
+	}
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(onErrorColumnNames, onErrorColumnTypes)}
+	split, err := NewSplitOperator(schema, desc, "test_stream", &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	split.rowErrHandler = handler
+	var sinks []*testSinkOper
+	for _, branch := range split.branches {
+		sink := newTestSinkOper(schema)
+		branch.oper.AddDownStreamOperator(sink)
+		sinks = append(sinks, sink)
+	}
+	return split, sinks
+}
+
+func createOnErrorProject(t *testing.T, handler *rowErrorHandler) *ProjectOperator {
+	exprs, err := toExprs("to_int(f1) + 1", "f2")
+	require.NoError(t, err)
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(onErrorColumnNames, onErrorColumnTypes)}
+	project, err := NewProjectOperator(schema, exprs, true, &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	project.rowErrHandler = handler
+	return project
+}
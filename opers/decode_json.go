@@ -0,0 +1,261 @@
+package opers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/tidwall/gjson"
+	"sync"
+	"unicode/utf8"
+)
+
+// DecodeJSONOperator decodes a payload column containing a JSON object into a typed column for each field in the
+// schema. Each payload is validated and then its top level fields are scanned once, and the fields in the schema are
+// appended directly to the column builders. This is much cheaper than extracting each field with a json_* function in
+// a project, which scans the payload for each field. The payload column is replaced by the decoded columns, and the
+// other columns of the input are kept. Fields which are missing or JSON null are null.
+// In strict mode a payload which is not a JSON object, or a field value which cannot be converted to its type, fails
+// the row, otherwise it gives null. Failed rows are handled according to the stream's 'on_error' setting.
+type DecodeJSONOperator struct {
+	BaseOperator
+	inSchema      *OperatorSchema
+	outSchema     *OperatorSchema
+	payloadIndex  int
+	isBytes       bool
+	fieldNames    []string
+	fieldTypes    []types.ColumnType
+	fieldIndexes  map[string]int
+	strict        bool
+	rowErrHandler *rowErrorHandler
+}
+
+func NewDecodeJSONOperator(inSchema *OperatorSchema, desc *parser.DecodeJSONDesc) (*DecodeJSONOperator, error) {
+	payloadIndex := -1
+	for i, colName := range inSchema.EventSchema.ColumnNames() {
+		if colName == desc.Column {
+			payloadIndex = i
+			break
+		}
+	}
+	if payloadIndex == -1 {
+		return nil, statementErrorAtTokenNamef("", desc, "cannot decode column '%s' - the incoming schema has no such column",
+			desc.Column)
+	}
+	payloadType := inSchema.EventSchema.ColumnTypes()[payloadIndex]
+	if payloadType != types.ColumnTypeString && payloadType != types.ColumnTypeBytes {
+		return nil, statementErrorAtTokenNamef("", desc, "cannot decode column '%s' - it must be of type string or bytes - it is of type %s",
+			desc.Column, payloadType.String())
+	}
+	var outNames []string
+	var outTypes []types.ColumnType
+	for i, colName := range inSchema.EventSchema.ColumnNames() {
+		if i != payloadIndex {
+			outNames = append(outNames, colName)
+			outTypes = append(outTypes, inSchema.EventSchema.ColumnTypes()[i])
+		}
+	}
+	fieldIndexes := make(map[string]int, len(desc.ColumnNames))
+	for i, fieldName := range desc.ColumnNames {
+		if isReservedIdentifierName(fieldName) {
+			return nil, statementErrorAtTokenNamef(fieldName, desc, "cannot use column name '%s', it is a reserved name",
+				fieldName)
+		}
+		for _, colName := range outNames {
+			if colName == fieldName {
+				return nil, statementErrorAtTokenNamef(fieldName, desc,
+					"cannot use column name '%s', the incoming schema already has a column with that name", fieldName)
+			}
+		}
+		fieldIndexes[fieldName] = i
+	}
+	outSchema := inSchema.Copy()
+	outSchema.EventSchema = evbatch.NewEventSchema(append(outNames, desc.ColumnNames...),
+		append(outTypes, desc.ColumnTypes...))
+	return &DecodeJSONOperator{
+		inSchema:     inSchema,
+		outSchema:    outSchema,
+		payloadIndex: payloadIndex,
+		isBytes:      payloadType == types.ColumnTypeBytes,
+		fieldNames:   desc.ColumnNames,
+		fieldTypes:   desc.ColumnTypes,
+		fieldIndexes: fieldIndexes,
+		strict:       desc.Strict,
+	}, nil
+}
+
+func (d *DecodeJSONOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
+	outBatch, err := d.processBatch(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return outBatch, d.SendQueryBatchDownStream(outBatch, execCtx)
+}
+
+func (d *DecodeJSONOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	outBatch, err := d.processBatch(batch, execCtx)
+	if err != nil {
+		return nil, err
+	}
+	if outBatch.RowCount > 0 {
+		return outBatch, d.sendBatchDownStream(outBatch, execCtx)
+	}
+	return outBatch, nil
+}
+
+func (d *DecodeJSONOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	defer batch.Release()
+	outColTypes := d.outSchema.EventSchema.ColumnTypes()
+	colBuilders := evbatch.CreateColBuilders(outColTypes)
+	numRowCols := len(outColTypes) - len(d.fieldTypes)
+	inColTypes := d.inSchema.EventSchema.ColumnTypes()
+	// The decoded values of a row are held until all fields have been decoded, so a row which fails in strict mode
+	// is not partially appended
+	vals := make([]any, len(d.fieldTypes))
+	fieldResults := make([]gjson.Result, len(d.fieldTypes))
+	var failed []failedRow
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		if err := d.decodeRow(rowIndex, batch, fieldResults, vals); err != nil {
+			if d.rowErrHandler == nil {
+				return nil, err
+			}
+			failed = append(failed, failedRow{rowIndex: rowIndex, err: err})
+			continue
+		}
+		outIndex := 0
+		for colIndex, colType := range inColTypes {
+			if colIndex != d.payloadIndex {
+				evbatch.CopyColumnEntryWithCol(colType, batch.Columns[colIndex], colBuilders[outIndex], rowIndex)
+				outIndex++
+			}
+		}
+		for i, val := range vals {
+			appendColumnValue(colBuilders[numRowCols+i], d.fieldTypes[i], val)
+		}
+	}
+	if len(failed) > 0 {
+		if err := d.rowErrHandler.handleFailedRows("decode_json", batch, failed, execCtx); err != nil {
+			return nil, err
+		}
+	}
+	return evbatch.NewBatchFromBuilders(d.outSchema.EventSchema, colBuilders...), nil
+}
+
+// decodeRow decodes the payload of the row into vals, with nil for fields which are null.
+func (d *DecodeJSONOperator) decodeRow(rowIndex int, batch *evbatch.Batch, fieldResults []gjson.Result, vals []any) error {
+	for i := range vals {
+		vals[i] = nil
+		fieldResults[i] = gjson.Result{}
+	}
+	col := batch.Columns[d.payloadIndex]
+	if col.IsNull(rowIndex) {
+		return nil
+	}
+	var payload []byte
+	if d.isBytes {
+		payload = col.(*evbatch.BytesColumn).Get(rowIndex)
+	} else {
+		payload = []byte(col.(*evbatch.StringColumn).Get(rowIndex))
+	}
+	// The payload is validated, which doesn't allocate, and then a single pass over the top level fields of the
+	// object picks out the ones in the schema. Values are taken from the validated payload, so they are not parsed
+	// again.
+	var obj gjson.Result
+	if gjson.ValidBytes(payload) {
+		obj = gjson.ParseBytes(payload)
+	}
+	if !obj.IsObject() {
+		if d.strict {
+			return errors.Errorf("decode_json payload is not a JSON object: %s", payloadForError(payload))
+		}
+		return nil
+	}
+	obj.ForEach(func(key, value gjson.Result) bool {
+		if index, ok := d.fieldIndexes[key.Str]; ok {
+			fieldResults[index] = value
+		}
+		return true
+	})
+	for i, res := range fieldResults {
+		if !res.Exists() || res.Type == gjson.Null {
+			continue
+		}
+		val, err := decodeJSONField(d.fieldTypes[i], res)
+		if err != nil {
+			if d.strict {
+				return errors.Errorf("decode_json field '%s': %v", d.fieldNames[i], err)
+			}
+			continue
+		}
+		vals[i] = val
+	}
+	return nil
+}
+
+// maxErrorPayloadLen is the maximum number of bytes of a payload that are included in an error message
+const maxErrorPayloadLen = 64
+
+// payloadForError returns the payload for an error message, truncated so that large payloads don't flood the logs
+func payloadForError(payload []byte) string {
+	if len(payload) <= maxErrorPayloadLen {
+		return string(payload)
+	}
+	end := maxErrorPayloadLen
+	// Don't split a multibyte character
+	for end > 0 && !utf8.RuneStart(payload[end]) {
+		end--
+	}
+	return fmt.Sprintf("%s... (%d bytes)", payload[:end], len(payload))
+}
+
+// decodeJSONField converts a JSON value to a value of the column type, with the same conversions as parse_json.
+func decodeJSONField(columnType types.ColumnType, res gjson.Result) (any, error) {
+	return types.FromJSONValue(columnType, jsonValue(res))
+}
+
+// jsonValue converts a value of a validated payload to the value that encoding/json would unmarshal it to, with
+// numbers as json.Number.
+func jsonValue(res gjson.Result) any {
+	switch res.Type {
+	case gjson.Number:
+		return json.Number(res.Raw)
+	case gjson.String:
+		return res.Str
+	case gjson.True, gjson.False:
+		return res.Bool()
+	case gjson.JSON:
+		if res.IsArray() {
+			arr := make([]any, 0)
+			res.ForEach(func(_, value gjson.Result) bool {
+				arr = append(arr, jsonValue(value))
+				return true
+			})
+			return arr
+		}
+		m := map[string]any{}
+		res.ForEach(func(key, value gjson.Result) bool {
+			m[key.Str] = jsonValue(value)
+			return true
+		})
+		return m
+	default:
+		return nil
+	}
+}
+
+func (d *DecodeJSONOperator) InSchema() *OperatorSchema {
+	return d.inSchema
+}
+
+func (d *DecodeJSONOperator) OutSchema() *OperatorSchema {
+	return d.outSchema
+}
+
+func (d *DecodeJSONOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (d *DecodeJSONOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,149 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var decodeJSONColumnNames = []string{"offset", "key", "val"}
+var decodeJSONColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeBytes}
+
+const decodeJSONSchema = "(a string, b int, c decimal(10,2), d array<int>, e timestamp)"
+
+func decodeJSONInData() [][]any {
+	return [][]any{
+		{int64(0), "k0", []byte(`{"b": 23, "a": "foo", "c": 1.5, "d": [1, 2], "e": 1000, "other": {"x": 1}}`)},
+		{int64(1), "k1", []byte(`{"a": "bar", "b": null}`)},
+		{int64(2), "k2", nil},
+		{int64(3), "k3", []byte(`{"a": "baz", "b": "not an int", "c": "2.25", "e": "2024-01-02T03:04:05Z"}`)},
+		{int64(4), "k4", []byte(`[1, 2, 3]`)},
+		{int64(5), "k5", []byte(`{"a": "broken"`)},
+	}
+}
+
+func TestDecodeJSONLenient(t *testing.T) {
+	decodeJSON, err := createDecodeJSON(decodeJSONSchema, "", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"offset", "key", "a", "b", "c", "d", "e"}, decodeJSON.OutSchema().EventSchema.ColumnNames())
+	batch := createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, decodeJSONInData())
+	out, err := decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	require.Equal(t, [][]any{
+		{int64(0), "k0", "foo", int64(23), decodeJSONDecimal(t, "1.50"), []any{int64(1), int64(2)}, types.NewTimestamp(1000)},
+		{int64(1), "k1", "bar", nil, nil, nil, nil},
+		{int64(2), "k2", nil, nil, nil, nil, nil},
+		{int64(3), "k3", "baz", nil, decodeJSONDecimal(t, "2.25"), nil, types.NewTimestamp(1704164645000)},
+		{int64(4), "k4", nil, nil, nil, nil, nil},
+		{int64(5), "k5", nil, nil, nil, nil, nil},
+	}, convertBatchToAnyArray(out))
+}
+
+func TestDecodeJSONStrictFailsBatchWithoutHandler(t *testing.T) {
+	decodeJSON, err := createDecodeJSON(decodeJSONSchema, "", true, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.NoError(t, err)
+	batch := createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, decodeJSONInData())
+	_, err = decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `decode_json field 'b': cannot convert JSON string "not an int" to int`)
+}
+
+func TestDecodeJSONStrictSkipsFailedRows(t *testing.T) {
+	decodeJSON, err := createDecodeJSON(decodeJSONSchema, "", true, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.NoError(t, err)
+	decodeJSON.rowErrHandler = &rowErrorHandler{onError: parser.OnErrorSkip}
+	batch := createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, decodeJSONInData())
+	out, err := decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	// Rows with a field of the wrong type, or payloads which are not JSON objects, are skipped
+	require.Equal(t, [][]any{
+		{int64(0), "k0", "foo", int64(23), decodeJSONDecimal(t, "1.50"), []any{int64(1), int64(2)}, types.NewTimestamp(1000)},
+		{int64(1), "k1", "bar", nil, nil, nil, nil},
+		{int64(2), "k2", nil, nil, nil, nil, nil},
+	}, convertBatchToAnyArray(out))
+}
+
+func TestDecodeJSONStrictInvalidPayload(t *testing.T) {
+	decodeJSON, err := createDecodeJSON("(a string)", "", true, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.NoError(t, err)
+	batch := createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, [][]any{{int64(0), "k0", []byte(`[1]`)}})
+	_, err = decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decode_json payload is not a JSON object: [1]")
+
+	// Large payloads are truncated in the error
+	payload := "[" + strings.Repeat("1,", 100) + "1]"
+	batch = createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, [][]any{{int64(0), "k0", []byte(payload)}})
+	_, err = decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decode_json payload is not a JSON object: "+payload[:64]+"... (203 bytes)")
+
+	// As are invalid payloads that start like an object
+	payload = `{"a": "` + strings.Repeat("é", 40)
+	batch = createEventBatch(decodeJSONColumnNames, decodeJSONColumnTypes, [][]any{{int64(0), "k0", []byte(payload)}})
+	_, err = decodeJSON.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decode_json payload is not a JSON object: "+payload[:63]+"... (87 bytes)")
+}
+
+func TestDecodeJSONStringColumn(t *testing.T) {
+	names := []string{"payload"}
+	colTypes := []types.ColumnType{types.ColumnTypeString}
+	decodeJSON, err := createDecodeJSON("(x float, y bool, s struct<p:int,q:string>)", "payload", false, names, colTypes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"x", "y", "s"}, decodeJSON.OutSchema().EventSchema.ColumnNames())
+	batch := createEventBatch(names, colTypes, [][]any{{`{"x": 1.25, "y": true, "s": {"q": "z", "p": 7}}`}})
+	out, err := decodeJSON.HandleQueryBatch(batch, &testQueryExecCtx{})
+	require.NoError(t, err)
+	require.Equal(t, [][]any{{1.25, true, []any{int64(7), "z"}}}, convertBatchToAnyArray(out))
+}
+
+func TestDecodeJSONInvalidColumn(t *testing.T) {
+	_, err := createDecodeJSON("(a string)", "foo", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot decode column 'foo' - the incoming schema has no such column")
+
+	_, err = createDecodeJSON("(a string)", "offset", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot decode column 'offset' - it must be of type string or bytes - it is of type int")
+}
+
+func TestDecodeJSONColumnClashes(t *testing.T) {
+	_, err := createDecodeJSON("(key string)", "", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use column name 'key', the incoming schema already has a column with that name")
+
+	_, err = createDecodeJSON("(event_time timestamp)", "", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use column name 'event_time', it is a reserved name")
+
+	// The payload column is replaced, so its name can be reused
+	decodeJSON, err := createDecodeJSON("(val int)", "", false, decodeJSONColumnNames, decodeJSONColumnTypes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"offset", "key", "val"}, decodeJSON.OutSchema().EventSchema.ColumnNames())
+}
+
+func createDecodeJSON(schema string, column string, strict bool, names []string,
+	colTypes []types.ColumnType) (*DecodeJSONOperator, error) {
+	desc := parser.NewDecodeJSONDesc()
+	input := "decode_json schema = " + schema
+	if column != "" {
+		input += " column = " + column
+	}
+	if strict {
+		input += " strict = true"
+	}
+	if err := parser.NewParser(nil).Parse(input+")", desc); err != nil {
+		return nil, err
+	}
+	return NewDecodeJSONOperator(&OperatorSchema{EventSchema: evbatch.NewEventSchema(names, colTypes)}, desc)
+}
+
+func decodeJSONDecimal(t *testing.T, s string) types.Decimal {
+	d, err := types.NewDecimalFromString(s, 10, 2)
+	require.NoError(t, err)
+	return d
+}
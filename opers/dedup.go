@@ -0,0 +1,138 @@
+package opers
+
+import (
+	"encoding/binary"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"sync"
+)
+
+// DedupOperator drops any row whose key has already been seen within the configured window. The event_time at which
+// each key was first seen is stored, per partition, in the dedup slab. Rows with the same key must therefore be in the
+// same partition, so the stream must be partitioned by some or all of the dedup key columns before the operator - this is
+// checked when the stream is deployed.
+type DedupOperator struct {
+	BaseOperator
+	schema            *OperatorSchema
+	keyExprs          []expr.Expression
+	slabID            int
+	within            int64
+	eventTimeColIndex int
+}
+
+func NewDedupOperator(schema *OperatorSchema, desc *parser.DedupDesc, slabID int,
+	expressionFactory *expr.ExpressionFactory) (*DedupOperator, error) {
+	keyExprs := make([]expr.Expression, len(desc.KeyExprs))
+	for i, keyExprDesc := range desc.KeyExprs {
+		e, err := expressionFactory.CreateExpression(keyExprDesc, schema.EventSchema)
+		if err != nil {
+			return nil, err
+		}
+		keyExprs[i] = e
+	}
+	eventTimeColIndex := 0
+	if HasOffsetColumn(schema.EventSchema) {
+		eventTimeColIndex = 1
+	}
+	return &DedupOperator{
+		schema:            schema,
+		keyExprs:          keyExprs,
+		slabID:            slabID,
+		within:            desc.Within.Milliseconds(),
+		eventTimeColIndex: eventTimeColIndex,
+	}, nil
+}
+
+func (d *DedupOperator) HandleQueryBatch(*evbatch.Batch, QueryExecContext) (*evbatch.Batch, error) {
+	panic("not supported in queries")
+}
+
+func (d *DedupOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	outBatch, err := d.processBatch(batch, execCtx)
+	if err != nil {
+		return nil, err
+	}
+	if outBatch.RowCount > 0 {
+		return outBatch, d.sendBatchDownStream(outBatch, execCtx)
+	}
+	return outBatch, nil
+}
+
+func (d *DedupOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	defer batch.Release()
+	keyCols := make([]evbatch.Column, len(d.keyExprs))
+	for i, keyExpr := range d.keyExprs {
+		col, err := expr.EvalColumn(keyExpr, batch)
+		if err != nil {
+			return nil, err
+		}
+		keyCols[i] = col
+	}
+	eventTimeCol := batch.GetTimestampColumn(d.eventTimeColIndex)
+	// Keys seen earlier in this batch won't be visible from the store yet
+	seenInBatch := map[string]int64{}
+	colBuilders := evbatch.CreateColBuilders(d.schema.EventSchema.ColumnTypes())
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		key := encoding.EncodeEntryPrefix(uint64(d.slabID), uint64(execCtx.PartitionID()), 32)
+		for i, keyExpr := range d.keyExprs {
+			key = evbatch.EncodeKeyCol(rowIndex, keyCols[i], keyExpr.ResultType(), key)
+		}
+		eventTime := eventTimeCol.Get(rowIndex).Val
+		firstSeen, seen, err := d.getFirstSeen(key, seenInBatch, execCtx)
+		if err != nil {
+			return nil, err
+		}
+		if seen {
+			diff := eventTime - firstSeen
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff < d.within {
+				// duplicate - drop it
+				continue
+			}
+		}
+		seenInBatch[string(key)] = eventTime
+		val := make([]byte, 8)
+		binary.LittleEndian.PutUint64(val, uint64(eventTime))
+		key = encoding.EncodeVersion(key, uint64(execCtx.WriteVersion()))
+		execCtx.StoreEntry(common.KV{Key: key, Value: val}, false)
+		for colIndex, ft := range d.schema.EventSchema.ColumnTypes() {
+			evbatch.CopyColumnEntry(ft, colBuilders, colIndex, rowIndex, batch)
+		}
+	}
+	return evbatch.NewBatchFromBuilders(d.schema.EventSchema, colBuilders...), nil
+}
+
+func (d *DedupOperator) getFirstSeen(key []byte, seenInBatch map[string]int64, execCtx StreamExecContext) (int64, bool, error) {
+	firstSeen, ok := seenInBatch[common.ByteSliceToStringZeroCopy(key)]
+	if ok {
+		return firstSeen, true, nil
+	}
+	v, err := execCtx.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	if v == nil {
+		return 0, false, nil
+	}
+	return int64(binary.LittleEndian.Uint64(v)), true, nil
+}
+
+func (d *DedupOperator) InSchema() *OperatorSchema {
+	return d.schema
+}
+
+func (d *DedupOperator) OutSchema() *OperatorSchema {
+	return d.schema
+}
+
+func (d *DedupOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (d *DedupOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,113 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var dedupColumnNames = []string{"offset", "event_time", "k1", "k2", "v"}
+var dedupColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeTimestamp, types.ColumnTypeString,
+	types.ColumnTypeInt, types.ColumnTypeString}
+
+func TestDedupWithinBatch(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(1), types.NewTimestamp(1001), "b", int64(1), "v1"},
+		{int64(2), types.NewTimestamp(1002), "a", int64(1), "v2"},
+		{int64(3), types.NewTimestamp(1003), "a", int64(2), "v3"},
+		{int64(4), types.NewTimestamp(1004), "b", int64(1), "v4"},
+		{int64(5), types.NewTimestamp(1005), nil, int64(1), "v5"},
+		{int64(6), types.NewTimestamp(1006), nil, int64(1), "v6"},
+	}
+	expectedOut := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(1), types.NewTimestamp(1001), "b", int64(1), "v1"},
+		{int64(3), types.NewTimestamp(1003), "a", int64(2), "v3"},
+		{int64(5), types.NewTimestamp(1005), nil, int64(1), "v5"},
+	}
+	testDedup(t, []string{"k1", "k2"}, time.Minute, inData, expectedOut, nil)
+}
+
+func TestDedupKeyExpression(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(1), types.NewTimestamp(1001), "A", int64(2), "v1"},
+		{int64(2), types.NewTimestamp(1002), "b", int64(3), "v2"},
+	}
+	expectedOut := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(2), types.NewTimestamp(1002), "b", int64(3), "v2"},
+	}
+	testDedup(t, []string{"to_lower(k1)"}, time.Minute, inData, expectedOut, nil)
+}
+
+func TestDedupOutsideWindow(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(1), types.NewTimestamp(1999), "a", int64(1), "v1"},
+		{int64(2), types.NewTimestamp(2000), "a", int64(1), "v2"},
+		{int64(3), types.NewTimestamp(2500), "a", int64(1), "v3"},
+		{int64(4), types.NewTimestamp(3000), "a", int64(1), "v4"},
+	}
+	expectedOut := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(2), types.NewTimestamp(2000), "a", int64(1), "v2"},
+		{int64(4), types.NewTimestamp(3000), "a", int64(1), "v4"},
+	}
+	testDedup(t, []string{"k1"}, time.Second, inData, expectedOut, nil)
+}
+
+func TestDedupAcrossBatches(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "a", int64(1), "v0"},
+		{int64(1), types.NewTimestamp(1001), "b", int64(1), "v1"},
+	}
+	stored := testDedup(t, []string{"k1"}, time.Second, inData, inData, nil)
+
+	inData = [][]any{
+		{int64(2), types.NewTimestamp(1500), "a", int64(1), "v2"},
+		{int64(3), types.NewTimestamp(1600), "c", int64(1), "v3"},
+		{int64(4), types.NewTimestamp(2001), "b", int64(1), "v4"},
+	}
+	expectedOut := [][]any{
+		{int64(3), types.NewTimestamp(1600), "c", int64(1), "v3"},
+		{int64(4), types.NewTimestamp(2001), "b", int64(1), "v4"},
+	}
+	testDedup(t, []string{"k1"}, time.Second, inData, expectedOut, stored)
+}
+
+func testDedup(t *testing.T, keyExprStrs []string, within time.Duration, inData [][]any, expectedOut [][]any,
+	stored []common.KV) []common.KV {
+	keyExprs, err := toExprs(keyExprStrs...)
+	require.NoError(t, err)
+	desc := &parser.DedupDesc{
+		KeyExprs:        keyExprs,
+		KeyExprsStrings: keyExprStrs,
+		Within:          within,
+	}
+	inSchema := evbatch.NewEventSchema(dedupColumnNames, dedupColumnTypes)
+	dedup, err := NewDedupOperator(&OperatorSchema{EventSchema: inSchema}, desc, 1001, &expr.ExpressionFactory{})
+	require.NoError(t, err)
+
+	storedMap := map[string][]byte{}
+	for _, kv := range stored {
+		// We remove the version as we don't look up based on that
+		storedMap[string(kv.Key[:len(kv.Key)-8])] = kv.Value
+	}
+	ctx := &testExecCtx{
+		version:     1234,
+		partitionID: 23,
+		stored:      storedMap,
+	}
+	batch := createEventBatch(dedupColumnNames, dedupColumnTypes, inData)
+	out, err := dedup.HandleStreamBatch(batch, ctx)
+	require.NoError(t, err)
+	require.Equal(t, expectedOut, convertBatchToAnyArray(out))
+	return append(stored, ctx.entries...)
+}
@@ -9,6 +9,7 @@ import (
 	log "github.com/spirit-labs/tektite/logger"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/proc"
+	"github.com/spirit-labs/tektite/retention"
 	store2 "github.com/spirit-labs/tektite/store"
 	"github.com/spirit-labs/tektite/testutils"
 	"github.com/spirit-labs/tektite/tppm"
@@ -414,6 +415,102 @@ func TestTableMultipleColKey(t *testing.T) {
 		expectedOut, streamInfo.UserSlab.SlabID, 0, store)
 }
 
+func TestTableWithIndex(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	tsl := `test_stream1 := (store table by f1 index by (f3))`
+	columnNames := []string{"f0", "f1", "f2", "f3"}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt, types.ColumnTypeFloat, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, true)
+
+	streamInfo := mgr.GetStream("test_stream1")
+	require.NotNil(t, streamInfo)
+	require.Equal(t, 1, len(streamInfo.ExtraSlabs))
+	require.Equal(t, 1, len(streamInfo.UserSlab.Indexes))
+	index := streamInfo.UserSlab.Indexes[0]
+	require.Equal(t, []string{"f3"}, index.IndexCols)
+	require.Equal(t, []int{3, 1}, index.Slab.KeyColIndexes)
+
+	dataIn := [][]any{
+		{int64(0), int64(10), float64(1.1), "foo1"},
+		{int64(1), int64(5), float64(2.1), "foo2"},
+		{int64(2), int64(13), float64(3.1), "foo3"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut := [][]any{
+		{int64(0), int64(10), float64(1.1), "foo1"},
+		{int64(1), int64(5), float64(2.1), "foo2"},
+		{int64(2), int64(13), float64(3.1), "foo3"},
+	}
+	verifyRowsInTablePartition(t, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt}, []int{3, 1},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat}, []int{0, 2},
+		expectedOut, index.Slab.SlabID, 0, store)
+
+	// Change the indexed column for some rows - the old index entries must be removed, including when the same row
+	// is updated more than once in a batch
+	dataIn = [][]any{
+		{int64(3), int64(10), float64(1.5), "foo9"},
+		{int64(4), int64(5), float64(2.2), "foo2"},
+		{int64(5), int64(5), float64(2.3), "foo0"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut = [][]any{
+		{int64(5), int64(5), float64(2.3), "foo0"},
+		{int64(2), int64(13), float64(3.1), "foo3"},
+		{int64(3), int64(10), float64(1.5), "foo9"},
+	}
+	verifyRowsInTablePartition(t, []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt}, []int{3, 1},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat}, []int{0, 2},
+		expectedOut, index.Slab.SlabID, 0, store)
+}
+
+func TestTableWithVersionsRetention(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+
+	deployStream(t, `test_stream1 := (store table by f0)`, mgr, columnNames, columnTypes, true, true)
+	deployStream(t, `test_stream2 := (store table by f0 versions_retention = 2h)`, mgr, columnNames, columnTypes,
+		true, true)
+
+	streamInfo := mgr.GetStream("test_stream2")
+	require.NotNil(t, streamInfo)
+	expectedPrefix := encoding.AppendUint64ToBufferBE(nil, uint64(streamInfo.UserSlab.SlabID))
+	versionRetentions := mgr.prefixRetentionService.(*dummyPrefixRetention).versionRetentions
+	require.Equal(t, []retention.PrefixRetention{{Prefix: expectedPrefix, Retention: uint64(2 * time.Hour / time.Millisecond)}},
+		versionRetentions)
+}
+
+func TestTableIndexInvalidColumns(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"f0", "f1", "f2"}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt, types.ColumnTypeString}
+
+	err := deployStreamReturnError(t, `test_stream1 := (store table by f1 index by (f7))`, mgr, columnNames,
+		columnTypes, true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use index column 'f7' - it is not a known column in the incoming schema")
+
+	err = deployStreamReturnError(t, `test_stream1 := (store table by f1 index by (f2, f1))`, mgr, columnNames,
+		columnTypes, true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use index column 'f1' - it is a key column of the table")
+}
+
 func TestAggregate(t *testing.T) {
 	mgr, pm, store := createManager()
 	defer stopStore(t, store)
@@ -469,6 +566,391 @@ func TestAggregate(t *testing.T) {
 		expectedOut, streamInfo.UserSlab.SlabID, 0, store)
 }
 
+func TestDeployDedup(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (partition by f1 partitions = 1) -> (dedup by f1 within 1m)`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, true)
+
+	streamInfo := mgr.GetStream("test_stream1")
+	require.NotNil(t, streamInfo)
+	// The rows are forwarded to the processor for the single partition
+	dedupProcessorID := streamInfo.Operators[2].OutSchema().PartitionProcessorMapping[0]
+	if dedupProcessorID != 0 {
+		pm.AddActiveProcessor(dedupProcessorID)
+	}
+	require.Equal(t, 1, len(streamInfo.ExtraSlabs))
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(0), "foo1"},
+		{types.NewTimestamp(1001), int64(1), "foo2"},
+		{types.NewTimestamp(1002), int64(2), "foo1"},
+		{types.NewTimestamp(1003), int64(3), "foo3"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut := [][]any{
+		{types.NewTimestamp(1000), int64(0), "foo1"},
+		{types.NewTimestamp(1001), int64(1), "foo2"},
+		{types.NewTimestamp(1003), int64(3), "foo3"},
+	}
+	verifyReceivedData(t, "test_stream1", 0, expectedOut, mgr)
+}
+
+func TestDedupRequiresPartitionByKey(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	// Partitioned by a subset of the dedup key, passed through a filter and renamed by a project
+	deployStream(t, `test_stream1 := (partition by f1 partitions = 10) -> (filter by f0 > 1) -> (project f0, f1 as g1) -> (dedup by g1, f0 within 1m)`,
+		mgr, columnNames, columnTypes, true, true)
+
+	err := deployStreamReturnError(t, `test_stream2 := (dedup by f1 within 1m)`, mgr, columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'dedup' requires a 'partition by' on the dedup key before it - rows with the same key must be in the same partition")
+
+	err = deployStreamReturnError(t, `test_stream2 := (partition by f1 partitions = 10) -> (dedup by f0 within 1m)`, mgr,
+		columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'dedup' key must include all the columns of the upstream 'partition by' - rows with the same key would not be in the same partition")
+
+	// The partition column is replaced by a computed column with the same name
+	err = deployStreamReturnError(t, `test_stream2 := (partition by f1 partitions = 10) -> (project f0, to_upper(f1) as f1) -> (dedup by f1 within 1m)`,
+		mgr, columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'dedup' requires a 'partition by' on the dedup key before it - rows with the same key must be in the same partition")
+}
+
+func TestDedupCannotBeFirstOperator(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	err := deployStreamReturnError(t, `test_stream1 := (dedup by f1 within 1m)`, mgr, nil, nil, false, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'dedup' cannot be the first operator in a stream")
+}
+
+func TestDeployTopN(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (partition by f1 partitions = 1) -> (topn n = 2 by f1 order by f0 desc)`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, true)
+
+	streamInfo := mgr.GetStream("test_stream1")
+	require.NotNil(t, streamInfo)
+	// The rows are forwarded to the processor for the single partition
+	topNProcessorID := streamInfo.Operators[2].OutSchema().PartitionProcessorMapping[0]
+	if topNProcessorID != 0 {
+		pm.AddActiveProcessor(topNProcessorID)
+	}
+	require.Equal(t, 1, len(streamInfo.ExtraSlabs))
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(10), "foo1"},
+		{types.NewTimestamp(1001), int64(20), "foo1"},
+		{types.NewTimestamp(1002), int64(30), "foo2"},
+		{types.NewTimestamp(1003), int64(5), "foo1"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut := [][]any{
+		{types.NewTimestamp(1001), int64(20), "foo1", int64(1)},
+		{types.NewTimestamp(1000), int64(10), "foo1", int64(2)},
+		{types.NewTimestamp(1002), int64(30), "foo2", int64(1)},
+	}
+	verifyReceivedData(t, "test_stream1", 0, expectedOut, mgr)
+}
+
+func TestTopNRequiresPartitionByKey(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	deployStream(t, `test_stream1 := (partition by f1 partitions = 10) -> (filter by f0 > 1) -> (topn n = 2 by f1, f0 order by event_time)`,
+		mgr, columnNames, columnTypes, true, true)
+
+	err := deployStreamReturnError(t, `test_stream2 := (topn n = 2 by f1 order by f0)`, mgr, columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'topn' requires a 'partition by' on the topn key before it - rows with the same key must be in the same partition")
+
+	err = deployStreamReturnError(t, `test_stream2 := (partition by f1 partitions = 10) -> (topn n = 2 by f0 order by f0)`, mgr,
+		columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'topn' key must include all the columns of the upstream 'partition by' - rows with the same key would not be in the same partition")
+
+	// With no key, all the rows must be ranked together, so they must be in a single partition
+	err = deployStreamReturnError(t, `test_stream2 := (partition by f1 partitions = 10) -> (topn n = 2 order by f0)`, mgr,
+		columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'topn' requires a 'partition by' on the topn key before it - rows with the same key must be in the same partition")
+	deployStream(t, `test_stream2 := (partition by f1 partitions = 1) -> (topn n = 2 order by f0)`, mgr, columnNames,
+		columnTypes, true, true)
+}
+
+func TestTopNInvalidN(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	err := deployStreamReturnError(t, `test_stream1 := (topn n = 0 by f1 order by f0)`, mgr, columnNames, columnTypes,
+		true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid value for 'n' - must be > 0")
+}
+
+func TestTopNCannotBeFirstOperator(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	err := deployStreamReturnError(t, `test_stream1 := (topn n = 3 order by f1)`, mgr, nil, nil, false, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'topn' cannot be the first operator in a stream")
+}
+
+func TestDeploySplit(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (split when f0 > 10 -> high, when f0 < 0 -> negative, else -> other)`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, false)
+	for _, branchName := range []string{"high", "negative", "other"} {
+		branchInfo := mgr.GetStream(branchName)
+		require.NotNil(t, branchInfo)
+		require.Equal(t, columnTypes, branchInfo.OutSchema.EventSchema.ColumnTypes())
+	}
+	deployStream(t, `high_out := high -> (project f1)`, mgr, nil, nil, false, true)
+	deployStream(t, `other_out := other -> (filter by f1 != "z")`, mgr, nil, nil, false, true)
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(20), "a"},
+		{types.NewTimestamp(1001), int64(-1), "b"},
+		{types.NewTimestamp(1002), int64(5), "c"},
+		{types.NewTimestamp(1003), int64(11), "d"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	verifyReceivedData(t, "high_out", 0, [][]any{
+		{types.NewTimestamp(1000), "a"},
+		{types.NewTimestamp(1003), "d"},
+	}, mgr)
+	verifyReceivedData(t, "other_out", 0, [][]any{
+		{types.NewTimestamp(1002), int64(5), "c"},
+	}, mgr)
+
+	// Branches can't be deleted directly
+	err := mgr.UndeployStream(createDeleteStreamDesc(t, "high"), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot delete stream high - it is a branch of the 'split' in stream test_stream1")
+
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream1"), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot delete stream test_stream1 - its split branch high has child streams: [high_out]")
+
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "high_out"), 0)
+	require.NoError(t, err)
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "other_out"), 0)
+	require.NoError(t, err)
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream1"), 0)
+	require.NoError(t, err)
+	for _, branchName := range []string{"high", "negative", "other"} {
+		require.Nil(t, mgr.GetStream(branchName))
+	}
+	require.Equal(t, 0, mgr.numStreams())
+}
+
+func TestSplitBranchStreamAlreadyExists(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	deployStream(t, `high := (filter by f0 > 10)`, mgr, columnNames, columnTypes, true, false)
+
+	err := deployStreamReturnError(t, `test_stream1 := (split when f0 > 10 -> high, else -> other)`, mgr, columnNames,
+		columnTypes, true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "stream 'high' already exists")
+}
+
+func TestCannotContinueFromSplit(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	deployStream(t, `test_stream1 := (split when f0 > 10 -> high)`, mgr, columnNames, columnTypes, true, false)
+
+	err := deployStreamReturnError(t, `test_stream2 := test_stream1 -> (filter by f0 > 1)`, mgr, nil, nil, false, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot consume from stream 'test_stream1' as it ends with a 'split'")
+}
+
+func TestSplitMustBeLastOperator(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	err := deployStreamReturnError(t, `test_stream1 := (split when f0 > 10 -> high) -> (filter by f0 > 1)`, mgr,
+		columnNames, columnTypes, true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'split' must be the last operator in a stream")
+}
+
+func TestDeployOnErrorDeadLetter(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (filter by to_int(f1) > 1) -> (project f0, to_int(f1) as f2) on_error := dead_letter`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, true)
+
+	streamInfo := mgr.GetStream("test_stream1")
+	require.NotNil(t, streamInfo)
+	require.Equal(t, 2, len(streamInfo.ExtraSlabs))
+	endpoint := mgr.GetKafkaEndpoint(DeadLetterTopicName("test_stream1"))
+	require.NotNil(t, endpoint)
+	require.NotNil(t, endpoint.OutEndpoint)
+	require.Nil(t, endpoint.InEndpoint)
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(0), "1"},
+		{types.NewTimestamp(1001), int64(1), "foo"},
+		{types.NewTimestamp(1002), int64(2), "3"},
+		{types.NewTimestamp(1003), int64(3), "bar"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut := [][]any{
+		{types.NewTimestamp(1002), int64(2), int64(3)},
+	}
+	verifyReceivedData(t, "test_stream1", 0, expectedOut, mgr)
+
+	// The two failed rows have been written to the dead-letter topic
+	lastOffset, _, ok, err := endpoint.OutEndpoint.LatestOffset(0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(2), lastOffset)
+
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream1"), 0)
+	require.NoError(t, err)
+	require.Nil(t, mgr.GetKafkaEndpoint(DeadLetterTopicName("test_stream1")))
+}
+
+func TestDeployOnErrorSkip(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (project f0, to_int(f1) as f2) on_error := skip`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, true)
+	require.Nil(t, mgr.GetKafkaEndpoint(DeadLetterTopicName("test_stream1")))
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(0), "1"},
+		{types.NewTimestamp(1001), int64(1), "foo"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	expectedOut := [][]any{
+		{types.NewTimestamp(1000), int64(0), int64(1)},
+	}
+	verifyReceivedData(t, "test_stream1", 0, expectedOut, mgr)
+}
+
+func TestDeploySplitOnErrorDeadLetter(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+	pm.AddActiveProcessor(0)
+
+	tsl := `test_stream1 := (split when to_int(f1) > 1 -> high, else -> other) on_error := dead_letter`
+	deployStream(t, tsl, mgr, columnNames, columnTypes, true, false)
+	endpoint := mgr.GetKafkaEndpoint(DeadLetterTopicName("test_stream1"))
+	require.NotNil(t, endpoint)
+	deployStream(t, `high_out := high -> (filter by f0 >= 0)`, mgr, nil, nil, false, true)
+	deployStream(t, `other_out := other -> (filter by f0 >= 0)`, mgr, nil, nil, false, true)
+
+	dataIn := [][]any{
+		{types.NewTimestamp(1000), int64(0), "1"},
+		{types.NewTimestamp(1001), int64(1), "foo"},
+		{types.NewTimestamp(1002), int64(2), "3"},
+		{types.NewTimestamp(1003), int64(3), "bar"},
+	}
+	injectBatch(t, "test_stream1", 0, 0, dataIn, mgr, pm)
+
+	verifyReceivedData(t, "high_out", 0, [][]any{
+		{types.NewTimestamp(1002), int64(2), "3"},
+	}, mgr)
+	verifyReceivedData(t, "other_out", 0, [][]any{
+		{types.NewTimestamp(1000), int64(0), "1"},
+	}, mgr)
+
+	// The two failed rows have been written to the dead-letter topic, rather than failing the batch
+	lastOffset, _, ok, err := endpoint.OutEndpoint.LatestOffset(0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(2), lastOffset)
+}
+
+func TestOnErrorDeadLetterDifferentPartitionSchemes(t *testing.T) {
+	mgr, _, store := createManager()
+	defer stopStore(t, store)
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	tsl := `test_stream1 := (filter by to_int(f1) > 1) -> (partition by f0 partitions = 3) -> (project f0) on_error := dead_letter`
+	err := deployStreamReturnError(t, tsl, mgr, columnNames, columnTypes, true, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "all 'filter', 'project', 'unnest', 'decode_json' and 'split' operators in the stream must have the same partition scheme")
+}
+
 func TestDeployStreamAlreadyExists(t *testing.T) {
 	mgr, _, _ := createManager()
 	tsl := `test_stream1 :=  (filter by f1 >= 2) -> (store stream)`
@@ -748,7 +1230,8 @@ func loadRowsFromPartition(t *testing.T, keyColumnTypes []types.ColumnType, outK
 		row := make([]any, numCols)
 		keySlice, _, err := encoding.DecodeKeyToSlice(curr.Key, 16, keyColumnTypes)
 		require.NoError(t, err)
-		rowSlice, _ := encoding.DecodeRowToSlice(curr.Value, 0, rowColumnTypes)
+		rowSlice, _, err := encoding.DecodeRowToSlice(curr.Value, 0, rowColumnTypes)
+		require.NoError(t, err)
 		for i, keyCol := range outKeyColIndexes {
 			row[keyCol] = keySlice[i]
 		}
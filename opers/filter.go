@@ -25,12 +25,18 @@ func NewFilterOperator(schema *OperatorSchema, exprDesc parser.ExprDesc, express
 
 type FilterOperator struct {
 	BaseOperator
-	schema *OperatorSchema
-	expr   expr.Expression
+	schema        *OperatorSchema
+	expr          expr.Expression
+	rowErrHandler *rowErrorHandler
+}
+
+// Expression returns the filter expression
+func (f *FilterOperator) Expression() expr.Expression {
+	return f.expr
 }
 
 func (f *FilterOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
-	outBatch, err := f.processBatch(batch)
+	outBatch, err := f.processBatch(batch, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,7 +44,7 @@ func (f *FilterOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExe
 }
 
 func (f *FilterOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
-	outBatch, err := f.processBatch(batch)
+	outBatch, err := f.processBatch(batch, execCtx)
 	if err != nil {
 		return nil, err
 	}
@@ -48,13 +54,18 @@ func (f *FilterOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamE
 	return outBatch, nil
 }
 
-func (f *FilterOperator) processBatch(batch *evbatch.Batch) (*evbatch.Batch, error) {
+func (f *FilterOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
 	defer batch.Release()
 	colBuilders := evbatch.CreateColBuilders(f.schema.EventSchema.ColumnTypes())
+	var failed []failedRow
 	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
 		accept, null, err := f.expr.EvalBool(rowIndex, batch)
 		if err != nil {
-			return nil, err
+			if f.rowErrHandler == nil {
+				return nil, err
+			}
+			failed = append(failed, failedRow{rowIndex: rowIndex, err: err})
+			continue
 		}
 		if !null && accept {
 			for colIndex, ft := range f.schema.EventSchema.ColumnTypes() {
@@ -62,6 +73,11 @@ func (f *FilterOperator) processBatch(batch *evbatch.Batch) (*evbatch.Batch, err
 			}
 		}
 	}
+	if len(failed) > 0 {
+		if err := f.rowErrHandler.handleFailedRows("filter", batch, failed, execCtx); err != nil {
+			return nil, err
+		}
+	}
 	return evbatch.NewBatchFromBuilders(f.OutSchema().EventSchema, colBuilders...), nil
 }
 
@@ -88,8 +88,6 @@ func NewJoinOperator(leftTableSlabID int, rightTableSlabID int, left Operator, r
 
 	isStreamTableJoin := leftIsTable || rightIsTable
 
-	joinType := JoinTypeUnknown
-
 	s1 := left.OutSchema()
 	s2 := right.OutSchema()
 
@@ -118,43 +116,16 @@ func NewJoinOperator(leftTableSlabID int, rightTableSlabID int, left Operator, r
 
 	keySequences := make([]uint64, 1+maxProcID)
 
-	leftCols := map[string]struct{}{}
-	for _, colName := range s1.EventSchema.ColumnNames() {
-		leftCols[colName] = struct{}{}
+	errorf := func(token lexer.Token, msg string, args ...any) error {
+		return statementErrorAtPositionf(token, op, msg, args...)
 	}
-	rightCols := map[string]struct{}{}
-	for _, colName := range s2.EventSchema.ColumnNames() {
-		rightCols[colName] = struct{}{}
+	joinType, leftKeyCols, rightKeyCols, err := ResolveJoinElements(joinElements, s1.EventSchema, s2.EventSchema, errorf)
+	if err != nil {
+		return nil, err
 	}
-
-	var leftKeyCols []string
-	var rightKeyCols []string
 	rightKeyMap := map[string]struct{}{}
-	for _, elem := range joinElements {
-		var jt JoinType
-		switch elem.JoinType {
-		case "=":
-			jt = JoinTypeInner
-		case "*=":
-			jt = JoinTypeLeftOuter
-		case "=*":
-			jt = JoinTypeRightOuter
-		default:
-			panic("invalid joinType")
-		}
-		if joinType != JoinTypeUnknown && joinType != jt {
-			return nil, statementErrorAtPositionf(elem.JoinTypeToken, op, "the same join type (one of `=`, `*=` or `=*`) must be used for all join expression")
-		}
-		joinType = jt
-		if err := checkKeyColumn(elem.LeftCol, leftCols, op, elem.LeftToken); err != nil {
-			return nil, err
-		}
-		if err := checkKeyColumn(elem.RightCol, rightCols, op, elem.RightToken); err != nil {
-			return nil, err
-		}
-		leftKeyCols = append(leftKeyCols, elem.LeftCol)
-		rightKeyCols = append(rightKeyCols, elem.RightCol)
-		rightKeyMap[elem.RightCol] = struct{}{}
+	for _, rightKeyCol := range rightKeyCols {
+		rightKeyMap[rightKeyCol] = struct{}{}
 	}
 
 	outerSideTable := false
@@ -207,14 +178,11 @@ func NewJoinOperator(leftTableSlabID int, rightTableSlabID int, left Operator, r
 		leftKeyColumnTypes = append(leftKeyColumnTypes, leftType)
 	}
 	var rightKeyColumnTypes []types.ColumnType
-	for i, col := range rightTable.outKeyCols {
-		rightType := rightTable.outSchema.EventSchema.ColumnTypes()[col]
-		if rightType.ID() != leftKeyColumnTypes[i].ID() {
-			joinElem := joinElements[i]
-			return nil, statementErrorAtPositionf(joinElem.JoinTypeToken, op, "cannot join columns '%s' and '%s' - they have different types %s and %s", leftKeyCols[i], rightKeyCols[i],
-				leftKeyColumnTypes[i].String(), rightType.String())
-		}
-		rightKeyColumnTypes = append(rightKeyColumnTypes, rightType)
+	for _, col := range rightTable.outKeyCols {
+		rightKeyColumnTypes = append(rightKeyColumnTypes, rightTable.outSchema.EventSchema.ColumnTypes()[col])
+	}
+	if err := CheckJoinColumnTypes(joinElements, leftKeyColumnTypes, rightKeyColumnTypes, errorf); err != nil {
+		return nil, err
 	}
 
 	// We check that the key cols in the external table are compatible with the join cols. They are compatible if the
@@ -414,13 +382,73 @@ func keyColsCompatible(colsExternal []int, joinCols []int) bool {
 	return true
 }
 
-func checkKeyColumn(columnName string, colNames map[string]struct{}, desc *parser.JoinDesc, token lexer.Token) error {
+// ResolveJoinElements checks that the columns of the join elements are in the left and right schemas, and that the
+// same join type is used for all the elements. It returns the join type and the names of the left and right join
+// columns. errorf creates the error for a problem at the position of a token.
+func ResolveJoinElements(joinElements []parser.JoinElement, leftSchema *evbatch.EventSchema,
+	rightSchema *evbatch.EventSchema, errorf JoinErrorFunc) (JoinType, []string, []string, error) {
+	leftCols := map[string]struct{}{}
+	for _, colName := range leftSchema.ColumnNames() {
+		leftCols[colName] = struct{}{}
+	}
+	rightCols := map[string]struct{}{}
+	for _, colName := range rightSchema.ColumnNames() {
+		rightCols[colName] = struct{}{}
+	}
+	joinType := JoinTypeUnknown
+	var leftKeyCols []string
+	var rightKeyCols []string
+	for _, elem := range joinElements {
+		var jt JoinType
+		switch elem.JoinType {
+		case "=":
+			jt = JoinTypeInner
+		case "*=":
+			jt = JoinTypeLeftOuter
+		case "=*":
+			jt = JoinTypeRightOuter
+		default:
+			panic("invalid joinType")
+		}
+		if joinType != JoinTypeUnknown && joinType != jt {
+			return 0, nil, nil, errorf(elem.JoinTypeToken, "the same join type (one of `=`, `*=` or `=*`) must be used for all join expression")
+		}
+		joinType = jt
+		if err := checkKeyColumn(elem.LeftCol, leftCols, elem.LeftToken, errorf); err != nil {
+			return 0, nil, nil, err
+		}
+		if err := checkKeyColumn(elem.RightCol, rightCols, elem.RightToken, errorf); err != nil {
+			return 0, nil, nil, err
+		}
+		leftKeyCols = append(leftKeyCols, elem.LeftCol)
+		rightKeyCols = append(rightKeyCols, elem.RightCol)
+	}
+	return joinType, leftKeyCols, rightKeyCols, nil
+}
+
+// CheckJoinColumnTypes checks that the type of each left join column is the same as the type of the right join column
+// it is joined with
+func CheckJoinColumnTypes(joinElements []parser.JoinElement, leftTypes []types.ColumnType,
+	rightTypes []types.ColumnType, errorf JoinErrorFunc) error {
+	for i, joinElem := range joinElements {
+		if rightTypes[i].ID() != leftTypes[i].ID() {
+			return errorf(joinElem.JoinTypeToken, "cannot join columns '%s' and '%s' - they have different types %s and %s",
+				joinElem.LeftCol, joinElem.RightCol, leftTypes[i].String(), rightTypes[i].String())
+		}
+	}
+	return nil
+}
+
+// JoinErrorFunc creates an error for a problem with a join at the position of a token
+type JoinErrorFunc func(token lexer.Token, msg string, args ...any) error
+
+func checkKeyColumn(columnName string, colNames map[string]struct{}, token lexer.Token, errorf JoinErrorFunc) error {
 	if columnName == OffsetColName || columnName == EventTimeColName {
-		return statementErrorAtPositionf(token, desc, "joining with column '%s' is not allowed", columnName)
+		return errorf(token, "joining with column '%s' is not allowed", columnName)
 	}
 	_, available := colNames[columnName]
 	if !available {
-		return statementErrorAtPositionf(token, desc, "cannot join with column '%s' - it is not a known column in the input stream", columnName)
+		return errorf(token, "cannot join with column '%s' - it is not a known column in the input stream", columnName)
 	}
 	return nil
 }
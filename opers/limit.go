@@ -0,0 +1,262 @@
+package opers
+
+import (
+	"bytes"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"sort"
+	"sync"
+)
+
+// LimitOperator restricts the rows returned by a query to a page of at most limit rows, starting at offset.
+//
+// A limit runs in two phases. The partial phase runs on the remote nodes, once per query loader, and stops passing rows
+// once limit + offset rows have been sent - no partition can contribute more rows than that to the page, so the loader
+// can stop scanning early. The final phase runs locally, gathers the results from all partitions, orders them and
+// returns the requested page.
+//
+// Subsequent pages of results which are ordered by the limit start after the last row of the previous page, so each
+// page only needs to scan from where the previous one finished. Results which have been sorted are paged by offset.
+type LimitOperator struct {
+	BaseOperator
+	partial             bool
+	schema              *OperatorSchema
+	limit               int
+	offset              int
+	expectedLastBatches int
+	orderCols           []int
+	numKeyCols          int
+}
+
+// PageStart identifies where a page of the results of a query with a limit starts. For results ordered by the limit,
+// the page starts after the row whose encoded order columns are AfterKey, and if the leading order columns are the key
+// columns of the slab being scanned, the scan of each partition resumes from ScanFromKey, the encoded key columns of
+// that row. Results which have been sorted are instead paged by Offset, the number of rows returned in previous pages.
+type PageStart struct {
+	Offset      int
+	AfterKey    []byte
+	ScanFromKey []byte
+}
+
+// LimitState holds the state of a limit for a single execution of a query.
+type LimitState struct {
+	lock           sync.Mutex
+	Page           PageStart
+	numRows        int
+	done           bool
+	numLastBatches int
+	batches        []*evbatch.Batch
+}
+
+// Done returns true if the partial phase of the limit has sent all the rows it needs to
+func (l *LimitState) Done() bool {
+	return l.done
+}
+
+// NewPartialLimitOperator creates the partial phase of a limit. Each partition must be scanned in the order of the
+// column values at orderCols.
+func NewPartialLimitOperator(schema *OperatorSchema, desc *parser.LimitDesc, orderCols []int) *LimitOperator {
+	return &LimitOperator{
+		partial:   true,
+		schema:    schema,
+		limit:     desc.Limit,
+		offset:    desc.Offset,
+		orderCols: orderCols,
+	}
+}
+
+// NewLimitOperator creates the final phase of a limit. The results are ordered by the column values at orderCols
+// before the page is taken - this ensures consecutive pages are consistent, as results arrive from partitions in an
+// arbitrary order. If orderCols is nil the results are assumed to be in order already, e.g. if they have been sorted.
+// numKeyCols is the number of leading order columns which are the key columns of the slab being scanned, or 0 if the
+// scan cannot be resumed from the last row of a page.
+func NewLimitOperator(schema *OperatorSchema, desc *parser.LimitDesc, expectedLastBatches int, orderCols []int,
+	numKeyCols int) *LimitOperator {
+	return &LimitOperator{
+		schema:              schema,
+		limit:               desc.Limit,
+		offset:              desc.Offset,
+		expectedLastBatches: expectedLastBatches,
+		orderCols:           orderCols,
+		numKeyCols:          numKeyCols,
+	}
+}
+
+// NextPage returns where the page after the specified page starts, given the rows of the page, or nil if the page is
+// the last one.
+func (l *LimitOperator) NextPage(page PageStart, batch *evbatch.Batch) *PageStart {
+	if batch.RowCount < l.limit {
+		return nil
+	}
+	if l.orderCols == nil {
+		return &PageStart{Offset: page.Offset + l.limit}
+	}
+	lastRow := batch.RowCount - 1
+	next := &PageStart{AfterKey: evbatch.EncodeKeyCols(batch, lastRow, l.orderCols, nil)}
+	if l.numKeyCols > 0 {
+		next.ScanFromKey = evbatch.EncodeKeyCols(batch, lastRow, l.orderCols[:l.numKeyCols], nil)
+	}
+	return next
+}
+
+// pageStart returns the number of rows of the results, after any rows which are before the page start, which must be
+// skipped before the page is taken
+func (l *LimitOperator) pageStart(page PageStart) int {
+	if page.AfterKey != nil {
+		// The offset of the limit was applied to the first page
+		return 0
+	}
+	return l.offset + page.Offset
+}
+
+// beforePage returns true if the row is before the start of the page
+func (l *LimitOperator) beforePage(page PageStart, batch *evbatch.Batch, rowIndex int) bool {
+	if page.AfterKey == nil {
+		return false
+	}
+	return bytes.Compare(evbatch.EncodeKeyCols(batch, rowIndex, l.orderCols, nil), page.AfterKey) <= 0
+}
+
+func (l *LimitOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
+	limitState := execCtx.ExecState().(*LimitState)
+	if l.partial {
+		return l.handlePartial(batch, limitState, execCtx)
+	}
+	limitState.lock.Lock()
+	defer limitState.lock.Unlock()
+	limitState.batches = append(limitState.batches, batch)
+	if execCtx.Last() {
+		limitState.numLastBatches++
+		if limitState.numLastBatches == l.expectedLastBatches {
+			return l.createPage(limitState), nil
+		}
+	}
+	return nil, nil
+}
+
+func (l *LimitOperator) handlePartial(batch *evbatch.Batch, limitState *LimitState,
+	execCtx QueryExecContext) (*evbatch.Batch, error) {
+	if limitState.done {
+		return nil, nil
+	}
+	if limitState.Page.AfterKey != nil {
+		// The scan resumes from the key of the last row of the previous page, so any rows up to and including that row
+		// are at the start of the partition
+		batch = l.skipRowsBeforePage(batch, limitState.Page)
+	}
+	remaining := l.limit + l.pageStart(limitState.Page) - limitState.numRows
+	if batch.RowCount < remaining {
+		limitState.numRows += batch.RowCount
+		return batch, l.SendQueryBatchDownStream(batch, execCtx)
+	}
+	// We have all the rows we need from this partition, so we send the last batch now
+	if batch.RowCount > remaining {
+		batch = l.copyRows(batch, 0, remaining)
+	}
+	limitState.numRows += batch.RowCount
+	limitState.done = true
+	return batch, l.SendQueryBatchDownStream(batch, &lastQueryExecCtx{QueryExecContext: execCtx})
+}
+
+func (l *LimitOperator) skipRowsBeforePage(batch *evbatch.Batch, page PageStart) *evbatch.Batch {
+	start := 0
+	for start < batch.RowCount && l.beforePage(page, batch, start) {
+		start++
+	}
+	if start == 0 {
+		return batch
+	}
+	defer batch.Release()
+	return l.copyRows(batch, start, batch.RowCount)
+}
+
+func (l *LimitOperator) createPage(limitState *LimitState) *evbatch.Batch {
+	type rowRef struct {
+		batch    *evbatch.Batch
+		rowIndex int
+		key      []byte
+	}
+	var rows []rowRef
+	for _, batch := range limitState.batches {
+		for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+			ref := rowRef{batch: batch, rowIndex: rowIndex}
+			if l.orderCols != nil {
+				ref.key = evbatch.EncodeKeyCols(batch, rowIndex, l.orderCols, nil)
+				if limitState.Page.AfterKey != nil && bytes.Compare(ref.key, limitState.Page.AfterKey) <= 0 {
+					// Returned in a previous page
+					continue
+				}
+			}
+			rows = append(rows, ref)
+		}
+	}
+	if l.orderCols != nil {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return bytes.Compare(rows[i].key, rows[j].key) < 0
+		})
+	}
+	start := l.pageStart(limitState.Page)
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := start + l.limit
+	if end > len(rows) {
+		end = len(rows)
+	}
+	columnTypes := l.schema.EventSchema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(columnTypes)
+	for _, row := range rows[start:end] {
+		for colIndex, colType := range columnTypes {
+			evbatch.CopyColumnEntry(colType, builders, colIndex, row.rowIndex, row.batch)
+		}
+	}
+	for _, batch := range limitState.batches {
+		batch.Release()
+	}
+	limitState.batches = nil
+	return evbatch.NewBatchFromBuilders(l.schema.EventSchema, builders...)
+}
+
+func (l *LimitOperator) copyRows(batch *evbatch.Batch, start int, end int) *evbatch.Batch {
+	columnTypes := l.schema.EventSchema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(columnTypes)
+	for colIndex, colType := range columnTypes {
+		for rowIndex := start; rowIndex < end; rowIndex++ {
+			evbatch.CopyColumnEntry(colType, builders, colIndex, rowIndex, batch)
+		}
+	}
+	return evbatch.NewBatchFromBuilders(l.schema.EventSchema, builders...)
+}
+
+func (l *LimitOperator) HandleStreamBatch(*evbatch.Batch, StreamExecContext) (*evbatch.Batch, error) {
+	panic("not supported in streams")
+}
+
+func (l *LimitOperator) HandleBarrier(StreamExecContext) error {
+	panic("not supported in streams")
+}
+
+func (l *LimitOperator) InSchema() *OperatorSchema {
+	return l.schema
+}
+
+func (l *LimitOperator) OutSchema() *OperatorSchema {
+	return l.schema
+}
+
+func (l *LimitOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (l *LimitOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
+
+// lastQueryExecCtx marks a batch as the last one for the execution, regardless of whether the underlying context is
+// the last
+type lastQueryExecCtx struct {
+	QueryExecContext
+}
+
+func (l *lastQueryExecCtx) Last() bool {
+	return true
+}
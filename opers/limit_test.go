@@ -0,0 +1,179 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+var limitColumnNames = []string{"f0", "f1"}
+var limitColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+
+func TestPartialLimitStopsWhenLimitReached(t *testing.T) {
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	partial := NewPartialLimitOperator(schema, &parser.LimitDesc{Limit: 3, Offset: 1}, nil)
+	receiver := &testQueryReceiverOper{}
+	partial.AddDownStreamOperator(receiver)
+
+	state := &LimitState{Page: PageStart{Offset: 1}}
+	// limit + offset + page offset = 5 rows are needed
+	batch1 := createEventBatch(limitColumnNames, limitColumnTypes, [][]any{{int64(0), "a"}, {int64(1), "b"}})
+	_, err := partial.HandleQueryBatch(batch1, &testQueryExecCtx{execState: state})
+	require.NoError(t, err)
+	require.False(t, state.Done())
+
+	batch2 := createEventBatch(limitColumnNames, limitColumnTypes,
+		[][]any{{int64(2), "c"}, {int64(3), "d"}, {int64(4), "e"}, {int64(5), "f"}})
+	_, err = partial.HandleQueryBatch(batch2, &testQueryExecCtx{execState: state})
+	require.NoError(t, err)
+	require.True(t, state.Done())
+
+	require.Equal(t, 2, len(receiver.batches))
+	require.Equal(t, []bool{false, true}, receiver.lasts)
+	require.Equal(t, [][]any{{int64(2), "c"}, {int64(3), "d"}, {int64(4), "e"}},
+		convertBatchToAnyArray(receiver.batches[1]))
+}
+
+func TestPartialLimitPassesLastBatch(t *testing.T) {
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	partial := NewPartialLimitOperator(schema, &parser.LimitDesc{Limit: 10}, nil)
+	receiver := &testQueryReceiverOper{}
+	partial.AddDownStreamOperator(receiver)
+
+	state := &LimitState{}
+	batch := createEventBatch(limitColumnNames, limitColumnTypes, [][]any{{int64(0), "a"}, {int64(1), "b"}})
+	_, err := partial.HandleQueryBatch(batch, &testQueryExecCtx{last: true, execState: state})
+	require.NoError(t, err)
+	require.False(t, state.Done())
+	require.Equal(t, []bool{true}, receiver.lasts)
+	require.Equal(t, 2, receiver.batches[0].RowCount)
+}
+
+func TestLimitOrdersAndPages(t *testing.T) {
+	partitionData := [][][]any{
+		{{int64(4), "e"}, {int64(1), "b"}},
+		{{int64(2), "c"}, {nil, "z"}},
+		{{int64(3), "d"}, {int64(0), "a"}},
+	}
+	expectedPages := [][][]any{
+		{{nil, "z"}, {int64(0), "a"}, {int64(1), "b"}},
+		{{int64(2), "c"}, {int64(3), "d"}, {int64(4), "e"}},
+		{},
+	}
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	limit := NewLimitOperator(schema, &parser.LimitDesc{Limit: 3}, len(partitionData), []int{0, 1}, 0)
+	var page PageStart
+	for pageIndex, expected := range expectedPages {
+		state := &LimitState{Page: page}
+		var res *evbatch.Batch
+		for i, data := range partitionData {
+			batch := createEventBatch(limitColumnNames, limitColumnTypes, data)
+			var err error
+			res, err = limit.HandleQueryBatch(batch, &testQueryExecCtx{last: true, execState: state})
+			require.NoError(t, err)
+			if i < len(partitionData)-1 {
+				require.Nil(t, res)
+			}
+		}
+		require.NotNil(t, res)
+		require.Equal(t, len(expected), res.RowCount)
+		if len(expected) > 0 {
+			require.Equal(t, expected, convertBatchToAnyArray(res))
+		}
+		next := limit.NextPage(page, res)
+		if pageIndex == len(expectedPages)-1 {
+			require.Nil(t, next)
+		} else {
+			require.NotNil(t, next)
+			// The next page starts after the last row of this page, rather than at an offset
+			require.Equal(t, 0, next.Offset)
+			require.Equal(t, evbatch.EncodeKeyCols(res, res.RowCount-1, []int{0, 1}, nil), next.AfterKey)
+			require.Nil(t, next.ScanFromKey)
+			page = *next
+		}
+	}
+}
+
+func TestLimitNextPageScanFromKey(t *testing.T) {
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	// The first order column is the key of the slab being scanned
+	limit := NewLimitOperator(schema, &parser.LimitDesc{Limit: 2}, 1, []int{0, 1}, 1)
+	batch := createEventBatch(limitColumnNames, limitColumnTypes, [][]any{{int64(0), "a"}, {int64(1), "b"}})
+	next := limit.NextPage(PageStart{}, batch)
+	require.NotNil(t, next)
+	require.Equal(t, evbatch.EncodeKeyCols(batch, 1, []int{0, 1}, nil), next.AfterKey)
+	require.Equal(t, evbatch.EncodeKeyCols(batch, 1, []int{0}, nil), next.ScanFromKey)
+
+	// A page which is not full is the last
+	batch = createEventBatch(limitColumnNames, limitColumnTypes, [][]any{{int64(2), "c"}})
+	require.Nil(t, limit.NextPage(*next, batch))
+}
+
+func TestPartialLimitSkipsRowsBeforePage(t *testing.T) {
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	partial := NewPartialLimitOperator(schema, &parser.LimitDesc{Limit: 2, Offset: 1}, []int{0})
+	receiver := &testQueryReceiverOper{}
+	partial.AddDownStreamOperator(receiver)
+
+	lastRow := createEventBatch(limitColumnNames, limitColumnTypes, [][]any{{int64(1), "b"}})
+	afterKey := evbatch.EncodeKeyCols(lastRow, 0, []int{0}, nil)
+	state := &LimitState{Page: PageStart{AfterKey: afterKey, ScanFromKey: afterKey}}
+	// The scan resumes from the last row of the previous page, which is skipped, and the offset only applies to the
+	// first page
+	batch := createEventBatch(limitColumnNames, limitColumnTypes,
+		[][]any{{int64(1), "b"}, {int64(2), "c"}, {int64(3), "d"}, {int64(4), "e"}})
+	_, err := partial.HandleQueryBatch(batch, &testQueryExecCtx{execState: state})
+	require.NoError(t, err)
+	require.True(t, state.Done())
+	require.Equal(t, []bool{true}, receiver.lasts)
+	require.Equal(t, [][]any{{int64(2), "c"}, {int64(3), "d"}}, convertBatchToAnyArray(receiver.batches[0]))
+}
+
+func TestLimitWithOffsetPreservesOrder(t *testing.T) {
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(limitColumnNames, limitColumnTypes)}
+	// No order cols - e.g. the results have already been sorted
+	limit := NewLimitOperator(schema, &parser.LimitDesc{Limit: 2, Offset: 1}, 1, nil, 0)
+	batch := createEventBatch(limitColumnNames, limitColumnTypes,
+		[][]any{{int64(4), "e"}, {int64(3), "d"}, {int64(2), "c"}, {int64(1), "b"}})
+	res, err := limit.HandleQueryBatch(batch, &testQueryExecCtx{last: true, execState: &LimitState{}})
+	require.NoError(t, err)
+	require.Equal(t, [][]any{{int64(3), "d"}, {int64(2), "c"}}, convertBatchToAnyArray(res))
+}
+
+type testQueryReceiverOper struct {
+	BaseOperator
+	batches []*evbatch.Batch
+	lasts   []bool
+}
+
+func (t *testQueryReceiverOper) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
+	t.batches = append(t.batches, batch)
+	t.lasts = append(t.lasts, execCtx.Last())
+	return nil, nil
+}
+
+func (t *testQueryReceiverOper) HandleStreamBatch(*evbatch.Batch, StreamExecContext) (*evbatch.Batch, error) {
+	panic("not supported")
+}
+
+func (t *testQueryReceiverOper) HandleBarrier(StreamExecContext) error {
+	panic("not supported")
+}
+
+func (t *testQueryReceiverOper) InSchema() *OperatorSchema {
+	return nil
+}
+
+func (t *testQueryReceiverOper) OutSchema() *OperatorSchema {
+	return nil
+}
+
+func (t *testQueryReceiverOper) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (t *testQueryReceiverOper) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
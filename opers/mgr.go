@@ -17,8 +17,10 @@ import (
 	"github.com/spirit-labs/tektite/mem"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/proc"
+	"github.com/spirit-labs/tektite/remoting"
 	"github.com/spirit-labs/tektite/retention"
 	"github.com/spirit-labs/tektite/types"
+	"math"
 	"reflect"
 	"sort"
 	"strings"
@@ -38,7 +40,10 @@ type StreamManager interface {
 		commandID int64) error
 	UndeployStream(deleteStremDesc parser.DeleteStreamDesc, commandID int64) error
 	GetStream(name string) *StreamInfo
+	ResolveTable(tableName string) expr.LookupTable
+	SetClusterMessageHandlers(vbHandler *remoting.TeeBlockingClusterMessageHandler)
 	GetAllStreams() []*StreamInfo
+	HasVersionRetentions() bool
 	GetKafkaEndpoint(name string) *KafkaEndpointInfo
 	GetAllKafkaEndpoints() []*KafkaEndpointInfo
 	RegisterSystemSlab(slabName string, persistorReceiverID int, deleterReceiverID int, slabID int,
@@ -75,6 +80,7 @@ type StreamManagerCtx interface {
 
 type prefixRetention interface {
 	AddPrefixRetention(prefixRetention retention.PrefixRetention)
+	AddVersionRetention(versionRetention retention.PrefixRetention)
 }
 
 type ProcessorManager interface {
@@ -91,6 +97,50 @@ type SlabInfo struct {
 	Schema        *OperatorSchema
 	KeyColIndexes []int
 	Type          SlabType
+	Indexes       []*IndexInfo
+	// VersionsRetention is how long older versions of the rows of a table are kept for, or zero if they are not kept
+	VersionsRetention time.Duration
+	// writes tracks the versions written to the slab on this node, or is nil if writes to the slab are not tracked
+	writes *tableWrites
+}
+
+// TrackWrites starts tracking the versions written to the slab on this node. Writes are only known from the version
+// loaded from knownFrom - for any version before it the slab may have been written, e.g. by another node before a
+// failover.
+func (s *SlabInfo) TrackWrites(knownFrom *atomic.Int64) {
+	s.writes = newTableWrites(knownFrom)
+}
+
+// RecordWrite records that the rows of the slab with the keys, which are the encoded key columns, have been written at
+// the version, if writes to the slab are tracked
+func (s *SlabInfo) RecordWrite(version int64, keys ...[]byte) {
+	s.writes.record(version, keys)
+}
+
+// WrittenAfter returns true if rows of the slab may have been written at a version after the specified version.
+// Writes are only tracked for tables whose rows don't expire - for any other slab it always returns true.
+func (s *SlabInfo) WrittenAfter(version int64) bool {
+	if s.writes == nil {
+		return true
+	}
+	return s.writes.writtenAfter(version)
+}
+
+// KeysWrittenAfter returns the keys, as encoded key columns, of the rows of the slab that may have been written at a
+// version after the specified version. It returns false if they are not known - when writes to the slab are not
+// tracked, or when the keys written at the versions have been dropped as too many rows have been written since.
+func (s *SlabInfo) KeysWrittenAfter(version int64) ([][]byte, bool) {
+	if s.writes == nil {
+		return nil, false
+	}
+	return s.writes.keysWrittenAfter(version)
+}
+
+// IndexInfo describes a secondary index on a table. The index is stored in its own slab, which has the same schema as
+// the table, with the index columns followed by the key columns of the table as its key.
+type IndexInfo struct {
+	IndexCols []string
+	Slab      *SlabInfo
 }
 
 type SlabType int
@@ -115,6 +165,7 @@ func NewStreamManager(messageClientFactory kafka.ClientFactory, stor store,
 		messageClientFactory:   messageClientFactory,
 		stor:                   stor,
 		streams:                map[string]*StreamInfo{},
+		lookupTables:           map[string]*tableLookup{},
 		kafkaEndpoints:         map[string]*KafkaEndpointInfo{},
 		receivers:              map[int]Receiver{},
 		cfg:                    cfg,
@@ -124,8 +175,11 @@ func NewStreamManager(messageClientFactory kafka.ClientFactory, stor store,
 		bridgeFromOpers:        map[*BridgeFromOperator]struct{}{},
 		partitionOperators:     map[*PartitionOperator]struct{}{},
 		lastFlushedVersion:     -1,
+		lastCompletedVersion:   -1,
 		streamMemStore:         treemap.NewWithStringComparator(),
 	}
+	// Writes made before the manager was created aren't known until the first version broadcast
+	mgr.writesKnownFrom.Store(math.MaxInt64)
 	mgr.streamMetaIterProvider = &StreamMetaIteratorProvider{pm: mgr}
 	mgr.receivers[common.DummyReceiverID] = newDummyReceiver()
 	mgr.calculateInjectableReceivers()
@@ -160,6 +214,11 @@ type streamManager struct {
 	streamMemStore         *treemap.Map
 	streamMetaIterProvider *StreamMetaIteratorProvider
 	lastCommandID          int64
+	lookupTablesLock       sync.RWMutex
+	lookupTables           map[string]*tableLookup
+	lookupProcessors       sync.Map // processor ID -> proc.Processor
+	lastCompletedVersion   int64
+	writesKnownFrom        atomic.Int64
 }
 
 func (pm *streamManager) GetIngestedMessageCount() int {
@@ -220,10 +279,33 @@ func validateStream(streamDesc *parser.CreateStreamDesc) error {
 			if i != 0 {
 				return statementErrorAtTokenNamef("", o, "continuation (->) must be at the start of a child stream")
 			}
+		case *parser.DedupDesc:
+			if i == 0 {
+				return statementErrorAtTokenNamef("", o, "'dedup' cannot be the first operator in a stream")
+			}
 		case *parser.FilterDesc:
 			if i == 0 {
 				return statementErrorAtTokenNamef("", o, "'filter' cannot be the first operator in a stream")
 			}
+		case *parser.TopNDesc:
+			if i == 0 {
+				return statementErrorAtTokenNamef("", o, "'topn' cannot be the first operator in a stream")
+			}
+		case *parser.UnnestDesc:
+			if i == 0 {
+				return statementErrorAtTokenNamef("", o, "'unnest' cannot be the first operator in a stream")
+			}
+		case *parser.DecodeJSONDesc:
+			if i == 0 {
+				return statementErrorAtTokenNamef("", o, "'decode_json' cannot be the first operator in a stream")
+			}
+		case *parser.SplitDesc:
+			if i == 0 {
+				return statementErrorAtTokenNamef("", o, "'split' cannot be the first operator in a stream")
+			}
+			if i != lastIndex {
+				return statementErrorAtTokenNamef("", o, "'split' must be the last operator in a stream")
+			}
 		case *parser.JoinDesc:
 			if i != 0 {
 				return statementErrorAtTokenNamef("", o, "'join' must be the first operator in a stream")
@@ -289,6 +371,11 @@ func (pm *streamManager) SetProcessorManager(procMgr ProcessorManager) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 	pm.processorManager = procMgr
+	// Tables are only looked up in partitions whose processor is a leader on this node
+	for _, processor := range procMgr.RegisterListener("lookup-tables", pm.lookupProcessorChange) {
+		pm.lookupProcessors.Store(processor.ID(), processor)
+	}
+	procMgr.RegisterListener("table-writes", pm.writesProcessorChange)
 }
 
 func (pm *streamManager) PrepareForShutdown() {
@@ -375,6 +462,12 @@ func (pm *streamManager) deployStream(streamDesc parser.CreateStreamDesc, receiv
 	slabSliceSeqs := &sliceSeq{seqs: slabSequences}
 	extraSlabInfos := map[string]*SlabInfo{}
 	var userSlab *SlabInfo
+	var rowErrHandler *rowErrorHandler
+	var deadLetterEndpointInfo *KafkaEndpointInfo
+	// The expressions of the stream resolve tables through a resolver which records the tables they look up
+	lookupResolver := &deployTableResolver{pm: pm, tableNames: map[string]struct{}{}}
+	exprFactory := *pm.expressionFactory
+	exprFactory.TableResolver = lookupResolver
 	for _, desc := range streamDesc.OperatorDescs {
 		var oper Operator
 		var err error
@@ -400,20 +493,58 @@ func (pm *streamManager) deployStream(streamDesc parser.CreateStreamDesc, receiv
 			oper, prefixRetentions, userSlab, err = pm.deployKafkaOutOperator(streamDesc.StreamName, op,
 				prevOperator, kafkaEndpointInfo, slabSliceSeqs, extraSlabInfos, prefixRetentions)
 		case *parser.FilterDesc:
-			oper, err = NewFilterOperator(prevOperator.OutSchema(), op.Expr, pm.expressionFactory)
+			var filter *FilterOperator
+			filter, err = NewFilterOperator(prevOperator.OutSchema(), op.Expr, &exprFactory)
+			if err == nil {
+				rowErrHandler, deadLetterEndpointInfo, err = pm.getRowErrorHandler(&streamDesc, op, prevOperator,
+					rowErrHandler, deadLetterEndpointInfo, slabSliceSeqs, extraSlabInfos)
+				filter.rowErrHandler = rowErrHandler
+				oper = filter
+			}
 		case *parser.ProjectDesc:
-			oper, err = NewProjectOperator(prevOperator.OutSchema(), op.Expressions, true, pm.expressionFactory)
+			var project *ProjectOperator
+			project, err = NewProjectOperator(prevOperator.OutSchema(), op.Expressions, true, &exprFactory)
+			if err == nil {
+				rowErrHandler, deadLetterEndpointInfo, err = pm.getRowErrorHandler(&streamDesc, op, prevOperator,
+					rowErrHandler, deadLetterEndpointInfo, slabSliceSeqs, extraSlabInfos)
+				project.rowErrHandler = rowErrHandler
+				oper = project
+			}
+		case *parser.UnnestDesc:
+			var unnest *UnnestOperator
+			unnest, err = NewUnnestOperator(prevOperator.OutSchema(), op, &exprFactory)
+			if err == nil {
+				rowErrHandler, deadLetterEndpointInfo, err = pm.getRowErrorHandler(&streamDesc, op, prevOperator,
+					rowErrHandler, deadLetterEndpointInfo, slabSliceSeqs, extraSlabInfos)
+				unnest.rowErrHandler = rowErrHandler
+				oper = unnest
+			}
+		case *parser.DecodeJSONDesc:
+			var decodeJSON *DecodeJSONOperator
+			decodeJSON, err = NewDecodeJSONOperator(prevOperator.OutSchema(), op)
+			if err == nil {
+				rowErrHandler, deadLetterEndpointInfo, err = pm.getRowErrorHandler(&streamDesc, op, prevOperator,
+					rowErrHandler, deadLetterEndpointInfo, slabSliceSeqs, extraSlabInfos)
+				decodeJSON.rowErrHandler = rowErrHandler
+				oper = decodeJSON
+			}
 		case *parser.PartitionDesc:
 			oper, err = pm.deployPartitionOperator(op, prevOperator, receiverSliceSeqs)
+		case *parser.DedupDesc:
+			oper, prefixRetentions, err = pm.deployDedupOperator(streamDesc.StreamName, op, prevOperator, slabSliceSeqs,
+				extraSlabInfos, prefixRetentions, &exprFactory)
+		case *parser.TopNDesc:
+			oper, err = pm.deployTopNOperator(streamDesc.StreamName, op, prevOperator, slabSliceSeqs, extraSlabInfos,
+				&exprFactory)
 		case *parser.AggregateDesc:
 			oper, prefixRetentions, userSlab, err = pm.deployAggregateOperator(streamDesc.StreamName, op, prevOperator,
-				slabSliceSeqs, receiverSliceSeqs, prefixRetentions, pm.stor, extraSlabInfos)
+				slabSliceSeqs, receiverSliceSeqs, prefixRetentions, pm.stor, extraSlabInfos, &exprFactory)
 		case *parser.StoreStreamDesc:
 			oper, prefixRetentions, userSlab, err = pm.deployStoreStreamOperator(streamDesc.StreamName, op,
 				prevOperator, slabSliceSeqs, extraSlabInfos, prefixRetentions)
 		case *parser.StoreTableDesc:
 			oper, prefixRetentions, userSlab, err = pm.deployStoreTableOperator(streamDesc.StreamName, op, prevOperator,
-				slabSliceSeqs, prefixRetentions)
+				slabSliceSeqs, extraSlabInfos, prefixRetentions)
 		case *parser.BackfillDesc:
 			oper, err = pm.deployBackfillOperator(operators, receiverSliceSeqs, op)
 		case *parser.JoinDesc:
@@ -429,6 +560,9 @@ func (pm *streamManager) deployStream(streamDesc parser.CreateStreamDesc, receiv
 				return statementErrorAtTokenNamef(op.ParentStreamName, op, "unknown parent stream '%s'",
 					op.ParentStreamName)
 			}
+			if err := checkNotSplit(upstreamStream, op); err != nil {
+				return err
+			}
 			upstreamLastOper := upstreamStream.Operators[len(upstreamStream.Operators)-1]
 			oper = &ContinuationOperator{
 				schema: upstreamLastOper.OutSchema(),
@@ -446,6 +580,17 @@ func (pm *streamManager) deployStream(streamDesc parser.CreateStreamDesc, receiv
 			var deferredWiring func(info *StreamInfo)
 			oper, deferredWiring, err = pm.deployUnionOperator(streamDesc.StreamName, op, receiverSliceSeqs)
 			deferredWirings = append(deferredWirings, deferredWiring)
+		case *parser.SplitDesc:
+			var deferredWiring func(info *StreamInfo)
+			var split *SplitOperator
+			split, deferredWiring, err = pm.deploySplitOperator(streamDesc.StreamName, op, prevOperator, &exprFactory)
+			if err == nil {
+				rowErrHandler, deadLetterEndpointInfo, err = pm.getRowErrorHandler(&streamDesc, op, prevOperator,
+					rowErrHandler, deadLetterEndpointInfo, slabSliceSeqs, extraSlabInfos)
+				split.rowErrHandler = rowErrHandler
+				oper = split
+				deferredWirings = append(deferredWirings, deferredWiring)
+			}
 		default:
 			panic("unexpected operator")
 		}
@@ -488,16 +633,25 @@ func (pm *streamManager) deployStream(streamDesc parser.CreateStreamDesc, receiv
 		}
 	}
 	pm.streams[streamDesc.StreamName] = info
+	pm.registerLookupTable(info)
 	if kafkaEndpointInfo != nil {
 		pm.kafkaEndpoints[streamDesc.StreamName] = kafkaEndpointInfo
 	}
+	if deadLetterEndpointInfo != nil {
+		pm.kafkaEndpoints[deadLetterEndpointInfo.Name] = deadLetterEndpointInfo
+	}
 	pm.invalidateCachedInfo()
 	for _, prefixRetention := range prefixRetentions {
 		pm.prefixRetentionService.AddPrefixRetention(prefixRetention)
 	}
+	if info.UserSlab != nil && info.UserSlab.VersionsRetention > 0 {
+		pm.prefixRetentionService.AddVersionRetention(*createPrefixRetention(info.UserSlab.VersionsRetention,
+			info.UserSlab.SlabID))
+	}
 	for _, deferred := range deferredWirings {
 		deferred(info)
 	}
+	pm.wireLookupTables(info, lookupResolver.tableNames)
 	pm.storeStreamMeta(info)
 	pm.lastCommandID = commandID
 	if pm.loaded {
@@ -807,9 +961,114 @@ func (pm *streamManager) deployPartitionOperator(op *parser.PartitionDesc,
 	return po, nil
 }
 
+func (pm *streamManager) deployDedupOperator(streamName string, op *parser.DedupDesc, prevOperator Operator,
+	slabSliceSeqs *sliceSeq, extraSlabInfos map[string]*SlabInfo,
+	prefixRetentions []retention.PrefixRetention, exprFactory *expr.ExpressionFactory) (Operator, []retention.PrefixRetention, error) {
+	if err := checkKeyPartitioning("dedup", op.KeyExprs, op, prevOperator); err != nil {
+		return nil, nil, err
+	}
+	slabID := slabSliceSeqs.GetNextID()
+	extraSlabInfos[fmt.Sprintf("dedup-%s-%d", streamName, slabID)] =
+		&SlabInfo{
+			StreamName: streamName,
+			SlabID:     slabID,
+			Type:       SlabTypeInternal,
+		}
+	dedupOper, err := NewDedupOperator(prevOperator.OutSchema(), op, slabID, exprFactory)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Seen keys are only needed for the dedup window, after that they can be removed
+	prefixRetention := createPrefixRetention(op.Within, slabID)
+	if prefixRetention != nil {
+		prefixRetentions = append(prefixRetentions, *prefixRetention)
+	}
+	return dedupOper, prefixRetentions, nil
+}
+
+func (pm *streamManager) deployTopNOperator(streamName string, op *parser.TopNDesc, prevOperator Operator,
+	slabSliceSeqs *sliceSeq, extraSlabInfos map[string]*SlabInfo, exprFactory *expr.ExpressionFactory) (Operator, error) {
+	slabID := slabSliceSeqs.GetNextID()
+	topN, err := NewTopNOperator(prevOperator.OutSchema(), op, slabID, exprFactory)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPartitioning("topn", op.KeyExprs, op, prevOperator); err != nil {
+		return nil, err
+	}
+	extraSlabInfos[fmt.Sprintf("topn-%s-%d", streamName, slabID)] =
+		&SlabInfo{
+			StreamName: streamName,
+			SlabID:     slabID,
+			Type:       SlabTypeInternal,
+		}
+	return topN, nil
+}
+
+// getRowErrorHandler returns the handler for rows which fail expression evaluation or decoding in a filter, project,
+// unnest, decode_json or split operator. The dead-letter topic is created the first time it is needed. Rows are written to it
+// from the processor that is handling the failed batch, so all operators that can write to it must have the same
+// partition scheme.
+func (pm *streamManager) getRowErrorHandler(streamDesc *parser.CreateStreamDesc, op errMsgAtPositionProvider,
+	prevOperator Operator, handler *rowErrorHandler, deadLetterEndpointInfo *KafkaEndpointInfo, slabSliceSeqs *sliceSeq,
+	extraSlabInfos map[string]*SlabInfo) (*rowErrorHandler, *KafkaEndpointInfo, error) {
+	if streamDesc.OnError == parser.OnErrorSkip {
+		return &rowErrorHandler{onError: parser.OnErrorSkip}, nil, nil
+	}
+	if streamDesc.OnError != parser.OnErrorDeadLetter {
+		// Errors fail the batch
+		return nil, nil, nil
+	}
+	schema := prevOperator.OutSchema()
+	if handler != nil {
+		deadLetterSchema := handler.deadLetter.OutSchema()
+		if deadLetterSchema.Partitions != schema.Partitions || deadLetterSchema.MappingID != schema.MappingID {
+			return nil, nil, statementErrorAtTokenNamef("", op,
+				"when 'on_error' is '%s' all 'filter', 'project', 'unnest', 'decode_json' and 'split' operators in the stream must have the same partition scheme. is there a partition operator between them?",
+				parser.OnErrorDeadLetter)
+		}
+		return handler, deadLetterEndpointInfo, nil
+	}
+	streamName := streamDesc.StreamName
+	slabID := slabSliceSeqs.GetNextID()
+	offsetsSlabID := slabSliceSeqs.GetNextID()
+	extraSlabInfos[fmt.Sprintf("dead-letter-offsets-%s-%d", streamName, offsetsSlabID)] =
+		&SlabInfo{
+			StreamName: streamName,
+			SlabID:     offsetsSlabID,
+			Type:       SlabTypeInternal,
+		}
+	deadLetterSchema := &OperatorSchema{
+		EventSchema:     DeadLetterSchema,
+		PartitionScheme: schema.PartitionScheme,
+	}
+	storeStreamOperator, _, slabInfo, err := pm.setupStoreStreamOperator(streamName, 0, deadLetterSchema, slabID,
+		offsetsSlabID, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	extraSlabInfos[fmt.Sprintf("dead-letter-%s-%d", streamName, slabID)] = slabInfo
+	kafkaOutOper, err := NewKafkaOutOperator(storeStreamOperator, slabID, offsetsSlabID, deadLetterSchema, pm.stor,
+		false)
+	if err != nil {
+		return nil, nil, err
+	}
+	handler = &rowErrorHandler{
+		onError:    parser.OnErrorDeadLetter,
+		deadLetter: kafkaOutOper,
+	}
+	deadLetterEndpointInfo = &KafkaEndpointInfo{
+		Name:        DeadLetterTopicName(streamName),
+		OutEndpoint: kafkaOutOper,
+		Schema:      deadLetterSchema,
+	}
+	return handler, deadLetterEndpointInfo, nil
+}
+
 func (pm *streamManager) deployAggregateOperator(streamName string, op *parser.AggregateDesc,
 	prevOperator Operator, slabSliceSeqs *sliceSeq, receiverSliceSeqs *sliceSeq,
-	prefixRetentions []retention.PrefixRetention, store store, extraSlabInfos map[string]*SlabInfo) (Operator, []retention.PrefixRetention, *SlabInfo, error) {
+	prefixRetentions []retention.PrefixRetention, store store, extraSlabInfos map[string]*SlabInfo,
+	exprFactory *expr.ExpressionFactory) (Operator, []retention.PrefixRetention, *SlabInfo, error) {
 	windowed := op.Size != nil
 	aggStateSlabID := slabSliceSeqs.GetNextID()
 	extraSlabInfos[fmt.Sprintf("aggregate-%s-%d", streamName, aggStateSlabID)] =
@@ -893,7 +1152,7 @@ func (pm *streamManager) deployAggregateOperator(streamName string, op *parser.A
 		}
 	}
 	aggOper, err := NewAggregateOperator(prevOperator.OutSchema(), op, aggStateSlabID, openWindowsSlabID, resultsSlabID,
-		closedWindowReceiverID, size, hop, store, lateness, storeResults, includeWindowCols, pm.expressionFactory)
+		closedWindowReceiverID, size, hop, store, lateness, storeResults, includeWindowCols, exprFactory)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -917,7 +1176,7 @@ func (pm *streamManager) deployAggregateOperator(streamName string, op *parser.A
 }
 
 func (pm *streamManager) deployStoreTableOperator(streamName string, op *parser.StoreTableDesc,
-	prevOperator Operator, slabSliceSeqs *sliceSeq,
+	prevOperator Operator, slabSliceSeqs *sliceSeq, extraSlabInfos map[string]*SlabInfo,
 	prefixRetentions []retention.PrefixRetention) (Operator, []retention.PrefixRetention, *SlabInfo, error) {
 	slabID := slabSliceSeqs.GetNextID()
 	to, err := NewStoreTableOperator(prevOperator.OutSchema(), slabID, pm.stor, op.KeyCols, pm.cfg.NodeID, false, op)
@@ -931,14 +1190,43 @@ func (pm *streamManager) deployStoreTableOperator(streamName string, op *parser.
 		KeyColIndexes: to.outKeyCols,
 		Type:          SlabTypeUserTable,
 	}
+	if op.VersionsRetention != nil {
+		userSlab.VersionsRetention = *op.VersionsRetention
+	}
 	ret := time.Duration(0)
 	if op.Retention != nil {
 		ret = *op.Retention
+	} else {
+		// Rows that expire change the table without being written, so we only track the writes of tables without
+		// a retention
+		userSlab.TrackWrites(&pm.writesKnownFrom)
+		to.writes = userSlab.writes
 	}
 	prefixRetention := createPrefixRetention(ret, slabID)
 	if prefixRetention != nil {
 		prefixRetentions = append(prefixRetentions, *prefixRetention)
 	}
+	for _, indexCols := range op.Indexes {
+		indexSlabID := slabSliceSeqs.GetNextID()
+		indexKeyCols, err := to.addIndex(indexSlabID, indexCols, op)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		indexSlab := &SlabInfo{
+			StreamName:    streamName,
+			SlabID:        indexSlabID,
+			Schema:        to.OutSchema(),
+			KeyColIndexes: indexKeyCols,
+			Type:          SlabTypeInternal,
+		}
+		userSlab.Indexes = append(userSlab.Indexes, &IndexInfo{IndexCols: indexCols, Slab: indexSlab})
+		extraSlabInfos[fmt.Sprintf("index-%s-%s-%d", streamName, strings.Join(indexCols, "-"), indexSlabID)] = indexSlab
+		// Index entries must expire along with the rows of the table
+		prefixRetention := createPrefixRetention(ret, indexSlabID)
+		if prefixRetention != nil {
+			prefixRetentions = append(prefixRetentions, *prefixRetention)
+		}
+	}
 	return to, prefixRetentions, userSlab, nil
 }
 
@@ -971,6 +1259,12 @@ func (pm *streamManager) deployJoinOperator(streamName string, op *parser.JoinDe
 	if !ok {
 		return nil, nil, nil, nil, statementErrorAtTokenNamef(op.RightStream, op, "unknown stream '%s'", op.RightStream)
 	}
+	if err := checkNotSplit(leftStream, op); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if err := checkNotSplit(rightStream, op); err != nil {
+		return nil, nil, nil, nil, err
+	}
 	leftOper := leftStream.Operators[len(leftStream.Operators)-1]
 	rightOper := rightStream.Operators[len(rightStream.Operators)-1]
 	leftSchema := leftOper.OutSchema()
@@ -1077,6 +1371,9 @@ func (pm *streamManager) deployUnionOperator(streamName string, desc *parser.Uni
 		if !ok {
 			return nil, nil, statementErrorAtTokenNamef(feedingStreamName, desc, "unknown stream '%s'", feedingStreamName)
 		}
+		if err := checkNotSplit(stream, desc); err != nil {
+			return nil, nil, err
+		}
 		lastOper := stream.Operators[len(stream.Operators)-1]
 		inputs = append(inputs, lastOper)
 		inputInfos = append(inputInfos, stream)
@@ -1122,6 +1419,65 @@ func (pm *streamManager) deployUnionOperator(streamName string, desc *parser.Uni
 	return uo, deferredWiring, nil
 }
 
+func (pm *streamManager) deploySplitOperator(streamName string, op *parser.SplitDesc,
+	prevOperator Operator, exprFactory *expr.ExpressionFactory) (*SplitOperator, func(info *StreamInfo), error) {
+	for _, branch := range op.Branches {
+		if isReservedIdentifierName(branch.StreamName) {
+			return nil, nil, statementErrorAtTokenNamef(branch.StreamName, op, "stream name '%s' is a reserved name",
+				branch.StreamName)
+		}
+		_, exists := pm.streams[branch.StreamName]
+		if exists || branch.StreamName == streamName {
+			return nil, nil, statementErrorAtTokenNamef(branch.StreamName, op, "stream '%s' already exists",
+				branch.StreamName)
+		}
+	}
+	split, err := NewSplitOperator(prevOperator.OutSchema(), op, streamName, exprFactory)
+	if err != nil {
+		return nil, nil, err
+	}
+	deferredWiring := func(info *StreamInfo) {
+		// Each branch is registered as a stream so that child streams can continue from it
+		for _, branch := range split.branches {
+			branchInfo := &StreamInfo{
+				Operators:             []Operator{branch.oper},
+				StreamDesc:            parser.CreateStreamDesc{StreamName: branch.streamName},
+				ExtraSlabs:            map[string]*SlabInfo{},
+				DownstreamStreamNames: map[string]struct{}{},
+				UpstreamStreamNames:   map[string]Operator{streamName: nil},
+				InSchema:              split.InSchema(),
+				OutSchema:             split.OutSchema(),
+				CommandID:             info.CommandID,
+			}
+			branch.oper.SetStreamInfo(branchInfo)
+			pm.streams[branch.streamName] = branchInfo
+			pm.storeStreamMeta(branchInfo)
+			pm.callChangeListeners(branch.streamName, true)
+		}
+	}
+	return split, deferredWiring, nil
+}
+
+// checkNotSplit returns an error if the stream ends with a split operator - rows are only sent to the branches of the
+// split, so other streams must consume from those.
+func checkNotSplit(stream *StreamInfo, desc errMsgAtPositionProvider) error {
+	if _, ok := stream.Operators[len(stream.Operators)-1].(*SplitOperator); ok {
+		name := stream.StreamDesc.StreamName
+		return statementErrorAtTokenNamef(name, desc,
+			"cannot consume from stream '%s' as it ends with a 'split' - consume from one of its branches instead", name)
+	}
+	return nil
+}
+
+func findSplitOperator(info *StreamInfo) *SplitOperator {
+	for _, oper := range info.Operators {
+		if split, ok := oper.(*SplitOperator); ok {
+			return split
+		}
+	}
+	return nil
+}
+
 func (pm *streamManager) findOffsetsSlabID(oper Operator) (int, error) {
 	switch o := oper.(type) {
 	case *StoreStreamOperator:
@@ -1180,6 +1536,11 @@ func (pm *streamManager) UndeployStream(deleteStreamDesc parser.DeleteStreamDesc
 	if info.Undeploying {
 		return errors.NewTektiteErrorf(errors.InternalError, "stream is already beiung undeployed")
 	}
+	if branch, ok := info.Operators[0].(*SplitBranchOperator); ok {
+		return statementErrorAtTokenNamef(deleteStreamDesc.StreamName, &deleteStreamDesc,
+			"cannot delete stream %s - it is a branch of the 'split' in stream %s - delete that stream instead",
+			deleteStreamDesc.StreamName, branch.splitStreamName)
+	}
 	if len(info.DownstreamStreamNames) > 0 {
 		var dsNames []string
 		for dsName := range info.DownstreamStreamNames {
@@ -1190,6 +1551,22 @@ func (pm *streamManager) UndeployStream(deleteStreamDesc parser.DeleteStreamDesc
 			"cannot delete stream %s - it has child streams: %v - they must be deleted first",
 			deleteStreamDesc.StreamName, dsNames)
 	}
+	split := findSplitOperator(info)
+	if split != nil {
+		for _, branch := range split.branches {
+			branchInfo := pm.streams[branch.streamName]
+			if len(branchInfo.DownstreamStreamNames) > 0 {
+				var dsNames []string
+				for dsName := range branchInfo.DownstreamStreamNames {
+					dsNames = append(dsNames, dsName)
+				}
+				sort.Strings(dsNames)
+				return statementErrorAtTokenNamef(deleteStreamDesc.StreamName, &deleteStreamDesc,
+					"cannot delete stream %s - its split branch %s has child streams: %v - they must be deleted first",
+					deleteStreamDesc.StreamName, branch.streamName, dsNames)
+			}
+		}
+	}
 	info.Undeploying = true
 
 	if pm.loaded {
@@ -1209,6 +1586,7 @@ func (pm *streamManager) UndeployStream(deleteStreamDesc parser.DeleteStreamDesc
 			deleteStreamDesc.StreamName, dsNames)
 	}
 	delete(pm.streams, deleteStreamDesc.StreamName)
+	pm.unregisterLookupTable(deleteStreamDesc.StreamName)
 	for upstreamStreamName, oper := range info.UpstreamStreamNames {
 		upstream, ok := pm.streams[upstreamStreamName]
 		if !ok {
@@ -1220,6 +1598,7 @@ func (pm *streamManager) UndeployStream(deleteStreamDesc parser.DeleteStreamDesc
 		}
 	}
 	delete(pm.kafkaEndpoints, info.StreamDesc.StreamName)
+	delete(pm.kafkaEndpoints, DeadLetterTopicName(info.StreamDesc.StreamName))
 	kafkaInOper, ok := info.Operators[0].(*BridgeFromOperator)
 	if ok {
 		delete(pm.bridgeFromOpers, kafkaInOper)
@@ -1235,6 +1614,13 @@ func (pm *streamManager) UndeployStream(deleteStreamDesc parser.DeleteStreamDesc
 	for _, slabInfo := range info.ExtraSlabs {
 		pm.deleteSlab(slabInfo)
 	}
+	if split != nil {
+		for _, branch := range split.branches {
+			delete(pm.streams, branch.streamName)
+			pm.deleteStreamMeta(branch.streamName)
+			pm.callChangeListeners(branch.streamName, false)
+		}
+	}
 	pm.invalidateCachedInfo()
 	pm.deleteStreamMeta(deleteStreamDesc.StreamName)
 	if pm.loaded {
@@ -1386,6 +1772,18 @@ func (pm *streamManager) GetAllStreams() []*StreamInfo {
 	return allStreams
 }
 
+// HasVersionRetentions returns true if any table keeps older versions of its rows
+func (pm *streamManager) HasVersionRetentions() bool {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	for _, info := range pm.streams {
+		if info.UserSlab != nil && info.UserSlab.VersionsRetention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (pm *streamManager) GetKafkaEndpoint(name string) *KafkaEndpointInfo {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
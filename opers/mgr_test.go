@@ -678,7 +678,12 @@ func createKafkaMessageBytes(partitionID int, offset int, key []byte, value []by
 }
 
 type dummyPrefixRetention struct {
+	versionRetentions []retention.PrefixRetention
 }
 
 func (d *dummyPrefixRetention) AddPrefixRetention(retention.PrefixRetention) {
 }
+
+func (d *dummyPrefixRetention) AddVersionRetention(versionRetention retention.PrefixRetention) {
+	d.versionRetentions = append(d.versionRetentions, versionRetention)
+}
@@ -255,6 +255,13 @@ func LoadColsFromKey(colBuilders []evbatch.ColumnBuilder, keyColumnTypes []types
 			var val types.Timestamp
 			val, off = encoding.KeyDecodeTimestamp(keyBuff, off)
 			colBuilder.(*evbatch.TimestampColBuilder).Append(val)
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			var val any
+			val, off, err = encoding.KeyDecodeNested(keyBuff, off, colType)
+			if err != nil {
+				return err
+			}
+			colBuilder.(*evbatch.NestedColBuilder).Append(val)
 		default:
 			panic("unknown type")
 		}
@@ -263,6 +270,13 @@ func LoadColsFromKey(colBuilders []evbatch.ColumnBuilder, keyColumnTypes []types
 }
 
 func LoadColsFromValue(colBuilders []evbatch.ColumnBuilder, rowColumnTypes []types.ColumnType, rowColIndexes []int, valueBuff []byte) {
+	LoadSelectedColsFromValue(colBuilders, rowColumnTypes, rowColIndexes, valueBuff, nil)
+}
+
+// LoadSelectedColsFromValue is like LoadColsFromValue but only decodes the columns where selected is true - the others
+// are skipped over and loaded as null. If selected is nil all columns are decoded.
+func LoadSelectedColsFromValue(colBuilders []evbatch.ColumnBuilder, rowColumnTypes []types.ColumnType, rowColIndexes []int,
+	valueBuff []byte, selected []bool) {
 	off := 0
 	for i, colIndex := range rowColIndexes {
 		isNull := valueBuff[off] == 0
@@ -273,6 +287,11 @@ func LoadColsFromValue(colBuilders []evbatch.ColumnBuilder, rowColumnTypes []typ
 			continue
 		}
 		colType := rowColumnTypes[i]
+		if selected != nil && !selected[i] {
+			off = skipValueCol(colType, valueBuff, off)
+			colBuilder.AppendNull()
+			continue
+		}
 		switch colType.ID() {
 		case types.ColumnTypeIDInt:
 			var val uint64
@@ -303,8 +322,29 @@ func LoadColsFromValue(colBuilders []evbatch.ColumnBuilder, rowColumnTypes []typ
 			u, off = encoding.ReadUint64FromBufferLE(valueBuff, off)
 			ts := types.NewTimestamp(int64(u))
 			colBuilder.(*evbatch.TimestampColBuilder).Append(ts)
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			var val []byte
+			val, off = encoding.ReadBytesFromBufferLE(valueBuff, off)
+			colBuilder.(*evbatch.NestedColBuilder).AppendEncoded(val)
 		default:
 			panic("unknown type")
 		}
 	}
 }
+
+func skipValueCol(colType types.ColumnType, valueBuff []byte, off int) int {
+	switch colType.ID() {
+	case types.ColumnTypeIDInt, types.ColumnTypeIDFloat, types.ColumnTypeIDTimestamp:
+		return off + 8
+	case types.ColumnTypeIDBool:
+		return off + 1
+	case types.ColumnTypeIDDecimal:
+		return off + 16
+	case types.ColumnTypeIDString, types.ColumnTypeIDBytes, types.ColumnTypeIDArray, types.ColumnTypeIDMap,
+		types.ColumnTypeIDStruct:
+		l, off := encoding.ReadUint32FromBufferLE(valueBuff, off)
+		return off + int(l)
+	default:
+		panic("unknown type")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/conf"
 	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
 	log "github.com/spirit-labs/tektite/logger"
 	"github.com/spirit-labs/tektite/parser"
 	"sync"
@@ -199,3 +200,77 @@ func (p *partitionReceiver) ReceiveBarrier(execCtx StreamExecContext) error {
 func (p *partitionReceiver) RequiresBarriersInjection() bool {
 	return false
 }
+
+// checkKeyPartitioning checks that rows with the same key, for an operator such as 'dedup' or 'topn' which keeps state
+// per key, are always in the same partition. This is the case if the nearest upstream 'partition by' (or the Kafka
+// message key, for a 'kafka in' or 'bridge from') only uses key columns, and those columns are passed through unchanged
+// by the operators in between. With no key, all the rows must be in a single partition.
+func checkKeyPartitioning(operName string, keyExprs []parser.ExprDesc, desc errMsgAtPositionProvider,
+	prevOperator Operator) error {
+	if prevOperator.OutSchema().Partitions == 1 {
+		return nil
+	}
+	// The names, in the output of the current operator, of the key columns
+	keyCols := map[string]struct{}{}
+	for _, keyExpr := range keyExprs {
+		if ident, ok := keyExpr.(*parser.IdentifierExprDesc); ok {
+			keyCols[ident.IdentifierName] = struct{}{}
+		}
+	}
+	allKeyCols := func(colNames ...string) bool {
+		for _, colName := range colNames {
+			if _, ok := keyCols[colName]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	for oper := prevOperator; oper != nil && len(keyCols) > 0; oper = oper.GetParentOperator() {
+		switch op := oper.(type) {
+		case *PartitionOperator:
+			colNames := op.outSchema.EventSchema.ColumnNames()
+			partitionCols := make([]string, len(op.keyIndexes))
+			for i, keyIndex := range op.keyIndexes {
+				partitionCols[i] = colNames[keyIndex]
+			}
+			if allKeyCols(partitionCols...) {
+				return nil
+			}
+			return statementErrorAtTokenNamef("", desc,
+				"'%s' key must include all the columns of the upstream 'partition by' - rows with the same key would not be in the same partition",
+				operName)
+		case *KafkaInOperator, *BridgeFromOperator:
+			if allKeyCols("key") {
+				return nil
+			}
+			return statementErrorAtTokenNamef("", desc,
+				"'%s' key must include the 'key' column, as the stream is partitioned by the Kafka message key - or add a 'partition by' on the %s key before it",
+				operName, operName)
+		case *ProjectOperator:
+			inColNames := op.inSchema.EventSchema.ColumnNames()
+			projected := map[string]struct{}{}
+			for i, colName := range op.outSchema.EventSchema.ColumnNames() {
+				if _, ok := keyCols[colName]; !ok {
+					continue
+				}
+				// Only columns which are passed through unchanged, possibly renamed, keep their values
+				if colExpr, ok := op.expressions[i].(*expr.ColumnExpr); ok {
+					projected[inColNames[colExpr.ColIndex()]] = struct{}{}
+				}
+			}
+			keyCols = projected
+		case *DecodeJSONOperator:
+			// The payload column can be replaced by a decoded column with the same name
+			delete(keyCols, op.inSchema.EventSchema.ColumnNames()[op.payloadIndex])
+		case *FilterOperator, *UnnestOperator, *DedupOperator, *TopNOperator, *ContinuationOperator:
+			// The columns are passed through unchanged
+		default:
+			return statementErrorAtTokenNamef("", desc,
+				"'%s' requires a 'partition by' on the %s key before it - rows with the same key must be in the same partition",
+				operName, operName)
+		}
+	}
+	return statementErrorAtTokenNamef("", desc,
+		"'%s' requires a 'partition by' on the %s key before it - rows with the same key must be in the same partition",
+		operName, operName)
+}
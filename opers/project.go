@@ -11,9 +11,10 @@ import (
 
 type ProjectOperator struct {
 	BaseOperator
-	inSchema    *OperatorSchema
-	outSchema   *OperatorSchema
-	expressions []expr.Expression
+	inSchema      *OperatorSchema
+	outSchema     *OperatorSchema
+	expressions   []expr.Expression
+	rowErrHandler *rowErrorHandler
 }
 
 func NewProjectOperator(inSchema *OperatorSchema, exprDescs []parser.ExprDesc, includeSystemColumns bool,
@@ -87,7 +88,7 @@ func NewProjectOperator(inSchema *OperatorSchema, exprDescs []parser.ExprDesc, i
 }
 
 func (f *ProjectOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
-	outBatch, err := f.processBatch(batch)
+	outBatch, err := f.processBatch(batch, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -95,26 +96,81 @@ func (f *ProjectOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryEx
 }
 
 func (f *ProjectOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
-	outBatch, err := f.processBatch(batch)
+	outBatch, err := f.processBatch(batch, execCtx)
 	if err != nil {
 		return nil, err
 	}
 	return outBatch, f.sendBatchDownStream(outBatch, execCtx)
 }
 
-func (f *ProjectOperator) processBatch(batch *evbatch.Batch) (*evbatch.Batch, error) {
+func (f *ProjectOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
 	defer batch.Release()
+	cols, err := f.evalColumns(batch)
+	if err == nil {
+		return evbatch.NewBatch(f.outSchema.EventSchema, cols...), nil
+	}
+	if f.rowErrHandler == nil {
+		return nil, err
+	}
+	// Find the rows which fail, and project the remaining ones
+	var failed []failedRow
+	colTypes := f.inSchema.EventSchema.ColumnTypes()
+	colBuilders := evbatch.CreateColBuilders(colTypes)
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		var rowErr error
+		for _, e := range f.expressions {
+			if rowErr = evalRow(e, rowIndex, batch); rowErr != nil {
+				break
+			}
+		}
+		if rowErr != nil {
+			failed = append(failed, failedRow{rowIndex: rowIndex, err: rowErr})
+			continue
+		}
+		for colIndex, ft := range colTypes {
+			evbatch.CopyColumnEntry(ft, colBuilders, colIndex, rowIndex, batch)
+		}
+	}
+	if len(failed) == 0 {
+		// Shouldn't happen unless the expressions are not deterministic
+		return nil, err
+	}
+	if err := f.rowErrHandler.handleFailedRows("project", batch, failed, execCtx); err != nil {
+		return nil, err
+	}
+	okBatch := evbatch.NewBatchFromBuilders(f.inSchema.EventSchema, colBuilders...)
+	defer okBatch.Release()
+	cols, err = f.evalColumns(okBatch)
+	if err != nil {
+		return nil, err
+	}
+	return evbatch.NewBatch(f.outSchema.EventSchema, cols...), nil
+}
 
-	fTypes := f.outSchema.EventSchema.ColumnTypes()
-	cols := make([]evbatch.Column, len(fTypes))
+func (f *ProjectOperator) evalColumns(batch *evbatch.Batch) ([]evbatch.Column, error) {
+	cols := make([]evbatch.Column, len(f.expressions))
 	for i, e := range f.expressions {
 		col, err := expr.EvalColumn(e, batch)
 		if err != nil {
+			for _, c := range cols[:i] {
+				c.Release()
+			}
 			return nil, err
 		}
 		cols[i] = col
 	}
-	return evbatch.NewBatch(f.outSchema.EventSchema, cols...), nil
+	return cols, nil
+}
+
+// OutputColumn returns the index of the first output column which is the input column with the specified index, passed
+// through unchanged, or false if there is no such column.
+func (f *ProjectOperator) OutputColumn(inColIndex int) (int, bool) {
+	for i, e := range f.expressions {
+		if colExpr, ok := e.(*expr.ColumnExpr); ok && colExpr.ColIndex() == inColIndex {
+			return i, true
+		}
+	}
+	return 0, false
 }
 
 func (f *ProjectOperator) InSchema() *OperatorSchema {
@@ -0,0 +1,432 @@
+package opers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"sync"
+)
+
+// QueryAggregateOperator computes an aggregation in a query. The aggregation is performed in two phases. The partial
+// phase runs on each node alongside the data - it computes the aggregate state for each group over all the rows of the
+// partition being queried, and sends a single batch of partial results to the node which issued the query. The merge
+// phase runs on the issuing node - it merges the partial results from all the partitions to give the final results.
+type QueryAggregateOperator struct {
+	BaseOperator
+	partial             bool
+	inSchema            *OperatorSchema
+	outSchema           *OperatorSchema
+	expectedLastBatches int64
+	keyExprs            []expr.Expression
+	keyColTypes         []types.ColumnType
+	aggFuncHolders      []aggFuncHolder
+	aggColTypes         []types.ColumnType
+	extraStateAggs      []int
+}
+
+type QueryAggregateState struct {
+	lock           sync.Mutex
+	numLastBatches int64
+	groups         map[string]*queryAggGroup
+	// groupKeys holds the keys in the order the groups were first seen, so results are returned in a stable order
+	groupKeys []string
+	// memoryBytes is an estimate of the memory used by the groups
+	memoryBytes int64
+}
+
+// queryAggGroupOverheadBytes is an estimate of the memory used by a group, besides its key and aggregation state
+const queryAggGroupOverheadBytes = 64
+
+// MemoryBytes returns an estimate of the memory used by the groups of the aggregation
+func (s *QueryAggregateState) MemoryBytes() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.memoryBytes
+}
+
+func (s *QueryAggregateState) setExtraData(group *queryAggGroup, index int, extra []byte) {
+	s.memoryBytes += int64(len(extra) - len(group.extraData[index]))
+	group.extraData[index] = extra
+}
+
+type queryAggGroup struct {
+	keyVals   []any
+	data      []any
+	extraData [][]byte
+}
+
+// NewQueryAggregateOperators creates the operators for the partial and the merge phases of an aggregation in a query.
+// expectedLastBatches is the number of partitions that the merge phase will receive partial results from.
+func NewQueryAggregateOperators(inSchema *OperatorSchema, aggDesc *parser.AggregateDesc, expectedLastBatches int,
+	expressionFactory *expr.ExpressionFactory) (*QueryAggregateOperator, *QueryAggregateOperator, error) {
+	var keyExprs []expr.Expression
+	var keyColTypes []types.ColumnType
+	for _, keyExprDesc := range aggDesc.KeyExprs {
+		e, err := expressionFactory.CreateExpression(keyExprDesc, inSchema.EventSchema)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyExprs = append(keyExprs, e)
+		keyColTypes = append(keyColTypes, e.ResultType())
+	}
+	var aggFuncHolders []aggFuncHolder
+	var aggColNames []string
+	var aggColTypes []types.ColumnType
+	var extraStateAggs []int
+	for i, aggExprDesc := range aggDesc.AggregateExprs {
+		holder, colName, rt, err := createAggFuncHolder(aggExprDesc, aggDesc.AggregateExprStrings[i],
+			inSchema.EventSchema, false, expressionFactory)
+		if err != nil {
+			return nil, nil, err
+		}
+		holder.colIndex = len(keyExprs) + i
+		aggFuncHolders = append(aggFuncHolders, holder)
+		if holder.aggFunc.RequiresExtraData() {
+			extraStateAggs = append(extraStateAggs, i)
+		}
+		aggColNames = append(aggColNames, colName)
+		aggColTypes = append(aggColTypes, rt)
+	}
+	// The results are the key columns followed by the aggregate columns
+	var outNames []string
+	outNames = append(outNames, aggDesc.KeyExprsStrings...)
+	outNames = append(outNames, aggColNames...)
+	var outTypes []types.ColumnType
+	outTypes = append(outTypes, keyColTypes...)
+	outTypes = append(outTypes, aggColTypes...)
+	// The partial results also contain the aggregation state of any aggregate functions that have one, e.g. avg, as
+	// this is needed to merge them
+	partialNames := append([]string{}, outNames...)
+	partialTypes := append([]types.ColumnType{}, outTypes...)
+	for _, index := range extraStateAggs {
+		partialNames = append(partialNames, fmt.Sprintf("%s_state", aggColNames[index]))
+		partialTypes = append(partialTypes, types.ColumnTypeBytes)
+	}
+	partialSchema := inSchema.Copy()
+	partialSchema.EventSchema = evbatch.NewEventSchema(partialNames, partialTypes)
+	outSchema := inSchema.Copy()
+	outSchema.EventSchema = evbatch.NewEventSchema(outNames, outTypes)
+	partial := &QueryAggregateOperator{
+		partial:        true,
+		inSchema:       inSchema,
+		outSchema:      partialSchema,
+		keyExprs:       keyExprs,
+		keyColTypes:    keyColTypes,
+		aggFuncHolders: aggFuncHolders,
+		aggColTypes:    aggColTypes,
+		extraStateAggs: extraStateAggs,
+	}
+	merge := &QueryAggregateOperator{
+		inSchema:            partialSchema,
+		outSchema:           outSchema,
+		expectedLastBatches: int64(expectedLastBatches),
+		keyColTypes:         keyColTypes,
+		aggFuncHolders:      aggFuncHolders,
+		aggColTypes:         aggColTypes,
+		extraStateAggs:      extraStateAggs,
+	}
+	return partial, merge, nil
+}
+
+func (q *QueryAggregateOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
+	state := execCtx.ExecState().(*QueryAggregateState)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if q.partial {
+		if err := q.accumulate(batch, state); err != nil {
+			return nil, err
+		}
+		if !execCtx.Last() {
+			return nil, nil
+		}
+		// We have seen all the rows of the partition so we can send the partial results to be merged
+		return nil, q.SendQueryBatchDownStream(q.createResultsBatch(state), execCtx)
+	}
+	if err := q.merge(batch, state); err != nil {
+		return nil, err
+	}
+	if execCtx.Last() {
+		state.numLastBatches++
+		if state.numLastBatches == q.expectedLastBatches {
+			return q.createResultsBatch(state), nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *QueryAggregateOperator) accumulate(batch *evbatch.Batch, state *QueryAggregateState) error {
+	defer batch.Release()
+	if batch.RowCount == 0 {
+		return nil
+	}
+	keyCols := make([]evbatch.Column, len(q.keyExprs))
+	for i, e := range q.keyExprs {
+		col, err := expr.EvalColumn(e, batch)
+		if err != nil {
+			return err
+		}
+		keyCols[i] = col
+	}
+	aggCols := make([]evbatch.Column, len(q.aggFuncHolders))
+	for i, holder := range q.aggFuncHolders {
+		col, err := expr.EvalColumn(holder.innerExpr, batch)
+		if err != nil {
+			return err
+		}
+		aggCols[i] = col
+	}
+	// First we group the values by key, as the aggregate functions are computed over slices of values
+	grouped := map[*queryAggGroup][]any{}
+	for row := 0; row < batch.RowCount; row++ {
+		group := state.getOrCreateGroup(keyCols, q.keyColTypes, row, len(q.aggFuncHolders))
+		vals, ok := grouped[group]
+		if !ok {
+			vals = make([]any, len(q.aggFuncHolders))
+			grouped[group] = vals
+		}
+		for i, holder := range q.aggFuncHolders {
+			col := aggCols[i]
+			if col.IsNull(row) {
+				continue
+			}
+			vals[i] = groupColData(holder.innerExpr.ResultType().ID(), col, row, vals[i])
+		}
+	}
+	for group, vals := range grouped {
+		for i, holder := range q.aggFuncHolders {
+			res, extra, err := computeAgg(holder.aggFunc, holder.innerExpr.ResultType().ID(), group.data[i],
+				group.extraData[i], vals[i])
+			if err != nil {
+				return err
+			}
+			group.data[i] = res
+			state.setExtraData(group, i, extra)
+		}
+	}
+	return nil
+}
+
+func (q *QueryAggregateOperator) merge(batch *evbatch.Batch, state *QueryAggregateState) error {
+	defer batch.Release()
+	numKeyCols := len(q.keyColTypes)
+	numAggs := len(q.aggFuncHolders)
+	keyCols := batch.Columns[:numKeyCols]
+	for row := 0; row < batch.RowCount; row++ {
+		group := state.getOrCreateGroup(keyCols, q.keyColTypes, row, numAggs)
+		// The aggregation states follow the aggregate columns
+		stateColIndex := numKeyCols + numAggs
+		for i, holder := range q.aggFuncHolders {
+			val := columnValue(batch.Columns[numKeyCols+i], q.aggColTypes[i], row)
+			var extra []byte
+			if holder.aggFunc.RequiresExtraData() {
+				stateCol := batch.GetBytesColumn(stateColIndex)
+				if !stateCol.IsNull(row) {
+					extra = stateCol.Get(row)
+				}
+				stateColIndex++
+			}
+			res, resExtra, err := mergeAgg(holder.aggFunc, q.aggColTypes[i], group.data[i], group.extraData[i], val, extra)
+			if err != nil {
+				return err
+			}
+			group.data[i] = res
+			state.setExtraData(group, i, resExtra)
+		}
+	}
+	return nil
+}
+
+// mergeAgg merges a partial aggregate result, and its aggregation state if it has one, into the current result for the
+// group.
+func mergeAgg(aggFunc AggFunc, resultType types.ColumnType, prev any, prevExtra []byte, val any, extra []byte) (any, []byte, error) {
+	if prev == nil && prevExtra == nil {
+		// First partial result for the group. We copy the state as it can be updated in place when merging.
+		if extra != nil {
+			extra = append([]byte(nil), extra...)
+		}
+		return val, extra, nil
+	}
+	if val == nil && extra == nil {
+		return prev, prevExtra, nil
+	}
+	switch f := aggFunc.(type) {
+	case *CountAggFunc:
+		return prev.(int64) + val.(int64), nil, nil
+	case *AvgAggFunc:
+		tot := math.Float64frombits(binary.LittleEndian.Uint64(extra))
+		count := int(binary.LittleEndian.Uint64(extra[8:]))
+		avg, mergedExtra, err := computeAvg(prevExtra, tot, count)
+		if err != nil {
+			return nil, nil, err
+		}
+		var res any
+		switch resultType.ID() {
+		case types.ColumnTypeIDTimestamp:
+			res = types.NewTimestamp(int64(avg))
+		case types.ColumnTypeIDDecimal:
+			res, err = avgDecimal(avg)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			res = avg
+		}
+		return res, mergedExtra, nil
+	case *ExternalAggFunc:
+		return f.mergeStates(prevExtra, extra)
+	default:
+		// For sum, min and max, merging partial results is the same as aggregating them
+		return computeAgg(aggFunc, resultType.ID(), prev, nil, singleValueSlice(resultType.ID(), val))
+	}
+}
+
+func singleValueSlice(ftID types.ColumnTypeID, val any) any {
+	switch ftID {
+	case types.ColumnTypeIDInt:
+		return []int64{val.(int64)}
+	case types.ColumnTypeIDFloat:
+		return []float64{val.(float64)}
+	case types.ColumnTypeIDBool:
+		return []bool{val.(bool)}
+	case types.ColumnTypeIDDecimal:
+		return []types.Decimal{val.(types.Decimal)}
+	case types.ColumnTypeIDString:
+		return []string{val.(string)}
+	case types.ColumnTypeIDBytes:
+		return [][]byte{val.([]byte)}
+	case types.ColumnTypeIDTimestamp:
+		return []types.Timestamp{val.(types.Timestamp)}
+	default:
+		panic("unknown type")
+	}
+}
+
+func (q *QueryAggregateOperator) createResultsBatch(state *QueryAggregateState) *evbatch.Batch {
+	colTypes := q.outSchema.EventSchema.ColumnTypes()
+	colBuilders := evbatch.CreateColBuilders(colTypes)
+	numKeyCols := len(q.keyColTypes)
+	numAggs := len(q.aggFuncHolders)
+	for _, sKey := range state.groupKeys {
+		group := state.groups[sKey]
+		for i, val := range group.keyVals {
+			appendColumnValue(colBuilders[i], colTypes[i], val)
+		}
+		for i, val := range group.data {
+			appendColumnValue(colBuilders[numKeyCols+i], colTypes[numKeyCols+i], val)
+		}
+		if q.partial {
+			for i, index := range q.extraStateAggs {
+				appendColumnValue(colBuilders[numKeyCols+numAggs+i], types.ColumnTypeBytes, group.extraData[index])
+			}
+		}
+	}
+	return evbatch.NewBatchFromBuilders(q.outSchema.EventSchema, colBuilders...)
+}
+
+func (s *QueryAggregateState) getOrCreateGroup(keyCols []evbatch.Column, keyColTypes []types.ColumnType, row int,
+	numAggs int) *queryAggGroup {
+	keyBuff := make([]byte, 0, 32)
+	for i, col := range keyCols {
+		keyBuff = evbatch.EncodeKeyCol(row, col, keyColTypes[i], keyBuff)
+	}
+	sKey := string(keyBuff)
+	if s.groups == nil {
+		s.groups = map[string]*queryAggGroup{}
+	}
+	group, ok := s.groups[sKey]
+	if !ok {
+		keyVals := make([]any, len(keyCols))
+		for i, col := range keyCols {
+			keyVals[i] = columnValue(col, keyColTypes[i], row)
+		}
+		group = &queryAggGroup{
+			keyVals:   keyVals,
+			data:      make([]any, numAggs),
+			extraData: make([][]byte, numAggs),
+		}
+		s.groups[sKey] = group
+		s.groupKeys = append(s.groupKeys, sKey)
+		s.memoryBytes += int64(len(sKey) + queryAggGroupOverheadBytes + 16*(len(keyCols)+numAggs))
+	}
+	return group
+}
+
+func columnValue(col evbatch.Column, colType types.ColumnType, row int) any {
+	if col.IsNull(row) {
+		return nil
+	}
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		return col.(*evbatch.IntColumn).Get(row)
+	case types.ColumnTypeIDFloat:
+		return col.(*evbatch.FloatColumn).Get(row)
+	case types.ColumnTypeIDBool:
+		return col.(*evbatch.BoolColumn).Get(row)
+	case types.ColumnTypeIDDecimal:
+		return col.(*evbatch.DecimalColumn).Get(row)
+	case types.ColumnTypeIDString:
+		return col.(*evbatch.StringColumn).Get(row)
+	case types.ColumnTypeIDBytes:
+		return col.(*evbatch.BytesColumn).Get(row)
+	case types.ColumnTypeIDTimestamp:
+		return col.(*evbatch.TimestampColumn).Get(row)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return col.(*evbatch.NestedColumn).Get(row)
+	default:
+		panic("unknown type")
+	}
+}
+
+func appendColumnValue(colBuilder evbatch.ColumnBuilder, colType types.ColumnType, val any) {
+	if val == nil {
+		colBuilder.AppendNull()
+		return
+	}
+	switch colType.ID() {
+	case types.ColumnTypeIDInt:
+		colBuilder.(*evbatch.IntColBuilder).Append(val.(int64))
+	case types.ColumnTypeIDFloat:
+		colBuilder.(*evbatch.FloatColBuilder).Append(val.(float64))
+	case types.ColumnTypeIDBool:
+		colBuilder.(*evbatch.BoolColBuilder).Append(val.(bool))
+	case types.ColumnTypeIDDecimal:
+		colBuilder.(*evbatch.DecimalColBuilder).Append(val.(types.Decimal))
+	case types.ColumnTypeIDString:
+		colBuilder.(*evbatch.StringColBuilder).Append(val.(string))
+	case types.ColumnTypeIDBytes:
+		colBuilder.(*evbatch.BytesColBuilder).Append(val.([]byte))
+	case types.ColumnTypeIDTimestamp:
+		colBuilder.(*evbatch.TimestampColBuilder).Append(val.(types.Timestamp))
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		colBuilder.(*evbatch.NestedColBuilder).Append(val)
+	default:
+		panic("unknown type")
+	}
+}
+
+func (q *QueryAggregateOperator) HandleStreamBatch(*evbatch.Batch, StreamExecContext) (*evbatch.Batch, error) {
+	panic("not supported in streams")
+}
+
+func (q *QueryAggregateOperator) HandleBarrier(StreamExecContext) error {
+	panic("not supported in streams")
+}
+
+func (q *QueryAggregateOperator) InSchema() *OperatorSchema {
+	return q.inSchema
+}
+
+func (q *QueryAggregateOperator) OutSchema() *OperatorSchema {
+	return q.outSchema
+}
+
+func (q *QueryAggregateOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (q *QueryAggregateOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,76 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestQueryAggregatePartialAndMerge(t *testing.T) {
+	columnNames := []string{"f0", "f1", "f2", "f3"}
+	columnTypes := []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt, types.ColumnTypeFloat,
+		types.ColumnTypeTimestamp}
+	partitionData := [][][]any{
+		{
+			{"a", int64(1), float64(1.5), types.NewTimestamp(1000)},
+			{"b", int64(2), float64(2.5), types.NewTimestamp(2000)},
+			{"a", int64(3), nil, types.NewTimestamp(3000)},
+		},
+		{
+			{"b", int64(4), float64(4.5), types.NewTimestamp(4000)},
+			{"a", int64(5), float64(5.5), types.NewTimestamp(5000)},
+		},
+		{
+			{"c", int64(6), float64(6.5), types.NewTimestamp(6000)},
+			{"a", int64(7), float64(7.5), types.NewTimestamp(7000)},
+		},
+	}
+	aggDesc := &parser.AggregateDesc{
+		AggregateExprStrings: []string{"count(f1)", "sum(f1)", "min(f2)", "max(f3)", "avg(f2)", "avg(f3)"},
+		KeyExprsStrings:      []string{"f0"},
+	}
+	aggExprs, err := toExprs(aggDesc.AggregateExprStrings...)
+	require.NoError(t, err)
+	aggDesc.AggregateExprs = aggExprs
+	keyExprs, err := toExprs(aggDesc.KeyExprsStrings...)
+	require.NoError(t, err)
+	aggDesc.KeyExprs = keyExprs
+
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(columnNames, columnTypes)}
+	partial, merge, err := NewQueryAggregateOperators(schema, aggDesc, len(partitionData), &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	// The partial results contain the aggregation state for the avg aggregations
+	require.Equal(t, []string{"f0", "count(f1)", "sum(f1)", "min(f2)", "max(f3)", "avg(f2)", "avg(f3)",
+		"avg(f2)_state", "avg(f3)_state"}, partial.OutSchema().EventSchema.ColumnNames())
+	require.Equal(t, []string{"f0", "count(f1)", "sum(f1)", "min(f2)", "max(f3)", "avg(f2)", "avg(f3)"},
+		merge.OutSchema().EventSchema.ColumnNames())
+
+	mergeState := &QueryAggregateState{}
+	var res *evbatch.Batch
+	for i, data := range partitionData {
+		// Each partition has its own partial state and sends its partial results when it receives the last batch
+		partialState := &QueryAggregateState{}
+		for j, row := range data {
+			batch := createEventBatch(columnNames, columnTypes, [][]any{row})
+			out, err := partial.HandleQueryBatch(batch, &testQueryExecCtx{last: j == len(data)-1, execState: partialState})
+			require.NoError(t, err)
+			require.Nil(t, out)
+		}
+		partialResults := partial.createResultsBatch(partialState)
+		res, err = merge.HandleQueryBatch(partialResults, &testQueryExecCtx{last: true, execState: mergeState})
+		require.NoError(t, err)
+		if i < len(partitionData)-1 {
+			require.Nil(t, res)
+		}
+	}
+	require.NotNil(t, res)
+	expected := [][]any{
+		{"a", int64(4), int64(16), float64(1.5), types.NewTimestamp(7000), float64(14.5) / 3, types.NewTimestamp(4000)},
+		{"b", int64(2), int64(6), float64(2.5), types.NewTimestamp(4000), float64(3.5), types.NewTimestamp(3000)},
+		{"c", int64(1), int64(6), float64(6.5), types.NewTimestamp(6000), float64(6.5), types.NewTimestamp(6000)},
+	}
+	require.Equal(t, expected, convertBatchToAnyArray(res))
+}
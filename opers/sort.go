@@ -45,6 +45,9 @@ func NewSortOperator(schema *OperatorSchema, expectedLastBatches int, sortByExpr
 		if err != nil {
 			return nil, err
 		}
+		if types.IsNestedType(e.ResultType()) {
+			return nil, exprDesc.ErrorAtPosition("cannot sort by expression of type %s", e.ResultType().String())
+		}
 		sortExprs[i] = e
 	}
 	return &SortOperator{
@@ -0,0 +1,161 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"sync"
+)
+
+// SplitOperator routes each row to the first branch whose 'when' expression evaluates to true, or to the 'else' branch
+// if there is one and no other branch matches. Rows which don't match any branch are dropped. Each branch is exposed as
+// a stream, with a single SplitBranchOperator, that child streams can continue from. Rows whose 'when' expressions fail
+// to evaluate are handled according to the 'on_error' of the stream, as for a filter.
+type SplitOperator struct {
+	BaseOperator
+	schema        *OperatorSchema
+	branches      []splitBranch
+	rowErrHandler *rowErrorHandler
+}
+
+type splitBranch struct {
+	streamName string
+	// expr is nil for the 'else' branch
+	expr expr.Expression
+	oper *SplitBranchOperator
+}
+
+func NewSplitOperator(schema *OperatorSchema, desc *parser.SplitDesc, streamName string,
+	expressionFactory *expr.ExpressionFactory) (*SplitOperator, error) {
+	split := &SplitOperator{
+		schema: schema,
+	}
+	for _, branchDesc := range desc.Branches {
+		var e expr.Expression
+		if branchDesc.Expr != nil {
+			ok, exprDesc, alias, aliasExprDesc := parser.ExtractAlias(branchDesc.Expr)
+			if !ok || alias != "" {
+				return nil, aliasExprDesc.ErrorAtPosition("split expressions must not have an alias")
+			}
+			var err error
+			e, err = expressionFactory.CreateExpression(exprDesc, schema.EventSchema)
+			if err != nil {
+				return nil, err
+			}
+		}
+		branchOper := &SplitBranchOperator{
+			schema:          schema,
+			splitStreamName: streamName,
+		}
+		branchOper.SetParentOperator(split)
+		// The branch operators are the downstream operators of the split so barriers get forwarded to them
+		split.AddDownStreamOperator(branchOper)
+		split.branches = append(split.branches, splitBranch{
+			streamName: branchDesc.StreamName,
+			expr:       e,
+			oper:       branchOper,
+		})
+	}
+	return split, nil
+}
+
+func (s *SplitOperator) HandleQueryBatch(*evbatch.Batch, QueryExecContext) (*evbatch.Batch, error) {
+	panic("not supported in queries")
+}
+
+func (s *SplitOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	defer batch.Release()
+	colTypes := s.schema.EventSchema.ColumnTypes()
+	branchBuilders := make([][]evbatch.ColumnBuilder, len(s.branches))
+	var failed []failedRow
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		for i, branch := range s.branches {
+			if branch.expr != nil {
+				accept, null, err := branch.expr.EvalBool(rowIndex, batch)
+				if err != nil {
+					if s.rowErrHandler == nil {
+						return nil, err
+					}
+					// The row isn't sent to any branch
+					failed = append(failed, failedRow{rowIndex: rowIndex, err: err})
+					break
+				}
+				if null || !accept {
+					continue
+				}
+			}
+			if branchBuilders[i] == nil {
+				branchBuilders[i] = evbatch.CreateColBuilders(colTypes)
+			}
+			for colIndex, ft := range colTypes {
+				evbatch.CopyColumnEntry(ft, branchBuilders[i], colIndex, rowIndex, batch)
+			}
+			break
+		}
+	}
+	if len(failed) > 0 {
+		if err := s.rowErrHandler.handleFailedRows("split", batch, failed, execCtx); err != nil {
+			return nil, err
+		}
+	}
+	for i, colBuilders := range branchBuilders {
+		if colBuilders == nil {
+			continue
+		}
+		branchBatch := evbatch.NewBatchFromBuilders(s.schema.EventSchema, colBuilders...)
+		if _, err := s.branches[i].oper.HandleStreamBatch(branchBatch, execCtx); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (s *SplitOperator) InSchema() *OperatorSchema {
+	return s.schema
+}
+
+func (s *SplitOperator) OutSchema() *OperatorSchema {
+	return s.schema
+}
+
+func (s *SplitOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (s *SplitOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
+
+// SplitBranchOperator is the only operator of the stream created for a branch of a split. Child streams continue from
+// it.
+type SplitBranchOperator struct {
+	BaseOperator
+	schema          *OperatorSchema
+	splitStreamName string
+}
+
+func (s *SplitBranchOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	return batch, s.sendBatchDownStream(batch, execCtx)
+}
+
+func (s *SplitBranchOperator) HandleQueryBatch(*evbatch.Batch, QueryExecContext) (*evbatch.Batch, error) {
+	panic("not supported in queries")
+}
+
+func (s *SplitBranchOperator) HandleBarrier(execCtx StreamExecContext) error {
+	return s.BaseOperator.HandleBarrier(execCtx)
+}
+
+func (s *SplitBranchOperator) InSchema() *OperatorSchema {
+	return s.schema
+}
+
+func (s *SplitBranchOperator) OutSchema() *OperatorSchema {
+	return s.schema
+}
+
+func (s *SplitBranchOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (s *SplitBranchOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,91 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var splitColumnNames = []string{"event_time", "f0", "f1"}
+var splitColumnTypes = []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+
+func TestSplitWithElse(t *testing.T) {
+	inData := [][]any{
+		{types.NewTimestamp(1000), int64(20), "a"},
+		{types.NewTimestamp(1001), int64(-1), "b"},
+		{types.NewTimestamp(1002), int64(5), "c"},
+		{types.NewTimestamp(1003), int64(11), "d"},
+		{types.NewTimestamp(1004), nil, "e"},
+	}
+	expected := [][][]any{
+		{
+			{types.NewTimestamp(1000), int64(20), "a"},
+			{types.NewTimestamp(1003), int64(11), "d"},
+		},
+		{
+			{types.NewTimestamp(1001), int64(-1), "b"},
+		},
+		{
+			{types.NewTimestamp(1002), int64(5), "c"},
+			{types.NewTimestamp(1004), nil, "e"},
+		},
+	}
+	testSplit(t, []string{"f0 > 10", "f0 < 0", ""}, inData, expected)
+}
+
+func TestSplitFirstMatchWins(t *testing.T) {
+	inData := [][]any{
+		{types.NewTimestamp(1000), int64(20), "a"},
+		{types.NewTimestamp(1001), int64(5), "b"},
+		{types.NewTimestamp(1002), int64(-5), "c"},
+	}
+	expected := [][][]any{
+		{
+			{types.NewTimestamp(1000), int64(20), "a"},
+		},
+		{
+			{types.NewTimestamp(1001), int64(5), "b"},
+		},
+	}
+	// Rows matching no branch are dropped as there is no else
+	testSplit(t, []string{"f0 > 10", "f0 > 0"}, inData, expected)
+}
+
+func testSplit(t *testing.T, branchExprs []string, inData [][]any, expected [][][]any) {
+	desc := &parser.SplitDesc{}
+	for i, branchExpr := range branchExprs {
+		branch := &parser.SplitBranchDesc{StreamName: "branch" + string(rune('a'+i))}
+		if branchExpr != "" {
+			exprs, err := toExprs(branchExpr)
+			require.NoError(t, err)
+			branch.Expr = exprs[0]
+		}
+		desc.Branches = append(desc.Branches, branch)
+	}
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(splitColumnNames, splitColumnTypes)}
+	split, err := NewSplitOperator(schema, desc, "test_stream", &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	require.Equal(t, len(branchExprs), len(split.GetDownStreamOperators()))
+	var sinks []*testSinkOper
+	for _, branch := range split.branches {
+		sink := newTestSinkOper(schema)
+		branch.oper.AddDownStreamOperator(sink)
+		sinks = append(sinks, sink)
+	}
+	batch := createEventBatch(splitColumnNames, splitColumnTypes, inData)
+	execCtx := &testExecCtx{partitionID: 1, processor: &testProcessor{id: 0}}
+	_, err = split.HandleStreamBatch(batch, execCtx)
+	require.NoError(t, err)
+	for i, sink := range sinks {
+		batches := sink.GetPartitionBatches()[1]
+		if i >= len(expected) || len(expected[i]) == 0 {
+			require.Equal(t, 0, len(batches))
+			continue
+		}
+		require.Equal(t, 1, len(batches))
+		require.Equal(t, expected[i], convertBatchToAnyArray(batches[0]))
+	}
+}
@@ -111,7 +111,8 @@ func testStoreOperator(t *testing.T, to *StoreStreamOperator, columnNamesIn []st
 			key := kv.Key[16:]
 			keySlice, _, err := encoding.DecodeKeyToSlice(key, 0, keyTypes)
 			require.NoError(t, err)
-			rowSlice, _ := encoding.DecodeRowToSlice(kv.Value, 0, rowTypes)
+			rowSlice, _, err := encoding.DecodeRowToSlice(kv.Value, 0, rowTypes)
+			require.NoError(t, err)
 			actualOut := make([]any, len(to.OutSchema().EventSchema.ColumnTypes()))
 			for i, keyCol := range to.keyCols {
 				actualOut[keyCol] = keySlice[i]
@@ -1,9 +1,11 @@
 package opers
 
 import (
+	"bytes"
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/encoding"
 	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/types"
 	"sync"
 )
 
@@ -22,6 +24,22 @@ type StoreTableOperator struct {
 	hasKey     bool
 	slabID     uint64
 	hasOffset  bool
+	indexes    []*tableIndex
+	// writes, if not nil, records the versions written to the table
+	writes *tableWrites
+}
+
+// tableIndex is a secondary index on a table. Each row of the table has an entry in the index slab with key
+// [index cols][table key cols] and value [remaining cols], so the row can be looked up by the index columns without
+// going back to the table.
+type tableIndex struct {
+	slabID        uint64
+	inIndexCols   []int
+	outKeyCols    []int
+	inRowCols     []int
+	outRowCols    []int
+	oldIndexCols  []int
+	oldValueTypes []types.ColumnType
 }
 
 func NewStoreTableOperator(schema *OperatorSchema, slabID int, store store, keyCols []string, nodeID int, noCache bool,
@@ -89,12 +107,95 @@ func NewStoreTableOperator(schema *OperatorSchema, slabID int, store store, keyC
 	}, nil
 }
 
+// addIndex adds a secondary index on the specified columns, stored in the slab with the specified id. It returns the
+// key column indexes of the index slab in the out schema of the operator.
+func (s *StoreTableOperator) addIndex(slabID int, indexCols []string, desc errMsgAtPositionProvider) ([]int, error) {
+	if !s.hasKey {
+		return nil, statementErrorAtTokenNamef("index", desc, "cannot create an index on a table with no key columns")
+	}
+	colMap := createInColIndexMap(s.inSchema.EventSchema)
+	keyColSet := make(map[int]struct{}, len(s.inKeyCols))
+	for _, keyCol := range s.inKeyCols {
+		keyColSet[keyCol] = struct{}{}
+	}
+	index := &tableIndex{slabID: uint64(slabID)}
+	indexColSet := make(map[int]struct{}, len(indexCols))
+	for _, indexCol := range indexCols {
+		colIndex, ok := colMap[indexCol]
+		if !ok || indexCol == OffsetColName {
+			return nil, statementErrorAtTokenNamef("index", desc,
+				"cannot use index column '%s' - it is not a known column in the incoming schema", indexCol)
+		}
+		if _, isKey := keyColSet[colIndex]; isKey {
+			return nil, statementErrorAtTokenNamef("index", desc,
+				"cannot use index column '%s' - it is a key column of the table", indexCol)
+		}
+		if _, dup := indexColSet[colIndex]; dup {
+			return nil, statementErrorAtTokenNamef("index", desc,
+				"cannot use index column '%s' - it is specified more than once", indexCol)
+		}
+		indexColSet[colIndex] = struct{}{}
+		index.inIndexCols = append(index.inIndexCols, colIndex)
+		index.outKeyCols = append(index.outKeyCols, s.toOutColIndex(colIndex))
+	}
+	for _, keyCol := range s.outKeyCols {
+		index.outKeyCols = append(index.outKeyCols, keyCol)
+	}
+	for _, rowCol := range s.rowCols {
+		if _, ok := indexColSet[rowCol]; !ok {
+			index.inRowCols = append(index.inRowCols, rowCol)
+			index.outRowCols = append(index.outRowCols, s.toOutColIndex(rowCol))
+		}
+	}
+	// To find the previous index entry for a row, we decode the previous value of the row from the table, so we need
+	// to know the positions of the index columns in the value
+	columnTypes := s.inSchema.EventSchema.ColumnTypes()
+	for i, rowCol := range s.rowCols {
+		index.oldValueTypes = append(index.oldValueTypes, columnTypes[rowCol])
+		if _, ok := indexColSet[rowCol]; ok {
+			index.oldIndexCols = append(index.oldIndexCols, i)
+		}
+	}
+	// The old index cols must be in the same order as the index cols
+	for i, colIndex := range index.inIndexCols {
+		for j, rowCol := range s.rowCols {
+			if rowCol == colIndex {
+				index.oldIndexCols[i] = j
+			}
+		}
+	}
+	s.indexes = append(s.indexes, index)
+	return index.outKeyCols, nil
+}
+
+func (s *StoreTableOperator) toOutColIndex(inColIndex int) int {
+	if s.hasOffset {
+		return inColIndex - 1
+	}
+	return inColIndex
+}
+
+// recordWrites records the keys of the rows of the batch as written at the version, if writes to the table are tracked
+func (s *StoreTableOperator) recordWrites(batch *evbatch.Batch, execCtx StreamExecContext) {
+	if s.writes == nil {
+		return
+	}
+	keys := make([][]byte, batch.RowCount)
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		keys[rowIndex] = evbatch.EncodeKeyCols(batch, rowIndex, s.inKeyCols, nil)
+	}
+	s.writes.record(int64(execCtx.WriteVersion()), keys)
+}
+
 func (s *StoreTableOperator) HandleQueryBatch(*evbatch.Batch, QueryExecContext) (*evbatch.Batch, error) {
 	panic("not supported in queries")
 }
 
 func (s *StoreTableOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
-	s.storeBatchInTable(batch, execCtx)
+	if err := s.storeBatchInTable(batch, execCtx); err != nil {
+		return nil, err
+	}
+	s.recordWrites(batch, execCtx)
 	if s.hasOffset {
 		// remove offset col
 		schema := batch.Schema
@@ -107,7 +208,12 @@ func (s *StoreTableOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx Str
 	return batch, s.sendBatchDownStream(batch, execCtx)
 }
 
-func (s *StoreTableOperator) storeBatchInTable(batch *evbatch.Batch, execCtx StreamExecContext) {
+func (s *StoreTableOperator) storeBatchInTable(batch *evbatch.Batch, execCtx StreamExecContext) error {
+	// The indexes must be updated before the rows are stored, as we need to read the previous values of the rows.
+	// The index entries are written with the same version as the rows, so they are committed along with them.
+	if err := s.storeBatchInIndexes(batch, execCtx); err != nil {
+		return err
+	}
 	if s.hasKey {
 		prefix := createTableKeyPrefix(s.slabID, uint64(execCtx.PartitionID()), 32)
 		storeBatchInTable(batch, s.inKeyCols, s.rowCols, prefix, execCtx, s.nodeID, s.noCache)
@@ -123,6 +229,74 @@ func (s *StoreTableOperator) storeBatchInTable(batch *evbatch.Batch, execCtx Str
 			Value: row,
 		}, s.noCache)
 	}
+	return nil
+}
+
+func (s *StoreTableOperator) storeBatchInIndexes(batch *evbatch.Batch, execCtx StreamExecContext) error {
+	if len(s.indexes) == 0 {
+		return nil
+	}
+	partID := uint64(execCtx.PartitionID())
+	// The prefixes have no spare capacity so each key is encoded into a new buffer
+	tablePrefix := createTableKeyPrefix(s.slabID, partID, 16)
+	for _, index := range s.indexes {
+		indexPrefix := createTableKeyPrefix(index.slabID, partID, 16)
+		// Rows updated earlier in this batch won't be visible from the store yet
+		indexKeysInBatch := map[string][]byte{}
+		for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+			tableKey := evbatch.EncodeKeyCols(batch, rowIndex, s.inKeyCols, tablePrefix)
+			indexKey := evbatch.EncodeKeyCols(batch, rowIndex, index.inIndexCols, indexPrefix)
+			indexKey = append(indexKey, tableKey[16:]...)
+			oldIndexKey, err := s.getOldIndexKey(index, tableKey, indexPrefix, indexKeysInBatch, execCtx)
+			if err != nil {
+				return err
+			}
+			if oldIndexKey != nil && !bytes.Equal(oldIndexKey, indexKey) {
+				// The indexed columns have changed, so we delete the old entry
+				execCtx.StoreEntry(common.KV{
+					Key: encoding.EncodeVersion(common.CopyByteSlice(oldIndexKey), uint64(execCtx.WriteVersion())),
+				}, s.noCache)
+			}
+			indexKeysInBatch[string(tableKey)] = indexKey
+			row := make([]byte, 0, rowInitialBufferSize)
+			row = evbatch.EncodeRowCols(batch, rowIndex, index.inRowCols, row)
+			if len(row) == 0 {
+				// An empty value is a tombstone, so we must store something
+				row = []byte{0}
+			}
+			execCtx.StoreEntry(common.KV{
+				Key:   encoding.EncodeVersion(common.CopyByteSlice(indexKey), uint64(execCtx.WriteVersion())),
+				Value: row,
+			}, s.noCache)
+		}
+	}
+	return nil
+}
+
+func (s *StoreTableOperator) getOldIndexKey(index *tableIndex, tableKey []byte, indexPrefix []byte,
+	indexKeysInBatch map[string][]byte, execCtx StreamExecContext) ([]byte, error) {
+	oldIndexKey, ok := indexKeysInBatch[common.ByteSliceToStringZeroCopy(tableKey)]
+	if ok {
+		return oldIndexKey, nil
+	}
+	oldValue, err := execCtx.Get(tableKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(oldValue) == 0 {
+		return nil, nil
+	}
+	oldColIndexes := make([]int, len(index.oldValueTypes))
+	for i := range oldColIndexes {
+		oldColIndexes[i] = i
+	}
+	colBuilders := evbatch.CreateColBuilders(index.oldValueTypes)
+	LoadColsFromValue(colBuilders, index.oldValueTypes, oldColIndexes, oldValue)
+	oldRow := evbatch.NewBatchFromBuilders(evbatch.NewEventSchema(make([]string, len(index.oldValueTypes)),
+		index.oldValueTypes), colBuilders...)
+	defer oldRow.Release()
+	oldIndexKey = evbatch.EncodeKeyCols(oldRow, 0, index.oldIndexCols, indexPrefix)
+	return append(oldIndexKey, tableKey[16:]...), nil
 }
 
 func createTableKeyPrefix(slabID uint64, partID uint64, cap int) []byte {
@@ -8,6 +8,7 @@ import (
 	"github.com/spirit-labs/tektite/types"
 	"github.com/stretchr/testify/require"
 	"math"
+	"sync/atomic"
 	"testing"
 )
 
@@ -123,7 +124,8 @@ func testTableOperator(t *testing.T, to *StoreTableOperator, columnNamesIn []str
 			key := kv.Key[16:]
 			keySlice, _, err := encoding.DecodeKeyToSlice(key, 0, keyTypes)
 			require.NoError(t, err)
-			rowSlice, _ := encoding.DecodeRowToSlice(kv.Value, 0, rowTypes)
+			rowSlice, _, err := encoding.DecodeRowToSlice(kv.Value, 0, rowTypes)
+			require.NoError(t, err)
 			actualOut := make([]any, len(to.OutSchema().EventSchema.ColumnTypes()))
 			for i, keyCol := range to.outKeyCols {
 				actualOut[keyCol] = keySlice[i]
@@ -137,3 +139,107 @@ func testTableOperator(t *testing.T, to *StoreTableOperator, columnNamesIn []str
 		require.Equal(t, expectedOutData, loadedOutData)
 	}
 }
+
+func TestTableOperatorRecordsWriteVersion(t *testing.T) {
+	fNames := []string{"f0", "f1"}
+	fTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+	to := createTableOperator(t, []string{"f0"}, fNames, fTypes)
+	slab := &SlabInfo{}
+	require.True(t, slab.WrittenAfter(100))
+	knownFrom := &atomic.Int64{}
+	slab.TrackWrites(knownFrom)
+	to.writes = slab.writes
+	require.False(t, slab.WrittenAfter(0))
+
+	for _, version := range []int{10, 12, 11} {
+		batch := createEventBatch(fNames, fTypes, [][]any{{int64(7), "foo"}})
+		_, err := to.HandleStreamBatch(batch, &testExecCtx{version: version, partitionID: 3})
+		require.NoError(t, err)
+		batch.Release()
+	}
+	require.True(t, slab.WrittenAfter(11))
+	require.False(t, slab.WrittenAfter(12))
+	key := evbatch.EncodeKeyCols(createEventBatch(fNames, fTypes, [][]any{{int64(7), "foo"}}), 0, []int{0}, nil)
+	keys, ok := slab.KeysWrittenAfter(10)
+	require.True(t, ok)
+	require.Equal(t, [][]byte{key}, keys)
+	keys, ok = slab.KeysWrittenAfter(12)
+	require.True(t, ok)
+	require.Empty(t, keys)
+}
+
+func TestTableOperatorIndexRemovesStaleEntry(t *testing.T) {
+	fNames := []string{"f0", "f1"}
+	fTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+	to := createTableOperator(t, []string{"f0"}, fNames, fTypes)
+	indexKeyCols, err := to.addIndex(1002, []string{"f1"}, &parser.StoreTableDesc{})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 0}, indexKeyCols)
+
+	// The previous value of the row in the table has f1 = "foo"
+	tableKey := evbatch.EncodeKeyCols(createEventBatch(fNames, fTypes, [][]any{{int64(7), "foo"}}), 0, []int{0},
+		createTableKeyPrefix(1001, 3, 16))
+	ctx := &testExecCtx{
+		version:     10,
+		partitionID: 3,
+		stored: map[string][]byte{
+			string(tableKey): evbatch.EncodeRowCols(createEventBatch(fNames, fTypes, [][]any{{int64(7), "foo"}}), 0, []int{1}, nil),
+		},
+	}
+	batch := createEventBatch(fNames, fTypes, [][]any{{int64(7), "bar"}})
+	defer batch.Release()
+	_, err = to.HandleStreamBatch(batch, ctx)
+	require.NoError(t, err)
+
+	// tombstone for the old index entry, the new index entry, then the table row
+	require.Equal(t, 3, len(ctx.entries))
+	oldIndexKey := evbatch.EncodeKeyCols(createEventBatch(fNames, fTypes, [][]any{{int64(7), "foo"}}), 0, []int{1, 0},
+		createTableKeyPrefix(1002, 3, 16))
+	require.Equal(t, encoding.EncodeVersion(oldIndexKey, 10), ctx.entries[0].Key)
+	require.Equal(t, 0, len(ctx.entries[0].Value))
+	newIndexKey := evbatch.EncodeKeyCols(batch, 0, []int{1, 0}, createTableKeyPrefix(1002, 3, 16))
+	require.Equal(t, encoding.EncodeVersion(newIndexKey, 10), ctx.entries[1].Key)
+	// All the columns are in the key of the index, so a placeholder value is stored
+	require.Equal(t, []byte{0}, ctx.entries[1].Value)
+}
+
+func TestLoadSelectedColsFromValue(t *testing.T) {
+	decType := &types.DecimalType{
+		Precision: types.DefaultDecimalPrecision,
+		Scale:     types.DefaultDecimalScale,
+	}
+	fNames := []string{"int_col", "float_col", "bool_col", "dec_col", "string_col", "bytes_col", "ts_col"}
+	fTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat, types.ColumnTypeBool, decType,
+		types.ColumnTypeString, types.ColumnTypeBytes, types.ColumnTypeTimestamp}
+	data := [][]any{
+		{int64(10), float64(1.0), true, createDecimal(t, "12345.54321"), "str1", []byte("bytes1"), types.NewTimestamp(0)},
+		{nil, float64(1.1), nil, createDecimal(t, "22345.54321"), nil, []byte("bytes2"), types.NewTimestamp(1)},
+	}
+	batch := createEventBatch(fNames, fTypes, data)
+	defer batch.Release()
+	colIndexes := []int{0, 1, 2, 3, 4, 5, 6}
+	// Select every other column, so each type is both skipped and decoded
+	for _, selected := range [][]bool{
+		{true, false, true, false, true, false, true},
+		{false, true, false, true, false, true, false},
+	} {
+		colBuilders := evbatch.CreateColBuilders(fTypes)
+		for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+			value := evbatch.EncodeRowCols(batch, rowIndex, colIndexes, nil)
+			LoadSelectedColsFromValue(colBuilders, fTypes, colIndexes, value, selected)
+		}
+		loaded := evbatch.NewBatchFromBuilders(evbatch.NewEventSchema(fNames, fTypes), colBuilders...)
+		var expected [][]any
+		for _, row := range data {
+			expectedRow := make([]any, len(row))
+			for i, val := range row {
+				if selected[i] {
+					expectedRow[i] = val
+				}
+			}
+			expected = append(expected, expectedRow)
+		}
+		require.Equal(t, expected, convertBatchToAnyArray(loaded))
+		loaded.Release()
+	}
+}
@@ -0,0 +1,214 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/proc"
+	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/remoting"
+	"github.com/spirit-labs/tektite/types"
+	"sync/atomic"
+)
+
+// tableLookup reads the rows of a table from the store for the 'lookup' function. The partition of a row is chosen in
+// the same way as for a 'get' query, so the table must be partitioned by its key columns. Rows are read as of the last
+// completed version, and only from partitions whose processor is a leader on this node, as the store does not have the
+// rows written on other nodes until they are flushed.
+type tableLookup struct {
+	tableName                 string
+	slabID                    uint64
+	partitions                int
+	rawPartitionKey           bool
+	partitionProcessorMapping map[int]int
+	keyColTypes               []types.ColumnType
+	// keyType is used to encode the key values, as the fields of a struct are encoded in the same way as key columns
+	keyType       *types.StructType
+	rowColNames   []string
+	rowColTypes   []types.ColumnType
+	rowColIndexes []int
+	pm            *streamManager
+}
+
+func newTableLookup(slabInfo *SlabInfo, pm *streamManager) *tableLookup {
+	schema := slabInfo.Schema.EventSchema
+	keyColSet := make(map[int]struct{}, len(slabInfo.KeyColIndexes))
+	keyType := &types.StructType{}
+	for _, colIndex := range slabInfo.KeyColIndexes {
+		keyColSet[colIndex] = struct{}{}
+		keyType.FieldNames = append(keyType.FieldNames, schema.ColumnNames()[colIndex])
+		keyType.FieldTypes = append(keyType.FieldTypes, schema.ColumnTypes()[colIndex])
+	}
+	t := &tableLookup{
+		tableName:                 slabInfo.StreamName,
+		slabID:                    uint64(slabInfo.SlabID),
+		partitions:                slabInfo.Schema.Partitions,
+		rawPartitionKey:           slabInfo.Schema.RawPartitionKey,
+		partitionProcessorMapping: slabInfo.Schema.PartitionProcessorMapping,
+		keyColTypes:               keyType.FieldTypes,
+		keyType:                   keyType,
+		pm:                        pm,
+	}
+	for i, colName := range schema.ColumnNames() {
+		if _, ok := keyColSet[i]; !ok {
+			t.rowColNames = append(t.rowColNames, colName)
+			t.rowColTypes = append(t.rowColTypes, schema.ColumnTypes()[i])
+			t.rowColIndexes = append(t.rowColIndexes, len(t.rowColIndexes))
+		}
+	}
+	return t
+}
+
+func (t *tableLookup) KeyColumnTypes() []types.ColumnType {
+	return t.keyColTypes
+}
+
+func (t *tableLookup) ColumnIndex(colName string) (int, types.ColumnType, bool) {
+	for i, rowColName := range t.rowColNames {
+		if rowColName == colName {
+			return i, t.rowColTypes[i], true
+		}
+	}
+	return 0, nil, false
+}
+
+func (t *tableLookup) CreateKey(keyVals []any) ([]byte, int) {
+	keyCols := encoding.KeyEncodeNested(make([]byte, 0, 32), t.keyType, keyVals)
+	var partitionKey []byte
+	if t.rawPartitionKey {
+		// The table receives data from a 'kafka in' or 'bridge from' operator, so the partition is chosen by hashing
+		// the Kafka message key
+		if len(keyVals) == 1 {
+			partitionKey, _ = keyVals[0].([]byte)
+		}
+	} else {
+		partitionKey = keyCols
+	}
+	partID := common.CalcPartition(common.DefaultHash(partitionKey), t.partitions)
+	key := createTableKeyPrefix(t.slabID, uint64(partID), 16+len(keyCols))
+	return append(key, keyCols...), t.partitionProcessorMapping[int(partID)]
+}
+
+func (t *tableLookup) Get(key []byte, processorID int, colIndex int) (any, error) {
+	if !t.pm.isLocalLeaderProcessor(processorID) {
+		return nil, errors.NewTektiteErrorf(errors.Unavailable,
+			"cannot lookup table '%s' - processor %d is not on this node", t.tableName, processorID)
+	}
+	lastCompletedVersion := atomic.LoadInt64(&t.pm.lastCompletedVersion)
+	if lastCompletedVersion < 0 {
+		// No version has completed yet, so there are no rows to read
+		return nil, nil
+	}
+	value, err := t.pm.stor.GetWithMaxVersion(key, uint64(lastCompletedVersion))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	selected := make([]bool, len(t.rowColTypes))
+	selected[colIndex] = true
+	colBuilders := evbatch.CreateColBuilders(t.rowColTypes)
+	LoadSelectedColsFromValue(colBuilders, t.rowColTypes, t.rowColIndexes, value, selected)
+	col := colBuilders[colIndex].Build()
+	defer col.Release()
+	return columnValue(col, t.rowColTypes[colIndex], 0), nil
+}
+
+// ResolveTable returns the table with the specified name so it can be read by the 'lookup' function, or nil if there is
+// no such table.
+func (pm *streamManager) ResolveTable(tableName string) expr.LookupTable {
+	// Expressions are created while a stream is deployed with the stream manager lock held, so the tables are
+	// registered separately, with their own lock
+	pm.lookupTablesLock.RLock()
+	defer pm.lookupTablesLock.RUnlock()
+	table, ok := pm.lookupTables[tableName]
+	if !ok {
+		return nil
+	}
+	return table
+}
+
+func (pm *streamManager) registerLookupTable(info *StreamInfo) {
+	if info.UserSlab == nil || info.UserSlab.Type != SlabTypeUserTable {
+		return
+	}
+	pm.lookupTablesLock.Lock()
+	defer pm.lookupTablesLock.Unlock()
+	pm.lookupTables[info.StreamDesc.StreamName] = newTableLookup(info.UserSlab, pm)
+}
+
+func (pm *streamManager) unregisterLookupTable(streamName string) {
+	pm.lookupTablesLock.Lock()
+	defer pm.lookupTablesLock.Unlock()
+	delete(pm.lookupTables, streamName)
+}
+
+// deployTableResolver resolves the tables looked up by the expressions of a stream which is being deployed, and
+// records their names, so the stream can be registered as a child of the tables.
+type deployTableResolver struct {
+	pm         *streamManager
+	tableNames map[string]struct{}
+}
+
+func (d *deployTableResolver) ResolveTable(tableName string) expr.LookupTable {
+	table := d.pm.ResolveTable(tableName)
+	if table != nil {
+		d.tableNames[tableName] = struct{}{}
+	}
+	return table
+}
+
+// wireLookupTables registers the stream as a child of the tables its expressions look up, so the tables cannot be
+// deleted while it exists.
+func (pm *streamManager) wireLookupTables(info *StreamInfo, tableNames map[string]struct{}) {
+	for tableName := range tableNames {
+		tableInfo, ok := pm.streams[tableName]
+		if !ok || tableName == info.StreamDesc.StreamName {
+			continue
+		}
+		if _, ok := info.UpstreamStreamNames[tableName]; !ok {
+			// There is no operator to remove from the table when the stream is deleted
+			info.UpstreamStreamNames[tableName] = nil
+		}
+		tableInfo.DownstreamStreamNames[info.StreamDesc.StreamName] = struct{}{}
+		pm.storeStreamMeta(tableInfo)
+	}
+}
+
+func (pm *streamManager) lookupProcessorChange(processor proc.Processor, started bool, _ bool) {
+	if started {
+		pm.lookupProcessors.Store(processor.ID(), processor)
+	} else {
+		pm.lookupProcessors.Delete(processor.ID())
+	}
+}
+
+// isLocalLeaderProcessor returns true if the processor is the leader of its group and is on this node.
+func (pm *streamManager) isLocalLeaderProcessor(processorID int) bool {
+	o, ok := pm.lookupProcessors.Load(processorID)
+	if !ok {
+		return false
+	}
+	processor := o.(proc.Processor)
+	return processor.IsLeader() && !processor.IsStopped()
+}
+
+func (pm *streamManager) SetClusterMessageHandlers(vbHandler *remoting.TeeBlockingClusterMessageHandler) {
+	vbHandler.Handlers = append(vbHandler.Handlers, &versionBroadcastHandler{pm: pm})
+}
+
+// versionBroadcastHandler tracks the last completed version, which is the version that tables are looked up as of,
+// and the version from which writes to tables are known.
+type versionBroadcastHandler struct {
+	pm *streamManager
+}
+
+func (v *versionBroadcastHandler) HandleMessage(messageHolder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	msg := messageHolder.Message.(*clustermsgs.VersionsMessage)
+	atomic.StoreInt64(&v.pm.lastCompletedVersion, msg.CompletedVersion)
+	v.pm.setWritesKnownFrom(msg.CurrentVersion)
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+package opers
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/remoting"
+	"github.com/spirit-labs/tektite/testutils"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLookupTableInStream(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+	mgr.expressionFactory.TableResolver = mgr
+	pm.AddActiveProcessor(0)
+
+	columnNames := []string{"f0", "f1", "f2"}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeFloat}
+	deployStream(t, `test_stream1 := (store table by f0)`, mgr, columnNames, columnTypes, true, true)
+
+	// Each row is injected into the partition chosen by hashing its key, as a partition operator would
+	dataIn := [][]any{
+		{int64(10), "foo1", float64(1.1)},
+		{int64(11), "foo2", nil},
+		{int64(12), "foo3", float64(3.1)},
+	}
+	schema := evbatch.NewEventSchema(columnNames, columnTypes)
+	for _, row := range dataIn {
+		batch := createEventBatch(columnNames, columnTypes, [][]any{row})
+		key := evbatch.EncodeKeyCols(batch, 0, []int{0}, nil)
+		partID := int(common.CalcPartition(common.DefaultHash(key), 10))
+		injectBatchWithSchema(t, "test_stream1", partID, 0, [][]any{row}, mgr, pm)
+		batch.Release()
+	}
+	tableSchema := mgr.GetStream("test_stream1").UserSlab.Schema
+	require.Equal(t, schema, tableSchema.EventSchema)
+	// The processors of the partitions of the table must be on this node for them to be looked up
+	for _, processorID := range tableSchema.ProcessorIDs {
+		if processorID != 0 {
+			pm.AddActiveProcessor(processorID)
+		}
+	}
+
+	table := mgr.ResolveTable("test_stream1")
+	require.NotNil(t, table)
+	require.Equal(t, []types.ColumnType{types.ColumnTypeInt}, table.KeyColumnTypes())
+	colIndex, colType, ok := table.ColumnIndex("f1")
+	require.True(t, ok)
+	require.Equal(t, types.ColumnTypeString, colType)
+	key, processorID := table.CreateKey([]any{int64(12)})
+	testutils.WaitUntil(t, func() (bool, error) {
+		value, err := store.Get(key)
+		return value != nil, err
+	})
+
+	// Rows are read as of the last completed version, so they are not seen until the version they were written at
+	// completes
+	val, err := table.Get(key, processorID, colIndex)
+	require.NoError(t, err)
+	require.Nil(t, val)
+	completeVersion := func(version int64) {
+		_, err := (&versionBroadcastHandler{pm: mgr}).HandleMessage(remoting.MessageHolder{
+			Message: &clustermsgs.VersionsMessage{CompletedVersion: version}})
+		require.NoError(t, err)
+	}
+	completeVersion(122)
+	val, err = table.Get(key, processorID, colIndex)
+	require.NoError(t, err)
+	require.Nil(t, val)
+	completeVersion(123)
+	val, err = table.Get(key, processorID, colIndex)
+	require.NoError(t, err)
+	require.Equal(t, "foo3", val)
+
+	deployStream(t, `test_stream2 := (project f0, lookup("test_stream1", f0, "f1") as name, lookup("test_stream1", f0, "f2") as score)`,
+		mgr, []string{"event_time", "f0"}, []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt}, true, true)
+	ts := types.NewTimestamp(1000)
+	injectBatch(t, "test_stream2", 0, 0, [][]any{{ts, int64(10)}, {ts, int64(11)}, {ts, int64(12)}, {ts, int64(13)},
+		{ts, nil}}, mgr, pm)
+	verifyReceivedData(t, "test_stream2", 0, [][]any{
+		{ts, int64(10), "foo1", float64(1.1)},
+		{ts, int64(11), "foo2", nil},
+		{ts, int64(12), "foo3", float64(3.1)},
+		{ts, int64(13), nil, nil},
+		{ts, nil, nil, nil},
+	}, mgr)
+
+	// The stream which looks up the table is a child of it, so the table cannot be deleted first
+	require.Equal(t, map[string]struct{}{"test_stream2": {}}, mgr.GetStream("test_stream1").DownstreamStreamNames)
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream1"), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot delete stream test_stream1 - it has child streams: [test_stream2]")
+
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream2"), 0)
+	require.NoError(t, err)
+	require.Empty(t, mgr.GetStream("test_stream1").DownstreamStreamNames)
+	err = mgr.UndeployStream(createDeleteStreamDesc(t, "test_stream1"), 0)
+	require.NoError(t, err)
+	require.Nil(t, mgr.ResolveTable("test_stream1"))
+}
+
+func TestLookupTableRemoteProcessor(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	pm.SetBatchHandler(mgr)
+	mgr.expressionFactory.TableResolver = mgr
+	pm.AddActiveProcessor(0)
+
+	deployStream(t, `test_stream1 := (store table by f0)`, mgr, []string{"f0", "f1"},
+		[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}, true, true)
+	table := mgr.ResolveTable("test_stream1")
+	colIndex, _, ok := table.ColumnIndex("f1")
+	require.True(t, ok)
+	// Find a key whose partition is owned by a processor other than processor 0
+	var key []byte
+	processorID := 0
+	for i := 0; processorID == 0; i++ {
+		key, processorID = table.CreateKey([]any{int64(i)})
+	}
+	_, err := table.Get(key, processorID, colIndex)
+	require.Error(t, err)
+	require.Equal(t, fmt.Sprintf("cannot lookup table 'test_stream1' - processor %d is not on this node", processorID),
+		err.Error())
+
+	pm.AddActiveProcessor(processorID)
+	val, err := table.Get(key, processorID, colIndex)
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	pm.RemoveActiveProcessor(processorID)
+	_, err = table.Get(key, processorID, colIndex)
+	require.Error(t, err)
+}
+
+func TestLookupUnknownTable(t *testing.T) {
+	mgr, pm, store := createManager()
+	defer stopStore(t, store)
+	defer pm.Close()
+	mgr.expressionFactory.TableResolver = mgr
+
+	columnNames := []string{"event_time", "f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeTimestamp, types.ColumnTypeInt, types.ColumnTypeString}
+	deployStream(t, `test_stream1 := (project f0, f1)`, mgr, columnNames, columnTypes, true, true)
+	// A stream which doesn't store a table cannot be looked up
+	require.Nil(t, mgr.ResolveTable("test_stream1"))
+
+	err := deployStreamReturnError(t, `test_stream2 := (project lookup("test_stream1", f0, "f1") as name)`, mgr,
+		columnNames, columnTypes, true, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'lookup' unknown table 'test_stream1'")
+}
+
+func TestTableLookupColumns(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"f0", "f1", "f2", "f3"},
+		[]types.ColumnType{types.ColumnTypeFloat, types.ColumnTypeString, types.ColumnTypeBool, types.ColumnTypeInt})
+	slabInfo := &SlabInfo{
+		SlabID:        1001,
+		Schema:        &OperatorSchema{EventSchema: schema, PartitionScheme: PartitionScheme{Partitions: 10}},
+		KeyColIndexes: []int{3, 1},
+		Type:          SlabTypeUserTable,
+	}
+	slabInfo.Schema.PartitionScheme = NewPartitionScheme("test", 10, false, 48)
+	table := newTableLookup(slabInfo, nil)
+	require.Equal(t, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}, table.KeyColumnTypes())
+
+	colIndex, colType, ok := table.ColumnIndex("f0")
+	require.True(t, ok)
+	require.Equal(t, 0, colIndex)
+	require.Equal(t, types.ColumnTypeFloat, colType)
+	colIndex, colType, ok = table.ColumnIndex("f2")
+	require.True(t, ok)
+	require.Equal(t, 1, colIndex)
+	require.Equal(t, types.ColumnTypeBool, colType)
+	_, _, ok = table.ColumnIndex("f1")
+	require.False(t, ok)
+	_, _, ok = table.ColumnIndex("foo")
+	require.False(t, ok)
+
+	// The key must be the same as the key the table stores the row with
+	batch := createEventBatch(schema.ColumnNames(), schema.ColumnTypes(), [][]any{{1.1, "foo", true, int64(23)}})
+	defer batch.Release()
+	keyCols := evbatch.EncodeKeyCols(batch, 0, slabInfo.KeyColIndexes, nil)
+	partID := common.CalcPartition(common.DefaultHash(keyCols), 10)
+	expectedKey := append(createTableKeyPrefix(1001, uint64(partID), 16), keyCols...)
+	key, processorID := table.CreateKey([]any{int64(23), "foo"})
+	require.Equal(t, expectedKey, key)
+	require.Equal(t, slabInfo.Schema.PartitionProcessorMapping[int(partID)], processorID)
+}
@@ -0,0 +1,133 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/proc"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxTrackedWriteKeys is the maximum number of keys of written rows that are kept for a table. When there are more, the
+// keys written at the oldest versions are dropped, and the rows written at those versions are no longer known.
+var maxTrackedWriteKeys = 100000
+
+// tableWrites tracks the writes to a table on this node - the highest version written, and the keys of the rows written
+// at each version - so that a query on the table only needs to be re-run when the table has changed, and then only for
+// the rows that have changed. The writes are only known from a node-wide version - when the stream manager is created,
+// or a processor becomes a leader on this node, rows may have been written at earlier versions that were not recorded
+// here, e.g. before a restart or by the previous leader of the processor, so until the next version broadcast any
+// version is treated as written.
+type tableWrites struct {
+	version   atomic.Int64
+	knownFrom *atomic.Int64
+	lock      sync.Mutex
+	// versionKeys holds the keys written at each version, in order of version
+	versionKeys []versionKeys
+	numKeys     int
+	// keysDroppedTo is the highest version whose keys have been dropped
+	keysDroppedTo int64
+}
+
+type versionKeys struct {
+	version int64
+	keys    map[string]struct{}
+}
+
+func newTableWrites(knownFrom *atomic.Int64) *tableWrites {
+	w := &tableWrites{knownFrom: knownFrom, keysDroppedTo: -1}
+	w.version.Store(-1)
+	return w
+}
+
+// record records that the rows with the keys, which are the encoded key columns of the table, have been written at the
+// version
+func (w *tableWrites) record(version int64, keys [][]byte) {
+	if w == nil {
+		return
+	}
+	w.recordKeys(version, keys)
+	for {
+		last := w.version.Load()
+		if version <= last || w.version.CompareAndSwap(last, version) {
+			return
+		}
+	}
+}
+
+func (w *tableWrites) recordKeys(version int64, keys [][]byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if version <= w.keysDroppedTo {
+		return
+	}
+	// Processors write at the same version concurrently, but versions mostly arrive in order, so we search from the
+	// end
+	i := len(w.versionKeys)
+	for i > 0 && w.versionKeys[i-1].version > version {
+		i--
+	}
+	if i == 0 || w.versionKeys[i-1].version != version {
+		w.versionKeys = append(w.versionKeys, versionKeys{})
+		copy(w.versionKeys[i+1:], w.versionKeys[i:])
+		w.versionKeys[i] = versionKeys{version: version, keys: map[string]struct{}{}}
+	} else {
+		i--
+	}
+	vk := w.versionKeys[i].keys
+	for _, key := range keys {
+		if _, exists := vk[string(key)]; !exists {
+			vk[string(key)] = struct{}{}
+			w.numKeys++
+		}
+	}
+	for w.numKeys > maxTrackedWriteKeys && len(w.versionKeys) > 0 {
+		oldest := w.versionKeys[0]
+		w.numKeys -= len(oldest.keys)
+		w.keysDroppedTo = oldest.version
+		w.versionKeys = w.versionKeys[1:]
+	}
+}
+
+func (w *tableWrites) writtenAfter(version int64) bool {
+	return version < w.knownFrom.Load() || w.version.Load() > version
+}
+
+// keysWrittenAfter returns the keys of the rows written after the version, in no particular order, or false if they are
+// not known
+func (w *tableWrites) keysWrittenAfter(version int64) ([][]byte, bool) {
+	if version < w.knownFrom.Load() {
+		return nil, false
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if version < w.keysDroppedTo {
+		return nil, false
+	}
+	start := sort.Search(len(w.versionKeys), func(i int) bool {
+		return w.versionKeys[i].version > version
+	})
+	var keys [][]byte
+	seen := map[string]struct{}{}
+	for _, vk := range w.versionKeys[start:] {
+		for key := range vk.keys {
+			if _, exists := seen[key]; !exists {
+				seen[key] = struct{}{}
+				keys = append(keys, []byte(key))
+			}
+		}
+	}
+	return keys, true
+}
+
+func (pm *streamManager) writesProcessorChange(_ proc.Processor, started bool, _ bool) {
+	if started {
+		pm.writesKnownFrom.Store(math.MaxInt64)
+	}
+}
+
+// setWritesKnownFrom is called with the current version when versions are broadcast. If the writes are not yet known
+// then they are known from the current version, as any write made on this node from then on is recorded.
+func (pm *streamManager) setWritesKnownFrom(currentVersion int64) {
+	pm.writesKnownFrom.CompareAndSwap(math.MaxInt64, currentVersion)
+}
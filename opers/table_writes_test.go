@@ -0,0 +1,115 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/remoting"
+	"github.com/spirit-labs/tektite/tppm"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTableWritesUnknownUntilVersionBroadcast(t *testing.T) {
+	columnNames := []string{"f0", "f1"}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+	broadcastVersion := func(mgr *streamManager, version int64) {
+		_, err := (&versionBroadcastHandler{pm: mgr}).HandleMessage(remoting.MessageHolder{
+			Message: &clustermsgs.VersionsMessage{CurrentVersion: version, CompletedVersion: version - 1}})
+		require.NoError(t, err)
+	}
+	startManager := func() (*streamManager, *tppm.TestProcessorManager) {
+		mgr, pm, store := createManager()
+		t.Cleanup(func() {
+			pm.Close()
+			stopStore(t, store)
+		})
+		pm.SetBatchHandler(mgr)
+		pm.AddActiveProcessor(0)
+		deployStream(t, `test_stream1 := (store table by f0)`, mgr, columnNames, columnTypes, true, true)
+		return mgr, pm
+	}
+
+	mgr, pm := startManager()
+	slab := mgr.GetStream("test_stream1").UserSlab
+	// Nothing is known about the writes made before the manager was created
+	require.True(t, slab.WrittenAfter(1000))
+	broadcastVersion(mgr, 100)
+	require.False(t, slab.WrittenAfter(100))
+	require.True(t, slab.WrittenAfter(99))
+	// The writes are only known from the first broadcast
+	broadcastVersion(mgr, 110)
+	require.True(t, slab.WrittenAfter(99))
+	require.False(t, slab.WrittenAfter(100))
+
+	// Rows are injected at version 123
+	injectBatch(t, "test_stream1", 0, 0, [][]any{{int64(1), "foo"}}, mgr, pm)
+	require.True(t, slab.WrittenAfter(122))
+	require.False(t, slab.WrittenAfter(123))
+
+	// A processor which becomes a leader on this node may have been written by its previous leader
+	pm.AddActiveProcessor(1)
+	require.True(t, slab.WrittenAfter(123))
+	broadcastVersion(mgr, 130)
+	require.False(t, slab.WrittenAfter(130))
+	require.True(t, slab.WrittenAfter(129))
+
+	// A subscriber resuming from a version after the manager is recreated, e.g. after a restart, sees the table as
+	// changed until the writes are known again
+	mgr, _ = startManager()
+	slab = mgr.GetStream("test_stream1").UserSlab
+	require.True(t, slab.WrittenAfter(130))
+	broadcastVersion(mgr, 140)
+	require.True(t, slab.WrittenAfter(130))
+	require.False(t, slab.WrittenAfter(140))
+}
+
+func TestTableWritesKeys(t *testing.T) {
+	defer func(prev int) {
+		maxTrackedWriteKeys = prev
+	}(maxTrackedWriteKeys)
+	maxTrackedWriteKeys = 5
+	knownFrom := &atomic.Int64{}
+	slab := &SlabInfo{}
+	_, ok := slab.KeysWrittenAfter(0)
+	require.False(t, ok)
+	slab.TrackWrites(knownFrom)
+
+	slab.RecordWrite(10, []byte("k1"), []byte("k2"))
+	slab.RecordWrite(12, []byte("k2"))
+	// Versions can be written out of order
+	slab.RecordWrite(11, []byte("k3"))
+	slab.RecordWrite(12, []byte("k4"))
+	keysWrittenAfter := func(version int64) []string {
+		keys, ok := slab.KeysWrittenAfter(version)
+		require.True(t, ok)
+		var sKeys []string
+		for _, key := range keys {
+			sKeys = append(sKeys, string(key))
+		}
+		sort.Strings(sKeys)
+		return sKeys
+	}
+	require.Equal(t, []string{"k1", "k2", "k3", "k4"}, keysWrittenAfter(9))
+	require.Equal(t, []string{"k2", "k3", "k4"}, keysWrittenAfter(10))
+	require.Equal(t, []string{"k2", "k4"}, keysWrittenAfter(11))
+	require.Empty(t, keysWrittenAfter(12))
+
+	// Too many keys are tracked, so the keys of the oldest version are dropped
+	slab.RecordWrite(13, []byte("k5"))
+	_, ok = slab.KeysWrittenAfter(9)
+	require.False(t, ok)
+	require.Equal(t, []string{"k2", "k3", "k4", "k5"}, keysWrittenAfter(10))
+	// A late write to a dropped version is not needed by anyone who can still get the keys
+	slab.RecordWrite(10, []byte("k6"))
+	require.Equal(t, []string{"k2", "k3", "k4", "k5"}, keysWrittenAfter(10))
+	// The written version is still known even though its keys have been dropped
+	require.True(t, slab.WrittenAfter(12))
+
+	// Keys are unknown before the writes are known
+	knownFrom.Store(11)
+	_, ok = slab.KeysWrittenAfter(10)
+	require.False(t, ok)
+	require.Equal(t, []string{"k2", "k4", "k5"}, keysWrittenAfter(11))
+}
@@ -0,0 +1,221 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"sync"
+)
+
+const RankColName = "rank"
+
+// TopNOperator maintains the top N rows for each key, according to the order by expressions. The ranked rows are stored
+// in the topn slab keyed by [key, rank]. For each incoming batch, the operator emits the rows whose rank has changed,
+// along with their new rank. Rows which drop out of the top N are emitted with a null rank, so downstream operators can
+// retract them.
+//
+// Each incoming row is ranked as a separate row - rows are not identified by any of their columns. So if the same
+// entity, e.g. a product, arrives again with a new value of the order by expressions, it is ranked again alongside its
+// earlier row, which keeps its rank until it is pushed out of the top N. Rows with the same key must be in the same
+// partition, so the stream must be partitioned by some or all of the key columns before the operator, or have a single
+// partition if there is no key - this is checked when the stream is deployed.
+type TopNOperator struct {
+	BaseOperator
+	inSchema       *OperatorSchema
+	outSchema      *OperatorSchema
+	rowSchema      *evbatch.EventSchema
+	n              int
+	keyExprs       []expr.Expression
+	sorter         *SortOperator
+	slabID         int
+	hasOffset      bool
+	rowColIndexes  []int
+	rowColumnTypes []types.ColumnType
+}
+
+func NewTopNOperator(inSchema *OperatorSchema, desc *parser.TopNDesc, slabID int,
+	expressionFactory *expr.ExpressionFactory) (*TopNOperator, error) {
+	if desc.N < 1 {
+		return nil, statementErrorAtTokenNamef("n", desc, "invalid value for 'n' - must be > 0")
+	}
+	hasOffset := HasOffsetColumn(inSchema.EventSchema)
+	rowSchema := inSchema.EventSchema
+	if hasOffset {
+		// We remove the offset column as it does not make sense after ranking
+		rowSchema = evbatch.NewEventSchema(inSchema.EventSchema.ColumnNames()[1:], inSchema.EventSchema.ColumnTypes()[1:])
+	}
+	for _, colName := range rowSchema.ColumnNames() {
+		if colName == RankColName {
+			return nil, statementErrorAtTokenNamef("", desc, "cannot use 'topn' - incoming schema already has a column called '%s'",
+				RankColName)
+		}
+	}
+	keyExprs := make([]expr.Expression, len(desc.KeyExprs))
+	for i, keyExprDesc := range desc.KeyExprs {
+		e, err := expressionFactory.CreateExpression(keyExprDesc, rowSchema)
+		if err != nil {
+			return nil, err
+		}
+		keyExprs[i] = e
+	}
+	// We use a stable sort so that rows which are already ranked keep their position when they tie with new rows
+	sorter, err := NewSortOperator(&OperatorSchema{EventSchema: rowSchema}, 1, desc.OrderByExprs, true, expressionFactory)
+	if err != nil {
+		return nil, err
+	}
+	outNames := append(append([]string{}, rowSchema.ColumnNames()...), RankColName)
+	outTypes := append(append([]types.ColumnType{}, rowSchema.ColumnTypes()...), types.ColumnTypeInt)
+	outSchema := inSchema.Copy()
+	outSchema.EventSchema = evbatch.NewEventSchema(outNames, outTypes)
+	rowColIndexes := make([]int, len(rowSchema.ColumnTypes()))
+	for i := range rowColIndexes {
+		rowColIndexes[i] = i
+	}
+	return &TopNOperator{
+		inSchema:       inSchema,
+		outSchema:      outSchema,
+		rowSchema:      rowSchema,
+		n:              desc.N,
+		keyExprs:       keyExprs,
+		sorter:         sorter,
+		slabID:         slabID,
+		hasOffset:      hasOffset,
+		rowColIndexes:  rowColIndexes,
+		rowColumnTypes: rowSchema.ColumnTypes(),
+	}, nil
+}
+
+func (t *TopNOperator) HandleQueryBatch(*evbatch.Batch, QueryExecContext) (*evbatch.Batch, error) {
+	panic("not supported in queries")
+}
+
+func (t *TopNOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	outBatch, err := t.processBatch(batch, execCtx)
+	if err != nil {
+		return nil, err
+	}
+	if outBatch.RowCount > 0 {
+		return outBatch, t.sendBatchDownStream(outBatch, execCtx)
+	}
+	return outBatch, nil
+}
+
+func (t *TopNOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	defer batch.Release()
+	rowBatch := batch
+	if t.hasOffset {
+		rowBatch = evbatch.NewBatch(t.rowSchema, batch.Columns[1:]...)
+	}
+	keyCols := make([]evbatch.Column, len(t.keyExprs))
+	for i, keyExpr := range t.keyExprs {
+		col, err := expr.EvalColumn(keyExpr, rowBatch)
+		if err != nil {
+			return nil, err
+		}
+		keyCols[i] = col
+	}
+	// Group the rows by key, maintaining the order in which the keys were first seen
+	var keys []string
+	grouped := map[string][]int{}
+	for rowIndex := 0; rowIndex < rowBatch.RowCount; rowIndex++ {
+		keyBuff := make([]byte, 0, initialKeyBufferSize)
+		for i, keyExpr := range t.keyExprs {
+			keyBuff = evbatch.EncodeKeyCol(rowIndex, keyCols[i], keyExpr.ResultType(), keyBuff)
+		}
+		sKey := common.ByteSliceToStringZeroCopy(keyBuff)
+		rows, ok := grouped[sKey]
+		if !ok {
+			keys = append(keys, sKey)
+		}
+		grouped[sKey] = append(rows, rowIndex)
+	}
+	outBuilders := evbatch.CreateColBuilders(t.outSchema.EventSchema.ColumnTypes())
+	for _, key := range keys {
+		if err := t.rankGroup(key, grouped[key], rowBatch, outBuilders, execCtx); err != nil {
+			return nil, err
+		}
+	}
+	return evbatch.NewBatchFromBuilders(t.outSchema.EventSchema, outBuilders...), nil
+}
+
+func (t *TopNOperator) rankGroup(key string, rows []int, rowBatch *evbatch.Batch, outBuilders []evbatch.ColumnBuilder,
+	execCtx StreamExecContext) error {
+	prefix := encoding.EncodeEntryPrefix(uint64(t.slabID), uint64(execCtx.PartitionID()), 32+len(key))
+	prefix = append(prefix, key...)
+	// Build a batch containing the currently ranked rows, in rank order, followed by the new rows
+	candidateBuilders := evbatch.CreateColBuilders(t.rowColumnTypes)
+	numRanked := 0
+	for rank := 1; rank <= t.n; rank++ {
+		v, err := execCtx.Get(encoding.KeyEncodeInt(common.CopyByteSlice(prefix), int64(rank)))
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			break
+		}
+		LoadColsFromValue(candidateBuilders, t.rowColumnTypes, t.rowColIndexes, v)
+		numRanked++
+	}
+	for _, rowIndex := range rows {
+		for colIndex, colType := range t.rowColumnTypes {
+			evbatch.CopyColumnEntry(colType, candidateBuilders, colIndex, rowIndex, rowBatch)
+		}
+	}
+	candidates := evbatch.NewBatchFromBuilders(t.rowSchema, candidateBuilders...)
+	defer candidates.Release()
+	index := make([]int, candidates.RowCount)
+	for i := range index {
+		index[i] = i
+	}
+	index, err := t.sorter.sortIndex(index, candidates)
+	if err != nil {
+		return err
+	}
+	if len(index) > t.n {
+		for _, candidateIndex := range index[t.n:] {
+			if candidateIndex < numRanked {
+				// The row was ranked before, and has been evicted
+				for colIndex, colType := range t.rowColumnTypes {
+					evbatch.CopyColumnEntry(colType, outBuilders, colIndex, candidateIndex, candidates)
+				}
+				outBuilders[len(outBuilders)-1].AppendNull()
+			}
+		}
+		index = index[:t.n]
+	}
+	for pos, candidateIndex := range index {
+		if candidateIndex == pos && candidateIndex < numRanked {
+			// Rank is unchanged
+			continue
+		}
+		rank := int64(pos + 1)
+		rankKey := encoding.KeyEncodeInt(common.CopyByteSlice(prefix), rank)
+		rankKey = encoding.EncodeVersion(rankKey, uint64(execCtx.WriteVersion()))
+		rowBuff := make([]byte, 0, rowInitialBufferSize)
+		rowBuff = evbatch.EncodeRowCols(candidates, candidateIndex, t.rowColIndexes, rowBuff)
+		execCtx.StoreEntry(common.KV{Key: rankKey, Value: rowBuff}, false)
+		for colIndex, colType := range t.rowColumnTypes {
+			evbatch.CopyColumnEntry(colType, outBuilders, colIndex, candidateIndex, candidates)
+		}
+		outBuilders[len(outBuilders)-1].(*evbatch.IntColBuilder).Append(rank)
+	}
+	return nil
+}
+
+func (t *TopNOperator) InSchema() *OperatorSchema {
+	return t.inSchema
+}
+
+func (t *TopNOperator) OutSchema() *OperatorSchema {
+	return t.outSchema
+}
+
+func (t *TopNOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (t *TopNOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,146 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var topNColumnNames = []string{"offset", "event_time", "region", "product", "amount"}
+var topNColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeTimestamp, types.ColumnTypeString,
+	types.ColumnTypeString, types.ColumnTypeInt}
+
+func TestTopNSingleBatch(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "uk", "p1", int64(10)},
+		{int64(1), types.NewTimestamp(1001), "us", "p2", int64(30)},
+		{int64(2), types.NewTimestamp(1002), "uk", "p3", int64(50)},
+		{int64(3), types.NewTimestamp(1003), "uk", "p4", int64(20)},
+		{int64(4), types.NewTimestamp(1004), "us", "p5", int64(5)},
+		{int64(5), types.NewTimestamp(1005), "uk", "p6", int64(5)},
+	}
+	// The out schema has no offset and has the rank column appended
+	expectedOut := [][]any{
+		{types.NewTimestamp(1002), "uk", "p3", int64(50), int64(1)},
+		{types.NewTimestamp(1003), "uk", "p4", int64(20), int64(2)},
+		{types.NewTimestamp(1001), "us", "p2", int64(30), int64(1)},
+		{types.NewTimestamp(1004), "us", "p5", int64(5), int64(2)},
+	}
+	testTopN(t, 2, []string{"region"}, []string{"amount desc"}, inData, expectedOut, nil)
+}
+
+func TestTopNNoKey(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "uk", "p1", int64(10)},
+		{int64(1), types.NewTimestamp(1001), "us", "p2", int64(30)},
+		{int64(2), types.NewTimestamp(1002), "uk", "p3", int64(50)},
+	}
+	expectedOut := [][]any{
+		{types.NewTimestamp(1000), "uk", "p1", int64(10), int64(1)},
+		{types.NewTimestamp(1001), "us", "p2", int64(30), int64(2)},
+	}
+	testTopN(t, 2, nil, []string{"amount"}, inData, expectedOut, nil)
+}
+
+func TestTopNEmitsOnlyChangedRanks(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "uk", "p1", int64(10)},
+		{int64(1), types.NewTimestamp(1001), "uk", "p2", int64(30)},
+		{int64(2), types.NewTimestamp(1002), "uk", "p3", int64(50)},
+	}
+	expectedOut := [][]any{
+		{types.NewTimestamp(1002), "uk", "p3", int64(50), int64(1)},
+		{types.NewTimestamp(1001), "uk", "p2", int64(30), int64(2)},
+		{types.NewTimestamp(1000), "uk", "p1", int64(10), int64(3)},
+	}
+	stored := testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, expectedOut, nil)
+
+	// Doesn't make it into the top 3
+	inData = [][]any{
+		{int64(3), types.NewTimestamp(1003), "uk", "p4", int64(5)},
+	}
+	stored = testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, nil, stored)
+
+	// Ties with rank 2 - the existing row keeps its rank, and the row ranked 3 is evicted and retracted
+	inData = [][]any{
+		{int64(4), types.NewTimestamp(1004), "uk", "p5", int64(30)},
+	}
+	expectedOut = [][]any{
+		{types.NewTimestamp(1000), "uk", "p1", int64(10), nil},
+		{types.NewTimestamp(1004), "uk", "p5", int64(30), int64(3)},
+	}
+	stored = testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, expectedOut, stored)
+
+	// New leader - all ranks shift down, and the row ranked 3 is evicted
+	inData = [][]any{
+		{int64(5), types.NewTimestamp(1005), "uk", "p6", int64(100)},
+	}
+	expectedOut = [][]any{
+		{types.NewTimestamp(1004), "uk", "p5", int64(30), nil},
+		{types.NewTimestamp(1005), "uk", "p6", int64(100), int64(1)},
+		{types.NewTimestamp(1002), "uk", "p3", int64(50), int64(2)},
+		{types.NewTimestamp(1001), "uk", "p2", int64(30), int64(3)},
+	}
+	testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, expectedOut, stored)
+}
+
+func TestTopNRanksEachRowSeparately(t *testing.T) {
+	inData := [][]any{
+		{int64(0), types.NewTimestamp(1000), "uk", "p1", int64(10)},
+		{int64(1), types.NewTimestamp(1001), "uk", "p2", int64(30)},
+	}
+	expectedOut := [][]any{
+		{types.NewTimestamp(1001), "uk", "p2", int64(30), int64(1)},
+		{types.NewTimestamp(1000), "uk", "p1", int64(10), int64(2)},
+	}
+	stored := testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, expectedOut, nil)
+
+	// The same product arrives again with a new amount - it is ranked as another row, and its earlier row keeps a rank
+	inData = [][]any{
+		{int64(2), types.NewTimestamp(1002), "uk", "p1", int64(50)},
+	}
+	expectedOut = [][]any{
+		{types.NewTimestamp(1002), "uk", "p1", int64(50), int64(1)},
+		{types.NewTimestamp(1001), "uk", "p2", int64(30), int64(2)},
+		{types.NewTimestamp(1000), "uk", "p1", int64(10), int64(3)},
+	}
+	testTopN(t, 3, []string{"region"}, []string{"amount desc"}, inData, expectedOut, stored)
+}
+
+func testTopN(t *testing.T, n int, keyExprStrs []string, orderByExprStrs []string, inData [][]any, expectedOut [][]any,
+	stored []common.KV) []common.KV {
+	keyExprs, err := toExprs(keyExprStrs...)
+	require.NoError(t, err)
+	orderByExprs, err := toExprs(orderByExprStrs...)
+	require.NoError(t, err)
+	desc := &parser.TopNDesc{
+		N:               n,
+		KeyExprs:        keyExprs,
+		KeyExprsStrings: keyExprStrs,
+		OrderByExprs:    orderByExprs,
+	}
+	inSchema := evbatch.NewEventSchema(topNColumnNames, topNColumnTypes)
+	topN, err := NewTopNOperator(&OperatorSchema{EventSchema: inSchema}, desc, 1001, &expr.ExpressionFactory{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"event_time", "region", "product", "amount", "rank"}, topN.OutSchema().EventSchema.ColumnNames())
+
+	storedMap := map[string][]byte{}
+	for _, kv := range stored {
+		// We remove the version as we don't look up based on that
+		storedMap[string(kv.Key[:len(kv.Key)-8])] = kv.Value
+	}
+	ctx := &testExecCtx{
+		version:     1234,
+		partitionID: 23,
+		stored:      storedMap,
+	}
+	batch := createEventBatch(topNColumnNames, topNColumnTypes, inData)
+	out, err := topN.HandleStreamBatch(batch, ctx)
+	require.NoError(t, err)
+	require.Equal(t, expectedOut, convertBatchToAnyArray(out))
+	return append(stored, ctx.entries...)
+}
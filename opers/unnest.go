@@ -0,0 +1,192 @@
+package opers
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/tidwall/gjson"
+	"sync"
+)
+
+// UnnestOperator emits a row for each element of an array valued expression, with the other columns of the input row
+// repeated and the element in an extra item column. The expression can either be of an array type, or be a string
+// containing a JSON array, e.g. the result of json_raw, in which case the item is the raw JSON of the element. When the
+// array is empty or null no rows are emitted, unless outer is true, in which case a single row with a null item is
+// emitted.
+type UnnestOperator struct {
+	BaseOperator
+	inSchema      *OperatorSchema
+	outSchema     *OperatorSchema
+	expr          expr.Expression
+	itemType      types.ColumnType
+	isJSON        bool
+	outer         bool
+	removeOffset  bool
+	rowErrHandler *rowErrorHandler
+}
+
+func NewUnnestOperator(inSchema *OperatorSchema, desc *parser.UnnestDesc,
+	expressionFactory *expr.ExpressionFactory) (*UnnestOperator, error) {
+	e, err := expressionFactory.CreateExpression(desc.Expr, inSchema.EventSchema)
+	if err != nil {
+		return nil, err
+	}
+	var itemType types.ColumnType
+	var isJSON bool
+	switch t := e.ResultType().(type) {
+	case *types.ArrayType:
+		itemType = t.ElemType
+	default:
+		if t.ID() != types.ColumnTypeIDString {
+			return nil, desc.Expr.ErrorAtPosition("unnest expression must be of type array, or a string containing a JSON array - it is %s",
+				t.String())
+		}
+		itemType = types.ColumnTypeString
+		isJSON = true
+	}
+	if isReservedIdentifierName(desc.ItemName) {
+		return nil, statementErrorAtTokenNamef(desc.ItemName, desc, "cannot use alias '%s', it is a reserved name",
+			desc.ItemName)
+	}
+	removeOffset := HasOffsetColumn(inSchema.EventSchema)
+	rowSchema := inSchema.EventSchema
+	if removeOffset {
+		// We remove the offset column as it does not make sense when there can be many rows for each offset
+		rowSchema = evbatch.NewEventSchema(inSchema.EventSchema.ColumnNames()[1:], inSchema.EventSchema.ColumnTypes()[1:])
+	}
+	for _, colName := range rowSchema.ColumnNames() {
+		if colName == desc.ItemName {
+			return nil, statementErrorAtTokenNamef(desc.ItemName, desc,
+				"cannot use alias '%s', the incoming schema already has a column with that name", desc.ItemName)
+		}
+	}
+	outNames := append(append([]string{}, rowSchema.ColumnNames()...), desc.ItemName)
+	outTypes := append(append([]types.ColumnType{}, rowSchema.ColumnTypes()...), itemType)
+	outSchema := inSchema.Copy()
+	outSchema.EventSchema = evbatch.NewEventSchema(outNames, outTypes)
+	return &UnnestOperator{
+		inSchema:     inSchema,
+		outSchema:    outSchema,
+		expr:         e,
+		itemType:     itemType,
+		isJSON:       isJSON,
+		outer:        desc.Outer,
+		removeOffset: removeOffset,
+	}, nil
+}
+
+func (u *UnnestOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx QueryExecContext) (*evbatch.Batch, error) {
+	outBatch, err := u.processBatch(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return outBatch, u.SendQueryBatchDownStream(outBatch, execCtx)
+}
+
+func (u *UnnestOperator) HandleStreamBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	outBatch, err := u.processBatch(batch, execCtx)
+	if err != nil {
+		return nil, err
+	}
+	if outBatch.RowCount > 0 {
+		return outBatch, u.sendBatchDownStream(outBatch, execCtx)
+	}
+	return outBatch, nil
+}
+
+func (u *UnnestOperator) processBatch(batch *evbatch.Batch, execCtx StreamExecContext) (*evbatch.Batch, error) {
+	defer batch.Release()
+	outColTypes := u.outSchema.EventSchema.ColumnTypes()
+	colBuilders := evbatch.CreateColBuilders(outColTypes)
+	numRowCols := len(outColTypes) - 1
+	itemBuilder := colBuilders[numRowCols]
+	firstInCol := 0
+	if u.removeOffset {
+		firstInCol = 1
+	}
+	var failed []failedRow
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		items, err := u.evalItems(rowIndex, batch)
+		if err != nil {
+			if u.rowErrHandler == nil {
+				return nil, err
+			}
+			failed = append(failed, failedRow{rowIndex: rowIndex, err: err})
+			continue
+		}
+		numOut := len(items)
+		if numOut == 0 {
+			if !u.outer {
+				continue
+			}
+			numOut = 1
+		}
+		for i := 0; i < numOut; i++ {
+			for colIndex := 0; colIndex < numRowCols; colIndex++ {
+				evbatch.CopyColumnEntryWithCol(outColTypes[colIndex], batch.Columns[firstInCol+colIndex],
+					colBuilders[colIndex], rowIndex)
+			}
+			if i < len(items) {
+				appendColumnValue(itemBuilder, u.itemType, items[i])
+			} else {
+				itemBuilder.AppendNull()
+			}
+		}
+	}
+	if len(failed) > 0 {
+		if err := u.rowErrHandler.handleFailedRows("unnest", batch, failed, execCtx); err != nil {
+			return nil, err
+		}
+	}
+	return evbatch.NewBatchFromBuilders(u.outSchema.EventSchema, colBuilders...), nil
+}
+
+// evalItems returns the elements of the array for the row. A null array gives no elements.
+func (u *UnnestOperator) evalItems(rowIndex int, batch *evbatch.Batch) ([]any, error) {
+	if !u.isJSON {
+		val, null, err := u.expr.EvalNested(rowIndex, batch)
+		if err != nil || null {
+			return nil, err
+		}
+		return val.([]any), nil
+	}
+	s, null, err := u.expr.EvalString(rowIndex, batch)
+	if err != nil || null {
+		return nil, err
+	}
+	res := gjson.Parse(s)
+	if res.Type == gjson.Null && gjson.Valid(s) {
+		// JSON null is treated as a null array
+		return nil, nil
+	}
+	if !res.IsArray() || !gjson.Valid(s) {
+		return nil, errors.Errorf("unnest expression value is not a JSON array: %s", s)
+	}
+	var items []any
+	res.ForEach(func(_, value gjson.Result) bool {
+		if value.Type == gjson.Null {
+			items = append(items, nil)
+		} else {
+			items = append(items, value.Raw)
+		}
+		return true
+	})
+	return items, nil
+}
+
+func (u *UnnestOperator) InSchema() *OperatorSchema {
+	return u.inSchema
+}
+
+func (u *UnnestOperator) OutSchema() *OperatorSchema {
+	return u.outSchema
+}
+
+func (u *UnnestOperator) Setup(StreamManagerCtx) error {
+	return nil
+}
+
+func (u *UnnestOperator) Teardown(StreamManagerCtx, *sync.RWMutex) {
+}
@@ -0,0 +1,133 @@
+package opers
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var unnestColumnNames = []string{"offset", "f1", "f2", "f3"}
+var unnestColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString,
+	&types.ArrayType{ElemType: types.ColumnTypeInt}, types.ColumnTypeString}
+
+func unnestInData() [][]any {
+	return [][]any{
+		{int64(0), "a", []any{int64(1), int64(2)}, `[{"x":1},null]`},
+		{int64(1), "b", []any{}, `[]`},
+		{int64(2), "c", nil, nil},
+		{int64(3), "d", []any{nil, int64(3)}, `null`},
+	}
+}
+
+func TestUnnestArray(t *testing.T) {
+	testUnnest(t, "f2", false, [][]any{
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, int64(1)},
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, int64(2)},
+		{"d", []any{nil, int64(3)}, `null`, nil},
+		{"d", []any{nil, int64(3)}, `null`, int64(3)},
+	})
+}
+
+func TestUnnestArrayOuter(t *testing.T) {
+	testUnnest(t, "f2", true, [][]any{
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, int64(1)},
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, int64(2)},
+		{"b", []any{}, `[]`, nil},
+		{"c", nil, nil, nil},
+		{"d", []any{nil, int64(3)}, `null`, nil},
+		{"d", []any{nil, int64(3)}, `null`, int64(3)},
+	})
+}
+
+func TestUnnestJSON(t *testing.T) {
+	testUnnest(t, "f3", false, [][]any{
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, `{"x":1}`},
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, nil},
+	})
+}
+
+func TestUnnestJSONOuter(t *testing.T) {
+	testUnnest(t, "f3", true, [][]any{
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, `{"x":1}`},
+		{"a", []any{int64(1), int64(2)}, `[{"x":1},null]`, nil},
+		{"b", []any{}, `[]`, nil},
+		{"c", nil, nil, nil},
+		{"d", []any{nil, int64(3)}, `null`, nil},
+	})
+}
+
+func testUnnest(t *testing.T, exprStr string, outer bool, expectedOut [][]any) {
+	unnest, err := createUnnest(exprStr, "item", outer, unnestColumnNames, unnestColumnTypes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f1", "f2", "f3", "item"}, unnest.OutSchema().EventSchema.ColumnNames())
+	batch := createEventBatch(unnestColumnNames, unnestColumnTypes, unnestInData())
+	out, err := unnest.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	require.Equal(t, expectedOut, convertBatchToAnyArray(out))
+}
+
+func TestUnnestNoOffsetColumn(t *testing.T) {
+	names := []string{"f2"}
+	colTypes := []types.ColumnType{&types.ArrayType{ElemType: types.ColumnTypeString}}
+	unnest, err := createUnnest("f2", "item", false, names, colTypes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f2", "item"}, unnest.OutSchema().EventSchema.ColumnNames())
+	batch := createEventBatch(names, colTypes, [][]any{{[]any{"x", "y"}}})
+	out, err := unnest.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	require.Equal(t, [][]any{{[]any{"x", "y"}, "x"}, {[]any{"x", "y"}, "y"}}, convertBatchToAnyArray(out))
+}
+
+func TestUnnestInvalidJSONFailsBatchWithoutHandler(t *testing.T) {
+	unnest, err := createUnnest("f1", "item", false, unnestColumnNames, unnestColumnTypes)
+	require.NoError(t, err)
+	batch := createEventBatch(unnestColumnNames, unnestColumnTypes, unnestInData())
+	_, err = unnest.HandleStreamBatch(batch, &testExecCtx{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unnest expression value is not a JSON array: a")
+}
+
+func TestUnnestSkipsFailedRows(t *testing.T) {
+	unnest, err := createUnnest("f3", "item", false, unnestColumnNames, unnestColumnTypes)
+	require.NoError(t, err)
+	unnest.rowErrHandler = &rowErrorHandler{onError: parser.OnErrorSkip}
+	data := unnestInData()
+	data[1][3] = `{"not":"an array"}`
+	batch := createEventBatch(unnestColumnNames, unnestColumnTypes, data)
+	out, err := unnest.HandleStreamBatch(batch, &testExecCtx{})
+	require.NoError(t, err)
+	require.Equal(t, 2, out.RowCount)
+}
+
+func TestUnnestInvalidExpressionType(t *testing.T) {
+	_, err := createUnnest("offset", "item", false, unnestColumnNames, unnestColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unnest expression must be of type array, or a string containing a JSON array - it is int")
+}
+
+func TestUnnestAliasClashesWithColumn(t *testing.T) {
+	_, err := createUnnest("f2", "f1", false, unnestColumnNames, unnestColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use alias 'f1', the incoming schema already has a column with that name")
+}
+
+func TestUnnestReservedAlias(t *testing.T) {
+	_, err := createUnnest("f2", "event_time", false, unnestColumnNames, unnestColumnTypes)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot use alias 'event_time', it is a reserved name")
+}
+
+func createUnnest(exprStr string, itemName string, outer bool, names []string,
+	colTypes []types.ColumnType) (*UnnestOperator, error) {
+	desc := parser.NewUnnestDesc()
+	if err := parser.NewParser(nil).Parse(fmt.Sprintf("unnest %s as %s outer = %t)", exprStr, itemName, outer),
+		desc); err != nil {
+		return nil, err
+	}
+	schema := &OperatorSchema{EventSchema: evbatch.NewEventSchema(names, colTypes)}
+	return NewUnnestOperator(schema, desc, &expr.ExpressionFactory{})
+}
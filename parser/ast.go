@@ -5,6 +5,7 @@ import (
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/spirit-labs/tektite/errors"
 	"github.com/spirit-labs/tektite/types"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -149,10 +150,19 @@ type CreateStreamDesc struct {
 	BaseDesc
 	StreamName    string
 	OperatorDescs []Parseable
+	OnError       string
 	TestSource    bool
 	TestSink      bool
 }
 
+// Values for the 'on_error' option of a stream. These determine what happens to a row when an expression fails to
+// evaluate for it.
+const (
+	OnErrorFail       = "fail"
+	OnErrorSkip       = "skip"
+	OnErrorDeadLetter = "dead_letter"
+)
+
 func (cs *CreateStreamDesc) parse(context *ParseContext) error {
 	token, err := context.expectToken()
 	if err != nil {
@@ -172,6 +182,11 @@ func (cs *CreateStreamDesc) parse(context *ParseContext) error {
 		if !context.HasNext() {
 			break
 		}
+		token, _ := context.PeekToken()
+		if token.Value == "on_error" {
+			context.MoveCursor(1)
+			return cs.parseOnError(context)
+		}
 		if _, err := context.expectToken("->"); err != nil {
 			return err
 		}
@@ -179,6 +194,23 @@ func (cs *CreateStreamDesc) parse(context *ParseContext) error {
 	return nil
 }
 
+func (cs *CreateStreamDesc) parseOnError(context *ParseContext) error {
+	if _, err := context.expectToken(":="); err != nil {
+		return err
+	}
+	token, err := context.expectToken(OnErrorDeadLetter, OnErrorSkip, OnErrorFail)
+	if err != nil {
+		return err
+	}
+	cs.OnError = token.Value
+	// on_error must come at the end of the stream definition
+	token, ok := context.NextToken()
+	if ok {
+		return foundUnexpectedTokenError("end of statement", token, context.input)
+	}
+	return nil
+}
+
 func (cs *CreateStreamDesc) parseOperatorDesc(context *ParseContext) error {
 	token, ok := context.PeekToken()
 	if !ok {
@@ -268,9 +300,24 @@ func (cs *CreateStreamDesc) parseOperatorDesc(context *ParseContext) error {
 	case "backfill":
 		operatorDesc = NewBackfillDesc()
 		context.MoveCursor(-1)
+	case "dedup":
+		operatorDesc = NewDedupDesc()
+		context.MoveCursor(-1)
+	case "topn":
+		operatorDesc = NewTopNDesc()
+		context.MoveCursor(-1)
+	case "split":
+		operatorDesc = NewSplitDesc()
+		context.MoveCursor(-1)
+	case "unnest":
+		operatorDesc = NewUnnestDesc()
+		context.MoveCursor(-1)
+	case "decode_json":
+		operatorDesc = NewDecodeJSONDesc()
+		context.MoveCursor(-1)
 	default:
-		expected := expectedStr("aggregate", "backfill", "bridge", "filter", "join", "kafka", "partition",
-			"producer", "project", "store", "topic", "union")
+		expected := expectedStr("aggregate", "backfill", "bridge", "decode_json", "dedup", "filter", "join", "kafka",
+			"partition", "producer", "project", "split", "store", "topic", "topn", "union", "unnest")
 		return errorAtPosition(fmt.Sprintf("expected %s", expected), token.Pos, context.input)
 	}
 	if err := operatorDesc.Parse(context); err != nil {
@@ -320,7 +367,8 @@ func (q *QueryDesc) parseOperatorDesc(context *ParseContext) error {
 	if _, err := context.expectToken("("); err != nil {
 		return err
 	}
-	token, err := context.expectToken("get", "scan", "project", "filter", "sort")
+	token, err := context.expectToken("get", "scan", "project", "filter", "aggregate", "join", "sort", "limit",
+		"unnest", "decode_json")
 	if err != nil {
 		return err
 	}
@@ -340,9 +388,24 @@ func (q *QueryDesc) parseOperatorDesc(context *ParseContext) error {
 	case "filter":
 		operatorDesc = NewFilterDesc()
 		context.MoveCursor(-1)
+	case "aggregate":
+		operatorDesc = NewAggregateDesc()
+		context.MoveCursor(-1)
+	case "join":
+		operatorDesc = NewQueryJoinDesc()
+		context.MoveCursor(-1)
 	case "sort":
 		operatorDesc = NewSortDesc()
 		context.MoveCursor(-1)
+	case "limit":
+		operatorDesc = NewLimitDesc()
+		context.MoveCursor(-1)
+	case "unnest":
+		operatorDesc = NewUnnestDesc()
+		context.MoveCursor(-1)
+	case "decode_json":
+		operatorDesc = NewDecodeJSONDesc()
+		context.MoveCursor(-1)
 	default:
 		panic("unexpected operator desc")
 	}
@@ -422,6 +485,16 @@ func (p *PrepareQueryDesc) parse(context *ParseContext) error {
 		return foundUnexpectedTokenError("identifier", token, context.input)
 	}
 	p.QueryName = token.Value
+	// The params can optionally be declared after the query name, in which case the arguments are provided in the
+	// order of the declaration rather than the order the params appear in the query
+	var declaredParams []PreparedStatementParam
+	token, ok = context.PeekToken()
+	if ok && token.Type == LParensTokenType {
+		declaredParams, err = parseParamDeclarations(context)
+		if err != nil {
+			return err
+		}
+	}
 	_, err = context.expectToken(":=")
 	if err != nil {
 		return err
@@ -438,29 +511,80 @@ func (p *PrepareQueryDesc) parse(context *ParseContext) error {
 		tok := context.TokenAt(i)
 		if tok.Type == IdentTokenType {
 			if tok.Value[0] == '$' {
-				parts := strings.Split(tok.Value, ":")
-				ok := false
-				if len(parts) == 2 {
-					ct, err := types.StringToColumnType(parts[1])
-					if err == nil {
-						params = append(params, PreparedStatementParam{
-							ParamName: tok.Value,
-							ParamType: ct,
-						})
-						ok = true
-					}
+				param, err := parsePreparedStatementParam(tok, context)
+				if err != nil {
+					return err
 				}
-				if !ok {
-					return errorAtPosition("invalid prepared statement parameter. must be of form '$name:type' where type is one of int, float, bool, decimal(p, s), string, bytes, timestamp",
-						tok.Pos, context.input)
+				if declaredParams != nil {
+					if !slices.ContainsFunc(declaredParams, func(declared PreparedStatementParam) bool {
+						return declared.ParamName == param.ParamName
+					}) {
+						return errorAtPosition(fmt.Sprintf("prepared statement parameter '%s' is not declared", tok.Value),
+							tok.Pos, context.input)
+					}
+					continue
 				}
+				params = append(params, param)
 			}
 		}
 	}
+	if declaredParams != nil {
+		params = declaredParams
+	}
 	p.Params = params
 	return nil
 }
 
+func parseParamDeclarations(context *ParseContext) ([]PreparedStatementParam, error) {
+	context.MoveCursor(1)
+	params := []PreparedStatementParam{}
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return nil, endOfInputError()
+		}
+		if token.Type == RParensTokenType && len(params) == 0 {
+			return params, nil
+		}
+		if token.Type != IdentTokenType || token.Value[0] != '$' {
+			return nil, foundUnexpectedTokenError("prepared statement parameter", token, context.input)
+		}
+		param, err := parsePreparedStatementParam(token, context)
+		if err != nil {
+			return nil, err
+		}
+		for _, declared := range params {
+			if declared.ParamName == param.ParamName {
+				return nil, errorAtPosition(fmt.Sprintf("prepared statement parameter '%s' is duplicated", token.Value),
+					token.Pos, context.input)
+			}
+		}
+		params = append(params, param)
+		token, err = context.expectToken(",", ")")
+		if err != nil {
+			return nil, err
+		}
+		if token.Type == RParensTokenType {
+			return params, nil
+		}
+	}
+}
+
+func parsePreparedStatementParam(tok lexer.Token, context *ParseContext) (PreparedStatementParam, error) {
+	parts := strings.Split(tok.Value, ":")
+	if len(parts) == 2 {
+		ct, err := types.StringToColumnType(parts[1])
+		if err == nil {
+			return PreparedStatementParam{
+				ParamName: tok.Value,
+				ParamType: ct,
+			}, nil
+		}
+	}
+	return PreparedStatementParam{}, errorAtPosition("invalid prepared statement parameter. must be of form '$name:type' where type is one of int, float, bool, decimal(p, s), string, bytes, timestamp",
+		tok.Pos, context.input)
+}
+
 type PreparedStatementParam struct {
 	ParamName string
 	ParamType types.ColumnType
@@ -852,8 +976,10 @@ func NewStoreTableDesc() *StoreTableDesc {
 
 type StoreTableDesc struct {
 	BaseDesc
-	KeyCols   []string
-	Retention *time.Duration
+	KeyCols           []string
+	Retention         *time.Duration
+	VersionsRetention *time.Duration
+	Indexes           [][]string
 }
 
 func (s *StoreTableDesc) parse(context *ParseContext) error {
@@ -881,25 +1007,71 @@ func (s *StoreTableDesc) parse(context *ParseContext) error {
 		return errorAtPosition(`no key columns specified`, nextToken.Pos, context.input)
 	}
 	s.KeyCols = cols
-	token, ok = context.NextToken()
-	if !ok {
-		return endOfInputError()
-	}
-	if token.Value == ")" {
-		return nil
+	for {
+		token, ok = context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		if token.Value == ")" {
+			return nil
+		}
+		switch token.Value {
+		case "retention":
+			if s.Retention != nil {
+				return duplicateArgumentError(token, context)
+			}
+			retention, err := parseDurationArg(context)
+			if err != nil {
+				return err
+			}
+			s.Retention = &retention
+		case "versions_retention":
+			if s.VersionsRetention != nil {
+				return duplicateArgumentError(token, context)
+			}
+			versionsRetention, err := parseDurationArg(context)
+			if err != nil {
+				return err
+			}
+			s.VersionsRetention = &versionsRetention
+		case "index":
+			indexCols, err := parseIndexCols(context)
+			if err != nil {
+				return err
+			}
+			s.Indexes = append(s.Indexes, indexCols)
+		default:
+			return foundUnexpectedTokenError(expectedStr("retention", "versions_retention", "index", ")"), token, context.input)
+		}
 	}
-	if token.Value != "retention" {
-		return foundUnexpectedTokenError("retention", token, context.input)
+}
+
+// parseIndexCols parses the columns of a secondary index, e.g. "by (last_name, first_name)"
+func parseIndexCols(context *ParseContext) ([]string, error) {
+	if _, err := context.expectToken("by"); err != nil {
+		return nil, err
 	}
-	retention, err := parseDurationArg(context)
-	if err != nil {
-		return err
+	if _, err := context.expectToken("("); err != nil {
+		return nil, err
 	}
-	s.Retention = &retention
-	if _, err := context.expectToken(")"); err != nil {
-		return err
+	var cols []string
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return nil, endOfInputError()
+		}
+		if token.Type != IdentTokenType {
+			return nil, foundUnexpectedTokenError("identifier", token, context.input)
+		}
+		cols = append(cols, token.Value)
+		token, err := context.expectToken(",", ")")
+		if err != nil {
+			return nil, err
+		}
+		if token.Value == ")" {
+			return cols, nil
+		}
 	}
-	return nil
 }
 
 func NewProjectDesc() *ProjectDesc {
@@ -999,6 +1171,451 @@ func (p *PartitionDesc) parse(context *ParseContext) error {
 	return nil
 }
 
+func NewDedupDesc() *DedupDesc {
+	super := &DedupDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+type DedupDesc struct {
+	BaseDesc
+	KeyExprs        []ExprDesc
+	KeyExprsStrings []string
+	Within          time.Duration
+}
+
+func (d *DedupDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	if _, err := context.expectToken("by"); err != nil {
+		return err
+	}
+	keyExprStrings, keyExprs, err := parseExpressions(context)
+	if err != nil {
+		return err
+	}
+	if len(keyExprs) == 0 {
+		tok, ok := context.PeekToken()
+		if !ok {
+			return endOfInputError()
+		}
+		return emptyKeyExpressionsError(tok.Pos, context)
+	}
+	d.KeyExprs = keyExprs
+	d.KeyExprsStrings = keyExprStrings
+	// within is mandatory
+	if _, err := context.expectToken("within"); err != nil {
+		return err
+	}
+	within, err := parseDurationArg(context)
+	if err != nil {
+		return err
+	}
+	d.Within = within
+	if _, err := context.expectToken(")"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DedupDesc) clearTokenState() {
+	d.BaseDesc.clearTokenState()
+	for _, expr := range d.KeyExprs {
+		clearable, ok := expr.(tokenClearable)
+		if ok {
+			clearable.clearTokenState()
+		}
+	}
+}
+
+func NewTopNDesc() *TopNDesc {
+	super := &TopNDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+type TopNDesc struct {
+	BaseDesc
+	N               int
+	KeyExprs        []ExprDesc
+	KeyExprsStrings []string
+	OrderByExprs    []ExprDesc
+}
+
+func (t *TopNDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	// n is mandatory
+	tok, err := parseNamedArg("n", IntegerTokenType, "integer", context)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(tok.Value)
+	if err != nil {
+		return errorAtPosition(fmt.Sprintf("%s is not an integer", tok.Value), tok.Pos, context.input)
+	}
+	t.N = n
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		if token.Value == ")" {
+			// End of operator definition
+			break
+		}
+		switch token.Value {
+		case "by":
+			if t.KeyExprs != nil {
+				return duplicateArgumentError(token, context)
+			}
+			keyExprStrings, keyExprs, err := parseExpressions(context)
+			if err != nil {
+				return err
+			}
+			if len(keyExprs) == 0 {
+				return emptyKeyExpressionsError(token.Pos, context)
+			}
+			t.KeyExprs = keyExprs
+			t.KeyExprsStrings = keyExprStrings
+		case "order":
+			if t.OrderByExprs != nil {
+				return duplicateArgumentError(token, context)
+			}
+			if _, err := context.expectToken("by"); err != nil {
+				return err
+			}
+			_, orderByExprs, err := parseExpressions(context)
+			if err != nil {
+				return err
+			}
+			if len(orderByExprs) == 0 {
+				return emptyKeyExpressionsError(token.Pos, context)
+			}
+			t.OrderByExprs = orderByExprs
+		default:
+			return foundUnexpectedTokenError(expectedStr("by", "order", ")"), token, context.input)
+		}
+	}
+	if t.OrderByExprs == nil {
+		return errorAtPosition("'order by' must be specified", *context.LastPos(), context.input)
+	}
+	return nil
+}
+
+func (t *TopNDesc) clearTokenState() {
+	t.BaseDesc.clearTokenState()
+	for _, expr := range t.KeyExprs {
+		clearable, ok := expr.(tokenClearable)
+		if ok {
+			clearable.clearTokenState()
+		}
+	}
+	for _, expr := range t.OrderByExprs {
+		clearable, ok := expr.(tokenClearable)
+		if ok {
+			clearable.clearTokenState()
+		}
+	}
+}
+
+func NewSplitDesc() *SplitDesc {
+	super := &SplitDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+type SplitDesc struct {
+	BaseDesc
+	Branches []*SplitBranchDesc
+}
+
+type SplitBranchDesc struct {
+	// Expr is nil for the 'else' branch
+	Expr       ExprDesc
+	StreamName string
+}
+
+func (s *SplitDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	streamNames := map[string]struct{}{}
+	for {
+		token, err := context.expectToken("when", "else")
+		if err != nil {
+			return err
+		}
+		isElse := token.Value == "else"
+		branch := &SplitBranchDesc{}
+		if !isElse {
+			_, exprs, err := parseExpressions(context)
+			if err != nil {
+				return err
+			}
+			if len(exprs) != 1 {
+				nextToken, ok := context.NextToken()
+				if !ok {
+					return endOfInputError()
+				}
+				return errorAtPosition(`a single 'when' expression must be specified`, nextToken.Pos, context.input)
+			}
+			branch.Expr = exprs[0]
+		}
+		if _, err := context.expectToken("->"); err != nil {
+			return err
+		}
+		token, err = context.expectToken()
+		if err != nil {
+			return err
+		}
+		if token.Type != IdentTokenType {
+			return foundUnexpectedTokenError("identifier", token, context.input)
+		}
+		if _, exists := streamNames[token.Value]; exists {
+			return errorAtPosition(fmt.Sprintf("stream '%s' is used by more than one branch", token.Value), token.Pos,
+				context.input)
+		}
+		streamNames[token.Value] = struct{}{}
+		branch.StreamName = token.Value
+		s.Branches = append(s.Branches, branch)
+		if isElse {
+			// 'else' must be the last branch
+			if _, err := context.expectToken(")"); err != nil {
+				return err
+			}
+			break
+		}
+		token, err = context.expectToken(",", ")")
+		if err != nil {
+			return err
+		}
+		if token.Value == ")" {
+			break
+		}
+	}
+	if s.Branches[0].Expr == nil {
+		return errorAtPosition("at least one 'when' branch must be specified", *context.LastPos(), context.input)
+	}
+	return nil
+}
+
+func (s *SplitDesc) clearTokenState() {
+	s.BaseDesc.clearTokenState()
+	for _, branch := range s.Branches {
+		clearable, ok := branch.Expr.(tokenClearable)
+		if ok {
+			clearable.clearTokenState()
+		}
+	}
+}
+
+func NewUnnestDesc() *UnnestDesc {
+	super := &UnnestDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+// UnnestDesc describes an operator which emits a row for each element of an array, e.g.
+// (unnest line_items as item outer = true)
+type UnnestDesc struct {
+	BaseDesc
+	Expr     ExprDesc
+	ItemName string
+	// Outer is true if a row, with a null item, should still be emitted when the array is empty or null
+	Outer bool
+}
+
+func (u *UnnestDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	_, exprs, err := parseExpressions(context)
+	if err != nil {
+		return err
+	}
+	if len(exprs) != 1 {
+		nextToken, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		return errorAtPosition(`a single unnest expression must be specified`, nextToken.Pos, context.input)
+	}
+	ok, exprDesc, alias, _ := ExtractAlias(exprs[0])
+	if !ok || alias == "" {
+		nextToken, ok := context.PeekToken()
+		if !ok {
+			return endOfInputError()
+		}
+		return errorAtPosition(`unnest expression must have an identifier alias for the item column, e.g. (unnest line_items as item)`,
+			nextToken.Pos, context.input)
+	}
+	u.Expr = exprDesc
+	u.ItemName = alias
+	seenOuter := false
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		if token.Value == ")" {
+			return nil
+		}
+		if token.Value != "outer" {
+			return foundUnexpectedTokenError(expectedStr("outer", ")"), token, context.input)
+		}
+		if seenOuter {
+			return duplicateArgumentError(token, context)
+		}
+		outer, err := parseBool(context)
+		if err != nil {
+			return err
+		}
+		u.Outer = outer
+		seenOuter = true
+	}
+}
+
+func (u *UnnestDesc) clearTokenState() {
+	u.BaseDesc.clearTokenState()
+	clearable, ok := u.Expr.(tokenClearable)
+	if ok {
+		clearable.clearTokenState()
+	}
+}
+
+func NewDecodeJSONDesc() *DecodeJSONDesc {
+	super := &DecodeJSONDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+// DecodeJSONDesc describes an operator which decodes a JSON object payload into typed columns, e.g.
+// (decode_json schema = (a string, b int, c decimal(10,2)) column = val strict = true)
+type DecodeJSONDesc struct {
+	BaseDesc
+	// Column is the name of the string or bytes column containing the payload. It defaults to 'val'
+	Column      string
+	ColumnNames []string
+	ColumnTypes []types.ColumnType
+	// Strict is true if a payload which is not a JSON object, or a field which cannot be converted to its type, is an
+	// error, rather than giving null
+	Strict bool
+}
+
+const DefaultDecodeJSONColumn = "val"
+
+func (d *DecodeJSONDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	d.Column = DefaultDecodeJSONColumn
+	var seenSchema, seenColumn, seenStrict bool
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		switch token.Value {
+		case ")":
+			if !seenSchema {
+				return errorAtPosition("'schema' must be specified", token.Pos, context.input)
+			}
+			return nil
+		case "schema":
+			if seenSchema {
+				return duplicateArgumentError(token, context)
+			}
+			if err := d.parseSchema(context); err != nil {
+				return err
+			}
+			seenSchema = true
+		case "column":
+			if seenColumn {
+				return duplicateArgumentError(token, context)
+			}
+			tok, err := parseNamedArgValue(IdentTokenType, "identifier", context)
+			if err != nil {
+				return err
+			}
+			d.Column = tok.Value
+			seenColumn = true
+		case "strict":
+			if seenStrict {
+				return duplicateArgumentError(token, context)
+			}
+			strict, err := parseBool(context)
+			if err != nil {
+				return err
+			}
+			d.Strict = strict
+			seenStrict = true
+		default:
+			return foundUnexpectedTokenError(expectedStr("schema", "column", "strict", ")"), token, context.input)
+		}
+	}
+}
+
+// parseSchema parses a list of column names and types, e.g. (a string, b array<int>, c decimal(10,2))
+func (d *DecodeJSONDesc) parseSchema(context *ParseContext) error {
+	token, skippedPastEquals, ok := skipPastOptionalEquals(context)
+	if !ok {
+		return endOfInputError()
+	}
+	if token.Value != "(" {
+		expected := "'('"
+		if !skippedPastEquals {
+			expected = "'=' or '('"
+		}
+		return foundUnexpectedTokenError(expected, token, context.input)
+	}
+	for {
+		nameTok, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		if nameTok.Type != IdentTokenType {
+			return foundUnexpectedTokenError("column name", nameTok, context.input)
+		}
+		for _, name := range d.ColumnNames {
+			if name == nameTok.Value {
+				return errorAtPosition(fmt.Sprintf("column '%s' is duplicated", nameTok.Value), nameTok.Pos,
+					context.input)
+			}
+		}
+		// The type is made up of all the tokens up to the next ',' or ')' which is not nested inside the type
+		typeTok, ok := context.PeekToken()
+		if !ok {
+			return endOfInputError()
+		}
+		var sb strings.Builder
+		depth := 0
+		var end lexer.Token
+		for {
+			tok, ok := context.NextToken()
+			if !ok {
+				return endOfInputError()
+			}
+			if depth == 0 && (tok.Value == "," || tok.Value == ")") {
+				end = tok
+				break
+			}
+			switch tok.Value {
+			case "(", "<":
+				depth++
+			case ")", ">":
+				depth--
+			}
+			sb.WriteString(tok.Value)
+		}
+		if sb.Len() == 0 {
+			return foundUnexpectedTokenError("column type", end, context.input)
+		}
+		columnType, err := types.StringToColumnType(sb.String())
+		if err != nil {
+			return errorAtPosition(fmt.Sprintf("invalid type '%s' for column '%s'", sb.String(), nameTok.Value),
+				typeTok.Pos, context.input)
+		}
+		d.ColumnNames = append(d.ColumnNames, nameTok.Value)
+		d.ColumnTypes = append(d.ColumnTypes, columnType)
+		if end.Value == ")" {
+			return nil
+		}
+	}
+}
+
 func NewAggregateDesc() *AggregateDesc {
 	super := &AggregateDesc{}
 	super.BaseDesc.super = super
@@ -1227,7 +1844,7 @@ func (j *JoinDesc) parse(context *ParseContext) error {
 	if _, err := context.expectToken("by"); err != nil {
 		return err
 	}
-	joinElements, token, err := parseJoinElements(context)
+	joinElements, token, err := parseJoinElements(context, ")", "within", "retention")
 	if err != nil {
 		return err
 	}
@@ -1293,7 +1910,8 @@ func parseJoinInput(context *ParseContext) (bool, lexer.Token, error) {
 	return isTable, token, nil
 }
 
-func parseJoinElements(context *ParseContext) ([]JoinElement, lexer.Token, error) {
+// parseJoinElements parses the join elements up to, and including, the first of the terminators
+func parseJoinElements(context *ParseContext, terminators ...string) ([]JoinElement, lexer.Token, error) {
 	var joinElements []JoinElement
 	first := true
 	for {
@@ -1301,7 +1919,7 @@ func parseJoinElements(context *ParseContext) ([]JoinElement, lexer.Token, error
 		if !ok {
 			return nil, lexer.Token{}, endOfInputError()
 		}
-		if token.Value == ")" || token.Value == "within" || token.Value == "retention" {
+		if slices.Contains(terminators, token.Value) {
 			// End of join elements definition
 			return joinElements, token, nil
 		}
@@ -1349,6 +1967,70 @@ func parseJoinElements(context *ParseContext) ([]JoinElement, lexer.Token, error
 	}
 }
 
+func NewQueryJoinDesc() *QueryJoinDesc {
+	super := &QueryJoinDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+// QueryJoinDesc describes the join of the results of a query with the rows of a table, e.g.
+// (join customers by cust_id = id strategy = lookup)
+// The left columns of the join elements are columns of the query results, and the right columns are columns of the
+// table. If no strategy is specified, one is chosen when the query is created.
+type QueryJoinDesc struct {
+	BaseDesc
+	TableName    string
+	JoinElements []JoinElement
+	Strategy     string
+}
+
+func (q *QueryJoinDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	token, ok := context.NextToken()
+	if !ok {
+		return endOfInputError()
+	}
+	if token.Type != IdentTokenType {
+		return foundUnexpectedTokenError("identifier", token, context.input)
+	}
+	q.TableName = token.Value
+	if _, err := context.expectToken("by"); err != nil {
+		return err
+	}
+	joinElements, token, err := parseJoinElements(context, ")", "strategy")
+	if err != nil {
+		return err
+	}
+	if len(joinElements) == 0 {
+		return errorAtPosition(`there must be at least one join column expression`, token.Pos, context.input)
+	}
+	q.JoinElements = joinElements
+	if token.Value == "strategy" {
+		tok, err := parseNamedArgValue(IdentTokenType, "identifier", context)
+		if err != nil {
+			return err
+		}
+		if tok.Value != "lookup" && tok.Value != "broadcast" {
+			return foundUnexpectedTokenError(expectedStr("lookup", "broadcast"), tok, context.input)
+		}
+		q.Strategy = tok.Value
+		if _, err := context.expectToken(")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *QueryJoinDesc) clearTokenState() {
+	q.BaseDesc.clearTokenState()
+	for i := 0; i < len(q.JoinElements); i++ {
+		e := &q.JoinElements[i]
+		e.JoinTypeToken = lexer.Token{}
+		e.LeftToken = lexer.Token{}
+		e.RightToken = lexer.Token{}
+	}
+}
+
 func NewKafkaInDesc() *KafkaInDesc {
 	super := &KafkaInDesc{}
 	super.BaseDesc.super = super
@@ -1584,6 +2266,7 @@ type GetDesc struct {
 	BaseDesc
 	KeyExprs  []ExprDesc
 	TableName string
+	IndexCols []string
 }
 
 func (g *GetDesc) parse(context *ParseContext) error {
@@ -1612,7 +2295,18 @@ func (g *GetDesc) parse(context *ParseContext) error {
 		return foundUnexpectedTokenError("identifier", token, context.input)
 	}
 	g.TableName = token.Value
-	_, err = context.expectToken(")")
+	token, err = context.expectToken("index", ")")
+	if err != nil {
+		return err
+	}
+	if token.Value == "index" {
+		// The key expressions are values of the columns of a secondary index, not of the key of the table
+		g.IndexCols, err = parseIndexCols(context)
+		if err != nil {
+			return err
+		}
+		_, err = context.expectToken(")")
+	}
 	return err
 }
 
@@ -1640,6 +2334,10 @@ type ScanDesc struct {
 	FromIncl     bool
 	TableName    string
 	All          bool
+	// AsOfTime, if set, is the point in time at which the table is read
+	AsOfTime *time.Time
+	// AsOfVersion, if set, is the version at which the table is read
+	AsOfVersion *int64
 }
 
 func (s *ScanDesc) parse(context *ParseContext) error {
@@ -1655,10 +2353,45 @@ func (s *ScanDesc) parse(context *ParseContext) error {
 		return foundUnexpectedTokenError("identifier", token, context.input)
 	}
 	s.TableName = token.Value
+	nextToken, ok := context.PeekToken()
+	if ok && nextToken.Value == "as_of" {
+		context.NextToken()
+		if err := s.parseAsOf(context); err != nil {
+			return err
+		}
+	}
 	_, err := context.expectToken(")")
 	return err
 }
 
+func (s *ScanDesc) parseAsOf(context *ParseContext) error {
+	token, skippedPastEquals, ok := skipPastOptionalEquals(context)
+	if !ok {
+		return endOfInputError()
+	}
+	switch token.Type {
+	case StringLiteralTokenType:
+		asOf, err := time.Parse(time.RFC3339, stripQuotes(token.Value))
+		if err != nil {
+			return errorAtPosition("invalid timestamp - must be in RFC3339 format", token.Pos, context.input)
+		}
+		s.AsOfTime = &asOf
+	case IntegerTokenType:
+		version, err := strconv.ParseInt(token.Value, 10, 64)
+		if err != nil || version < 0 {
+			return errorAtPosition("invalid version", token.Pos, context.input)
+		}
+		s.AsOfVersion = &version
+	default:
+		expected := "string literal or integer"
+		if !skippedPastEquals {
+			expected = "'=' or string literal or integer"
+		}
+		return foundUnexpectedTokenError(expected, token, context.input)
+	}
+	return nil
+}
+
 func (s *ScanDesc) parseRange(context *ParseContext) error {
 	nextToken, ok := context.PeekToken()
 	if !ok {
@@ -1799,6 +2532,65 @@ func (s *SortDesc) clearTokenState() {
 	}
 }
 
+func NewLimitDesc() *LimitDesc {
+	super := &LimitDesc{}
+	super.BaseDesc.super = super
+	return super
+}
+
+type LimitDesc struct {
+	BaseDesc
+	Limit  int
+	Offset int
+}
+
+func (l *LimitDesc) parse(context *ParseContext) error {
+	context.MoveCursor(1)
+	// the number of rows is mandatory
+	tok, err := context.expectToken()
+	if err != nil {
+		return err
+	}
+	if tok.Type != IntegerTokenType {
+		return foundUnexpectedTokenError("integer", tok, context.input)
+	}
+	limit, err := strconv.Atoi(tok.Value)
+	if err != nil || limit < 1 {
+		return errorAtPosition("limit must be a positive integer", tok.Pos, context.input)
+	}
+	l.Limit = limit
+	hasOffset := false
+	for {
+		token, ok := context.NextToken()
+		if !ok {
+			return endOfInputError()
+		}
+		if token.Value == ")" {
+			// End of operator definition
+			break
+		}
+		switch token.Value {
+		case "offset":
+			if hasOffset {
+				return duplicateArgumentError(token, context)
+			}
+			tok, err := parseNamedArgValue(IntegerTokenType, "integer", context)
+			if err != nil {
+				return err
+			}
+			offset, err := strconv.Atoi(tok.Value)
+			if err != nil {
+				return errorAtPosition(fmt.Sprintf("%s is not an integer", tok.Value), tok.Pos, context.input)
+			}
+			l.Offset = offset
+			hasOffset = true
+		default:
+			return foundUnexpectedTokenError(expectedStr("offset", ")"), token, context.input)
+		}
+	}
+	return nil
+}
+
 func parseOptionalRetention(context *ParseContext) (*time.Duration, error) {
 	token, ok := context.NextToken()
 	if !ok {
@@ -1951,9 +2743,9 @@ func parseDurationArg(context *ParseContext) (time.Duration, error) {
 	if err != nil {
 		return 0, err
 	}
-	dur, err := time.ParseDuration(tok.Value)
+	dur, err := ParseDuration(tok.Value)
 	if err != nil {
-		return 0, err
+		return 0, errorAtPosition(err.Error(), tok.Pos, context.input)
 	}
 	return dur, nil
 }
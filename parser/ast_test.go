@@ -46,7 +46,7 @@ my_stream := kafka in partitions 10
 
 func TestFailedToParseOperatorName(t *testing.T) {
 	input := "my_stream := (wibble foo=24h)"
-	expectedMsg := `expected one of: 'aggregate', 'backfill', 'bridge', 'filter', 'join', 'kafka', 'partition', 'producer', 'project', 'store', 'topic', 'union' (line 1 column 15):
+	expectedMsg := `expected one of: 'aggregate', 'backfill', 'bridge', 'decode_json', 'dedup', 'filter', 'join', 'kafka', 'partition', 'producer', 'project', 'split', 'store', 'topic', 'topn', 'union', 'unnest' (line 1 column 15):
 my_stream := (wibble foo=24h)
               ^`
 	testFailedToParseCreateStream(t, input, expectedMsg)
@@ -1548,6 +1548,19 @@ func TestParseStoreStream(t *testing.T) {
 		},
 	}
 	testParseCreateStream(t, input, expected)
+
+	// Durations can be in days, as in expressions
+	retention = 7 * 24 * time.Hour
+	input = "my_stream := (store stream retention=7d)"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&StoreStreamDesc{
+				Retention: &retention,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
 }
 
 func TestFailedToParseStore(t *testing.T) {
@@ -1586,6 +1599,12 @@ my_stream := (store stream retention = foo)
 my_stream := (store stream retention =)
                                       ^`
 	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store stream retention = 106752d)"
+	expectedMsg = `duration '106752d' is out of range (line 1 column 40):
+my_stream := (store stream retention = 106752d)
+                                       ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
 }
 
 func TestParseStoreTable(t *testing.T) {
@@ -1645,6 +1664,33 @@ func TestParseStoreTable(t *testing.T) {
 		},
 	}
 	testParseCreateStream(t, input, expected)
+
+	input = "my_stream := (store table by f1 index by (f2) retention=2h index by (f3, f4))"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&StoreTableDesc{
+				KeyCols:   []string{"f1"},
+				Retention: &retention,
+				Indexes:   [][]string{{"f2"}, {"f3", "f4"}},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	versionsRetention := 24 * time.Hour
+	input = "my_stream := (store table by f1 retention=2h versions_retention=24h)"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&StoreTableDesc{
+				KeyCols:           []string{"f1"},
+				Retention:         &retention,
+				VersionsRetention: &versionsRetention,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
 }
 
 func TestFailedToParseStoreTable(t *testing.T) {
@@ -1670,11 +1716,47 @@ my_stream := (store table by f1 retention =)
                                            ^`
 	testFailedToParseCreateStream(t, input, expectedMsg)
 
+	input = "my_stream := (store table by f1 versions_retention = 1h versions_retention = 2h)"
+	expectedMsg = `argument 'versions_retention' is duplicated (line 1 column 57):
+my_stream := (store table by f1 versions_retention = 1h versions_retention = 2h)
+                                                        ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
 	input = "my_stream := (store table by)"
 	expectedMsg = `no key columns specified (line 1 column 29):
 my_stream := (store table by)
                             ^`
 	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store table by f1 foo)"
+	expectedMsg = `expected one of: 'retention', 'versions_retention', 'index', ')' but found 'foo' (line 1 column 33):
+my_stream := (store table by f1 foo)
+                                ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store table by f1 index (f2))"
+	expectedMsg = `expected 'by' but found '(' (line 1 column 39):
+my_stream := (store table by f1 index (f2))
+                                      ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store table by f1 index by f2)"
+	expectedMsg = `expected '(' but found 'f2' (line 1 column 42):
+my_stream := (store table by f1 index by f2)
+                                         ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store table by f1 index by (f2 f3))"
+	expectedMsg = `expected one of: ',', ')' but found 'f3' (line 1 column 46):
+my_stream := (store table by f1 index by (f2 f3))
+                                             ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (store table by f1 retention 1h retention 2h)"
+	expectedMsg = `argument 'retention' is duplicated (line 1 column 46):
+my_stream := (store table by f1 retention 1h retention 2h)
+                                             ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
 }
 
 func TestParseFilter(t *testing.T) {
@@ -1724,6 +1806,439 @@ my_stream := (filter by to_lower(f1
 	testFailedToParseCreateStream(t, input, expectedMsg)
 }
 
+func TestParseDedup(t *testing.T) {
+	input := "my_stream := (dedup by f1 within 10m)"
+	expected := CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&DedupDesc{
+				KeyExprs:        []ExprDesc{&IdentifierExprDesc{IdentifierName: "f1"}},
+				KeyExprsStrings: []string{"f1"},
+				Within:          10 * time.Minute,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = "my_stream := (dedup by f1, to_lower(f2) within=5s)"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&DedupDesc{
+				KeyExprs: []ExprDesc{
+					&IdentifierExprDesc{IdentifierName: "f1"},
+					&FunctionExprDesc{FunctionName: "to_lower", ArgExprs: []ExprDesc{&IdentifierExprDesc{IdentifierName: "f2"}}},
+				},
+				KeyExprsStrings: []string{"f1", "to_lower(f2)"},
+				Within:          5 * time.Second,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+}
+
+func TestFailedToParseDedup(t *testing.T) {
+	input := "my_stream := (dedup)"
+	expectedMsg := `expected 'by' but found ')' (line 1 column 20):
+my_stream := (dedup)
+                   ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (dedup by f1)"
+	expectedMsg = `expected 'within' but found ')' (line 1 column 26):
+my_stream := (dedup by f1)
+                         ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (dedup by f1 within foo)"
+	expectedMsg = `expected '=' or duration but found 'foo' (line 1 column 34):
+my_stream := (dedup by f1 within foo)
+                                 ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (dedup by f1 within 10m"
+	expectedMsg = `reached end of statement`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
+func TestParseOnError(t *testing.T) {
+	for _, onError := range []string{OnErrorDeadLetter, OnErrorSkip, OnErrorFail} {
+		input := fmt.Sprintf("my_stream := (filter by f1 > 10) -> (project f2) on_error := %s", onError)
+		expected := CreateStreamDesc{
+			StreamName: "my_stream",
+			OperatorDescs: []Parseable{
+				&FilterDesc{
+					Expr: &BinaryOperatorExprDesc{
+						Left:  &IdentifierExprDesc{IdentifierName: "f1"},
+						Right: &IntegerConstExprDesc{Value: 10},
+						Op:    ">",
+					},
+				},
+				&ProjectDesc{
+					Expressions: []ExprDesc{&IdentifierExprDesc{IdentifierName: "f2"}},
+				},
+			},
+			OnError: onError,
+		}
+		testParseCreateStream(t, input, expected)
+	}
+}
+
+func TestFailedToParseOnError(t *testing.T) {
+	input := "my_stream := (project f2) on_error = skip"
+	expectedMsg := `expected ':=' but found '=' (line 1 column 36):
+my_stream := (project f2) on_error = skip
+                                   ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (project f2) on_error := retry"
+	expectedMsg = `expected one of: 'dead_letter', 'skip', 'fail' but found 'retry' (line 1 column 39):
+my_stream := (project f2) on_error := retry
+                                      ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (project f2) on_error := skip -> (filter by f2 > 1)"
+	expectedMsg = `expected end of statement but found '->' (line 1 column 44):
+my_stream := (project f2) on_error := skip -> (filter by f2 > 1)
+                                           ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (project f2) on_error :="
+	expectedMsg = `reached end of statement`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
+func TestParseSplit(t *testing.T) {
+	input := "my_stream := (split when f1 > 10 -> high, when to_lower(f2) == \"x\" -> xs, else -> other)"
+	expected := CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&SplitDesc{
+				Branches: []*SplitBranchDesc{
+					{
+						Expr: &BinaryOperatorExprDesc{
+							Left:  &IdentifierExprDesc{IdentifierName: "f1"},
+							Right: &IntegerConstExprDesc{Value: 10},
+							Op:    ">",
+						},
+						StreamName: "high",
+					},
+					{
+						Expr: &BinaryOperatorExprDesc{
+							Left: &FunctionExprDesc{
+								FunctionName: "to_lower",
+								ArgExprs:     []ExprDesc{&IdentifierExprDesc{IdentifierName: "f2"}},
+							},
+							Right: &StringConstExprDesc{Value: "x"},
+							Op:    "==",
+						},
+						StreamName: "xs",
+					},
+					{
+						StreamName: "other",
+					},
+				},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = "my_stream := (filter by f1 > 0) -> (split when f1 > 10 -> high)"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&FilterDesc{
+				Expr: &BinaryOperatorExprDesc{
+					Left:  &IdentifierExprDesc{IdentifierName: "f1"},
+					Right: &IntegerConstExprDesc{Value: 0},
+					Op:    ">",
+				},
+			},
+			&SplitDesc{
+				Branches: []*SplitBranchDesc{
+					{
+						Expr: &BinaryOperatorExprDesc{
+							Left:  &IdentifierExprDesc{IdentifierName: "f1"},
+							Right: &IntegerConstExprDesc{Value: 10},
+							Op:    ">",
+						},
+						StreamName: "high",
+					},
+				},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+}
+
+func TestFailedToParseSplit(t *testing.T) {
+	input := "my_stream := (split f1 > 10 -> high)"
+	expectedMsg := `expected one of: 'when', 'else' but found 'f1' (line 1 column 21):
+my_stream := (split f1 > 10 -> high)
+                    ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (split else -> other)"
+	expectedMsg = `at least one 'when' branch must be specified (line 1 column 34):
+my_stream := (split else -> other)
+                                 ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (split else -> other, when f1 > 10 -> high)"
+	expectedMsg = `expected ')' but found ',' (line 1 column 34):
+my_stream := (split else -> other, when f1 > 10 -> high)
+                                 ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (split when f1 > 10 high)"
+	expectedMsg = `expected '->' but found 'high' (line 1 column 34):
+my_stream := (split when f1 > 10 high)
+                                 ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (split when f1 > 10 -> high, when f1 < 0 -> high)"
+	expectedMsg = `stream 'high' is used by more than one branch (line 1 column 58):
+my_stream := (split when f1 > 10 -> high, when f1 < 0 -> high)
+                                                         ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (split when f1 > 10 -> 23)"
+	expectedMsg = `expected identifier but found '23' (line 1 column 37):
+my_stream := (split when f1 > 10 -> 23)
+                                    ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
+func TestParseTopN(t *testing.T) {
+	input := "my_stream := (topn n = 3 by f1 order by f2 desc)"
+	expected := CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&TopNDesc{
+				N:               3,
+				KeyExprs:        []ExprDesc{&IdentifierExprDesc{IdentifierName: "f1"}},
+				KeyExprsStrings: []string{"f1"},
+				OrderByExprs: []ExprDesc{
+					&UnaryPostfixOperatorExprDesc{Operand: &IdentifierExprDesc{IdentifierName: "f2"}, Op: "desc"},
+				},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = "my_stream := (topn n=10 order by f2, f3)"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&TopNDesc{
+				N: 10,
+				OrderByExprs: []ExprDesc{
+					&IdentifierExprDesc{IdentifierName: "f2"},
+					&IdentifierExprDesc{IdentifierName: "f3"},
+				},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+}
+
+func TestFailedToParseTopN(t *testing.T) {
+	input := "my_stream := (topn by f1 order by f2)"
+	expectedMsg := `expected 'n' but found 'by' (line 1 column 20):
+my_stream := (topn by f1 order by f2)
+                   ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (topn n = 3 by f1)"
+	expectedMsg = `'order by' must be specified (line 1 column 31):
+my_stream := (topn n = 3 by f1)
+                              ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (topn n = 3 order f1)"
+	expectedMsg = `expected 'by' but found 'f1' (line 1 column 32):
+my_stream := (topn n = 3 order f1)
+                               ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (topn n = 3 order by f1 foo)"
+	expectedMsg = `expected one of: 'by', 'order', ')' but found 'foo' (line 1 column 38):
+my_stream := (topn n = 3 order by f1 foo)
+                                     ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
+func TestParseUnnest(t *testing.T) {
+	input := "my_stream := (unnest line_items as item)"
+	expected := CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&UnnestDesc{
+				Expr:     &IdentifierExprDesc{IdentifierName: "line_items"},
+				ItemName: "item",
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = `my_stream := (unnest json_raw("items", val) as item outer = true)`
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&UnnestDesc{
+				Expr: &FunctionExprDesc{
+					FunctionName: "json_raw",
+					ArgExprs: []ExprDesc{
+						&StringConstExprDesc{Value: "items"},
+						&IdentifierExprDesc{IdentifierName: "val"},
+					},
+				},
+				ItemName: "item",
+				Outer:    true,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = `(scan all from orders)->(unnest line_items as item outer false)`
+	expectedQuery := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "orders",
+			All:       true,
+		},
+		&UnnestDesc{
+			Expr:     &IdentifierExprDesc{IdentifierName: "line_items"},
+			ItemName: "item",
+		},
+	}}
+	testParseQuery(t, input, expectedQuery)
+}
+
+func TestFailedToParseUnnest(t *testing.T) {
+	input := "my_stream := (unnest line_items)"
+	expectedMsg := `unnest expression must have an identifier alias for the item column, e.g. (unnest line_items as item) (line 1 column 32):
+my_stream := (unnest line_items)
+                               ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (unnest f1 as item, f2 as item2)"
+	expectedMsg = `a single unnest expression must be specified (line 1 column 45):
+my_stream := (unnest f1 as item, f2 as item2)
+                                            ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (unnest f1 as item outer = 1)"
+	expectedMsg = `expected bool but found '1' (line 1 column 41):
+my_stream := (unnest f1 as item outer = 1)
+                                        ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (unnest f1 as item outer = true outer = false)"
+	expectedMsg = `argument 'outer' is duplicated (line 1 column 46):
+my_stream := (unnest f1 as item outer = true outer = false)
+                                             ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (unnest f1 as item foo)"
+	expectedMsg = `expected one of: 'outer', ')' but found 'foo' (line 1 column 33):
+my_stream := (unnest f1 as item foo)
+                                ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
+func TestParseDecodeJSON(t *testing.T) {
+	input := "my_stream := (decode_json schema = (a string, b int, c decimal(10, 2)))"
+	expected := CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&DecodeJSONDesc{
+				Column:      "val",
+				ColumnNames: []string{"a", "b", "c"},
+				ColumnTypes: []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt,
+					&types.DecimalType{Precision: 10, Scale: 2}},
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = "my_stream := (decode_json strict = true column = payload schema (tags array<string>, attrs map<string,int>, pos struct<x:float,y:float>))"
+	expected = CreateStreamDesc{
+		StreamName: "my_stream",
+		OperatorDescs: []Parseable{
+			&DecodeJSONDesc{
+				Column:      "payload",
+				ColumnNames: []string{"tags", "attrs", "pos"},
+				ColumnTypes: []types.ColumnType{
+					&types.ArrayType{ElemType: types.ColumnTypeString},
+					&types.MapType{ValueType: types.ColumnTypeInt},
+					&types.StructType{FieldNames: []string{"x", "y"},
+						FieldTypes: []types.ColumnType{types.ColumnTypeFloat, types.ColumnTypeFloat}},
+				},
+				Strict: true,
+			},
+		},
+	}
+	testParseCreateStream(t, input, expected)
+
+	input = `(scan all from raw)->(decode_json schema = (ts timestamp))`
+	expectedQuery := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "raw",
+			All:       true,
+		},
+		&DecodeJSONDesc{
+			Column:      "val",
+			ColumnNames: []string{"ts"},
+			ColumnTypes: []types.ColumnType{types.ColumnTypeTimestamp},
+		},
+	}}
+	testParseQuery(t, input, expectedQuery)
+}
+
+func TestFailedToParseDecodeJSON(t *testing.T) {
+	input := "my_stream := (decode_json column = val)"
+	expectedMsg := `'schema' must be specified (line 1 column 39):
+my_stream := (decode_json column = val)
+                                      ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = (a foo))"
+	expectedMsg = `invalid type 'foo' for column 'a' (line 1 column 39):
+my_stream := (decode_json schema = (a foo))
+                                      ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = (a int, a string))"
+	expectedMsg = `column 'a' is duplicated (line 1 column 44):
+my_stream := (decode_json schema = (a int, a string))
+                                           ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = (a))"
+	expectedMsg = `expected column type but found ')' (line 1 column 38):
+my_stream := (decode_json schema = (a))
+                                     ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = a int)"
+	expectedMsg = `expected '(' but found 'a' (line 1 column 36):
+my_stream := (decode_json schema = a int)
+                                   ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = (a int) strict = true strict = false)"
+	expectedMsg = `argument 'strict' is duplicated (line 1 column 58):
+my_stream := (decode_json schema = (a int) strict = true strict = false)
+                                                         ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+
+	input = "my_stream := (decode_json schema = (a int) foo)"
+	expectedMsg = `expected one of: 'schema', 'column', 'strict', ')' but found 'foo' (line 1 column 44):
+my_stream := (decode_json schema = (a int) foo)
+                                           ^`
+	testFailedToParseCreateStream(t, input, expectedMsg)
+}
+
 func TestParseKafaIn(t *testing.T) {
 	input := "my_stream := (kafka in partitions 10)"
 	expected := CreateStreamDesc{
@@ -2261,6 +2776,33 @@ func TestParseGet(t *testing.T) {
 	testParseQuery(t, input, expected)
 }
 
+func TestParseGetWithIndex(t *testing.T) {
+	input := `(get "foo@bar.com" from some_table index by (email))`
+	expected := QueryDesc{OperatorDescs: []Parseable{
+		&GetDesc{
+			KeyExprs: []ExprDesc{
+				&StringConstExprDesc{Value: `foo@bar.com`},
+			},
+			TableName: "some_table",
+			IndexCols: []string{"email"},
+		},
+	}}
+	testParseQuery(t, input, expected)
+
+	input = `(get "smith", "bob" from some_table index by (last_name, first_name))`
+	expected = QueryDesc{OperatorDescs: []Parseable{
+		&GetDesc{
+			KeyExprs: []ExprDesc{
+				&StringConstExprDesc{Value: `smith`},
+				&StringConstExprDesc{Value: `bob`},
+			},
+			TableName: "some_table",
+			IndexCols: []string{"last_name", "first_name"},
+		},
+	}}
+	testParseQuery(t, input, expected)
+}
+
 func TestFailedToParseGet(t *testing.T) {
 	input := `(get`
 	expectedMsg := `reached end of statement`
@@ -2302,6 +2844,56 @@ func TestParseScanAll(t *testing.T) {
 	testParseQuery(t, input, expected)
 }
 
+func TestParseScanAsOf(t *testing.T) {
+	input := `(scan all from some_table as_of "2024-05-01T00:00:00Z")`
+	asOfTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			All:       true,
+			TableName: "some_table",
+			AsOfTime:  &asOfTime,
+		},
+	}}
+	testParseQuery(t, input, expected)
+
+	input = `(scan "val1" to "val9" from some_table as_of = 1234)`
+	asOfVersion := int64(1234)
+	expected = QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			FromKeyExprs: []ExprDesc{
+				&StringConstExprDesc{Value: "val1"},
+			},
+			ToKeyExprs: []ExprDesc{
+				&StringConstExprDesc{Value: "val9"},
+			},
+			FromIncl:    true,
+			TableName:   "some_table",
+			AsOfVersion: &asOfVersion,
+		},
+	}}
+	testParseQuery(t, input, expected)
+}
+
+func TestFailedToParseScanAsOf(t *testing.T) {
+	input := `(scan all from some_table as_of "yesterday")`
+	expectedMsg := `invalid timestamp - must be in RFC3339 format (line 1 column 33):
+(scan all from some_table as_of "yesterday")
+                                ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(scan all from some_table as_of foo)`
+	expectedMsg = `expected '=' or string literal or integer but found 'foo' (line 1 column 33):
+(scan all from some_table as_of foo)
+                                ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(scan all from some_table as_of)`
+	expectedMsg = `expected '=' or string literal or integer but found ')' (line 1 column 32):
+(scan all from some_table as_of)
+                               ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+}
+
 func TestParseScan(t *testing.T) {
 	input := `(scan "val1" to "val9" from some_table)`
 	expected := QueryDesc{OperatorDescs: []Parseable{
@@ -2684,6 +3276,167 @@ func TestMultipleQueryOperators(t *testing.T) {
 	testParseQuery(t, input, expected)
 }
 
+func TestParseLimit(t *testing.T) {
+	input := `(scan all from some_table)->(limit 10)`
+	expected := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "some_table",
+			All:       true,
+		},
+		&LimitDesc{
+			Limit: 10,
+		},
+	}}
+	testParseQuery(t, input, expected)
+
+	input = `(scan all from some_table)->(sort by f1)->(limit 10 offset = 20)`
+	expected = QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "some_table",
+			All:       true,
+		},
+		&SortDesc{
+			SortExprs: []ExprDesc{
+				&IdentifierExprDesc{IdentifierName: "f1"},
+			},
+		},
+		&LimitDesc{
+			Limit:  10,
+			Offset: 20,
+		},
+	}}
+	testParseQuery(t, input, expected)
+}
+
+func TestFailedToParseLimit(t *testing.T) {
+	input := `(limit)`
+	expectedMsg := `expected integer but found ')' (line 1 column 7):
+(limit)
+      ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(limit 0)`
+	expectedMsg = `limit must be a positive integer (line 1 column 8):
+(limit 0)
+       ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(limit 10 offset foo)`
+	expectedMsg = `expected '=' or integer but found 'foo' (line 1 column 18):
+(limit 10 offset foo)
+                 ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(limit 10 offset 1 offset 2)`
+	expectedMsg = `argument 'offset' is duplicated (line 1 column 20):
+(limit 10 offset 1 offset 2)
+                   ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(limit 10 foo)`
+	expectedMsg = `expected one of: 'offset', ')' but found 'foo' (line 1 column 11):
+(limit 10 foo)
+          ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+}
+
+func TestParseQueryJoin(t *testing.T) {
+	input := `(scan all from orders)->(join customers by cust_id = id)`
+	expected := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "orders",
+			All:       true,
+		},
+		&QueryJoinDesc{
+			TableName: "customers",
+			JoinElements: []JoinElement{
+				{LeftCol: "cust_id", JoinType: "=", RightCol: "id"},
+			},
+		},
+	}}
+	testParseQuery(t, input, expected)
+
+	input = `(scan all from orders)->(join customers by cust_id *= id, country *= country strategy = broadcast)`
+	expected = QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "orders",
+			All:       true,
+		},
+		&QueryJoinDesc{
+			TableName: "customers",
+			JoinElements: []JoinElement{
+				{LeftCol: "cust_id", JoinType: "*=", RightCol: "id"},
+				{LeftCol: "country", JoinType: "*=", RightCol: "country"},
+			},
+			Strategy: "broadcast",
+		},
+	}}
+	testParseQuery(t, input, expected)
+}
+
+func TestFailedToParseQueryJoin(t *testing.T) {
+	input := `(join customers)`
+	expectedMsg := `expected 'by' but found ')' (line 1 column 16):
+(join customers)
+               ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(join customers by)`
+	expectedMsg = `there must be at least one join column expression (line 1 column 19):
+(join customers by)
+                  ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(join customers by cust_id = id strategy = hash)`
+	expectedMsg = `expected one of: 'lookup', 'broadcast' but found 'hash' (line 1 column 44):
+(join customers by cust_id = id strategy = hash)
+                                           ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+
+	input = `(join customers by cust_id = id strategy = lookup foo)`
+	expectedMsg = `expected ')' but found 'foo' (line 1 column 51):
+(join customers by cust_id = id strategy = lookup foo)
+                                                  ^`
+	testFailedToParseQuery(t, input, expectedMsg)
+}
+
+func TestParseQueryAggregate(t *testing.T) {
+	input := `(scan all from some_table)->(aggregate count(f1), sum(f2) as tot by f3)->(sort by f3)`
+	expected := QueryDesc{OperatorDescs: []Parseable{
+		&ScanDesc{
+			TableName: "some_table",
+			All:       true,
+		},
+		&AggregateDesc{
+			AggregateExprs: []ExprDesc{
+				&FunctionExprDesc{
+					FunctionName: "count",
+					Aggregate:    true,
+					ArgExprs:     []ExprDesc{&IdentifierExprDesc{IdentifierName: "f1"}},
+				},
+				&BinaryOperatorExprDesc{
+					Left: &FunctionExprDesc{
+						FunctionName: "sum",
+						Aggregate:    true,
+						ArgExprs:     []ExprDesc{&IdentifierExprDesc{IdentifierName: "f2"}},
+					},
+					Right: &IdentifierExprDesc{IdentifierName: "tot"},
+					Op:    "as",
+				},
+			},
+			AggregateExprStrings: []string{"count(f1)", "sum(f2) as tot"},
+			KeyExprs:             []ExprDesc{&IdentifierExprDesc{IdentifierName: "f3"}},
+			KeyExprsStrings:      []string{"f3"},
+		},
+		&SortDesc{
+			SortExprs: []ExprDesc{
+				&IdentifierExprDesc{IdentifierName: "f3"},
+			},
+		},
+	}}
+	testParseQuery(t, input, expected)
+}
+
 func testParseDeleteStream(t *testing.T, input string, expected DeleteStreamDesc) {
 	cs := NewDeleteStreamDesc()
 	err := NewParser(nil).Parse(input, cs)
@@ -2854,6 +3607,40 @@ func TestParsePrepare(t *testing.T) {
 	testParseTSL(t, input, expected)
 }
 
+func TestParsePrepareWithDeclaredParams(t *testing.T) {
+	// The declared order of the params is the order of the arguments, and params can be used more than once
+	input := `prepare my_query($p2:string, $p1:int) := (scan all from some_table)->(filter by k == $p1:int && v == $p2:string && w == $p1:int)`
+	tsl, err := NewParser(nil).ParseTSL(input)
+	require.NoError(t, err)
+	require.Equal(t, []PreparedStatementParam{
+		{ParamName: "$p2:string", ParamType: types.ColumnTypeString},
+		{ParamName: "$p1:int", ParamType: types.ColumnTypeInt},
+	}, tsl.PrepareQuery.Params)
+
+	tsl, err = NewParser(nil).ParseTSL(`prepare my_query() := (scan all from some_table)`)
+	require.NoError(t, err)
+	require.Equal(t, []PreparedStatementParam{}, tsl.PrepareQuery.Params)
+}
+
+func TestFailedToParsePrepareWithDeclaredParams(t *testing.T) {
+	testFailedToParseTSL(t, `prepare my_query($p1:int) := (get $p2:int from some_table)`,
+		`prepared statement parameter '$p2:int' is not declared (line 1 column 35):
+prepare my_query($p1:int) := (get $p2:int from some_table)
+                                  ^`)
+	testFailedToParseTSL(t, `prepare my_query($p1:int, $p1:int) := (get $p1:int from some_table)`,
+		`prepared statement parameter '$p1:int' is duplicated (line 1 column 27):
+prepare my_query($p1:int, $p1:int) := (get $p1:int from some_table)
+                          ^`)
+	testFailedToParseTSL(t, `prepare my_query(foo) := (get $p1:int from some_table)`,
+		`expected prepared statement parameter but found 'foo' (line 1 column 18):
+prepare my_query(foo) := (get $p1:int from some_table)
+                 ^`)
+	testFailedToParseTSL(t, `prepare my_query($p1:int $p2:int) := (get $p1:int from some_table)`,
+		`expected one of: ',', ')' but found '$p2:int' (line 1 column 26):
+prepare my_query($p1:int $p2:int) := (get $p1:int from some_table)
+                         ^`)
+}
+
 func TestFailedToParseTSL(t *testing.T) {
 	expectedMsg := `statement is empty`
 	testFailedToParseTSL(t, ``, expectedMsg)
@@ -5,7 +5,10 @@ import (
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/errors"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ExprDesc interface {
@@ -98,6 +101,13 @@ type StringConstExprDesc struct {
 	Value string
 }
 
+// DurationConstExprDesc is an interval literal, e.g. 10s, 1h30m or 7d. It is a number of milliseconds, so it can be
+// used anywhere an int can, e.g. date_add(ts, 1h).
+type DurationConstExprDesc struct {
+	BaseExprDesc
+	Value time.Duration
+}
+
 type IdentifierExprDesc struct {
 	BaseExprDesc
 	IdentifierName string
@@ -155,6 +165,7 @@ func ExtractExpressionTokens(context *ParseContext) ([]lexer.Token, bool, error)
 	// a ')': e.g. (store table by = 3 + to_int(f2) )
 	// a ',': e.g. (project f3, f1 + 10, f7) - the first two expressions are terminated by ','
 	// an identifier that's not preceded by an operator: e.g. (aggregate sum(f1), count(to_lower(f2)) by f3) - the second agggregate expression is terminated by 'by'
+	// a '->': e.g. (split when f1 > 10 -> high, else -> low)
 	parensCount := 0
 	var prevToken *lexer.Token
 	var tokens []lexer.Token
@@ -184,6 +195,9 @@ loop:
 				context.NextToken()
 				break loop
 			}
+		case PipeTokenType:
+			// '->' is never part of an expression, e.g. (split when f1 > 10 -> high, else -> low)
+			break loop
 		}
 		prevToken = &token
 		tokens = append(tokens, token)
@@ -250,11 +264,12 @@ func (p *Parser) shuntExpression(context *ParseContext) ([]lexer.Token, error) {
 		case BinaryOpTokenType, UnaryOpTokenType:
 			if (tok.Value == "-" || tok.Value == "+") && (prevToken == nil || prevToken.Type == BinaryOpTokenType ||
 				prevToken.Type == UnaryOpTokenType || prevToken.Type == ListSeparatorTokenType) {
-				// The lexer lexes all '-' or '+' as binary operators including ones that are meant to represent number constants
-				// e.g. "-3" or "4 + -3", "4 - +3", so if we have a "-" or "+" followed by a number we treat it as a number constant not
-				// a binary op if it's the first token, or it's preceded by an operator.
+				// The lexer lexes all '-' or '+' as binary operators including ones that are meant to represent number
+				// or duration constants e.g. "-3" or "4 + -3", "4 - +3", "-1h", so if we have a "-" or "+" followed by a
+				// number or duration we treat it as a constant not a binary op if it's the first token, or it's preceded by
+				// an operator.
 				next, ok := context.PeekToken()
-				if ok && (next.Type == IntegerTokenType || next.Type == FloatTokenType) {
+				if ok && (next.Type == IntegerTokenType || next.Type == FloatTokenType || next.Type == DurationTokenType) {
 					context.NextToken()
 					if tok.Value == "-" {
 						numBytes := make([]byte, len(next.Value)+1)
@@ -430,6 +445,16 @@ func (p *Parser) parseExpression(tokens []lexer.Token, pos int, input string) (E
 		fe.tokenInfo.input = input
 		expr = fe
 		pos--
+	case DurationTokenType:
+		d, err := ParseDuration(tok.Value)
+		if err != nil {
+			return nil, 0, errorAtPosition("invalid duration literal", tok.Pos, input)
+		}
+		de := &DurationConstExprDesc{Value: d}
+		de.tokenInfo.token = tok
+		de.tokenInfo.input = input
+		expr = de
+		pos--
 	case StringLiteralTokenType:
 		unquoted, err := strconv.Unquote(tok.Value)
 		if err != nil {
@@ -472,6 +497,57 @@ func (p *Parser) parseExpression(tokens []lexer.Token, pos int, input string) (E
 	return expr, pos, nil
 }
 
+// ParseDuration parses a duration literal, e.g. 10s, 1h30m or -7d. It is like time.ParseDuration but also supports 'd'
+// for days. Days are always 24 hours - use date_add with a unit for calendar arithmetic. An error is returned if the
+// duration does not fit in a time.Duration.
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "-+")
+	var total time.Duration
+	for len(s) > 0 {
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if i <= 0 {
+			return 0, errors.Errorf("invalid duration '%s'", s)
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			// The number is all digits, so it can only be out of range
+			return 0, errors.Errorf("duration '%s' is out of range", orig)
+		}
+		s = s[i:]
+		var unit time.Duration
+		switch {
+		case strings.HasPrefix(s, "ms"):
+			unit, s = time.Millisecond, s[2:]
+		case strings.HasPrefix(s, "s"):
+			unit, s = time.Second, s[1:]
+		case strings.HasPrefix(s, "m"):
+			unit, s = time.Minute, s[1:]
+		case strings.HasPrefix(s, "h"):
+			unit, s = time.Hour, s[1:]
+		case strings.HasPrefix(s, "d"):
+			unit, s = 24*time.Hour, s[1:]
+		default:
+			return 0, errors.Errorf("invalid duration unit in '%s'", s)
+		}
+		if n > int64(math.MaxInt64/unit) {
+			return 0, errors.Errorf("duration '%s' is out of range", orig)
+		}
+		d := time.Duration(n) * unit
+		if total > math.MaxInt64-d {
+			return 0, errors.Errorf("duration '%s' is out of range", orig)
+		}
+		total += d
+	}
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
+
 func (p *Parser) isFunction(functionName string) bool {
 	_, ok := BuiltinFunctions[functionName]
 	if ok {
@@ -482,7 +558,8 @@ func (p *Parser) isFunction(functionName string) bool {
 		return true
 	}
 	if p.externalFunctionChecker != nil {
-		return p.externalFunctionChecker.FunctionExists(functionName)
+		return p.externalFunctionChecker.FunctionExists(functionName) ||
+			p.externalFunctionChecker.AggregateFunctionExists(functionName)
 	}
 	return false
 }
@@ -497,6 +574,9 @@ func (p *Parser) createFunctionExpression(tokens []lexer.Token, pos int, input s
 	}
 	if !isNonAggFunction {
 		_, ok := AggregateFunctions[funcName]
+		if !ok && p.externalFunctionChecker != nil {
+			ok = p.externalFunctionChecker.AggregateFunctionExists(funcName)
+		}
 		if !ok {
 			msg := fmt.Sprintf("unknown function '%s'", funcName)
 			return nil, 0, errorAtPosition(msg, tok.Pos, input)
@@ -714,6 +794,8 @@ var BuiltinFunctions = map[string]struct{}{
 	"is_not_null": {},
 	"in":          {},
 	"case":        {},
+	"coalesce":    {},
+	"nullif":      {},
 
 	"decimal_shift": {},
 
@@ -731,6 +813,15 @@ var BuiltinFunctions = map[string]struct{}{
 	"replace":     {},
 	"sprintf":     {},
 
+	"regexp_extract": {},
+	"regexp_replace": {},
+	"split":          {},
+	"split_part":     {},
+	"lpad":           {},
+	"rpad":           {},
+	"index_of":       {},
+	"url_parse":      {},
+
 	"to_int":       {},
 	"to_float":     {},
 	"to_string":    {},
@@ -749,6 +840,13 @@ var BuiltinFunctions = map[string]struct{}{
 	"millis":      {},
 	"now":         {},
 
+	"date_trunc":   {},
+	"date_add":     {},
+	"date_diff":    {},
+	"to_timezone":  {},
+	"day_of_week":  {},
+	"week_of_year": {},
+
 	"json_int":     {},
 	"json_float":   {},
 	"json_bool":    {},
@@ -756,6 +854,11 @@ var BuiltinFunctions = map[string]struct{}{
 	"json_raw":     {},
 	"json_is_null": {},
 	"json_type":    {},
+	"json_object":  {},
+	"json_array":   {},
+	"to_json":      {},
+	"json_query":   {},
+	"parse_json":   {},
 
 	"kafka_build_headers": {},
 	"kafka_header":        {},
@@ -764,5 +867,41 @@ var BuiltinFunctions = map[string]struct{}{
 	"uint64_be":   {},
 	"uint64_le":   {},
 
-	"abs": {},
+	"abs":      {},
+	"round":    {},
+	"floor":    {},
+	"ceil":     {},
+	"pow":      {},
+	"sqrt":     {},
+	"log":      {},
+	"exp":      {},
+	"sign":     {},
+	"greatest": {},
+	"least":    {},
+
+	"md5":           {},
+	"sha256":        {},
+	"murmur3":       {},
+	"xxhash":        {},
+	"base64_encode": {},
+	"base64_decode": {},
+	"hex":           {},
+	"uuid_v4":       {},
+
+	"avro_decode":     {},
+	"avro_encode":     {},
+	"protobuf_decode": {},
+	"protobuf_encode": {},
+
+	"lookup": {},
+
+	"array":        {},
+	"map":          {},
+	"named_struct": {},
+	"element_at":   {},
+	"get_field":    {},
+	"size":         {},
+	"map_keys":     {},
+	"map_values":   {},
+	"explode":      {},
 }
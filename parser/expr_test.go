@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"fmt"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/stretchr/testify/require"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestShunting(t *testing.T) {
@@ -109,6 +111,28 @@ func TestParseConstantExpression(t *testing.T) {
 		},
 	)
 
+	testParseExpression(t, "10s", &DurationConstExprDesc{Value: 10 * time.Second})
+	testParseExpression(t, "250ms", &DurationConstExprDesc{Value: 250 * time.Millisecond})
+	testParseExpression(t, "7d", &DurationConstExprDesc{Value: 7 * 24 * time.Hour})
+	testParseExpression(t, "1h30m", &DurationConstExprDesc{Value: 90 * time.Minute})
+	testParseExpression(t, "1d12h", &DurationConstExprDesc{Value: 36 * time.Hour})
+	testParseExpression(t, "-1h", &DurationConstExprDesc{Value: -time.Hour})
+	testParseExpression(t, "+5m", &DurationConstExprDesc{Value: 5 * time.Minute})
+	testParseExpression(t, "date_add(f1,-1d)",
+		&FunctionExprDesc{
+			FunctionName: "date_add",
+			ArgExprs: []ExprDesc{
+				&IdentifierExprDesc{IdentifierName: "f1"},
+				&DurationConstExprDesc{Value: -24 * time.Hour},
+			},
+		},
+	)
+	testParseExpression(t, "f1 - 1h", &BinaryOperatorExprDesc{
+		Left:  &IdentifierExprDesc{IdentifierName: "f1"},
+		Right: &DurationConstExprDesc{Value: time.Hour},
+		Op:    "-",
+	})
+
 	testParseExpression(t, "true", &BoolConstExprDesc{Value: true})
 	testParseExpression(t, "false", &BoolConstExprDesc{Value: false})
 
@@ -138,6 +162,29 @@ func TestParseSimpleBinaryExpression(t *testing.T) {
 	testParseExpression(t, "1 + 2", expected)
 }
 
+func TestParseDuration(t *testing.T) {
+	d, err := ParseDuration("2d3h4m5s6ms")
+	require.NoError(t, err)
+	require.Equal(t, 2*24*time.Hour+3*time.Hour+4*time.Minute+5*time.Second+6*time.Millisecond, d)
+	d, err = ParseDuration("-90s")
+	require.NoError(t, err)
+	require.Equal(t, -90*time.Second, d)
+	_, err = ParseDuration("10x")
+	require.Error(t, err)
+	_, err = ParseDuration("h")
+	require.Error(t, err)
+
+	// The largest duration is just under 106752 days
+	d, err = ParseDuration("106751d23h47m16s")
+	require.NoError(t, err)
+	require.Equal(t, 106751*24*time.Hour+23*time.Hour+47*time.Minute+16*time.Second, d)
+	for _, s := range []string{"106752d", "106751d23h48m", "9223372036854775808ms", "-106752d"} {
+		_, err = ParseDuration(s)
+		require.Error(t, err)
+		require.Equal(t, fmt.Sprintf("duration '%s' is out of range", s), err.Error())
+	}
+}
+
 func TestParseSimpleBinaryExpressionWithIdentifierAndFloat(t *testing.T) {
 	expected := &BinaryOperatorExprDesc{
 		Left:  &IdentifierExprDesc{IdentifierName: "$x"},
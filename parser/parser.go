@@ -9,12 +9,12 @@ import (
 
 var lex = lexer.MustSimple([]lexer.SimpleRule{
 	{"StreamAssignment", `:=`},
-	{"Duration", `(?:[1-9][0-9]*(?:ms|s|m|h|d))`},
+	{"Duration", `(?:[1-9][0-9]*(?:ms|s|m|h|d)(?:[0-9]+(?:ms|s|m|h|d))*)`},
 	{"Pipe", `->`},
 	// Note - there is ambiguity for "==" as this appears is a valid expr, so we omit it from JoinType
 	{"JoinType", `(?:\*=|=\*)`},
 	{"UnaryPostfixOp", `(?:ascending|asc|descending|desc)`},
-	{"BinaryOp", `(?:as|==|!=|<=|>=|&&|\|\||[-+\*/%<>])`},
+	{"BinaryOp", `(?:as\b|==|!=|<=|>=|&&|\|\||[-+\*/%<>])`},
 	{"UnaryOp", `!`},
 	{"ArgAssignment", `=`},
 	{"BoolLiteral", `(?:true|false)`},
@@ -75,6 +75,7 @@ func NewParser(externalFunctionChecker ExternalFunctionChecker) *Parser {
 
 type ExternalFunctionChecker interface {
 	FunctionExists(functionName string) bool
+	AggregateFunctionExists(functionName string) bool
 }
 
 type Parser struct {
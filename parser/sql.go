@@ -0,0 +1,1570 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SQL statements are compiled to the equivalent TSL, which is then parsed into the same descriptors as a TSL query. This
+// means a SQL query is planned and executed in exactly the same way as a TSL query, and the TSL can be sent to other
+// nodes in place of the SQL.
+//
+// The supported dialect is:
+//
+//	SELECT * | expr [[AS] alias], ...
+//	FROM table [[AS] alias]
+//	[[INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON a.col = b.col [AND ...]]
+//	[WHERE expr]
+//	[GROUP BY col, ...]
+//	[HAVING expr]
+//	[ORDER BY col | alias | ordinal [ASC | DESC], ...]
+//	[LIMIT n [OFFSET m]]
+//
+// and prepared queries:
+//
+//	PREPARE name [(type, ...)] AS select
+//
+// where the params are referenced in the query as $1, $2, etc.
+
+var sqlLex = lexer.MustSimple([]lexer.SimpleRule{
+	{"Whitespace", `[ \t\n\r]+`},
+	{"Comment", `--[^\n]*`},
+	{"String", `'(?:''|[^'])*'`},
+	{"QuotedIdent", `"(?:""|[^"])*"`},
+	{"Param", `\$[1-9][0-9]*`},
+	{"Float", `(?:\d+\.\d*|\.\d+)(?:[eE][-+]?\d+)?|\d+[eE][-+]?\d+`},
+	{"Integer", `\d+`},
+	{"Ident", `[a-zA-Z_][a-zA-Z0-9_]*`},
+	{"Operator", `(?:<>|!=|<=|>=|[=<>+\-*/%])`},
+	{"Punct", `[(),.;]`},
+})
+
+var sqlWhitespaceTokenType = sqlLex.Symbols()["Whitespace"]
+var sqlCommentTokenType = sqlLex.Symbols()["Comment"]
+var sqlStringTokenType = sqlLex.Symbols()["String"]
+var sqlQuotedIdentTokenType = sqlLex.Symbols()["QuotedIdent"]
+var sqlParamTokenType = sqlLex.Symbols()["Param"]
+var sqlFloatTokenType = sqlLex.Symbols()["Float"]
+var sqlIntegerTokenType = sqlLex.Symbols()["Integer"]
+var sqlIdentTokenType = sqlLex.Symbols()["Ident"]
+
+// sqlKeywords are the reserved words of the SQL dialect - they cannot be used as unquoted identifiers
+var sqlKeywords = map[string]struct{}{
+	"select": {}, "from": {}, "where": {}, "group": {}, "by": {}, "having": {}, "order": {}, "limit": {},
+	"offset": {}, "join": {}, "inner": {}, "left": {}, "right": {}, "full": {}, "outer": {}, "cross": {}, "on": {},
+	"as": {}, "and": {}, "or": {}, "not": {}, "is": {}, "null": {}, "in": {}, "between": {}, "asc": {}, "desc": {},
+	"true": {}, "false": {}, "prepare": {}, "distinct": {}, "union": {},
+}
+
+var sqlComparisonOps = map[string]string{
+	"=":  "==",
+	"<>": "!=",
+	"!=": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// SQLDesc is the result of parsing a SQL statement. Exactly one of Query and PrepareQuery is set.
+type SQLDesc struct {
+	// TSL is the TSL statement that the SQL compiles to
+	TSL          string
+	Query        *QueryDesc
+	PrepareQuery *PrepareQueryDesc
+	sql          string
+	tokens       []lexer.Token
+}
+
+var tslErrorPositionRegex = regexp.MustCompile(`(?s)^(.*?) \(line (\d+) column (\d+)\):\n(.*)$`)
+
+var tslParamNameRegex = regexp.MustCompile(`^\$p(\d+):`)
+
+// MapError maps an error at a position in the TSL the SQL compiles to, such as an error preparing or executing the
+// query, to an error at the token in the SQL that the TSL at that position was generated from. If there is no such
+// token, the error is returned without the position, as the TSL is not something the user wrote. Errors that are not
+// at a position in the TSL are returned unchanged.
+func (d *SQLDesc) MapError(err error) error {
+	var terr errors.TektiteError
+	if err == nil || !errors.As(err, &terr) {
+		return err
+	}
+	matches := tslErrorPositionRegex.FindStringSubmatch(terr.Msg)
+	if matches == nil || !strings.HasPrefix(matches[4], d.TSL) {
+		return err
+	}
+	msg := matches[1]
+	line, _ := strconv.Atoi(matches[2])
+	column, _ := strconv.Atoi(matches[3])
+	if tok, ok := d.sqlTokenAt(line, column); ok {
+		terr.Msg = MessageWithPosition(msg, tok.Pos, d.sql)
+	} else {
+		terr.Msg = msg
+	}
+	return terr
+}
+
+// sqlTokenAt returns the SQL token that the TSL token at the position was generated from
+func (d *SQLDesc) sqlTokenAt(line int, column int) (lexer.Token, bool) {
+	tslTokens, err := Lex(d.TSL, true)
+	if err != nil {
+		return lexer.Token{}, false
+	}
+	var tslValue string
+	for _, tok := range tslTokens {
+		if tok.Pos.Line == line && tok.Pos.Column == column {
+			tslValue = tok.Value
+			break
+		}
+	}
+	if tslValue == "" {
+		return lexer.Token{}, false
+	}
+	if m := tslParamNameRegex.FindStringSubmatch(tslValue); m != nil {
+		for _, tok := range d.tokens {
+			if tok.Type == sqlParamTokenType && tok.Value[1:] == m[1] {
+				return tok, true
+			}
+		}
+		return lexer.Token{}, false
+	}
+	// The columns of a join are prefixed with l_ and r_ after the join
+	candidates := []string{tslValue}
+	if strings.HasPrefix(tslValue, "l_") || strings.HasPrefix(tslValue, "r_") {
+		candidates = append(candidates, tslValue[2:])
+	}
+	for _, candidate := range candidates {
+		for _, tok := range d.tokens {
+			if (tok.Type == sqlIdentTokenType || tok.Type == sqlQuotedIdentTokenType) && !isSQLKeyword(tok) &&
+				strings.EqualFold(sqlIdentifierValue(tok), candidate) {
+				return tok, true
+			}
+		}
+	}
+	return lexer.Token{}, false
+}
+
+func (p *Parser) ParseSQL(input string) (*SQLDesc, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, errors.NewTektiteErrorf(errors.ParseError, "statement is empty")
+	}
+	tokens, err := lexSQL(input)
+	if err != nil {
+		return nil, err
+	}
+	sp := &sqlParser{input: input, tokens: tokens}
+	tsl, prepare, err := sp.compileStatement()
+	if err != nil {
+		return nil, err
+	}
+	desc := &SQLDesc{TSL: tsl, sql: input, tokens: tokens}
+	if prepare {
+		tslDesc, err := p.ParseTSL(tsl)
+		if err != nil {
+			return nil, desc.MapError(err)
+		}
+		desc.PrepareQuery = tslDesc.PrepareQuery
+	} else {
+		desc.Query, err = p.ParseQuery(tsl)
+		if err != nil {
+			return nil, desc.MapError(err)
+		}
+	}
+	return desc, nil
+}
+
+// ParsePreparedSQL parses a SQL query that references the params $1..$n, where the types of the params are given by
+// paramTypes, and returns the prepared query with the name queryName. This is used when the param types are known from
+// outside the SQL, e.g. when a client of the PostgreSQL wire protocol prepares a statement.
+func (p *Parser) ParsePreparedSQL(queryName string, paramTypes []types.ColumnType, input string) (*SQLDesc, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, errors.NewTektiteErrorf(errors.ParseError, "statement is empty")
+	}
+	tokens, err := lexSQL(input)
+	if err != nil {
+		return nil, err
+	}
+	sp := &sqlParser{input: input, tokens: tokens, prepare: true, paramTypes: []string{}}
+	for _, paramType := range paramTypes {
+		sp.paramTypes = append(sp.paramTypes, paramType.String())
+	}
+	query, err := sp.compileQuery()
+	if err != nil {
+		return nil, err
+	}
+	tsl := sp.prepareHeader(queryName) + query
+	desc := &SQLDesc{TSL: tsl, sql: input, tokens: tokens}
+	tslDesc, err := p.ParseTSL(tsl)
+	if err != nil {
+		return nil, desc.MapError(err)
+	}
+	desc.PrepareQuery = tslDesc.PrepareQuery
+	return desc, nil
+}
+
+// SQLParamCount returns the number of params referenced by a SQL query, i.e. the highest n of the params $1..$n
+func SQLParamCount(input string) (int, error) {
+	tokens, err := lexSQL(input)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, token := range tokens {
+		if token.Type == sqlParamTokenType {
+			index, err := strconv.Atoi(token.Value[1:])
+			if err != nil {
+				return 0, errorAtPosition("invalid parameter", token.Pos, input)
+			}
+			count = max(count, index)
+		}
+	}
+	return count, nil
+}
+
+func lexSQL(input string) ([]lexer.Token, error) {
+	l, err := sqlLex.Lex("", strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	var tokens []lexer.Token
+	for {
+		token, err := l.Next()
+		if err != nil {
+			var le *lexer.Error
+			if errors.As(err, &le) && strings.Contains(le.Error(), "invalid input text") {
+				return nil, errorAtPosition("invalid statement", le.Pos, input)
+			}
+			return nil, err
+		}
+		if token.Type == lexer.EOF {
+			return tokens, nil
+		}
+		if token.Type != sqlWhitespaceTokenType && token.Type != sqlCommentTokenType {
+			tokens = append(tokens, token)
+		}
+	}
+}
+
+type sqlExpr interface{}
+
+type sqlColumnRef struct {
+	qualifier string
+	name      string
+	token     lexer.Token
+}
+
+type sqlLiteral struct {
+	tsl string
+}
+
+type sqlParamRef struct {
+	index int
+	token lexer.Token
+}
+
+type sqlBinaryExpr struct {
+	op    string
+	left  sqlExpr
+	right sqlExpr
+}
+
+type sqlNotExpr struct {
+	operand sqlExpr
+}
+
+type sqlIsNullExpr struct {
+	operand sqlExpr
+	not     bool
+}
+
+type sqlFuncCall struct {
+	name  string
+	args  []sqlExpr
+	star  bool
+	token lexer.Token
+}
+
+func (f *sqlFuncCall) isAggregate() bool {
+	_, ok := AggregateFunctions[f.name]
+	return ok
+}
+
+type sqlSelectItem struct {
+	expr  sqlExpr
+	alias string
+}
+
+type sqlTableRef struct {
+	name  string
+	alias string
+	token lexer.Token
+}
+
+func (t *sqlTableRef) matches(qualifier string) bool {
+	if t.alias != "" {
+		return qualifier == t.alias
+	}
+	return qualifier == t.name
+}
+
+type sqlJoin struct {
+	table     sqlTableRef
+	leftOuter bool
+	on        sqlExpr
+	onToken   lexer.Token
+}
+
+type sqlGroupItem struct {
+	expr  sqlExpr
+	token lexer.Token
+}
+
+type sqlOrderItem struct {
+	expr       sqlExpr
+	descending bool
+	token      lexer.Token
+}
+
+type sqlSelect struct {
+	star    bool
+	items   []sqlSelectItem
+	table   sqlTableRef
+	join    *sqlJoin
+	where   sqlExpr
+	groupBy []sqlGroupItem
+	having  sqlExpr
+	orderBy []sqlOrderItem
+	limit   int
+	offset  int
+}
+
+type sqlParser struct {
+	input      string
+	tokens     []lexer.Token
+	pos        int
+	paramTypes []string
+	prepare    bool
+}
+
+func (s *sqlParser) peek() (lexer.Token, bool) {
+	if s.pos == len(s.tokens) {
+		return lexer.Token{}, false
+	}
+	return s.tokens[s.pos], true
+}
+
+func (s *sqlParser) next() (lexer.Token, error) {
+	if s.pos == len(s.tokens) {
+		return lexer.Token{}, endOfInputError()
+	}
+	tok := s.tokens[s.pos]
+	s.pos++
+	return tok, nil
+}
+
+func (s *sqlParser) peekIs(values ...string) bool {
+	tok, ok := s.peek()
+	if !ok {
+		return false
+	}
+	for _, value := range values {
+		if isSQLToken(tok, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptToken consumes the next token if it is the given keyword or symbol
+func (s *sqlParser) acceptToken(value string) bool {
+	if s.peekIs(value) {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *sqlParser) expectToken(expected ...string) (lexer.Token, error) {
+	tok, err := s.next()
+	if err != nil {
+		return lexer.Token{}, err
+	}
+	for _, value := range expected {
+		if isSQLToken(tok, value) {
+			return tok, nil
+		}
+	}
+	return lexer.Token{}, foundUnexpectedTokenError(expectedStr(expected...), tok, s.input)
+}
+
+func isSQLToken(tok lexer.Token, value string) bool {
+	if tok.Type == sqlIdentTokenType {
+		// keywords are case-insensitive
+		return strings.EqualFold(tok.Value, value)
+	}
+	return tok.Type != sqlStringTokenType && tok.Type != sqlQuotedIdentTokenType && tok.Value == value
+}
+
+func isSQLKeyword(tok lexer.Token) bool {
+	if tok.Type != sqlIdentTokenType {
+		return false
+	}
+	_, ok := sqlKeywords[strings.ToLower(tok.Value)]
+	return ok
+}
+
+func (s *sqlParser) errorAt(tok lexer.Token, msg string, args ...any) error {
+	return errorAtPosition(fmt.Sprintf(msg, args...), tok.Pos, s.input)
+}
+
+func (s *sqlParser) parseIdentifier() (string, lexer.Token, error) {
+	tok, err := s.next()
+	if err != nil {
+		return "", lexer.Token{}, err
+	}
+	var name string
+	switch {
+	case tok.Type == sqlQuotedIdentTokenType:
+		name = sqlIdentifierValue(tok)
+	case tok.Type == sqlIdentTokenType && !isSQLKeyword(tok):
+		name = tok.Value
+	default:
+		return "", lexer.Token{}, foundUnexpectedTokenError("identifier", tok, s.input)
+	}
+	// Identifiers are written into the TSL as they are, so they must be TSL identifiers too
+	if !isTSLIdentifier(name) {
+		return "", lexer.Token{}, s.errorAt(tok, "'%s' cannot be used as an identifier - it is not a valid identifier in the TSL the query compiles to",
+			name)
+	}
+	return name, tok, nil
+}
+
+func sqlIdentifierValue(tok lexer.Token) string {
+	if tok.Type == sqlQuotedIdentTokenType {
+		return strings.ReplaceAll(tok.Value[1:len(tok.Value)-1], `""`, `"`)
+	}
+	return tok.Value
+}
+
+// isTSLIdentifier returns true if the name is lexed as a single TSL identifier, which is not a param
+func isTSLIdentifier(name string) bool {
+	tokens, err := Lex(name, false)
+	return err == nil && len(tokens) == 1 && tokens[0].Type == IdentTokenType && tokens[0].Value == name &&
+		!strings.ContainsAny(name, "$:")
+}
+
+func (s *sqlParser) peekIdentifier() bool {
+	tok, ok := s.peek()
+	return ok && (tok.Type == sqlQuotedIdentTokenType || (tok.Type == sqlIdentTokenType && !isSQLKeyword(tok)))
+}
+
+func (s *sqlParser) compileStatement() (string, bool, error) {
+	var sb strings.Builder
+	if s.acceptToken("prepare") {
+		s.prepare = true
+		name, _, err := s.parseIdentifier()
+		if err != nil {
+			return "", false, err
+		}
+		if s.acceptToken("(") {
+			if err := s.parseParamTypes(); err != nil {
+				return "", false, err
+			}
+		}
+		if _, err := s.expectToken("as"); err != nil {
+			return "", false, err
+		}
+		sb.WriteString(s.prepareHeader(name))
+	}
+	query, err := s.compileQuery()
+	if err != nil {
+		return "", false, err
+	}
+	sb.WriteString(query)
+	return sb.String(), s.prepare, nil
+}
+
+// prepareHeader returns the start of the TSL prepare statement for a prepared query with the declared param types
+func (s *sqlParser) prepareHeader(queryName string) string {
+	var sb strings.Builder
+	sb.WriteString("prepare ")
+	sb.WriteString(queryName)
+	if s.paramTypes != nil {
+		sb.WriteRune('(')
+		for i, paramType := range s.paramTypes {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(sqlParamName(i+1, paramType))
+		}
+		sb.WriteRune(')')
+	}
+	sb.WriteString(" := ")
+	return sb.String()
+}
+
+// compileQuery compiles the remaining tokens, which must be a select, to a TSL query
+func (s *sqlParser) compileQuery() (string, error) {
+	sel, err := s.parseSelect()
+	if err != nil {
+		return "", err
+	}
+	s.acceptToken(";")
+	if tok, ok := s.peek(); ok {
+		return "", foundUnexpectedTokenError("end of statement", tok, s.input)
+	}
+	return s.compileSelect(sel)
+}
+
+func (s *sqlParser) parseParamTypes() error {
+	s.paramTypes = []string{}
+	if s.acceptToken(")") {
+		return nil
+	}
+	for {
+		tok, err := s.next()
+		if err != nil {
+			return err
+		}
+		typeName := strings.ToLower(tok.Value)
+		if typeName == "decimal" {
+			// decimal(precision, scale)
+			var sb strings.Builder
+			sb.WriteString("decimal")
+			for _, expected := range []string{"(", "", ",", "", ")"} {
+				tok, err := s.next()
+				if err != nil {
+					return err
+				}
+				if expected == "" && tok.Type != sqlIntegerTokenType {
+					return foundUnexpectedTokenError("integer", tok, s.input)
+				} else if expected != "" && tok.Value != expected {
+					return foundUnexpectedTokenError(expectedStr(expected), tok, s.input)
+				}
+				sb.WriteString(tok.Value)
+			}
+			typeName = sb.String()
+		}
+		if _, err := types.StringToColumnType(typeName); err != nil {
+			return s.errorAt(tok, "unknown parameter type '%s'. must be one of int, float, bool, decimal(p, s), string, bytes, timestamp",
+				tok.Value)
+		}
+		s.paramTypes = append(s.paramTypes, typeName)
+		tok, err = s.expectToken(",", ")")
+		if err != nil {
+			return err
+		}
+		if tok.Value == ")" {
+			return nil
+		}
+	}
+}
+
+func sqlParamName(index int, paramType string) string {
+	return fmt.Sprintf("$p%d:%s", index, paramType)
+}
+
+func (s *sqlParser) parseSelect() (*sqlSelect, error) {
+	if _, err := s.expectToken("select"); err != nil {
+		return nil, err
+	}
+	sel := &sqlSelect{}
+	if s.acceptToken("*") {
+		sel.star = true
+	} else {
+		for {
+			e, err := s.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := sqlSelectItem{expr: e}
+			if s.acceptToken("as") || s.peekIdentifier() {
+				item.alias, _, err = s.parseIdentifier()
+				if err != nil {
+					return nil, err
+				}
+			}
+			sel.items = append(sel.items, item)
+			if !s.acceptToken(",") {
+				break
+			}
+		}
+	}
+	if _, err := s.expectToken("from"); err != nil {
+		return nil, err
+	}
+	var err error
+	sel.table, err = s.parseTableRef()
+	if err != nil {
+		return nil, err
+	}
+	if s.peekIs("join", "inner", "left", "right", "full", "cross") {
+		sel.join, err = s.parseJoin()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.acceptToken("where") {
+		sel.where, err = s.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.acceptToken("group") {
+		if _, err := s.expectToken("by"); err != nil {
+			return nil, err
+		}
+		for {
+			tok, _ := s.peek()
+			e, err := s.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			sel.groupBy = append(sel.groupBy, sqlGroupItem{expr: e, token: tok})
+			if !s.acceptToken(",") {
+				break
+			}
+		}
+	}
+	if s.acceptToken("having") {
+		sel.having, err = s.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.acceptToken("order") {
+		if _, err := s.expectToken("by"); err != nil {
+			return nil, err
+		}
+		for {
+			tok, _ := s.peek()
+			e, err := s.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := sqlOrderItem{expr: e, token: tok}
+			if s.acceptToken("desc") {
+				item.descending = true
+			} else {
+				s.acceptToken("asc")
+			}
+			sel.orderBy = append(sel.orderBy, item)
+			if !s.acceptToken(",") {
+				break
+			}
+		}
+	}
+	if s.peekIs("limit") {
+		s.pos++
+		sel.limit, err = s.parsePositiveInt("limit")
+		if err != nil {
+			return nil, err
+		}
+		if s.acceptToken("offset") {
+			sel.offset, err = s.parsePositiveInt("offset")
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if tok, ok := s.peek(); ok && isSQLToken(tok, "offset") {
+		return nil, s.errorAt(tok, "OFFSET can only be used with LIMIT")
+	}
+	return sel, nil
+}
+
+func (s *sqlParser) parsePositiveInt(clause string) (int, error) {
+	tok, err := s.next()
+	if err != nil {
+		return 0, err
+	}
+	if tok.Type != sqlIntegerTokenType {
+		return 0, foundUnexpectedTokenError("integer", tok, s.input)
+	}
+	val, err := strconv.Atoi(tok.Value)
+	if err != nil || (clause == "limit" && val < 1) {
+		return 0, s.errorAt(tok, "%s must be a positive integer", clause)
+	}
+	return val, nil
+}
+
+func (s *sqlParser) parseTableRef() (sqlTableRef, error) {
+	name, tok, err := s.parseIdentifier()
+	if err != nil {
+		return sqlTableRef{}, err
+	}
+	// table names can be qualified, e.g. sys.streams
+	for s.acceptToken(".") {
+		part, _, err := s.parseIdentifier()
+		if err != nil {
+			return sqlTableRef{}, err
+		}
+		name = name + "." + part
+	}
+	ref := sqlTableRef{name: name, token: tok}
+	if s.acceptToken("as") || s.peekIdentifier() {
+		ref.alias, _, err = s.parseIdentifier()
+		if err != nil {
+			return sqlTableRef{}, err
+		}
+	}
+	return ref, nil
+}
+
+func (s *sqlParser) parseJoin() (*sqlJoin, error) {
+	join := &sqlJoin{}
+	tok, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(tok.Value) {
+	case "join":
+	case "inner":
+		if _, err := s.expectToken("join"); err != nil {
+			return nil, err
+		}
+	case "left":
+		s.acceptToken("outer")
+		if _, err := s.expectToken("join"); err != nil {
+			return nil, err
+		}
+		join.leftOuter = true
+	default:
+		return nil, s.errorAt(tok, "only inner joins and left outer joins are supported")
+	}
+	join.table, err = s.parseTableRef()
+	if err != nil {
+		return nil, err
+	}
+	join.onToken, err = s.expectToken("on")
+	if err != nil {
+		return nil, err
+	}
+	join.on, err = s.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if s.peekIs("join", "inner", "left", "right", "full", "cross") {
+		tok, _ := s.peek()
+		return nil, s.errorAt(tok, "only one join is supported in a query")
+	}
+	return join, nil
+}
+
+func (s *sqlParser) parseExprList() ([]sqlExpr, error) {
+	var exprs []sqlExpr
+	for {
+		e, err := s.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !s.acceptToken(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// Expressions are parsed with precedence, lowest first: OR, AND, NOT, comparisons, + and -, * / and %
+
+func (s *sqlParser) parseExpr() (sqlExpr, error) {
+	left, err := s.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for s.acceptToken("or") {
+		right, err := s.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlBinaryExpr{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (s *sqlParser) parseAnd() (sqlExpr, error) {
+	left, err := s.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for s.acceptToken("and") {
+		right, err := s.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlBinaryExpr{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (s *sqlParser) parseNot() (sqlExpr, error) {
+	if s.acceptToken("not") {
+		operand, err := s.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlNotExpr{operand: operand}, nil
+	}
+	return s.parseComparison()
+}
+
+func (s *sqlParser) parseComparison() (sqlExpr, error) {
+	left, err := s.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := s.peek()
+	if !ok {
+		return left, nil
+	}
+	if op, ok := sqlComparisonOps[tok.Value]; ok && tok.Type != sqlStringTokenType {
+		s.pos++
+		right, err := s.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlBinaryExpr{op: op, left: left, right: right}, nil
+	}
+	if s.acceptToken("is") {
+		not := s.acceptToken("not")
+		if _, err := s.expectToken("null"); err != nil {
+			return nil, err
+		}
+		return &sqlIsNullExpr{operand: left, not: not}, nil
+	}
+	not := false
+	if s.peekIs("not") && s.pos+1 < len(s.tokens) &&
+		(isSQLToken(s.tokens[s.pos+1], "in") || isSQLToken(s.tokens[s.pos+1], "between")) {
+		s.pos++
+		not = true
+	}
+	var e sqlExpr
+	if s.acceptToken("in") {
+		// x IN (a, b) is equivalent to x = a OR x = b
+		if _, err := s.expectToken("("); err != nil {
+			return nil, err
+		}
+		values, err := s.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.expectToken(")"); err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			eq := &sqlBinaryExpr{op: "==", left: left, right: value}
+			if e == nil {
+				e = eq
+			} else {
+				e = &sqlBinaryExpr{op: "||", left: e, right: eq}
+			}
+		}
+	} else if s.acceptToken("between") {
+		// x BETWEEN a AND b is equivalent to x >= a AND x <= b
+		lower, err := s.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.expectToken("and"); err != nil {
+			return nil, err
+		}
+		upper, err := s.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		e = &sqlBinaryExpr{op: "&&", left: &sqlBinaryExpr{op: ">=", left: left, right: lower},
+			right: &sqlBinaryExpr{op: "<=", left: left, right: upper}}
+	} else {
+		return left, nil
+	}
+	if not {
+		return &sqlNotExpr{operand: e}, nil
+	}
+	return e, nil
+}
+
+func (s *sqlParser) parseAdditive() (sqlExpr, error) {
+	left, err := s.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for s.peekIs("+", "-") {
+		tok, _ := s.next()
+		right, err := s.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlBinaryExpr{op: tok.Value, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (s *sqlParser) parseMultiplicative() (sqlExpr, error) {
+	left, err := s.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for s.peekIs("*", "/", "%") {
+		tok, _ := s.next()
+		right, err := s.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &sqlBinaryExpr{op: tok.Value, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (s *sqlParser) parsePrimary() (sqlExpr, error) {
+	tok, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.Type {
+	case sqlIntegerTokenType:
+		return &sqlLiteral{tsl: tok.Value}, nil
+	case sqlFloatTokenType:
+		return &sqlLiteral{tsl: tok.Value + "f"}, nil
+	case sqlStringTokenType:
+		str := strings.ReplaceAll(tok.Value[1:len(tok.Value)-1], "''", "'")
+		return &sqlLiteral{tsl: strconv.Quote(str)}, nil
+	case sqlParamTokenType:
+		return s.parseParamRef(tok)
+	case sqlQuotedIdentTokenType, sqlIdentTokenType:
+		if isSQLToken(tok, "true") || isSQLToken(tok, "false") {
+			return &sqlLiteral{tsl: strings.ToLower(tok.Value)}, nil
+		}
+		if isSQLToken(tok, "null") {
+			return nil, s.errorAt(tok, "NULL literals are not supported - use IS NULL or IS NOT NULL")
+		}
+		if isSQLKeyword(tok) {
+			return nil, foundUnexpectedTokenError("expression", tok, s.input)
+		}
+		s.pos--
+		name, _, err := s.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		if tok.Type == sqlIdentTokenType && s.peekIs("(") {
+			return s.parseFuncCall(name, tok)
+		}
+		ref := &sqlColumnRef{name: name, token: tok}
+		if s.acceptToken(".") {
+			ref.qualifier = name
+			ref.name, _, err = s.parseIdentifier()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return ref, nil
+	}
+	switch tok.Value {
+	case "(":
+		e, err := s.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.expectToken(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "-":
+		// negative numeric literal
+		next, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if next.Type == sqlIntegerTokenType {
+			return &sqlLiteral{tsl: "-" + next.Value}, nil
+		}
+		if next.Type == sqlFloatTokenType {
+			return &sqlLiteral{tsl: "-" + next.Value + "f"}, nil
+		}
+		return nil, s.errorAt(tok, "unary minus can only be applied to a numeric literal")
+	}
+	return nil, foundUnexpectedTokenError("expression", tok, s.input)
+}
+
+func (s *sqlParser) parseParamRef(tok lexer.Token) (sqlExpr, error) {
+	if !s.prepare {
+		return nil, s.errorAt(tok, "parameters can only be used in a PREPARE statement")
+	}
+	index, err := strconv.Atoi(tok.Value[1:])
+	if err != nil || index > len(s.paramTypes) {
+		return nil, s.errorAt(tok, "parameter %s has not been declared - declare the parameter types after the query name, e.g. PREPARE my_query (int, string) AS ...",
+			tok.Value)
+	}
+	return &sqlParamRef{index: index, token: tok}, nil
+}
+
+func (s *sqlParser) parseFuncCall(name string, tok lexer.Token) (sqlExpr, error) {
+	s.pos++ // the "("
+	call := &sqlFuncCall{name: strings.ToLower(name), token: tok}
+	if s.acceptToken("*") {
+		if call.name != "count" {
+			return nil, s.errorAt(tok, "'*' can only be used as the argument of count")
+		}
+		call.star = true
+	} else if !s.peekIs(")") {
+		var err error
+		call.args, err = s.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.expectToken(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// sqlScope resolves the column references in an expression to the names of the columns in the TSL query at the point
+// the expression is evaluated
+type sqlScope struct {
+	sel *sqlSelect
+	// afterJoin is true if the expression is evaluated on the output of the join
+	afterJoin bool
+	// rightKeyCols maps the join columns of the right table to the corresponding join columns of the left table
+	rightKeyCols map[string]string
+}
+
+func (c *sqlScope) resolve(ref *sqlColumnRef, s *sqlParser) (string, error) {
+	sel := c.sel
+	if sel.join == nil {
+		if ref.qualifier != "" && !sel.table.matches(ref.qualifier) {
+			return "", s.errorAt(ref.token, "unknown table or alias '%s'", ref.qualifier)
+		}
+		return ref.name, nil
+	}
+	if ref.qualifier == "" {
+		return "", s.errorAt(ref.token, "column '%s' must be qualified with a table name or alias in a query with a join", ref.name)
+	}
+	if sel.table.matches(ref.qualifier) {
+		if c.afterJoin {
+			// the join prefixes the columns from the left with l_ and those from the right with r_
+			return "l_" + ref.name, nil
+		}
+		return ref.name, nil
+	}
+	if sel.join.table.matches(ref.qualifier) {
+		if leftCol, ok := c.rightKeyCols[ref.name]; ok {
+			// the join columns of the right table are not included in the output of the join
+			return "l_" + leftCol, nil
+		}
+		return "r_" + ref.name, nil
+	}
+	return "", s.errorAt(ref.token, "unknown table or alias '%s'", ref.qualifier)
+}
+
+// sqlAggregates holds the aggregate function calls in a query, each of which is computed by the TSL aggregate and given
+// a generated name
+type sqlAggregates struct {
+	calls []*sqlFuncCall
+	names []string
+	tsl   []string
+}
+
+func (s *sqlParser) render(e sqlExpr, scope *sqlScope, aggs *sqlAggregates) (string, error) {
+	switch ex := e.(type) {
+	case *sqlColumnRef:
+		return scope.resolve(ex, s)
+	case *sqlLiteral:
+		return ex.tsl, nil
+	case *sqlParamRef:
+		return sqlParamName(ex.index, s.paramTypes[ex.index-1]), nil
+	case *sqlBinaryExpr:
+		left, err := s.render(ex.left, scope, aggs)
+		if err != nil {
+			return "", err
+		}
+		right, err := s.render(ex.right, scope, aggs)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, ex.op, right), nil
+	case *sqlNotExpr:
+		operand, err := s.render(ex.operand, scope, aggs)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("!(%s)", operand), nil
+	case *sqlIsNullExpr:
+		operand, err := s.render(ex.operand, scope, aggs)
+		if err != nil {
+			return "", err
+		}
+		if ex.not {
+			return fmt.Sprintf("is_not_null(%s)", operand), nil
+		}
+		return fmt.Sprintf("is_null(%s)", operand), nil
+	case *sqlFuncCall:
+		if ex.isAggregate() {
+			if aggs == nil {
+				return "", s.errorAt(ex.token, "aggregate functions can only be used in the select list or HAVING clause")
+			}
+			for i, call := range aggs.calls {
+				if sqlExprEquals(call, ex) {
+					return aggs.names[i], nil
+				}
+			}
+			panic("aggregate not collected")
+		}
+		args := make([]string, len(ex.args))
+		for i, arg := range ex.args {
+			var err error
+			args[i], err = s.render(arg, scope, aggs)
+			if err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("%s(%s)", ex.name, strings.Join(args, ", ")), nil
+	default:
+		panic("unexpected sql expression")
+	}
+}
+
+// collectAggregates adds the aggregate function calls in the expression to aggs, and renders their arguments
+func (s *sqlParser) collectAggregates(e sqlExpr, scope *sqlScope, aggs *sqlAggregates, inAggregate bool) error {
+	switch ex := e.(type) {
+	case *sqlBinaryExpr:
+		if err := s.collectAggregates(ex.left, scope, aggs, inAggregate); err != nil {
+			return err
+		}
+		return s.collectAggregates(ex.right, scope, aggs, inAggregate)
+	case *sqlNotExpr:
+		return s.collectAggregates(ex.operand, scope, aggs, inAggregate)
+	case *sqlIsNullExpr:
+		return s.collectAggregates(ex.operand, scope, aggs, inAggregate)
+	case *sqlFuncCall:
+		if !ex.isAggregate() {
+			for _, arg := range ex.args {
+				if err := s.collectAggregates(arg, scope, aggs, inAggregate); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if inAggregate {
+			return s.errorAt(ex.token, "aggregate functions cannot be nested")
+		}
+		for _, call := range aggs.calls {
+			if sqlExprEquals(call, ex) {
+				// the same aggregate is used more than once, e.g. in the select list and in HAVING
+				return nil
+			}
+		}
+		if ex.star || len(ex.args) == 0 {
+			if !ex.star {
+				return s.errorAt(ex.token, "aggregate function '%s' requires an argument", ex.name)
+			}
+		} else if len(ex.args) != 1 {
+			return s.errorAt(ex.token, "aggregate function '%s' takes a single argument", ex.name)
+		}
+		var tsl string
+		if ex.star {
+			// count(*) counts every row
+			tsl = "count(1)"
+		} else {
+			if err := s.collectAggregates(ex.args[0], scope, aggs, true); err != nil {
+				return err
+			}
+			arg, err := s.render(ex.args[0], scope, nil)
+			if err != nil {
+				return err
+			}
+			tsl = fmt.Sprintf("%s(%s)", ex.name, arg)
+		}
+		name := fmt.Sprintf("_agg%d", len(aggs.calls))
+		aggs.calls = append(aggs.calls, ex)
+		aggs.names = append(aggs.names, name)
+		aggs.tsl = append(aggs.tsl, fmt.Sprintf("%s as %s", tsl, name))
+	}
+	return nil
+}
+
+// checkGrouped returns an error if the expression references a column that is not a group by column, other than in an
+// aggregate function
+func (s *sqlParser) checkGrouped(e sqlExpr, scope *sqlScope, groupCols map[string]struct{}) error {
+	switch ex := e.(type) {
+	case *sqlColumnRef:
+		col, err := scope.resolve(ex, s)
+		if err != nil {
+			return err
+		}
+		if _, ok := groupCols[col]; !ok {
+			return s.errorAt(ex.token, "column '%s' must appear in the GROUP BY clause or be used in an aggregate function",
+				ex.name)
+		}
+	case *sqlBinaryExpr:
+		if err := s.checkGrouped(ex.left, scope, groupCols); err != nil {
+			return err
+		}
+		return s.checkGrouped(ex.right, scope, groupCols)
+	case *sqlNotExpr:
+		return s.checkGrouped(ex.operand, scope, groupCols)
+	case *sqlIsNullExpr:
+		return s.checkGrouped(ex.operand, scope, groupCols)
+	case *sqlFuncCall:
+		if ex.isAggregate() {
+			return nil
+		}
+		for _, arg := range ex.args {
+			if err := s.checkGrouped(arg, scope, groupCols); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findAggregate returns the first aggregate function call in the expression, or nil if there are none
+func findAggregate(e sqlExpr) *sqlFuncCall {
+	switch ex := e.(type) {
+	case *sqlBinaryExpr:
+		if call := findAggregate(ex.left); call != nil {
+			return call
+		}
+		return findAggregate(ex.right)
+	case *sqlNotExpr:
+		return findAggregate(ex.operand)
+	case *sqlIsNullExpr:
+		return findAggregate(ex.operand)
+	case *sqlFuncCall:
+		if ex.isAggregate() {
+			return ex
+		}
+		for _, arg := range ex.args {
+			if call := findAggregate(arg); call != nil {
+				return call
+			}
+		}
+	}
+	return nil
+}
+
+// conjuncts splits an expression into the expressions that are combined with AND
+func conjuncts(e sqlExpr, res []sqlExpr) []sqlExpr {
+	if binary, ok := e.(*sqlBinaryExpr); ok && binary.op == "&&" {
+		res = conjuncts(binary.left, res)
+		return conjuncts(binary.right, res)
+	}
+	return append(res, e)
+}
+
+// referencesOnly returns true if all the column references in the expression are qualified by the table
+func referencesOnly(e sqlExpr, table *sqlTableRef) bool {
+	switch ex := e.(type) {
+	case *sqlColumnRef:
+		return ex.qualifier != "" && table.matches(ex.qualifier)
+	case *sqlBinaryExpr:
+		return referencesOnly(ex.left, table) && referencesOnly(ex.right, table)
+	case *sqlNotExpr:
+		return referencesOnly(ex.operand, table)
+	case *sqlIsNullExpr:
+		return referencesOnly(ex.operand, table)
+	case *sqlFuncCall:
+		for _, arg := range ex.args {
+			if !referencesOnly(arg, table) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *sqlParser) renderConjunction(exprs []sqlExpr, scope *sqlScope, aggs *sqlAggregates) (string, error) {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		var err error
+		parts[i], err = s.render(e, scope, aggs)
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(parts, " && "), nil
+}
+
+func (s *sqlParser) compileSelect(sel *sqlSelect) (string, error) {
+	var ops []string
+	ops = append(ops, fmt.Sprintf("(scan all from %s)", sel.table.name))
+	scope := &sqlScope{sel: sel}
+	var whereExprs []sqlExpr
+	if sel.where != nil {
+		if call := findAggregate(sel.where); call != nil {
+			return "", s.errorAt(call.token, "aggregate functions cannot be used in the WHERE clause - use HAVING instead")
+		}
+		whereExprs = conjuncts(sel.where, nil)
+	}
+	if sel.join != nil {
+		joinTsl, err := s.compileJoin(sel, scope)
+		if err != nil {
+			return "", err
+		}
+		// Conditions on just the left table are applied before the join, so they can be pushed down to the scan and
+		// fewer rows are joined
+		var before, after []sqlExpr
+		for _, e := range whereExprs {
+			if referencesOnly(e, &sel.table) {
+				before = append(before, e)
+			} else {
+				after = append(after, e)
+			}
+		}
+		if len(before) > 0 {
+			filter, err := s.renderConjunction(before, scope, nil)
+			if err != nil {
+				return "", err
+			}
+			ops = append(ops, fmt.Sprintf("(filter by %s)", filter))
+		}
+		ops = append(ops, joinTsl)
+		scope.afterJoin = true
+		whereExprs = after
+	}
+	if len(whereExprs) > 0 {
+		filter, err := s.renderConjunction(whereExprs, scope, nil)
+		if err != nil {
+			return "", err
+		}
+		ops = append(ops, fmt.Sprintf("(filter by %s)", filter))
+	}
+
+	aggregated := len(sel.groupBy) > 0 || sel.having != nil
+	for _, item := range sel.items {
+		aggregated = aggregated || findAggregate(item.expr) != nil
+	}
+	// outNames are the names of the columns output by the query, in select order
+	var outNames []string
+	if aggregated {
+		if sel.star {
+			return "", s.errorAt(sel.table.token, "SELECT * cannot be used with GROUP BY or aggregate functions")
+		}
+		aggTsl, names, err := s.compileAggregate(sel, scope)
+		if err != nil {
+			return "", err
+		}
+		ops = append(ops, aggTsl...)
+		outNames = names
+	} else if !sel.star {
+		projTsl, names, err := s.compileProject(sel, scope, nil)
+		if err != nil {
+			return "", err
+		}
+		ops = append(ops, projTsl)
+		outNames = names
+	}
+	if len(sel.orderBy) > 0 {
+		sortTsl, err := s.compileOrderBy(sel, scope, outNames)
+		if err != nil {
+			return "", err
+		}
+		ops = append(ops, sortTsl)
+	}
+	if sel.limit > 0 {
+		if sel.offset > 0 {
+			ops = append(ops, fmt.Sprintf("(limit %d offset %d)", sel.limit, sel.offset))
+		} else {
+			ops = append(ops, fmt.Sprintf("(limit %d)", sel.limit))
+		}
+	}
+	return strings.Join(ops, "->"), nil
+}
+
+func (s *sqlParser) compileJoin(sel *sqlSelect, scope *sqlScope) (string, error) {
+	join := sel.join
+	scope.rightKeyCols = map[string]string{}
+	var elements []string
+	joinOp := "="
+	if join.leftOuter {
+		joinOp = "*="
+	}
+	for _, e := range conjuncts(join.on, nil) {
+		binary, ok := e.(*sqlBinaryExpr)
+		var left, right *sqlColumnRef
+		if ok && binary.op == "==" {
+			left, _ = binary.left.(*sqlColumnRef)
+			right, _ = binary.right.(*sqlColumnRef)
+		}
+		if left == nil || right == nil || left.qualifier == "" || right.qualifier == "" {
+			return "", s.errorAt(join.onToken, "join conditions must be equalities between a column of each table, e.g. a.x = b.y")
+		}
+		if join.table.matches(left.qualifier) && sel.table.matches(right.qualifier) {
+			left, right = right, left
+		}
+		if !sel.table.matches(left.qualifier) || !join.table.matches(right.qualifier) {
+			return "", s.errorAt(join.onToken, "join conditions must be equalities between a column of each table, e.g. a.x = b.y")
+		}
+		elements = append(elements, fmt.Sprintf("%s %s %s", left.name, joinOp, right.name))
+		scope.rightKeyCols[right.name] = left.name
+	}
+	return fmt.Sprintf("(join %s by %s)", join.table.name, strings.Join(elements, ", ")), nil
+}
+
+func (s *sqlParser) compileAggregate(sel *sqlSelect, scope *sqlScope) ([]string, []string, error) {
+	groupCols := map[string]struct{}{}
+	var keys []string
+	for _, item := range sel.groupBy {
+		ref, ok := item.expr.(*sqlColumnRef)
+		if !ok {
+			return nil, nil, s.errorAt(item.token, "GROUP BY expressions must be columns")
+		}
+		col, err := scope.resolve(ref, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, col)
+		groupCols[col] = struct{}{}
+	}
+	aggs := &sqlAggregates{}
+	for _, item := range sel.items {
+		if err := s.checkGrouped(item.expr, scope, groupCols); err != nil {
+			return nil, nil, err
+		}
+		if err := s.collectAggregates(item.expr, scope, aggs, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	if sel.having != nil {
+		if err := s.checkGrouped(sel.having, scope, groupCols); err != nil {
+			return nil, nil, err
+		}
+		if err := s.collectAggregates(sel.having, scope, aggs, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(aggs.calls) == 0 {
+		// Grouping without any aggregate functions - TSL requires at least one aggregate expression
+		aggs.tsl = append(aggs.tsl, "count(1) as _agg0")
+	}
+	var ops []string
+	aggTsl := fmt.Sprintf("(aggregate %s", strings.Join(aggs.tsl, ", "))
+	if len(keys) > 0 {
+		aggTsl = fmt.Sprintf("%s by %s", aggTsl, strings.Join(keys, ", "))
+	}
+	ops = append(ops, aggTsl+")")
+	if sel.having != nil {
+		having, err := s.render(sel.having, scope, aggs)
+		if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, fmt.Sprintf("(filter by %s)", having))
+	}
+	projTsl, names, err := s.compileProject(sel, scope, aggs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(ops, projTsl), names, nil
+}
+
+func (s *sqlParser) compileProject(sel *sqlSelect, scope *sqlScope, aggs *sqlAggregates) (string, []string, error) {
+	var exprs []string
+	var names []string
+	for i, item := range sel.items {
+		rendered, err := s.render(item.expr, scope, aggs)
+		if err != nil {
+			return "", nil, err
+		}
+		name := item.alias
+		if name == "" {
+			if ref, ok := item.expr.(*sqlColumnRef); ok {
+				name = ref.name
+			} else {
+				// the same default name as TSL uses for expressions in a project
+				name = fmt.Sprintf("col%d", i)
+			}
+		}
+		if rendered != name {
+			rendered = fmt.Sprintf("%s as %s", rendered, name)
+		}
+		exprs = append(exprs, rendered)
+		names = append(names, name)
+	}
+	return fmt.Sprintf("(project %s)", strings.Join(exprs, ", ")), names, nil
+}
+
+// compileOrderBy creates the sort. The sort is applied to the results of the query, so the ORDER BY expressions must
+// refer to the output columns - by name, by position, or by repeating the expression from the select list
+func (s *sqlParser) compileOrderBy(sel *sqlSelect, scope *sqlScope, outNames []string) (string, error) {
+	var sortExprs []string
+	for _, item := range sel.orderBy {
+		name, err := s.orderByColumn(sel, scope, outNames, item)
+		if err != nil {
+			return "", err
+		}
+		if item.descending {
+			name += " desc"
+		}
+		sortExprs = append(sortExprs, name)
+	}
+	return fmt.Sprintf("(sort by %s)", strings.Join(sortExprs, ", ")), nil
+}
+
+func (s *sqlParser) orderByColumn(sel *sqlSelect, scope *sqlScope, outNames []string, item sqlOrderItem) (string, error) {
+	if sel.star {
+		ref, ok := item.expr.(*sqlColumnRef)
+		if !ok {
+			return "", s.errorAt(item.token, "ORDER BY expressions must be columns when selecting *")
+		}
+		return scope.resolve(ref, s)
+	}
+	if lit, ok := item.expr.(*sqlLiteral); ok {
+		ordinal, err := strconv.Atoi(lit.tsl)
+		if err != nil || ordinal < 1 || ordinal > len(outNames) {
+			return "", s.errorAt(item.token, "ORDER BY position %s is not in the select list", lit.tsl)
+		}
+		return outNames[ordinal-1], nil
+	}
+	if ref, ok := item.expr.(*sqlColumnRef); ok && ref.qualifier == "" {
+		// output column names take precedence over the columns of the tables
+		for _, name := range outNames {
+			if name == ref.name {
+				return name, nil
+			}
+		}
+	}
+	for i, selItem := range sel.items {
+		if sqlExprEquals(selItem.expr, item.expr) {
+			return outNames[i], nil
+		}
+	}
+	return "", s.errorAt(item.token, "ORDER BY expressions must be in the select list")
+}
+
+func sqlExprEquals(e1 sqlExpr, e2 sqlExpr) bool {
+	switch ex1 := e1.(type) {
+	case *sqlColumnRef:
+		ex2, ok := e2.(*sqlColumnRef)
+		return ok && ex1.name == ex2.name && (ex1.qualifier == ex2.qualifier || ex1.qualifier == "" || ex2.qualifier == "")
+	case *sqlLiteral:
+		ex2, ok := e2.(*sqlLiteral)
+		return ok && ex1.tsl == ex2.tsl
+	case *sqlParamRef:
+		ex2, ok := e2.(*sqlParamRef)
+		return ok && ex1.index == ex2.index
+	case *sqlBinaryExpr:
+		ex2, ok := e2.(*sqlBinaryExpr)
+		return ok && ex1.op == ex2.op && sqlExprEquals(ex1.left, ex2.left) && sqlExprEquals(ex1.right, ex2.right)
+	case *sqlNotExpr:
+		ex2, ok := e2.(*sqlNotExpr)
+		return ok && sqlExprEquals(ex1.operand, ex2.operand)
+	case *sqlIsNullExpr:
+		ex2, ok := e2.(*sqlIsNullExpr)
+		return ok && ex1.not == ex2.not && sqlExprEquals(ex1.operand, ex2.operand)
+	case *sqlFuncCall:
+		ex2, ok := e2.(*sqlFuncCall)
+		if !ok || ex1.name != ex2.name || ex1.star != ex2.star || len(ex1.args) != len(ex2.args) {
+			return false
+		}
+		for i, arg := range ex1.args {
+			if !sqlExprEquals(arg, ex2.args[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestParseSQLSelect(t *testing.T) {
+	testParseSQL(t, `SELECT * FROM orders`, `(scan all from orders)`)
+	testParseSQL(t, `select * from sys.streams;`, `(scan all from sys.streams)`)
+	testParseSQL(t, `SELECT id, name AS n, amount * 2 FROM orders`,
+		`(scan all from orders)->(project id, name as n, (amount * 2) as col2)`)
+	testParseSQL(t, `SELECT o.id, o.name n FROM orders o`, `(scan all from orders)->(project id, name as n)`)
+	testParseSQL(t, `SELECT "offset", to_upper(name) FROM orders`,
+		`(scan all from orders)->(project offset, to_upper(name) as col1)`)
+}
+
+func TestParseSQLWhere(t *testing.T) {
+	testParseSQL(t, `SELECT * FROM orders WHERE amount > 10.5 AND name = 'it''s' -- a comment`,
+		`(scan all from orders)->(filter by (amount > 10.5f) && (name == "it's"))`)
+	testParseSQL(t, `SELECT * FROM orders WHERE a <> 1 OR NOT b = -2 AND c IS NULL`,
+		`(scan all from orders)->(filter by ((a != 1) || (!((b == -2)) && is_null(c))))`)
+	testParseSQL(t, `SELECT * FROM orders WHERE a IS NOT NULL AND b = true`,
+		`(scan all from orders)->(filter by is_not_null(a) && (b == true))`)
+	testParseSQL(t, `SELECT * FROM orders WHERE a BETWEEN 1 AND 10 AND b NOT IN ('x', 'y')`,
+		`(scan all from orders)->(filter by (a >= 1) && (a <= 10) && !(((b == "x") || (b == "y"))))`)
+	testParseSQL(t, `SELECT * FROM orders WHERE (a + 1) * 2 >= b % 3`,
+		`(scan all from orders)->(filter by (((a + 1) * 2) >= (b % 3)))`)
+}
+
+func TestParseSQLGroupBy(t *testing.T) {
+	testParseSQL(t, `SELECT cust_id, count(*), sum(amount) AS total FROM orders GROUP BY cust_id`,
+		`(scan all from orders)->(aggregate count(1) as _agg0, sum(amount) as _agg1 by cust_id)->(project cust_id, _agg0 as col1, _agg1 as total)`)
+	testParseSQL(t, `SELECT max(amount) - min(amount) AS spread FROM orders`,
+		`(scan all from orders)->(aggregate max(amount) as _agg0, min(amount) as _agg1)->(project (_agg0 - _agg1) as spread)`)
+	// The same aggregate is only computed once
+	testParseSQL(t, `SELECT cust_id, count(*) AS num FROM orders GROUP BY cust_id HAVING count(*) > 1 AND avg(amount) > 10`,
+		`(scan all from orders)->(aggregate count(1) as _agg0, avg(amount) as _agg1 by cust_id)->(filter by ((_agg0 > 1) && (_agg1 > 10)))->(project cust_id, _agg0 as num)`)
+	testParseSQL(t, `SELECT cust_id FROM orders GROUP BY cust_id`,
+		`(scan all from orders)->(aggregate count(1) as _agg0 by cust_id)->(project cust_id)`)
+}
+
+func TestParseSQLOrderByAndLimit(t *testing.T) {
+	testParseSQL(t, `SELECT id, name AS n FROM orders ORDER BY n DESC, id LIMIT 10 OFFSET 5`,
+		`(scan all from orders)->(project id, name as n)->(sort by n desc, id)->(limit 10 offset 5)`)
+	testParseSQL(t, `SELECT cust_id, count(*) FROM orders GROUP BY cust_id ORDER BY 2 DESC, count(*) LIMIT 3`,
+		`(scan all from orders)->(aggregate count(1) as _agg0 by cust_id)->(project cust_id, _agg0 as col1)->(sort by col1 desc, col1)->(limit 3)`)
+	testParseSQL(t, `SELECT * FROM orders o ORDER BY o.amount ASC`,
+		`(scan all from orders)->(sort by amount)`)
+}
+
+func TestParseSQLJoin(t *testing.T) {
+	// Conditions on just the left table are applied before the join
+	testParseSQL(t, `SELECT o.id, c.name, c.id AS cid FROM orders o JOIN customers c ON c.id = o.cust_id
+		WHERE o.amount > 10 AND c.country <> 'UK'`,
+		`(scan all from orders)->(filter by (amount > 10))->(join customers by cust_id = id)->(filter by (r_country != "UK"))->(project l_id as id, r_name as name, l_cust_id as cid)`)
+	testParseSQL(t, `SELECT * FROM orders LEFT OUTER JOIN customers ON orders.cust_id = customers.id AND orders.region = customers.region`,
+		`(scan all from orders)->(join customers by cust_id *= id, region *= region)`)
+	testParseSQL(t, `SELECT c.country, sum(o.amount) FROM orders o LEFT JOIN customers c ON o.cust_id = c.id GROUP BY c.country`,
+		`(scan all from orders)->(join customers by cust_id *= id)->(aggregate sum(l_amount) as _agg0 by r_country)->(project r_country as country, _agg0 as col1)`)
+}
+
+func TestParseSQLPrepare(t *testing.T) {
+	desc, err := NewParser(nil).ParseSQL(`PREPARE my_query (int, decimal(10, 2)) AS SELECT * FROM orders WHERE amount > $2 AND id = $1 AND other = $2`)
+	require.NoError(t, err)
+	require.Equal(t, `prepare my_query($p1:int, $p2:decimal(10,2)) := (scan all from orders)->(filter by (amount > $p2:decimal(10,2)) && (id == $p1:int) && (other == $p2:decimal(10,2)))`,
+		desc.TSL)
+	require.Nil(t, desc.Query)
+	require.Equal(t, "my_query", desc.PrepareQuery.QueryName)
+	// The params are in the order they are declared in
+	require.Equal(t, []PreparedStatementParam{
+		{ParamName: "$p1:int", ParamType: types.ColumnTypeInt},
+		{ParamName: "$p2:decimal(10,2)", ParamType: &types.DecimalType{Precision: 10, Scale: 2}},
+	}, desc.PrepareQuery.Params)
+
+	testParseSQL(t, `PREPARE my_query AS SELECT * FROM orders`, `prepare my_query := (scan all from orders)`)
+}
+
+func TestParsePreparedSQL(t *testing.T) {
+	desc, err := NewParser(nil).ParsePreparedSQL("my_query", []types.ColumnType{types.ColumnTypeString, types.ColumnTypeInt},
+		`SELECT id FROM orders WHERE id = $2 AND name = $1`)
+	require.NoError(t, err)
+	require.Equal(t, `prepare my_query($p1:string, $p2:int) := (scan all from orders)->(filter by (id == $p2:int) && (name == $p1:string))->(project id)`,
+		desc.TSL)
+	require.Equal(t, "my_query", desc.PrepareQuery.QueryName)
+	require.Equal(t, 2, len(desc.PrepareQuery.Params))
+
+	_, err = NewParser(nil).ParsePreparedSQL("my_query", []types.ColumnType{types.ColumnTypeInt},
+		`SELECT id FROM orders WHERE id = $2`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "parameter $2 has not been declared")
+}
+
+func TestSQLParamCount(t *testing.T) {
+	count, err := SQLParamCount(`SELECT * FROM orders WHERE id = $2 AND amount > $1 AND other = $2`)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	// Params in string literals are not counted
+	count, err = SQLParamCount(`SELECT * FROM orders WHERE name = '$1'`)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestParseSQLCreatesQueryDesc(t *testing.T) {
+	desc, err := NewParser(nil).ParseSQL(`SELECT id FROM orders WHERE amount > 10 ORDER BY id LIMIT 5`)
+	require.NoError(t, err)
+	require.Nil(t, desc.PrepareQuery)
+	require.Equal(t, 5, len(desc.Query.OperatorDescs))
+	require.IsType(t, &ScanDesc{}, desc.Query.OperatorDescs[0])
+	require.IsType(t, &FilterDesc{}, desc.Query.OperatorDescs[1])
+	require.IsType(t, &ProjectDesc{}, desc.Query.OperatorDescs[2])
+	require.IsType(t, &SortDesc{}, desc.Query.OperatorDescs[3])
+	require.Equal(t, 5, desc.Query.OperatorDescs[4].(*LimitDesc).Limit)
+}
+
+func TestFailedToParseSQL(t *testing.T) {
+	testFailedToParseSQL(t, ``, `statement is empty`)
+	testFailedToParseSQL(t, `SELECT * FROM`, `reached end of statement`)
+	testFailedToParseSQL(t, `SELECT * FROM orders WHERE a = #`, `invalid statement (line 1 column 32):
+SELECT * FROM orders WHERE a = #
+                               ^`)
+	testFailedToParseSQL(t, `UPDATE orders SET a = 1`, `expected 'select' but found 'UPDATE' (line 1 column 1):
+UPDATE orders SET a = 1
+^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders foo bar`, `expected end of statement but found 'bar' (line 1 column 26):
+SELECT * FROM orders foo bar
+                         ^`)
+	testFailedToParseSQL(t, `SELECT DISTINCT a FROM orders`, `expected expression but found 'DISTINCT' (line 1 column 8):
+SELECT DISTINCT a FROM orders
+       ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders WHERE a = NULL`, `NULL literals are not supported - use IS NULL or IS NOT NULL (line 1 column 32):
+SELECT * FROM orders WHERE a = NULL
+                               ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders WHERE a = $1`, `parameters can only be used in a PREPARE statement (line 1 column 32):
+SELECT * FROM orders WHERE a = $1
+                               ^`)
+	testFailedToParseSQL(t, `PREPARE q (int) AS SELECT * FROM orders WHERE a = $2`, `parameter $2 has not been declared - declare the parameter types after the query name, e.g. PREPARE my_query (int, string) AS ... (line 1 column 51):
+PREPARE q (int) AS SELECT * FROM orders WHERE a = $2
+                                                  ^`)
+	testFailedToParseSQL(t, `PREPARE q (integer) AS SELECT * FROM orders`, `unknown parameter type 'integer'. must be one of int, float, bool, decimal(p, s), string, bytes, timestamp (line 1 column 12):
+PREPARE q (integer) AS SELECT * FROM orders
+           ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders OFFSET 10`, `OFFSET can only be used with LIMIT (line 1 column 22):
+SELECT * FROM orders OFFSET 10
+                     ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders LIMIT 0`, `limit must be a positive integer (line 1 column 28):
+SELECT * FROM orders LIMIT 0
+                           ^`)
+}
+
+func TestFailedToParseSQLAggregate(t *testing.T) {
+	testFailedToParseSQL(t, `SELECT cust_id, amount, count(*) FROM orders GROUP BY cust_id`, `column 'amount' must appear in the GROUP BY clause or be used in an aggregate function (line 1 column 17):
+SELECT cust_id, amount, count(*) FROM orders GROUP BY cust_id
+                ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders GROUP BY cust_id`, `SELECT * cannot be used with GROUP BY or aggregate functions (line 1 column 15):
+SELECT * FROM orders GROUP BY cust_id
+              ^`)
+	testFailedToParseSQL(t, `SELECT count(*) FROM orders GROUP BY cust_id + 1`, `GROUP BY expressions must be columns (line 1 column 38):
+SELECT count(*) FROM orders GROUP BY cust_id + 1
+                                     ^`)
+	testFailedToParseSQL(t, `SELECT sum(max(a)) FROM orders`, `aggregate functions cannot be nested (line 1 column 12):
+SELECT sum(max(a)) FROM orders
+           ^`)
+	testFailedToParseSQL(t, `SELECT a FROM orders WHERE count(a) > 1`, `aggregate functions cannot be used in the WHERE clause - use HAVING instead (line 1 column 28):
+SELECT a FROM orders WHERE count(a) > 1
+                           ^`)
+	testFailedToParseSQL(t, `SELECT sum(*) FROM orders`, `'*' can only be used as the argument of count (line 1 column 8):
+SELECT sum(*) FROM orders
+       ^`)
+	testFailedToParseSQL(t, `SELECT id, name FROM orders ORDER BY amount`, `ORDER BY expressions must be in the select list (line 1 column 38):
+SELECT id, name FROM orders ORDER BY amount
+                                     ^`)
+}
+
+func TestFailedToParseSQLJoin(t *testing.T) {
+	testFailedToParseSQL(t, `SELECT * FROM orders o RIGHT JOIN customers c ON o.cust_id = c.id`, `only inner joins and left outer joins are supported (line 1 column 24):
+SELECT * FROM orders o RIGHT JOIN customers c ON o.cust_id = c.id
+                       ^`)
+	testFailedToParseSQL(t, `SELECT * FROM orders o JOIN customers c ON o.cust_id > c.id`, `join conditions must be equalities between a column of each table, e.g. a.x = b.y (line 1 column 41):
+SELECT * FROM orders o JOIN customers c ON o.cust_id > c.id
+                                        ^`)
+	testFailedToParseSQL(t, `SELECT id FROM orders o JOIN customers c ON o.cust_id = c.id`, `column 'id' must be qualified with a table name or alias in a query with a join (line 1 column 8):
+SELECT id FROM orders o JOIN customers c ON o.cust_id = c.id
+       ^`)
+	testFailedToParseSQL(t, `SELECT x.id FROM orders o JOIN customers c ON o.cust_id = c.id`, `unknown table or alias 'x' (line 1 column 8):
+SELECT x.id FROM orders o JOIN customers c ON o.cust_id = c.id
+       ^`)
+	testFailedToParseSQL(t, `SELECT * FROM a JOIN b ON a.x = b.x JOIN c ON a.y = c.y`, `only one join is supported in a query (line 1 column 37):
+SELECT * FROM a JOIN b ON a.x = b.x JOIN c ON a.y = c.y
+                                    ^`)
+}
+
+func TestFailedToParseSQLIdentifier(t *testing.T) {
+	testFailedToParseSQL(t, `SELECT "my col" FROM orders`, `'my col' cannot be used as an identifier - it is not a valid identifier in the TSL the query compiles to (line 1 column 8):
+SELECT "my col" FROM orders
+       ^`)
+	// A quoted identifier cannot inject TSL into the query
+	testFailedToParseSQL(t, `SELECT * FROM "orders)->(project secret"`, `'orders)->(project secret' cannot be used as an identifier - it is not a valid identifier in the TSL the query compiles to (line 1 column 15):
+SELECT * FROM "orders)->(project secret"
+              ^`)
+	testFailedToParseSQL(t, `SELECT id AS "$p1:int" FROM orders`, `'$p1:int' cannot be used as an identifier - it is not a valid identifier in the TSL the query compiles to (line 1 column 14):
+SELECT id AS "$p1:int" FROM orders
+             ^`)
+	testFailedToParseSQL(t, `SELECT description FROM orders`, `'description' cannot be used as an identifier - it is not a valid identifier in the TSL the query compiles to (line 1 column 8):
+SELECT description FROM orders
+       ^`)
+}
+
+func TestSQLMapError(t *testing.T) {
+	desc, err := NewParser(nil).ParseSQL(`PREPARE q (int) AS SELECT o.id, c.name FROM orders o JOIN customers c ON o.cust_id = c.id
+WHERE c.age > $1`)
+	require.NoError(t, err)
+	tslError := func(tslToken string) error {
+		index := strings.Index(desc.TSL, tslToken)
+		require.True(t, index >= 0)
+		pos := lexer.Position{Line: 1, Column: index + 1, Offset: index}
+		return errors.NewTektiteError(errors.PrepareQueryError, MessageWithPosition("some error", pos, desc.TSL))
+	}
+	// Errors in the TSL are mapped to the SQL token the TSL was generated from
+	err = desc.MapError(tslError("r_name"))
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.PrepareQueryError))
+	require.Equal(t, `some error (line 1 column 35):
+PREPARE q (int) AS SELECT o.id, c.name FROM orders o JOIN customers c ON o.cust_id = c.id
+                                  ^`, err.Error())
+	err = desc.MapError(tslError("$p1:int"))
+	require.Equal(t, `some error (line 2 column 15):
+WHERE c.age > $1
+              ^`, err.Error())
+	err = desc.MapError(tslError("customers"))
+	require.Equal(t, `some error (line 1 column 59):
+PREPARE q (int) AS SELECT o.id, c.name FROM orders o JOIN customers c ON o.cust_id = c.id
+                                                          ^`, err.Error())
+	// If there is no corresponding token, only the message is returned
+	err = desc.MapError(tslError(":="))
+	require.Equal(t, "some error", err.Error())
+	// Errors without a position in the TSL are not changed
+	other := errors.NewTektiteError(errors.ExecuteQueryError, "query failed")
+	require.Equal(t, other, desc.MapError(other))
+	require.Nil(t, desc.MapError(nil))
+}
+
+func testParseSQL(t *testing.T, sql string, expectedTSL string) {
+	desc, err := NewParser(nil).ParseSQL(sql)
+	require.NoError(t, err)
+	require.Equal(t, expectedTSL, desc.TSL)
+}
+
+func testFailedToParseSQL(t *testing.T, sql string, expectedMsg string) {
+	_, err := NewParser(nil).ParseSQL(sql)
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.ParseError))
+	require.Equal(t, expectedMsg, err.Error())
+}
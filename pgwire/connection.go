@@ -0,0 +1,802 @@
+package pgwire
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/query"
+	"github.com/spirit-labs/tektite/types"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// serverParameters are reported to the client once it has connected. Clients and drivers use these to decide how to
+// format and parse values.
+var serverParameters = [][2]string{
+	{"server_version", "14.0"},
+	{"server_encoding", "UTF8"},
+	{"client_encoding", "UTF8"},
+	{"DateStyle", "ISO, MDY"},
+	{"TimeZone", "UTC"},
+	{"integer_datetimes", "on"},
+	{"standard_conforming_strings", "on"},
+}
+
+// statementQueryName is the name of the prepare statement that a statement with params compiles to. The query is not
+// prepared on the cluster - statements are local to the connection, and are executed directly with their args.
+const statementQueryName = "pg_statement"
+
+// statement is a statement prepared with a Parse message
+type statement struct {
+	tsl string
+	// query is the query that a statement without params executes
+	query *parser.QueryDesc
+	// prepareQuery is the query that a statement with params executes with the args it is bound to
+	prepareQuery *parser.PrepareQueryDesc
+	paramOIDs    []uint32
+	paramTypes   []types.ColumnType
+	resultSchema *evbatch.EventSchema
+	empty        bool
+	// sqlDesc maps errors in the query back to the SQL of the statement
+	sqlDesc *parser.SQLDesc
+}
+
+// portal is a statement bound to its params with a Bind message. The results are created when the portal is first
+// executed - if the client limits the rows returned, the remaining rows are returned by executing the portal again.
+type portal struct {
+	stmt          *statement
+	args          []any
+	resultFormats []int16
+	results       *queryResults
+	rowCount      int
+}
+
+type connection struct {
+	s          *Server
+	id         int32
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeBuff  []byte
+	writeErr   error
+	closeGroup sync.WaitGroup
+	lock       sync.Mutex
+	closed     bool
+	tls        bool
+	statements map[string]*statement
+	portals    map[string]*portal
+	// skipToSync is set when a message of the extended query protocol fails - the following messages are discarded
+	// until the client sends a Sync
+	skipToSync bool
+}
+
+func (c *connection) start() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeGroup.Add(1)
+	common.Go(c.readLoop)
+}
+
+func (c *connection) readLoop() {
+	defer common.PanicHandler()
+	defer c.closeGroup.Done()
+	if err := c.serve(); err != nil && err != io.EOF {
+		log.Debugf("postgres connection closed: %v", err)
+	}
+	for _, p := range c.portals {
+		p.close()
+	}
+	c.s.removeConnection(c)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	if err := c.conn.Close(); err != nil {
+		// Ignore
+	}
+}
+
+func (c *connection) stop() {
+	c.lock.Lock()
+	c.closed = true
+	if err := c.conn.Close(); err != nil {
+		// Do nothing - connection might already have been closed (e.g. from client)
+	}
+	c.lock.Unlock()
+	c.closeGroup.Wait()
+}
+
+func (c *connection) serve() error {
+	ok, err := c.startup()
+	if err != nil || !ok {
+		return err
+	}
+	for {
+		msgType, body, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		if msgType == msgTerminate {
+			return nil
+		}
+		if c.skipToSync && msgType != msgSync {
+			continue
+		}
+		if err := c.handleMessage(msgType, body); err != nil {
+			c.sendError(err, "ERROR")
+			c.skipToSync = true
+		}
+		if c.writeErr != nil {
+			return c.writeErr
+		}
+	}
+}
+
+func (c *connection) handleMessage(msgType byte, body []byte) error {
+	switch msgType {
+	case msgQuery:
+		c.handleQuery(body)
+		return nil
+	case msgParse:
+		return c.handleParse(body)
+	case msgBind:
+		return c.handleBind(body)
+	case msgDescribe:
+		return c.handleDescribe(body)
+	case msgExecute:
+		return c.handleExecute(body)
+	case msgClose:
+		return c.handleClose(body)
+	case msgFlush:
+		c.flush()
+		return nil
+	case msgSync:
+		c.skipToSync = false
+		c.sendReadyForQuery()
+		c.flush()
+		return nil
+	default:
+		return newPgError(sqlStateProtocolViolation, "unsupported message type '%c'", msgType)
+	}
+}
+
+// startup handles the messages sent by the client before the connection is established - these are a request to
+// negotiate TLS, followed by the startup message. It returns false if the connection should be closed.
+func (c *connection) startup() (bool, error) {
+	for {
+		body, err := c.readStartupMessage()
+		if err != nil {
+			return false, err
+		}
+		r := &messageReader{buff: body}
+		code := r.readInt32()
+		switch {
+		case code == sslRequestCode:
+			if c.s.tlsConfig == nil || c.tls {
+				if _, err := c.conn.Write([]byte{'N'}); err != nil {
+					return false, err
+				}
+				continue
+			}
+			if _, err := c.conn.Write([]byte{'S'}); err != nil {
+				return false, err
+			}
+			tlsConn := tls.Server(c.conn, c.s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return false, err
+			}
+			c.lock.Lock()
+			c.conn = tlsConn
+			c.lock.Unlock()
+			c.reader = bufio.NewReaderSize(tlsConn, readBuffSize)
+			c.tls = true
+		case code == gssEncRequestCode:
+			if _, err := c.conn.Write([]byte{'N'}); err != nil {
+				return false, err
+			}
+		case code == cancelRequestCode:
+			// Cancelling queries is not supported - the client closes the connection after sending the request
+			return false, nil
+		case code>>16 == protocolVersion3>>16:
+			if c.s.tlsConfig != nil && !c.tls {
+				c.sendError(newPgError(sqlStateInvalidAuthorization, "the server requires connections to use SSL"), "FATAL")
+				c.flush()
+				return false, c.writeErr
+			}
+			// The remaining fields are the startup parameters such as user and database, which are not used, other than
+			// to report protocol options that are not supported
+			var unsupportedOptions []string
+			for {
+				key := r.readString()
+				if key == "" || r.err != nil {
+					break
+				}
+				r.readString()
+				if strings.HasPrefix(key, "_pq_.") {
+					unsupportedOptions = append(unsupportedOptions, key)
+				}
+			}
+			if code != protocolVersion3 || len(unsupportedOptions) > 0 {
+				c.sendNegotiateProtocolVersion(unsupportedOptions)
+			}
+			c.sendStartupResponse()
+			c.flush()
+			return true, c.writeErr
+		default:
+			c.sendError(newPgError(sqlStateProtocolViolation, "unsupported frontend protocol %d.%d", code>>16,
+				code&0xffff), "FATAL")
+			c.flush()
+			return false, c.writeErr
+		}
+	}
+}
+
+func (c *connection) readStartupMessage() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	return c.readBody(int(binary.BigEndian.Uint32(header[:])) - 4)
+}
+
+func (c *connection) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	body, err := c.readBody(int(binary.BigEndian.Uint32(header[1:])) - 4)
+	return header[0], body, err
+}
+
+func (c *connection) readBody(size int) ([]byte, error) {
+	if size < 0 || size > maxMessageSize {
+		return nil, errors.Errorf("invalid message size %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// handleQuery handles a query sent with the simple query protocol
+func (c *connection) handleQuery(body []byte) {
+	r := &messageReader{buff: body}
+	sql := r.readString()
+	if r.err != nil {
+		c.sendError(r.err, "ERROR")
+	} else if err := c.executeSimpleQuery(sql); err != nil {
+		c.sendError(err, "ERROR")
+	}
+	c.sendReadyForQuery()
+	c.flush()
+}
+
+func (c *connection) executeSimpleQuery(sql string) error {
+	if isEmptyStatement(sql) {
+		c.sendMessage(msgEmptyQueryResponse)
+		return nil
+	}
+	desc, err := c.s.parser.ParseSQL(sql)
+	if err != nil {
+		return err
+	}
+	if desc.PrepareQuery != nil {
+		// Prepared queries are created on all nodes, like prepare statements in TSL
+		if err := c.s.commandManager.ExecuteCommand(desc.TSL); err != nil {
+			return desc.MapError(err)
+		}
+		c.sendCommandComplete("PREPARE")
+		return nil
+	}
+	results, err := executeQuery(func(o outFunc) error {
+		return desc.MapError(c.s.queryManager.ExecuteQueryDirect(desc.TSL, *desc.Query, query.Limits{}, o))
+	})
+	if err != nil {
+		return err
+	}
+	results.hasRow()
+	schema := results.schema
+	if schema == nil {
+		// No batches were returned, so we get the schema from the query
+		schema, err = c.s.queryManager.GetQueryResultSchema(*desc.Query, nil)
+		if err != nil {
+			return desc.MapError(err)
+		}
+	}
+	c.sendRowDescription(schema, nil)
+	rowCount, _ := c.sendRows(results, nil, 0)
+	if results.err != nil {
+		return results.err
+	}
+	c.sendCommandComplete(fmt.Sprintf("SELECT %d", rowCount))
+	return nil
+}
+
+func (c *connection) handleParse(body []byte) error {
+	r := &messageReader{buff: body}
+	name := r.readString()
+	sql := r.readString()
+	numParams := r.readInt16()
+	var paramOIDs []uint32
+	for i := 0; i < int(numParams) && r.err == nil; i++ {
+		paramOIDs = append(paramOIDs, uint32(r.readInt32()))
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if _, exists := c.statements[name]; exists && name != "" {
+		return newPgError(sqlStateDuplicatePreparedStatement, "prepared statement \"%s\" already exists", name)
+	}
+	stmt, err := c.prepareStatement(sql, paramOIDs)
+	if err != nil {
+		return err
+	}
+	c.statements[name] = stmt
+	c.sendMessage(msgParseComplete)
+	return nil
+}
+
+func (c *connection) prepareStatement(sql string, paramOIDs []uint32) (*statement, error) {
+	if isEmptyStatement(sql) {
+		return &statement{empty: true}, nil
+	}
+	numParams, err := parser.SQLParamCount(sql)
+	if err != nil {
+		return nil, err
+	}
+	if numParams == 0 {
+		desc, err := c.s.parser.ParseSQL(sql)
+		if err != nil {
+			return nil, err
+		}
+		if desc.Query == nil {
+			return nil, newPgError(sqlStateFeatureNotSupported,
+				"PREPARE statements can only be executed with the simple query protocol")
+		}
+		schema, err := c.s.queryManager.GetQueryResultSchema(*desc.Query, nil)
+		if err != nil {
+			return nil, desc.MapError(err)
+		}
+		return &statement{tsl: desc.TSL, query: desc.Query, resultSchema: schema, sqlDesc: desc}, nil
+	}
+	paramTypes := make([]types.ColumnType, numParams)
+	for i := range paramTypes {
+		if i >= len(paramOIDs) || paramOIDs[i] == 0 || paramOIDs[i] == oidUnknown {
+			return nil, newPgError(sqlStateIndeterminateDatatype,
+				"could not determine data type of parameter $%d - parameter types must be specified when the statement is prepared",
+				i+1)
+		}
+		paramType, ok := oidToColumnType(paramOIDs[i])
+		if !ok {
+			return nil, newPgError(sqlStateFeatureNotSupported, "parameter $%d has unsupported type with oid %d", i+1,
+				paramOIDs[i])
+		}
+		paramTypes[i] = paramType
+	}
+	desc, err := c.s.parser.ParsePreparedSQL(statementQueryName, paramTypes, sql)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := c.s.queryManager.GetQueryResultSchema(*desc.PrepareQuery.Query, desc.PrepareQuery.Params)
+	if err != nil {
+		return nil, desc.MapError(err)
+	}
+	return &statement{
+		tsl:          desc.TSL,
+		prepareQuery: desc.PrepareQuery,
+		paramOIDs:    paramOIDs[:numParams],
+		paramTypes:   paramTypes,
+		resultSchema: schema,
+		sqlDesc:      desc,
+	}, nil
+}
+
+func (c *connection) handleBind(body []byte) error {
+	r := &messageReader{buff: body}
+	portalName := r.readString()
+	stmtName := r.readString()
+	paramFormats := r.readFormats()
+	numParams := r.readInt16()
+	var paramVals [][]byte
+	for i := 0; i < int(numParams) && r.err == nil; i++ {
+		paramVals = append(paramVals, r.readBytes())
+	}
+	resultFormats := r.readFormats()
+	if r.err != nil {
+		return r.err
+	}
+	stmt, ok := c.statements[stmtName]
+	if !ok {
+		return newPgError(sqlStateInvalidStatementName, "prepared statement \"%s\" does not exist", stmtName)
+	}
+	if len(paramVals) != len(stmt.paramTypes) {
+		return newPgError(sqlStateProtocolViolation,
+			"bind message supplies %d parameters, but prepared statement \"%s\" requires %d", len(paramVals), stmtName,
+			len(stmt.paramTypes))
+	}
+	if len(paramFormats) > 1 && len(paramFormats) != len(paramVals) {
+		return newPgError(sqlStateProtocolViolation, "bind message has %d parameter formats but %d parameters",
+			len(paramFormats), len(paramVals))
+	}
+	var args []any
+	if len(paramVals) > 0 {
+		args = make([]any, len(paramVals))
+		for i, val := range paramVals {
+			if val == nil {
+				continue
+			}
+			arg, err := decodeParam(val, stmt.paramTypes[i], formatForColumn(paramFormats, i))
+			if err != nil {
+				return newPgError(sqlStateInvalidParameterValue, "invalid value for parameter $%d: %v", i+1, err)
+			}
+			args[i] = arg
+		}
+	}
+	if stmt.resultSchema != nil {
+		columnTypes := stmt.resultSchema.ColumnTypes()
+		if len(resultFormats) > 1 && len(resultFormats) != len(columnTypes) {
+			return newPgError(sqlStateProtocolViolation, "bind message has %d result formats but query has %d columns",
+				len(resultFormats), len(columnTypes))
+		}
+		for i, columnType := range columnTypes {
+			if formatForColumn(resultFormats, i) == formatBinary && !supportsBinaryFormat(columnType) {
+				return newPgError(sqlStateFeatureNotSupported, "binary format is not supported for column '%s' of type %s",
+					stmt.resultSchema.ColumnNames()[i], columnType.String())
+			}
+		}
+	}
+	if existing, ok := c.portals[portalName]; ok {
+		existing.close()
+	}
+	c.portals[portalName] = &portal{
+		stmt:          stmt,
+		args:          args,
+		resultFormats: resultFormats,
+	}
+	c.sendMessage(msgBindComplete)
+	return nil
+}
+
+func (c *connection) handleDescribe(body []byte) error {
+	r := &messageReader{buff: body}
+	kind := r.readByte()
+	name := r.readString()
+	if r.err != nil {
+		return r.err
+	}
+	switch kind {
+	case 'S':
+		stmt, ok := c.statements[name]
+		if !ok {
+			return newPgError(sqlStateInvalidStatementName, "prepared statement \"%s\" does not exist", name)
+		}
+		c.sendParameterDescription(stmt.paramOIDs)
+		if stmt.resultSchema == nil {
+			c.sendMessage(msgNoData)
+		} else {
+			c.sendRowDescription(stmt.resultSchema, nil)
+		}
+	case 'P':
+		p, ok := c.portals[name]
+		if !ok {
+			return newPgError(sqlStateInvalidCursorName, "portal \"%s\" does not exist", name)
+		}
+		if p.stmt.resultSchema == nil {
+			c.sendMessage(msgNoData)
+		} else {
+			c.sendRowDescription(p.stmt.resultSchema, p.resultFormats)
+		}
+	default:
+		return newPgError(sqlStateProtocolViolation, "invalid describe message subtype '%c'", kind)
+	}
+	return nil
+}
+
+func (c *connection) handleExecute(body []byte) error {
+	r := &messageReader{buff: body}
+	name := r.readString()
+	maxRows := r.readInt32()
+	if r.err != nil {
+		return r.err
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return newPgError(sqlStateInvalidCursorName, "portal \"%s\" does not exist", name)
+	}
+	if p.stmt.empty {
+		c.sendMessage(msgEmptyQueryResponse)
+		return nil
+	}
+	if p.results == nil {
+		results, err := c.executeStatement(p.stmt, p.args)
+		if err != nil {
+			return err
+		}
+		p.results = results
+	}
+	rowCount, more := c.sendRows(p.results, p.resultFormats, int(maxRows))
+	if p.results.err != nil {
+		return p.results.err
+	}
+	p.rowCount += rowCount
+	if more {
+		c.sendMessage(msgPortalSuspended)
+		return nil
+	}
+	c.sendCommandComplete(fmt.Sprintf("SELECT %d", p.rowCount))
+	return nil
+}
+
+func (c *connection) executeStatement(stmt *statement, args []any) (*queryResults, error) {
+	return executeQuery(func(o outFunc) error {
+		if stmt.prepareQuery == nil {
+			return stmt.sqlDesc.MapError(c.s.queryManager.ExecuteQueryDirect(stmt.tsl, *stmt.query, query.Limits{}, o))
+		}
+		return stmt.sqlDesc.MapError(c.s.queryManager.ExecuteQueryDirectWithArgs(stmt.tsl, *stmt.prepareQuery, args,
+			query.Limits{}, o))
+	})
+}
+
+func (c *connection) handleClose(body []byte) error {
+	r := &messageReader{buff: body}
+	kind := r.readByte()
+	name := r.readString()
+	if r.err != nil {
+		return r.err
+	}
+	switch kind {
+	case 'S':
+		delete(c.statements, name)
+	case 'P':
+		if p, ok := c.portals[name]; ok {
+			p.close()
+			delete(c.portals, name)
+		}
+	default:
+		return newPgError(sqlStateProtocolViolation, "invalid close message subtype '%c'", kind)
+	}
+	c.sendMessage(msgCloseComplete)
+	return nil
+}
+
+// sendRows sends the rows of the results as DataRow messages. If maxRows > 0, at most maxRows rows are sent, and it
+// returns true if there are more rows to send.
+func (c *connection) sendRows(results *queryResults, formats []int16, maxRows int) (int, bool) {
+	rowCount := 0
+	for results.hasRow() {
+		if maxRows > 0 && rowCount == maxRows {
+			return rowCount, true
+		}
+		c.sendDataRow(results.batch, results.rowIndex, formats)
+		results.rowIndex++
+		rowCount++
+		if len(c.writeBuff) >= flushThreshold {
+			c.flush()
+			if c.writeErr != nil {
+				results.discard()
+				break
+			}
+		}
+	}
+	return rowCount, false
+}
+
+func (c *connection) sendStartupResponse() {
+	buff, lenPos := startMessage(c.writeBuff, msgAuthentication)
+	buff = binary.BigEndian.AppendUint32(buff, 0) // AuthenticationOk
+	buff = endMessage(buff, lenPos)
+	for _, param := range serverParameters {
+		buff, lenPos = startMessage(buff, msgParameterStatus)
+		buff = appendString(buff, param[0])
+		buff = appendString(buff, param[1])
+		buff = endMessage(buff, lenPos)
+	}
+	buff, lenPos = startMessage(buff, msgBackendKeyData)
+	buff = binary.BigEndian.AppendUint32(buff, uint32(c.id))
+	buff = binary.BigEndian.AppendUint32(buff, 0)
+	c.writeBuff = endMessage(buff, lenPos)
+	c.sendReadyForQuery()
+}
+
+func (c *connection) sendNegotiateProtocolVersion(unsupportedOptions []string) {
+	buff, lenPos := startMessage(c.writeBuff, 'v')
+	buff = binary.BigEndian.AppendUint32(buff, protocolVersion3)
+	buff = binary.BigEndian.AppendUint32(buff, uint32(len(unsupportedOptions)))
+	for _, option := range unsupportedOptions {
+		buff = appendString(buff, option)
+	}
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendReadyForQuery() {
+	buff, lenPos := startMessage(c.writeBuff, msgReadyForQuery)
+	buff = append(buff, 'I') // idle - transactions are not supported
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendRowDescription(schema *evbatch.EventSchema, formats []int16) {
+	columnNames := schema.ColumnNames()
+	buff, lenPos := startMessage(c.writeBuff, msgRowDescription)
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(columnNames)))
+	for i, columnType := range schema.ColumnTypes() {
+		buff = appendString(buff, columnNames[i])
+		buff = binary.BigEndian.AppendUint32(buff, 0) // table oid
+		buff = binary.BigEndian.AppendUint16(buff, 0) // column attribute number
+		buff = binary.BigEndian.AppendUint32(buff, columnTypeToOID(columnType))
+		buff = binary.BigEndian.AppendUint16(buff, uint16(typeSize(columnType)))
+		typeModifier := int32(-1)
+		if decType, ok := columnType.(*types.DecimalType); ok {
+			typeModifier = int32(decType.Precision<<16|decType.Scale) + 4
+		}
+		buff = binary.BigEndian.AppendUint32(buff, uint32(typeModifier))
+		buff = binary.BigEndian.AppendUint16(buff, uint16(formatForColumn(formats, i)))
+	}
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendParameterDescription(paramOIDs []uint32) {
+	buff, lenPos := startMessage(c.writeBuff, msgParameterDescription)
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(paramOIDs)))
+	for _, oid := range paramOIDs {
+		buff = binary.BigEndian.AppendUint32(buff, oid)
+	}
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendDataRow(batch *evbatch.Batch, rowIndex int, formats []int16) {
+	buff, lenPos := startMessage(c.writeBuff, msgDataRow)
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(batch.Columns)))
+	for colIndex := range batch.Columns {
+		buff = encodeValue(buff, batch, colIndex, rowIndex, formatForColumn(formats, colIndex))
+	}
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendCommandComplete(tag string) {
+	buff, lenPos := startMessage(c.writeBuff, msgCommandComplete)
+	buff = appendString(buff, tag)
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+// sendMessage sends a message that has no body
+func (c *connection) sendMessage(msgType byte) {
+	buff, lenPos := startMessage(c.writeBuff, msgType)
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) sendError(err error, severity string) {
+	code, msg := errorCodeAndMessage(err)
+	buff, lenPos := startMessage(c.writeBuff, msgErrorResponse)
+	buff = append(buff, 'S')
+	buff = appendString(buff, severity)
+	buff = append(buff, 'V')
+	buff = appendString(buff, severity)
+	buff = append(buff, 'C')
+	buff = appendString(buff, code)
+	buff = append(buff, 'M')
+	buff = appendString(buff, msg)
+	buff = append(buff, 0)
+	c.writeBuff = endMessage(buff, lenPos)
+}
+
+func (c *connection) flush() {
+	if c.writeErr == nil && len(c.writeBuff) > 0 {
+		_, c.writeErr = c.conn.Write(c.writeBuff)
+	}
+	c.writeBuff = c.writeBuff[:0]
+}
+
+func errorCodeAndMessage(err error) (string, string) {
+	var pgErr *pgError
+	if errors.As(err, &pgErr) {
+		return pgErr.code, pgErr.msg
+	}
+	var perr errors.TektiteError
+	if !errors.As(err, &perr) {
+		perr = common.LogInternalError(err)
+	}
+	switch perr.Code {
+	case errors.ParseError, errors.StatementError:
+		return sqlStateSyntaxError, perr.Msg
+	case errors.Unavailable:
+		return sqlStateCannotConnectNow, perr.Msg
+	case errors.InternalError:
+		return sqlStateInternalError, perr.Msg
+	default:
+		return sqlStateQueryError, perr.Msg
+	}
+}
+
+func isEmptyStatement(sql string) bool {
+	return strings.Trim(sql, " \t\r\n;") == ""
+}
+
+func (p *portal) close() {
+	if p.results != nil {
+		p.results.discard()
+	}
+}
+
+type outFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error
+
+// queryResults receives the results of a query as they arrive from the query manager, and tracks the next row to
+// send to the client
+type queryResults struct {
+	batchCh   chan *evbatch.Batch
+	discardCh chan struct{}
+	batch     *evbatch.Batch
+	rowIndex  int
+	schema    *evbatch.EventSchema
+	done      bool
+	// err is set if the query failed after it started executing, once all the results before the failure are read
+	err      error
+	queryErr error
+}
+
+func executeQuery(execFunc func(outFunc) error) (*queryResults, error) {
+	lastCount := uint64(0)
+	results := &queryResults{
+		batchCh:   make(chan *evbatch.Batch, 10),
+		discardCh: make(chan struct{}),
+	}
+	o := func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		if err != nil {
+			results.queryErr = err
+			close(results.batchCh)
+			return nil
+		}
+		if batch != nil {
+			select {
+			case results.batchCh <- batch:
+			case <-results.discardCh:
+				// The results have been discarded, so the query is cancelled
+				return errors.NewTektiteErrorf(errors.ExecuteQueryError, "query results have been discarded")
+			}
+		}
+		if last && atomic.AddUint64(&lastCount, 1) == uint64(numLastBatches) {
+			close(results.batchCh)
+		}
+		return nil
+	}
+	if err := execFunc(o); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// hasRow returns true if there is another row of results, waiting for the next batch to arrive if necessary
+func (q *queryResults) hasRow() bool {
+	for !q.done && (q.batch == nil || q.rowIndex >= q.batch.RowCount) {
+		batch, ok := <-q.batchCh
+		if !ok {
+			q.done = true
+			q.batch = nil
+			// queryErr is set before the channel is closed
+			q.err = q.queryErr
+			break
+		}
+		if q.schema == nil {
+			q.schema = batch.Schema
+		}
+		q.batch = batch
+		q.rowIndex = 0
+	}
+	return !q.done
+}
+
+// discard discards any remaining results, and cancels the query if it is still executing
+func (q *queryResults) discard() {
+	if q.done {
+		return
+	}
+	q.done = true
+	close(q.discardCh)
+}
@@ -0,0 +1,206 @@
+package pgwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	readBuffSize = 8 * 1024
+	// flushThreshold is the number of buffered bytes at which the results of a query are written to the connection,
+	// rather than waiting for the query to complete
+	flushThreshold = 64 * 1024
+	// maxMessageSize protects the server from a client sending a bogus message length
+	maxMessageSize = 64 * 1024 * 1024
+)
+
+// Codes sent in the first message of a connection, in place of the protocol version
+const (
+	protocolVersion3  = 196608
+	sslRequestCode    = 80877103
+	gssEncRequestCode = 80877104
+	cancelRequestCode = 80877102
+)
+
+// Frontend message types
+const (
+	msgQuery     = 'Q'
+	msgParse     = 'P'
+	msgBind      = 'B'
+	msgDescribe  = 'D'
+	msgExecute   = 'E'
+	msgClose     = 'C'
+	msgFlush     = 'H'
+	msgSync      = 'S'
+	msgTerminate = 'X'
+)
+
+// Backend message types
+const (
+	msgAuthentication       = 'R'
+	msgParameterStatus      = 'S'
+	msgBackendKeyData       = 'K'
+	msgReadyForQuery        = 'Z'
+	msgRowDescription       = 'T'
+	msgDataRow              = 'D'
+	msgCommandComplete      = 'C'
+	msgErrorResponse        = 'E'
+	msgEmptyQueryResponse   = 'I'
+	msgParseComplete        = '1'
+	msgBindComplete         = '2'
+	msgCloseComplete        = '3'
+	msgNoData               = 'n'
+	msgParameterDescription = 't'
+	msgPortalSuspended      = 's'
+)
+
+// SQLSTATE error codes sent to the client
+const (
+	sqlStateProtocolViolation          = "08P01"
+	sqlStateFeatureNotSupported        = "0A000"
+	sqlStateInvalidAuthorization       = "28000"
+	sqlStateSyntaxError                = "42601"
+	sqlStateIndeterminateDatatype      = "42P18"
+	sqlStateDuplicatePreparedStatement = "42P05"
+	sqlStateInvalidStatementName       = "26000"
+	sqlStateInvalidCursorName          = "34000"
+	sqlStateInvalidParameterValue      = "22023"
+	sqlStateQueryError                 = "42000"
+	sqlStateCannotConnectNow           = "57P03"
+	sqlStateInternalError              = "XX000"
+)
+
+// pgError is an error that is sent to the client with a specific SQLSTATE code
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (p *pgError) Error() string {
+	return p.msg
+}
+
+func newPgError(code string, msg string, args ...any) error {
+	return &pgError{code: code, msg: fmt.Sprintf(msg, args...)}
+}
+
+// messageReader reads the fields of the body of a frontend message. If the body is too short, err is set and the
+// remaining reads return zero values.
+type messageReader struct {
+	buff []byte
+	pos  int
+	err  error
+}
+
+func (m *messageReader) readInt16() int16 {
+	if !m.check(2) {
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(m.buff[m.pos:]))
+	m.pos += 2
+	return v
+}
+
+func (m *messageReader) readInt32() int32 {
+	if !m.check(4) {
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(m.buff[m.pos:]))
+	m.pos += 4
+	return v
+}
+
+func (m *messageReader) readByte() byte {
+	if !m.check(1) {
+		return 0
+	}
+	v := m.buff[m.pos]
+	m.pos++
+	return v
+}
+
+// readString reads a null terminated string
+func (m *messageReader) readString() string {
+	if m.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(m.buff[m.pos:], 0)
+	if end == -1 {
+		m.err = newPgError(sqlStateProtocolViolation, "invalid message - string is not terminated")
+		return ""
+	}
+	s := string(m.buff[m.pos : m.pos+end])
+	m.pos += end + 1
+	return s
+}
+
+// readBytes reads a value that is prefixed with its length, returning nil for a null value
+func (m *messageReader) readBytes() []byte {
+	l := m.readInt32()
+	if l < 0 || m.err != nil {
+		return nil
+	}
+	if !m.check(int(l)) {
+		return nil
+	}
+	v := m.buff[m.pos : m.pos+int(l)]
+	m.pos += int(l)
+	return v
+}
+
+// readFormats reads a list of format codes
+func (m *messageReader) readFormats() []int16 {
+	numFormats := m.readInt16()
+	if numFormats < 0 {
+		m.err = newPgError(sqlStateProtocolViolation, "invalid message - negative format count")
+		return nil
+	}
+	formats := make([]int16, 0, numFormats)
+	for i := 0; i < int(numFormats) && m.err == nil; i++ {
+		formats = append(formats, m.readInt16())
+	}
+	return formats
+}
+
+func (m *messageReader) check(size int) bool {
+	if m.err != nil {
+		return false
+	}
+	if m.pos+size > len(m.buff) {
+		m.err = newPgError(sqlStateProtocolViolation, "invalid message - message is too short")
+		return false
+	}
+	return true
+}
+
+// formatForColumn returns the format for the column at colIndex, given the format codes sent by the client - no codes
+// means all columns are text, a single code applies to all columns, otherwise there is a code per column
+func formatForColumn(formats []int16, colIndex int) int16 {
+	switch len(formats) {
+	case 0:
+		return formatText
+	case 1:
+		return formats[0]
+	default:
+		return formats[colIndex]
+	}
+}
+
+// startMessage appends the header of a backend message, returning the position of its length, which is filled in by
+// endMessage once the body has been appended
+func startMessage(buff []byte, msgType byte) ([]byte, int) {
+	buff = append(buff, msgType)
+	lenPos := len(buff)
+	return append(buff, 0, 0, 0, 0), lenPos
+}
+
+func endMessage(buff []byte, lenPos int) []byte {
+	binary.BigEndian.PutUint32(buff[lenPos:], uint32(len(buff)-lenPos))
+	return buff
+}
+
+func appendString(buff []byte, s string) []byte {
+	buff = append(buff, s...)
+	return append(buff, 0)
+}
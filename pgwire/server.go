@@ -0,0 +1,136 @@
+package pgwire
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/spirit-labs/tektite/command"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/conf"
+	"github.com/spirit-labs/tektite/errors"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/query"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Server implements the PostgreSQL v3 frontend/backend protocol, so that PostgreSQL clients, drivers and tools can
+// execute SQL queries against Tektite tables.
+//
+// Queries sent with the simple query protocol are executed directly. Statements prepared with the extended query
+// protocol that take parameters are created as prepared queries on all nodes of the cluster, with a name derived from
+// the SQL and the parameter types, so each distinct statement is only prepared once. Clients are not authenticated,
+// other than by client certificates if TLS is configured to require them.
+func NewServer(cfg *conf.Config, queryManager query.Manager, commandManager command.Manager,
+	parser *parser.Parser) *Server {
+	return &Server{
+		cfg:            cfg,
+		queryManager:   queryManager,
+		commandManager: commandManager,
+		parser:         parser,
+	}
+}
+
+type Server struct {
+	cfg                 *conf.Config
+	queryManager        query.Manager
+	commandManager      command.Manager
+	parser              *parser.Parser
+	tlsConfig           *tls.Config
+	listener            net.Listener
+	started             bool
+	lock                sync.RWMutex
+	acceptLoopExitGroup sync.WaitGroup
+	connections         sync.Map
+	connectionSeq       int32
+}
+
+// Start - note that this does not actually start the server - we delay really starting this (with Activate)
+// until the rest of the server is started.
+func (s *Server) Start() error {
+	return nil
+}
+
+func (s *Server) Activate() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return nil
+	}
+	// TLS is negotiated by the client sending an SSLRequest on the plain connection, so we always listen with a plain
+	// listener
+	if s.cfg.PostgresServerTLSConfig.Enabled {
+		tlsConfig, err := conf.CreateServerTLSConfig(s.cfg.PostgresServerTLSConfig)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		s.tlsConfig = tlsConfig
+	}
+	list, err := net.Listen("tcp", s.ListenAddress())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.listener = list
+	s.started = true
+	s.acceptLoopExitGroup.Add(1)
+	common.Go(s.acceptLoop)
+	log.Debugf("started postgres server on %s", s.ListenAddress())
+	return nil
+}
+
+func (s *Server) acceptLoop() {
+	defer s.acceptLoopExitGroup.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			// Ok - was closed
+			break
+		}
+		c := s.newConnection(conn)
+		s.connections.Store(c, struct{}{})
+		c.start()
+	}
+}
+
+func (s *Server) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.started {
+		return nil
+	}
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			// Ignore
+		}
+	}
+	// Wait for accept loop to exit
+	s.acceptLoopExitGroup.Wait()
+
+	// Now close connections
+	s.connections.Range(func(conn, _ interface{}) bool {
+		conn.(*connection).stop()
+		return true
+	})
+	s.started = false
+	return nil
+}
+
+func (s *Server) ListenAddress() string {
+	return s.cfg.PostgresServerAddresses[s.cfg.NodeID]
+}
+
+func (s *Server) removeConnection(conn *connection) {
+	s.connections.Delete(conn)
+}
+
+func (s *Server) newConnection(conn net.Conn) *connection {
+	return &connection{
+		s:          s,
+		id:         atomic.AddInt32(&s.connectionSeq, 1),
+		conn:       conn,
+		reader:     bufio.NewReaderSize(conn, readBuffSize),
+		statements: map[string]*statement{},
+		portals:    map[string]*portal{},
+	}
+}
@@ -0,0 +1,779 @@
+package pgwire
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"github.com/spirit-labs/tektite/clustmgr"
+	"github.com/spirit-labs/tektite/command"
+	"github.com/spirit-labs/tektite/conf"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/opers"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/query"
+	"github.com/spirit-labs/tektite/remoting"
+	"github.com/spirit-labs/tektite/testutils"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	serverKeyPath  = "testdata/serverkey.pem"
+	serverCertPath = "testdata/servercert.pem"
+)
+
+var testColumnNames = []string{"id", "name", "amount"}
+var testColumnTypes = []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeFloat}
+
+func TestSimpleQuery(t *testing.T) {
+	server, queryMgr, _ := startServer(t, false)
+	defer stopServer(t, server)
+	queryMgr.batches = []*evbatch.Batch{
+		createBatch([][]any{{int64(1), "foo", 1.5}, {int64(2), nil, 2.25}}),
+		createBatch([][]any{{int64(3), "bar", nil}}),
+	}
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('Q', appendString(nil, "SELECT id, name, amount FROM orders WHERE id > 0"))
+	rowDesc := client.expectMessage('T')
+	require.Equal(t, []testColumn{
+		{name: "id", oid: oidInt8, format: formatText},
+		{name: "name", oid: oidText, format: formatText},
+		{name: "amount", oid: oidFloat8, format: formatText},
+	}, parseRowDescription(rowDesc))
+	var rows [][]any
+	for i := 0; i < 3; i++ {
+		rows = append(rows, parseDataRow(client.expectMessage('D')))
+	}
+	require.Equal(t, [][]any{{"1", "foo", "1.5"}, {"2", nil, "2.25"}, {"3", "bar", nil}}, rows)
+	require.Equal(t, "SELECT 3", readCString(client.expectMessage('C')))
+	client.expectReadyForQuery()
+
+	require.Equal(t, "(scan all from orders)->(filter by (id > 0))->(project id, name, amount)", queryMgr.getTSL())
+}
+
+func TestSimpleQueryNoRows(t *testing.T) {
+	server, queryMgr, _ := startServer(t, false)
+	defer stopServer(t, server)
+	queryMgr.resultSchema = evbatch.NewEventSchema(testColumnNames, testColumnTypes)
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('Q', appendString(nil, "SELECT * FROM orders"))
+	require.Equal(t, 3, len(parseRowDescription(client.expectMessage('T'))))
+	require.Equal(t, "SELECT 0", readCString(client.expectMessage('C')))
+	client.expectReadyForQuery()
+}
+
+func TestSimpleQueryEmpty(t *testing.T) {
+	server, _, _ := startServer(t, false)
+	defer stopServer(t, server)
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('Q', appendString(nil, " ; "))
+	client.expectMessage('I')
+	client.expectReadyForQuery()
+}
+
+func TestSimpleQueryPrepare(t *testing.T) {
+	server, _, commandMgr := startServer(t, false)
+	defer stopServer(t, server)
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('Q', appendString(nil, "PREPARE my_query (int) AS SELECT * FROM orders WHERE id = $1"))
+	require.Equal(t, "PREPARE", readCString(client.expectMessage('C')))
+	client.expectReadyForQuery()
+	require.Equal(t, "prepare my_query($p1:int) := (scan all from orders)->(filter by (id == $p1:int))",
+		commandMgr.getCommands()[0])
+}
+
+func TestSimpleQueryErrors(t *testing.T) {
+	server, queryMgr, _ := startServer(t, false)
+	defer stopServer(t, server)
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('Q', appendString(nil, "SELECT * FROM"))
+	code, msg := parseErrorResponse(client.expectMessage('E'))
+	require.Equal(t, sqlStateSyntaxError, code)
+	require.Contains(t, msg, "reached end of statement")
+	client.expectReadyForQuery()
+
+	queryMgr.err = errors.NewTektiteErrorf(errors.Unavailable, "not ready")
+	client.send('Q', appendString(nil, "SELECT * FROM orders"))
+	code, msg = parseErrorResponse(client.expectMessage('E'))
+	require.Equal(t, sqlStateCannotConnectNow, code)
+	require.Equal(t, "not ready", msg)
+	client.expectReadyForQuery()
+}
+
+func TestExtendedQueryWithParams(t *testing.T) {
+	server, queryMgr, commandMgr := startServer(t, false)
+	defer stopServer(t, server)
+	queryMgr.batches = []*evbatch.Batch{
+		createBatch([][]any{{int64(7), "foo", 1.5}, {int64(8), nil, nil}}),
+	}
+	client := connect(t, server, false)
+	defer client.close()
+
+	sql := "SELECT id, name, amount FROM orders WHERE id = $1 AND name = $2"
+	client.send('P', parseMessage("stmt1", sql, oidInt4, oidVarchar))
+	client.send('D', append([]byte{'S'}, appendString(nil, "stmt1")...))
+	// The params are sent as int4 in binary format and text in text format, and the results are requested in binary
+	bind := bindMessage("", "stmt1", []int16{formatBinary, formatText},
+		[][]byte{binary.BigEndian.AppendUint32(nil, 7), []byte("foo")}, []int16{formatBinary})
+	client.send('B', bind)
+	client.send('D', append([]byte{'P'}, appendString(nil, "")...))
+	client.send('E', executeMessage("", 0))
+	client.send('S', nil)
+
+	client.expectMessage('1')
+	paramDesc := client.expectMessage('t')
+	require.Equal(t, []byte{0, 2, 0, 0, 0, oidInt4, 0, 0, oidVarchar >> 8, oidVarchar & 0xff}, paramDesc)
+	require.Equal(t, []testColumn{
+		{name: "id", oid: oidInt8, format: formatText},
+		{name: "name", oid: oidText, format: formatText},
+		{name: "amount", oid: oidFloat8, format: formatText},
+	}, parseRowDescription(client.expectMessage('T')))
+	client.expectMessage('2')
+	require.Equal(t, []testColumn{
+		{name: "id", oid: oidInt8, format: formatBinary},
+		{name: "name", oid: oidText, format: formatBinary},
+		{name: "amount", oid: oidFloat8, format: formatBinary},
+	}, parseRowDescription(client.expectMessage('T')))
+	row1 := parseDataRow(client.expectMessage('D'))
+	require.Equal(t, []any{string(binary.BigEndian.AppendUint64(nil, 7)), "foo",
+		string(binary.BigEndian.AppendUint64(nil, math.Float64bits(1.5)))}, row1)
+	row2 := parseDataRow(client.expectMessage('D'))
+	require.Equal(t, []any{string(binary.BigEndian.AppendUint64(nil, 8)), nil, nil}, row2)
+	require.Equal(t, "SELECT 2", readCString(client.expectMessage('C')))
+	client.expectReadyForQuery()
+
+	// The statement is executed directly with its args - it is not prepared on the cluster
+	require.Equal(t, 0, len(commandMgr.getCommands()))
+	require.Equal(t, "prepare pg_statement($p1:int, $p2:string) := (scan all from orders)->(filter by (id == $p1:int) && (name == $p2:string))->(project id, name, amount)",
+		queryMgr.getTSL())
+	require.Equal(t, []any{int64(7), "foo"}, queryMgr.getArgs())
+	require.Equal(t, 0, len(queryMgr.preparedQueries))
+}
+
+func TestExtendedQueryMaxRows(t *testing.T) {
+	server, queryMgr, _ := startServer(t, false)
+	defer stopServer(t, server)
+	queryMgr.batches = []*evbatch.Batch{
+		createBatch([][]any{{int64(1), "a", 1.0}, {int64(2), "b", 2.0}}),
+		createBatch([][]any{{int64(3), "c", 3.0}}),
+	}
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('P', parseMessage("", "SELECT * FROM orders"))
+	client.send('B', bindMessage("", "", nil, nil, nil))
+	client.send('E', executeMessage("", 2))
+	client.send('E', executeMessage("", 2))
+	client.send('S', nil)
+
+	client.expectMessage('1')
+	client.expectMessage('2')
+	require.Equal(t, []any{"1", "a", "1"}, parseDataRow(client.expectMessage('D')))
+	require.Equal(t, []any{"2", "b", "2"}, parseDataRow(client.expectMessage('D')))
+	client.expectMessage('s')
+	require.Equal(t, []any{"3", "c", "3"}, parseDataRow(client.expectMessage('D')))
+	require.Equal(t, "SELECT 3", readCString(client.expectMessage('C')))
+	client.expectReadyForQuery()
+	require.Equal(t, "(scan all from orders)", queryMgr.getTSL())
+}
+
+func TestExtendedQueryErrorSkipsToSync(t *testing.T) {
+	server, _, _ := startServer(t, false)
+	defer stopServer(t, server)
+	client := connect(t, server, false)
+	defer client.close()
+
+	// The type of the param isn't specified
+	client.send('P', parseMessage("", "SELECT * FROM orders WHERE id = $1", 0))
+	client.send('B', bindMessage("", "", nil, nil, nil))
+	client.send('E', executeMessage("", 0))
+	client.send('S', nil)
+
+	code, msg := parseErrorResponse(client.expectMessage('E'))
+	require.Equal(t, sqlStateIndeterminateDatatype, code)
+	require.Equal(t, "could not determine data type of parameter $1 - parameter types must be specified when the statement is prepared",
+		msg)
+	// The bind and execute are skipped
+	client.expectReadyForQuery()
+
+	client.send('B', bindMessage("", "unknown", nil, nil, nil))
+	client.send('S', nil)
+	code, msg = parseErrorResponse(client.expectMessage('E'))
+	require.Equal(t, sqlStateInvalidStatementName, code)
+	require.Equal(t, `prepared statement "unknown" does not exist`, msg)
+	client.expectReadyForQuery()
+}
+
+func TestCloseStatementAndPortal(t *testing.T) {
+	server, _, _ := startServer(t, false)
+	defer stopServer(t, server)
+	client := connect(t, server, false)
+	defer client.close()
+
+	client.send('P', parseMessage("stmt1", "SELECT * FROM orders"))
+	client.send('B', bindMessage("portal1", "stmt1", nil, nil, nil))
+	client.send('C', append([]byte{'P'}, appendString(nil, "portal1")...))
+	client.send('C', append([]byte{'S'}, appendString(nil, "stmt1")...))
+	client.send('E', executeMessage("portal1", 0))
+	client.send('S', nil)
+
+	client.expectMessage('1')
+	client.expectMessage('2')
+	client.expectMessage('3')
+	client.expectMessage('3')
+	code, _ := parseErrorResponse(client.expectMessage('E'))
+	require.Equal(t, sqlStateInvalidCursorName, code)
+	client.expectReadyForQuery()
+}
+
+func TestTLS(t *testing.T) {
+	server, queryMgr, _ := startServer(t, true)
+	defer stopServer(t, server)
+	queryMgr.batches = []*evbatch.Batch{createBatch([][]any{{int64(1), "foo", 1.5}})}
+
+	client := connect(t, server, true)
+	defer client.close()
+	client.send('Q', appendString(nil, "SELECT * FROM orders"))
+	client.expectMessage('T')
+	require.Equal(t, []any{"1", "foo", "1.5"}, parseDataRow(client.expectMessage('D')))
+	client.expectMessage('C')
+	client.expectReadyForQuery()
+
+	// Connecting without TLS is rejected
+	conn, err := net.Dial("tcp", server.ListenAddress())
+	require.NoError(t, err)
+	defer closeConn(conn)
+	plainClient := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	plainClient.sendStartup()
+	code, msg := parseErrorResponse(plainClient.expectMessage('E'))
+	require.Equal(t, sqlStateInvalidAuthorization, code)
+	require.Equal(t, "the server requires connections to use SSL", msg)
+}
+
+func TestSSLRequestRefusedWhenTLSNotEnabled(t *testing.T) {
+	server, _, _ := startServer(t, false)
+	defer stopServer(t, server)
+	conn, err := net.Dial("tcp", server.ListenAddress())
+	require.NoError(t, err)
+	defer closeConn(conn)
+	client := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	client.sendSSLRequest()
+	resp, err := client.reader.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('N'), resp)
+	// The client can continue without TLS
+	client.sendStartup()
+	client.expectStartupResponse()
+}
+
+func startServer(t *testing.T, tlsEnabled bool) (*Server, *testQueryManager, *testCommandManager) {
+	t.Helper()
+	cfg := &conf.Config{
+		PostgresServerEnabled:   true,
+		PostgresServerAddresses: []string{fmt.Sprintf("localhost:%d", testutils.PortProvider.GetPort(t))},
+	}
+	if tlsEnabled {
+		cfg.PostgresServerTLSConfig = conf.TLSConfig{
+			Enabled:  true,
+			KeyPath:  serverKeyPath,
+			CertPath: serverCertPath,
+		}
+	}
+	queryMgr := &testQueryManager{preparedQueries: map[string]*parser.PrepareQueryDesc{}}
+	commandMgr := &testCommandManager{queryMgr: queryMgr, parser: parser.NewParser(nil)}
+	server := NewServer(cfg, queryMgr, commandMgr, parser.NewParser(nil))
+	require.NoError(t, server.Start())
+	require.NoError(t, server.Activate())
+	return server, queryMgr, commandMgr
+}
+
+func stopServer(t *testing.T, server *Server) {
+	require.NoError(t, server.Stop())
+}
+
+func connect(t *testing.T, server *Server, useTLS bool) *testClient {
+	conn, err := net.Dial("tcp", server.ListenAddress())
+	require.NoError(t, err)
+	client := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	if useTLS {
+		client.sendSSLRequest()
+		resp, err := client.reader.ReadByte()
+		require.NoError(t, err)
+		require.Equal(t, byte('S'), resp)
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		require.NoError(t, tlsConn.Handshake())
+		client.conn = tlsConn
+		client.reader = bufio.NewReader(tlsConn)
+	}
+	client.sendStartup()
+	client.expectStartupResponse()
+	return client
+}
+
+func closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		// Ignore
+	}
+}
+
+// testClient is a minimal client of the PostgreSQL wire protocol
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *testClient) sendSSLRequest() {
+	buff := binary.BigEndian.AppendUint32(nil, 8)
+	buff = binary.BigEndian.AppendUint32(buff, sslRequestCode)
+	_, err := c.conn.Write(buff)
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) sendStartup() {
+	buff := binary.BigEndian.AppendUint32(nil, 0)
+	buff = binary.BigEndian.AppendUint32(buff, protocolVersion3)
+	buff = appendString(buff, "user")
+	buff = appendString(buff, "tektite")
+	buff = appendString(buff, "database")
+	buff = appendString(buff, "tektite")
+	buff = append(buff, 0)
+	binary.BigEndian.PutUint32(buff, uint32(len(buff)))
+	_, err := c.conn.Write(buff)
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) expectStartupResponse() {
+	require.Equal(c.t, []byte{0, 0, 0, 0}, c.expectMessage('R'))
+	params := map[string]string{}
+	for {
+		msgType, body := c.readMessage()
+		if msgType == 'K' {
+			break
+		}
+		require.Equal(c.t, byte('S'), msgType)
+		parts := strings.Split(string(body), "\x00")
+		params[parts[0]] = parts[1]
+	}
+	require.Equal(c.t, "UTF8", params["client_encoding"])
+	require.Equal(c.t, "14.0", params["server_version"])
+	c.expectReadyForQuery()
+}
+
+func (c *testClient) send(msgType byte, body []byte) {
+	buff := []byte{msgType}
+	buff = binary.BigEndian.AppendUint32(buff, uint32(len(body)+4))
+	buff = append(buff, body...)
+	_, err := c.conn.Write(buff)
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) readMessage() (byte, []byte) {
+	var header [5]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(c.t, err)
+	body := make([]byte, int(binary.BigEndian.Uint32(header[1:]))-4)
+	_, err = io.ReadFull(c.reader, body)
+	require.NoError(c.t, err)
+	return header[0], body
+}
+
+func (c *testClient) expectMessage(msgType byte) []byte {
+	actualType, body := c.readMessage()
+	if actualType == 'E' && msgType != 'E' {
+		_, msg := parseErrorResponse(body)
+		require.Fail(c.t, "unexpected error response", msg)
+	}
+	require.Equal(c.t, string(msgType), string(actualType))
+	return body
+}
+
+func (c *testClient) expectReadyForQuery() {
+	require.Equal(c.t, []byte{'I'}, c.expectMessage('Z'))
+}
+
+func (c *testClient) close() {
+	c.send('X', nil)
+	closeConn(c.conn)
+}
+
+func parseMessage(name string, sql string, paramOIDs ...uint32) []byte {
+	buff := appendString(nil, name)
+	buff = appendString(buff, sql)
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(paramOIDs)))
+	for _, oid := range paramOIDs {
+		buff = binary.BigEndian.AppendUint32(buff, oid)
+	}
+	return buff
+}
+
+func bindMessage(portalName string, stmtName string, paramFormats []int16, params [][]byte, resultFormats []int16) []byte {
+	buff := appendString(nil, portalName)
+	buff = appendString(buff, stmtName)
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(paramFormats)))
+	for _, format := range paramFormats {
+		buff = binary.BigEndian.AppendUint16(buff, uint16(format))
+	}
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(params)))
+	for _, param := range params {
+		buff = binary.BigEndian.AppendUint32(buff, uint32(len(param)))
+		buff = append(buff, param...)
+	}
+	buff = binary.BigEndian.AppendUint16(buff, uint16(len(resultFormats)))
+	for _, format := range resultFormats {
+		buff = binary.BigEndian.AppendUint16(buff, uint16(format))
+	}
+	return buff
+}
+
+func executeMessage(portalName string, maxRows int) []byte {
+	buff := appendString(nil, portalName)
+	return binary.BigEndian.AppendUint32(buff, uint32(maxRows))
+}
+
+type testColumn struct {
+	name   string
+	oid    uint32
+	format int16
+}
+
+func parseRowDescription(body []byte) []testColumn {
+	r := &messageReader{buff: body}
+	numCols := r.readInt16()
+	var cols []testColumn
+	for i := 0; i < int(numCols); i++ {
+		col := testColumn{name: r.readString()}
+		r.readInt32()
+		r.readInt16()
+		col.oid = uint32(r.readInt32())
+		r.readInt16()
+		r.readInt32()
+		col.format = r.readInt16()
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+func parseDataRow(body []byte) []any {
+	r := &messageReader{buff: body}
+	numCols := r.readInt16()
+	var row []any
+	for i := 0; i < int(numCols); i++ {
+		val := r.readBytes()
+		if val == nil {
+			row = append(row, nil)
+		} else {
+			row = append(row, string(val))
+		}
+	}
+	return row
+}
+
+func parseErrorResponse(body []byte) (string, string) {
+	r := &messageReader{buff: body}
+	var code, msg string
+	for {
+		fieldType := r.readByte()
+		if fieldType == 0 {
+			return code, msg
+		}
+		val := r.readString()
+		switch fieldType {
+		case 'C':
+			code = val
+		case 'M':
+			msg = val
+		}
+	}
+}
+
+func readCString(body []byte) string {
+	r := &messageReader{buff: body}
+	return r.readString()
+}
+
+func createBatch(rows [][]any) *evbatch.Batch {
+	builders := evbatch.CreateColBuilders(testColumnTypes)
+	for _, row := range rows {
+		for i, val := range row {
+			if val == nil {
+				builders[i].AppendNull()
+				continue
+			}
+			switch v := val.(type) {
+			case int64:
+				builders[i].(*evbatch.IntColBuilder).Append(v)
+			case string:
+				builders[i].(*evbatch.StringColBuilder).Append(v)
+			case float64:
+				builders[i].(*evbatch.FloatColBuilder).Append(v)
+			}
+		}
+	}
+	return evbatch.NewBatchFromBuilders(evbatch.NewEventSchema(testColumnNames, testColumnTypes), builders...)
+}
+
+type testQueryManager struct {
+	lock            sync.Mutex
+	batches         []*evbatch.Batch
+	resultSchema    *evbatch.EventSchema
+	err             error
+	tsl             string
+	queryName       string
+	args            []any
+	preparedQueries map[string]*parser.PrepareQueryDesc
+}
+
+func (t *testQueryManager) getTSL() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.tsl
+}
+
+func (t *testQueryManager) getExecState() (string, []any) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.queryName, t.args
+}
+
+func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) {
+	batches := t.batches
+	go func() {
+		if len(batches) == 0 {
+			if err := outputFunc(true, 1, nil, nil); err != nil {
+				panic(err)
+			}
+			return
+		}
+		for i, batch := range batches {
+			if err := outputFunc(i == len(batches)-1, 1, batch, nil); err != nil {
+				panic(err)
+			}
+		}
+	}()
+}
+
+func (t *testQueryManager) PrepareQuery(prepareQuery parser.PrepareQueryDesc) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.preparedQueries[prepareQuery.QueryName] = &prepareQuery
+	return nil
+}
+
+func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, _ query.Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return 0, t.err
+	}
+	t.queryName = queryName
+	t.args = args
+	t.sendBatches(outputFunc)
+	return 1, nil
+}
+
+func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, opers.PageStart, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, _ query.Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.tsl = tsl
+	t.sendBatches(outputFunc)
+	return nil
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithArgs(tsl string, _ parser.PrepareQueryDesc, args []any, _ query.Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.tsl = tsl
+	t.args = args
+	t.sendBatches(outputFunc)
+	return nil
+}
+
+func (t *testQueryManager) getArgs() []any {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.args
+}
+
+func (t *testQueryManager) SetLastCompletedVersion(int64) {
+}
+
+func (t *testQueryManager) ExecuteRemoteQuery(*clustermsgs.QueryMessage) error {
+	return nil
+}
+
+func (t *testQueryManager) ReceiveQueryResult(*clustermsgs.QueryResponse) {
+}
+
+func (t *testQueryManager) GetPreparedQueryParamSchema(preparedQueryName string) *evbatch.EventSchema {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	desc, ok := t.preparedQueries[preparedQueryName]
+	if !ok {
+		return nil
+	}
+	var paramNames []string
+	var paramTypes []types.ColumnType
+	for _, param := range desc.Params {
+		paramNames = append(paramNames, param.ParamName)
+		paramTypes = append(paramTypes, param.ParamType)
+	}
+	return evbatch.NewEventSchema(paramNames, paramTypes)
+}
+
+func (t *testQueryManager) GetPreparedQueryLimit(string) int {
+	return 0
+}
+
+func (t *testQueryManager) GetPreparedQueryNextPage(string, opers.PageStart, *evbatch.Batch) *opers.PageStart {
+	return nil
+}
+
+func (t *testQueryManager) GetPreparedQueryResultSchema(string) *evbatch.EventSchema {
+	return evbatch.NewEventSchema(testColumnNames, testColumnTypes)
+}
+
+func (t *testQueryManager) GetQueryResultSchema(parser.QueryDesc, []parser.PreparedStatementParam) (*evbatch.EventSchema, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.resultSchema != nil {
+		return t.resultSchema, nil
+	}
+	return evbatch.NewEventSchema(testColumnNames, testColumnTypes), nil
+}
+
+func (t *testQueryManager) SetClusterMessageHandlers(remoting.Server, *remoting.TeeBlockingClusterMessageHandler) {
+}
+
+func (t *testQueryManager) GetLastCompletedVersion() int {
+	return 0
+}
+
+func (t *testQueryManager) GetLastFlushedVersion() int {
+	return 0
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithHighestVersion(string, parser.QueryDesc, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectIfChanged(string, parser.QueryDesc, int64, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectByKey(string, parser.QueryDesc, int64, query.Limits) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectForChangedKeys(parser.QueryDesc, int64, int64) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) RegisterCompletedVersionListener(string, func(version int64)) {
+}
+
+func (t *testQueryManager) UnregisterCompletedVersionListener(string) {
+}
+
+func (t *testQueryManager) RunningQueries() []query.RunningQuery {
+	return nil
+}
+
+func (t *testQueryManager) CancelQuery(string) error {
+	return nil
+}
+
+func (t *testQueryManager) CancelRemoteQuery(*clustermsgs.QueryCancelMessage) {
+}
+
+func (t *testQueryManager) Activate() {
+}
+
+func (t *testQueryManager) Start() error {
+	return nil
+}
+
+func (t *testQueryManager) Stop() error {
+	return nil
+}
+
+// testCommandManager records the commands it executes, and prepares queries with the query manager
+type testCommandManager struct {
+	lock     sync.Mutex
+	commands []string
+	queryMgr *testQueryManager
+	parser   *parser.Parser
+}
+
+func (t *testCommandManager) getCommands() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.commands
+}
+
+func (t *testCommandManager) ExecuteCommand(command string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.commands = append(t.commands, command)
+	desc, err := t.parser.ParseTSL(command)
+	if err != nil {
+		return err
+	}
+	if desc.PrepareQuery != nil {
+		return t.queryMgr.PrepareQuery(*desc.PrepareQuery)
+	}
+	return nil
+}
+
+func (t *testCommandManager) SetCommandSignaller(command.Signaller) {
+}
+
+func (t *testCommandManager) SetPrefixRetentionService(command.PrefixRetention) {
+}
+
+func (t *testCommandManager) HandleClusterState(clustmgr.ClusterState) error {
+	return nil
+}
+
+func (t *testCommandManager) Start() error {
+	return nil
+}
+
+func (t *testCommandManager) Stop() error {
+	return nil
+}
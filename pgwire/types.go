@@ -0,0 +1,270 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/types"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object IDs of the PostgreSQL types that Tektite column types are mapped to
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidName        = 19
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidJSON        = 114
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidUnknown     = 705
+	oidBpchar      = 1042
+	oidVarchar     = 1043
+	oidTimestamp   = 1114
+	oidTimestamptz = 1184
+	oidNumeric     = 1700
+)
+
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+// postgresEpochMicros is the PostgreSQL epoch, 2000-01-01 00:00:00 UTC, in microseconds past the unix epoch. Binary
+// timestamps are encoded as microseconds past the PostgreSQL epoch.
+const postgresEpochMicros = 946684800000000
+
+const timestampFormat = "2006-01-02 15:04:05.000"
+
+var timestampParseFormats = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// columnTypeToOID returns the object ID of the PostgreSQL type that a column type is mapped to
+func columnTypeToOID(columnType types.ColumnType) uint32 {
+	switch columnType.ID() {
+	case types.ColumnTypeIDInt:
+		return oidInt8
+	case types.ColumnTypeIDFloat:
+		return oidFloat8
+	case types.ColumnTypeIDBool:
+		return oidBool
+	case types.ColumnTypeIDDecimal:
+		return oidNumeric
+	case types.ColumnTypeIDString:
+		return oidText
+	case types.ColumnTypeIDBytes:
+		return oidBytea
+	case types.ColumnTypeIDTimestamp:
+		return oidTimestamp
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return oidJSON
+	default:
+		panic("unknown type")
+	}
+}
+
+// oidToColumnType returns the column type for a parameter with the PostgreSQL type oid
+func oidToColumnType(oid uint32) (types.ColumnType, bool) {
+	switch oid {
+	case oidInt2, oidInt4, oidInt8:
+		return types.ColumnTypeInt, true
+	case oidFloat4, oidFloat8:
+		return types.ColumnTypeFloat, true
+	case oidBool:
+		return types.ColumnTypeBool, true
+	case oidNumeric:
+		return &types.DecimalType{Precision: types.DefaultDecimalPrecision, Scale: types.DefaultDecimalScale}, true
+	case oidText, oidVarchar, oidBpchar, oidName:
+		return types.ColumnTypeString, true
+	case oidBytea:
+		return types.ColumnTypeBytes, true
+	case oidTimestamp, oidTimestamptz:
+		return types.ColumnTypeTimestamp, true
+	default:
+		return nil, false
+	}
+}
+
+// typeSize returns the size in bytes of the PostgreSQL type that a column type is mapped to, or -1 if it has a
+// variable size
+func typeSize(columnType types.ColumnType) int16 {
+	switch columnType.ID() {
+	case types.ColumnTypeIDInt, types.ColumnTypeIDFloat, types.ColumnTypeIDTimestamp:
+		return 8
+	case types.ColumnTypeIDBool:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// encodeValue appends the value at rowIndex of the column in the given format, as a length prefixed field of a DataRow
+func encodeValue(buff []byte, batch *evbatch.Batch, colIndex int, rowIndex int, format int16) []byte {
+	col := batch.Columns[colIndex]
+	if col.IsNull(rowIndex) {
+		return binary.BigEndian.AppendUint32(buff, math.MaxUint32) // -1
+	}
+	lenPos := len(buff)
+	buff = append(buff, 0, 0, 0, 0)
+	columnType := batch.Schema.ColumnTypes()[colIndex]
+	if format == formatBinary {
+		switch columnType.ID() {
+		case types.ColumnTypeIDInt:
+			buff = binary.BigEndian.AppendUint64(buff, uint64(batch.GetIntColumn(colIndex).Get(rowIndex)))
+		case types.ColumnTypeIDFloat:
+			buff = binary.BigEndian.AppendUint64(buff, math.Float64bits(batch.GetFloatColumn(colIndex).Get(rowIndex)))
+		case types.ColumnTypeIDBool:
+			if batch.GetBoolColumn(colIndex).Get(rowIndex) {
+				buff = append(buff, 1)
+			} else {
+				buff = append(buff, 0)
+			}
+		case types.ColumnTypeIDString:
+			buff = append(buff, batch.GetStringColumn(colIndex).Get(rowIndex)...)
+		case types.ColumnTypeIDBytes:
+			buff = append(buff, batch.GetBytesColumn(colIndex).Get(rowIndex)...)
+		case types.ColumnTypeIDTimestamp:
+			micros := batch.GetTimestampColumn(colIndex).Get(rowIndex).Val*1000 - postgresEpochMicros
+			buff = binary.BigEndian.AppendUint64(buff, uint64(micros))
+		default:
+			panic("unsupported binary format")
+		}
+	} else {
+		switch columnType.ID() {
+		case types.ColumnTypeIDInt:
+			buff = strconv.AppendInt(buff, batch.GetIntColumn(colIndex).Get(rowIndex), 10)
+		case types.ColumnTypeIDFloat:
+			buff = strconv.AppendFloat(buff, batch.GetFloatColumn(colIndex).Get(rowIndex), 'g', -1, 64)
+		case types.ColumnTypeIDBool:
+			if batch.GetBoolColumn(colIndex).Get(rowIndex) {
+				buff = append(buff, 't')
+			} else {
+				buff = append(buff, 'f')
+			}
+		case types.ColumnTypeIDDecimal:
+			d := batch.GetDecimalColumn(colIndex).Get(rowIndex)
+			buff = append(buff, d.Num.ToString(int32(d.Scale))...)
+		case types.ColumnTypeIDString:
+			buff = append(buff, batch.GetStringColumn(colIndex).Get(rowIndex)...)
+		case types.ColumnTypeIDBytes:
+			buff = append(buff, '\\', 'x')
+			buff = append(buff, hex.EncodeToString(batch.GetBytesColumn(colIndex).Get(rowIndex))...)
+		case types.ColumnTypeIDTimestamp:
+			ts := batch.GetTimestampColumn(colIndex).Get(rowIndex)
+			buff = time.UnixMilli(ts.Val).UTC().AppendFormat(buff, timestampFormat)
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			// nested values are sent as json
+			val := types.ToJSONValue(columnType, batch.GetNestedColumn(colIndex).Get(rowIndex))
+			bytes, err := json.Marshal(val)
+			if err != nil {
+				panic(err) // values converted by ToJSONValue can always be marshalled
+			}
+			buff = append(buff, bytes...)
+		default:
+			panic("unknown type")
+		}
+	}
+	binary.BigEndian.PutUint32(buff[lenPos:], uint32(len(buff)-lenPos-4))
+	return buff
+}
+
+// supportsBinaryFormat returns true if values of the column type can be sent in binary format
+func supportsBinaryFormat(columnType types.ColumnType) bool {
+	return columnType.ID() != types.ColumnTypeIDDecimal && !types.IsNestedType(columnType)
+}
+
+// decodeParam decodes the value of a prepared statement parameter sent by the client in the given format
+func decodeParam(val []byte, columnType types.ColumnType, format int16) (any, error) {
+	if format == formatBinary {
+		return decodeBinaryParam(val, columnType)
+	}
+	sVal := string(val)
+	switch columnType.ID() {
+	case types.ColumnTypeIDInt:
+		return strconv.ParseInt(strings.TrimSpace(sVal), 10, 64)
+	case types.ColumnTypeIDFloat:
+		return strconv.ParseFloat(strings.TrimSpace(sVal), 64)
+	case types.ColumnTypeIDBool:
+		switch strings.ToLower(strings.TrimSpace(sVal)) {
+		case "t", "true", "y", "yes", "on", "1":
+			return true, nil
+		case "f", "false", "n", "no", "off", "0":
+			return false, nil
+		}
+		return nil, errors.Errorf("invalid bool value '%s'", sVal)
+	case types.ColumnTypeIDDecimal:
+		decType := columnType.(*types.DecimalType)
+		return types.NewDecimalFromString(strings.TrimSpace(sVal), decType.Precision, decType.Scale)
+	case types.ColumnTypeIDString:
+		return sVal, nil
+	case types.ColumnTypeIDBytes:
+		if strings.HasPrefix(sVal, "\\x") {
+			return hex.DecodeString(sVal[2:])
+		}
+		return val, nil
+	case types.ColumnTypeIDTimestamp:
+		sVal = strings.TrimSpace(sVal)
+		for _, layout := range timestampParseFormats {
+			t, err := time.Parse(layout, sVal)
+			if err == nil {
+				return types.NewTimestamp(t.UnixMilli()), nil
+			}
+		}
+		return nil, errors.Errorf("invalid timestamp value '%s'", sVal)
+	case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+		return nil, errors.Errorf("parameters of type %s are not supported", columnType.String())
+	default:
+		panic("unknown type")
+	}
+}
+
+func decodeBinaryParam(val []byte, columnType types.ColumnType) (any, error) {
+	switch columnType.ID() {
+	case types.ColumnTypeIDInt:
+		switch len(val) {
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(val))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(val))), nil
+		case 8:
+			return int64(binary.BigEndian.Uint64(val)), nil
+		}
+	case types.ColumnTypeIDFloat:
+		switch len(val) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(val))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(val)), nil
+		}
+	case types.ColumnTypeIDBool:
+		if len(val) == 1 {
+			return val[0] != 0, nil
+		}
+	case types.ColumnTypeIDString:
+		return string(val), nil
+	case types.ColumnTypeIDBytes:
+		return val, nil
+	case types.ColumnTypeIDTimestamp:
+		if len(val) == 8 {
+			micros := int64(binary.BigEndian.Uint64(val)) + postgresEpochMicros
+			return types.NewTimestamp(micros / 1000), nil
+		}
+	default:
+		return nil, errors.Errorf("binary format is not supported for parameters of type %s", columnType.String())
+	}
+	return nil, errors.Errorf("invalid binary value for parameter of type %s", columnType.String())
+}
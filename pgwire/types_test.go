@@ -0,0 +1,124 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEncodeTextValues(t *testing.T) {
+	decType := &types.DecimalType{Precision: 10, Scale: 2}
+	dec, err := types.NewDecimalFromString("1234.56", 10, 2)
+	require.NoError(t, err)
+	ts := time.Date(2024, 3, 4, 5, 6, 7, 890000000, time.UTC).UnixMilli()
+	batch := createTypesBatch(t, decType, true, dec, []byte("abc"), ts)
+
+	expected := []string{"t", "1234.56", `\x616263`, "2024-03-04 05:06:07.890"}
+	for colIndex, exp := range expected {
+		buff := encodeValue(nil, batch, colIndex, 0, formatText)
+		require.Equal(t, exp, string(buff[4:]))
+		require.Equal(t, uint32(len(exp)), binary.BigEndian.Uint32(buff))
+	}
+	// null
+	buff := encodeValue(nil, batch, 0, 1, formatText)
+	require.Equal(t, []byte{0xff, 0xff, 0xff, 0xff}, buff)
+}
+
+func TestEncodeBinaryTimestamp(t *testing.T) {
+	ts := time.Date(2000, 1, 1, 0, 0, 1, 0, time.UTC).UnixMilli()
+	batch := createTypesBatch(t, &types.DecimalType{Precision: 10, Scale: 2}, false, types.Decimal{}, nil, ts)
+	buff := encodeValue(nil, batch, 3, 0, formatBinary)
+	require.Equal(t, int64(1000000), int64(binary.BigEndian.Uint64(buff[4:])))
+}
+
+func TestDecodeParams(t *testing.T) {
+	decType := &types.DecimalType{Precision: 38, Scale: 6}
+	dec, err := types.NewDecimalFromString("12.5", 38, 6)
+	require.NoError(t, err)
+	ts := types.NewTimestamp(time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC).UnixMilli())
+	testCases := []struct {
+		val        string
+		columnType types.ColumnType
+		expected   any
+	}{
+		{"123", types.ColumnTypeInt, int64(123)},
+		{"1.25", types.ColumnTypeFloat, 1.25},
+		{"true", types.ColumnTypeBool, true},
+		{"f", types.ColumnTypeBool, false},
+		{"12.5", decType, dec},
+		{"foo", types.ColumnTypeString, "foo"},
+		{`\x616263`, types.ColumnTypeBytes, []byte("abc")},
+		{"2024-03-04 05:06:07", types.ColumnTypeTimestamp, ts},
+		{"2024-03-04 05:06:07+00", types.ColumnTypeTimestamp, ts},
+		{"2024-03-04T05:06:07Z", types.ColumnTypeTimestamp, ts},
+	}
+	for _, tc := range testCases {
+		val, err := decodeParam([]byte(tc.val), tc.columnType, formatText)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, val)
+	}
+
+	_, err = decodeParam([]byte("foo"), types.ColumnTypeInt, formatText)
+	require.Error(t, err)
+	_, err = decodeParam([]byte("maybe"), types.ColumnTypeBool, formatText)
+	require.Error(t, err)
+
+	val, err := decodeParam(binary.BigEndian.AppendUint16(nil, 0xffff), types.ColumnTypeInt, formatBinary)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), val)
+	_, err = decodeParam([]byte{1, 2, 3}, types.ColumnTypeInt, formatBinary)
+	require.Error(t, err)
+	_, err = decodeParam([]byte{1}, decType, formatBinary)
+	require.Error(t, err)
+}
+
+func TestEncodeTextNestedValues(t *testing.T) {
+	columnTypes := []types.ColumnType{&types.ArrayType{ElemType: types.ColumnTypeInt},
+		&types.StructType{FieldNames: []string{"s", "m"}, FieldTypes: []types.ColumnType{types.ColumnTypeString,
+			&types.MapType{ValueType: types.ColumnTypeBool}}}}
+	builders := evbatch.CreateColBuilders(columnTypes)
+	builders[0].(*evbatch.NestedColBuilder).Append([]any{int64(1), nil, int64(3)})
+	builders[1].(*evbatch.NestedColBuilder).Append([]any{"foo", map[string]any{"a": true}})
+	batch := evbatch.NewBatchFromBuilders(evbatch.NewEventSchema([]string{"a", "s"}, columnTypes), builders...)
+
+	expected := []string{"[1,null,3]", `{"s":"foo","m":{"a":true}}`}
+	for colIndex, exp := range expected {
+		require.Equal(t, uint32(oidJSON), columnTypeToOID(columnTypes[colIndex]))
+		require.False(t, supportsBinaryFormat(columnTypes[colIndex]))
+		buff := encodeValue(nil, batch, colIndex, 0, formatText)
+		require.Equal(t, exp, string(buff[4:]))
+	}
+}
+
+func TestOIDMapping(t *testing.T) {
+	for _, columnType := range []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeFloat, types.ColumnTypeBool,
+		&types.DecimalType{Precision: 38, Scale: 6}, types.ColumnTypeString, types.ColumnTypeBytes,
+		types.ColumnTypeTimestamp} {
+		mapped, ok := oidToColumnType(columnTypeToOID(columnType))
+		require.True(t, ok)
+		require.Equal(t, columnType, mapped)
+	}
+	_, ok := oidToColumnType(oidUnknown)
+	require.False(t, ok)
+}
+
+// createTypesBatch creates a batch with columns of bool, decimal, bytes and timestamp, with a row of the values and a
+// row of nulls
+func createTypesBatch(t *testing.T, decType *types.DecimalType, b bool, dec types.Decimal, bytes []byte,
+	ts int64) *evbatch.Batch {
+	t.Helper()
+	columnTypes := []types.ColumnType{types.ColumnTypeBool, decType, types.ColumnTypeBytes, types.ColumnTypeTimestamp}
+	builders := evbatch.CreateColBuilders(columnTypes)
+	builders[0].(*evbatch.BoolColBuilder).Append(b)
+	builders[1].(*evbatch.DecimalColBuilder).Append(dec)
+	builders[2].(*evbatch.BytesColBuilder).Append(bytes)
+	builders[3].(*evbatch.TimestampColBuilder).Append(types.NewTimestamp(ts))
+	for _, builder := range builders {
+		builder.AppendNull()
+	}
+	schema := evbatch.NewEventSchema([]string{"b", "d", "by", "ts"}, columnTypes)
+	return evbatch.NewBatchFromBuilders(schema, builders...)
+}
@@ -54,6 +54,13 @@ func (m *levelManagerBatchHandler) HandleProcessBatch(_ Processor,
 	case levels.StoreLastFlushedVersionCommand:
 		lastFlushedVersion := int64(binary.LittleEndian.Uint64(bytes[1:]))
 		return true, nil, nil, levelManager.StoreLastFlushedVersion(lastFlushedVersion, reprocess, processBatch.ReplSeq)
+	case levels.RegisterVersionRetentionsCommand:
+		versionRetentions, _ := retention.DeserializePrefixRetentions(bytes, 1)
+		return true, nil, nil, levelManager.RegisterVersionRetentions(versionRetentions, reprocess, processBatch.ReplSeq)
+	case levels.StoreVersionTimeCommand:
+		version := int64(binary.LittleEndian.Uint64(bytes[1:]))
+		timeMs := int64(binary.LittleEndian.Uint64(bytes[9:]))
+		return true, nil, nil, levelManager.StoreVersionTime(version, timeMs, reprocess, processBatch.ReplSeq)
 	default:
 		panic("unknown command")
 	}
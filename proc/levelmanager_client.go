@@ -143,6 +143,32 @@ func (l *LevelManagerLocalClient) LoadLastFlushedVersion() (int64, error) {
 	return resp.LastFlushedVersion, nil
 }
 
+func (l *LevelManagerLocalClient) RegisterVersionRetentions(versionRetentions []retention.PrefixRetention) error {
+	bytes := levels.EncodeRegisterVersionRetentionsCommand(versionRetentions)
+	return ingestCommandBatchSync(bytes, l.processorManager, l.cfg.ProcessorCount)
+}
+
+func (l *LevelManagerLocalClient) StoreVersionTime(version int64, timeMs int64) error {
+	if l.processorManager == nil {
+		panic("processor manager not set")
+	}
+	bytes := levels.EncodeStoreVersionTimeCommand(version, timeMs)
+	return ingestCommandBatchSync(bytes, l.processorManager, l.cfg.ProcessorCount)
+}
+
+func (l *LevelManagerLocalClient) GetVersionForTime(timeMs int64) (int64, error) {
+	if l.processorManager == nil {
+		panic("processor manager not set")
+	}
+	req := &clustermsgs.LevelManagerGetVersionForTimeMessage{Time: timeMs}
+	r, err := l.sendLevelManagerRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	resp := r.(*clustermsgs.LevelManagerGetVersionForTimeResponse)
+	return resp.Version, nil
+}
+
 func (l *LevelManagerLocalClient) GetStats() (levels.Stats, error) {
 	if l.processorManager == nil {
 		panic("processor manager not set")
@@ -0,0 +1,329 @@
+// Package protoreg manages the protobuf descriptors used to decode and encode protobuf messages. Descriptors are
+// registered as FileDescriptorSets, as produced by `protoc --descriptor_set_out`, and are stored in the object store so
+// they are available on every node.
+package protoreg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/lock"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/objstore"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"sort"
+	"sync"
+	"time"
+
+	// The well known types are registered in protoregistry.GlobalFiles, so descriptor sets can import them without
+	// including them
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	descriptorManagerLock       = "protobuf-descriptor-lock"
+	descriptorObjectStorePrefix = "protobuf-descriptors"
+)
+
+var descriptorIndexKey = []byte(fmt.Sprintf("%s-index", descriptorObjectStorePrefix))
+
+// DescriptorManager manages the registered descriptor sets. Each set is stored in the object store under its name, and
+// the names of the registered sets are stored in an index object. Message types defined in any registered set can be
+// found by their fully qualified name. A set registered on another node is loaded the first time one of its messages
+// is looked up.
+type DescriptorManager struct {
+	lock           sync.RWMutex
+	objStoreClient objstore.Client
+	lockMgr        lock.Manager
+	sets           map[string]*protoregistry.Files
+	files          *protoregistry.Files
+}
+
+func NewDescriptorManager(objStoreClient objstore.Client, lockMgr lock.Manager) *DescriptorManager {
+	return &DescriptorManager{
+		objStoreClient: objStoreClient,
+		lockMgr:        lockMgr,
+		sets:           map[string]*protoregistry.Files{},
+		files:          &protoregistry.Files{},
+	}
+}
+
+func (d *DescriptorManager) RegisterDescriptors(name string, descriptorSetBytes []byte) error {
+	if name == "" {
+		return errors.NewTektiteError(errors.ProtobufError, "descriptor set name must be specified")
+	}
+	set, err := parseDescriptorSet(descriptorSetBytes)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.getClusterWideLock(); err != nil {
+		return err
+	}
+	defer d.releaseClusterWideLock()
+	names, err := d.loadIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := names[name]; ok {
+		return errors.NewTektiteErrorf(errors.ProtobufError, "descriptor set '%s' already registered", name)
+	}
+	if err := d.loadSets(names); err != nil {
+		return err
+	}
+	// Check the message types do not conflict with those of the registered sets
+	d.sets[name] = set
+	files, err := d.mergeSets()
+	if err != nil {
+		delete(d.sets, name)
+		return err
+	}
+	if err := d.objStoreClient.Put(createDescriptorKey(name), descriptorSetBytes); err != nil {
+		delete(d.sets, name)
+		return err
+	}
+	names[name] = struct{}{}
+	if err := d.storeIndex(names); err != nil {
+		delete(d.sets, name)
+		return err
+	}
+	d.files = files
+	return nil
+}
+
+func (d *DescriptorManager) UnregisterDescriptors(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.getClusterWideLock(); err != nil {
+		return err
+	}
+	defer d.releaseClusterWideLock()
+	names, err := d.loadIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := names[name]; !ok {
+		return errors.NewTektiteErrorf(errors.ProtobufError, "unknown descriptor set '%s'", name)
+	}
+	delete(names, name)
+	if err := d.storeIndex(names); err != nil {
+		return err
+	}
+	if err := d.objStoreClient.Delete(createDescriptorKey(name)); err != nil {
+		return err
+	}
+	delete(d.sets, name)
+	files, err := d.mergeSets()
+	if err != nil {
+		return err
+	}
+	d.files = files
+	return nil
+}
+
+// FindMessage returns the descriptor of the message type with the fully qualified name, e.g. 'mypackage.MyMessage'.
+func (d *DescriptorManager) FindMessage(fullName string) (protoreflect.MessageDescriptor, error) {
+	d.lock.RLock()
+	md, ok := findMessage(d.files, fullName)
+	d.lock.RUnlock()
+	if ok {
+		return md, nil
+	}
+	// The message may be in a set registered, or unregistered, on another node
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	names, err := d.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.loadSets(names); err != nil {
+		return nil, err
+	}
+	md, ok = findMessage(d.files, fullName)
+	if !ok {
+		return nil, errors.NewTektiteErrorf(errors.ProtobufError, "unknown protobuf message type '%s'", fullName)
+	}
+	return md, nil
+}
+
+func findMessage(files *protoregistry.Files, fullName string) (protoreflect.MessageDescriptor, bool) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return nil, false
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	return md, ok
+}
+
+// loadSets loads the sets in names that are not already loaded, and removes those that are not in names.
+func (d *DescriptorManager) loadSets(names map[string]struct{}) error {
+	changed := false
+	for name := range names {
+		if _, ok := d.sets[name]; ok {
+			continue
+		}
+		buff, err := d.objStoreClient.Get(createDescriptorKey(name))
+		if err != nil {
+			return err
+		}
+		if buff == nil {
+			return errors.NewTektiteErrorf(errors.ProtobufError, "descriptor set '%s' not found in object store", name)
+		}
+		set, err := parseDescriptorSet(buff)
+		if err != nil {
+			return err
+		}
+		d.sets[name] = set
+		changed = true
+	}
+	for name := range d.sets {
+		if _, ok := names[name]; !ok {
+			delete(d.sets, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	files, err := d.mergeSets()
+	if err != nil {
+		return err
+	}
+	d.files = files
+	return nil
+}
+
+// mergeSets creates a registry containing the files of all the loaded sets. Sets can contain the same file, e.g. one
+// they both import, in which case it is only registered once. Sets are merged in name order so conflicts are reported
+// consistently.
+func (d *DescriptorManager) mergeSets() (*protoregistry.Files, error) {
+	names := make([]string, 0, len(d.sets))
+	for name := range d.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	merged := &protoregistry.Files{}
+	for _, name := range names {
+		var err error
+		d.sets[name].RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			if _, e := merged.FindFileByPath(fd.Path()); e == nil {
+				return true
+			}
+			if e := merged.RegisterFile(fd); e != nil {
+				err = errors.NewTektiteErrorf(errors.ProtobufError, "descriptor set '%s' conflicts with registered descriptors: %v",
+					name, e)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+func parseDescriptorSet(buff []byte) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(buff, set); err != nil {
+		return nil, errors.NewTektiteErrorf(errors.ProtobufError, "invalid protobuf descriptor set: %v", err)
+	}
+	if len(set.File) == 0 {
+		return nil, errors.NewTektiteError(errors.ProtobufError, "invalid protobuf descriptor set: it contains no files")
+	}
+	addWellKnownImports(set)
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, errors.NewTektiteErrorf(errors.ProtobufError,
+			"invalid protobuf descriptor set (imports must be included with --include_imports): %v", err)
+	}
+	return files, nil
+}
+
+// addWellKnownImports adds the well known types imported by the files of the set that it does not include.
+func addWellKnownImports(set *descriptorpb.FileDescriptorSet) {
+	included := map[string]struct{}{}
+	for _, fdp := range set.File {
+		included[fdp.GetName()] = struct{}{}
+	}
+	for i := 0; i < len(set.File); i++ {
+		for _, dep := range set.File[i].Dependency {
+			if _, ok := included[dep]; ok {
+				continue
+			}
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(dep)
+			if err != nil {
+				// Not a well known type, protodesc.NewFiles will report it as missing
+				continue
+			}
+			set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+			included[dep] = struct{}{}
+		}
+	}
+}
+
+func (d *DescriptorManager) loadIndex() (map[string]struct{}, error) {
+	buff, err := d.objStoreClient.Get(descriptorIndexKey)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	if buff == nil {
+		return names, nil
+	}
+	var arr []string
+	if err := json.Unmarshal(buff, &arr); err != nil {
+		return nil, err
+	}
+	for _, name := range arr {
+		names[name] = struct{}{}
+	}
+	return names, nil
+}
+
+func (d *DescriptorManager) storeIndex(names map[string]struct{}) error {
+	arr := make([]string, 0, len(names))
+	for name := range names {
+		arr = append(arr, name)
+	}
+	sort.Strings(arr)
+	buff, err := json.Marshal(arr)
+	if err != nil {
+		return err
+	}
+	return d.objStoreClient.Put(descriptorIndexKey, buff)
+}
+
+func (d *DescriptorManager) getClusterWideLock() error {
+	for {
+		ok, err := d.lockMgr.GetLock(descriptorManagerLock)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		// Lock is already held - retry after delay
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func (d *DescriptorManager) releaseClusterWideLock() {
+	if _, err := d.lockMgr.ReleaseLock(descriptorManagerLock); err != nil {
+		log.Errorf("failed to release lock %v", err)
+	}
+}
+
+func createDescriptorKey(name string) []byte {
+	return []byte(fmt.Sprintf("%s.%s", descriptorObjectStorePrefix, name))
+}
@@ -0,0 +1,196 @@
+package protoreg
+
+import (
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/lock"
+	"github.com/spirit-labs/tektite/objstore/dev"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+)
+
+func createDescriptorSet(t *testing.T, fileName string, pkg string, messageName string, withTimestamp bool,
+	extraFiles ...*descriptorpb.FileDescriptorProto) []byte {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String("name"),
+		Number: proto.Int32(1),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:  proto.String(messageName),
+			Field: []*descriptorpb.FieldDescriptorProto{field},
+		}},
+	}
+	if withTimestamp {
+		file.Dependency = []string{"google/protobuf/timestamp.proto"}
+		file.MessageType[0].Field = append(file.MessageType[0].Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String("created"),
+			Number:   proto.Int32(2),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(".google.protobuf.Timestamp"),
+		})
+	}
+	set := &descriptorpb.FileDescriptorSet{File: append(extraFiles, file)}
+	buff, err := proto.Marshal(set)
+	require.NoError(t, err)
+	return buff
+}
+
+func createDescriptorManager() *DescriptorManager {
+	return NewDescriptorManager(dev.NewInMemStore(0), lock.NewInMemLockManager())
+}
+
+func TestRegisterAndFindMessage(t *testing.T) {
+	mgr := createDescriptorManager()
+	_, err := mgr.FindMessage("orders.Order")
+	require.Error(t, err)
+	require.Equal(t, "unknown protobuf message type 'orders.Order'", err.Error())
+
+	err = mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	md, err := mgr.FindMessage("orders.Order")
+	require.NoError(t, err)
+	require.Equal(t, "orders.Order", string(md.FullName()))
+	require.Equal(t, 1, md.Fields().Len())
+
+	// Not a message
+	_, err = mgr.FindMessage("orders.Order.name")
+	require.Error(t, err)
+	_, err = mgr.FindMessage("orders")
+	require.Error(t, err)
+}
+
+func TestRegisterWithWellKnownImport(t *testing.T) {
+	mgr := createDescriptorManager()
+	// The set does not include timestamp.proto, it is added
+	err := mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", true))
+	require.NoError(t, err)
+	md, err := mgr.FindMessage("orders.Order")
+	require.NoError(t, err)
+	require.Equal(t, "google.protobuf.Timestamp", string(md.Fields().ByName("created").Message().FullName()))
+
+	// Another set can include it
+	err = mgr.RegisterDescriptors("customers", createDescriptorSet(t, "customers.proto", "customers", "Customer", true,
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto)))
+	require.NoError(t, err)
+	_, err = mgr.FindMessage("customers.Customer")
+	require.NoError(t, err)
+}
+
+func TestRegisterAlreadyRegistered(t *testing.T) {
+	mgr := createDescriptorManager()
+	err := mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	err = mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders2.proto", "orders2", "Order", false))
+	require.Error(t, err)
+	var terr errors.TektiteError
+	require.True(t, errors.As(err, &terr))
+	require.Equal(t, errors.ErrorCode(errors.ProtobufError), terr.Code)
+	require.Equal(t, "descriptor set 'orders' already registered", err.Error())
+}
+
+func TestRegisterConflictingMessage(t *testing.T) {
+	mgr := createDescriptorManager()
+	err := mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	err = mgr.RegisterDescriptors("orders2", createDescriptorSet(t, "other.proto", "orders", "Order", false))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "descriptor set 'orders2' conflicts with registered descriptors")
+	// The set was not registered
+	err = mgr.UnregisterDescriptors("orders2")
+	require.Error(t, err)
+	require.Equal(t, "unknown descriptor set 'orders2'", err.Error())
+	_, err = mgr.FindMessage("orders.Order")
+	require.NoError(t, err)
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	mgr := createDescriptorManager()
+	err := mgr.RegisterDescriptors("orders", []byte("foo"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid protobuf descriptor set")
+
+	err = mgr.RegisterDescriptors("orders", nil)
+	require.Error(t, err)
+	require.Equal(t, "invalid protobuf descriptor set: it contains no files", err.Error())
+
+	err = mgr.RegisterDescriptors("", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.Error(t, err)
+	require.Equal(t, "descriptor set name must be specified", err.Error())
+
+	// Missing import
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:       proto.String("orders.proto"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"customers.proto"},
+	}}}
+	buff, err := proto.Marshal(set)
+	require.NoError(t, err)
+	err = mgr.RegisterDescriptors("orders", buff)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "imports must be included with --include_imports")
+}
+
+func TestUnregister(t *testing.T) {
+	mgr := createDescriptorManager()
+	err := mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	err = mgr.RegisterDescriptors("customers", createDescriptorSet(t, "customers.proto", "customers", "Customer", false))
+	require.NoError(t, err)
+
+	err = mgr.UnregisterDescriptors("orders")
+	require.NoError(t, err)
+	_, err = mgr.FindMessage("orders.Order")
+	require.Error(t, err)
+	_, err = mgr.FindMessage("customers.Customer")
+	require.NoError(t, err)
+
+	err = mgr.UnregisterDescriptors("orders")
+	require.Error(t, err)
+	require.Equal(t, "unknown descriptor set 'orders'", err.Error())
+
+	// Can be registered again
+	err = mgr.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	_, err = mgr.FindMessage("orders.Order")
+	require.NoError(t, err)
+}
+
+func TestDescriptorsSharedBetweenNodes(t *testing.T) {
+	objStore := dev.NewInMemStore(0)
+	lockMgr := lock.NewInMemLockManager()
+	mgr1 := NewDescriptorManager(objStore, lockMgr)
+	mgr2 := NewDescriptorManager(objStore, lockMgr)
+
+	// Registered on one node, found on the other
+	err := mgr1.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+	_, err = mgr2.FindMessage("orders.Order")
+	require.NoError(t, err)
+
+	err = mgr2.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.Error(t, err)
+	require.Equal(t, "descriptor set 'orders' already registered", err.Error())
+
+	err = mgr2.RegisterDescriptors("customers", createDescriptorSet(t, "customers.proto", "customers", "Customer", false))
+	require.NoError(t, err)
+	_, err = mgr1.FindMessage("customers.Customer")
+	require.NoError(t, err)
+
+	// Unregistered on one node, and the other node sees it is no longer registered
+	err = mgr2.UnregisterDescriptors("orders")
+	require.NoError(t, err)
+	err = mgr1.UnregisterDescriptors("orders")
+	require.Error(t, err)
+	err = mgr1.RegisterDescriptors("orders", createDescriptorSet(t, "orders.proto", "orders", "Order", false))
+	require.NoError(t, err)
+}
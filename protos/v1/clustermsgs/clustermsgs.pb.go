@@ -1200,6 +1200,202 @@ func (x *LevelManagerGetStatsResponse) GetPayload() []byte {
 	return nil
 }
 
+type LevelManagerRegisterVersionRetentionsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (x *LevelManagerRegisterVersionRetentionsRequest) Reset() {
+	*x = LevelManagerRegisterVersionRetentionsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LevelManagerRegisterVersionRetentionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LevelManagerRegisterVersionRetentionsRequest) ProtoMessage() {}
+
+func (x *LevelManagerRegisterVersionRetentionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LevelManagerRegisterVersionRetentionsRequest.ProtoReflect.Descriptor instead.
+func (*LevelManagerRegisterVersionRetentionsRequest) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *LevelManagerRegisterVersionRetentionsRequest) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+type LevelManagerStoreVersionTimeMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version int64 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Time    int64 `protobuf:"varint,2,opt,name=time,proto3" json:"time,omitempty"`
+}
+
+func (x *LevelManagerStoreVersionTimeMessage) Reset() {
+	*x = LevelManagerStoreVersionTimeMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LevelManagerStoreVersionTimeMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LevelManagerStoreVersionTimeMessage) ProtoMessage() {}
+
+func (x *LevelManagerStoreVersionTimeMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LevelManagerStoreVersionTimeMessage.ProtoReflect.Descriptor instead.
+func (*LevelManagerStoreVersionTimeMessage) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *LevelManagerStoreVersionTimeMessage) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *LevelManagerStoreVersionTimeMessage) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type LevelManagerGetVersionForTimeMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time int64 `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+}
+
+func (x *LevelManagerGetVersionForTimeMessage) Reset() {
+	*x = LevelManagerGetVersionForTimeMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[22]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LevelManagerGetVersionForTimeMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LevelManagerGetVersionForTimeMessage) ProtoMessage() {}
+
+func (x *LevelManagerGetVersionForTimeMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[22]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LevelManagerGetVersionForTimeMessage.ProtoReflect.Descriptor instead.
+func (*LevelManagerGetVersionForTimeMessage) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *LevelManagerGetVersionForTimeMessage) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type LevelManagerGetVersionForTimeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version int64 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (x *LevelManagerGetVersionForTimeResponse) Reset() {
+	*x = LevelManagerGetVersionForTimeResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[23]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LevelManagerGetVersionForTimeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LevelManagerGetVersionForTimeResponse) ProtoMessage() {}
+
+func (x *LevelManagerGetVersionForTimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[23]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LevelManagerGetVersionForTimeResponse.ProtoReflect.Descriptor instead.
+func (*LevelManagerGetVersionForTimeResponse) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *LevelManagerGetVersionForTimeResponse) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
 type CompactionPollMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -1209,7 +1405,7 @@ type CompactionPollMessage struct {
 func (x *CompactionPollMessage) Reset() {
 	*x = CompactionPollMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[20]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[24]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1222,7 +1418,7 @@ func (x *CompactionPollMessage) String() string {
 func (*CompactionPollMessage) ProtoMessage() {}
 
 func (x *CompactionPollMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[20]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[24]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1235,7 +1431,7 @@ func (x *CompactionPollMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CompactionPollMessage.ProtoReflect.Descriptor instead.
 func (*CompactionPollMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{20}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{24}
 }
 
 type CompactionPollResponse struct {
@@ -1249,7 +1445,7 @@ type CompactionPollResponse struct {
 func (x *CompactionPollResponse) Reset() {
 	*x = CompactionPollResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[21]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[25]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1262,7 +1458,7 @@ func (x *CompactionPollResponse) String() string {
 func (*CompactionPollResponse) ProtoMessage() {}
 
 func (x *CompactionPollResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[21]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[25]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1275,7 +1471,7 @@ func (x *CompactionPollResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CompactionPollResponse.ProtoReflect.Descriptor instead.
 func (*CompactionPollResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{21}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{25}
 }
 
 func (x *CompactionPollResponse) GetJob() []byte {
@@ -1296,7 +1492,7 @@ type LocalObjStoreGetRequest struct {
 func (x *LocalObjStoreGetRequest) Reset() {
 	*x = LocalObjStoreGetRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[22]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[26]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1309,7 +1505,7 @@ func (x *LocalObjStoreGetRequest) String() string {
 func (*LocalObjStoreGetRequest) ProtoMessage() {}
 
 func (x *LocalObjStoreGetRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[22]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[26]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1322,7 +1518,7 @@ func (x *LocalObjStoreGetRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LocalObjStoreGetRequest.ProtoReflect.Descriptor instead.
 func (*LocalObjStoreGetRequest) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{22}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{26}
 }
 
 func (x *LocalObjStoreGetRequest) GetKey() []byte {
@@ -1343,7 +1539,7 @@ type LocalObjStoreGetResponse struct {
 func (x *LocalObjStoreGetResponse) Reset() {
 	*x = LocalObjStoreGetResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[23]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[27]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1356,7 +1552,7 @@ func (x *LocalObjStoreGetResponse) String() string {
 func (*LocalObjStoreGetResponse) ProtoMessage() {}
 
 func (x *LocalObjStoreGetResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[23]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[27]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1369,7 +1565,7 @@ func (x *LocalObjStoreGetResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LocalObjStoreGetResponse.ProtoReflect.Descriptor instead.
 func (*LocalObjStoreGetResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{23}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{27}
 }
 
 func (x *LocalObjStoreGetResponse) GetValue() []byte {
@@ -1391,7 +1587,7 @@ type LocalObjStoreAddRequest struct {
 func (x *LocalObjStoreAddRequest) Reset() {
 	*x = LocalObjStoreAddRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[24]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[28]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1404,7 +1600,7 @@ func (x *LocalObjStoreAddRequest) String() string {
 func (*LocalObjStoreAddRequest) ProtoMessage() {}
 
 func (x *LocalObjStoreAddRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[24]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[28]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1417,7 +1613,7 @@ func (x *LocalObjStoreAddRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LocalObjStoreAddRequest.ProtoReflect.Descriptor instead.
 func (*LocalObjStoreAddRequest) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{24}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{28}
 }
 
 func (x *LocalObjStoreAddRequest) GetKey() []byte {
@@ -1445,7 +1641,7 @@ type LocalObjStoreDeleteRequest struct {
 func (x *LocalObjStoreDeleteRequest) Reset() {
 	*x = LocalObjStoreDeleteRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[25]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[29]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1458,7 +1654,7 @@ func (x *LocalObjStoreDeleteRequest) String() string {
 func (*LocalObjStoreDeleteRequest) ProtoMessage() {}
 
 func (x *LocalObjStoreDeleteRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[25]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[29]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1471,48 +1667,321 @@ func (x *LocalObjStoreDeleteRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LocalObjStoreDeleteRequest.ProtoReflect.Descriptor instead.
 func (*LocalObjStoreDeleteRequest) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{25}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *LocalObjStoreDeleteRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type QueryMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ExecId          []byte   `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
+	QueryName       string   `protobuf:"bytes,2,opt,name=query_name,json=queryName,proto3" json:"query_name,omitempty"`
+	Tsl             string   `protobuf:"bytes,3,opt,name=tsl,proto3" json:"tsl,omitempty"`
+	HighestVersion  uint64   `protobuf:"varint,4,opt,name=highest_version,json=highestVersion,proto3" json:"highest_version,omitempty"`
+	ClusterVersion  uint64   `protobuf:"varint,5,opt,name=cluster_version,json=clusterVersion,proto3" json:"cluster_version,omitempty"`
+	Args            []byte   `protobuf:"bytes,6,opt,name=args,proto3" json:"args,omitempty"`
+	Partitions      []byte   `protobuf:"bytes,7,opt,name=partitions,proto3" json:"partitions,omitempty"`
+	SenderAddress   string   `protobuf:"bytes,8,opt,name=sender_address,json=senderAddress,proto3" json:"sender_address,omitempty"`
+	PageOffset      uint64   `protobuf:"varint,9,opt,name=page_offset,json=pageOffset,proto3" json:"page_offset,omitempty"`
+	JoinTables      [][]byte `protobuf:"bytes,10,rep,name=join_tables,json=joinTables,proto3" json:"join_tables,omitempty"`
+	MaxRowsScanned  int64    `protobuf:"varint,11,opt,name=max_rows_scanned,json=maxRowsScanned,proto3" json:"max_rows_scanned,omitempty"`
+	PageAfterKey    []byte   `protobuf:"bytes,12,opt,name=page_after_key,json=pageAfterKey,proto3" json:"page_after_key,omitempty"`
+	PageScanFromKey []byte   `protobuf:"bytes,13,opt,name=page_scan_from_key,json=pageScanFromKey,proto3" json:"page_scan_from_key,omitempty"`
+}
+
+func (x *QueryMessage) Reset() {
+	*x = QueryMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[30]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QueryMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryMessage) ProtoMessage() {}
+
+func (x *QueryMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[30]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryMessage.ProtoReflect.Descriptor instead.
+func (*QueryMessage) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *QueryMessage) GetExecId() []byte {
+	if x != nil {
+		return x.ExecId
+	}
+	return nil
+}
+
+func (x *QueryMessage) GetQueryName() string {
+	if x != nil {
+		return x.QueryName
+	}
+	return ""
+}
+
+func (x *QueryMessage) GetTsl() string {
+	if x != nil {
+		return x.Tsl
+	}
+	return ""
+}
+
+func (x *QueryMessage) GetHighestVersion() uint64 {
+	if x != nil {
+		return x.HighestVersion
+	}
+	return 0
+}
+
+func (x *QueryMessage) GetClusterVersion() uint64 {
+	if x != nil {
+		return x.ClusterVersion
+	}
+	return 0
+}
+
+func (x *QueryMessage) GetArgs() []byte {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *QueryMessage) GetPartitions() []byte {
+	if x != nil {
+		return x.Partitions
+	}
+	return nil
+}
+
+func (x *QueryMessage) GetSenderAddress() string {
+	if x != nil {
+		return x.SenderAddress
+	}
+	return ""
+}
+
+func (x *QueryMessage) GetPageOffset() uint64 {
+	if x != nil {
+		return x.PageOffset
+	}
+	return 0
+}
+
+func (x *QueryMessage) GetJoinTables() [][]byte {
+	if x != nil {
+		return x.JoinTables
+	}
+	return nil
+}
+
+func (x *QueryMessage) GetMaxRowsScanned() int64 {
+	if x != nil {
+		return x.MaxRowsScanned
+	}
+	return 0
+}
+
+func (x *QueryMessage) GetPageAfterKey() []byte {
+	if x != nil {
+		return x.PageAfterKey
+	}
+	return nil
+}
+
+func (x *QueryMessage) GetPageScanFromKey() []byte {
+	if x != nil {
+		return x.PageScanFromKey
+	}
+	return nil
+}
+
+type QueryResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ExecId      []byte `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
+	Value       []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Last        bool   `protobuf:"varint,3,opt,name=last,proto3" json:"last,omitempty"`
+	ErrorCode   int32  `protobuf:"varint,4,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	Error       string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	RowsScanned int64  `protobuf:"varint,6,opt,name=rows_scanned,json=rowsScanned,proto3" json:"rows_scanned,omitempty"`
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[31]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[31]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *QueryResponse) GetExecId() []byte {
+	if x != nil {
+		return x.ExecId
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+func (x *QueryResponse) GetErrorCode() int32 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *QueryResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *QueryResponse) GetRowsScanned() int64 {
+	if x != nil {
+		return x.RowsScanned
+	}
+	return 0
+}
+
+type QueryCancelMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ExecId []byte `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
+}
+
+func (x *QueryCancelMessage) Reset() {
+	*x = QueryCancelMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[32]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QueryCancelMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryCancelMessage) ProtoMessage() {}
+
+func (x *QueryCancelMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[32]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryCancelMessage.ProtoReflect.Descriptor instead.
+func (*QueryCancelMessage) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{32}
 }
 
-func (x *LocalObjStoreDeleteRequest) GetKey() []byte {
+func (x *QueryCancelMessage) GetExecId() []byte {
 	if x != nil {
-		return x.Key
+		return x.ExecId
 	}
 	return nil
 }
 
-type QueryMessage struct {
+type QueryChangedMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ExecId         []byte `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
-	QueryName      string `protobuf:"bytes,2,opt,name=query_name,json=queryName,proto3" json:"query_name,omitempty"`
-	Tsl            string `protobuf:"bytes,3,opt,name=tsl,proto3" json:"tsl,omitempty"`
-	HighestVersion uint64 `protobuf:"varint,4,opt,name=highest_version,json=highestVersion,proto3" json:"highest_version,omitempty"`
-	ClusterVersion uint64 `protobuf:"varint,5,opt,name=cluster_version,json=clusterVersion,proto3" json:"cluster_version,omitempty"`
-	Args           []byte `protobuf:"bytes,6,opt,name=args,proto3" json:"args,omitempty"`
-	Partitions     []byte `protobuf:"bytes,7,opt,name=partitions,proto3" json:"partitions,omitempty"`
-	SenderAddress  string `protobuf:"bytes,8,opt,name=sender_address,json=senderAddress,proto3" json:"sender_address,omitempty"`
+	TableNames          []string `protobuf:"bytes,1,rep,name=table_names,json=tableNames,proto3" json:"table_names,omitempty"`
+	ChangedAfterVersion int64    `protobuf:"varint,2,opt,name=changed_after_version,json=changedAfterVersion,proto3" json:"changed_after_version,omitempty"`
+	ClusterVersion      uint64   `protobuf:"varint,3,opt,name=cluster_version,json=clusterVersion,proto3" json:"cluster_version,omitempty"`
+	WithKeys            bool     `protobuf:"varint,4,opt,name=with_keys,json=withKeys,proto3" json:"with_keys,omitempty"`
 }
 
-func (x *QueryMessage) Reset() {
-	*x = QueryMessage{}
+func (x *QueryChangedMessage) Reset() {
+	*x = QueryChangedMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[26]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[33]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *QueryMessage) String() string {
+func (x *QueryChangedMessage) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*QueryMessage) ProtoMessage() {}
+func (*QueryChangedMessage) ProtoMessage() {}
 
-func (x *QueryMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[26]
+func (x *QueryChangedMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[33]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1523,94 +1992,66 @@ func (x *QueryMessage) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use QueryMessage.ProtoReflect.Descriptor instead.
-func (*QueryMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{26}
+// Deprecated: Use QueryChangedMessage.ProtoReflect.Descriptor instead.
+func (*QueryChangedMessage) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{33}
 }
 
-func (x *QueryMessage) GetExecId() []byte {
+func (x *QueryChangedMessage) GetTableNames() []string {
 	if x != nil {
-		return x.ExecId
+		return x.TableNames
 	}
 	return nil
 }
 
-func (x *QueryMessage) GetQueryName() string {
-	if x != nil {
-		return x.QueryName
-	}
-	return ""
-}
-
-func (x *QueryMessage) GetTsl() string {
-	if x != nil {
-		return x.Tsl
-	}
-	return ""
-}
-
-func (x *QueryMessage) GetHighestVersion() uint64 {
+func (x *QueryChangedMessage) GetChangedAfterVersion() int64 {
 	if x != nil {
-		return x.HighestVersion
+		return x.ChangedAfterVersion
 	}
 	return 0
 }
 
-func (x *QueryMessage) GetClusterVersion() uint64 {
+func (x *QueryChangedMessage) GetClusterVersion() uint64 {
 	if x != nil {
 		return x.ClusterVersion
 	}
 	return 0
 }
 
-func (x *QueryMessage) GetArgs() []byte {
-	if x != nil {
-		return x.Args
-	}
-	return nil
-}
-
-func (x *QueryMessage) GetPartitions() []byte {
-	if x != nil {
-		return x.Partitions
-	}
-	return nil
-}
-
-func (x *QueryMessage) GetSenderAddress() string {
+func (x *QueryChangedMessage) GetWithKeys() bool {
 	if x != nil {
-		return x.SenderAddress
+		return x.WithKeys
 	}
-	return ""
+	return false
 }
 
-type QueryResponse struct {
+type QueryChangedResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	ExecId []byte `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
-	Value  []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
-	Last   bool   `protobuf:"varint,3,opt,name=last,proto3" json:"last,omitempty"`
+	Changed     bool     `protobuf:"varint,1,opt,name=changed,proto3" json:"changed,omitempty"`
+	Keys        [][]byte `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	KeysUnknown bool     `protobuf:"varint,3,opt,name=keys_unknown,json=keysUnknown,proto3" json:"keys_unknown,omitempty"`
 }
 
-func (x *QueryResponse) Reset() {
-	*x = QueryResponse{}
+func (x *QueryChangedResponse) Reset() {
+	*x = QueryChangedResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[27]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[34]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *QueryResponse) String() string {
+func (x *QueryChangedResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*QueryResponse) ProtoMessage() {}
+func (*QueryChangedResponse) ProtoMessage() {}
 
-func (x *QueryResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[27]
+func (x *QueryChangedResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[34]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1621,28 +2062,28 @@ func (x *QueryResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
-func (*QueryResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{27}
+// Deprecated: Use QueryChangedResponse.ProtoReflect.Descriptor instead.
+func (*QueryChangedResponse) Descriptor() ([]byte, []int) {
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{34}
 }
 
-func (x *QueryResponse) GetExecId() []byte {
+func (x *QueryChangedResponse) GetChanged() bool {
 	if x != nil {
-		return x.ExecId
+		return x.Changed
 	}
-	return nil
+	return false
 }
 
-func (x *QueryResponse) GetValue() []byte {
+func (x *QueryChangedResponse) GetKeys() [][]byte {
 	if x != nil {
-		return x.Value
+		return x.Keys
 	}
 	return nil
 }
 
-func (x *QueryResponse) GetLast() bool {
+func (x *QueryChangedResponse) GetKeysUnknown() bool {
 	if x != nil {
-		return x.Last
+		return x.KeysUnknown
 	}
 	return false
 }
@@ -1660,7 +2101,7 @@ type VersionsMessage struct {
 func (x *VersionsMessage) Reset() {
 	*x = VersionsMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[28]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[35]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1673,7 +2114,7 @@ func (x *VersionsMessage) String() string {
 func (*VersionsMessage) ProtoMessage() {}
 
 func (x *VersionsMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[28]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[35]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1686,7 +2127,7 @@ func (x *VersionsMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use VersionsMessage.ProtoReflect.Descriptor instead.
 func (*VersionsMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{28}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{35}
 }
 
 func (x *VersionsMessage) GetCurrentVersion() int64 {
@@ -1719,7 +2160,7 @@ type GetCurrentVersionMessage struct {
 func (x *GetCurrentVersionMessage) Reset() {
 	*x = GetCurrentVersionMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[29]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[36]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1732,7 +2173,7 @@ func (x *GetCurrentVersionMessage) String() string {
 func (*GetCurrentVersionMessage) ProtoMessage() {}
 
 func (x *GetCurrentVersionMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[29]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[36]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1745,7 +2186,7 @@ func (x *GetCurrentVersionMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetCurrentVersionMessage.ProtoReflect.Descriptor instead.
 func (*GetCurrentVersionMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{29}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{36}
 }
 
 type VersionCompleteMessage struct {
@@ -1762,7 +2203,7 @@ type VersionCompleteMessage struct {
 func (x *VersionCompleteMessage) Reset() {
 	*x = VersionCompleteMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[30]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[37]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1775,7 +2216,7 @@ func (x *VersionCompleteMessage) String() string {
 func (*VersionCompleteMessage) ProtoMessage() {}
 
 func (x *VersionCompleteMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[30]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[37]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1788,7 +2229,7 @@ func (x *VersionCompleteMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use VersionCompleteMessage.ProtoReflect.Descriptor instead.
 func (*VersionCompleteMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{30}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{37}
 }
 
 func (x *VersionCompleteMessage) GetVersion() uint64 {
@@ -1831,7 +2272,7 @@ type FailureDetectedMessage struct {
 func (x *FailureDetectedMessage) Reset() {
 	*x = FailureDetectedMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[31]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[38]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1844,7 +2285,7 @@ func (x *FailureDetectedMessage) String() string {
 func (*FailureDetectedMessage) ProtoMessage() {}
 
 func (x *FailureDetectedMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[31]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[38]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1857,7 +2298,7 @@ func (x *FailureDetectedMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FailureDetectedMessage.ProtoReflect.Descriptor instead.
 func (*FailureDetectedMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{31}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{38}
 }
 
 func (x *FailureDetectedMessage) GetProcessorCount() uint64 {
@@ -1885,7 +2326,7 @@ type GetLastFailureFlushedVersionMessage struct {
 func (x *GetLastFailureFlushedVersionMessage) Reset() {
 	*x = GetLastFailureFlushedVersionMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[32]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[39]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1898,7 +2339,7 @@ func (x *GetLastFailureFlushedVersionMessage) String() string {
 func (*GetLastFailureFlushedVersionMessage) ProtoMessage() {}
 
 func (x *GetLastFailureFlushedVersionMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[32]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[39]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1911,7 +2352,7 @@ func (x *GetLastFailureFlushedVersionMessage) ProtoReflect() protoreflect.Messag
 
 // Deprecated: Use GetLastFailureFlushedVersionMessage.ProtoReflect.Descriptor instead.
 func (*GetLastFailureFlushedVersionMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{32}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{39}
 }
 
 func (x *GetLastFailureFlushedVersionMessage) GetClusterVersion() uint64 {
@@ -1932,7 +2373,7 @@ type GetLastFailureFlushedVersionResponse struct {
 func (x *GetLastFailureFlushedVersionResponse) Reset() {
 	*x = GetLastFailureFlushedVersionResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[33]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[40]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1945,7 +2386,7 @@ func (x *GetLastFailureFlushedVersionResponse) String() string {
 func (*GetLastFailureFlushedVersionResponse) ProtoMessage() {}
 
 func (x *GetLastFailureFlushedVersionResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[33]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[40]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1958,7 +2399,7 @@ func (x *GetLastFailureFlushedVersionResponse) ProtoReflect() protoreflect.Messa
 
 // Deprecated: Use GetLastFailureFlushedVersionResponse.ProtoReflect.Descriptor instead.
 func (*GetLastFailureFlushedVersionResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{33}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{40}
 }
 
 func (x *GetLastFailureFlushedVersionResponse) GetFlushedVersion() int64 {
@@ -1980,7 +2421,7 @@ type FailureCompleteMessage struct {
 func (x *FailureCompleteMessage) Reset() {
 	*x = FailureCompleteMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[34]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[41]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1993,7 +2434,7 @@ func (x *FailureCompleteMessage) String() string {
 func (*FailureCompleteMessage) ProtoMessage() {}
 
 func (x *FailureCompleteMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[34]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[41]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2006,7 +2447,7 @@ func (x *FailureCompleteMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FailureCompleteMessage.ProtoReflect.Descriptor instead.
 func (*FailureCompleteMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{34}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{41}
 }
 
 func (x *FailureCompleteMessage) GetProcessorCount() uint64 {
@@ -2034,7 +2475,7 @@ type IsFailureCompleteMessage struct {
 func (x *IsFailureCompleteMessage) Reset() {
 	*x = IsFailureCompleteMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[35]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[42]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2047,7 +2488,7 @@ func (x *IsFailureCompleteMessage) String() string {
 func (*IsFailureCompleteMessage) ProtoMessage() {}
 
 func (x *IsFailureCompleteMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[35]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[42]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2060,7 +2501,7 @@ func (x *IsFailureCompleteMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IsFailureCompleteMessage.ProtoReflect.Descriptor instead.
 func (*IsFailureCompleteMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{35}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{42}
 }
 
 func (x *IsFailureCompleteMessage) GetClusterVersion() uint64 {
@@ -2081,7 +2522,7 @@ type IsFailureCompleteResponse struct {
 func (x *IsFailureCompleteResponse) Reset() {
 	*x = IsFailureCompleteResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[36]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[43]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2094,7 +2535,7 @@ func (x *IsFailureCompleteResponse) String() string {
 func (*IsFailureCompleteResponse) ProtoMessage() {}
 
 func (x *IsFailureCompleteResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[36]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[43]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2107,7 +2548,7 @@ func (x *IsFailureCompleteResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IsFailureCompleteResponse.ProtoReflect.Descriptor instead.
 func (*IsFailureCompleteResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{36}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{43}
 }
 
 func (x *IsFailureCompleteResponse) GetComplete() bool {
@@ -2131,7 +2572,7 @@ type VersionFlushedMessage struct {
 func (x *VersionFlushedMessage) Reset() {
 	*x = VersionFlushedMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[37]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[44]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2144,7 +2585,7 @@ func (x *VersionFlushedMessage) String() string {
 func (*VersionFlushedMessage) ProtoMessage() {}
 
 func (x *VersionFlushedMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[37]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[44]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2157,7 +2598,7 @@ func (x *VersionFlushedMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use VersionFlushedMessage.ProtoReflect.Descriptor instead.
 func (*VersionFlushedMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{37}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{44}
 }
 
 func (x *VersionFlushedMessage) GetNodeId() uint32 {
@@ -2197,7 +2638,7 @@ type CommandAvailableMessage struct {
 func (x *CommandAvailableMessage) Reset() {
 	*x = CommandAvailableMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[38]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[45]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2210,7 +2651,7 @@ func (x *CommandAvailableMessage) String() string {
 func (*CommandAvailableMessage) ProtoMessage() {}
 
 func (x *CommandAvailableMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[38]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[45]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2223,7 +2664,7 @@ func (x *CommandAvailableMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CommandAvailableMessage.ProtoReflect.Descriptor instead.
 func (*CommandAvailableMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{38}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{45}
 }
 
 type ShutdownMessage struct {
@@ -2237,7 +2678,7 @@ type ShutdownMessage struct {
 func (x *ShutdownMessage) Reset() {
 	*x = ShutdownMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[39]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[46]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2250,7 +2691,7 @@ func (x *ShutdownMessage) String() string {
 func (*ShutdownMessage) ProtoMessage() {}
 
 func (x *ShutdownMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[39]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[46]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2263,7 +2704,7 @@ func (x *ShutdownMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ShutdownMessage.ProtoReflect.Descriptor instead.
 func (*ShutdownMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{39}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{46}
 }
 
 func (x *ShutdownMessage) GetPhase() uint32 {
@@ -2284,7 +2725,7 @@ type ShutdownResponse struct {
 func (x *ShutdownResponse) Reset() {
 	*x = ShutdownResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[40]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[47]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2297,7 +2738,7 @@ func (x *ShutdownResponse) String() string {
 func (*ShutdownResponse) ProtoMessage() {}
 
 func (x *ShutdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[40]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[47]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2310,7 +2751,7 @@ func (x *ShutdownResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ShutdownResponse.ProtoReflect.Descriptor instead.
 func (*ShutdownResponse) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{40}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{47}
 }
 
 func (x *ShutdownResponse) GetFlushed() bool {
@@ -2331,7 +2772,7 @@ type RemotingTestMessage struct {
 func (x *RemotingTestMessage) Reset() {
 	*x = RemotingTestMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[41]
+		mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[48]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -2344,7 +2785,7 @@ func (x *RemotingTestMessage) String() string {
 func (*RemotingTestMessage) ProtoMessage() {}
 
 func (x *RemotingTestMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[41]
+	mi := &file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[48]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2357,7 +2798,7 @@ func (x *RemotingTestMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RemotingTestMessage.ProtoReflect.Descriptor instead.
 func (*RemotingTestMessage) Descriptor() ([]byte, []int) {
-	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{41}
+	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP(), []int{48}
 }
 
 func (x *RemotingTestMessage) GetSomeField() string {
@@ -2530,122 +2971,178 @@ var file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDesc = []byte{
 	0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65,
 	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61,
 	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64,
-	0x22, 0x17, 0x0a, 0x15, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x6f,
-	0x6c, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x2a, 0x0a, 0x16, 0x43, 0x6f, 0x6d,
-	0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x6f, 0x6c, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f,
-	0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6a, 0x6f, 0x62, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x03, 0x6a, 0x6f, 0x62, 0x22, 0x2b, 0x0a, 0x17, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62,
-	0x6a, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b,
-	0x65, 0x79, 0x22, 0x30, 0x0a, 0x18, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62, 0x6a, 0x53, 0x74,
-	0x6f, 0x72, 0x65, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14,
-	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76,
-	0x61, 0x6c, 0x75, 0x65, 0x22, 0x41, 0x0a, 0x17, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62, 0x6a,
-	0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
-	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65,
-	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x2e, 0x0a, 0x1a, 0x4c, 0x6f, 0x63, 0x61, 0x6c,
-	0x4f, 0x62, 0x6a, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x85, 0x02, 0x0a, 0x0c, 0x51, 0x75, 0x65, 0x72,
-	0x79, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x65, 0x78, 0x65, 0x63,
-	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x65, 0x78, 0x65, 0x63, 0x49,
-	0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x71, 0x75, 0x65, 0x72, 0x79, 0x4e, 0x61, 0x6d, 0x65,
-	0x12, 0x10, 0x0a, 0x03, 0x74, 0x73, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x74,
-	0x73, 0x6c, 0x12, 0x27, 0x0a, 0x0f, 0x68, 0x69, 0x67, 0x68, 0x65, 0x73, 0x74, 0x5f, 0x76, 0x65,
-	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x68, 0x69, 0x67,
-	0x68, 0x65, 0x73, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x63,
-	0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05,
+	0x22, 0x48, 0x0a, 0x2c, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72,
+	0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x52,
+	0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0x53, 0x0a, 0x23, 0x4c, 0x65,
+	0x76, 0x65, 0x6c, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x22,
+	0x3a, 0x0a, 0x24, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x47,
+	0x65, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x46, 0x6f, 0x72, 0x54, 0x69, 0x6d, 0x65,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x41, 0x0a, 0x25, 0x4c,
+	0x65, 0x76, 0x65, 0x6c, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x47, 0x65, 0x74, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x46, 0x6f, 0x72, 0x54, 0x69, 0x6d, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x17,
+	0x0a, 0x15, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x6f, 0x6c, 0x6c,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x2a, 0x0a, 0x16, 0x43, 0x6f, 0x6d, 0x70, 0x61,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x6f, 0x6c, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x10, 0x0a, 0x03, 0x6a, 0x6f, 0x62, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03,
+	0x6a, 0x6f, 0x62, 0x22, 0x2b, 0x0a, 0x17, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62, 0x6a, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x22, 0x30, 0x0a, 0x18, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62, 0x6a, 0x53, 0x74, 0x6f, 0x72,
+	0x65, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x22, 0x41, 0x0a, 0x17, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62, 0x6a, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x2e, 0x0a, 0x1a, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x4f, 0x62,
+	0x6a, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0xc4, 0x03, 0x0a, 0x0c, 0x51, 0x75, 0x65, 0x72, 0x79, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x65, 0x78, 0x65, 0x63, 0x49, 0x64, 0x12,
+	0x1d, 0x0a, 0x0a, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x71, 0x75, 0x65, 0x72, 0x79, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x74, 0x73, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x74, 0x73, 0x6c,
+	0x12, 0x27, 0x0a, 0x0f, 0x68, 0x69, 0x67, 0x68, 0x65, 0x73, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x68, 0x69, 0x67, 0x68, 0x65,
+	0x73, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75,
+	0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x72, 0x67, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x04, 0x61, 0x72, 0x67, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x70, 0x61, 0x72, 0x74, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x70, 0x61, 0x72, 0x74,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x65, 0x6e, 0x64, 0x65, 0x72,
+	0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d,
+	0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1f, 0x0a,
+	0x0b, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x09, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x0a, 0x70, 0x61, 0x67, 0x65, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x12, 0x1f,
+	0x0a, 0x0b, 0x6a, 0x6f, 0x69, 0x6e, 0x5f, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x0a, 0x20,
+	0x03, 0x28, 0x0c, 0x52, 0x0a, 0x6a, 0x6f, 0x69, 0x6e, 0x54, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x12,
+	0x28, 0x0a, 0x10, 0x6d, 0x61, 0x78, 0x5f, 0x72, 0x6f, 0x77, 0x73, 0x5f, 0x73, 0x63, 0x61, 0x6e,
+	0x6e, 0x65, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x6d, 0x61, 0x78, 0x52, 0x6f,
+	0x77, 0x73, 0x53, 0x63, 0x61, 0x6e, 0x6e, 0x65, 0x64, 0x12, 0x24, 0x0a, 0x0e, 0x70, 0x61, 0x67,
+	0x65, 0x5f, 0x61, 0x66, 0x74, 0x65, 0x72, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x0c, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x0c, 0x70, 0x61, 0x67, 0x65, 0x41, 0x66, 0x74, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x12,
+	0x2b, 0x0a, 0x12, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x73, 0x63, 0x61, 0x6e, 0x5f, 0x66, 0x72, 0x6f,
+	0x6d, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0f, 0x70, 0x61, 0x67,
+	0x65, 0x53, 0x63, 0x61, 0x6e, 0x46, 0x72, 0x6f, 0x6d, 0x4b, 0x65, 0x79, 0x22, 0xaa, 0x01, 0x0a,
+	0x0d, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x17,
+	0x0a, 0x07, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x06, 0x65, 0x78, 0x65, 0x63, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x6c, 0x61, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x6c, 0x61, 0x73,
+	0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65,
+	0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x21, 0x0a, 0x0c, 0x72, 0x6f, 0x77, 0x73, 0x5f, 0x73,
+	0x63, 0x61, 0x6e, 0x6e, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x72, 0x6f,
+	0x77, 0x73, 0x53, 0x63, 0x61, 0x6e, 0x6e, 0x65, 0x64, 0x22, 0x2d, 0x0a, 0x12, 0x51, 0x75, 0x65,
+	0x72, 0x79, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12,
+	0x17, 0x0a, 0x07, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x06, 0x65, 0x78, 0x65, 0x63, 0x49, 0x64, 0x22, 0xb0, 0x01, 0x0a, 0x13, 0x51, 0x75, 0x65,
+	0x72, 0x79, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x4e, 0x61, 0x6d, 0x65,
+	0x73, 0x12, 0x32, 0x0a, 0x15, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x5f, 0x61, 0x66, 0x74,
+	0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x13, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x41, 0x66, 0x74, 0x65, 0x72, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e,
+	0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1b,
+	0x0a, 0x09, 0x77, 0x69, 0x74, 0x68, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x08, 0x77, 0x69, 0x74, 0x68, 0x4b, 0x65, 0x79, 0x73, 0x22, 0x67, 0x0a, 0x14, 0x51,
+	0x75, 0x65, 0x72, 0x79, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x12, 0x12, 0x0a,
+	0x04, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x6b, 0x65, 0x79,
+	0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x75, 0x6e, 0x6b, 0x6e, 0x6f, 0x77,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x6b, 0x65, 0x79, 0x73, 0x55, 0x6e, 0x6b,
+	0x6e, 0x6f, 0x77, 0x6e, 0x22, 0x90, 0x01, 0x0a, 0x0f, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x73, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x75, 0x72, 0x72,
+	0x65, 0x6e, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x12, 0x2b, 0x0a, 0x11, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x5f, 0x76,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x63, 0x6f,
+	0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x27,
+	0x0a, 0x0f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x1a, 0x0a, 0x18, 0x47, 0x65, 0x74, 0x43, 0x75,
+	0x72, 0x72, 0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x22, 0x98, 0x01, 0x0a, 0x16, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x43,
+	0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x31, 0x0a, 0x14, 0x72, 0x65, 0x71, 0x75,
+	0x69, 0x72, 0x65, 0x64, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x13, 0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64,
+	0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x63,
+	0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x6f,
+	0x6f, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x64, 0x6f, 0x6f, 0x6d, 0x22, 0x6a,
+	0x0a, 0x16, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x44, 0x65, 0x74, 0x65, 0x63, 0x74, 0x65,
+	0x64, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63,
+	0x65, 0x73, 0x73, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0e, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e,
+	0x74, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73,
+	0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x4e, 0x0a, 0x23, 0x47, 0x65,
+	0x74, 0x4c, 0x61, 0x73, 0x74, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x46, 0x6c, 0x75, 0x73,
+	0x68, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73,
+	0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x4f, 0x0a, 0x24, 0x47, 0x65,
+	0x74, 0x4c, 0x61, 0x73, 0x74, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x46, 0x6c, 0x75, 0x73,
+	0x68, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x5f, 0x76, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x66, 0x6c, 0x75,
+	0x73, 0x68, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x6a, 0x0a, 0x16, 0x46,
+	0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73,
+	0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e,
+	0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x27,
+	0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x43, 0x0a, 0x18, 0x49, 0x73, 0x46, 0x61, 0x69,
+	0x6c, 0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c,
+	0x75, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x37, 0x0a, 0x19,
+	0x49, 0x73, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74,
+	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x6f, 0x6d,
+	0x70, 0x6c, 0x65, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x63, 0x6f, 0x6d,
+	0x70, 0x6c, 0x65, 0x74, 0x65, 0x22, 0x9c, 0x01, 0x0a, 0x15, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x46, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12,
+	0x17, 0x0a, 0x07, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x06, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x5f,
+	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x70, 0x72, 0x6f,
+	0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x27, 0x0a, 0x0f, 0x63,
+	0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04,
 	0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72,
-	0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x72, 0x67, 0x73, 0x18, 0x06, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x04, 0x61, 0x72, 0x67, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x70, 0x61, 0x72, 0x74,
-	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x70, 0x61,
-	0x72, 0x74, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x65, 0x6e, 0x64,
-	0x65, 0x72, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x0d, 0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22,
-	0x52, 0x0a, 0x0d, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
-	0x12, 0x17, 0x0a, 0x07, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x0c, 0x52, 0x06, 0x65, 0x78, 0x65, 0x63, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
-	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12,
-	0x12, 0x0a, 0x04, 0x6c, 0x61, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x6c,
-	0x61, 0x73, 0x74, 0x22, 0x90, 0x01, 0x0a, 0x0f, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x73,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x75, 0x72, 0x72, 0x65,
-	0x6e, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
-	0x52, 0x0e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x12, 0x2b, 0x0a, 0x11, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x5f, 0x76, 0x65,
-	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x63, 0x6f, 0x6d,
-	0x70, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x27, 0x0a,
-	0x0f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x56,
-	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x1a, 0x0a, 0x18, 0x47, 0x65, 0x74, 0x43, 0x75, 0x72,
-	0x72, 0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x22, 0x98, 0x01, 0x0a, 0x16, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6f,
-	0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x18, 0x0a,
-	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07,
-	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x31, 0x0a, 0x14, 0x72, 0x65, 0x71, 0x75, 0x69,
-	0x72, 0x65, 0x64, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x13, 0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x43,
-	0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x6f,
-	0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
-	0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x6f, 0x6f,
-	0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x64, 0x6f, 0x6f, 0x6d, 0x22, 0x6a, 0x0a,
-	0x16, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x44, 0x65, 0x74, 0x65, 0x63, 0x74, 0x65, 0x64,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63, 0x65,
-	0x73, 0x73, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
-	0x52, 0x0e, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e, 0x74,
-	0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74,
-	0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x4e, 0x0a, 0x23, 0x47, 0x65, 0x74,
-	0x4c, 0x61, 0x73, 0x74, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x46, 0x6c, 0x75, 0x73, 0x68,
-	0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
-	0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74,
-	0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x4f, 0x0a, 0x24, 0x47, 0x65, 0x74,
-	0x4c, 0x61, 0x73, 0x74, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x46, 0x6c, 0x75, 0x73, 0x68,
-	0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
-	0x65, 0x12, 0x27, 0x0a, 0x0f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x5f, 0x76, 0x65, 0x72,
-	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x66, 0x6c, 0x75, 0x73,
-	0x68, 0x65, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x6a, 0x0a, 0x16, 0x46, 0x61,
-	0x69, 0x6c, 0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65, 0x73,
-	0x73, 0x61, 0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f,
-	0x72, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x70,
-	0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x27, 0x0a,
-	0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x56,
-	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x43, 0x0a, 0x18, 0x49, 0x73, 0x46, 0x61, 0x69, 0x6c,
-	0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65,
-	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75,
-	0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x37, 0x0a, 0x19, 0x49,
-	0x73, 0x46, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x65,
-	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x6f, 0x6d, 0x70,
-	0x6c, 0x65, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x63, 0x6f, 0x6d, 0x70,
-	0x6c, 0x65, 0x74, 0x65, 0x22, 0x9c, 0x01, 0x0a, 0x15, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x46, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x17,
-	0x0a, 0x07, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
-	0x06, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69,
-	0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f,
-	0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x5f, 0x63,
-	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x70, 0x72, 0x6f, 0x63,
-	0x65, 0x73, 0x73, 0x6f, 0x72, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6c,
-	0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20,
-	0x01, 0x28, 0x04, 0x52, 0x0e, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x22, 0x19, 0x0a, 0x17, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x41, 0x76,
-	0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x27,
-	0x0a, 0x0f, 0x53, 0x68, 0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
-	0x65, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
-	0x52, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x22, 0x2c, 0x0a, 0x10, 0x53, 0x68, 0x75, 0x74, 0x64,
-	0x6f, 0x77, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x66,
-	0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x66, 0x6c,
-	0x75, 0x73, 0x68, 0x65, 0x64, 0x22, 0x34, 0x0a, 0x13, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x69, 0x6e,
-	0x67, 0x54, 0x65, 0x73, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1d, 0x0a, 0x0a,
-	0x73, 0x6f, 0x6d, 0x65, 0x5f, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x09, 0x73, 0x6f, 0x6d, 0x65, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x42, 0x36, 0x5a, 0x34, 0x67,
-	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x70, 0x69, 0x72, 0x69, 0x74,
-	0x2d, 0x6c, 0x61, 0x62, 0x73, 0x2f, 0x74, 0x65, 0x6b, 0x74, 0x69, 0x74, 0x65, 0x2f, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x73, 0x2f, 0x76, 0x31, 0x2f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x6d,
-	0x73, 0x67, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x73, 0x69, 0x6f, 0x6e, 0x22, 0x19, 0x0a, 0x17, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x41,
+	0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22,
+	0x27, 0x0a, 0x0f, 0x53, 0x68, 0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x05, 0x70, 0x68, 0x61, 0x73, 0x65, 0x22, 0x2c, 0x0a, 0x10, 0x53, 0x68, 0x75, 0x74,
+	0x64, 0x6f, 0x77, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07,
+	0x66, 0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x66,
+	0x6c, 0x75, 0x73, 0x68, 0x65, 0x64, 0x22, 0x34, 0x0a, 0x13, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x69,
+	0x6e, 0x67, 0x54, 0x65, 0x73, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1d, 0x0a,
+	0x0a, 0x73, 0x6f, 0x6d, 0x65, 0x5f, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x73, 0x6f, 0x6d, 0x65, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x42, 0x36, 0x5a, 0x34,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x70, 0x69, 0x72, 0x69,
+	0x74, 0x2d, 0x6c, 0x61, 0x62, 0x73, 0x2f, 0x74, 0x65, 0x6b, 0x74, 0x69, 0x74, 0x65, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x73, 0x2f, 0x76, 0x31, 0x2f, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x6d, 0x73, 0x67, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -2660,50 +3157,57 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescGZIP() []by
 	return file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDescData
 }
 
-var file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes = make([]protoimpl.MessageInfo, 42)
+var file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes = make([]protoimpl.MessageInfo, 49)
 var file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_goTypes = []interface{}{
-	(*ForwardBatchMessage)(nil),                         // 0: spiritlabs.tektite.clustermsgs.v1.ForwardBatchMessage
-	(*ReplicateMessage)(nil),                            // 1: spiritlabs.tektite.clustermsgs.v1.ReplicateMessage
-	(*LastCommittedRequest)(nil),                        // 2: spiritlabs.tektite.clustermsgs.v1.LastCommittedRequest
-	(*LastCommittedResponse)(nil),                       // 3: spiritlabs.tektite.clustermsgs.v1.LastCommittedResponse
-	(*SetLastCommittedMessage)(nil),                     // 4: spiritlabs.tektite.clustermsgs.v1.SetLastCommittedMessage
-	(*FlushMessage)(nil),                                // 5: spiritlabs.tektite.clustermsgs.v1.FlushMessage
-	(*LevelManagerGetTableIDsForRangeMessage)(nil),      // 6: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetTableIDsForRangeMessage
-	(*LevelManagerGetPrefixRetentionsMessage)(nil),      // 7: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetPrefixRetentionsMessage
-	(*LevelManagerGetTableIDsForRangeResponse)(nil),     // 8: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetTableIDsForRangeResponse
-	(*LevelManagerVersionRange)(nil),                    // 9: spiritlabs.tektite.clustermsgs.v1.LevelManagerVersionRange
-	(*LevelManagerRawResponse)(nil),                     // 10: spiritlabs.tektite.clustermsgs.v1.LevelManagerRawResponse
-	(*LevelManagerApplyChangesRequest)(nil),             // 11: spiritlabs.tektite.clustermsgs.v1.LevelManagerApplyChangesRequest
-	(*LevelManagerRegisterDeadVersionRangeRequest)(nil), // 12: spiritlabs.tektite.clustermsgs.v1.LevelManagerRegisterDeadVersionRangeRequest
-	(*LevelManagerL0AddRequest)(nil),                    // 13: spiritlabs.tektite.clustermsgs.v1.LevelManagerL0AddRequest
-	(*LevelManagerRegisterPrefixRetentionsRequest)(nil), // 14: spiritlabs.tektite.clustermsgs.v1.LevelManagerRegisterPrefixRetentionsRequest
-	(*LevelManagerLoadLastFlushedVersionMessage)(nil),   // 15: spiritlabs.tektite.clustermsgs.v1.LevelManagerLoadLastFlushedVersionMessage
-	(*LevelManagerLoadLastFlushedVersionResponse)(nil),  // 16: spiritlabs.tektite.clustermsgs.v1.LevelManagerLoadLastFlushedVersionResponse
-	(*LevelManagerStoreLastFlushedVersionMessage)(nil),  // 17: spiritlabs.tektite.clustermsgs.v1.LevelManagerStoreLastFlushedVersionMessage
-	(*LevelManagerGetStatsMessage)(nil),                 // 18: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetStatsMessage
-	(*LevelManagerGetStatsResponse)(nil),                // 19: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetStatsResponse
-	(*CompactionPollMessage)(nil),                       // 20: spiritlabs.tektite.clustermsgs.v1.CompactionPollMessage
-	(*CompactionPollResponse)(nil),                      // 21: spiritlabs.tektite.clustermsgs.v1.CompactionPollResponse
-	(*LocalObjStoreGetRequest)(nil),                     // 22: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreGetRequest
-	(*LocalObjStoreGetResponse)(nil),                    // 23: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreGetResponse
-	(*LocalObjStoreAddRequest)(nil),                     // 24: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreAddRequest
-	(*LocalObjStoreDeleteRequest)(nil),                  // 25: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreDeleteRequest
-	(*QueryMessage)(nil),                                // 26: spiritlabs.tektite.clustermsgs.v1.QueryMessage
-	(*QueryResponse)(nil),                               // 27: spiritlabs.tektite.clustermsgs.v1.QueryResponse
-	(*VersionsMessage)(nil),                             // 28: spiritlabs.tektite.clustermsgs.v1.VersionsMessage
-	(*GetCurrentVersionMessage)(nil),                    // 29: spiritlabs.tektite.clustermsgs.v1.GetCurrentVersionMessage
-	(*VersionCompleteMessage)(nil),                      // 30: spiritlabs.tektite.clustermsgs.v1.VersionCompleteMessage
-	(*FailureDetectedMessage)(nil),                      // 31: spiritlabs.tektite.clustermsgs.v1.FailureDetectedMessage
-	(*GetLastFailureFlushedVersionMessage)(nil),         // 32: spiritlabs.tektite.clustermsgs.v1.GetLastFailureFlushedVersionMessage
-	(*GetLastFailureFlushedVersionResponse)(nil),        // 33: spiritlabs.tektite.clustermsgs.v1.GetLastFailureFlushedVersionResponse
-	(*FailureCompleteMessage)(nil),                      // 34: spiritlabs.tektite.clustermsgs.v1.FailureCompleteMessage
-	(*IsFailureCompleteMessage)(nil),                    // 35: spiritlabs.tektite.clustermsgs.v1.IsFailureCompleteMessage
-	(*IsFailureCompleteResponse)(nil),                   // 36: spiritlabs.tektite.clustermsgs.v1.IsFailureCompleteResponse
-	(*VersionFlushedMessage)(nil),                       // 37: spiritlabs.tektite.clustermsgs.v1.VersionFlushedMessage
-	(*CommandAvailableMessage)(nil),                     // 38: spiritlabs.tektite.clustermsgs.v1.CommandAvailableMessage
-	(*ShutdownMessage)(nil),                             // 39: spiritlabs.tektite.clustermsgs.v1.ShutdownMessage
-	(*ShutdownResponse)(nil),                            // 40: spiritlabs.tektite.clustermsgs.v1.ShutdownResponse
-	(*RemotingTestMessage)(nil),                         // 41: spiritlabs.tektite.clustermsgs.v1.RemotingTestMessage
+	(*ForwardBatchMessage)(nil),                          // 0: spiritlabs.tektite.clustermsgs.v1.ForwardBatchMessage
+	(*ReplicateMessage)(nil),                             // 1: spiritlabs.tektite.clustermsgs.v1.ReplicateMessage
+	(*LastCommittedRequest)(nil),                         // 2: spiritlabs.tektite.clustermsgs.v1.LastCommittedRequest
+	(*LastCommittedResponse)(nil),                        // 3: spiritlabs.tektite.clustermsgs.v1.LastCommittedResponse
+	(*SetLastCommittedMessage)(nil),                      // 4: spiritlabs.tektite.clustermsgs.v1.SetLastCommittedMessage
+	(*FlushMessage)(nil),                                 // 5: spiritlabs.tektite.clustermsgs.v1.FlushMessage
+	(*LevelManagerGetTableIDsForRangeMessage)(nil),       // 6: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetTableIDsForRangeMessage
+	(*LevelManagerGetPrefixRetentionsMessage)(nil),       // 7: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetPrefixRetentionsMessage
+	(*LevelManagerGetTableIDsForRangeResponse)(nil),      // 8: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetTableIDsForRangeResponse
+	(*LevelManagerVersionRange)(nil),                     // 9: spiritlabs.tektite.clustermsgs.v1.LevelManagerVersionRange
+	(*LevelManagerRawResponse)(nil),                      // 10: spiritlabs.tektite.clustermsgs.v1.LevelManagerRawResponse
+	(*LevelManagerApplyChangesRequest)(nil),              // 11: spiritlabs.tektite.clustermsgs.v1.LevelManagerApplyChangesRequest
+	(*LevelManagerRegisterDeadVersionRangeRequest)(nil),  // 12: spiritlabs.tektite.clustermsgs.v1.LevelManagerRegisterDeadVersionRangeRequest
+	(*LevelManagerL0AddRequest)(nil),                     // 13: spiritlabs.tektite.clustermsgs.v1.LevelManagerL0AddRequest
+	(*LevelManagerRegisterPrefixRetentionsRequest)(nil),  // 14: spiritlabs.tektite.clustermsgs.v1.LevelManagerRegisterPrefixRetentionsRequest
+	(*LevelManagerLoadLastFlushedVersionMessage)(nil),    // 15: spiritlabs.tektite.clustermsgs.v1.LevelManagerLoadLastFlushedVersionMessage
+	(*LevelManagerLoadLastFlushedVersionResponse)(nil),   // 16: spiritlabs.tektite.clustermsgs.v1.LevelManagerLoadLastFlushedVersionResponse
+	(*LevelManagerStoreLastFlushedVersionMessage)(nil),   // 17: spiritlabs.tektite.clustermsgs.v1.LevelManagerStoreLastFlushedVersionMessage
+	(*LevelManagerGetStatsMessage)(nil),                  // 18: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetStatsMessage
+	(*LevelManagerGetStatsResponse)(nil),                 // 19: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetStatsResponse
+	(*LevelManagerRegisterVersionRetentionsRequest)(nil), // 20: spiritlabs.tektite.clustermsgs.v1.LevelManagerRegisterVersionRetentionsRequest
+	(*LevelManagerStoreVersionTimeMessage)(nil),          // 21: spiritlabs.tektite.clustermsgs.v1.LevelManagerStoreVersionTimeMessage
+	(*LevelManagerGetVersionForTimeMessage)(nil),         // 22: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetVersionForTimeMessage
+	(*LevelManagerGetVersionForTimeResponse)(nil),        // 23: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetVersionForTimeResponse
+	(*CompactionPollMessage)(nil),                        // 24: spiritlabs.tektite.clustermsgs.v1.CompactionPollMessage
+	(*CompactionPollResponse)(nil),                       // 25: spiritlabs.tektite.clustermsgs.v1.CompactionPollResponse
+	(*LocalObjStoreGetRequest)(nil),                      // 26: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreGetRequest
+	(*LocalObjStoreGetResponse)(nil),                     // 27: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreGetResponse
+	(*LocalObjStoreAddRequest)(nil),                      // 28: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreAddRequest
+	(*LocalObjStoreDeleteRequest)(nil),                   // 29: spiritlabs.tektite.clustermsgs.v1.LocalObjStoreDeleteRequest
+	(*QueryMessage)(nil),                                 // 30: spiritlabs.tektite.clustermsgs.v1.QueryMessage
+	(*QueryResponse)(nil),                                // 31: spiritlabs.tektite.clustermsgs.v1.QueryResponse
+	(*QueryCancelMessage)(nil),                           // 32: spiritlabs.tektite.clustermsgs.v1.QueryCancelMessage
+	(*QueryChangedMessage)(nil),                          // 33: spiritlabs.tektite.clustermsgs.v1.QueryChangedMessage
+	(*QueryChangedResponse)(nil),                         // 34: spiritlabs.tektite.clustermsgs.v1.QueryChangedResponse
+	(*VersionsMessage)(nil),                              // 35: spiritlabs.tektite.clustermsgs.v1.VersionsMessage
+	(*GetCurrentVersionMessage)(nil),                     // 36: spiritlabs.tektite.clustermsgs.v1.GetCurrentVersionMessage
+	(*VersionCompleteMessage)(nil),                       // 37: spiritlabs.tektite.clustermsgs.v1.VersionCompleteMessage
+	(*FailureDetectedMessage)(nil),                       // 38: spiritlabs.tektite.clustermsgs.v1.FailureDetectedMessage
+	(*GetLastFailureFlushedVersionMessage)(nil),          // 39: spiritlabs.tektite.clustermsgs.v1.GetLastFailureFlushedVersionMessage
+	(*GetLastFailureFlushedVersionResponse)(nil),         // 40: spiritlabs.tektite.clustermsgs.v1.GetLastFailureFlushedVersionResponse
+	(*FailureCompleteMessage)(nil),                       // 41: spiritlabs.tektite.clustermsgs.v1.FailureCompleteMessage
+	(*IsFailureCompleteMessage)(nil),                     // 42: spiritlabs.tektite.clustermsgs.v1.IsFailureCompleteMessage
+	(*IsFailureCompleteResponse)(nil),                    // 43: spiritlabs.tektite.clustermsgs.v1.IsFailureCompleteResponse
+	(*VersionFlushedMessage)(nil),                        // 44: spiritlabs.tektite.clustermsgs.v1.VersionFlushedMessage
+	(*CommandAvailableMessage)(nil),                      // 45: spiritlabs.tektite.clustermsgs.v1.CommandAvailableMessage
+	(*ShutdownMessage)(nil),                              // 46: spiritlabs.tektite.clustermsgs.v1.ShutdownMessage
+	(*ShutdownResponse)(nil),                             // 47: spiritlabs.tektite.clustermsgs.v1.ShutdownResponse
+	(*RemotingTestMessage)(nil),                          // 48: spiritlabs.tektite.clustermsgs.v1.RemotingTestMessage
 }
 var file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_depIdxs = []int32{
 	9, // 0: spiritlabs.tektite.clustermsgs.v1.LevelManagerGetTableIDsForRangeResponse.dead_versions:type_name -> spiritlabs.tektite.clustermsgs.v1.LevelManagerVersionRange
@@ -2961,7 +3465,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CompactionPollMessage); i {
+			switch v := v.(*LevelManagerRegisterVersionRetentionsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -2973,7 +3477,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CompactionPollResponse); i {
+			switch v := v.(*LevelManagerStoreVersionTimeMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -2985,7 +3489,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[22].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LocalObjStoreGetRequest); i {
+			switch v := v.(*LevelManagerGetVersionForTimeMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -2997,7 +3501,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LocalObjStoreGetResponse); i {
+			switch v := v.(*LevelManagerGetVersionForTimeResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3009,7 +3513,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[24].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LocalObjStoreAddRequest); i {
+			switch v := v.(*CompactionPollMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3021,7 +3525,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[25].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LocalObjStoreDeleteRequest); i {
+			switch v := v.(*CompactionPollResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3033,7 +3537,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[26].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*QueryMessage); i {
+			switch v := v.(*LocalObjStoreGetRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3045,7 +3549,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[27].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*QueryResponse); i {
+			switch v := v.(*LocalObjStoreGetResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3057,7 +3561,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[28].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*VersionsMessage); i {
+			switch v := v.(*LocalObjStoreAddRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3069,7 +3573,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[29].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetCurrentVersionMessage); i {
+			switch v := v.(*LocalObjStoreDeleteRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3081,7 +3585,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[30].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*VersionCompleteMessage); i {
+			switch v := v.(*QueryMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3093,7 +3597,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[31].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FailureDetectedMessage); i {
+			switch v := v.(*QueryResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3105,7 +3609,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[32].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetLastFailureFlushedVersionMessage); i {
+			switch v := v.(*QueryCancelMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3117,7 +3621,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[33].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetLastFailureFlushedVersionResponse); i {
+			switch v := v.(*QueryChangedMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3129,7 +3633,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[34].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FailureCompleteMessage); i {
+			switch v := v.(*QueryChangedResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3141,7 +3645,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[35].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*IsFailureCompleteMessage); i {
+			switch v := v.(*VersionsMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3153,7 +3657,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[36].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*IsFailureCompleteResponse); i {
+			switch v := v.(*GetCurrentVersionMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3165,7 +3669,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[37].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*VersionFlushedMessage); i {
+			switch v := v.(*VersionCompleteMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3177,7 +3681,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[38].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CommandAvailableMessage); i {
+			switch v := v.(*FailureDetectedMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3189,7 +3693,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[39].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ShutdownMessage); i {
+			switch v := v.(*GetLastFailureFlushedVersionMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3201,7 +3705,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[40].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ShutdownResponse); i {
+			switch v := v.(*GetLastFailureFlushedVersionResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -3213,6 +3717,90 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			}
 		}
 		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[41].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FailureCompleteMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[42].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*IsFailureCompleteMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[43].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*IsFailureCompleteResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[44].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VersionFlushedMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[45].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CommandAvailableMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[46].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ShutdownMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[47].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ShutdownResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_msgTypes[48].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*RemotingTestMessage); i {
 			case 0:
 				return &v.state
@@ -3231,7 +3819,7 @@ func file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_spiritsoft_tektite_clustermsgs_v1_clustermsgs_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   42,
+			NumMessages:   49,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"bytes"
 	"encoding/binary"
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/encoding"
@@ -33,6 +34,11 @@ type GetOperator struct {
 	emptyBatch      *evbatch.Batch
 	nodeID          int
 	keySchema       *evbatch.EventSchema
+	filters         []expr.Expression
+	filterRowCols   []bool
+	selectedRowCols []bool
+	// keyFromConditions is set when the key, or range of keys, comes from comparisons in a filter
+	keyFromConditions bool
 }
 
 type KeyColExpr interface {
@@ -80,17 +86,18 @@ func NewGetOperator(isRange bool, rangeStartExprs []expr.Expression, rangeEndExp
 	}
 }
 
-func (g *GetOperator) CreateIterator(partID uint64, args *evbatch.Batch, highestVersion uint64) (iteration.Iterator, error) {
+// CreateIterator creates the iterator over the rows of the partition. If fromKey is not nil the iterator starts at the
+// row with that key, or the next one, if that is later than the start of the range.
+func (g *GetOperator) CreateIterator(partID uint64, args *evbatch.Batch, highestVersion uint64,
+	fromKey []byte) (iteration.Iterator, error) {
 	var start, end []byte
 	if !g.isRange {
 		// get
-		keyStart, err := g.CreateRangeStartKey(args)
+		var err error
+		start, end, err = g.getKeyRange(partID, args, 0)
 		if err != nil {
 			return nil, err
 		}
-		start = encoding.AppendUint64ToBufferBE(g.keyPrefix, partID)
-		start = append(start, keyStart...)
-		end = common.IncrementBytesBigEndian(start)
 	} else if g.rangeStartExprs == nil && g.rangeEndExprs == nil {
 		// scan all
 		start = encoding.AppendUint64ToBufferBE(g.keyPrefix, partID)
@@ -98,10 +105,13 @@ func (g *GetOperator) CreateIterator(partID uint64, args *evbatch.Batch, highest
 	} else {
 		// scan range
 		if g.rangeStartExprs != nil {
-			keyStart, err := g.CreateRangeStartKey(args)
+			keyStart, noRows, err := g.createConditionKey(g.rangeStartExprs, args, 0)
 			if err != nil {
 				return nil, err
 			}
+			if noRows {
+				return g.createEmptyIterator(partID, highestVersion)
+			}
 			if !g.startInclusive {
 				keyStart = common.IncrementBytesBigEndian(keyStart)
 			}
@@ -111,10 +121,13 @@ func (g *GetOperator) CreateIterator(partID uint64, args *evbatch.Batch, highest
 			start = encoding.AppendUint64ToBufferBE(g.keyPrefix, partID)
 		}
 		if g.rangeEndExprs != nil {
-			keyEnd, err := g.CreateRangeEndKey(args)
+			keyEnd, noRows, err := g.createConditionKey(g.rangeEndExprs, args, 0)
 			if err != nil {
 				return nil, err
 			}
+			if noRows {
+				return g.createEmptyIterator(partID, highestVersion)
+			}
 			if g.endInclusive {
 				keyEnd = common.IncrementBytesBigEndian(keyEnd)
 			}
@@ -124,107 +137,184 @@ func (g *GetOperator) CreateIterator(partID uint64, args *evbatch.Batch, highest
 			end = encoding.AppendUint64ToBufferBE(g.keyPrefix, partID+1)
 		}
 	}
+	if fromKey != nil {
+		from := append(encoding.AppendUint64ToBufferBE(g.keyPrefix, partID), fromKey...)
+		if bytes.Compare(from, start) > 0 {
+			start = from
+		}
+	}
+	log.Debugf("node:%d creating query iterator start:%v end:%v with max version:%d", g.nodeID, start, end, highestVersion)
+	return g.store.NewIterator(start, end, highestVersion, false)
+}
+
+// CreateGetIterator creates the iterator for a get of the row with the key in the args at rowIndex, in the partition
+func (g *GetOperator) CreateGetIterator(partID uint64, args *evbatch.Batch, rowIndex int,
+	highestVersion uint64) (iteration.Iterator, error) {
+	start, end, err := g.getKeyRange(partID, args, rowIndex)
+	if err != nil {
+		return nil, err
+	}
 	log.Debugf("node:%d creating query iterator start:%v end:%v with max version:%d", g.nodeID, start, end, highestVersion)
 	return g.store.NewIterator(start, end, highestVersion, false)
 }
 
-func (g *GetOperator) CreateRangeStartKey(args *evbatch.Batch) ([]byte, error) {
-	return g.createKey(g.rangeStartExprs, args)
+func (g *GetOperator) getKeyRange(partID uint64, args *evbatch.Batch, rowIndex int) ([]byte, []byte, error) {
+	keyStart, noRows, err := g.createConditionKey(g.rangeStartExprs, args, rowIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := encoding.AppendUint64ToBufferBE(g.keyPrefix, partID)
+	if noRows {
+		// An empty range
+		return start, start, nil
+	}
+	start = append(start, keyStart...)
+	return start, common.IncrementBytesBigEndian(start), nil
 }
 
-func (g *GetOperator) CreateRangeEndKey(args *evbatch.Batch) ([]byte, error) {
-	return g.createKey(g.rangeEndExprs, args)
+// SetKeyFromConditions marks the key, or range of keys, of the operator as coming from comparisons in a filter, rather
+// than from a get or scan. A comparison with null is never true, so if any of the key expressions, e.g. a prepared
+// statement param, evaluates to null then no rows are returned - rather than the rows with a null key, or an unbounded
+// range.
+func (g *GetOperator) SetKeyFromConditions() {
+	g.keyFromConditions = true
 }
 
-func (g *GetOperator) createKey(exprs []expr.Expression, args *evbatch.Batch) ([]byte, error) {
+// createConditionKey creates the key from the expressions, and returns true if no rows can match it as it comes from
+// conditions and one of the expressions evaluates to null
+func (g *GetOperator) createConditionKey(exprs []expr.Expression, args *evbatch.Batch, rowIndex int) ([]byte, bool, error) {
+	key, hasNull, err := g.createKey(exprs, args, rowIndex)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, hasNull && g.keyFromConditions, nil
+}
+
+func (g *GetOperator) createEmptyIterator(partID uint64, highestVersion uint64) (iteration.Iterator, error) {
+	start := encoding.AppendUint64ToBufferBE(g.keyPrefix, partID)
+	return g.store.NewIterator(start, start, highestVersion, false)
+}
+
+func (g *GetOperator) CreateRangeStartKey(args *evbatch.Batch, rowIndex int) ([]byte, error) {
+	key, _, err := g.createKey(g.rangeStartExprs, args, rowIndex)
+	return key, err
+}
+
+func (g *GetOperator) CreateRangeEndKey(args *evbatch.Batch, rowIndex int) ([]byte, error) {
+	key, _, err := g.createKey(g.rangeEndExprs, args, rowIndex)
+	return key, err
+}
+
+// createKey creates the key from the expressions, and returns whether any of them evaluated to null
+func (g *GetOperator) createKey(exprs []expr.Expression, args *evbatch.Batch, rowIndex int) ([]byte, bool, error) {
 	buff := make([]byte, 0, 32)
+	hasNull := false
 	for _, e := range exprs {
 		switch e.ResultType().ID() {
 		case types.ColumnTypeIDInt:
-			val, null, err := e.EvalInt(0, args)
+			val, null, err := e.EvalInt(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeInt(buff, val)
 		case types.ColumnTypeIDFloat:
-			val, null, err := e.EvalFloat(0, args)
+			val, null, err := e.EvalFloat(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeFloat(buff, val)
 		case types.ColumnTypeIDBool:
-			val, null, err := e.EvalBool(0, args)
+			val, null, err := e.EvalBool(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.AppendBoolToBuffer(buff, val)
 		case types.ColumnTypeIDDecimal:
-			val, null, err := e.EvalDecimal(0, args)
+			val, null, err := e.EvalDecimal(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeDecimal(buff, val)
 		case types.ColumnTypeIDString:
-			val, null, err := e.EvalString(0, args)
+			val, null, err := e.EvalString(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeString(buff, val)
 		case types.ColumnTypeIDBytes:
-			val, null, err := e.EvalBytes(0, args)
+			val, null, err := e.EvalBytes(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeBytes(buff, val)
 		case types.ColumnTypeIDTimestamp:
-			val, null, err := e.EvalTimestamp(0, args)
+			val, null, err := e.EvalTimestamp(rowIndex, args)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if null {
+				hasNull = true
 				buff = append(buff, 0)
 				continue
 			}
 			buff = append(buff, 1)
 			buff = encoding.KeyEncodeTimestamp(buff, val)
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			val, null, err := e.EvalNested(rowIndex, args)
+			if err != nil {
+				return nil, false, err
+			}
+			if null {
+				hasNull = true
+				buff = append(buff, 0)
+				continue
+			}
+			buff = append(buff, 1)
+			buff = encoding.KeyEncodeNested(buff, e.ResultType(), val)
 		default:
 			panic("unknown type")
 		}
 	}
-	return buff, nil
+	return buff, hasNull, nil
 }
 
-func (g *GetOperator) CreateRawPartitionKey(args *evbatch.Batch) ([]byte, error) {
+func (g *GetOperator) CreateRawPartitionKey(args *evbatch.Batch, rowIndex int) ([]byte, error) {
 	if len(g.rangeStartExprs) != 1 {
 		return nil, errors.NewQueryErrorf("query on a raw partition key must have a single expression")
 	}
@@ -235,7 +325,7 @@ func (g *GetOperator) CreateRawPartitionKey(args *evbatch.Batch) ([]byte, error)
 			e.ResultType().String())
 	}
 	// A raw partition is chosen by hashing the bytes of the Kafka message key
-	val, null, err := e.EvalBytes(0, args)
+	val, null, err := e.EvalBytes(rowIndex, args)
 	if err != nil {
 		return nil, err
 	}
@@ -245,7 +335,44 @@ func (g *GetOperator) CreateRawPartitionKey(args *evbatch.Batch) ([]byte, error)
 	return val, nil
 }
 
-func (g *GetOperator) LoadBatch(iter iteration.Iterator, maxRows int) (*evbatch.Batch, bool, error) {
+// PushDownFilter makes the operator apply the filter to rows as they are loaded. The columns referenced by the filters
+// are decoded first, and the rest of the row is only decoded if it passes all the filters.
+func (g *GetOperator) PushDownFilter(filter expr.Expression, filterCols []int) {
+	g.filters = append(g.filters, filter)
+	mask := g.rowColsMask(filterCols)
+	if g.filterRowCols == nil {
+		g.filterRowCols = mask
+		return
+	}
+	for i, selected := range mask {
+		g.filterRowCols[i] = g.filterRowCols[i] || selected
+	}
+}
+
+// PushDownRequiredCols makes the operator only decode the specified columns. Any other non-key columns are skipped
+// and loaded as null. Key columns are always loaded.
+func (g *GetOperator) PushDownRequiredCols(requiredCols []int) {
+	g.selectedRowCols = g.rowColsMask(requiredCols)
+}
+
+func (g *GetOperator) rowColsMask(colIndexes []int) []bool {
+	mask := make([]bool, len(g.rowColIndexes))
+	for _, colIndex := range colIndexes {
+		for i, rowColIndex := range g.rowColIndexes {
+			if rowColIndex == colIndex {
+				mask[i] = true
+			}
+		}
+	}
+	return mask
+}
+
+// LoadBatch loads up to maxRows rows from the iterator. It returns the rows that pass any filters, the number of rows
+// that were scanned, and whether there are more rows to load.
+func (g *GetOperator) LoadBatch(iter iteration.Iterator, maxRows int) (*evbatch.Batch, int, bool, error) {
+	if len(g.filters) > 0 {
+		return g.loadFilteredBatch(iter, maxRows)
+	}
 	colBuilders := evbatch.CreateColBuilders(g.schema.EventSchema.ColumnTypes())
 	rc := 0
 	valid := false
@@ -253,7 +380,7 @@ func (g *GetOperator) LoadBatch(iter iteration.Iterator, maxRows int) (*evbatch.
 		var err error
 		valid, err = iter.IsValid()
 		if err != nil {
-			return nil, false, err
+			return nil, 0, false, err
 		}
 		if rc == maxRows {
 			break
@@ -268,18 +395,86 @@ func (g *GetOperator) LoadBatch(iter iteration.Iterator, maxRows int) (*evbatch.
 			log.Debugf("query loader loaded key %v (%s) value %v (%s) version %d", k, string(k), v, string(v), version)
 		}
 		if err := opers.LoadColsFromKey(colBuilders, g.keyColumnTypes, g.keyColIndexes, k); err != nil {
-			return nil, false, err
+			return nil, 0, false, err
 		}
-		opers.LoadColsFromValue(colBuilders, g.rowColumnTypes, g.rowColIndexes, iter.Current().Value)
+		opers.LoadSelectedColsFromValue(colBuilders, g.rowColumnTypes, g.rowColIndexes, iter.Current().Value,
+			g.selectedRowCols)
 		if err = iter.Next(); err != nil {
-			return nil, false, err
+			return nil, 0, false, err
+		}
+		rc++
+	}
+	if rc == 0 {
+		return g.emptyBatch, 0, false, nil
+	}
+	return evbatch.NewBatchFromBuilders(g.schema.EventSchema, colBuilders...), rc, valid, nil
+}
+
+// loadFilteredBatch loads up to maxRows rows from the iterator, and returns those that pass the filters. First, only
+// the columns needed by the filters are decoded, then the filters are evaluated, then the remaining columns are
+// decoded for the rows that pass.
+func (g *GetOperator) loadFilteredBatch(iter iteration.Iterator, maxRows int) (*evbatch.Batch, int, bool, error) {
+	columnTypes := g.schema.EventSchema.ColumnTypes()
+	filterColBuilders := evbatch.CreateColBuilders(columnTypes)
+	var kvs []common.KV
+	valid := false
+	for {
+		var err error
+		valid, err = iter.IsValid()
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if len(kvs) == maxRows || !valid {
+			break
+		}
+		kv := iter.Current()
+		if err := opers.LoadColsFromKey(filterColBuilders, g.keyColumnTypes, g.keyColIndexes, kv.Key); err != nil {
+			return nil, 0, false, err
+		}
+		opers.LoadSelectedColsFromValue(filterColBuilders, g.rowColumnTypes, g.rowColIndexes, kv.Value, g.filterRowCols)
+		kvs = append(kvs, kv)
+		if err = iter.Next(); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	if len(kvs) == 0 {
+		return g.emptyBatch, 0, false, nil
+	}
+	filterBatch := evbatch.NewBatchFromBuilders(g.schema.EventSchema, filterColBuilders...)
+	defer filterBatch.Release()
+	colBuilders := evbatch.CreateColBuilders(columnTypes)
+	rc := 0
+	for rowIndex, kv := range kvs {
+		accept, err := g.evalFilters(rowIndex, filterBatch)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if !accept {
+			continue
 		}
+		if err := opers.LoadColsFromKey(colBuilders, g.keyColumnTypes, g.keyColIndexes, kv.Key); err != nil {
+			return nil, 0, false, err
+		}
+		opers.LoadSelectedColsFromValue(colBuilders, g.rowColumnTypes, g.rowColIndexes, kv.Value, g.selectedRowCols)
 		rc++
 	}
 	if rc == 0 {
-		return g.emptyBatch, false, nil
+		return g.emptyBatch, len(kvs), valid, nil
+	}
+	return evbatch.NewBatchFromBuilders(g.schema.EventSchema, colBuilders...), len(kvs), valid, nil
+}
+
+func (g *GetOperator) evalFilters(rowIndex int, batch *evbatch.Batch) (bool, error) {
+	for _, filter := range g.filters {
+		accept, null, err := filter.EvalBool(rowIndex, batch)
+		if err != nil {
+			return false, err
+		}
+		if null || !accept {
+			return false, nil
+		}
 	}
-	return evbatch.NewBatchFromBuilders(g.schema.EventSchema, colBuilders...), valid, nil
+	return true, nil
 }
 
 func (g *GetOperator) HandleStreamBatch(*evbatch.Batch, opers.StreamExecContext) (*evbatch.Batch, error) {
@@ -316,3 +511,52 @@ func (g *GetOperator) Teardown(opers.StreamManagerCtx, *sync.RWMutex) {
 func (g *GetOperator) GetKeyColExprs() []expr.Expression {
 	return g.rangeStartExprs
 }
+
+// multiGetIterator iterates over the rows with the keys in the args at rows, in the partition, getting each in turn
+type multiGetIterator struct {
+	getOperator    *GetOperator
+	partID         uint64
+	args           *evbatch.Batch
+	rows           []int
+	highestVersion uint64
+	pos            int
+	iter           iteration.Iterator
+}
+
+func (m *multiGetIterator) Current() common.KV {
+	return m.iter.Current()
+}
+
+func (m *multiGetIterator) Next() error {
+	return m.iter.Next()
+}
+
+func (m *multiGetIterator) IsValid() (bool, error) {
+	for {
+		if m.iter == nil {
+			if m.pos == len(m.rows) {
+				return false, nil
+			}
+			iter, err := m.getOperator.CreateGetIterator(m.partID, m.args, m.rows[m.pos], m.highestVersion)
+			if err != nil {
+				return false, err
+			}
+			m.iter = iter
+			m.pos++
+		}
+		valid, err := m.iter.IsValid()
+		if err != nil || valid {
+			return valid, err
+		}
+		// No row with this key, or we have read it, so move on to the next key
+		m.iter.Close()
+		m.iter = nil
+	}
+}
+
+func (m *multiGetIterator) Close() {
+	if m.iter != nil {
+		m.iter.Close()
+		m.iter = nil
+	}
+}
@@ -0,0 +1,503 @@
+package query
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/opers"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"strings"
+	"sync"
+)
+
+// maxBroadcastJoinRows is the maximum number of rows in a table that is broadcast for a join
+var maxBroadcastJoinRows = 100000
+
+// joinLookupQueryPrefix is the prefix of the query name used when looking up a row by key for a join. The query is not
+// prepared, it is created on demand by the node that receives it.
+const joinLookupQueryPrefix = "$join_lookup:"
+
+// JoinOperator joins the results of a query with the rows of a table.
+//
+// A lookup join gets the row with each distinct join key in an incoming batch from the table - this requires the join
+// columns to be the key columns of the table. Rows in partitions on this node are read directly from the store, and the
+// rest are looked up on the nodes that own them with a get. A broadcast join scans the whole table once, on the node
+// executing the query, and the rows are sent to the remote nodes with the query, where they are joined using a hash
+// table. This is more efficient for small tables, and is used if the join columns are not the key columns of the table.
+type JoinOperator struct {
+	opers.BaseOperator
+	inSchema        *opers.OperatorSchema
+	outSchema       *opers.OperatorSchema
+	joinType        opers.JoinType
+	broadcast       bool
+	broadcastIndex  int
+	tableName       string
+	tableSchema     *evbatch.EventSchema
+	tableQuery      *QInfo
+	leftKeyCols     []int
+	rightKeyCols    []int
+	leftColsToKeep  []int
+	rightColsToKeep []int
+	mgr             *manager
+}
+
+func (m *manager) newJoinOperator(inSchema *opers.OperatorSchema, desc *parser.QueryJoinDesc,
+	broadcastIndex int) (*JoinOperator, error) {
+	streamInfo := m.streamInfoProvider.GetStream(desc.TableName)
+	if streamInfo == nil || streamInfo.UserSlab == nil ||
+		(streamInfo.UserSlab.Type != opers.SlabTypeUserStream && streamInfo.UserSlab.Type != opers.SlabTypeUserTable &&
+			streamInfo.UserSlab.Type != opers.SlabTypeQueryableInternal) {
+		return nil, queryErrorAtTokenf(desc.TableName, desc, "unknown table or stream '%s'", desc.TableName)
+	}
+	tableSlab := streamInfo.UserSlab
+	tableSchema := tableSlab.Schema.EventSchema
+	errorf := func(token lexer.Token, msg string, args ...any) error {
+		return queryErrorAtPositionf(token, desc, msg, args...)
+	}
+	joinType, leftKeyColNames, rightKeyColNames, err := opers.ResolveJoinElements(desc.JoinElements,
+		inSchema.EventSchema, tableSchema, errorf)
+	if err != nil {
+		return nil, err
+	}
+	if joinType == opers.JoinTypeRightOuter {
+		return nil, errorf(desc.JoinElements[0].JoinTypeToken, "right outer joins are not supported in queries")
+	}
+	leftKeyCols := columnIndexes(inSchema.EventSchema, leftKeyColNames)
+	rightKeyCols := columnIndexes(tableSchema, rightKeyColNames)
+	var leftKeyTypes, rightKeyTypes []types.ColumnType
+	for i := range leftKeyCols {
+		leftKeyTypes = append(leftKeyTypes, inSchema.EventSchema.ColumnTypes()[leftKeyCols[i]])
+		rightKeyTypes = append(rightKeyTypes, tableSchema.ColumnTypes()[rightKeyCols[i]])
+	}
+	if err := opers.CheckJoinColumnTypes(desc.JoinElements, leftKeyTypes, rightKeyTypes, errorf); err != nil {
+		return nil, err
+	}
+
+	// A lookup join gets rows by key, so the join columns must be the key columns of the table. We order the join
+	// columns in the order of the key columns
+	lookupLeftKeyCols, canLookup := lookupKeyCols(tableSlab, leftKeyCols, rightKeyCols)
+	var broadcast bool
+	switch desc.Strategy {
+	case "lookup":
+		if !canLookup {
+			return nil, queryErrorAtTokenf("strategy", desc,
+				"cannot use a lookup join - the join columns must be the key columns of table '%s'", desc.TableName)
+		}
+	case "broadcast":
+		broadcast = true
+	default:
+		broadcast = !canLookup
+	}
+	j := &JoinOperator{
+		inSchema:       inSchema,
+		joinType:       joinType,
+		broadcast:      broadcast,
+		broadcastIndex: broadcastIndex,
+		tableName:      desc.TableName,
+		tableSchema:    tableSchema,
+		leftKeyCols:    leftKeyCols,
+		rightKeyCols:   rightKeyCols,
+		mgr:            m,
+	}
+	if broadcast {
+		queryDesc, err := m.parser.ParseQuery(j.broadcastTsl())
+		if err != nil {
+			return nil, err
+		}
+		j.tableQuery, err = m.createQueryInfo(queryDesc.OperatorDescs, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		j.leftKeyCols = lookupLeftKeyCols
+		j.rightKeyCols = tableSlab.KeyColIndexes
+		j.tableQuery = m.createJoinLookupQueryInfo(streamInfo)
+	}
+
+	// The output schema is all the columns from the left followed by all the columns from the right without the join
+	// columns. Columns are prefixed with l_ and r_ to disambiguate
+	rightKeyMap := map[string]struct{}{}
+	for _, rightKeyCol := range rightKeyColNames {
+		rightKeyMap[rightKeyCol] = struct{}{}
+	}
+	var outNames []string
+	var outTypes []types.ColumnType
+	for i, leftColName := range inSchema.EventSchema.ColumnNames() {
+		if leftColName == opers.OffsetColName {
+			continue
+		}
+		outNames = append(outNames, fmt.Sprintf("l_%s", leftColName))
+		outTypes = append(outTypes, inSchema.EventSchema.ColumnTypes()[i])
+		j.leftColsToKeep = append(j.leftColsToKeep, i)
+	}
+	for i, rightColName := range tableSchema.ColumnNames() {
+		if rightColName == opers.OffsetColName {
+			continue
+		}
+		if _, isKey := rightKeyMap[rightColName]; isKey {
+			continue
+		}
+		outNames = append(outNames, fmt.Sprintf("r_%s", rightColName))
+		outTypes = append(outTypes, tableSchema.ColumnTypes()[i])
+		j.rightColsToKeep = append(j.rightColsToKeep, i)
+	}
+	outSchema := inSchema.Copy()
+	outSchema.EventSchema = evbatch.NewEventSchema(outNames, outTypes)
+	j.outSchema = outSchema
+	return j, nil
+}
+
+func columnIndexes(schema *evbatch.EventSchema, colNames []string) []int {
+	indexes := make([]int, len(colNames))
+	for i, colName := range colNames {
+		for colIndex, name := range schema.ColumnNames() {
+			if name == colName {
+				indexes[i] = colIndex
+			}
+		}
+	}
+	return indexes
+}
+
+// lookupKeyCols returns the left join columns in the order of the key columns of the table, and true, if the right join
+// columns are the key columns of the table
+func lookupKeyCols(tableSlab *opers.SlabInfo, leftKeyCols []int, rightKeyCols []int) ([]int, bool) {
+	if tableSlab.Type == opers.SlabTypeUserStream || len(rightKeyCols) != len(tableSlab.KeyColIndexes) {
+		return nil, false
+	}
+	lookupCols := make([]int, len(tableSlab.KeyColIndexes))
+	for i, keyCol := range tableSlab.KeyColIndexes {
+		found := false
+		for j, rightKeyCol := range rightKeyCols {
+			if rightKeyCol == keyCol {
+				lookupCols[i] = leftKeyCols[j]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return lookupCols, true
+}
+
+func (j *JoinOperator) broadcastTsl() string {
+	return fmt.Sprintf("(scan all from %s)", j.tableName)
+}
+
+// createJoinLookupQueryInfo creates a query that gets the rows of a table with the keys specified in the rows of the
+// args. This is used by a lookup join to get the rows that are not in partitions on this node.
+func (m *manager) createJoinLookupQueryInfo(streamInfo *opers.StreamInfo) *QInfo {
+	slabInfo := streamInfo.UserSlab
+	tableSchema := slabInfo.Schema.EventSchema
+	var keyNames []string
+	var keyTypes []types.ColumnType
+	var keyExprs []expr.Expression
+	for i, keyCol := range slabInfo.KeyColIndexes {
+		keyType := tableSchema.ColumnTypes()[keyCol]
+		keyNames = append(keyNames, tableSchema.ColumnNames()[keyCol])
+		keyTypes = append(keyTypes, keyType)
+		keyExprs = append(keyExprs, expr.NewColumnExpression(i, keyType))
+	}
+	iterProvider := m.storeIteratorProvider
+	if streamInfo.StreamMeta {
+		iterProvider = m.streamMetaIteratorProvider
+	}
+	getOper := NewGetOperator(false, keyExprs, nil, true, false, slabInfo.SlabID, slabInfo.KeyColIndexes,
+		slabInfo.Schema, iterProvider, m.nodeID)
+	nro := &networkResultsOperator{
+		remoting: m.remoting,
+	}
+	getOper.AddDownStreamOperator(nro)
+	return &QInfo{
+		SlabInfo:           slabInfo,
+		RemoteOperators:    []opers.Operator{getOper, nro},
+		ParamSchema:        evbatch.NewEventSchema(keyNames, keyTypes),
+		RemoteResultSchema: tableSchema,
+		ResultSchema:       tableSchema,
+		FullKeyLookup:      true,
+		MultiKeyLookup:     true,
+	}
+}
+
+// joinLookupQueryInfo returns the query info for a query name used by a lookup join, or nil if the name is not one
+func (m *manager) joinLookupQueryInfo(queryName string) (*QInfo, error) {
+	tableName, ok := strings.CutPrefix(queryName, joinLookupQueryPrefix)
+	if !ok {
+		return nil, nil
+	}
+	streamInfo := m.streamInfoProvider.GetStream(tableName)
+	if streamInfo == nil || streamInfo.UserSlab == nil {
+		return nil, errors.NewQueryErrorf("unknown table '%s'", tableName)
+	}
+	return m.createJoinLookupQueryInfo(streamInfo), nil
+}
+
+// joinTable holds the rows of the table being joined with, by encoded join key
+type joinTable struct {
+	rows map[string][]joinRow
+}
+
+type joinRow struct {
+	batch    *evbatch.Batch
+	rowIndex int
+}
+
+func newJoinTable(batch *evbatch.Batch, keyCols []int) *joinTable {
+	table := &joinTable{rows: map[string][]joinRow{}}
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		if hasNullKeyCol(batch, rowIndex, keyCols) {
+			continue
+		}
+		key := string(evbatch.EncodeKeyCols(batch, rowIndex, keyCols, nil))
+		table.rows[key] = append(table.rows[key], joinRow{batch: batch, rowIndex: rowIndex})
+	}
+	return table
+}
+
+func hasNullKeyCol(batch *evbatch.Batch, rowIndex int, keyCols []int) bool {
+	for _, keyCol := range keyCols {
+		if batch.Columns[keyCol].IsNull(rowIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JoinOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx opers.QueryExecContext) (*evbatch.Batch, error) {
+	ctx := execCtx.(*queryExecCtx)
+	var table *joinTable
+	if j.broadcast {
+		table = ctx.joinTables[j.broadcastIndex]
+	} else {
+		var err error
+		table, err = j.lookupRows(batch, ctx.highestVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+	outBatch := j.joinBatch(batch, table)
+	return outBatch, j.SendQueryBatchDownStream(outBatch, execCtx)
+}
+
+func (j *JoinOperator) joinBatch(batch *evbatch.Batch, table *joinTable) *evbatch.Batch {
+	outTypes := j.outSchema.EventSchema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(outTypes)
+	numLeftCols := len(j.leftColsToKeep)
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		// Null never matches
+		var matches []joinRow
+		if !hasNullKeyCol(batch, rowIndex, j.leftKeyCols) {
+			key := evbatch.EncodeKeyCols(batch, rowIndex, j.leftKeyCols, nil)
+			matches = table.rows[string(key)]
+		}
+		if len(matches) == 0 {
+			if j.joinType != opers.JoinTypeLeftOuter {
+				continue
+			}
+			j.copyLeftCols(batch, rowIndex, builders)
+			for i := range j.rightColsToKeep {
+				builders[numLeftCols+i].AppendNull()
+			}
+			continue
+		}
+		for _, match := range matches {
+			j.copyLeftCols(batch, rowIndex, builders)
+			for i, colIndex := range j.rightColsToKeep {
+				evbatch.CopyColumnEntryWithCol(outTypes[numLeftCols+i], match.batch.Columns[colIndex],
+					builders[numLeftCols+i], match.rowIndex)
+			}
+		}
+	}
+	return evbatch.NewBatchFromBuilders(j.outSchema.EventSchema, builders...)
+}
+
+func (j *JoinOperator) copyLeftCols(batch *evbatch.Batch, rowIndex int, builders []evbatch.ColumnBuilder) {
+	outTypes := j.outSchema.EventSchema.ColumnTypes()
+	for i, colIndex := range j.leftColsToKeep {
+		evbatch.CopyColumnEntryWithCol(outTypes[i], batch.Columns[colIndex], builders[i], rowIndex)
+	}
+}
+
+// lookupRows gets the rows of the table for each distinct join key in the batch. Keys in partitions on this node are
+// read directly from the store, and the rest are looked up with a single query, which gets all the keys on each node
+// with one message to that node.
+func (j *JoinOperator) lookupRows(batch *evbatch.Batch, highestVersion uint64) (*joinTable, error) {
+	table := &joinTable{rows: map[string][]joinRow{}}
+	args := j.createLookupArgs(batch)
+	remoteNodePartitions := map[int][]int{}
+	remotePartitions := map[int]struct{}{}
+	var remoteRows []int
+	for rowIndex := 0; rowIndex < args.RowCount; rowIndex++ {
+		partID, nodeID, err := j.mgr.keyPartition(j.tableQuery, args, rowIndex)
+		if err != nil {
+			return nil, err
+		}
+		if nodeID != j.mgr.nodeID {
+			remoteRows = append(remoteRows, rowIndex)
+			if _, exists := remotePartitions[partID]; !exists {
+				remotePartitions[partID] = struct{}{}
+				remoteNodePartitions[nodeID] = append(remoteNodePartitions[nodeID], partID)
+			}
+			continue
+		}
+		res, err := j.getLocal(uint64(partID), args, rowIndex, highestVersion)
+		if err != nil {
+			return nil, err
+		}
+		j.addLookupResult(table, res)
+	}
+	if len(remoteRows) == 0 {
+		return table, nil
+	}
+	remoteArgs := args
+	if len(remoteRows) < args.RowCount {
+		remoteArgs = copyRows(args, remoteRows)
+	}
+	results, err := j.mgr.executeAndGather(j.tableQuery, joinLookupQueryPrefix+j.tableName, "", remoteArgs,
+		remoteNodePartitions, int64(highestVersion), Limits{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range results {
+		j.addLookupResult(table, res)
+	}
+	return table, nil
+}
+
+// addLookupResult adds the rows got from the table to the join table, by their key
+func (j *JoinOperator) addLookupResult(table *joinTable, res *evbatch.Batch) {
+	for rowIndex := 0; rowIndex < res.RowCount; rowIndex++ {
+		key := string(evbatch.EncodeKeyCols(res, rowIndex, j.rightKeyCols, nil))
+		table.rows[key] = append(table.rows[key], joinRow{batch: res, rowIndex: rowIndex})
+	}
+}
+
+// createLookupArgs creates the args for the get of the rows with the join keys in the batch, with a row for each
+// distinct join key that is not null
+func (j *JoinOperator) createLookupArgs(batch *evbatch.Batch) *evbatch.Batch {
+	paramTypes := j.tableQuery.ParamSchema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(paramTypes)
+	keys := map[string]struct{}{}
+	for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+		if hasNullKeyCol(batch, rowIndex, j.leftKeyCols) {
+			continue
+		}
+		key := string(evbatch.EncodeKeyCols(batch, rowIndex, j.leftKeyCols, nil))
+		if _, exists := keys[key]; exists {
+			continue
+		}
+		keys[key] = struct{}{}
+		for i, colIndex := range j.leftKeyCols {
+			evbatch.CopyColumnEntryWithCol(paramTypes[i], batch.Columns[colIndex], builders[i], rowIndex)
+		}
+	}
+	return evbatch.NewBatchFromBuilders(j.tableQuery.ParamSchema, builders...)
+}
+
+func copyRows(batch *evbatch.Batch, rows []int) *evbatch.Batch {
+	columnTypes := batch.Schema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(columnTypes)
+	for _, rowIndex := range rows {
+		for colIndex, colType := range columnTypes {
+			evbatch.CopyColumnEntry(colType, builders, colIndex, rowIndex, batch)
+		}
+	}
+	return evbatch.NewBatchFromBuilders(batch.Schema, builders...)
+}
+
+func (j *JoinOperator) getLocal(partID uint64, args *evbatch.Batch, rowIndex int,
+	highestVersion uint64) (*evbatch.Batch, error) {
+	getOper := j.tableQuery.RemoteOperators[0].(*GetOperator)
+	iter, err := getOper.CreateGetIterator(partID, args, rowIndex, highestVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	batch, _, _, err := getOper.LoadBatch(iter, 1)
+	return batch, err
+}
+
+// loadBroadcastTables scans the tables of the broadcast joins of the query, and returns the rows of each as a
+// serialized batch
+func (m *manager) loadBroadcastTables(info *QInfo, highestVersion int64, qrh *queryResultHandler) ([][]byte, error) {
+	var tables [][]byte
+	memoryBytes := int64(0)
+	for _, join := range info.BroadcastJoins {
+		tableName := join.tableName
+		// We stop scanning the table as soon as it has too many rows, or the query has stopped
+		checkRows := func(numRows int) error {
+			if qrh.isStopped() {
+				return errors.NewTektiteErrorf(errors.ExecuteQueryError, "query has stopped")
+			}
+			if numRows > maxBroadcastJoinRows {
+				return errors.NewQueryErrorf("cannot join with '%s' - it has more than %d rows so it cannot be broadcast. join with the key columns of the table to use a lookup join",
+					tableName, maxBroadcastJoinRows)
+			}
+			return nil
+		}
+		results, err := m.executeAndGather(join.tableQuery, "", join.broadcastTsl(), nil, nil, highestVersion,
+			Limits{}, checkRows)
+		if err != nil {
+			return nil, err
+		}
+		columnTypes := join.tableSchema.ColumnTypes()
+		builders := evbatch.CreateColBuilders(columnTypes)
+		for _, res := range results {
+			for rowIndex := 0; rowIndex < res.RowCount; rowIndex++ {
+				for colIndex, colType := range columnTypes {
+					evbatch.CopyColumnEntry(colType, builders, colIndex, rowIndex, res)
+				}
+			}
+		}
+		table := evbatch.NewBatchFromBuilders(join.tableSchema, builders...).Serialize(nil)
+		// The rows are held in memory on every node the query is sent to
+		memoryBytes += int64(len(table))
+		if err := qrh.checkMemory(memoryBytes); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// createJoinTables creates the hash tables for the broadcast joins of the query from the rows sent with the query
+func createJoinTables(info *QInfo, buffs [][]byte) ([]*joinTable, error) {
+	if len(buffs) != len(info.BroadcastJoins) {
+		return nil, errors.Errorf("expected rows for %d broadcast joins, received %d", len(info.BroadcastJoins),
+			len(buffs))
+	}
+	tables := make([]*joinTable, len(buffs))
+	for i, join := range info.BroadcastJoins {
+		batch := evbatch.NewBatchFromSingleBuff(join.tableSchema, buffs[i])
+		tables[i] = newJoinTable(batch, join.rightKeyCols)
+	}
+	return tables, nil
+}
+
+func (j *JoinOperator) HandleStreamBatch(*evbatch.Batch, opers.StreamExecContext) (*evbatch.Batch, error) {
+	panic("not supported in streams")
+}
+
+func (j *JoinOperator) HandleBarrier(opers.StreamExecContext) error {
+	panic("not supported in streams")
+}
+
+func (j *JoinOperator) InSchema() *opers.OperatorSchema {
+	return j.inSchema
+}
+
+func (j *JoinOperator) OutSchema() *opers.OperatorSchema {
+	return j.outSchema
+}
+
+func (j *JoinOperator) Setup(opers.StreamManagerCtx) error {
+	return nil
+}
+
+func (j *JoinOperator) Teardown(opers.StreamManagerCtx, *sync.RWMutex) {
+}
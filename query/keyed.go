@@ -0,0 +1,230 @@
+package query
+
+import (
+	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/errors"
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/opers"
+	"github.com/spirit-labs/tektite/parser"
+	"strings"
+)
+
+// keyedQueryPrefix is the prefix of the query name used when executing the scan of a keyed query. As with the lookup of
+// a join, the query is not prepared - the rest of the name is the TSL of the query, and the node that receives it
+// creates the query from that.
+const keyedQueryPrefix = "$keyed:"
+
+// KeyedResults holds the results of a query by the key of the row of its table that they come from
+type KeyedResults struct {
+	Schema *evbatch.EventSchema
+	// Rows holds the result rows by the encoded key columns of the row of the table. A key whose row has no results,
+	// e.g. because it no longer matches a filter, has a nil batch.
+	Rows map[string]*evbatch.Batch
+}
+
+// keyedQuery is a query whose result rows each come from a single row of the table it reads - a 'scan all' of a table
+// followed only by filters and projections. Its results can be kept up to date by the key of the row they come from, as
+// the results for the rows of the table that have been written can be found by getting just those rows, rather than
+// executing the whole query again.
+//
+// The scan, along with any filters directly after it, is executed on the nodes with the partitions of the table as for
+// any other query, so the filters are pushed down, and it returns the matching rows of the table with their keys. The
+// rest of the query is then applied to each row on its own on this node.
+type keyedQuery struct {
+	tableName  string
+	streamInfo *opers.StreamInfo
+	scanInfo   *QInfo
+	// rowOperators has an operator for each operator of the query after the scan, to apply it to a row of the table
+	rowOperators []opers.Operator
+	// numScanFilters is the number of filters that are executed with the scan
+	numScanFilters int
+}
+
+// newKeyedQuery creates the keyed query for the query, or returns nil if the query is not of the form of one. The lock
+// must be held.
+func (m *manager) newKeyedQuery(query parser.QueryDesc) (*keyedQuery, error) {
+	opDescs := query.OperatorDescs
+	scanDesc, ok := opDescs[0].(*parser.ScanDesc)
+	if !ok || !scanDesc.All || scanDesc.AsOfTime != nil || scanDesc.AsOfVersion != nil {
+		return nil, nil
+	}
+	streamInfo := m.streamInfoProvider.GetStream(scanDesc.TableName)
+	if streamInfo == nil || streamInfo.UserSlab == nil || streamInfo.UserSlab.Type != opers.SlabTypeUserTable {
+		return nil, nil
+	}
+	kq := &keyedQuery{
+		tableName:  scanDesc.TableName,
+		streamInfo: streamInfo,
+	}
+	schema := streamInfo.UserSlab.Schema
+	afterProject := false
+	for _, opDesc := range opDescs[1:] {
+		var oper opers.Operator
+		var err error
+		switch desc := opDesc.(type) {
+		case *parser.FilterDesc:
+			oper, err = opers.NewFilterOperator(schema, desc.Expr, m.expressionFactory)
+			if !afterProject {
+				kq.numScanFilters++
+			}
+		case *parser.ProjectDesc:
+			oper, err = opers.NewProjectOperator(schema, desc.Expressions, false, m.expressionFactory)
+			afterProject = true
+		default:
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		kq.rowOperators = append(kq.rowOperators, oper)
+		schema = oper.OutSchema()
+	}
+	var err error
+	kq.scanInfo, err = m.createQueryInfo(opDescs[:1+kq.numScanFilters], nil)
+	if err != nil {
+		return nil, err
+	}
+	return kq, nil
+}
+
+// keyedQueryInfo returns the query info for the scan of a keyed query, or nil if the query name is not one
+func (m *manager) keyedQueryInfo(queryName string) (*QInfo, error) {
+	tsl, ok := strings.CutPrefix(queryName, keyedQueryPrefix)
+	if !ok {
+		return nil, nil
+	}
+	queryDesc, err := m.parser.ParseQuery(tsl)
+	if err != nil {
+		return nil, err
+	}
+	kq, err := m.newKeyedQuery(*queryDesc)
+	if err != nil {
+		return nil, err
+	}
+	if kq == nil {
+		return nil, errors.NewQueryErrorf("query '%s' cannot be executed by key", tsl)
+	}
+	return kq.scanInfo, nil
+}
+
+// ExecuteQueryDirectByKey executes the query against the data as of highestVersion, and returns its results by the key
+// of the row of the table they come from. It returns false if the query is not one whose result rows each come from a
+// single row of its table - a 'scan all' of a table followed only by filters and projections.
+func (m *manager) ExecuteQueryDirectByKey(tsl string, query parser.QueryDesc, highestVersion int64,
+	limits Limits) (*KeyedResults, bool, error) {
+	m.lock.RLock()
+	kq, err := m.newKeyedQuery(query)
+	m.lock.RUnlock()
+	if err != nil || kq == nil {
+		return nil, false, err
+	}
+	if err := m.checkVersionRetained([]string{kq.tableName}, highestVersion); err != nil {
+		return nil, false, err
+	}
+	batches, err := m.executeAndGather(kq.scanInfo, keyedQueryPrefix+tsl, "", nil, nil, highestVersion, limits, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	results := kq.newResults()
+	if err := kq.addResults(results, batches, kq.numScanFilters); err != nil {
+		return nil, false, err
+	}
+	return results, true, nil
+}
+
+// ExecuteQueryDirectForChangedKeys executes a query of the form supported by ExecuteQueryDirectByKey for just the rows
+// of its table that may have been written after changedAfterVersion, and returns their results as of highestVersion by
+// key. Each of the keys is in the results, with a nil batch if its row has no results, e.g. because it no longer
+// matches a filter. The rows are got by key in the same way as with a 'get'. It returns false if the keys written are
+// not known, e.g. because a node has restarted or too many rows have been written, or the query is not of the supported
+// form - in that case the query must be executed with ExecuteQueryDirectByKey instead.
+func (m *manager) ExecuteQueryDirectForChangedKeys(query parser.QueryDesc, changedAfterVersion int64,
+	highestVersion int64) (*KeyedResults, bool, error) {
+	m.lock.RLock()
+	kq, err := m.newKeyedQuery(query)
+	var lookupInfo *QInfo
+	if kq != nil {
+		lookupInfo = m.createJoinLookupQueryInfo(kq.streamInfo)
+	}
+	m.lock.RUnlock()
+	if err != nil || kq == nil {
+		return nil, false, err
+	}
+	keys, ok, err := m.changedKeys(kq.tableName, changedAfterVersion)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	results := kq.newResults()
+	if len(keys) == 0 {
+		return results, true, nil
+	}
+	keyTypes := lookupInfo.ParamSchema.ColumnTypes()
+	builders := evbatch.CreateColBuilders(keyTypes)
+	for _, key := range keys {
+		keyValues, _, err := encoding.DecodeKeyToSlice(key, 0, keyTypes)
+		if err != nil {
+			return nil, false, err
+		}
+		appendArgs(builders, keyTypes, keyValues)
+		results.Rows[string(key)] = nil
+	}
+	args := evbatch.NewBatchFromBuilders(lookupInfo.ParamSchema, builders...)
+	// Each row of the args is got from its partition, so we only send the query to those partitions
+	nodePartitions := map[int][]int{}
+	partitions := map[int]struct{}{}
+	for rowIndex := 0; rowIndex < args.RowCount; rowIndex++ {
+		partID, nodeID, err := m.keyPartition(lookupInfo, args, rowIndex)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, exists := partitions[partID]; !exists {
+			partitions[partID] = struct{}{}
+			nodePartitions[nodeID] = append(nodePartitions[nodeID], partID)
+		}
+	}
+	batches, err := m.executeAndGather(lookupInfo, joinLookupQueryPrefix+kq.tableName, "", args, nodePartitions,
+		highestVersion, Limits{}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := kq.addResults(results, batches, 0); err != nil {
+		return nil, false, err
+	}
+	return results, true, nil
+}
+
+func (k *keyedQuery) newResults() *KeyedResults {
+	schema := k.streamInfo.UserSlab.Schema.EventSchema
+	if len(k.rowOperators) > 0 {
+		schema = k.rowOperators[len(k.rowOperators)-1].OutSchema().EventSchema
+	}
+	return &KeyedResults{
+		Schema: schema,
+		Rows:   map[string]*evbatch.Batch{},
+	}
+}
+
+// addResults applies the row operators from fromOperator to each row of the table in the batches, and adds the results
+// to the keyed results. A row with no results is only added if its key is already in the results.
+func (k *keyedQuery) addResults(results *KeyedResults, batches []*evbatch.Batch, fromOperator int) error {
+	keyCols := k.streamInfo.UserSlab.KeyColIndexes
+	for _, batch := range batches {
+		for rowIndex := 0; rowIndex < batch.RowCount; rowIndex++ {
+			key := string(evbatch.EncodeKeyCols(batch, rowIndex, keyCols, nil))
+			res := copyRows(batch, []int{rowIndex})
+			for _, oper := range k.rowOperators[fromOperator:] {
+				var err error
+				res, err = oper.HandleQueryBatch(res, nil)
+				if err != nil {
+					return err
+				}
+			}
+			if res.RowCount > 0 {
+				results.Rows[key] = res
+			} else if _, exists := results.Rows[key]; exists {
+				results.Rows[key] = nil
+			}
+		}
+	}
+	return nil
+}
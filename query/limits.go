@@ -0,0 +1,190 @@
+package query
+
+import (
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	log "github.com/spirit-labs/tektite/logger"
+	"github.com/spirit-labs/tektite/opers"
+	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Limits are the guardrails applied to an execution of a query. A zero value means the limit configured for the server
+// applies, and a negative value means there is no limit.
+type Limits struct {
+	// Timeout is the maximum time the query can take before it is cancelled
+	Timeout time.Duration
+	// MaxRowsScanned is the maximum number of rows the query can scan, across all the nodes it executes on
+	MaxRowsScanned int64
+	// MaxMemoryBytes is the maximum memory the query can use on the node that received it, to hold the results while
+	// sorting them, the groups of an aggregation, or the rows of the tables of broadcast joins
+	MaxMemoryBytes int64
+}
+
+// NoLimits is used for queries that must not be limited, such as the system queries the server executes
+var NoLimits = Limits{Timeout: -1, MaxRowsScanned: -1, MaxMemoryBytes: -1}
+
+func (l Limits) withDefaults(defaults Limits) Limits {
+	if l.Timeout == 0 {
+		l.Timeout = defaults.Timeout
+	}
+	if l.MaxRowsScanned == 0 {
+		l.MaxRowsScanned = defaults.MaxRowsScanned
+	}
+	if l.MaxMemoryBytes == 0 {
+		l.MaxMemoryBytes = defaults.MaxMemoryBytes
+	}
+	return l
+}
+
+// RunningQuery describes a query that is executing, that was received by this node
+type RunningQuery struct {
+	ID string
+	// QueryName is the name of the prepared query, if the query is a prepared query
+	QueryName string
+	// TSL is the query, if the query is not a prepared query
+	TSL       string
+	Version   int64
+	StartTime time.Time
+}
+
+// RunningQueries returns the queries that are executing, that were received by this node, oldest first
+func (m *manager) RunningQueries() []RunningQuery {
+	var queries []RunningQuery
+	m.resultHandlers.Range(func(_, value any) bool {
+		queries = append(queries, value.(*queryResultHandler).runningQuery)
+		return true
+	})
+	sort.SliceStable(queries, func(i, j int) bool {
+		return queries[i].StartTime.Before(queries[j].StartTime)
+	})
+	return queries
+}
+
+// CancelQuery cancels a query that is executing, that was received by this node
+func (m *manager) CancelQuery(queryID string) error {
+	var qrh *queryResultHandler
+	m.resultHandlers.Range(func(_, value any) bool {
+		h := value.(*queryResultHandler)
+		if h.runningQuery.ID == queryID {
+			qrh = h
+			return false
+		}
+		return true
+	})
+	if qrh == nil {
+		return errors.NewTektiteErrorf(errors.ExecuteQueryError, "unknown query '%s'", queryID)
+	}
+	m.failQuery(qrh, errors.NewTektiteErrorf(errors.ExecuteQueryError, "query has been cancelled"))
+	return nil
+}
+
+// failQuery stops the query, cancels it on the nodes it was sent to, and passes the error to the output func of the
+// query
+func (m *manager) failQuery(qrh *queryResultHandler, err error) {
+	qrh.lock.Lock()
+	defer qrh.lock.Unlock()
+	if qrh.stopped {
+		return
+	}
+	qrh.stopped = true
+	m.removeQuery(qrh)
+	m.sendCancelMessages(qrh)
+	if err := qrh.outputFunc(true, 1, nil, err); err != nil {
+		// Ignore - the query has already failed
+	}
+}
+
+// abortQuery stops a query that could not be sent to all the nodes, and cancels it on the nodes that did receive it. It
+// returns false if the query had already stopped.
+func (m *manager) abortQuery(qrh *queryResultHandler) bool {
+	qrh.lock.Lock()
+	defer qrh.lock.Unlock()
+	if qrh.stopped {
+		return false
+	}
+	qrh.stopped = true
+	m.removeQuery(qrh)
+	m.sendCancelMessages(qrh)
+	return true
+}
+
+func (m *manager) removeQuery(qrh *queryResultHandler) {
+	m.resultHandlers.Delete(qrh.execID)
+	if qrh.timer != nil {
+		qrh.timer.Stop()
+	}
+}
+
+func (m *manager) sendCancelMessages(qrh *queryResultHandler) {
+	msg := &clustermsgs.QueryCancelMessage{ExecId: []byte(qrh.execID)}
+	for _, nid := range qrh.nodeIDs {
+		address := m.remotingListenAddresses[nid]
+		common.Go(func() {
+			if err := m.remoting.SendQueryCancel(msg, address); err != nil {
+				// The query will still complete on the node, but its results will be ignored
+				log.Warnf("failed to cancel query on node %s: %v", address, err)
+			}
+		})
+	}
+}
+
+// CancelRemoteQuery stops the query loaders of a query that was cancelled on the node that received it
+func (m *manager) CancelRemoteQuery(msg *clustermsgs.QueryCancelMessage) {
+	rq, ok := m.remoteQueries.Load(string(msg.ExecId))
+	if !ok {
+		// The query has already completed on this node
+		return
+	}
+	rq.(*remoteQuery).cancel()
+}
+
+// sendQueryError sends the error that a query loader failed with to the node that received the query, so the query
+// fails there
+func (m *manager) sendQueryError(ql *queryLoader, err error) {
+	var perr errors.TektiteError
+	if !errors.As(err, &perr) {
+		perr = common.LogInternalError(err)
+	}
+	msg := &clustermsgs.QueryResponse{
+		ExecId:    []byte(ql.execID),
+		ErrorCode: int32(perr.Code),
+		Error:     perr.Msg,
+	}
+	if err := m.remoting.SendQueryResponse(msg, ql.resultAddress); err != nil {
+		log.Errorf("failed to send query error %v", err)
+	}
+}
+
+// remoteQuery tracks the query loaders executing a query on this node, for a query received by another node
+type remoteQuery struct {
+	loaders        []*queryLoader
+	numRunning     atomic.Int64
+	rowsScanned    atomic.Int64
+	maxRowsScanned int64
+}
+
+// addRowsScanned adds to the rows scanned on this node. The query can't have scanned fewer rows in total than on this
+// node, so it is stopped as soon as they exceed the limit, without waiting for the node that received the query to
+// add up the rows scanned on every node.
+func (r *remoteQuery) addRowsScanned(rowsScanned int) error {
+	total := r.rowsScanned.Add(int64(rowsScanned))
+	if r.maxRowsScanned > 0 && total > r.maxRowsScanned {
+		return errors.NewTektiteErrorf(errors.ExecuteQueryError,
+			"query exceeded the maximum of %d rows scanned and has been cancelled", r.maxRowsScanned)
+	}
+	return nil
+}
+
+func (r *remoteQuery) cancel() {
+	for _, ql := range r.loaders {
+		ql.cancelled.Store(true)
+	}
+}
+
+func isSortOperator(oper opers.Operator) bool {
+	_, ok := oper.(*opers.SortOperator)
+	return ok
+}
@@ -2,6 +2,7 @@ package query
 
 import (
 	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/google/uuid"
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/encoding"
@@ -16,26 +17,49 @@ import (
 	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
 	"github.com/spirit-labs/tektite/remoting"
 	"github.com/spirit-labs/tektite/types"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// Manager executes queries. The results of a query are passed to outputFunc as they arrive. If the query fails after it
+// has started executing, for example because it exceeded one of its limits or was cancelled, outputFunc is called with
+// the error, and is not called again. If outputFunc returns an error, the query is cancelled.
 type Manager interface {
 	PrepareQuery(prepareQuery parser.PrepareQueryDesc) error
-	ExecutePreparedQuery(queryName string, args []any,
-		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error)
-	ExecutePreparedQueryWithHighestVersion(queryName string, args []any, highestVersion int64,
-		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error)
-	ExecuteQueryDirect(tsl string, query parser.QueryDesc,
-		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) error
+	ExecutePreparedQuery(queryName string, args []any, limits Limits,
+		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error)
+	ExecutePreparedQueryWithHighestVersion(queryName string, args []any, highestVersion int64, page opers.PageStart,
+		limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error)
+	ExecuteQueryDirect(tsl string, query parser.QueryDesc, limits Limits,
+		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error
+	ExecuteQueryDirectWithArgs(tsl string, prepareQuery parser.PrepareQueryDesc, args []any, limits Limits,
+		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error
+	ExecuteQueryDirectWithHighestVersion(tsl string, query parser.QueryDesc, highestVersion int64, limits Limits,
+		outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error
+	ExecuteQueryDirectIfChanged(tsl string, query parser.QueryDesc, changedAfterVersion int64, highestVersion int64,
+		limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error)
+	ExecuteQueryDirectByKey(tsl string, query parser.QueryDesc, highestVersion int64, limits Limits) (*KeyedResults, bool, error)
+	ExecuteQueryDirectForChangedKeys(query parser.QueryDesc, changedAfterVersion int64,
+		highestVersion int64) (*KeyedResults, bool, error)
+	RunningQueries() []RunningQuery
+	CancelQuery(queryID string) error
 	SetLastCompletedVersion(version int64)
 	ExecuteRemoteQuery(msg *clustermsgs.QueryMessage) error
 	ReceiveQueryResult(msg *clustermsgs.QueryResponse)
+	CancelRemoteQuery(msg *clustermsgs.QueryCancelMessage)
 	GetPreparedQueryParamSchema(preparedQueryName string) *evbatch.EventSchema
+	GetPreparedQueryLimit(preparedQueryName string) int
+	GetPreparedQueryNextPage(preparedQueryName string, page opers.PageStart, batch *evbatch.Batch) *opers.PageStart
+	GetPreparedQueryResultSchema(preparedQueryName string) *evbatch.EventSchema
+	GetQueryResultSchema(query parser.QueryDesc, params []parser.PreparedStatementParam) (*evbatch.EventSchema, error)
 	SetClusterMessageHandlers(remotingServer remoting.Server, vbHandler *remoting.TeeBlockingClusterMessageHandler)
 	GetLastCompletedVersion() int
 	GetLastFlushedVersion() int
+	RegisterCompletedVersionListener(listenerName string, listener func(version int64))
+	UnregisterCompletedVersionListener(listenerName string)
 	Activate()
 	Start() error
 	Stop() error
@@ -57,9 +81,19 @@ type manager struct {
 	expressionFactory          *expr.ExpressionFactory
 	parser                     *parser.Parser
 	maxBatchRows               int
+	defaultLimits              Limits
+	remoteQueries              sync.Map
 	lastCompletedVersion       int64
 	lastFlushedVersion         int64
 	nodeID                     int
+	versionIndex               versionIndex
+	versionListenersLock       sync.Mutex
+	versionListeners           map[string]func(version int64)
+}
+
+// versionIndex maps a point in time to the last version that was completed at that time
+type versionIndex interface {
+	GetVersionForTime(timeMs int64) (int64, error)
 }
 
 type iteratorProvider interface {
@@ -72,7 +106,20 @@ type QInfo struct {
 	RemoteOperators    []opers.Operator
 	ParamSchema        *evbatch.EventSchema
 	RemoteResultSchema *evbatch.EventSchema
+	ResultSchema       *evbatch.EventSchema
 	FullKeyLookup      bool
+	// MultiKeyLookup is set when each row of the args is the key of a row to get, rather than the query having a
+	// single row of args. The query gets the rows with the keys in the partitions it is executed on.
+	MultiKeyLookup bool
+	Limit          int
+	BroadcastJoins []*JoinOperator
+	// AsOfTime and AsOfVersion are set when the query reads the table as it was in the past
+	AsOfTime    *time.Time
+	AsOfVersion *int64
+	// VersionsRetention is how long the table read as of a point in the past keeps older versions for
+	VersionsRetention time.Duration
+	// TableNames are the names of the tables read by a prepared query
+	TableNames []string
 }
 
 func createEmptyBatch(schema *evbatch.EventSchema) *evbatch.Batch {
@@ -93,13 +140,16 @@ type StreamInfoProvider interface {
 type queryRemoting interface {
 	SendQueryMessageAsync(completionFunc func(remoting.ClusterMessage, error), request *clustermsgs.QueryMessage, serverAddress string)
 	SendQueryResponse(request *clustermsgs.QueryResponse, serverAddress string) error
+	SendQueryCancel(request *clustermsgs.QueryCancelMessage, serverAddress string) error
+	SendQueryChangedMessageAsync(completionFunc func(remoting.ClusterMessage, error),
+		request *clustermsgs.QueryChangedMessage, serverAddress string)
 	Close()
 }
 
 func NewManager(partitionMapper proc.PartitionMapper, clustVersionProvider clusterVersionProvider, nodeID int,
 	streamInfoProvider StreamInfoProvider, storeIterProvider iteratorProvider, streamMetaIterProvider iteratorProvider,
-	remoting queryRemoting, remotingListenAddresses []string, maxBatchRows int, expressionFactory *expr.ExpressionFactory,
-	parser *parser.Parser) Manager {
+	remoting queryRemoting, remotingListenAddresses []string, maxBatchRows int, defaultLimits Limits,
+	expressionFactory *expr.ExpressionFactory, parser *parser.Parser, versionIndex versionIndex) Manager {
 	return &manager{
 		preparedQueries:            map[string]*QInfo{},
 		partitionMapper:            partitionMapper,
@@ -111,10 +161,13 @@ func NewManager(partitionMapper proc.PartitionMapper, clustVersionProvider clust
 		remotingListenAddresses:    remotingListenAddresses,
 		remotingAddress:            remotingListenAddresses[nodeID],
 		maxBatchRows:               maxBatchRows,
+		defaultLimits:              defaultLimits,
 		lastCompletedVersion:       -1,
 		nodeID:                     nodeID,
 		expressionFactory:          expressionFactory,
 		parser:                     parser,
+		versionIndex:               versionIndex,
+		versionListeners:           map[string]func(version int64){},
 	}
 }
 
@@ -147,6 +200,25 @@ version for the version in the query message by the time it arrives on the remot
 
 func (m *manager) SetLastCompletedVersion(version int64) {
 	atomic.StoreInt64(&m.lastCompletedVersion, version)
+	m.versionListenersLock.Lock()
+	defer m.versionListenersLock.Unlock()
+	for _, listener := range m.versionListeners {
+		listener(version)
+	}
+}
+
+// RegisterCompletedVersionListener registers a listener which is called each time the last completed version changes.
+// The listener is called with the new version, and must not block.
+func (m *manager) RegisterCompletedVersionListener(listenerName string, listener func(version int64)) {
+	m.versionListenersLock.Lock()
+	defer m.versionListenersLock.Unlock()
+	m.versionListeners[listenerName] = listener
+}
+
+func (m *manager) UnregisterCompletedVersionListener(listenerName string) {
+	m.versionListenersLock.Lock()
+	defer m.versionListenersLock.Unlock()
+	delete(m.versionListeners, listenerName)
 }
 
 func (m *manager) SetLastFlushedVersion(version int64) {
@@ -164,6 +236,8 @@ func (m *manager) GetLastFlushedVersion() int {
 func (m *manager) SetClusterMessageHandlers(remotingServer remoting.Server, vbHandler *remoting.TeeBlockingClusterMessageHandler) {
 	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageQueryMessage, &queryMessageHandler{m: m})
 	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageQueryResponse, &queryResponseHandler{m: m})
+	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageQueryCancelMessage, &queryCancelHandler{m: m})
+	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageQueryChangedMessage, &queryChangedHandler{m: m})
 	vbHandler.Handlers = append(vbHandler.Handlers, &versionBroadcastHandler{m: m})
 }
 
@@ -186,6 +260,29 @@ func (q *queryResponseHandler) HandleMessage(messageHolder remoting.MessageHolde
 	return nil, nil
 }
 
+type queryCancelHandler struct {
+	m *manager
+}
+
+func (q *queryCancelHandler) HandleMessage(messageHolder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	cancelMessage := messageHolder.Message.(*clustermsgs.QueryCancelMessage)
+	q.m.CancelRemoteQuery(cancelMessage)
+	return nil, nil
+}
+
+type queryChangedHandler struct {
+	m *manager
+}
+
+func (q *queryChangedHandler) HandleMessage(messageHolder remoting.MessageHolder) (remoting.ClusterMessage, error) {
+	changedMessage := messageHolder.Message.(*clustermsgs.QueryChangedMessage)
+	resp, err := q.m.tablesChanged(changedMessage)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 type versionBroadcastHandler struct {
 	m *manager
 }
@@ -207,6 +304,52 @@ func (m *manager) GetPreparedQueryParamSchema(preparedQueryName string) *evbatch
 	return pqi.ParamSchema
 }
 
+// GetPreparedQueryLimit returns the maximum number of rows returned by the prepared query, or 0 if it has no limit
+func (m *manager) GetPreparedQueryLimit(preparedQueryName string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pqi, exists := m.preparedQueries[preparedQueryName]
+	if !exists {
+		return 0
+	}
+	return pqi.Limit
+}
+
+// GetPreparedQueryNextPage returns where the page of results after the specified page of the prepared query starts,
+// given the rows of the page, or nil if there are no more results
+func (m *manager) GetPreparedQueryNextPage(preparedQueryName string, page opers.PageStart,
+	batch *evbatch.Batch) *opers.PageStart {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pqi, exists := m.preparedQueries[preparedQueryName]
+	if !exists || pqi.Limit == 0 {
+		return nil
+	}
+	return pqi.LocalOperators[len(pqi.LocalOperators)-1].(*opers.LimitOperator).NextPage(page, batch)
+}
+
+// GetPreparedQueryResultSchema returns the schema of the results of the prepared query, or nil if it does not exist
+func (m *manager) GetPreparedQueryResultSchema(preparedQueryName string) *evbatch.EventSchema {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pqi, exists := m.preparedQueries[preparedQueryName]
+	if !exists {
+		return nil
+	}
+	return pqi.ResultSchema
+}
+
+// GetQueryResultSchema returns the schema of the results of the query with the params, without executing it
+func (m *manager) GetQueryResultSchema(query parser.QueryDesc, params []parser.PreparedStatementParam) (*evbatch.EventSchema, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	info, err := m.createQueryInfo(query.OperatorDescs, params)
+	if err != nil {
+		return nil, err
+	}
+	return info.ResultSchema, nil
+}
+
 func (m *manager) PrepareQuery(prepareQuery parser.PrepareQueryDesc) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -218,16 +361,29 @@ func (m *manager) PrepareQuery(prepareQuery parser.PrepareQueryDesc) error {
 	if err != nil {
 		return err
 	}
+	pqi.TableNames = queryTableNames(*prepareQuery.Query)
 	m.preparedQueries[prepareQuery.QueryName] = pqi
 	return nil
 }
 
 func (m *manager) createQueryInfo(opDescs []parser.Parseable, params []parser.PreparedStatementParam) (*QInfo, error) {
 	var operators []opers.Operator
+	var localOperators []opers.Operator
 	var prevOperator opers.Operator
 	var streamInfo *opers.StreamInfo
+	// slabInfo is the slab that the query reads from - this is the slab of a secondary index if one is used
+	var slabInfo *opers.SlabInfo
 	var isFullKeyLookup bool
-	hasSort := false
+	var sortDesc *parser.SortDesc
+	var limitDesc *parser.LimitDesc
+	var broadcastJoins []*JoinOperator
+	var asOfTime *time.Time
+	var asOfVersion *int64
+	var versionsRetention time.Duration
+	// residualFilters holds, by operator index, what remains of a filter after the conditions that reference
+	// prepared statement params have been applied by the key lookup of the preceding scan
+	residualFilters := map[int]parser.ExprDesc{}
+	hasAggregate := false
 	var paramSchema *evbatch.EventSchema
 	lp := len(params)
 	if lp > 0 {
@@ -239,7 +395,6 @@ func (m *manager) createQueryInfo(opDescs []parser.Parseable, params []parser.Pr
 		}
 		paramSchema = evbatch.NewEventSchema(pNames, pTypes)
 	}
-loop:
 	for i, opDesc := range opDescs {
 		var oper opers.Operator
 		var err error
@@ -250,8 +405,24 @@ loop:
 				(streamInfo.UserSlab.Type != opers.SlabTypeUserTable && streamInfo.UserSlab.Type != opers.SlabTypeQueryableInternal) {
 				return nil, queryErrorAtTokenf(desc.TableName, desc, "unknown table '%s'", desc.TableName)
 			}
-			isFullKeyLookup = len(desc.KeyExprs) == len(streamInfo.UserSlab.KeyColIndexes)
-			colExprs, err := m.createAndValidateLookupParamExprs(paramSchema, desc.KeyExprs, streamInfo.UserSlab)
+			slabInfo = streamInfo.UserSlab
+			if desc.IndexCols != nil {
+				slabInfo = findIndexSlab(streamInfo.UserSlab, desc.IndexCols)
+				if slabInfo == nil {
+					return nil, queryErrorAtTokenf("index", desc, "table '%s' has no index on columns (%s)",
+						desc.TableName, strings.Join(desc.IndexCols, ", "))
+				}
+				if len(desc.KeyExprs) != len(desc.IndexCols) {
+					return nil, queryErrorAtTokenf("index", desc,
+						"number of key elements specified (%d) does not match number of index columns (%d)",
+						len(desc.KeyExprs), len(desc.IndexCols))
+				}
+				// An index entry can be in any partition, so we must fan out
+				isFullKeyLookup = false
+			} else {
+				isFullKeyLookup = len(desc.KeyExprs) == len(slabInfo.KeyColIndexes)
+			}
+			colExprs, err := m.createAndValidateLookupParamExprs(paramSchema, desc.KeyExprs, slabInfo)
 			if err != nil {
 				return nil, err
 			}
@@ -261,8 +432,8 @@ loop:
 			} else {
 				iterProvider = m.storeIteratorProvider
 			}
-			oper = NewGetOperator(false, colExprs, nil, true, false, streamInfo.UserSlab.SlabID,
-				streamInfo.UserSlab.KeyColIndexes, streamInfo.UserSlab.Schema, iterProvider, m.nodeID)
+			oper = NewGetOperator(false, colExprs, nil, true, false, slabInfo.SlabID,
+				slabInfo.KeyColIndexes, slabInfo.Schema, iterProvider, m.nodeID)
 		case *parser.ScanDesc:
 			streamInfo = m.streamInfoProvider.GetStream(desc.TableName)
 			isFullKeyLookup = false
@@ -292,68 +463,214 @@ loop:
 					}
 				}
 			}
+			slabInfo = streamInfo.UserSlab
+			if desc.AsOfTime != nil || desc.AsOfVersion != nil {
+				if slabInfo.VersionsRetention == 0 {
+					return nil, queryErrorAtTokenf("as_of", desc,
+						"table '%s' cannot be queried with as_of as it does not keep older versions - it must be created with versions_retention",
+						desc.TableName)
+				}
+				asOfTime, asOfVersion = desc.AsOfTime, desc.AsOfVersion
+				versionsRetention = slabInfo.VersionsRetention
+			}
 			var iterProvider iteratorProvider
 			if streamInfo.StreamMeta {
 				iterProvider = m.streamMetaIteratorProvider
 			} else {
 				iterProvider = m.storeIteratorProvider
 			}
-			oper = NewGetOperator(true, rangeStartExprs, rangeEndExprs, desc.FromIncl,
-				desc.ToIncl, streamInfo.UserSlab.SlabID,
+			fromIncl, toIncl := desc.FromIncl, desc.ToIncl
+			keyFromConditions := false
+			if desc.All && !streamInfo.StreamMeta && i < len(opDescs)-1 {
+				if filterDesc, ok := opDescs[i+1].(*parser.FilterDesc); ok {
+					// The filter is still applied to the results of the scan, so we only need to choose which rows
+					// to load
+					kr := m.keyRangeForFilter(streamInfo.UserSlab, filterDesc.Expr, paramSchema)
+					if kr == nil || kr.numEqualities == 0 {
+						// If the filter matches the leading columns of an index we can get the rows from the index
+						// instead of scanning the whole table
+						indexSlab, keyExprs, conditions := m.chooseIndexForFilter(streamInfo.UserSlab, filterDesc.Expr,
+							paramSchema)
+						if indexSlab != nil {
+							slabInfo = indexSlab
+							residualFilters[i+1] = residualFilter(filterDesc.Expr, conditions)
+							getOper := NewGetOperator(false, keyExprs, nil, true, false, slabInfo.SlabID,
+								slabInfo.KeyColIndexes, slabInfo.Schema, iterProvider, m.nodeID)
+							getOper.SetKeyFromConditions()
+							oper = getOper
+							break
+						}
+					}
+					if kr != nil {
+						// The filter restricts the key columns, so we only scan the matching range of keys
+						rangeStartExprs, rangeEndExprs = kr.startExprs, kr.endExprs
+						fromIncl, toIncl = kr.startInclusive, kr.endInclusive
+						residualFilters[i+1] = residualFilter(filterDesc.Expr, kr.conditions)
+						keyFromConditions = true
+					}
+				}
+			}
+			getOper := NewGetOperator(true, rangeStartExprs, rangeEndExprs, fromIncl,
+				toIncl, streamInfo.UserSlab.SlabID,
 				streamInfo.UserSlab.KeyColIndexes, streamInfo.UserSlab.Schema, iterProvider, m.nodeID)
+			if keyFromConditions {
+				getOper.SetKeyFromConditions()
+			}
+			oper = getOper
 		case *parser.FilterDesc:
-			oper, err = opers.NewFilterOperator(prevOperator.OutSchema(), desc.Expr, m.expressionFactory)
+			filterExpr := desc.Expr
+			if residual, ok := residualFilters[i]; ok {
+				if residual == nil {
+					// All the conditions of the filter are applied by the key lookup
+					continue
+				}
+				filterExpr = residual
+			}
+			if param := findParam(filterExpr); param != nil {
+				return nil, queryErrorAtTokenf(param.IdentifierName, desc,
+					"prepared statement parameters can only be used in a filter directly after a scan, in conditions on the key columns or indexed columns of the table")
+			}
+			filterOper, err := opers.NewFilterOperator(prevOperator.OutSchema(), filterExpr, m.expressionFactory)
+			if err != nil {
+				return nil, err
+			}
+			if getOper, ok := prevOperator.(*GetOperator); ok {
+				// Filters directly after the get are applied as the rows are loaded from storage
+				schema := getOper.OutSchema().EventSchema
+				getOper.PushDownFilter(filterOper.Expression(), referencedColumns(schema, filterExpr))
+				continue
+			}
+			oper = filterOper
 		case *parser.ProjectDesc:
 			// If the query specifies cols then we don't include offset and event_time
 			oper, err = opers.NewProjectOperator(prevOperator.OutSchema(), desc.Expressions, false, m.expressionFactory)
+		case *parser.UnnestDesc:
+			oper, err = opers.NewUnnestOperator(prevOperator.OutSchema(), desc, m.expressionFactory)
+		case *parser.DecodeJSONDesc:
+			oper, err = opers.NewDecodeJSONOperator(prevOperator.OutSchema(), desc)
+		case *parser.AggregateDesc:
+			if hasAggregate {
+				return nil, queryErrorAtTokenf("", desc, "only one aggregate is allowed in a query")
+			}
+			if err := validateQueryAggregate(desc); err != nil {
+				return nil, err
+			}
+			partialOper, mergeOper, err := opers.NewQueryAggregateOperators(prevOperator.OutSchema(), desc,
+				expectedLastBatches(streamInfo, isFullKeyLookup), m.expressionFactory)
+			if err != nil {
+				return nil, err
+			}
+			// The partial aggregate runs remotely, the merge runs locally along with any following operators
+			operators = append(operators, partialOper)
+			localOperators = append(localOperators, mergeOper)
+			prevOperator = mergeOper
+			hasAggregate = true
+			continue
+		case *parser.QueryJoinDesc:
+			if hasAggregate {
+				return nil, queryErrorAtTokenf("", desc, "join must come before any aggregate in a query")
+			}
+			joinOper, err := m.newJoinOperator(prevOperator.OutSchema(), desc, len(broadcastJoins))
+			if err != nil {
+				return nil, err
+			}
+			if joinOper.broadcast {
+				broadcastJoins = append(broadcastJoins, joinOper)
+			}
+			oper = joinOper
 		case *parser.SortDesc:
+			if i != len(opDescs)-1 && (i != len(opDescs)-2 || !isLimitDesc(opDescs[i+1])) {
+				return nil, queryErrorAtTokenf("", desc, "sort must be the last operator in a query, or be followed by a limit")
+			}
+			// We add this later
+			sortDesc = desc
+			continue
+		case *parser.LimitDesc:
 			if i != len(opDescs)-1 {
-				return nil, queryErrorAtTokenf("", desc, "sort must be the last operator in a query")
+				return nil, queryErrorAtTokenf("", desc, "limit must be the last operator in a query")
 			}
 			// We add this later
-			hasSort = true
-			break loop // must be the last one
+			limitDesc = desc
+			continue
 		}
 		if err != nil {
 			return nil, err
 		}
-		operators = append(operators, oper)
+		if hasAggregate {
+			localOperators = append(localOperators, oper)
+		} else {
+			operators = append(operators, oper)
+		}
 		prevOperator = oper
 	}
 
-	var sortOper opers.Operator
-	if hasSort {
-		// We need to add the sort after the remove offset operator, if any
-		expectedLastBatches := 1
-		if !isFullKeyLookup {
-			expectedLastBatches = streamInfo.UserSlab.Schema.PartitionScheme.Partitions
+	if getOper, ok := operators[0].(*GetOperator); ok {
+		// Only decode the columns that are used by the rest of the query
+		if required := requiredColumns(getOper.OutSchema().EventSchema, opDescs[1:]); required != nil {
+			getOper.PushDownRequiredCols(required)
 		}
-		var err error
-		sortOper, err = opers.NewSortOperator(prevOperator.OutSchema(), expectedLastBatches,
-			opDescs[len(opDescs)-1].(*parser.SortDesc).SortExprs, false, m.expressionFactory)
+	}
+
+	if sortDesc != nil {
+		// The sort is run locally after results are gathered from remote managers
+		numLastBatches := 1
+		if !hasAggregate {
+			numLastBatches = expectedLastBatches(streamInfo, isFullKeyLookup)
+		}
+		sortOper, err := opers.NewSortOperator(prevOperator.OutSchema(), numLastBatches, sortDesc.SortExprs, false,
+			m.expressionFactory)
 		if err != nil {
 			return nil, err
 		}
-		operators = append(operators, sortOper)
+		localOperators = append(localOperators, sortOper)
 		prevOperator = sortOper
 	}
 
+	limit := 0
+	if limitDesc != nil {
+		// The page is taken locally after results are gathered from remote managers. Unless the results have been
+		// sorted we order them so that consecutive pages are consistent.
+		numLastBatches := 1
+		var orderCols []int
+		numKeyCols := 0
+		if sortDesc == nil {
+			schema := prevOperator.OutSchema().EventSchema
+			var keyCols []int
+			hasKeyCols := false
+			if !hasAggregate {
+				keyCols, hasKeyCols = keyColsInSchema(operators)
+			}
+			// Results are ordered by key first, then by the rest of the columns to break any ties
+			orderCols = keyCols
+			for colIndex := range schema.ColumnNames() {
+				orderCols = append(orderCols, colIndex)
+			}
+			if !hasAggregate {
+				numLastBatches = expectedLastBatches(streamInfo, isFullKeyLookup)
+				if hasKeyCols {
+					// Each partition is scanned in key order, and the page is ordered by key, so we can push the limit
+					// down to the remote nodes, and they can stop scanning once they have sent enough rows. Later
+					// pages resume the scan from the key of the last row of the previous page.
+					operators = append(operators, opers.NewPartialLimitOperator(prevOperator.OutSchema(), limitDesc,
+						orderCols))
+					numKeyCols = len(keyCols)
+				}
+			}
+		}
+		limitOper := opers.NewLimitOperator(prevOperator.OutSchema(), limitDesc, numLastBatches, orderCols, numKeyCols)
+		localOperators = append(localOperators, limitOper)
+		prevOperator = limitOper
+		limit = limitDesc.Limit
+	}
+
+	// Note that local operators are not linked together - the queryResultHandler passes the results of each local
+	// operator to the next
 	for i, oper := range operators {
 		if i != len(operators)-1 {
 			oper.AddDownStreamOperator(operators[i+1])
 		}
 	}
-	var remoteOperators []opers.Operator
-	var localOperators []opers.Operator
-	if !hasSort {
-		remoteOperators = operators
-	} else {
-		// Only the sort operator is run locally after results are gathered from remote managers, so we remove
-		// the sort operator from the remote operators
-		remoteOperators = operators[:len(operators)-1]
-		remoteOperators[len(remoteOperators)-1].RemoveDownStreamOperator(sortOper)
-		localOperators = []opers.Operator{sortOper}
-	}
+	remoteOperators := operators
 	// Insert a networkResultsOperator to send the results over the network
 	nro := &networkResultsOperator{
 		remoting: m.remoting,
@@ -362,15 +679,94 @@ loop:
 	lastOper.AddDownStreamOperator(nro)
 	remoteOperators = append(remoteOperators, nro)
 	return &QInfo{
-		SlabInfo:           streamInfo.UserSlab,
+		SlabInfo:           slabInfo,
 		LocalOperators:     localOperators,
 		RemoteOperators:    remoteOperators,
 		RemoteResultSchema: remoteOperators[len(remoteOperators)-2].OutSchema().EventSchema,
+		ResultSchema:       prevOperator.OutSchema().EventSchema,
 		FullKeyLookup:      isFullKeyLookup,
 		ParamSchema:        paramSchema,
+		Limit:              limit,
+		BroadcastJoins:     broadcastJoins,
+		AsOfTime:           asOfTime,
+		AsOfVersion:        asOfVersion,
+		VersionsRetention:  versionsRetention,
 	}, nil
 }
 
+// findIndexSlab returns the slab of the index on the table whose leading columns are indexCols, or nil if there is no
+// such index
+func findIndexSlab(tableSlab *opers.SlabInfo, indexCols []string) *opers.SlabInfo {
+	for _, index := range tableSlab.Indexes {
+		if len(index.IndexCols) >= len(indexCols) && slices.Equal(index.IndexCols[:len(indexCols)], indexCols) {
+			return index.Slab
+		}
+	}
+	return nil
+}
+
+func isLimitDesc(opDesc parser.Parseable) bool {
+	_, ok := opDesc.(*parser.LimitDesc)
+	return ok
+}
+
+// keyColsInSchema returns the indexes in the output of the operators of the key columns of the slab read by the first
+// operator. Each key column is traced through the operators, so it is only found if it is passed through unchanged -
+// a column computed by a project is not a key column even if it has the same name. False is returned if any of the key
+// columns are not in the output.
+func keyColsInSchema(operators []opers.Operator) ([]int, bool) {
+	getOper, ok := operators[0].(*GetOperator)
+	if !ok {
+		return nil, false
+	}
+	keyCols := slices.Clone(getOper.keyColIndexes)
+	for _, oper := range operators[1:] {
+		for i, colIndex := range keyCols {
+			switch op := oper.(type) {
+			case *opers.FilterOperator:
+				// Columns are unchanged
+			case *opers.ProjectOperator:
+				keyCols[i], ok = op.OutputColumn(colIndex)
+				if !ok {
+					return nil, false
+				}
+			default:
+				return nil, false
+			}
+		}
+	}
+	return keyCols, true
+}
+
+// expectedLastBatches returns the number of partitions that will send results for the query
+func expectedLastBatches(streamInfo *opers.StreamInfo, isFullKeyLookup bool) int {
+	if isFullKeyLookup {
+		return 1
+	}
+	return streamInfo.UserSlab.Schema.PartitionScheme.Partitions
+}
+
+func validateQueryAggregate(desc *parser.AggregateDesc) error {
+	var argName string
+	if desc.Size != nil {
+		argName = "size"
+	} else if desc.Hop != nil {
+		argName = "hop"
+	} else if desc.Lateness != nil {
+		argName = "lateness"
+	} else if desc.Store != nil {
+		argName = "store"
+	} else if desc.IncludeWindowCols != nil {
+		argName = "window_cols"
+	} else if desc.Retention != nil {
+		argName = "retention"
+	}
+	if argName != "" {
+		return queryErrorAtTokenf(argName, desc, "'%s' is not supported for an aggregate in a query", argName)
+	}
+	return nil
+}
+
 func (m *manager) createAndValidateLookupParamExprs(schema *evbatch.EventSchema, exprDescs []parser.ExprDesc,
 	slabInfo *opers.SlabInfo) ([]expr.Expression, error) {
 	var colExprs []expr.Expression
@@ -426,6 +822,19 @@ func (m *manager) calcNodePartitions(info *QInfo, args *evbatch.Batch) (map[int]
 		}
 		return nodePartitions, partitionScheme.Partitions, nil
 	}
+	partID, nodeID, err := m.keyPartition(info, args, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return map[int][]int{
+		nodeID: {partID},
+	}, 1, nil
+}
+
+// keyPartition returns the partition, and the node it is on, of the row with the key in the args at rowIndex, for a
+// query that specifies values for all the key cols
+func (m *manager) keyPartition(info *QInfo, args *evbatch.Batch, rowIndex int) (int, int, error) {
+	partitionScheme := info.SlabInfo.Schema.PartitionScheme
 	lo := info.RemoteOperators[0].(*GetOperator)
 	var partitionKey []byte
 	var err error
@@ -433,30 +842,27 @@ func (m *manager) calcNodePartitions(info *QInfo, args *evbatch.Batch) (map[int]
 		// If the slab is on a stream which receives data from a *kafka in* or *bridge from* operator
 		// then the data has been partitioned by the Kafka key, in this case RawPartitionKey is true and we choose
 		// the partition based on a simple hash of the specified lookup key value.
-		partitionKey, err = lo.CreateRawPartitionKey(args)
+		partitionKey, err = lo.CreateRawPartitionKey(args, rowIndex)
 	} else {
 		// Otherwise, if the slab is after a partition operator, then RawPartitionKey will be set to false, as the data
 		// has been re-partitioned based on the keys specified in the partition operator. This can be a composite key
 		// and allows for nulls, in this case we need to choose the partition based on that key, so we have to generate
 		// it in the same way it was generated when hashing in the partition operator.
-		partitionKey, err = lo.CreateRangeStartKey(args)
+		partitionKey, err = lo.CreateRangeStartKey(args, rowIndex)
 	}
 	if err != nil {
-		return nil, 0, err
+		return 0, 0, err
 	}
-
 	hash := common.DefaultHash(partitionKey)
 	partID := int(common.CalcPartition(hash, partitionScheme.Partitions))
 	nodeID := m.partitionMapper.NodeForPartition(partID, partitionScheme.MappingID, partitionScheme.Partitions)
-	return map[int][]int{
-		nodeID: {partID},
-	}, 1, nil
+	return partID, nodeID, nil
 }
 
-func (m *manager) ExecuteQueryWithRetry(queryName string, args []any,
-	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (m *manager) ExecuteQueryWithRetry(queryName string, args []any, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	for {
-		numParts, err := m.ExecutePreparedQuery(queryName, args, outputFunc)
+		numParts, err := m.ExecutePreparedQuery(queryName, args, limits, outputFunc)
 		if err == nil {
 			return numParts, nil
 		}
@@ -469,98 +875,387 @@ func (m *manager) ExecuteQueryWithRetry(queryName string, args []any,
 	}
 }
 
-func (m *manager) ExecuteQueryDirect(tsl string, query parser.QueryDesc,
-	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) error {
+func (m *manager) ExecuteQueryDirect(tsl string, query parser.QueryDesc, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	highestVersion := atomic.LoadInt64(&m.lastCompletedVersion)
+	return m.ExecuteQueryDirectWithHighestVersion(tsl, query, highestVersion, limits, outputFunc)
+}
+
+// ExecuteQueryDirectWithHighestVersion executes the query against the data as of highestVersion, which must not be
+// greater than the last completed version. It fails if highestVersion is older than the tables keep versions for.
+func (m *manager) ExecuteQueryDirectWithHighestVersion(tsl string, query parser.QueryDesc, highestVersion int64,
+	limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
 	m.lock.RLock()
-	defer m.lock.RUnlock()
 	info, err := m.createQueryInfo(query.OperatorDescs, nil)
+	m.lock.RUnlock()
 	if err != nil {
 		return err
 	}
+	if err := m.checkVersionRetained(queryTableNames(query), highestVersion); err != nil {
+		return err
+	}
+	// We don't hold the lock while executing, as a query with a join executes other queries, and these are handled by
+	// this node too
+	_, err = m.executeQuery(info, "", tsl, nil, highestVersion, opers.PageStart{}, limits, outputFunc)
+	return err
+}
+
+// checkVersionRetained returns an error if the tables might no longer have the rows as of the version. Older versions of
+// the rows are removed by compaction once they have been flushed, unless the table keeps them for its versions
+// retention.
+func (m *manager) checkVersionRetained(tableNames []string, version int64) error {
+	lastFlushedVersion := atomic.LoadInt64(&m.lastFlushedVersion)
+	if version == -1 || version >= lastFlushedVersion {
+		// A version of -1 means no version has completed, so there is no data
+		return nil
+	}
+	for _, tableName := range tableNames {
+		streamInfo := m.streamInfoProvider.GetStream(tableName)
+		if streamInfo == nil || streamInfo.UserSlab == nil {
+			// The query info has already been created, so the table exists
+			continue
+		}
+		retention := streamInfo.UserSlab.VersionsRetention
+		if retention == 0 {
+			return errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"cannot query table '%s' as of version %d - it does not keep older versions, and the oldest version it can be queried as of is %d",
+				tableName, version, lastFlushedVersion)
+		}
+		oldestVersion, err := m.versionIndex.GetVersionForTime(time.Now().Add(-retention).UnixMilli())
+		if err != nil {
+			return err
+		}
+		if version < oldestVersion {
+			return errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"cannot query table '%s' as of version %d - it is before the versions retention of the table",
+				tableName, version)
+		}
+	}
+	return nil
+}
+
+// ExecuteQueryDirectIfChanged executes the query against the data as of highestVersion, but only if any of the tables
+// it reads may have been written to after changedAfterVersion. It returns whether the query was executed.
+func (m *manager) ExecuteQueryDirectIfChanged(tsl string, query parser.QueryDesc, changedAfterVersion int64,
+	highestVersion int64, limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error) {
+	m.lock.RLock()
+	info, err := m.createQueryInfo(query.OperatorDescs, nil)
+	m.lock.RUnlock()
+	if err != nil {
+		return false, err
+	}
+	changed, err := m.queryChanged(query, changedAfterVersion)
+	if err != nil || !changed {
+		return false, err
+	}
+	_, err = m.executeQuery(info, "", tsl, nil, highestVersion, opers.PageStart{}, limits, outputFunc)
+	return true, err
+}
+
+// queryChanged asks each node with partitions of the tables read by the query whether any of them may have been
+// written to after the version. This is much cheaper than executing the query, as no rows are read.
+func (m *manager) queryChanged(query parser.QueryDesc, changedAfterVersion int64) (bool, error) {
+	responses, ok, err := m.sendQueryChanged(queryTableNames(query), changedAfterVersion, false)
+	if err != nil || !ok {
+		return !ok, err
+	}
+	for _, resp := range responses {
+		if resp.Changed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// changedKeys asks each node with partitions of the table for the keys of the rows it may have written after the
+// version. It returns false if any of the nodes does not know them.
+func (m *manager) changedKeys(tableName string, changedAfterVersion int64) ([][]byte, bool, error) {
+	responses, ok, err := m.sendQueryChanged([]string{tableName}, changedAfterVersion, true)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var keys [][]byte
+	for _, resp := range responses {
+		if resp.KeysUnknown {
+			return nil, false, nil
+		}
+		// A row is only written on the node with its partition, so the keys from each node are distinct
+		keys = append(keys, resp.Keys...)
+	}
+	return keys, true, nil
+}
+
+// sendQueryChanged sends a message to each node with partitions of the tables, to find out what has been written to them
+// after the version, and returns the responses. It returns false if the tables are not known.
+func (m *manager) sendQueryChanged(tableNames []string, changedAfterVersion int64,
+	withKeys bool) ([]*clustermsgs.QueryChangedResponse, bool, error) {
+	nodeIDs := map[int]struct{}{}
+	for _, tableName := range tableNames {
+		streamInfo := m.streamInfoProvider.GetStream(tableName)
+		if streamInfo == nil || streamInfo.UserSlab == nil {
+			return nil, false, nil
+		}
+		partitionScheme := streamInfo.UserSlab.Schema.PartitionScheme
+		nodePartitions, err := m.partitionMapper.NodePartitions(partitionScheme.MappingID, partitionScheme.Partitions)
+		if err != nil {
+			return nil, false, err
+		}
+		for nid := range nodePartitions {
+			nodeIDs[nid] = struct{}{}
+		}
+	}
+	if len(nodeIDs) == 0 {
+		return nil, false, nil
+	}
+	msg := &clustermsgs.QueryChangedMessage{
+		TableNames:          tableNames,
+		ChangedAfterVersion: changedAfterVersion,
+		ClusterVersion:      uint64(m.clustVersionProvider.ClusterVersion()),
+		WithKeys:            withKeys,
+	}
+	var lock sync.Mutex
+	var responses []*clustermsgs.QueryChangedResponse
+	ch := make(chan error, 1)
+	cf := common.NewCountDownFuture(len(nodeIDs), func(err error) {
+		ch <- err
+	})
+	for nid := range nodeIDs {
+		m.remoting.SendQueryChangedMessageAsync(func(resp remoting.ClusterMessage, err error) {
+			if err == nil {
+				lock.Lock()
+				responses = append(responses, resp.(*clustermsgs.QueryChangedResponse))
+				lock.Unlock()
+			}
+			cf.CountDown(remoting.MaybeConvertError(err))
+		}, msg, m.remotingListenAddresses[nid])
+	}
+	if err := <-ch; err != nil {
+		return nil, false, err
+	}
+	return responses, true, nil
+}
+
+func queryTableNames(query parser.QueryDesc) []string {
+	var tableNames []string
+	for _, desc := range query.OperatorDescs {
+		switch op := desc.(type) {
+		case *parser.ScanDesc:
+			tableNames = append(tableNames, op.TableName)
+		case *parser.GetDesc:
+			tableNames = append(tableNames, op.TableName)
+		case *parser.QueryJoinDesc:
+			tableNames = append(tableNames, op.TableName)
+		}
+	}
+	return tableNames
+}
+
+// tablesChanged returns whether any of the tables may have been written to on this node after the version, along with
+// the keys of the rows written if they are requested
+func (m *manager) tablesChanged(msg *clustermsgs.QueryChangedMessage) (*clustermsgs.QueryChangedResponse, error) {
+	if !m.clustVersionProvider.IsReadyAsOfVersion(int(msg.ClusterVersion)) {
+		// As with a query, a processor that is failing over might not have reprocessed the writes from the
+		// replication queue yet
+		return nil, errors.NewTektiteErrorf(errors.Unavailable,
+			"cannot check for changes, remote node is not ready at required version")
+	}
+	resp := &clustermsgs.QueryChangedResponse{}
+	for _, tableName := range msg.TableNames {
+		streamInfo := m.streamInfoProvider.GetStream(tableName)
+		if streamInfo == nil || streamInfo.UserSlab == nil {
+			resp.Changed = true
+			resp.KeysUnknown = true
+			continue
+		}
+		if msg.WithKeys {
+			keys, ok := streamInfo.UserSlab.KeysWrittenAfter(msg.ChangedAfterVersion)
+			if !ok {
+				resp.KeysUnknown = true
+			}
+			resp.Keys = append(resp.Keys, keys...)
+		}
+		if streamInfo.UserSlab.WrittenAfter(msg.ChangedAfterVersion) {
+			resp.Changed = true
+		}
+	}
+	return resp, nil
+}
+
+// ExecuteQueryDirectWithArgs executes the query of a prepare statement with the args, without preparing it. tsl is the
+// prepare statement - it is sent to the other nodes so they know the types of the params.
+func (m *manager) ExecuteQueryDirectWithArgs(tsl string, prepareQuery parser.PrepareQueryDesc, args []any, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
 	highestVersion := atomic.LoadInt64(&m.lastCompletedVersion)
-	_, err = m.executeQuery(info, "", tsl, nil, highestVersion, outputFunc)
+	m.lock.RLock()
+	info, err := m.createQueryInfo(prepareQuery.Query.OperatorDescs, prepareQuery.Params)
+	m.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	if len(args) != len(prepareQuery.Params) {
+		return errors.NewQueryErrorf("query requires %d args but %d were provided", len(prepareQuery.Params), len(args))
+	}
+	_, err = m.executeQuery(info, "", tsl, args, highestVersion, opers.PageStart{}, limits, outputFunc)
 	return err
 }
 
-func (m *manager) ExecutePreparedQuery(queryName string, args []any,
-	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (m *manager) ExecutePreparedQuery(queryName string, args []any, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	highestVersion := atomic.LoadInt64(&m.lastCompletedVersion)
-	return m.ExecutePreparedQueryWithHighestVersion(queryName, args, highestVersion, outputFunc)
+	return m.ExecutePreparedQueryWithHighestVersion(queryName, args, highestVersion, opers.PageStart{}, limits, outputFunc)
 }
 
+// ExecutePreparedQueryWithHighestVersion executes the prepared query against the data as of highestVersion. For a query
+// with a limit, page is where the page of results starts - executing the query again with the same highestVersion and
+// the page returned by GetPreparedQueryNextPage pages through a consistent snapshot of the results. Once the tables
+// read no longer have the rows as of highestVersion, the cursor of the pages has expired and an error is returned.
 func (m *manager) ExecutePreparedQueryWithHighestVersion(queryName string, args []any, highestVersion int64,
-	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+	page opers.PageStart, limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	m.lock.RLock()
-	defer m.lock.RUnlock()
 	info, exists := m.preparedQueries[queryName]
+	m.lock.RUnlock()
 	if !exists {
 		return 0, errors.Errorf("query `%s` does not exist", queryName)
 	}
-	return m.executeQuery(info, queryName, "", args, highestVersion, outputFunc)
+	if err := m.checkVersionRetained(info.TableNames, highestVersion); err != nil {
+		return 0, errors.NewTektiteErrorf(errors.ExecuteQueryError, "cursor expired: %v", err)
+	}
+	return m.executeQuery(info, queryName, "", args, highestVersion, page, limits, outputFunc)
 }
 
 func (m *manager) executeQuery(info *QInfo, queryName string, tsl string, args []any, highestVersion int64,
-	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+	page opers.PageStart, limits Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
+	highestVersion, err := m.asOfVersion(info, highestVersion)
+	if err != nil {
+		return 0, err
+	}
+	// We encode the args into an event batch - this is used to evaluate them on the remote side, and it's easy to
+	// serialize
+	var argsBatch *evbatch.Batch
+	if args != nil {
+		builders := evbatch.CreateColBuilders(info.ParamSchema.ColumnTypes())
+		appendArgs(builders, info.ParamSchema.ColumnTypes(), args)
+		argsBatch = evbatch.NewBatchFromBuilders(info.ParamSchema, builders...)
+	}
+	return m.executeQueryWithArgsBatch(info, queryName, tsl, argsBatch, highestVersion, page, limits, outputFunc)
+}
+
+// appendArgs appends the args to the builders as a row
+func appendArgs(builders []evbatch.ColumnBuilder, paramTypes []types.ColumnType, args []any) {
+	for i, arg := range args {
+		if arg == nil {
+			builders[i].AppendNull()
+			continue
+		}
+		ct := paramTypes[i]
+		switch ct.ID() {
+		case types.ColumnTypeIDInt:
+			builders[i].(*evbatch.IntColBuilder).Append(arg.(int64))
+		case types.ColumnTypeIDFloat:
+			builders[i].(*evbatch.FloatColBuilder).Append(arg.(float64))
+		case types.ColumnTypeIDBool:
+			builders[i].(*evbatch.BoolColBuilder).Append(arg.(bool))
+		case types.ColumnTypeIDDecimal:
+			builders[i].(*evbatch.DecimalColBuilder).Append(arg.(types.Decimal))
+		case types.ColumnTypeIDString:
+			builders[i].(*evbatch.StringColBuilder).Append(arg.(string))
+		case types.ColumnTypeIDBytes:
+			builders[i].(*evbatch.BytesColBuilder).Append(arg.([]byte))
+		case types.ColumnTypeIDTimestamp:
+			builders[i].(*evbatch.TimestampColBuilder).Append(arg.(types.Timestamp))
+		case types.ColumnTypeIDArray, types.ColumnTypeIDMap, types.ColumnTypeIDStruct:
+			builders[i].(*evbatch.NestedColBuilder).Append(arg)
+		default:
+			panic("unexpected col type")
+		}
+	}
+}
+
+func (m *manager) executeQueryWithArgsBatch(info *QInfo, queryName string, tsl string, argsBatch *evbatch.Batch,
+	highestVersion int64, page opers.PageStart, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 
 	if highestVersion == -1 {
 		// No version has completed yet, so there is no data. This would be the case on startup of a new cluster
 		// So we return an empty batch
-		if err := outputFunc(true, 1, createEmptyBatch(info.RemoteResultSchema)); err != nil {
+		if err := outputFunc(true, 1, createEmptyBatch(info.ResultSchema), nil); err != nil {
 			return 0, err
 		}
 		return 0, nil
 	}
 
-	execID, _ := uuid.New().MarshalBinary()
+	nodePartitions, numParts, err := m.calcNodePartitions(info, argsBatch)
+	if err != nil {
+		return 0, err
+	}
+	return m.executeQueryOnPartitions(info, queryName, tsl, argsBatch, nodePartitions, numParts, highestVersion, page,
+		limits, outputFunc)
+}
+
+// executeQueryOnPartitions executes the query on the partitions of each node in nodePartitions
+func (m *manager) executeQueryOnPartitions(info *QInfo, queryName string, tsl string, argsBatch *evbatch.Batch,
+	nodePartitions map[int][]int, numParts int, highestVersion int64, page opers.PageStart, limits Limits,
+	outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
+	queryID := uuid.New()
+	execID, _ := queryID.MarshalBinary()
 	sExecID := common.ByteSliceToStringZeroCopy(execID)
+	limits = limits.withDefaults(m.defaultLimits)
 
-	// We encode the args into an event batch - this is used to evaluate them on the remote side, and it's easy to
-	// serialize
-	var argsBatch *evbatch.Batch
 	var argsBuff []byte
-	if args != nil {
-		paramTypes := info.ParamSchema.ColumnTypes()
-		builders := evbatch.CreateColBuilders(paramTypes)
-		for i, arg := range args {
-			if arg == nil {
-				builders[i].AppendNull()
-				continue
-			}
-			ct := paramTypes[i]
-			switch ct.ID() {
-			case types.ColumnTypeIDInt:
-				builders[i].(*evbatch.IntColBuilder).Append(arg.(int64))
-			case types.ColumnTypeIDFloat:
-				builders[i].(*evbatch.FloatColBuilder).Append(arg.(float64))
-			case types.ColumnTypeIDBool:
-				builders[i].(*evbatch.BoolColBuilder).Append(arg.(bool))
-			case types.ColumnTypeIDDecimal:
-				builders[i].(*evbatch.DecimalColBuilder).Append(arg.(types.Decimal))
-			case types.ColumnTypeIDString:
-				builders[i].(*evbatch.StringColBuilder).Append(arg.(string))
-			case types.ColumnTypeIDBytes:
-				builders[i].(*evbatch.BytesColBuilder).Append(arg.([]byte))
-			case types.ColumnTypeIDTimestamp:
-				builders[i].(*evbatch.TimestampColBuilder).Append(arg.(types.Timestamp))
-			default:
-				panic("unexpected col type")
-			}
-		}
-		argsBatch = evbatch.NewBatchFromBuilders(info.ParamSchema, builders...)
+	if argsBatch != nil {
 		argsBuff = argsBatch.Serialize(nil)
 	}
 
-	nodePartitions, numParts, err := m.calcNodePartitions(info, argsBatch)
-	if err != nil {
-		return 0, err
+	localExecStates := make([]any, len(info.LocalOperators))
+	for i, oper := range info.LocalOperators {
+		localExecStates[i] = createExecState(oper, page)
 	}
 	qrh := &queryResultHandler{
-		localOperators: info.LocalOperators,
-		outputFunc:     outputFunc,
-		schema:         info.RemoteResultSchema,
-		numPartitions:  int64(numParts),
+		localOperators:  info.LocalOperators,
+		localExecStates: localExecStates,
+		outputFunc:      outputFunc,
+		schema:          info.RemoteResultSchema,
+		numPartitions:   int64(numParts),
+		runningQuery: RunningQuery{
+			ID:        queryID.String(),
+			QueryName: queryName,
+			TSL:       tsl,
+			Version:   highestVersion,
+			StartTime: time.Now(),
+		},
+		execID:         sExecID,
+		maxMemoryBytes: limits.MaxMemoryBytes,
+		maxRowsScanned: limits.MaxRowsScanned,
+		// Only a sort holds all the results in memory - an aggregate only holds the merged aggregate
+		resultsHeld: len(info.LocalOperators) > 0 && isSortOperator(info.LocalOperators[0]),
 	}
 	m.resultHandlers.Store(sExecID, qrh)
+	if limits.Timeout > 0 {
+		qrh.timer = time.AfterFunc(limits.Timeout, func() {
+			m.failQuery(qrh, errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"query exceeded the timeout of %s and has been cancelled", limits.Timeout))
+		})
+	}
+
+	// The rows of the tables of any broadcast joins are loaded here, and sent to the remote nodes with the query. The
+	// query is already running, so it can time out or be cancelled while they are loaded.
+	joinTables, err := m.loadBroadcastTables(info, highestVersion, qrh)
+	if err != nil {
+		if !m.abortQuery(qrh) {
+			// The query had already failed, and the error has been passed to outputFunc
+			return numParts, nil
+		}
+		return 0, err
+	}
+	qrh.lock.Lock()
+	if qrh.stopped {
+		qrh.lock.Unlock()
+		return numParts, nil
+	}
+	for nid := range nodePartitions {
+		qrh.nodeIDs = append(qrh.nodeIDs, nid)
+	}
+	qrh.lock.Unlock()
 
 	ch := make(chan error, 1)
 	cf := common.NewCountDownFuture(len(nodePartitions), func(err error) {
@@ -571,26 +1266,129 @@ func (m *manager) executeQuery(info *QInfo, queryName string, tsl string, args [
 		address := m.remotingListenAddresses[nid]
 		partitionsBuff := serializePartitions(partitions)
 		msg := &clustermsgs.QueryMessage{
-			ExecId:         execID,
-			QueryName:      queryName,
-			Tsl:            tsl,
-			Args:           argsBuff,
-			Partitions:     partitionsBuff,
-			SenderAddress:  m.remotingAddress,
-			HighestVersion: uint64(highestVersion),
-			ClusterVersion: uint64(clusterVersion),
+			ExecId:          execID,
+			QueryName:       queryName,
+			Tsl:             tsl,
+			Args:            argsBuff,
+			Partitions:      partitionsBuff,
+			SenderAddress:   m.remotingAddress,
+			HighestVersion:  uint64(highestVersion),
+			ClusterVersion:  uint64(clusterVersion),
+			PageOffset:      uint64(page.Offset),
+			PageAfterKey:    page.AfterKey,
+			PageScanFromKey: page.ScanFromKey,
+			JoinTables:      joinTables,
+			MaxRowsScanned:  limits.MaxRowsScanned,
 		}
 		m.remoting.SendQueryMessageAsync(func(_ remoting.ClusterMessage, err error) {
 			cf.CountDown(remoting.MaybeConvertError(err))
 		}, msg, address)
 	}
 	err = <-ch
-	if err != nil {
-		m.resultHandlers.Delete(sExecID)
+	if err != nil && !m.abortQuery(qrh) {
+		// The query had already failed, and the error has been passed to outputFunc
+		return numParts, nil
 	}
 	return numParts, err
 }
 
+// asOfVersion returns the version the query must read at. For a query that reads a table as of a point in the past
+// this is the last version that was completed at that point, as long as it is not later than highestVersion. The point
+// must not be before the versions retention of the table, as older versions may have been removed.
+func (m *manager) asOfVersion(info *QInfo, highestVersion int64) (int64, error) {
+	if info.AsOfVersion == nil && info.AsOfTime == nil {
+		return highestVersion, nil
+	}
+	retentionStart := time.Now().Add(-info.VersionsRetention)
+	var version int64
+	if info.AsOfVersion != nil {
+		version = *info.AsOfVersion
+		oldestVersion, err := m.versionIndex.GetVersionForTime(retentionStart.UnixMilli())
+		if err != nil {
+			return 0, err
+		}
+		if version < oldestVersion {
+			return 0, errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"cannot query the table as of version %d - it is before the versions retention of the table", version)
+		}
+	} else {
+		if info.AsOfTime.Before(retentionStart) {
+			return 0, errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"cannot query the table as of %s - it is before the versions retention of the table",
+				info.AsOfTime.Format(time.RFC3339))
+		}
+		var err error
+		version, err = m.versionIndex.GetVersionForTime(info.AsOfTime.UnixMilli())
+		if err != nil {
+			return 0, err
+		}
+		if version == -1 {
+			return 0, errors.NewTektiteErrorf(errors.ExecuteQueryError,
+				"no version of the table is available as of %s - it is before the versions retention of the table",
+				info.AsOfTime.Format(time.RFC3339))
+		}
+	}
+	if version > highestVersion {
+		version = highestVersion
+	}
+	return version, nil
+}
+
+// executeAndGather executes the query with the limits and waits for all the results. If nodePartitions is not nil the
+// query is only executed on those partitions. If checkRows is not nil it is called with the number of rows received so far as each
+// batch of results is received, and the query fails with its error, if any.
+func (m *manager) executeAndGather(info *QInfo, queryName string, tsl string, argsBatch *evbatch.Batch,
+	nodePartitions map[int][]int, highestVersion int64, limits Limits, checkRows func(numRows int) error) ([]*evbatch.Batch, error) {
+	var lock sync.Mutex
+	var results []*evbatch.Batch
+	numRows := 0
+	numLastBatchesReceived := 0
+	ch := make(chan error, 1)
+	outputFunc := func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		if err != nil {
+			ch <- err
+			return nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		numRows += batch.RowCount
+		if checkRows != nil {
+			if err := checkRows(numRows); err != nil {
+				// Returning the error fails the query, which stops the scan on all nodes, and the error is then passed
+				// back to this function
+				return err
+			}
+		}
+		results = append(results, batch)
+		if last {
+			numLastBatchesReceived++
+			if numLastBatchesReceived == numLastBatches {
+				ch <- nil
+			}
+		}
+		return nil
+	}
+	var err error
+	if nodePartitions == nil {
+		_, err = m.executeQueryWithArgsBatch(info, queryName, tsl, argsBatch, highestVersion, opers.PageStart{}, limits,
+			outputFunc)
+	} else {
+		numParts := 0
+		for _, partIDs := range nodePartitions {
+			numParts += len(partIDs)
+		}
+		_, err = m.executeQueryOnPartitions(info, queryName, tsl, argsBatch, nodePartitions, numParts, highestVersion,
+			opers.PageStart{}, limits, outputFunc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := <-ch; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 func (m *manager) HandlerCount() int {
 	count := 0
 	m.resultHandlers.Range(func(_, _ any) bool {
@@ -601,35 +1399,90 @@ func (m *manager) HandlerCount() int {
 }
 
 type queryResultHandler struct {
+	lock              sync.Mutex
 	localOperators    []opers.Operator
-	outputFunc        func(complete bool, numLastBatches int, batch *evbatch.Batch) error
+	localExecStates   []any
+	outputFunc        func(complete bool, numLastBatches int, batch *evbatch.Batch, err error) error
 	schema            *evbatch.EventSchema
 	numPartitions     int64
 	outputCalledCount int64
-	sortState         opers.SortState
+	runningQuery      RunningQuery
+	execID            string
+	nodeIDs           []int
+	timer             *time.Timer
+	maxMemoryBytes    int64
+	resultsHeld       bool
+	memoryBytes       int64
+	maxRowsScanned    int64
+	rowsScanned       int64
+	// stopped is set when the query completes or fails, after which no more results are passed to outputFunc
+	stopped bool
+}
+
+// createExecState creates the state an operator needs for a single execution of a query, if any
+func createExecState(oper opers.Operator, page opers.PageStart) any {
+	switch oper.(type) {
+	case *opers.SortOperator:
+		return &opers.SortState{}
+	case *opers.QueryAggregateOperator:
+		return &opers.QueryAggregateState{}
+	case *opers.LimitOperator:
+		return &opers.LimitState{Page: page}
+	default:
+		return nil
+	}
 }
 
-func (q *queryResultHandler) handleQueryResult(last bool, buff []byte) (bool, error) {
+func (q *queryResultHandler) handleQueryResult(last bool, buff []byte, rowsScanned int64) (bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stopped {
+		return false, nil
+	}
+	// Each node checks the rows it has scanned too, so a query that scans a lot of rows without returning any results
+	// is stopped before it completes
+	q.rowsScanned += rowsScanned
+	if q.maxRowsScanned > 0 && q.rowsScanned > q.maxRowsScanned {
+		return false, errors.NewTektiteErrorf(errors.ExecuteQueryError,
+			"query exceeded the maximum of %d rows scanned and has been cancelled", q.maxRowsScanned)
+	}
+	if q.resultsHeld {
+		q.memoryBytes += int64(len(buff))
+		if err := q.checkMemory(q.memoryBytes); err != nil {
+			return false, err
+		}
+	}
 	batch := convertBytesToBatch(buff, q.schema)
 	if q.localOperators != nil {
-		// For now, this is always a sort. It will only return a non nil batch when it has received all batches
-		var err error
-		batch, err = q.localOperators[0].HandleQueryBatch(batch, &queryExecCtx{
-			last:      last,
-			execState: &q.sortState,
-		})
-		if err != nil {
-			return true, err
+		// The first local operator is a sort or the merge of an aggregate. These only return a non nil batch when they
+		// have received all batches, and the result is then passed through the remaining local operators
+		for i, oper := range q.localOperators {
+			var err error
+			batch, err = oper.HandleQueryBatch(batch, &queryExecCtx{
+				last:      last,
+				execState: q.localExecStates[i],
+			})
+			if err != nil {
+				return false, err
+			}
+			if batch == nil {
+				break
+			}
+		}
+		if aggState, ok := q.localExecStates[0].(*opers.QueryAggregateState); ok {
+			if err := q.checkMemory(aggState.MemoryBytes()); err != nil {
+				return false, err
+			}
 		}
 		if batch != nil {
-			// For a sort we only receive a single sorted batch
-			if err := q.outputFunc(last, 1, batch); err != nil {
-				return true, err
+			// We only receive a single batch containing all the results
+			if err := q.outputFunc(last, 1, batch, nil); err != nil {
+				return false, err
 			}
 		}
 	} else {
-		if err := q.outputFunc(last, int(q.numPartitions), batch); err != nil {
-			return true, err
+		if err := q.outputFunc(last, int(q.numPartitions), batch, nil); err != nil {
+			return false, err
 		}
 	}
 	if last {
@@ -638,28 +1491,68 @@ func (q *queryResultHandler) handleQueryResult(last bool, buff []byte) (bool, er
 			panic("handler called too many times")
 		}
 		if count == q.numPartitions {
+			q.stopped = true
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+func (q *queryResultHandler) checkMemory(memoryBytes int64) error {
+	if q.maxMemoryBytes > 0 && memoryBytes > q.maxMemoryBytes {
+		return errors.NewTektiteErrorf(errors.ExecuteQueryError,
+			"query exceeded the maximum memory of %d bytes and has been cancelled", q.maxMemoryBytes)
+	}
+	return nil
+}
+
+func (q *queryResultHandler) isStopped() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.stopped
+}
+
 func (m *manager) ReceiveQueryResult(msg *clustermsgs.QueryResponse) {
 	sExecID := string(msg.ExecId)
-	qrh, ok := m.resultHandlers.Load(sExecID)
+	h, ok := m.resultHandlers.Load(sExecID)
 	if !ok {
-		// This can occur if the query failed to send to all remote nodes and the handler was removed - ignore
+		// This can occur if the query failed to send to all remote nodes, or has already failed, and the handler was
+		// removed - ignore
 		return
 	}
-	complete, err := qrh.(*queryResultHandler).handleQueryResult(msg.Last, msg.Value)
+	qrh := h.(*queryResultHandler)
+	if msg.ErrorCode != 0 {
+		// The query failed on the remote node
+		m.failQuery(qrh, errors.NewTektiteError(errors.ErrorCode(msg.ErrorCode), msg.Error))
+		return
+	}
+	complete, err := qrh.handleQueryResult(msg.Last, msg.Value, msg.RowsScanned)
 	if err != nil {
-		log.Errorf("failed to handle query result %v", err)
+		m.failQuery(qrh, err)
+		return
 	}
 	if complete {
-		m.resultHandlers.Delete(sExecID)
+		m.removeQuery(qrh)
 	}
 }
 
+// directQueryInfo creates the query info for a query that is executed directly. A query with args is sent as the
+// prepare statement it was executed from, so the types of the params are known.
+func (m *manager) directQueryInfo(tsl string) (*QInfo, error) {
+	if strings.HasPrefix(tsl, "prepare ") {
+		tslDesc, err := m.parser.ParseTSL(tsl)
+		if err != nil {
+			return nil, err
+		}
+		return m.createQueryInfo(tslDesc.PrepareQuery.Query.OperatorDescs, tslDesc.PrepareQuery.Params)
+	}
+	queryDesc, err := m.parser.ParseQuery(tsl)
+	if err != nil {
+		return nil, err
+	}
+	return m.createQueryInfo(queryDesc.OperatorDescs, nil)
+}
+
 func (m *manager) ExecuteRemoteQuery(msg *clustermsgs.QueryMessage) error {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -670,19 +1563,29 @@ func (m *manager) ExecuteRemoteQuery(msg *clustermsgs.QueryMessage) error {
 	}
 	var info *QInfo
 	if msg.QueryName != "" {
-		// Prepared query
-		var exists bool
-		info, exists = m.preparedQueries[msg.QueryName]
-		if !exists {
-			return errors.Errorf("query %s does not exist", msg.QueryName)
-		}
-	} else {
-		// direct query
-		queryDesc, err := m.parser.ParseQuery(msg.Tsl)
+		// Prepared query, the lookup of a join, or the scan of a keyed query
+		var err error
+		info, err = m.joinLookupQueryInfo(msg.QueryName)
 		if err != nil {
 			return err
 		}
-		info, err = m.createQueryInfo(queryDesc.OperatorDescs, nil)
+		if info == nil {
+			info, err = m.keyedQueryInfo(msg.QueryName)
+			if err != nil {
+				return err
+			}
+		}
+		if info == nil {
+			var exists bool
+			info, exists = m.preparedQueries[msg.QueryName]
+			if !exists {
+				return errors.Errorf("query %s does not exist", msg.QueryName)
+			}
+		}
+	} else {
+		// direct query
+		var err error
+		info, err = m.directQueryInfo(msg.Tsl)
 		if err != nil {
 			return err
 		}
@@ -704,7 +1607,37 @@ func (m *manager) ExecuteRemoteQuery(msg *clustermsgs.QueryMessage) error {
 		argsBatch = evbatch.NewBatchFromSingleBuff(info.ParamSchema, msg.Args)
 	}
 
+	var joinTables []*joinTable
+	if len(info.BroadcastJoins) > 0 {
+		var err error
+		joinTables, err = createJoinTables(info, msg.JoinTables)
+		if err != nil {
+			return err
+		}
+	}
+
 	lo := info.RemoteOperators[0].(*GetOperator)
+	page := opers.PageStart{
+		Offset:      int(msg.PageOffset),
+		AfterKey:    msg.PageAfterKey,
+		ScanFromKey: msg.PageScanFromKey,
+	}
+	var keyRows map[uint64][]int
+	if info.MultiKeyLookup {
+		// Each loader gets the rows with the keys in its partition
+		keyRows = map[uint64][]int{}
+		for rowIndex := 0; rowIndex < argsBatch.RowCount; rowIndex++ {
+			partID, _, err := m.keyPartition(info, argsBatch, rowIndex)
+			if err != nil {
+				return err
+			}
+			keyRows[uint64(partID)] = append(keyRows[uint64(partID)], rowIndex)
+		}
+	}
+	rq := &remoteQuery{
+		maxRowsScanned: msg.MaxRowsScanned,
+		loaders:        make([]*queryLoader, 0, len(partitionIDs)),
+	}
 	// For now, we just have one loader per partition but, we should experiment to see if it's more efficient to have
 	// multiple sharing the same loader - also for Kafka consumers we will have multiple paritions on the same loader
 	for _, partID := range partitionIDs {
@@ -716,20 +1649,49 @@ func (m *manager) ExecuteRemoteQuery(msg *clustermsgs.QueryMessage) error {
 			getOperator:    lo,
 			rateLimiter:    &dummyRateLimiter{},
 			args:           argsBatch,
+			keyRows:        keyRows[partID],
+			execState:      createRemoteExecState(info, page),
+			scanFromKey:    page.ScanFromKey,
 			execID:         string(msg.ExecId),
 			resultAddress:  msg.SenderAddress,
 			maxRows:        m.maxBatchRows,
 			nodeID:         m.nodeID,
+			joinTables:     joinTables,
+			remoteQuery:    rq,
 		}
+		rq.loaders = append(rq.loaders, ql)
+	}
+	rq.numRunning.Store(int64(len(rq.loaders)))
+	execID := string(msg.ExecId)
+	m.remoteQueries.Store(execID, rq)
+	for _, ql := range rq.loaders {
+		ql := ql
 		common.Go(func() {
 			if err := ql.start(); err != nil {
-				log.Errorf("failed to start query loader %v", err)
+				// The query has failed, so the other loaders for the query can stop too
+				rq.cancel()
+				ql.closeIterators()
+				m.sendQueryError(ql, err)
+			}
+			if rq.numRunning.Add(-1) == 0 {
+				m.remoteQueries.Delete(execID)
 			}
 		})
 	}
 	return nil
 }
 
+// createRemoteExecState creates the state for the remote operators of a query loader. Only the partial phase of an
+// aggregate or a limit has state on the remote side, and a query never has both.
+func createRemoteExecState(info *QInfo, page opers.PageStart) any {
+	for _, oper := range info.RemoteOperators {
+		if state := createExecState(oper, page); state != nil {
+			return state
+		}
+	}
+	return nil
+}
+
 func (m *manager) getPreparedQuery(name string) *QInfo {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -753,14 +1715,33 @@ type queryLoader struct {
 	cancelled      atomic.Bool
 	rateLimiter    RateLimiter
 	args           *evbatch.Batch
+	execState      any
 	execID         string
 	resultAddress  string
 	nodeID         int
+	joinTables     []*joinTable
+	remoteQuery    *remoteQuery
+	// unsentRowsScanned is the number of rows scanned since results were last sent to the node that received the query
+	unsentRowsScanned int64
+	// scanFromKey is the key of the last row of the previous page of a query with a limit, if the scan can resume from it
+	scanFromKey []byte
+	// keyRows are the rows of the args with the keys to get, for a multi key lookup
+	keyRows []int
 }
 
 func (ql *queryLoader) start() error {
 	for i, partID := range ql.partitionIDs {
-		iter, err := ql.getOperator.CreateIterator(partID, ql.args, ql.highestVersion)
+		if ql.info.MultiKeyLookup {
+			ql.iters[i] = &multiGetIterator{
+				getOperator:    ql.getOperator,
+				partID:         partID,
+				args:           ql.args,
+				rows:           ql.keyRows,
+				highestVersion: ql.highestVersion,
+			}
+			continue
+		}
+		iter, err := ql.getOperator.CreateIterator(partID, ql.args, ql.highestVersion, ql.scanFromKey)
 		if err != nil {
 			return err
 		}
@@ -781,28 +1762,52 @@ func (ql *queryLoader) runLoop() error {
 			// Iterators all complete
 			break
 		}
-		batch, more, err := ql.getOperator.LoadBatch(iter, ql.maxRows)
+		batch, rowsScanned, more, err := ql.getOperator.LoadBatch(iter, ql.maxRows)
 		if err != nil {
 			return err
 		}
+		if err := ql.remoteQuery.addRowsScanned(rowsScanned); err != nil {
+			return err
+		}
+		ql.unsentRowsScanned += int64(rowsScanned)
 		if !more {
 			// no more rows on the iterator
 			iter.Close()
 			ql.iters[iterPos] = nil
 		}
 		_, err = ql.getOperator.HandleQueryBatch(batch, &queryExecCtx{
-			execID:        ql.execID,
-			resultAddress: ql.resultAddress,
-			last:          !more,
+			execID:            ql.execID,
+			resultAddress:     ql.resultAddress,
+			last:              !more,
+			execState:         ql.execState,
+			highestVersion:    ql.highestVersion,
+			joinTables:        ql.joinTables,
+			unsentRowsScanned: &ql.unsentRowsScanned,
 		})
 		if err != nil {
 			return err
 		}
+		if limitState, ok := ql.execState.(*opers.LimitState); ok && limitState.Done() {
+			// The limit has been reached, so there's no need to load any more rows
+			ql.closeIterators()
+			break
+		}
 		ql.rateLimiter.Limit()
 	}
+	// If the query was cancelled, the iterators are still open
+	ql.closeIterators()
 	return nil
 }
 
+func (ql *queryLoader) closeIterators() {
+	for i, iter := range ql.iters {
+		if iter != nil {
+			iter.Close()
+			ql.iters[i] = nil
+		}
+	}
+}
+
 func (ql *queryLoader) chooseIterator() (iteration.Iterator, int, bool) {
 	start := ql.pos
 	for {
@@ -822,10 +1827,13 @@ func (ql *queryLoader) chooseIterator() (iteration.Iterator, int, bool) {
 }
 
 type queryExecCtx struct {
-	execID        string
-	resultAddress string
-	last          bool
-	execState     any
+	execID            string
+	resultAddress     string
+	last              bool
+	execState         any
+	highestVersion    uint64
+	joinTables        []*joinTable
+	unsentRowsScanned *int64
 }
 
 func (q *queryExecCtx) ExecID() string {
@@ -900,6 +1908,11 @@ func (nr *networkResultsOperator) HandleQueryBatch(batch *evbatch.Batch, execCtx
 		Value:  bytes,
 		Last:   execCtx.Last(),
 	}
+	if qec, ok := execCtx.(*queryExecCtx); ok && qec.unsentRowsScanned != nil {
+		// The rows scanned are sent with the results, so the limit can be applied to the query as a whole
+		msg.RowsScanned = *qec.unsentRowsScanned
+		*qec.unsentRowsScanned = 0
+	}
 	return nil, nr.remoting.SendQueryResponse(msg, execCtx.ResultAddress())
 }
 
@@ -966,6 +1979,7 @@ func deserializePartitions(buff []byte) []uint64 {
 
 type errMsgAtPositionProvider interface {
 	ErrorMsgAtToken(msg string, tokenVal string) string
+	ErrorMsgAtPosition(msg string, position lexer.Position) string
 }
 
 func queryErrorAtTokenf(tokenName string, provider errMsgAtPositionProvider, msg string, args ...interface{}) error {
@@ -973,3 +1987,9 @@ func queryErrorAtTokenf(tokenName string, provider errMsgAtPositionProvider, msg
 	msg = provider.ErrorMsgAtToken(msg, tokenName)
 	return errors.NewQueryErrorf(msg)
 }
+
+func queryErrorAtPositionf(token lexer.Token, provider errMsgAtPositionProvider, msg string, args ...interface{}) error {
+	msg = fmt.Sprintf(msg, args...)
+	msg = provider.ErrorMsgAtPosition(msg, token.Pos)
+	return errors.NewQueryErrorf(msg)
+}
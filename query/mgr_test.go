@@ -6,6 +6,7 @@ import (
 	"github.com/apache/arrow/go/v11/arrow/decimal128"
 	"github.com/spirit-labs/tektite/common"
 	"github.com/spirit-labs/tektite/encoding"
+	"github.com/spirit-labs/tektite/errors"
 	"github.com/spirit-labs/tektite/evbatch"
 	"github.com/spirit-labs/tektite/expr"
 	"github.com/spirit-labs/tektite/mem"
@@ -18,6 +19,7 @@ import (
 	"github.com/spirit-labs/tektite/tppm"
 	"github.com/spirit-labs/tektite/types"
 	"github.com/stretchr/testify/require"
+	"math"
 	"math/rand"
 	"reflect"
 	"sort"
@@ -125,7 +127,7 @@ func TestQMGetAll(t *testing.T) {
 	var done sync.WaitGroup
 	done.Add(1)
 	var lastBatchCount int
-	numParts, err := mgr.ExecutePreparedQuery("test_query1", nil, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	numParts, err := mgr.ExecutePreparedQuery("test_query1", nil, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		rows := convertBatchToAnyArray(batch, schema)
 		lock.Lock()
 		defer lock.Unlock()
@@ -290,7 +292,7 @@ func testQMGetRange(t *testing.T, expectedStart int, expectedEnd int, rangeStr s
 	var done sync.WaitGroup
 	done.Add(1)
 	var lastBatchCount int
-	numParts, err := mgr.ExecutePreparedQuery("test_query1", argVals, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	numParts, err := mgr.ExecutePreparedQuery("test_query1", argVals, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		rows := convertBatchToAnyArray(batch, schema)
 		lock.Lock()
 		defer lock.Unlock()
@@ -687,6 +689,457 @@ func TestQueryVersionsSnapshotIsolation(t *testing.T) {
 	executeQueryFromMgr(t, "test_query1", schema, keyCols, expectedKeyVals, argVals, data2, 1, mgr)
 }
 
+func TestQueryAsOf(t *testing.T) {
+	data := [][]any{
+		{int64(0), "foo0"},
+		{int64(1), "foo1"},
+		{int64(2), "foo2"},
+	}
+	keyCols := []int{0}
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, columnTypes)
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	// The table keeps older versions for long enough that the times below can be queried
+	slInfoProvider.GetStream("test_slab1").UserSlab.VersionsRetention = time.Since(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st, 10)
+	data2 := [][]any{
+		{int64(0), "boo0"},
+		{int64(1), "boo1"},
+		{int64(2), "boo2"},
+	}
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data2, ctx.st, 13)
+	for _, mgrPair := range ctx.qms {
+		mgrPair.qm.SetLastCompletedVersion(13)
+	}
+	asOf := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ctx.versionIndex.setVersionForTime(asOf.UnixMilli(), 12)
+
+	prepareQuery(t, `prepare test_query1 := (scan all from test_slab1)`, ctx)
+	prepareQuery(t, `prepare test_query2 := (scan all from test_slab1 as_of 12)`, ctx)
+	prepareQuery(t, `prepare test_query3 := (scan all from test_slab1 as_of "2024-05-01T00:00:00Z")`, ctx)
+	prepareQuery(t, `prepare test_query4 := (scan all from test_slab1 as_of 100)`, ctx)
+	prepareQuery(t, `prepare test_query5 := (scan all from test_slab1 as_of "2024-04-01T00:00:00Z")`, ctx)
+
+	executeQuery(t, ctx, "test_query1", schema, keyCols, nil, nil, data2, defaultNumPartitions)
+	executeQuery(t, ctx, "test_query2", schema, keyCols, nil, nil, data, defaultNumPartitions)
+	executeQuery(t, ctx, "test_query3", schema, keyCols, nil, nil, data, defaultNumPartitions)
+	// A version later than the last completed version reads the last completed version
+	executeQuery(t, ctx, "test_query4", schema, keyCols, nil, nil, data2, defaultNumPartitions)
+
+	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query5", nil, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Equal(t, "no version of the table is available as of 2024-04-01T00:00:00Z - it is before the versions retention of the table",
+		err.Error())
+}
+
+func TestQueryAsOfOutsideVersionsRetention(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, []int{0})
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	mgr := ctx.qms[0].qm
+	mgr.SetLastCompletedVersion(20)
+
+	// A table that does not keep older versions cannot be queried as_of
+	ast, err := parser.NewParser(nil).ParseTSL(`prepare test_query1 := (scan all from test_slab1 as_of 12)`)
+	require.NoError(t, err)
+	err = mgr.PrepareQuery(*ast.PrepareQuery)
+	require.Error(t, err)
+	require.Equal(t, `table 'test_slab1' cannot be queried with as_of as it does not keep older versions - it must be created with versions_retention (line 1 column 50):
+prepare test_query1 := (scan all from test_slab1 as_of 12)
+                                                 ^`, err.Error())
+
+	slInfoProvider.GetStream("test_slab1").UserSlab.VersionsRetention = time.Hour
+	// Version 15 completed before the start of the retention period, so older versions may have been removed
+	ctx.versionIndex.setVersionForTime(time.Now().Add(-2*time.Hour).UnixMilli(), 15)
+	prepareQuery(t, `prepare test_query2 := (scan all from test_slab1 as_of 14)`, ctx)
+	prepareQuery(t, `prepare test_query3 := (scan all from test_slab1 as_of 15)`, ctx)
+	prepareQuery(t, `prepare test_query4 := (scan all from test_slab1 as_of "2024-05-01T00:00:00Z")`, ctx)
+	noop := func(bool, int, *evbatch.Batch, error) error {
+		return nil
+	}
+	_, err = mgr.ExecutePreparedQuery("test_query2", nil, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cannot query the table as of version 14 - it is before the versions retention of the table",
+		err.Error())
+	_, err = mgr.ExecutePreparedQuery("test_query3", nil, Limits{}, noop)
+	require.NoError(t, err)
+	_, err = mgr.ExecutePreparedQuery("test_query4", nil, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cannot query the table as of 2024-05-01T00:00:00Z - it is before the versions retention of the table",
+		err.Error())
+}
+
+func TestCompletedVersionListeners(t *testing.T) {
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID,
+		evbatch.NewEventSchema([]string{"f0"}, []types.ColumnType{types.ColumnTypeInt}), defaultNumPartitions, []int{0})
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	mgr := ctx.qms[0].qm
+
+	var versions1, versions2 []int64
+	mgr.RegisterCompletedVersionListener("listener1", func(version int64) {
+		versions1 = append(versions1, version)
+	})
+	mgr.RegisterCompletedVersionListener("listener2", func(version int64) {
+		versions2 = append(versions2, version)
+	})
+	mgr.SetLastCompletedVersion(1)
+	mgr.UnregisterCompletedVersionListener("listener2")
+	mgr.SetLastCompletedVersion(2)
+	require.Equal(t, []int64{1, 2}, versions1)
+	require.Equal(t, []int64{1}, versions2)
+}
+
+func TestExecuteQueryDirectWithHighestVersion(t *testing.T) {
+	data := [][]any{
+		{int64(0), "foo0"},
+		{int64(1), "foo1"},
+	}
+	keyCols := []int{0}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st, 10)
+	data2 := [][]any{
+		{int64(0), "boo0"},
+		{int64(1), "boo1"},
+	}
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data2, ctx.st, 13)
+	for _, mgrPair := range ctx.qms {
+		mgrPair.qm.SetLastCompletedVersion(13)
+	}
+	tsl := `(scan all from test_slab1)`
+	query, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	for version, expected := range map[int64][][]any{12: data, 13: data2} {
+		var lock sync.Mutex
+		var rows [][]any
+		var done sync.WaitGroup
+		done.Add(1)
+		lastBatchCount := 0
+		err = ctx.qms[0].qm.ExecuteQueryDirectWithHighestVersion(tsl, *query, version, Limits{},
+			func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+				lock.Lock()
+				defer lock.Unlock()
+				rows = append(rows, convertBatchToAnyArray(batch, schema)...)
+				if last {
+					lastBatchCount++
+					if lastBatchCount == numLastBatches {
+						done.Done()
+					}
+				}
+				return nil
+			})
+		require.NoError(t, err)
+		done.Wait()
+		checkQueryResults(t, keyCols, schema, rows, expected, nil)
+	}
+}
+
+func TestExecuteQueryDirectWithHighestVersionNotRetained(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, []int{0})
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	mgr := ctx.qms[0].qm
+	mgr.SetLastCompletedVersion(20)
+	mgr.(*manager).SetLastFlushedVersion(12)
+	tsl := `(scan all from test_slab1)`
+	query, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	noop := func(bool, int, *evbatch.Batch, error) error {
+		return nil
+	}
+
+	// Versions before the last flushed version may have been compacted away
+	err = mgr.ExecuteQueryDirectWithHighestVersion(tsl, *query, 11, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cannot query table 'test_slab1' as of version 11 - it does not keep older versions, and the oldest version it can be queried as of is 12",
+		err.Error())
+	err = mgr.ExecuteQueryDirectWithHighestVersion(tsl, *query, 12, Limits{}, noop)
+	require.NoError(t, err)
+
+	// Unless the table keeps them
+	slInfoProvider.GetStream("test_slab1").UserSlab.VersionsRetention = time.Hour
+	ctx.versionIndex.setVersionForTime(time.Now().Add(-2*time.Hour).UnixMilli(), 5)
+	err = mgr.ExecuteQueryDirectWithHighestVersion(tsl, *query, 5, Limits{}, noop)
+	require.NoError(t, err)
+	err = mgr.ExecuteQueryDirectWithHighestVersion(tsl, *query, 4, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cannot query table 'test_slab1' as of version 4 - it is before the versions retention of the table",
+		err.Error())
+}
+
+func TestExecutePreparedQueryWithHighestVersionNotRetained(t *testing.T) {
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, []int{0})
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	mgr := ctx.qms[0].qm
+	mgr.SetLastCompletedVersion(20)
+	mgr.(*manager).SetLastFlushedVersion(12)
+	prepareQuery(t, `prepare test_query1 := (scan all from test_slab1) -> (limit 2)`, ctx)
+	noop := func(bool, int, *evbatch.Batch, error) error {
+		return nil
+	}
+
+	// The pages of a cursor are executed as of the version of its first page, which may have been compacted away
+	page := opers.PageStart{Offset: 2}
+	_, err := mgr.ExecutePreparedQueryWithHighestVersion("test_query1", nil, 11, page, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cursor expired: cannot query table 'test_slab1' as of version 11 - it does not keep older versions, and the oldest version it can be queried as of is 12",
+		err.Error())
+	var tErr errors.TektiteError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, errors.ErrorCode(errors.ExecuteQueryError), tErr.Code)
+	_, err = mgr.ExecutePreparedQueryWithHighestVersion("test_query1", nil, 12, page, Limits{}, noop)
+	require.NoError(t, err)
+
+	// Unless the table keeps them
+	slInfoProvider.GetStream("test_slab1").UserSlab.VersionsRetention = time.Hour
+	ctx.versionIndex.setVersionForTime(time.Now().Add(-2*time.Hour).UnixMilli(), 5)
+	_, err = mgr.ExecutePreparedQueryWithHighestVersion("test_query1", nil, 5, page, Limits{}, noop)
+	require.NoError(t, err)
+	_, err = mgr.ExecutePreparedQueryWithHighestVersion("test_query1", nil, 4, page, Limits{}, noop)
+	require.Error(t, err)
+	require.Equal(t, "cursor expired: cannot query table 'test_slab1' as of version 4 - it is before the versions retention of the table",
+		err.Error())
+}
+
+func TestExecuteQueryDirectIfChanged(t *testing.T) {
+	data := [][]any{
+		{int64(0), "foo0"},
+		{int64(1), "foo1"},
+	}
+	keyCols := []int{0}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st, 10)
+	for _, mgrPair := range ctx.qms {
+		mgrPair.qm.SetLastCompletedVersion(13)
+	}
+	tsl := `(scan all from test_slab1)`
+	query, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	executeIfChanged := func(changedAfterVersion int64) (bool, [][]any) {
+		var lock sync.Mutex
+		var rows [][]any
+		var done sync.WaitGroup
+		done.Add(1)
+		lastBatchCount := 0
+		executed, err := ctx.qms[0].qm.ExecuteQueryDirectIfChanged(tsl, *query, changedAfterVersion, 13, Limits{},
+			func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+				lock.Lock()
+				defer lock.Unlock()
+				rows = append(rows, convertBatchToAnyArray(batch, schema)...)
+				if last {
+					lastBatchCount++
+					if lastBatchCount == numLastBatches {
+						done.Done()
+					}
+				}
+				return nil
+			})
+		require.NoError(t, err)
+		if executed {
+			done.Wait()
+		}
+		return executed, rows
+	}
+
+	// Writes to the table aren't tracked, so it is always executed
+	executed, rows := executeIfChanged(12)
+	require.True(t, executed)
+	checkQueryResults(t, keyCols, schema, rows, data, nil)
+
+	slab := slInfoProvider.GetStream("test_slab1").UserSlab
+	knownFrom := &atomic.Int64{}
+	knownFrom.Store(math.MaxInt64)
+	slab.TrackWrites(knownFrom)
+	// Until the writes are known, e.g. after a restart, the table may have changed at any version
+	executed, rows = executeIfChanged(12)
+	require.True(t, executed)
+	checkQueryResults(t, keyCols, schema, rows, data, nil)
+
+	knownFrom.Store(8)
+	slab.RecordWrite(10)
+	executed, _ = executeIfChanged(12)
+	require.False(t, executed)
+	executed, _ = executeIfChanged(10)
+	require.False(t, executed)
+	executed, rows = executeIfChanged(9)
+	require.True(t, executed)
+	checkQueryResults(t, keyCols, schema, rows, data, nil)
+	// Writes before the version they are known from are unknown
+	knownFrom.Store(11)
+	executed, _ = executeIfChanged(10)
+	require.True(t, executed)
+	executed, _ = executeIfChanged(11)
+	require.False(t, executed)
+
+	slab.RecordWrite(13)
+	// An older write doesn't lower the version
+	slab.RecordWrite(11)
+	executed, rows = executeIfChanged(12)
+	require.True(t, executed)
+	checkQueryResults(t, keyCols, schema, rows, data, nil)
+}
+
+func TestExecuteQueryDirectByKey(t *testing.T) {
+	data := [][]any{
+		{int64(0), "foo0"},
+		{int64(1), "foo1"},
+		{int64(2), "foo2"},
+		{int64(3), "foo3"},
+	}
+	keyCols := []int{0}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlabWithVersion(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st, 10)
+	for _, mgrPair := range ctx.qms {
+		mgrPair.qm.SetLastCompletedVersion(13)
+	}
+	keyOf := func(key int64) string {
+		return string(encoding.KeyEncodeInt([]byte{1}, key))
+	}
+	resultsOf := func(results *KeyedResults) map[string][]any {
+		rows := map[string][]any{}
+		for key, batch := range results.Rows {
+			if batch == nil {
+				rows[key] = nil
+				continue
+			}
+			require.Equal(t, 1, batch.RowCount)
+			rows[key] = convertBatchToAnyArray(batch, results.Schema)[0]
+		}
+		return rows
+	}
+
+	tsl := `(scan all from test_slab1) -> (filter by f0 > 0) -> (project f1)`
+	query, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	results, ok, err := ctx.qms[0].qm.ExecuteQueryDirectByKey(tsl, *query, 13, Limits{})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []string{"f1"}, results.Schema.ColumnNames())
+	require.Equal(t, map[string][]any{
+		keyOf(1): {"foo1"},
+		keyOf(2): {"foo2"},
+		keyOf(3): {"foo3"},
+	}, resultsOf(results))
+
+	// Writes to the table aren't tracked, so the changed keys aren't known
+	_, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 12, 13)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	slab := slInfoProvider.GetStream("test_slab1").UserSlab
+	knownFrom := &atomic.Int64{}
+	knownFrom.Store(math.MaxInt64)
+	slab.TrackWrites(knownFrom)
+	// Until the writes are known, e.g. after a restart, the changed keys aren't known
+	_, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 12, 13)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	knownFrom.Store(8)
+	slab.RecordWrite(10, []byte(keyOf(0)), []byte(keyOf(2)))
+	slab.RecordWrite(11, []byte(keyOf(5)))
+	results, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 12, 13)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 0, len(results.Rows))
+
+	// Each key written is in the results - a key whose row doesn't match the filter, or doesn't exist, has no results
+	results, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 9, 13)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, map[string][]any{
+		keyOf(0): nil,
+		keyOf(2): {"foo2"},
+		keyOf(5): nil,
+	}, resultsOf(results))
+
+	results, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 10, 13)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, map[string][]any{keyOf(5): nil}, resultsOf(results))
+
+	// Only a scan followed by filters and projections can be executed by key
+	tsl = `(scan all from test_slab1) -> (sort by f1)`
+	query, err = parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	_, ok, err = ctx.qms[0].qm.ExecuteQueryDirectByKey(tsl, *query, 13, Limits{})
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = ctx.qms[0].qm.ExecuteQueryDirectForChangedKeys(*query, 9, 13)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestExecuteQueryDirectWithArgs(t *testing.T) {
+	var data [][]any
+	for i := 0; i < 10; i++ {
+		data = append(data, []any{int64(i), fmt.Sprintf("foo%d", i)})
+	}
+	keyCols := []int{0}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlab(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st)
+	for _, mgrPair := range ctx.qms {
+		mgrPair.qm.SetLastCompletedVersion(0)
+	}
+	tsl := `prepare test_query1 := (scan all from test_slab1)->(filter by f0 >= $p1:int)`
+	tslDesc, err := parser.NewParser(nil).ParseTSL(tsl)
+	require.NoError(t, err)
+	mgr := ctx.qms[0].qm
+	var lock sync.Mutex
+	var rows [][]any
+	var done sync.WaitGroup
+	done.Add(1)
+	lastBatchCount := 0
+	// The query is executed on all nodes with the args, without being prepared
+	err = mgr.ExecuteQueryDirectWithArgs(tsl, *tslDesc.PrepareQuery, []any{int64(7)}, Limits{},
+		func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+			require.NoError(t, err)
+			lock.Lock()
+			defer lock.Unlock()
+			rows = append(rows, convertBatchToAnyArray(batch, schema)...)
+			if last {
+				lastBatchCount++
+				if lastBatchCount == numLastBatches {
+					done.Done()
+				}
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	done.Wait()
+	checkQueryResults(t, keyCols, schema, rows, data[7:], nil)
+	for _, mgrPair := range ctx.qms {
+		require.Nil(t, mgrPair.qm.GetPreparedQueryParamSchema("test_query1"))
+	}
+
+	err = mgr.ExecuteQueryDirectWithArgs(tsl, *tslDesc.PrepareQuery, nil, Limits{},
+		func(bool, int, *evbatch.Batch, error) error {
+			return nil
+		})
+	require.Error(t, err)
+	require.Equal(t, "query requires 1 args but 0 were provided", err.Error())
+}
+
 func TestQueryFailsRemotingError(t *testing.T) {
 	ctx := setupForQueryFailureTests(t)
 	defer ctx.tearDown(t)
@@ -696,7 +1149,7 @@ func TestQueryFailsRemotingError(t *testing.T) {
 		mgrPair.tm.SetUnavailable()
 	}
 
-	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query1", []any{int64(1)}, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query1", []any{int64(1)}, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		return nil
 	})
 	require.Error(t, err)
@@ -720,7 +1173,7 @@ func TestQueryFailsNotReadyWithVersion(t *testing.T) {
 	ctx := setupForQueryFailureTestsWithClusterVersionProvider(t, versionProvider)
 	defer ctx.tearDown(t)
 
-	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query1", []any{int64(1)}, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query1", []any{int64(1)}, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		return nil
 	})
 	require.Error(t, err)
@@ -876,7 +1329,7 @@ func TestQMSort(t *testing.T) {
 	var lock sync.Mutex
 	var done sync.WaitGroup
 	done.Add(1)
-	numParts, err := mgr.ExecutePreparedQuery("test_query1", nil, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	numParts, err := mgr.ExecutePreparedQuery("test_query1", nil, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		rows := convertBatchToAnyArray(batch, schema)
 		lock.Lock()
 		defer lock.Unlock()
@@ -902,6 +1355,832 @@ func TestQMSort(t *testing.T) {
 	require.Equal(t, expectedOut, results)
 }
 
+func TestQMAggregate(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2), sum(f2), min(f2), max(f2), avg(f3) by f1) -> (sort by f1)`
+	results, schema := executeAggregateQuery(t, tsl)
+	require.Equal(t, []string{"f1", "count(f2)", "sum(f2)", "min(f2)", "max(f2)", "avg(f3)"}, schema.ColumnNames())
+	expected := [][]any{
+		{nil, int64(1), int64(130), int64(130), int64(130), float64(11)},
+		{"failed", int64(3), int64(60), int64(10), int64(30), float64(2)},
+		{"pending", int64(2), int64(90), int64(40), int64(50), float64(4.5)},
+		{"succeeded", int64(4), int64(360), int64(60), int64(120), float64(7.5)},
+	}
+	require.Equal(t, expected, results)
+}
+
+func TestQMAggregateNoKey(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) as num, sum(f2) as tot)`
+	results, schema := executeAggregateQuery(t, tsl)
+	require.Equal(t, []string{"num", "tot"}, schema.ColumnNames())
+	require.Equal(t, [][]any{{int64(10), int64(640)}}, results)
+}
+
+func TestQMAggregateWithFilterAndProjectAfter(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f2 > 10) -> (aggregate count(f2) as num, sum(f2) as tot by f1) -> (filter by num > 1) -> (project f1, tot * 2 as double_tot) -> (sort by double_tot desc)`
+	results, schema := executeAggregateQuery(t, tsl)
+	require.Equal(t, []string{"f1", "double_tot"}, schema.ColumnNames())
+	expected := [][]any{
+		{"succeeded", int64(720)},
+		{"pending", int64(180)},
+		{"failed", int64(100)},
+	}
+	require.Equal(t, expected, results)
+}
+
+func TestQMAggregateNotSupportedArgs(t *testing.T) {
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1 size=1m hop=10s)`,
+		`'size' is not supported for an aggregate in a query (line 1 column 81):
+prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1 size=1m hop=10s)
+                                                                                ^`)
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1 store=false)`,
+		`'store' is not supported for an aggregate in a query (line 1 column 81):
+prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1 store=false)
+                                                                                ^`)
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1) -> (aggregate count(f1))`,
+		`only one aggregate is allowed in a query (line 1 column 86):
+prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1) -> (aggregate count(f1))
+                                                                                     ^`)
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (aggregate f2 by f1)`,
+		`'f2' is not a valid aggregate expression. must be one of 'avg(<expr>)', 'count(<expr>)', 'max(<expr>)', 'min(<expr>)' or 'sum(<expr>)' (line 1 column 65):
+prepare test_query1 := (scan all from test_slab1) -> (aggregate f2 by f1)
+                                                                ^`)
+}
+
+func testQMAggregateError(t *testing.T, tsl string, expectedMsg string) {
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID, aggregateQuerySchema(), defaultNumPartitions, []int{0})
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	ast, err := parser.NewParser(nil).ParseTSL(tsl)
+	require.NoError(t, err)
+	err = ctx.qms[0].qm.PrepareQuery(*ast.PrepareQuery)
+	require.Error(t, err)
+	require.Equal(t, expectedMsg, err.Error())
+}
+
+func aggregateQuerySchema() *evbatch.EventSchema {
+	columnTypes := []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeInt, types.ColumnTypeFloat}
+	return evbatch.NewEventSchema([]string{"f0", "f1", "f2", "f3"}, columnTypes)
+}
+
+func executeAggregateQuery(t *testing.T, tsl string) ([][]any, *evbatch.EventSchema) {
+	data := [][]any{
+		{int64(0), "succeeded", int64(60), float64(6)},
+		{int64(1), "failed", int64(10), float64(1)},
+		{int64(2), "pending", int64(40), float64(4)},
+		{int64(3), "succeeded", int64(80), float64(7)},
+		{int64(4), "failed", int64(20), float64(2)},
+		{int64(5), "succeeded", int64(100), float64(8)},
+		{int64(6), "pending", int64(50), float64(5)},
+		{int64(7), "failed", int64(30), float64(3)},
+		{int64(8), "succeeded", int64(120), float64(9)},
+		{int64(9), nil, int64(130), float64(11)},
+	}
+	keyCols := []int{0}
+	schema := aggregateQuerySchema()
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlab(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st)
+	prepareQuery(t, tsl, ctx)
+	mgr := ctx.qms[rand.Intn(len(ctx.qms))].qm
+	var results [][]any
+	var resultsSchema *evbatch.EventSchema
+	var lock sync.Mutex
+	var done sync.WaitGroup
+	done.Add(1)
+	numParts, err := mgr.ExecutePreparedQuery("test_query1", nil, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		lock.Lock()
+		defer lock.Unlock()
+		// The partial results are merged so there is only a single batch
+		require.Nil(t, results)
+		require.True(t, last)
+		require.Equal(t, 1, numLastBatches)
+		results = convertBatchToAnyArray(batch, batch.Schema)
+		resultsSchema = batch.Schema
+		done.Done()
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, defaultNumPartitions, numParts)
+	done.Wait()
+	return results, resultsSchema
+}
+
+func TestQMLimitPages(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (limit 4)`
+	pages, pageStarts, qInfo := executeLimitQuery(t, tsl, 3)
+	require.Equal(t, 4, qInfo.Limit)
+	// The limit is pushed down to the remote nodes
+	require.IsType(t, &opers.LimitOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-2])
+	// And subsequent pages resume the scan from the key of the last row of the previous page
+	require.Equal(t, 3, len(pageStarts))
+	for _, pageStart := range pageStarts[1:] {
+		require.Equal(t, 0, pageStart.Offset)
+		require.NotNil(t, pageStart.AfterKey)
+		require.NotNil(t, pageStart.ScanFromKey)
+	}
+	expected := [][][]any{
+		{
+			{int64(0), "succeeded", int64(60), float64(6)},
+			{int64(1), "failed", int64(10), float64(1)},
+			{int64(2), "pending", int64(40), float64(4)},
+			{int64(3), "succeeded", int64(80), float64(7)},
+		},
+		{
+			{int64(4), "failed", int64(20), float64(2)},
+			{int64(5), "succeeded", int64(100), float64(8)},
+			{int64(6), "pending", int64(50), float64(5)},
+			{int64(7), "failed", int64(30), float64(3)},
+		},
+		{
+			{int64(8), "succeeded", int64(120), float64(9)},
+			{int64(9), nil, int64(130), float64(11)},
+		},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMLimitWithOffsetAndFilter(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f2 > 20) -> (project f0, f2) -> (limit 2 offset 1)`
+	pages, _, qInfo := executeLimitQuery(t, tsl, 2)
+	require.IsType(t, &opers.LimitOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-2])
+	expected := [][][]any{
+		{
+			{int64(2), int64(40)},
+			{int64(3), int64(80)},
+		},
+		{
+			{int64(5), int64(100)},
+			{int64(6), int64(50)},
+		},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMLimitWithoutKeyCols(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (project f2) -> (limit 3)`
+	pages, _, qInfo := executeLimitQuery(t, tsl, 2)
+	// The key isn't in the results so the limit can't be pushed down, and the results are ordered by all columns
+	require.IsType(t, &networkResultsOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-1])
+	require.IsType(t, &opers.ProjectOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-2])
+	expected := [][][]any{
+		{{int64(10)}, {int64(20)}, {int64(30)}},
+		{{int64(40)}, {int64(50)}, {int64(60)}},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMLimitAliasOfKeyColumn(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (project f2 as f0) -> (limit 3)`
+	pages, _, qInfo := executeLimitQuery(t, tsl, 2)
+	// The f0 column has the name of the key column but not its values, so the limit can't be pushed down
+	require.IsType(t, &networkResultsOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-1])
+	require.IsType(t, &opers.ProjectOperator{}, qInfo.RemoteOperators[len(qInfo.RemoteOperators)-2])
+	expected := [][][]any{
+		{{int64(10)}, {int64(20)}, {int64(30)}},
+		{{int64(40)}, {int64(50)}, {int64(60)}},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMSortAndLimit(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (sort by f2 desc) -> (limit 3 offset 1)`
+	pages, pageStarts, _ := executeLimitQuery(t, tsl, 2)
+	// Sorted results are paged by offset
+	require.Equal(t, 3, pageStarts[1].Offset)
+	expected := [][][]any{
+		{
+			{int64(8), "succeeded", int64(120), float64(9)},
+			{int64(5), "succeeded", int64(100), float64(8)},
+			{int64(3), "succeeded", int64(80), float64(7)},
+		},
+		{
+			{int64(0), "succeeded", int64(60), float64(6)},
+			{int64(6), "pending", int64(50), float64(5)},
+			{int64(2), "pending", int64(40), float64(4)},
+		},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMAggregateAndLimit(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (aggregate count(f2) by f1) -> (limit 2)`
+	pages, _, _ := executeLimitQuery(t, tsl, 2)
+	expected := [][][]any{
+		{
+			{nil, int64(1)},
+			{"failed", int64(3)},
+		},
+		{
+			{"pending", int64(2)},
+			{"succeeded", int64(4)},
+		},
+	}
+	require.Equal(t, expected, pages)
+}
+
+func TestQMLimitNotLast(t *testing.T) {
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (limit 10) -> (filter by f2 > 10)`,
+		`limit must be the last operator in a query (line 1 column 55):
+prepare test_query1 := (scan all from test_slab1) -> (limit 10) -> (filter by f2 > 10)
+                                                      ^`)
+	testQMAggregateError(t, `prepare test_query1 := (scan all from test_slab1) -> (sort by f2) -> (filter by f2 > 10) -> (limit 10)`,
+		`sort must be the last operator in a query, or be followed by a limit (line 1 column 55):
+prepare test_query1 := (scan all from test_slab1) -> (sort by f2) -> (filter by f2 > 10) -> (limit 10)
+                                                      ^`)
+}
+
+// executeLimitQuery executes the query a page at a time until the last page, or numPages pages, have been returned,
+// and returns the results and start of each page
+func executeLimitQuery(t *testing.T, tsl string, numPages int) ([][][]any, []opers.PageStart, *QInfo) {
+	data := [][]any{
+		{int64(0), "succeeded", int64(60), float64(6)},
+		{int64(1), "failed", int64(10), float64(1)},
+		{int64(2), "pending", int64(40), float64(4)},
+		{int64(3), "succeeded", int64(80), float64(7)},
+		{int64(4), "failed", int64(20), float64(2)},
+		{int64(5), "succeeded", int64(100), float64(8)},
+		{int64(6), "pending", int64(50), float64(5)},
+		{int64(7), "failed", int64(30), float64(3)},
+		{int64(8), "succeeded", int64(120), float64(9)},
+		{int64(9), nil, int64(130), float64(11)},
+	}
+	keyCols := []int{0}
+	schema := aggregateQuerySchema()
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	// Use a small batch size so the limit is reached part way through the rows of a partition
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, 1, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlab(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st)
+	prepareQuery(t, tsl, ctx)
+	var pages [][][]any
+	var pageStarts []opers.PageStart
+	pageStart := &opers.PageStart{}
+	for len(pages) < numPages && pageStart != nil {
+		mgr := ctx.qms[rand.Intn(len(ctx.qms))].qm
+		var page [][]any
+		var next *opers.PageStart
+		var done sync.WaitGroup
+		done.Add(1)
+		_, err := mgr.ExecutePreparedQueryWithHighestVersion("test_query1", nil, 0, *pageStart, Limits{},
+			func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+				// The page is taken locally so there is only a single batch
+				require.True(t, last)
+				require.Equal(t, 1, numLastBatches)
+				page = convertBatchToAnyArray(batch, batch.Schema)
+				next = mgr.GetPreparedQueryNextPage("test_query1", *pageStart, batch)
+				done.Done()
+				return nil
+			})
+		require.NoError(t, err)
+		done.Wait()
+		pages = append(pages, page)
+		pageStarts = append(pageStarts, *pageStart)
+		pageStart = next
+	}
+	return pages, pageStarts, ctx.qms[0].qm.(*manager).getPreparedQuery("test_query1")
+}
+
+func TestQMGetWithIndex(t *testing.T) {
+	tsl := `prepare test_query1 := (get "failed" from test_slab1 index by (f1))`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID+1, qInfo.SlabInfo.SlabID)
+	require.False(t, qInfo.FullKeyLookup)
+	expected := [][]any{
+		{int64(1), "failed", int64(10), float64(1)},
+		{int64(4), "failed", int64(20), float64(2)},
+		{int64(7), "failed", int64(30), float64(3)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMGetWithIndexMultipleColumns(t *testing.T) {
+	tsl := `prepare test_query1 := (get "succeeded", 80 from test_slab1 index by (f1, f2))`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID+2, qInfo.SlabInfo.SlabID)
+	require.Equal(t, [][]any{{int64(3), "succeeded", int64(80), float64(7)}}, rows)
+}
+
+func TestQMFilterUsesIndex(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f2 > 45 && f1 == "pending")`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID+1, qInfo.SlabInfo.SlabID)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.False(t, getOper.isRange)
+	require.Equal(t, [][]any{{int64(6), "pending", int64(50), float64(5)}}, rows)
+}
+
+func TestQMFilterUsesIndexWithMostColumns(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by "succeeded" == f1 && f2 == 100)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID+2, qInfo.SlabInfo.SlabID)
+	require.Equal(t, [][]any{{int64(5), "succeeded", int64(100), float64(8)}}, rows)
+}
+
+func TestQMFilterDoesNotUseIndex(t *testing.T) {
+	// f2 is not a leading column of an index, and f1 is not compared to a constant
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f2 == 60 || f1 == "pending")`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID, qInfo.SlabInfo.SlabID)
+	require.True(t, qInfo.RemoteOperators[0].(*GetOperator).isRange)
+	expected := [][]any{
+		{int64(0), "succeeded", int64(60), float64(6)},
+		{int64(2), "pending", int64(40), float64(4)},
+		{int64(6), "pending", int64(50), float64(5)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMFilterAndProjectPushedDown(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f2 > 30) -> (filter by f1 != "failed") -> (project f0, f2)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	// The filters are applied by the get, so they are not in the remote operators
+	require.Equal(t, 3, len(qInfo.RemoteOperators))
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Equal(t, 2, len(getOper.filters))
+	require.IsType(t, &opers.ProjectOperator{}, qInfo.RemoteOperators[1])
+	// Row cols are f1, f2, f3 - f3 is not used by the query
+	require.Equal(t, []bool{true, true, false}, getOper.filterRowCols)
+	require.Equal(t, []bool{true, true, false}, getOper.selectedRowCols)
+	expected := [][]any{
+		{int64(0), int64(60)},
+		{int64(2), int64(40)},
+		{int64(3), int64(80)},
+		{int64(5), int64(100)},
+		{int64(6), int64(50)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMRequiredColumnsForAggregate(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (aggregate sum(f3) by f1)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Nil(t, getOper.filters)
+	require.Equal(t, []bool{true, false, true}, getOper.selectedRowCols)
+	expected := [][]any{
+		{"failed", float64(6)},
+		{"pending", float64(9)},
+		{"succeeded", float64(21)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMNoRequiredColumnsPushedDownWithoutProject(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f3 < 3.0f)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Nil(t, getOper.selectedRowCols)
+	require.Equal(t, []bool{false, false, true}, getOper.filterRowCols)
+	expected := [][]any{
+		{int64(1), "failed", int64(10), float64(1)},
+		{int64(4), "failed", int64(20), float64(2)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMKeyRangeFromFilter(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 >= 3 && f0 < 6)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.True(t, getOper.isRange)
+	require.Equal(t, 1, len(getOper.rangeStartExprs))
+	require.Equal(t, 1, len(getOper.rangeEndExprs))
+	require.True(t, getOper.startInclusive)
+	require.False(t, getOper.endInclusive)
+	expected := [][]any{
+		{int64(3), "succeeded", int64(80), float64(7)},
+		{int64(4), "failed", int64(20), float64(2)},
+		{int64(5), "succeeded", int64(100), float64(8)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMKeyRangeFromFilterFlipped(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by 5 < f0)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Equal(t, 1, len(getOper.rangeStartExprs))
+	require.Nil(t, getOper.rangeEndExprs)
+	require.False(t, getOper.startInclusive)
+	expected := [][]any{
+		{int64(6), "pending", int64(50), float64(5)},
+		{int64(7), "failed", int64(30), float64(3)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMIndexPreferredToKeyRange(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 <= 3 && f1 == "succeeded")`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	// There's no equality on the key, so the index on f1 is preferred
+	require.Equal(t, defaultSlabID+1, qInfo.SlabInfo.SlabID)
+	expected := [][]any{
+		{int64(0), "succeeded", int64(60), float64(6)},
+		{int64(3), "succeeded", int64(80), float64(7)},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestQMKeyEqualityPreferredToIndex(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f1 == "failed" && f0 == 4)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	require.Equal(t, defaultSlabID, qInfo.SlabInfo.SlabID)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.True(t, getOper.startInclusive)
+	require.True(t, getOper.endInclusive)
+	require.Equal(t, [][]any{{int64(4), "failed", int64(20), float64(2)}}, rows)
+}
+
+func TestQMKeyRangeFromFilterWithParam(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 > $p1:int)`
+	rows, qInfo := executeIndexQuery(t, tsl, int64(5))
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Equal(t, 1, len(getOper.rangeStartExprs))
+	require.True(t, getOper.keyFromConditions)
+	expected := [][]any{
+		{int64(6), "pending", int64(50), float64(5)},
+		{int64(7), "failed", int64(30), float64(3)},
+	}
+	require.Equal(t, expected, rows)
+
+	// A comparison with null is never true, so a null bound matches no rows rather than leaving the range unbounded
+	rows, _ = executeIndexQuery(t, tsl, nil)
+	require.Equal(t, 0, len(rows))
+	tsl = `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 >= 2 && f0 < $p1:int)`
+	rows, _ = executeIndexQuery(t, tsl, nil)
+	require.Equal(t, 0, len(rows))
+	tsl = `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 == $p1:int)`
+	rows, _ = executeIndexQuery(t, tsl, nil)
+	require.Equal(t, 0, len(rows))
+	// The same for an index lookup
+	tsl = `prepare test_query1 := (scan all from test_slab1) -> (filter by f1 == $p1:string)`
+	rows, qInfo = executeIndexQuery(t, tsl, nil)
+	require.Equal(t, defaultSlabID+1, qInfo.SlabInfo.SlabID)
+	require.Equal(t, 0, len(rows))
+}
+
+func TestQMKeyRangeNotUsedForColumnComparison(t *testing.T) {
+	tsl := `prepare test_query1 := (scan all from test_slab1) -> (filter by f0 > f2)`
+	rows, qInfo := executeIndexQuery(t, tsl)
+	getOper := qInfo.RemoteOperators[0].(*GetOperator)
+	require.Nil(t, getOper.rangeStartExprs)
+	require.Nil(t, getOper.rangeEndExprs)
+	require.Equal(t, 0, len(rows))
+}
+
+func TestQMGetWithUnknownIndex(t *testing.T) {
+	slInfoProvider, _ := createIndexedStreamInfoProvider()
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	tsl := `prepare test_query1 := (get 10 from test_slab1 index by (f2))`
+	ast, err := parser.NewParser(nil).ParseTSL(tsl)
+	require.NoError(t, err)
+	err = ctx.qms[0].qm.PrepareQuery(*ast.PrepareQuery)
+	require.Error(t, err)
+	require.Equal(t, `table 'test_slab1' has no index on columns (f2) (line 1 column 48):
+prepare test_query1 := (get 10 from test_slab1 index by (f2))
+                                               ^`, err.Error())
+}
+
+// createIndexedStreamInfoProvider creates a table with key f0, an index on f1 and an index on f1, f2
+func createIndexedStreamInfoProvider() (*testStreamInfoProvider, [][]int) {
+	schema := aggregateQuerySchema()
+	slInfoProvider, _ := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, []int{0})
+	tableSlab := slInfoProvider.(*testStreamInfoProvider).streams["test_slab1"].UserSlab
+	indexKeyCols := [][]int{{1, 0}, {1, 2, 0}}
+	for i, indexCols := range [][]string{{"f1"}, {"f1", "f2"}} {
+		tableSlab.Indexes = append(tableSlab.Indexes, &opers.IndexInfo{
+			IndexCols: indexCols,
+			Slab: &opers.SlabInfo{
+				StreamName:    "test_slab1",
+				SlabID:        defaultSlabID + i + 1,
+				Schema:        tableSlab.Schema,
+				KeyColIndexes: indexKeyCols[i],
+				Type:          opers.SlabTypeInternal,
+			},
+		})
+	}
+	return slInfoProvider.(*testStreamInfoProvider), indexKeyCols
+}
+
+// executeIndexQuery executes the query with the args against a table with secondary indexes and returns the results
+// ordered by the first column
+func executeIndexQuery(t *testing.T, tsl string, args ...any) ([][]any, *QInfo) {
+	data := [][]any{
+		{int64(0), "succeeded", int64(60), float64(6)},
+		{int64(1), "failed", int64(10), float64(1)},
+		{int64(2), "pending", int64(40), float64(4)},
+		{int64(3), "succeeded", int64(80), float64(7)},
+		{int64(4), "failed", int64(20), float64(2)},
+		{int64(5), "succeeded", int64(100), float64(8)},
+		{int64(6), "pending", int64(50), float64(5)},
+		{int64(7), "failed", int64(30), float64(3)},
+	}
+	schema := aggregateQuerySchema()
+	slInfoProvider, indexKeyCols := createIndexedStreamInfoProvider()
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows, slInfoProvider)
+	defer ctx.tearDown(t)
+	writeDataToSlab(t, defaultSlabID, schema, []int{0}, defaultNumPartitions, data, ctx.st)
+	for i, keyCols := range indexKeyCols {
+		writeDataToSlab(t, defaultSlabID+i+1, schema, keyCols, defaultNumPartitions, data, ctx.st)
+	}
+	return executeAndSortRows(t, tsl, ctx, args...)
+}
+
+// executeAndSortRows prepares and executes the query and returns the results ordered by the first column
+func executeAndSortRows(t *testing.T, tsl string, ctx *mgrCtx, args ...any) ([][]any, *QInfo) {
+	prepareQuery(t, tsl, ctx)
+	mgr := ctx.qms[rand.Intn(len(ctx.qms))].qm
+	var rows [][]any
+	var lock sync.Mutex
+	var done sync.WaitGroup
+	done.Add(1)
+	lastBatchCount := 0
+	_, err := mgr.ExecutePreparedQuery("test_query1", args, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		lock.Lock()
+		defer lock.Unlock()
+		rows = append(rows, convertBatchToAnyArray(batch, batch.Schema)...)
+		if last {
+			lastBatchCount++
+			if lastBatchCount == numLastBatches {
+				done.Done()
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	done.Wait()
+	sort.Slice(rows, func(i, j int) bool {
+		if v, ok := rows[i][0].(int64); ok {
+			return v < rows[j][0].(int64)
+		}
+		return fmt.Sprint(rows[i][0]) < fmt.Sprint(rows[j][0])
+	})
+	return rows, ctx.qms[0].qm.(*manager).getPreparedQuery("test_query1")
+}
+
+func TestQMJoinLookup(t *testing.T) {
+	rows, info := executeJoinQuery(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id = id)`)
+	require.Equal(t, 1, len(info.RemoteOperators[1:len(info.RemoteOperators)-1]))
+	join := info.RemoteOperators[1].(*JoinOperator)
+	require.False(t, join.broadcast)
+	require.Equal(t, []string{"l_order_id", "l_cust_id", "l_amount", "r_name", "r_country"},
+		info.ResultSchema.ColumnNames())
+	require.Equal(t, [][]any{
+		{int64(0), int64(10), int64(100), "alice", "uk"},
+		{int64(1), int64(11), int64(200), "bob", "us"},
+		{int64(2), int64(10), int64(300), "alice", "uk"},
+		{int64(4), int64(12), int64(500), "carol", "uk"},
+	}, rows)
+}
+
+func TestQMJoinLookupLeftOuter(t *testing.T) {
+	rows, _ := executeJoinQuery(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id *= id)`)
+	require.Equal(t, [][]any{
+		{int64(0), int64(10), int64(100), "alice", "uk"},
+		{int64(1), int64(11), int64(200), "bob", "us"},
+		{int64(2), int64(10), int64(300), "alice", "uk"},
+		{int64(3), int64(99), int64(400), nil, nil},
+		{int64(4), int64(12), int64(500), "carol", "uk"},
+		{int64(5), nil, int64(600), nil, nil},
+	}, rows)
+}
+
+func TestQMJoinBroadcast(t *testing.T) {
+	rows, info := executeJoinQuery(t,
+		`prepare test_query1 := (scan all from orders)->(join customers by cust_id = id strategy = broadcast)`)
+	require.True(t, info.RemoteOperators[1].(*JoinOperator).broadcast)
+	require.Equal(t, 1, len(info.BroadcastJoins))
+	require.Equal(t, [][]any{
+		{int64(0), int64(10), int64(100), "alice", "uk"},
+		{int64(1), int64(11), int64(200), "bob", "us"},
+		{int64(2), int64(10), int64(300), "alice", "uk"},
+		{int64(4), int64(12), int64(500), "carol", "uk"},
+	}, rows)
+}
+
+func TestQMJoinOnNonKeyColumnIsBroadcast(t *testing.T) {
+	// Each order joins with all customers in the same country
+	rows, info := executeJoinQuery(t,
+		`prepare test_query1 := (scan all from orders)->(join customers by cust_id = id)->(join customers by r_country = country)`)
+	require.False(t, info.RemoteOperators[1].(*JoinOperator).broadcast)
+	require.True(t, info.RemoteOperators[2].(*JoinOperator).broadcast)
+	require.Equal(t, []string{"l_l_order_id", "l_l_cust_id", "l_l_amount", "l_r_name", "l_r_country", "r_id", "r_name"},
+		info.ResultSchema.ColumnNames())
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i][0].(int64) != rows[j][0].(int64) {
+			return rows[i][0].(int64) < rows[j][0].(int64)
+		}
+		return rows[i][5].(int64) < rows[j][5].(int64)
+	})
+	require.Equal(t, [][]any{
+		{int64(0), int64(10), int64(100), "alice", "uk", int64(10), "alice"},
+		{int64(0), int64(10), int64(100), "alice", "uk", int64(12), "carol"},
+		{int64(1), int64(11), int64(200), "bob", "us", int64(11), "bob"},
+		{int64(2), int64(10), int64(300), "alice", "uk", int64(10), "alice"},
+		{int64(2), int64(10), int64(300), "alice", "uk", int64(12), "carol"},
+		{int64(4), int64(12), int64(500), "carol", "uk", int64(10), "alice"},
+		{int64(4), int64(12), int64(500), "carol", "uk", int64(12), "carol"},
+	}, rows)
+}
+
+func TestQMJoinThenAggregate(t *testing.T) {
+	rows, _ := executeJoinQuery(t,
+		`prepare test_query1 := (scan all from orders)->(join customers by cust_id = id)->(aggregate sum(l_amount) by r_country)`)
+	require.Equal(t, [][]any{
+		{"uk", int64(900)},
+		{"us", int64(200)},
+	}, rows)
+}
+
+func TestQMJoinLookupBatchesKeysPerNode(t *testing.T) {
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows,
+		createJoinStreamInfoProvider())
+	defer ctx.tearDown(t)
+	var customers [][]any
+	for i := 0; i < 50; i++ {
+		customers = append(customers, []any{int64(i), fmt.Sprintf("customer-%d", i), "uk"})
+	}
+	writeDataToSlab(t, defaultSlabID+1, joinCustomersSchema, []int{0}, defaultNumPartitions, customers, ctx.st)
+	prepareQuery(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id = id)`, ctx)
+	mgr := ctx.qms[0].qm.(*manager)
+	join := mgr.getPreparedQuery("test_query1").RemoteOperators[1].(*JoinOperator)
+
+	// Each customer is ordered twice, and there is an order with an unknown customer
+	builders := evbatch.CreateColBuilders(joinOrdersSchema.ColumnTypes())
+	for i := 0; i < 101; i++ {
+		builders[0].(*evbatch.IntColBuilder).Append(int64(i))
+		builders[1].(*evbatch.IntColBuilder).Append(int64(i / 2))
+		builders[2].(*evbatch.IntColBuilder).Append(int64(100))
+	}
+	batch := evbatch.NewBatchFromBuilders(joinOrdersSchema, builders...)
+	table, err := join.lookupRows(batch, 0)
+	require.NoError(t, err)
+	require.Equal(t, 50, len(table.rows))
+	for i := 0; i < 50; i++ {
+		key := evbatch.EncodeKeyCols(batch, i*2, []int{1}, nil)
+		rows := table.rows[string(key)]
+		require.Equal(t, 1, len(rows))
+		require.Equal(t, fmt.Sprintf("customer-%d", i), rows[0].batch.GetStringColumn(1).Get(rows[0].rowIndex))
+	}
+	// The keys on other nodes are looked up with a single message to each node
+	require.Equal(t, int64(defaultNumManagers-1), ctx.qms[0].tm.numJoinLookups.Load())
+}
+
+func TestQMJoinBroadcastTooManyRows(t *testing.T) {
+	prevMax := maxBroadcastJoinRows
+	maxBroadcastJoinRows = 2
+	defer func() {
+		maxBroadcastJoinRows = prevMax
+	}()
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows,
+		createJoinStreamInfoProvider())
+	defer ctx.tearDown(t)
+	customers := [][]any{
+		{int64(10), "alice", "uk"},
+		{int64(11), "bob", "us"},
+		{int64(12), "carol", "uk"},
+	}
+	writeDataToSlab(t, defaultSlabID+1, joinCustomersSchema, []int{0}, defaultNumPartitions, customers, ctx.st)
+	prepareQuery(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id = id strategy = broadcast)`,
+		ctx)
+	_, err := ctx.qms[0].qm.ExecutePreparedQuery("test_query1", nil, Limits{},
+		func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+			return nil
+		})
+	require.Error(t, err)
+	require.Equal(t, "cannot join with 'customers' - it has more than 2 rows so it cannot be broadcast. join with the key columns of the table to use a lookup join",
+		err.Error())
+}
+
+func TestQMJoinErrors(t *testing.T) {
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join unknown by cust_id = id)`,
+		`unknown table or stream 'unknown' (line 1 column 54):
+prepare test_query1 := (scan all from orders)->(join unknown by cust_id = id)
+                                                     ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join customers by foo = id)`,
+		`cannot join with column 'foo' - it is not a known column in the input stream (line 1 column 67):
+prepare test_query1 := (scan all from orders)->(join customers by foo = id)
+                                                                  ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id = name)`,
+		`cannot join columns 'cust_id' and 'name' - they have different types int and string (line 1 column 75):
+prepare test_query1 := (scan all from orders)->(join customers by cust_id = name)
+                                                                          ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join customers by cust_id =* id)`,
+		`right outer joins are not supported in queries (line 1 column 75):
+prepare test_query1 := (scan all from orders)->(join customers by cust_id =* id)
+                                                                          ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join customers by order_id = id, cust_id *= id)`,
+		"the same join type (one of `=`, `*=` or `=*`) must be used for all join expression (line 1 column 90):\n"+
+			`prepare test_query1 := (scan all from orders)->(join customers by order_id = id, cust_id *= id)
+                                                                                         ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(join customers by amount = country strategy = lookup)`,
+		`cannot join columns 'amount' and 'country' - they have different types int and string (line 1 column 74):
+prepare test_query1 := (scan all from orders)->(join customers by amount = country strategy = lookup)
+                                                                         ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from customers)->(join customers by name = name strategy = lookup)`,
+		`cannot use a lookup join - the join columns must be the key columns of table 'customers' (line 1 column 82):
+prepare test_query1 := (scan all from customers)->(join customers by name = name strategy = lookup)
+                                                                                 ^`)
+	testQMJoinError(t, `prepare test_query1 := (scan all from orders)->(aggregate count(amount) by cust_id)->(join customers by cust_id = id)`,
+		`join must come before any aggregate in a query (line 1 column 87):
+prepare test_query1 := (scan all from orders)->(aggregate count(amount) by cust_id)->(join customers by cust_id = id)
+                                                                                      ^`)
+}
+
+func testQMJoinError(t *testing.T, tsl string, expectedMsg string) {
+	ctx := setupQueryManagers(1, defaultNumPartitions, defaultMaxBatchRows, createJoinStreamInfoProvider())
+	defer ctx.tearDown(t)
+	ast, err := parser.NewParser(nil).ParseTSL(tsl)
+	require.NoError(t, err)
+	err = ctx.qms[0].qm.PrepareQuery(*ast.PrepareQuery)
+	require.Error(t, err)
+	require.Equal(t, expectedMsg, err.Error())
+}
+
+func TestQMSQLJoinAggregate(t *testing.T) {
+	rows, info := executeJoinQuery(t, compileSQL(t, `PREPARE test_query1 AS
+		SELECT c.country, count(*) AS num, sum(o.amount) AS total
+		FROM orders o JOIN customers c ON o.cust_id = c.id
+		WHERE o.amount >= 200
+		GROUP BY c.country`))
+	require.Equal(t, []string{"country", "num", "total"}, info.ResultSchema.ColumnNames())
+	require.Equal(t, [][]any{
+		{"uk", int64(2), int64(800)},
+		{"us", int64(1), int64(200)},
+	}, rows)
+}
+
+func TestQMSQLParamsAppliedByKeyLookup(t *testing.T) {
+	rows, info := executeJoinQuery(t, compileSQL(t, `PREPARE test_query1 (int, int) AS
+		SELECT o.order_id, c.name FROM orders o LEFT JOIN customers c ON o.cust_id = c.id
+		WHERE o.order_id >= $1 AND o.order_id < $2 AND o.amount > 200`), int64(1), int64(4))
+	// The conditions with params are applied by the key range of the scan, so only the condition on amount is
+	// pushed down to the scan as a filter
+	get := info.RemoteOperators[0].(*GetOperator)
+	require.Equal(t, 1, len(get.filters))
+	require.Equal(t, 1, len(get.rangeStartExprs))
+	require.Equal(t, [][]any{
+		{int64(2), "alice"},
+		{int64(3), nil},
+	}, rows)
+}
+
+func TestQMSQLParamNotOnKeyColumn(t *testing.T) {
+	testQMJoinError(t, compileSQL(t, `PREPARE test_query1 (int) AS SELECT * FROM orders WHERE amount = $1`),
+		`prepared statement parameters can only be used in a filter directly after a scan, in conditions on the key columns or indexed columns of the table (line 1 column 79):
+prepare test_query1($p1:int) := (scan all from orders)->(filter by (amount == $p1:int))
+                                                                              ^`)
+}
+
+func compileSQL(t *testing.T, sql string) string {
+	desc, err := parser.NewParser(nil).ParseSQL(sql)
+	require.NoError(t, err)
+	return desc.TSL
+}
+
+var joinOrdersSchema = evbatch.NewEventSchema([]string{"order_id", "cust_id", "amount"},
+	[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt, types.ColumnTypeInt})
+var joinCustomersSchema = evbatch.NewEventSchema([]string{"id", "name", "country"},
+	[]types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString, types.ColumnTypeString})
+
+// createJoinStreamInfoProvider creates an orders table with key order_id and a customers table with key id
+func createJoinStreamInfoProvider() *testStreamInfoProvider {
+	slInfoProvider, _ := createStreamInfoProvider("orders", defaultSlabID, joinOrdersSchema, defaultNumPartitions,
+		[]int{0})
+	customersProvider, _ := createStreamInfoProvider("customers", defaultSlabID+1, joinCustomersSchema,
+		defaultNumPartitions, []int{0})
+	streams := slInfoProvider.(*testStreamInfoProvider).streams
+	streams["customers"] = customersProvider.(*testStreamInfoProvider).streams["customers"]
+	return slInfoProvider.(*testStreamInfoProvider)
+}
+
+// executeJoinQuery executes the query against the orders and customers tables and returns the results ordered by the
+// first column
+func executeJoinQuery(t *testing.T, tsl string, args ...any) ([][]any, *QInfo) {
+	orders := [][]any{
+		{int64(0), int64(10), int64(100)},
+		{int64(1), int64(11), int64(200)},
+		{int64(2), int64(10), int64(300)},
+		{int64(3), int64(99), int64(400)},
+		{int64(4), int64(12), int64(500)},
+		{int64(5), nil, int64(600)},
+	}
+	customers := [][]any{
+		{int64(10), "alice", "uk"},
+		{int64(11), "bob", "us"},
+		{int64(12), "carol", "uk"},
+		{int64(13), "dave", "fr"},
+	}
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows,
+		createJoinStreamInfoProvider())
+	defer ctx.tearDown(t)
+	writeDataToSlab(t, defaultSlabID, joinOrdersSchema, []int{0}, defaultNumPartitions, orders, ctx.st)
+	writeDataToSlab(t, defaultSlabID+1, joinCustomersSchema, []int{0}, defaultNumPartitions, customers, ctx.st)
+	return executeAndSortRows(t, tsl, ctx, args...)
+}
+
 func createDecimal(t *testing.T, str string, precision int, scale int) types.Decimal {
 	num, err := decimal128.FromString(str, int32(precision), int32(scale))
 	require.NoError(t, err)
@@ -987,6 +2266,241 @@ func TestDirectQueryGetRange(t *testing.T) {
 	testDirectQuery(t, tsl, data, expected, columnTypes, keyCols, keyColTypes)
 }
 
+func TestQueryMaxRowsScanned(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 500)
+	defer ctx.tearDown(t)
+	tsl := "(scan all from test_slab1)"
+	err := executeDirectQueryForError(t, ctx.qms[0].qm, tsl, Limits{MaxRowsScanned: 50})
+	require.Equal(t, "query exceeded the maximum of 50 rows scanned and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+
+	// A query that is within the limit succeeds
+	testDirectQueryWithLimits(t, ctx.qms[0].qm, tsl, Limits{MaxRowsScanned: 1000}, 500)
+}
+
+func TestQueryMaxMemoryBytes(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 500)
+	defer ctx.tearDown(t)
+	tsl := "(scan all from test_slab1) -> (sort by f1)"
+	err := executeDirectQueryForError(t, ctx.qms[0].qm, tsl, Limits{MaxMemoryBytes: 1000})
+	require.Equal(t, "query exceeded the maximum memory of 1000 bytes and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+
+	// The memory limit only applies to queries that hold all their results in memory
+	testDirectQueryWithLimits(t, ctx.qms[0].qm, "(scan all from test_slab1)", Limits{MaxMemoryBytes: 1000}, 500)
+}
+
+func TestQueryMaxRowsScannedAcrossNodes(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 500)
+	defer ctx.tearDown(t)
+	// Each node scans fewer than 300 rows, but the query scans 500 in total
+	tsl := "(scan all from test_slab1)"
+	err := executeDirectQueryForError(t, ctx.qms[0].qm, tsl, Limits{MaxRowsScanned: 300})
+	require.Equal(t, "query exceeded the maximum of 300 rows scanned and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+}
+
+func TestQueryMaxMemoryBytesAggregate(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 500)
+	defer ctx.tearDown(t)
+	// Each distinct value of f1 is a separate group held in memory
+	tsl := "(scan all from test_slab1) -> (aggregate count(f0) by f1)"
+	err := executeDirectQueryForError(t, ctx.qms[0].qm, tsl, Limits{MaxMemoryBytes: 1000})
+	require.Equal(t, "query exceeded the maximum memory of 1000 bytes and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+
+	// An aggregation with no key columns only holds a single group
+	testDirectQueryWithLimits(t, ctx.qms[0].qm, "(scan all from test_slab1) -> (aggregate count(f0))",
+		Limits{MaxMemoryBytes: 1000}, 1)
+}
+
+func TestQueryMaxMemoryBytesBroadcastJoin(t *testing.T) {
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, defaultMaxBatchRows,
+		createJoinStreamInfoProvider())
+	defer ctx.tearDown(t)
+	var customers [][]any
+	for i := 0; i < 100; i++ {
+		customers = append(customers, []any{int64(i), fmt.Sprintf("customer-%d", i), "uk"})
+	}
+	writeDataToSlab(t, defaultSlabID+1, joinCustomersSchema, []int{0}, defaultNumPartitions, customers, ctx.st)
+	tsl := "(scan all from orders) -> (join customers by cust_id = id strategy = broadcast)"
+	queryDesc, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	err = ctx.qms[0].qm.ExecuteQueryDirect(tsl, *queryDesc, Limits{MaxMemoryBytes: 1000},
+		func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+			return nil
+		})
+	require.Error(t, err)
+	require.Equal(t, "query exceeded the maximum memory of 1000 bytes and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 10)
+	defer ctx.tearDown(t)
+	// The results never arrive, so the query times out
+	for _, pair := range ctx.qms {
+		pair.tm.dropResponses.Store(true)
+	}
+	err := executeDirectQueryForError(t, ctx.qms[0].qm, "(scan all from test_slab1)",
+		Limits{Timeout: 100 * time.Millisecond})
+	require.Equal(t, "query exceeded the timeout of 100ms and has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+	requireQueryCancelledOnAllNodes(t, ctx)
+}
+
+func TestDefaultLimits(t *testing.T) {
+	defaults := Limits{Timeout: time.Minute, MaxRowsScanned: 1000, MaxMemoryBytes: 2000}
+	require.Equal(t, defaults, Limits{}.withDefaults(defaults))
+	require.Equal(t, Limits{Timeout: time.Second, MaxRowsScanned: 1000, MaxMemoryBytes: 3000},
+		Limits{Timeout: time.Second, MaxMemoryBytes: 3000}.withDefaults(defaults))
+	require.Equal(t, NoLimits, NoLimits.withDefaults(defaults))
+}
+
+func TestListAndCancelRunningQueries(t *testing.T) {
+	ctx := setupGuardrailsTest(t, 10)
+	defer ctx.tearDown(t)
+	for _, pair := range ctx.qms {
+		pair.tm.dropResponses.Store(true)
+	}
+	mgr := ctx.qms[0].qm
+	require.Equal(t, 0, len(mgr.RunningQueries()))
+
+	tsl := "(scan all from test_slab1)"
+	queryDesc, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	err = mgr.ExecuteQueryDirect(tsl, *queryDesc, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		if err != nil {
+			errCh <- err
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	running := mgr.RunningQueries()
+	require.Equal(t, 1, len(running))
+	require.Equal(t, tsl, running[0].TSL)
+	require.Equal(t, "", running[0].QueryName)
+	require.Equal(t, int64(0), running[0].Version)
+	require.False(t, running[0].StartTime.IsZero())
+	// The query is only listed on the node that received it
+	require.Equal(t, 0, len(ctx.qms[1].qm.RunningQueries()))
+
+	err = mgr.CancelQuery(running[0].ID)
+	require.NoError(t, err)
+	err = <-errCh
+	require.Equal(t, "query has been cancelled", err.Error())
+	requireNoRunningQueries(t, ctx)
+	requireQueryCancelledOnAllNodes(t, ctx)
+
+	err = mgr.CancelQuery(running[0].ID)
+	require.Error(t, err)
+	require.Equal(t, fmt.Sprintf("unknown query '%s'", running[0].ID), err.Error())
+}
+
+func setupGuardrailsTest(t *testing.T, numRows int) *mgrCtx {
+	var data [][]any
+	for i := 0; i < numRows; i++ {
+		data = append(data, []any{int64(i), fmt.Sprintf("foo%d", i)})
+	}
+	keyCols := []int{0}
+	schema := evbatch.NewEventSchema([]string{"f0", "f1"}, []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeString})
+	slInfoProvider, slabID := createStreamInfoProvider("test_slab1", defaultSlabID, schema, defaultNumPartitions, keyCols)
+	ctx := setupQueryManagers(defaultNumManagers, defaultNumPartitions, 10, slInfoProvider)
+	writeDataToSlab(t, slabID, schema, keyCols, defaultNumPartitions, data, ctx.st)
+	return ctx
+}
+
+func executeDirectQueryForError(t *testing.T, mgr Manager, tsl string, limits Limits) error {
+	queryDesc, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	var lock sync.Mutex
+	var lastBatchCount int
+	err = mgr.ExecuteQueryDirect(tsl, *queryDesc, limits, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			errCh <- err
+			return nil
+		}
+		if last {
+			lastBatchCount++
+			if lastBatchCount == numLastBatches {
+				errCh <- nil
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = <-errCh
+	require.Error(t, err)
+	var perr errors.TektiteError
+	require.True(t, errors.As(err, &perr))
+	require.Equal(t, errors.ExecuteQueryError, int(perr.Code))
+	return err
+}
+
+func testDirectQueryWithLimits(t *testing.T, mgr Manager, tsl string, limits Limits, expectedRows int) {
+	queryDesc, err := parser.NewParser(nil).ParseQuery(tsl)
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	var lock sync.Mutex
+	var lastBatchCount, numRows int
+	err = mgr.ExecuteQueryDirect(tsl, *queryDesc, limits, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			errCh <- err
+			return nil
+		}
+		numRows += batch.RowCount
+		if last {
+			lastBatchCount++
+			if lastBatchCount == numLastBatches {
+				errCh <- nil
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	require.Equal(t, expectedRows, numRows)
+}
+
+func requireNoRunningQueries(t *testing.T, ctx *mgrCtx) {
+	for _, pair := range ctx.qms {
+		require.Equal(t, 0, len(pair.qm.RunningQueries()))
+		require.Equal(t, 0, pair.qm.(*manager).HandlerCount())
+	}
+	// The query loaders on each node stop
+	testutils.WaitUntil(t, func() (bool, error) {
+		for _, pair := range ctx.qms {
+			count := 0
+			pair.qm.(*manager).remoteQueries.Range(func(_, _ any) bool {
+				count++
+				return true
+			})
+			if count > 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func requireQueryCancelledOnAllNodes(t *testing.T, ctx *mgrCtx) {
+	testutils.WaitUntil(t, func() (bool, error) {
+		for _, pair := range ctx.qms {
+			if pair.tm.numCancels.Load() == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
 func testDirectQuery(t *testing.T, tsl string, data [][]any, expected [][]any, columnTypes []types.ColumnType,
 	keyCols []int, keyColTypes []types.ColumnType) {
 	require.NotNil(t, data)
@@ -1010,7 +2524,7 @@ func testDirectQuery(t *testing.T, tsl string, data [][]any, expected [][]any, c
 	var done sync.WaitGroup
 	done.Add(1)
 	var lastBatchCount int
-	err = mgr.ExecuteQueryDirect(tsl, *queryDesc, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	err = mgr.ExecuteQueryDirect(tsl, *queryDesc, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		rows := convertBatchToAnyArray(batch, schema)
 		lock.Lock()
 		defer lock.Unlock()
@@ -1036,9 +2550,36 @@ func testDirectQuery(t *testing.T, tsl string, data [][]any, expected [][]any, c
 }
 
 type mgrCtx struct {
-	qms  []*mgrPair
-	st   *store.Store
-	tnpp *tppm.TestNodePartitionProvider
+	qms          []*mgrPair
+	st           *store.Store
+	tnpp         *tppm.TestNodePartitionProvider
+	versionIndex *testVersionIndex
+}
+
+type testVersionIndex struct {
+	lock         sync.Mutex
+	versionTimes map[int64]int64
+}
+
+func (t *testVersionIndex) setVersionForTime(timeMs int64, version int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.versionTimes[timeMs] = version
+}
+
+// GetVersionForTime returns the version stored for the latest time that is not after timeMs, like the level manager
+func (t *testVersionIndex) GetVersionForTime(timeMs int64) (int64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	version := int64(-1)
+	latest := int64(math.MinInt64)
+	for tm, ver := range t.versionTimes {
+		if tm <= timeMs && tm > latest {
+			latest = tm
+			version = ver
+		}
+	}
+	return version, nil
 }
 
 type mgrPair struct {
@@ -1103,11 +2644,12 @@ func setupQueryManagersWithClusterVersionProvider(numMgrs int, numPartitions int
 		addresses[i] = fmt.Sprintf("addr-%d", i)
 	}
 	p := parser.NewParser(nil)
+	versionIndex := &testVersionIndex{versionTimes: map[int64]int64{}}
 	for i := range pairs {
 		tm := newTestRemoting()
 		tm.start()
-		mgr := NewManager(npp, clustVersionProvider, i, slInfoProvider, st, st, tm, addresses, maxBatchRows,
-			&expr.ExpressionFactory{}, p)
+		mgr := NewManager(npp, clustVersionProvider, i, slInfoProvider, st, st, tm, addresses, maxBatchRows, Limits{},
+			&expr.ExpressionFactory{}, p, versionIndex)
 		pair := &mgrPair{
 			qm: mgr,
 			tm: tm,
@@ -1124,9 +2666,10 @@ func setupQueryManagersWithClusterVersionProvider(numMgrs int, numPartitions int
 		pair.tm.mgrsMap = mgrsMap
 	}
 	return &mgrCtx{
-		qms:  pairs,
-		st:   st,
-		tnpp: npp,
+		qms:          pairs,
+		st:           st,
+		tnpp:         npp,
+		versionIndex: versionIndex,
 	}
 }
 
@@ -1151,7 +2694,7 @@ func executeQueryFromMgr(t *testing.T, queryName string, evSchema *evbatch.Event
 	var done sync.WaitGroup
 	done.Add(1)
 	var lastBatchCount int
-	numParts, err := mgr.ExecutePreparedQuery(queryName, argVals, func(last bool, numLastBatches int, batch *evbatch.Batch) error {
+	numParts, err := mgr.ExecutePreparedQuery(queryName, argVals, Limits{}, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error {
 		rows := convertBatchToAnyArray(batch, evSchema)
 		lock.Lock()
 		defer lock.Unlock()
@@ -1218,9 +2761,12 @@ func newTestRemoting() *testRemoting {
 }
 
 type testRemoting struct {
-	mgrsMap     map[string]Manager
-	sendChannel chan sendInfo
-	unavailable atomic.Bool
+	mgrsMap        map[string]Manager
+	sendChannel    chan sendInfo
+	unavailable    atomic.Bool
+	dropResponses  atomic.Bool
+	numCancels     atomic.Int64
+	numJoinLookups atomic.Int64
 }
 
 func (t *testRemoting) SetUnavailable() {
@@ -1258,6 +2804,9 @@ func (t *testRemoting) SendQueryMessageAsync(completionFunc func(remoting.Cluste
 	if !ok {
 		panic("can't find manager")
 	}
+	if strings.HasPrefix(msg.QueryName, joinLookupQueryPrefix) {
+		t.numJoinLookups.Add(1)
+	}
 	t.sendChannel <- sendInfo{
 		mgr: mgr,
 		msg: msg,
@@ -1266,6 +2815,9 @@ func (t *testRemoting) SendQueryMessageAsync(completionFunc func(remoting.Cluste
 }
 
 func (t *testRemoting) SendQueryResponse(msg *clustermsgs.QueryResponse, serverAddress string) error {
+	if t.dropResponses.Load() {
+		return nil
+	}
 	mgr, ok := t.mgrsMap[serverAddress]
 	if !ok {
 		panic("can't find manager")
@@ -1274,6 +2826,32 @@ func (t *testRemoting) SendQueryResponse(msg *clustermsgs.QueryResponse, serverA
 	return nil
 }
 
+func (t *testRemoting) SendQueryCancel(msg *clustermsgs.QueryCancelMessage, serverAddress string) error {
+	mgr, ok := t.mgrsMap[serverAddress]
+	if !ok {
+		panic("can't find manager")
+	}
+	mgr.(*manager).remoting.(*testRemoting).numCancels.Add(1)
+	mgr.CancelRemoteQuery(msg)
+	return nil
+}
+
+func (t *testRemoting) SendQueryChangedMessageAsync(completionFunc func(remoting.ClusterMessage, error),
+	msg *clustermsgs.QueryChangedMessage, address string) {
+	mgr, ok := t.mgrsMap[address]
+	if !ok {
+		panic("can't find manager")
+	}
+	go func() {
+		resp, err := mgr.(*manager).tablesChanged(msg)
+		if err != nil {
+			completionFunc(nil, err)
+			return
+		}
+		completionFunc(resp, nil)
+	}()
+}
+
 func (t *testRemoting) Close() {
 }
 
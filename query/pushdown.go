@@ -0,0 +1,310 @@
+package query
+
+import (
+	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/expr"
+	"github.com/spirit-labs/tektite/opers"
+	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/types"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// comparison is a condition of the form 'col op value' in the conjunction of a filter expression. Conditions of the form
+// 'value op col' are flipped so the column is always on the left.
+type comparison struct {
+	colName   string
+	op        string
+	value     parser.ExprDesc
+	condition parser.ExprDesc
+}
+
+var flippedComparisonOps = map[string]string{
+	"==": "==",
+	">":  "<",
+	">=": "<=",
+	"<":  ">",
+	"<=": ">=",
+}
+
+// collectComparisons appends the comparisons between a column and another expression in the conjunction of the filter
+// expression to comparisons
+func collectComparisons(exprDesc parser.ExprDesc, comparisons []comparison) []comparison {
+	binary, ok := exprDesc.(*parser.BinaryOperatorExprDesc)
+	if !ok {
+		return comparisons
+	}
+	if binary.Op == "&&" {
+		comparisons = collectComparisons(binary.Left, comparisons)
+		return collectComparisons(binary.Right, comparisons)
+	}
+	flipped, ok := flippedComparisonOps[binary.Op]
+	if !ok {
+		return comparisons
+	}
+	if ident, ok := binary.Left.(*parser.IdentifierExprDesc); ok {
+		return append(comparisons, comparison{colName: ident.IdentifierName, op: binary.Op, value: binary.Right,
+			condition: binary})
+	}
+	if ident, ok := binary.Right.(*parser.IdentifierExprDesc); ok {
+		return append(comparisons, comparison{colName: ident.IdentifierName, op: flipped, value: binary.Left,
+			condition: binary})
+	}
+	return comparisons
+}
+
+// findComparisonValue returns the value of the first comparison on the column with one of the ops that can be used to
+// look up the key column, compiled to an expression, along with the op and the condition it came from
+func (m *manager) findComparisonValue(comparisons []comparison, colName string, keyColType types.ColumnType,
+	paramSchema *evbatch.EventSchema, ops ...string) (expr.Expression, string, parser.ExprDesc) {
+	for _, comp := range comparisons {
+		if comp.colName != colName {
+			continue
+		}
+		for _, op := range ops {
+			if comp.op != op {
+				continue
+			}
+			// The value must not reference any columns of the table - it can only contain constants and params
+			e, err := m.expressionFactory.CreateExpression(comp.value, paramSchema)
+			if err != nil || !typesCompatible(e.ResultType(), keyColType) {
+				continue
+			}
+			return e, op, comp.condition
+		}
+	}
+	return nil, "", nil
+}
+
+// keyRange is a range of keys to scan, derived from the conditions on the key columns in a filter
+type keyRange struct {
+	startExprs     []expr.Expression
+	endExprs       []expr.Expression
+	startInclusive bool
+	endInclusive   bool
+	numEqualities  int
+	// conditions are the conditions of the filter that the range was derived from
+	conditions []parser.ExprDesc
+}
+
+// keyRangeForFilter looks for conditions in the filter expression that restrict the key columns of the slab - equality
+// on the leading key columns, optionally followed by lower and/or upper bounds on the next key column - and returns
+// the corresponding range of keys to scan. It returns nil if the filter does not restrict the key. As the conditions on
+// params are removed from the filter, the get must return no rows if a bound is null - see SetKeyFromConditions.
+func (m *manager) keyRangeForFilter(slabInfo *opers.SlabInfo, filterExpr parser.ExprDesc,
+	paramSchema *evbatch.EventSchema) *keyRange {
+	paramSchema = nonNilSchema(paramSchema)
+	comparisons := collectComparisons(filterExpr, nil)
+	if len(comparisons) == 0 {
+		return nil
+	}
+	schema := slabInfo.Schema.EventSchema
+	var equalities []expr.Expression
+	var lower, upper expr.Expression
+	var lowerOp, upperOp string
+	var conditions []parser.ExprDesc
+	for _, keyColIndex := range slabInfo.KeyColIndexes {
+		colName := schema.ColumnNames()[keyColIndex]
+		colType := schema.ColumnTypes()[keyColIndex]
+		if e, _, condition := m.findComparisonValue(comparisons, colName, colType, paramSchema, "=="); e != nil {
+			equalities = append(equalities, e)
+			conditions = append(conditions, condition)
+			continue
+		}
+		var lowerCondition, upperCondition parser.ExprDesc
+		lower, lowerOp, lowerCondition = m.findComparisonValue(comparisons, colName, colType, paramSchema, ">", ">=")
+		upper, upperOp, upperCondition = m.findComparisonValue(comparisons, colName, colType, paramSchema, "<", "<=")
+		if lowerCondition != nil {
+			conditions = append(conditions, lowerCondition)
+		}
+		if upperCondition != nil {
+			conditions = append(conditions, upperCondition)
+		}
+		break
+	}
+	if len(equalities) == 0 && lower == nil && upper == nil {
+		return nil
+	}
+	kr := &keyRange{
+		startInclusive: lower == nil || lowerOp == ">=",
+		endInclusive:   upper == nil || upperOp == "<=",
+		numEqualities:  len(equalities),
+		conditions:     conditions,
+	}
+	if len(equalities) > 0 || lower != nil {
+		kr.startExprs = append(kr.startExprs, equalities...)
+		if lower != nil {
+			kr.startExprs = append(kr.startExprs, lower)
+		}
+	}
+	if len(equalities) > 0 || upper != nil {
+		kr.endExprs = append(kr.endExprs, equalities...)
+		if upper != nil {
+			kr.endExprs = append(kr.endExprs, upper)
+		}
+	}
+	return kr
+}
+
+// chooseIndexForFilter looks for equality conditions on the leading columns of an index in the conjunction of the
+// filter expression. If found, it returns the slab of the index that matches the most columns, along with the key
+// expressions to look up in the index and the conditions they came from.
+func (m *manager) chooseIndexForFilter(tableSlab *opers.SlabInfo, filterExpr parser.ExprDesc,
+	paramSchema *evbatch.EventSchema) (*opers.SlabInfo, []expr.Expression, []parser.ExprDesc) {
+	if len(tableSlab.Indexes) == 0 {
+		return nil, nil, nil
+	}
+	paramSchema = nonNilSchema(paramSchema)
+	comparisons := collectComparisons(filterExpr, nil)
+	var bestSlab *opers.SlabInfo
+	var bestExprs []expr.Expression
+	var bestConditions []parser.ExprDesc
+	for _, index := range tableSlab.Indexes {
+		var keyExprs []expr.Expression
+		var conditions []parser.ExprDesc
+		for i, indexCol := range index.IndexCols {
+			keyColType := index.Slab.Schema.EventSchema.ColumnTypes()[index.Slab.KeyColIndexes[i]]
+			e, _, condition := m.findComparisonValue(comparisons, indexCol, keyColType, paramSchema, "==")
+			if e == nil {
+				break
+			}
+			keyExprs = append(keyExprs, e)
+			conditions = append(conditions, condition)
+		}
+		if len(keyExprs) > len(bestExprs) {
+			bestSlab = index.Slab
+			bestExprs = keyExprs
+			bestConditions = conditions
+		}
+	}
+	return bestSlab, bestExprs, bestConditions
+}
+
+// residualFilter returns the filter expression without the conditions that reference prepared statement params and
+// have been applied by looking up the key or an index. Such conditions can't be evaluated by the filter, as the filter
+// is evaluated against the rows of the table only. It returns nil if no conditions remain.
+func residualFilter(filterExpr parser.ExprDesc, applied []parser.ExprDesc) parser.ExprDesc {
+	binary, ok := filterExpr.(*parser.BinaryOperatorExprDesc)
+	if ok && binary.Op == "&&" {
+		left := residualFilter(binary.Left, applied)
+		right := residualFilter(binary.Right, applied)
+		if left == nil {
+			return right
+		}
+		if right == nil {
+			return left
+		}
+		if left == binary.Left && right == binary.Right {
+			return binary
+		}
+		return &parser.BinaryOperatorExprDesc{BaseExprDesc: binary.BaseExprDesc, Left: left, Right: right, Op: "&&"}
+	}
+	if findParam(filterExpr) != nil && slices.Contains(applied, filterExpr) {
+		return nil
+	}
+	return filterExpr
+}
+
+// findParam returns the first prepared statement param referenced in the expression, or nil if there are none
+func findParam(exprDesc parser.ExprDesc) *parser.IdentifierExprDesc {
+	switch e := exprDesc.(type) {
+	case *parser.IdentifierExprDesc:
+		if strings.HasPrefix(e.IdentifierName, "$") {
+			return e
+		}
+	case *parser.BinaryOperatorExprDesc:
+		if param := findParam(e.Left); param != nil {
+			return param
+		}
+		return findParam(e.Right)
+	case *parser.UnaryOperatorExprDesc:
+		return findParam(e.Operand)
+	case *parser.UnaryPostfixOperatorExprDesc:
+		return findParam(e.Operand)
+	case *parser.FunctionExprDesc:
+		for _, arg := range e.ArgExprs {
+			if param := findParam(arg); param != nil {
+				return param
+			}
+		}
+	}
+	return nil
+}
+
+// requiredColumns returns the indexes of the columns of the schema that are needed by the operators of a query that
+// follow the initial get or scan. It returns nil if all columns are needed.
+func requiredColumns(schema *evbatch.EventSchema, opDescs []parser.Parseable) []int {
+	required := map[int]struct{}{}
+	for _, opDesc := range opDescs {
+		switch desc := opDesc.(type) {
+		case *parser.FilterDesc:
+			addReferencedColumns(schema, desc.Expr, required)
+		case *parser.ProjectDesc:
+			for _, e := range desc.Expressions {
+				addReferencedColumns(schema, e, required)
+			}
+			return sortedColumns(required)
+		case *parser.AggregateDesc:
+			for _, e := range desc.AggregateExprs {
+				addReferencedColumns(schema, e, required)
+			}
+			for _, e := range desc.KeyExprs {
+				addReferencedColumns(schema, e, required)
+			}
+			return sortedColumns(required)
+		default:
+			// All columns are returned, or are needed by a sort
+			return nil
+		}
+	}
+	return nil
+}
+
+// referencedColumns returns the indexes of the columns of the schema that are referenced in the expression
+func referencedColumns(schema *evbatch.EventSchema, exprDesc parser.ExprDesc) []int {
+	cols := map[int]struct{}{}
+	addReferencedColumns(schema, exprDesc, cols)
+	return sortedColumns(cols)
+}
+
+func addReferencedColumns(schema *evbatch.EventSchema, exprDesc parser.ExprDesc, cols map[int]struct{}) {
+	switch e := exprDesc.(type) {
+	case *parser.IdentifierExprDesc:
+		for i, colName := range schema.ColumnNames() {
+			if colName == e.IdentifierName {
+				cols[i] = struct{}{}
+			}
+		}
+	case *parser.BinaryOperatorExprDesc:
+		addReferencedColumns(schema, e.Left, cols)
+		if e.Op != "as" {
+			// The right hand side of an 'as' is the alias, not a column
+			addReferencedColumns(schema, e.Right, cols)
+		}
+	case *parser.UnaryOperatorExprDesc:
+		addReferencedColumns(schema, e.Operand, cols)
+	case *parser.UnaryPostfixOperatorExprDesc:
+		addReferencedColumns(schema, e.Operand, cols)
+	case *parser.FunctionExprDesc:
+		for _, arg := range e.ArgExprs {
+			addReferencedColumns(schema, arg, cols)
+		}
+	}
+}
+
+func sortedColumns(cols map[int]struct{}) []int {
+	res := make([]int, 0, len(cols))
+	for col := range cols {
+		res = append(res, col)
+	}
+	sort.Ints(res)
+	return res
+}
+
+func nonNilSchema(schema *evbatch.EventSchema) *evbatch.EventSchema {
+	if schema == nil {
+		return evbatch.NewEventSchema(nil, nil)
+	}
+	return schema
+}
@@ -24,6 +24,16 @@ func (d *DefaultRemoting) SendQueryResponse(request *clustermsgs.QueryResponse,
 	return err
 }
 
+func (d *DefaultRemoting) SendQueryCancel(request *clustermsgs.QueryCancelMessage, serverAddress string) error {
+	_, err := d.remotingClient.SendRPC(request, serverAddress)
+	return err
+}
+
+func (d *DefaultRemoting) SendQueryChangedMessageAsync(completionFunc func(remoting.ClusterMessage, error),
+	request *clustermsgs.QueryChangedMessage, serverAddress string) {
+	d.remotingClient.SendRPCAsync(completionFunc, request, serverAddress)
+}
+
 func (d *DefaultRemoting) Close() {
 	d.remotingClient.Stop()
 }
@@ -18,6 +18,7 @@ const (
 	ClusterMessageTypeUnknown ClusterMessageType = iota + 1
 	ClusterMessageQueryMessage
 	ClusterMessageQueryResponse
+	ClusterMessageQueryCancelMessage
 	ClusterMessageReplicateMessage
 	ClusterMessageForwardMessage
 	ClusterMessageFlushMessage
@@ -46,6 +47,10 @@ const (
 	ClusterMessageLevelManagerStoreLastFlushedVersionMessage
 	ClusterMessageLevelManagerGetStatsMessage
 	ClusterMessageLevelManagerGetStatsResponse
+	ClusterMessageLevelManagerRegisterVersionRetentionsMessage
+	ClusterMessageLevelManagerStoreVersionTimeMessage
+	ClusterMessageLevelManagerGetVersionForTimeMessage
+	ClusterMessageLevelManagerGetVersionForTimeResponse
 	ClusterMessageCompactionPollMessage
 	ClusterMessageCompactionPollResponse
 	ClusterMessageLocalObjStoreGet
@@ -56,6 +61,8 @@ const (
 	ClusterMessageShutdownMessage
 	ClusterMessageShutdownResponse
 	ClusterMessageRemotingTestMessage
+	ClusterMessageQueryChangedMessage
+	ClusterMessageQueryChangedResponse
 )
 
 func TypeForClusterMessage(clusterMessage ClusterMessage) ClusterMessageType {
@@ -64,6 +71,12 @@ func TypeForClusterMessage(clusterMessage ClusterMessage) ClusterMessageType {
 		return ClusterMessageQueryMessage
 	case *clustermsgs.QueryResponse:
 		return ClusterMessageQueryResponse
+	case *clustermsgs.QueryCancelMessage:
+		return ClusterMessageQueryCancelMessage
+	case *clustermsgs.QueryChangedMessage:
+		return ClusterMessageQueryChangedMessage
+	case *clustermsgs.QueryChangedResponse:
+		return ClusterMessageQueryChangedResponse
 	case *clustermsgs.ReplicateMessage:
 		return ClusterMessageReplicateMessage
 	case *clustermsgs.FlushMessage:
@@ -120,6 +133,14 @@ func TypeForClusterMessage(clusterMessage ClusterMessage) ClusterMessageType {
 		return ClusterMessageLevelManagerGetStatsMessage
 	case *clustermsgs.LevelManagerGetStatsResponse:
 		return ClusterMessageLevelManagerGetStatsResponse
+	case *clustermsgs.LevelManagerRegisterVersionRetentionsRequest:
+		return ClusterMessageLevelManagerRegisterVersionRetentionsMessage
+	case *clustermsgs.LevelManagerStoreVersionTimeMessage:
+		return ClusterMessageLevelManagerStoreVersionTimeMessage
+	case *clustermsgs.LevelManagerGetVersionForTimeMessage:
+		return ClusterMessageLevelManagerGetVersionForTimeMessage
+	case *clustermsgs.LevelManagerGetVersionForTimeResponse:
+		return ClusterMessageLevelManagerGetVersionForTimeResponse
 	case *clustermsgs.CompactionPollMessage:
 		return ClusterMessageCompactionPollMessage
 	case *clustermsgs.CompactionPollResponse:
@@ -203,6 +224,12 @@ func DeserializeClusterMessage(data []byte) (ClusterMessage, error) {
 		msg = &clustermsgs.QueryMessage{}
 	case ClusterMessageQueryResponse:
 		msg = &clustermsgs.QueryResponse{}
+	case ClusterMessageQueryCancelMessage:
+		msg = &clustermsgs.QueryCancelMessage{}
+	case ClusterMessageQueryChangedMessage:
+		msg = &clustermsgs.QueryChangedMessage{}
+	case ClusterMessageQueryChangedResponse:
+		msg = &clustermsgs.QueryChangedResponse{}
 	case ClusterMessageReplicateMessage:
 		msg = &clustermsgs.ReplicateMessage{}
 	case ClusterMessageForwardMessage:
@@ -259,6 +286,14 @@ func DeserializeClusterMessage(data []byte) (ClusterMessage, error) {
 		msg = &clustermsgs.LevelManagerGetStatsMessage{}
 	case ClusterMessageLevelManagerGetStatsResponse:
 		msg = &clustermsgs.LevelManagerGetStatsResponse{}
+	case ClusterMessageLevelManagerRegisterVersionRetentionsMessage:
+		msg = &clustermsgs.LevelManagerRegisterVersionRetentionsRequest{}
+	case ClusterMessageLevelManagerStoreVersionTimeMessage:
+		msg = &clustermsgs.LevelManagerStoreVersionTimeMessage{}
+	case ClusterMessageLevelManagerGetVersionForTimeMessage:
+		msg = &clustermsgs.LevelManagerGetVersionForTimeMessage{}
+	case ClusterMessageLevelManagerGetVersionForTimeResponse:
+		msg = &clustermsgs.LevelManagerGetVersionForTimeResponse{}
 	case ClusterMessageCompactionPollMessage:
 		msg = &clustermsgs.CompactionPollMessage{}
 	case ClusterMessageCompactionPollResponse:
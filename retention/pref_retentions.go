@@ -14,21 +14,23 @@ import (
 )
 
 type PrefixRetentionsService struct {
-	cfg                     *conf.Config
-	levelMgrClient          retentionsProvider
-	prefixes                atomic.Pointer[[]PrefixRetention]
-	versionPendingPrefixMap map[string]pendingPrefix
-	currWriteVersion        int64
-	prevFlushedVersion      int64
-	loadTimer               *common.TimerHandle
-	lock                    sync.Mutex
-	started                 bool
-	needsVersionCorrection  bool
+	cfg                      *conf.Config
+	levelMgrClient           retentionsProvider
+	prefixes                 atomic.Pointer[[]PrefixRetention]
+	versionPendingPrefixMap  map[string]pendingPrefix
+	pendingVersionRetentions []PrefixRetention
+	currWriteVersion         int64
+	prevFlushedVersion       int64
+	loadTimer                *common.TimerHandle
+	lock                     sync.Mutex
+	started                  bool
+	needsVersionCorrection   bool
 }
 
 type retentionsProvider interface {
 	GetPrefixRetentions() ([]PrefixRetention, error)
 	RegisterPrefixRetentions(prefixes []PrefixRetention) error
+	RegisterVersionRetentions(versionRetentions []PrefixRetention) error
 }
 
 type pendingPrefix struct {
@@ -108,6 +110,15 @@ func (d *PrefixRetentionsService) AddPrefixRetention(prefixRetention PrefixReten
 	}
 }
 
+// AddVersionRetention requests that older versions of keys with the prefix are kept for the duration of the
+// Retention of the PrefixRetention, in ms. It is registered with the level manager when the next versions are set,
+// and retried until it succeeds.
+func (d *PrefixRetentionsService) AddVersionRetention(versionRetention PrefixRetention) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.pendingVersionRetentions = append(d.pendingVersionRetentions, versionRetention)
+}
+
 func (d *PrefixRetentionsService) SetVersions(writeVersion int64, flushedVersion int64) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
@@ -123,6 +134,24 @@ func (d *PrefixRetentionsService) SetVersions(writeVersion int64, flushedVersion
 		d.needsVersionCorrection = false
 	}
 	d.versionFlushed(flushedVersion)
+	d.registerVersionRetentions()
+}
+
+func (d *PrefixRetentionsService) registerVersionRetentions() {
+	if len(d.pendingVersionRetentions) == 0 {
+		return
+	}
+	if err := d.levelMgrClient.RegisterVersionRetentions(d.pendingVersionRetentions); err != nil {
+		// It will be retried the next time versions are set
+		msg := fmt.Sprintf("failed to register version retentions: %v", err)
+		if common.IsUnavailableError(err) {
+			log.Warn(msg)
+		} else {
+			log.Error(msg)
+		}
+		return
+	}
+	d.pendingVersionRetentions = nil
 }
 
 func (d *PrefixRetentionsService) HasPendingPrefixesToRegister() bool {
@@ -0,0 +1,201 @@
+package schemareg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	log "github.com/spirit-labs/tektite/logger"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const requestTimeout = 10 * time.Second
+
+// ConfluentClient is a client of a registry that implements the Confluent schema registry REST API. Schemas fetched by
+// id never change, so they are cached indefinitely. The latest schema of a subject is cached for cacheTTL, after which
+// it is fetched again so new versions are seen.
+type ConfluentClient struct {
+	baseURL      string
+	username     string
+	password     string
+	cacheTTL     time.Duration
+	httpClient   *http.Client
+	schemasByID  sync.Map
+	latestLock   sync.Mutex
+	latestSchema map[string]cachedSchema
+}
+
+type cachedSchema struct {
+	schema    *Schema
+	fetchTime time.Time
+}
+
+func NewConfluentClient(baseURL string, username string, password string, cacheTTL time.Duration) *ConfluentClient {
+	return &ConfluentClient{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		username:     username,
+		password:     password,
+		cacheTTL:     cacheTTL,
+		httpClient:   &http.Client{Timeout: requestTimeout},
+		latestSchema: map[string]cachedSchema{},
+	}
+}
+
+// schemaResponse is the body of the responses of the registry that return a schema
+type schemaResponse struct {
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	ID         int32  `json:"id"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+type registerRequest struct {
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+type registerResponse struct {
+	ID int32 `json:"id"`
+}
+
+type errorResponse struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (c *ConfluentClient) GetSchemaByID(id int32) (*Schema, error) {
+	s, ok := c.schemasByID.Load(id)
+	if ok {
+		return s.(*Schema), nil
+	}
+	var resp schemaResponse
+	if err := c.sendRequest(http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	schema := &Schema{
+		ID:         id,
+		SchemaType: normalizeSchemaType(resp.SchemaType),
+		Schema:     resp.Schema,
+	}
+	c.schemasByID.Store(id, schema)
+	return schema, nil
+}
+
+func (c *ConfluentClient) GetLatestSchema(subject string) (*Schema, error) {
+	c.latestLock.Lock()
+	cached, ok := c.latestSchema[subject]
+	c.latestLock.Unlock()
+	if ok && time.Since(cached.fetchTime) < c.cacheTTL {
+		return cached.schema, nil
+	}
+	var resp schemaResponse
+	path := fmt.Sprintf("/subjects/%s/versions/latest", url.PathEscape(subject))
+	if err := c.sendRequest(http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	schema := &Schema{
+		ID:         resp.ID,
+		Subject:    resp.Subject,
+		Version:    resp.Version,
+		SchemaType: normalizeSchemaType(resp.SchemaType),
+		Schema:     resp.Schema,
+	}
+	c.latestLock.Lock()
+	c.latestSchema[subject] = cachedSchema{schema: schema, fetchTime: time.Now()}
+	c.latestLock.Unlock()
+	return schema, nil
+}
+
+func (c *ConfluentClient) GetSubjectSchemas(subject string) ([]*Schema, error) {
+	var versions []int
+	path := fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject))
+	if err := c.sendRequest(http.MethodGet, path, nil, &versions); err != nil {
+		return nil, err
+	}
+	schemas := make([]*Schema, 0, len(versions))
+	for _, version := range versions {
+		var resp schemaResponse
+		if err := c.sendRequest(http.MethodGet, fmt.Sprintf("%s/%d", path, version), nil, &resp); err != nil {
+			return nil, err
+		}
+		schema := &Schema{
+			ID:         resp.ID,
+			Subject:    resp.Subject,
+			Version:    resp.Version,
+			SchemaType: normalizeSchemaType(resp.SchemaType),
+			Schema:     resp.Schema,
+		}
+		c.schemasByID.LoadOrStore(schema.ID, &Schema{ID: schema.ID, SchemaType: schema.SchemaType, Schema: schema.Schema})
+		schemas = append(schemas, schema)
+	}
+	return schemas, nil
+}
+
+func (c *ConfluentClient) Register(subject string, schemaType string, schema string) (int32, error) {
+	req := registerRequest{Schema: schema}
+	if schemaType = normalizeSchemaType(schemaType); schemaType != SchemaTypeAvro {
+		req.SchemaType = schemaType
+	}
+	var resp registerResponse
+	path := fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject))
+	if err := c.sendRequest(http.MethodPost, path, req, &resp); err != nil {
+		return 0, err
+	}
+	// The latest version of the subject may have changed
+	c.latestLock.Lock()
+	delete(c.latestSchema, subject)
+	c.latestLock.Unlock()
+	return resp.ID, nil
+}
+
+func (c *ConfluentClient) sendRequest(method string, path string, reqBody any, respBody any) error {
+	var body io.Reader
+	if reqBody != nil {
+		buff, err := json.Marshal(reqBody)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buff)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Errorf("failed to send request to schema registry: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("failed to close schema registry response %v", err)
+		}
+	}()
+	buff, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Errorf("failed to read response from schema registry: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.Unmarshal(buff, &errResp); err == nil && errResp.Message != "" {
+			return errors.Errorf("schema registry returned error %d: %s", errResp.ErrorCode, errResp.Message)
+		}
+		return errors.Errorf("schema registry returned status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(buff, respBody); err != nil {
+		return errors.Errorf("invalid response from schema registry: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package schemareg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/avro"
+	"github.com/spirit-labs/tektite/common"
+	"github.com/spirit-labs/tektite/errors"
+	log "github.com/spirit-labs/tektite/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	errorCodeSubjectNotFound = 40401
+	errorCodeVersionNotFound = 40402
+	errorCodeSchemaNotFound  = 40403
+	errorCodeInvalidSchema   = 42201
+)
+
+// EmbeddedRegistry is an in-memory schema registry, for local development and testing. Schemas registered with it are
+// lost when the server stops. If it has a listen address, it also serves the subset of the Confluent schema registry
+// REST API that producers and consumers use, so Kafka clients can register and fetch schemas with it.
+type EmbeddedRegistry struct {
+	listenAddress string
+	lock          sync.RWMutex
+	schemas       []*Schema
+	subjects      map[string][]int32
+	listener      net.Listener
+	httpServer    *http.Server
+}
+
+func NewEmbeddedRegistry(listenAddress string) *EmbeddedRegistry {
+	return &EmbeddedRegistry{
+		listenAddress: listenAddress,
+		subjects:      map[string][]int32{},
+	}
+}
+
+func (e *EmbeddedRegistry) Start() error {
+	if e.listenAddress == "" {
+		return nil
+	}
+	list, err := net.Listen("tcp", e.listenAddress)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	e.listener = list
+	e.httpServer = &http.Server{Handler: e}
+	common.Go(func() {
+		if err := e.httpServer.Serve(list); err != nil && err != http.ErrServerClosed {
+			log.Errorf("embedded schema registry failed: %v", err)
+		}
+	})
+	log.Debugf("started embedded schema registry on %s", e.listenAddress)
+	return nil
+}
+
+func (e *EmbeddedRegistry) Stop() error {
+	if e.httpServer == nil {
+		return nil
+	}
+	return e.httpServer.Close()
+}
+
+// ListenAddress returns the address the registry REST API is served on
+func (e *EmbeddedRegistry) ListenAddress() string {
+	if e.listener != nil {
+		return e.listener.Addr().String()
+	}
+	return e.listenAddress
+}
+
+func (e *EmbeddedRegistry) GetSchemaByID(id int32) (*Schema, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if id < 1 || int(id) > len(e.schemas) {
+		return nil, errors.Errorf("schema with id %d not found", id)
+	}
+	s := e.schemas[id-1]
+	return &Schema{ID: s.ID, SchemaType: s.SchemaType, Schema: s.Schema}, nil
+}
+
+func (e *EmbeddedRegistry) GetLatestSchema(subject string) (*Schema, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	versions, ok := e.subjects[subject]
+	if !ok {
+		return nil, errors.Errorf("subject '%s' not found", subject)
+	}
+	return e.getVersion(subject, versions, len(versions)), nil
+}
+
+func (e *EmbeddedRegistry) GetSubjectSchemas(subject string) ([]*Schema, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	versions, ok := e.subjects[subject]
+	if !ok {
+		return nil, errors.Errorf("subject '%s' not found", subject)
+	}
+	schemas := make([]*Schema, len(versions))
+	for i := range versions {
+		schemas[i] = e.getVersion(subject, versions, i+1)
+	}
+	return schemas, nil
+}
+
+func (e *EmbeddedRegistry) getVersion(subject string, versions []int32, version int) *Schema {
+	s := e.schemas[versions[version-1]-1]
+	return &Schema{
+		ID:         s.ID,
+		Subject:    subject,
+		Version:    version,
+		SchemaType: s.SchemaType,
+		Schema:     s.Schema,
+	}
+}
+
+func (e *EmbeddedRegistry) Register(subject string, schemaType string, schema string) (int32, error) {
+	schemaType = normalizeSchemaType(schemaType)
+	if schemaType == SchemaTypeAvro {
+		if _, err := avro.ParseSchema(schema); err != nil {
+			return 0, err
+		}
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	// As with the Confluent registry, a schema has the same id under all the subjects it is registered under
+	var id int32
+	for _, s := range e.schemas {
+		if s.SchemaType == schemaType && s.Schema == schema {
+			id = s.ID
+			break
+		}
+	}
+	if id == 0 {
+		id = int32(len(e.schemas) + 1)
+		e.schemas = append(e.schemas, &Schema{ID: id, SchemaType: schemaType, Schema: schema})
+	}
+	versions := e.subjects[subject]
+	for _, vid := range versions {
+		if vid == id {
+			return id, nil
+		}
+	}
+	e.subjects[subject] = append(versions, id)
+	return id, nil
+}
+
+func (e *EmbeddedRegistry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	defer common.PanicHandler()
+	parts := strings.Split(strings.Trim(request.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeRegistryError(writer, http.StatusBadRequest, 400, "invalid path")
+			return
+		}
+		parts[i] = unescaped
+	}
+	switch {
+	case len(parts) == 3 && parts[0] == "schemas" && parts[1] == "ids" && request.Method == http.MethodGet:
+		e.handleGetSchemaByID(writer, parts[2])
+	case len(parts) == 1 && parts[0] == "subjects" && request.Method == http.MethodGet:
+		e.handleGetSubjects(writer)
+	case len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions" && request.Method == http.MethodPost:
+		e.handleRegister(writer, request, parts[1])
+	case len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions" && request.Method == http.MethodGet:
+		e.handleGetVersions(writer, parts[1])
+	case len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions" && request.Method == http.MethodGet:
+		e.handleGetVersion(writer, parts[1], parts[3])
+	default:
+		writeRegistryError(writer, http.StatusNotFound, 404, "HTTP 404 Not Found")
+	}
+}
+
+func (e *EmbeddedRegistry) handleGetSchemaByID(writer http.ResponseWriter, sID string) {
+	id, err := strconv.ParseInt(sID, 10, 32)
+	if err != nil {
+		writeRegistryError(writer, http.StatusNotFound, errorCodeSchemaNotFound, "Schema not found")
+		return
+	}
+	schema, err := e.GetSchemaByID(int32(id))
+	if err != nil {
+		writeRegistryError(writer, http.StatusNotFound, errorCodeSchemaNotFound, "Schema not found")
+		return
+	}
+	writeRegistryResponse(writer, toSchemaResponse(schema))
+}
+
+func (e *EmbeddedRegistry) handleGetSubjects(writer http.ResponseWriter) {
+	e.lock.RLock()
+	subjects := make([]string, 0, len(e.subjects))
+	for subject := range e.subjects {
+		subjects = append(subjects, subject)
+	}
+	e.lock.RUnlock()
+	sort.Strings(subjects)
+	writeRegistryResponse(writer, subjects)
+}
+
+func (e *EmbeddedRegistry) handleRegister(writer http.ResponseWriter, request *http.Request, subject string) {
+	var req registerRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		writeRegistryError(writer, http.StatusUnprocessableEntity, errorCodeInvalidSchema, "Invalid request body")
+		return
+	}
+	id, err := e.Register(subject, req.SchemaType, req.Schema)
+	if err != nil {
+		writeRegistryError(writer, http.StatusUnprocessableEntity, errorCodeInvalidSchema,
+			fmt.Sprintf("Invalid schema: %v", err))
+		return
+	}
+	writeRegistryResponse(writer, registerResponse{ID: id})
+}
+
+func (e *EmbeddedRegistry) handleGetVersions(writer http.ResponseWriter, subject string) {
+	e.lock.RLock()
+	versions, ok := e.subjects[subject]
+	e.lock.RUnlock()
+	if !ok {
+		writeRegistryError(writer, http.StatusNotFound, errorCodeSubjectNotFound,
+			fmt.Sprintf("Subject '%s' not found.", subject))
+		return
+	}
+	nums := make([]int, len(versions))
+	for i := range versions {
+		nums[i] = i + 1
+	}
+	writeRegistryResponse(writer, nums)
+}
+
+func (e *EmbeddedRegistry) handleGetVersion(writer http.ResponseWriter, subject string, sVersion string) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	versions, ok := e.subjects[subject]
+	if !ok {
+		writeRegistryError(writer, http.StatusNotFound, errorCodeSubjectNotFound,
+			fmt.Sprintf("Subject '%s' not found.", subject))
+		return
+	}
+	version := len(versions)
+	if sVersion != "latest" {
+		v, err := strconv.Atoi(sVersion)
+		if err != nil || v < 1 || v > len(versions) {
+			writeRegistryError(writer, http.StatusNotFound, errorCodeVersionNotFound, "Version not found.")
+			return
+		}
+		version = v
+	}
+	writeRegistryResponse(writer, toSchemaResponse(e.getVersion(subject, versions, version)))
+}
+
+func toSchemaResponse(schema *Schema) schemaResponse {
+	resp := schemaResponse{
+		Subject: schema.Subject,
+		Version: schema.Version,
+		ID:      schema.ID,
+		Schema:  schema.Schema,
+	}
+	if schema.SchemaType != SchemaTypeAvro {
+		resp.SchemaType = schema.SchemaType
+	}
+	return resp
+}
+
+func writeRegistryResponse(writer http.ResponseWriter, resp any) {
+	buff, err := json.Marshal(resp)
+	if err != nil {
+		writeRegistryError(writer, http.StatusInternalServerError, 50001, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if _, err := writer.Write(buff); err != nil {
+		log.Warnf("failed to write schema registry response: %v", err)
+	}
+}
+
+func writeRegistryError(writer http.ResponseWriter, statusCode int, errorCode int, msg string) {
+	buff, err := json.Marshal(errorResponse{ErrorCode: errorCode, Message: msg})
+	if err != nil {
+		panic(err) // marshalling the error response cannot fail
+	}
+	writer.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	writer.WriteHeader(statusCode)
+	if _, err := writer.Write(buff); err != nil {
+		log.Warnf("failed to write schema registry response: %v", err)
+	}
+}
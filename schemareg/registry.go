@@ -0,0 +1,63 @@
+// Package schemareg provides access to the schemas that Kafka messages are encoded with, from a Confluent-compatible
+// schema registry, or from an embedded registry for local development and testing.
+package schemareg
+
+import (
+	"encoding/binary"
+	"github.com/spirit-labs/tektite/errors"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJson     = "JSON"
+
+	// wireFormatMagicByte is the first byte of a message encoded in the Confluent wire format
+	wireFormatMagicByte = 0
+	wireFormatHeaderLen = 5
+)
+
+// Schema is a version of a schema registered under a subject
+type Schema struct {
+	ID         int32
+	Subject    string
+	Version    int
+	SchemaType string
+	Schema     string
+}
+
+type Registry interface {
+	// GetSchemaByID returns the schema with the given global id. The Subject and Version of the returned schema are not
+	// set, as the same schema can be registered under more than one subject.
+	GetSchemaByID(id int32) (*Schema, error)
+	// GetLatestSchema returns the latest version of the schema registered under the subject
+	GetLatestSchema(subject string) (*Schema, error)
+	// GetSubjectSchemas returns all the versions of the schema registered under the subject, in version order
+	GetSubjectSchemas(subject string) ([]*Schema, error)
+	// Register registers the schema under the subject, if it is not already registered under it, and returns its id
+	Register(subject string, schemaType string, schema string) (int32, error)
+}
+
+// AppendWireHeader appends the header of the Confluent wire format, which identifies the schema of the message, to out.
+// The header is followed by the encoded message.
+func AppendWireHeader(out []byte, schemaID int32) []byte {
+	out = append(out, wireFormatMagicByte)
+	return binary.BigEndian.AppendUint32(out, uint32(schemaID))
+}
+
+// ParseWireHeader returns the id of the schema of a message encoded in the Confluent wire format, and the encoded
+// message that follows the header
+func ParseWireHeader(buff []byte) (int32, []byte, error) {
+	if len(buff) < wireFormatHeaderLen || buff[0] != wireFormatMagicByte {
+		return 0, nil, errors.New("message is not in the schema registry wire format")
+	}
+	return int32(binary.BigEndian.Uint32(buff[1:])), buff[wireFormatHeaderLen:], nil
+}
+
+func normalizeSchemaType(schemaType string) string {
+	// The registry API omits the schema type for Avro schemas
+	if schemaType == "" {
+		return SchemaTypeAvro
+	}
+	return schemaType
+}
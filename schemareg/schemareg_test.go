@@ -0,0 +1,198 @@
+package schemareg
+
+import (
+	"fmt"
+	"github.com/spirit-labs/tektite/testutils"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	schema1 = `{"type": "record", "name": "r1", "fields": [{"name": "a", "type": "int"}]}`
+	schema2 = `{"type": "record", "name": "r2", "fields": [{"name": "b", "type": "string"}]}`
+)
+
+func TestWireHeader(t *testing.T) {
+	buff := AppendWireHeader([]byte("foo"), 0x01020304)
+	require.Equal(t, []byte{'f', 'o', 'o', 0, 1, 2, 3, 4}, buff)
+	buff = append(buff, "payload"...)
+	id, payload, err := ParseWireHeader(buff[3:])
+	require.NoError(t, err)
+	require.Equal(t, int32(0x01020304), id)
+	require.Equal(t, "payload", string(payload))
+
+	_, _, err = ParseWireHeader([]byte{0, 1, 2, 3})
+	require.Error(t, err)
+	require.Equal(t, "message is not in the schema registry wire format", err.Error())
+	_, _, err = ParseWireHeader([]byte{1, 1, 2, 3, 4})
+	require.Error(t, err)
+}
+
+func TestEmbeddedRegistry(t *testing.T) {
+	testRegistry(t, NewEmbeddedRegistry(""))
+}
+
+func TestConfluentClient(t *testing.T) {
+	embedded := startEmbeddedRegistry(t)
+	defer func() {
+		err := embedded.Stop()
+		require.NoError(t, err)
+	}()
+	client := NewConfluentClient(fmt.Sprintf("http://%s/", embedded.ListenAddress()), "", "", time.Minute)
+	testRegistry(t, client)
+}
+
+func testRegistry(t *testing.T, registry Registry) {
+	_, err := registry.GetLatestSchema("subject1")
+	require.Error(t, err)
+	_, err = registry.GetSchemaByID(1)
+	require.Error(t, err)
+
+	id1, err := registry.Register("subject1", SchemaTypeAvro, schema1)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), id1)
+	latest, err := registry.GetLatestSchema("subject1")
+	require.NoError(t, err)
+	require.Equal(t, &Schema{ID: 1, Subject: "subject1", Version: 1, SchemaType: SchemaTypeAvro, Schema: schema1}, latest)
+
+	// Registering the same schema again does not create a new version
+	id, err := registry.Register("subject1", "", schema1)
+	require.NoError(t, err)
+	require.Equal(t, id1, id)
+
+	id2, err := registry.Register("subject1", SchemaTypeAvro, schema2)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), id2)
+	latest, err = registry.GetLatestSchema("subject1")
+	require.NoError(t, err)
+	require.Equal(t, &Schema{ID: 2, Subject: "subject1", Version: 2, SchemaType: SchemaTypeAvro, Schema: schema2}, latest)
+
+	// The same schema has the same id under another subject
+	id, err = registry.Register("subject2", SchemaTypeAvro, schema1)
+	require.NoError(t, err)
+	require.Equal(t, id1, id)
+	latest, err = registry.GetLatestSchema("subject2")
+	require.NoError(t, err)
+	require.Equal(t, &Schema{ID: 1, Subject: "subject2", Version: 1, SchemaType: SchemaTypeAvro, Schema: schema1}, latest)
+
+	schema, err := registry.GetSchemaByID(id2)
+	require.NoError(t, err)
+	require.Equal(t, &Schema{ID: 2, SchemaType: SchemaTypeAvro, Schema: schema2}, schema)
+
+	schemas, err := registry.GetSubjectSchemas("subject1")
+	require.NoError(t, err)
+	require.Equal(t, []*Schema{
+		{ID: 1, Subject: "subject1", Version: 1, SchemaType: SchemaTypeAvro, Schema: schema1},
+		{ID: 2, Subject: "subject1", Version: 2, SchemaType: SchemaTypeAvro, Schema: schema2},
+	}, schemas)
+	_, err = registry.GetSubjectSchemas("unknown")
+	require.Error(t, err)
+
+	protoSchema := `syntax = "proto3"; message Foo { string bar = 1; }`
+	id3, err := registry.Register("subject3", SchemaTypeProtobuf, protoSchema)
+	require.NoError(t, err)
+	schema, err = registry.GetSchemaByID(id3)
+	require.NoError(t, err)
+	require.Equal(t, &Schema{ID: id3, SchemaType: SchemaTypeProtobuf, Schema: protoSchema}, schema)
+
+	_, err = registry.Register("subject1", SchemaTypeAvro, `{"type": "foo"}`)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "invalid avro schema: unknown type 'foo'"))
+}
+
+func TestConfluentClientCachesLatestSchema(t *testing.T) {
+	embedded := startEmbeddedRegistry(t)
+	defer func() {
+		err := embedded.Stop()
+		require.NoError(t, err)
+	}()
+	client := NewConfluentClient(fmt.Sprintf("http://%s", embedded.ListenAddress()), "", "", 100*time.Millisecond)
+	_, err := embedded.Register("subject1", SchemaTypeAvro, schema1)
+	require.NoError(t, err)
+	latest, err := client.GetLatestSchema("subject1")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), latest.ID)
+
+	// A new version registered elsewhere is seen once the cached version expires
+	_, err = embedded.Register("subject1", SchemaTypeAvro, schema2)
+	require.NoError(t, err)
+	latest, err = client.GetLatestSchema("subject1")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), latest.ID)
+	testutils.WaitUntil(t, func() (bool, error) {
+		latest, err = client.GetLatestSchema("subject1")
+		if err != nil {
+			return false, err
+		}
+		return latest.ID == 2, nil
+	})
+
+	// Schemas fetched by id are cached indefinitely
+	schema, err := client.GetSchemaByID(2)
+	require.NoError(t, err)
+	err = embedded.Stop()
+	require.NoError(t, err)
+	cached, err := client.GetSchemaByID(2)
+	require.NoError(t, err)
+	require.Same(t, schema, cached)
+	_, err = client.GetSchemaByID(1)
+	require.Error(t, err)
+}
+
+func TestEmbeddedRegistryRestAPI(t *testing.T) {
+	embedded := startEmbeddedRegistry(t)
+	defer func() {
+		err := embedded.Stop()
+		require.NoError(t, err)
+	}()
+	baseURL := fmt.Sprintf("http://%s", embedded.ListenAddress())
+	_, err := embedded.Register("orders-value", SchemaTypeAvro, schema1)
+	require.NoError(t, err)
+	_, err = embedded.Register("orders-value", SchemaTypeAvro, schema2)
+	require.NoError(t, err)
+	_, err = embedded.Register("customers-value", SchemaTypeAvro, schema2)
+	require.NoError(t, err)
+
+	testRestRequest(t, baseURL+"/subjects", http.StatusOK, `["customers-value","orders-value"]`)
+	testRestRequest(t, baseURL+"/subjects/orders-value/versions", http.StatusOK, `[1,2]`)
+	testRestRequest(t, baseURL+"/subjects/orders-value/versions/1", http.StatusOK,
+		`{"subject":"orders-value","version":1,"id":1,"schema":"{\"type\": \"record\", \"name\": \"r1\", \"fields\": [{\"name\": \"a\", \"type\": \"int\"}]}"}`)
+	testRestRequest(t, baseURL+"/subjects/customers-value/versions/latest", http.StatusOK,
+		`{"subject":"customers-value","version":1,"id":2,"schema":"{\"type\": \"record\", \"name\": \"r2\", \"fields\": [{\"name\": \"b\", \"type\": \"string\"}]}"}`)
+	testRestRequest(t, baseURL+"/schemas/ids/2", http.StatusOK,
+		`{"id":2,"schema":"{\"type\": \"record\", \"name\": \"r2\", \"fields\": [{\"name\": \"b\", \"type\": \"string\"}]}"}`)
+
+	testRestRequest(t, baseURL+"/subjects/unknown/versions/latest", http.StatusNotFound,
+		`{"error_code":40401,"message":"Subject 'unknown' not found."}`)
+	testRestRequest(t, baseURL+"/subjects/orders-value/versions/3", http.StatusNotFound,
+		`{"error_code":40402,"message":"Version not found."}`)
+	testRestRequest(t, baseURL+"/schemas/ids/3", http.StatusNotFound,
+		`{"error_code":40403,"message":"Schema not found"}`)
+	testRestRequest(t, baseURL+"/foo", http.StatusNotFound,
+		`{"error_code":404,"message":"HTTP 404 Not Found"}`)
+}
+
+func testRestRequest(t *testing.T, uri string, expectedStatus int, expectedBody string) {
+	resp, err := http.Get(uri) //nolint:gosec
+	require.NoError(t, err)
+	defer func() {
+		err := resp.Body.Close()
+		require.NoError(t, err)
+	}()
+	require.Equal(t, expectedStatus, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, expectedBody, string(body))
+}
+
+func startEmbeddedRegistry(t *testing.T) *EmbeddedRegistry {
+	address := fmt.Sprintf("localhost:%d", testutils.PortProvider.GetPort(t))
+	embedded := NewEmbeddedRegistry(address)
+	err := embedded.Start()
+	require.NoError(t, err)
+	return embedded
+}
@@ -17,10 +17,13 @@ import (
 	"github.com/spirit-labs/tektite/objstore/minio"
 	"github.com/spirit-labs/tektite/opers"
 	"github.com/spirit-labs/tektite/parser"
+	"github.com/spirit-labs/tektite/pgwire"
 	"github.com/spirit-labs/tektite/proc"
+	"github.com/spirit-labs/tektite/protoreg"
 	"github.com/spirit-labs/tektite/query"
 	"github.com/spirit-labs/tektite/repli"
 	"github.com/spirit-labs/tektite/retention"
+	"github.com/spirit-labs/tektite/schemareg"
 	"github.com/spirit-labs/tektite/sequence"
 	"github.com/spirit-labs/tektite/store"
 	"github.com/spirit-labs/tektite/tabcache"
@@ -135,11 +138,26 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 
 	moduleManager := wasm.NewModuleManager(objStoreClient, lockManager, &config)
 	invokerFactory := &wasm.InvokerFactory{ModManager: moduleManager}
-	exprFactory := &expr.ExpressionFactory{ExternalInvokerFactory: invokerFactory}
+	var schemaRegistry schemareg.Registry
+	var embeddedSchemaRegistry *schemareg.EmbeddedRegistry
+	switch config.SchemaRegistryType {
+	case conf.ConfluentSchemaRegistryType:
+		schemaRegistry = schemareg.NewConfluentClient(config.SchemaRegistryURL, config.SchemaRegistryUsername,
+			config.SchemaRegistryPassword, config.SchemaRegistryCacheTTL)
+	case conf.EmbeddedSchemaRegistryType:
+		embeddedSchemaRegistry = schemareg.NewEmbeddedRegistry(config.SchemaRegistryListenAddress)
+		schemaRegistry = embeddedSchemaRegistry
+	}
+	descriptorManager := protoreg.NewDescriptorManager(objStoreClient, lockManager)
+	exprFactory := &expr.ExpressionFactory{ExternalInvokerFactory: invokerFactory, SchemaRegistry: schemaRegistry,
+		ProtobufMessages: descriptorManager, LookupCacheMaxSize: config.LookupCacheMaxSize,
+		LookupCacheTTL: config.LookupCacheTTL}
 
 	theParser := parser.NewParser(&wasmFunctionChecker{moduleManager})
 
 	streamManager := opers.NewStreamManager(clientFactory, dataStore, prefixRetentions, exprFactory, &config, false)
+	// The stream manager provides the tables read by the lookup function, so it is set once the manager is created
+	exprFactory.TableResolver = streamManager
 
 	handlerFactory := &batchHandlerFactory{
 		cfg:           &config,
@@ -155,10 +173,15 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 	processorManager.RegisterStateHandler(versionManager.HandleClusterState)
 
 	streamManager.SetProcessorManager(processorManager)
+	versionManager.SetVersionRetentionsProvider(streamManager)
 
 	queryManager := query.NewManager(processorManager, processorManager, config.NodeID, streamManager, dataStore,
 		streamManager.StreamMetaIteratorProvider(), query.NewDefaultRemoting(&config), config.ClusterAddresses,
-		config.QueryMaxBatchRows, exprFactory, theParser)
+		config.QueryMaxBatchRows, query.Limits{
+			Timeout:        config.QueryTimeout,
+			MaxRowsScanned: int64(config.QueryMaxRowsScanned),
+			MaxMemoryBytes: int64(config.QueryMaxMemoryBytes),
+		}, exprFactory, theParser, levMgrClient)
 
 	levelManagerService := levels.NewLevelManagerService(processorManager, &config, objStoreClient, tableCache,
 		proc.NewLevelManagerCommandIngestor(processorManager), processorManager)
@@ -187,7 +210,8 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 	var apiServer *api.HTTPAPIServer
 	if config.HttpApiEnabled {
 		apiServer = api.NewHTTPAPIServer(config.HttpApiAddresses[config.NodeID], config.HttpApiPath,
-			queryManager, commandMgr, theParser, moduleManager, config.HttpApiTlsConfig)
+			queryManager, commandMgr, theParser, moduleManager, descriptorManager, config.HttpApiTlsConfig)
+		streamManager.RegisterChangeListener(apiServer.StreamChanged)
 	}
 
 	var kafkaServer *kafkaserver.Server
@@ -210,6 +234,11 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 			metaProvider, processorProvider, kafkaGroupCoordinator, dataStore, streamManager)
 	}
 
+	var pgServer *pgwire.Server
+	if config.PostgresServerEnabled {
+		pgServer = pgwire.NewServer(&config, queryManager, commandMgr, theParser)
+	}
+
 	var adminServer *admin.Server
 	if config.AdminConsoleEnabled {
 		adminServer, err = admin.NewServer(&config, levMgrClient, streamManager, processorManager)
@@ -234,6 +263,7 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 	dataStore.SetClusterMessageHandlers(teeHandler)
 	versionManager.SetClusterMessageHandlers(remotingServer)
 	queryManager.SetClusterMessageHandlers(remotingServer, teeHandler)
+	streamManager.SetClusterMessageHandlers(teeHandler)
 
 	services := []service{
 		remotingServer,
@@ -251,11 +281,13 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 		streamManager,
 		queryManager,
 		moduleManager,
+		embeddedSchemaRegistry,
 		commandMgr,
 		commandSignaller,
 		apiServer,
 		kafkaGroupCoordinator,
 		kafkaServer,
+		pgServer,
 		compactionService,
 		theMetrics,
 		lifeCycleMgr,
@@ -280,6 +312,7 @@ func NewServerWithClientFactory(config conf.Config, clientFactory kafka.ClientFa
 		versionManager:      versionManager,
 		kafkaServer:         kafkaServer,
 		apiServer:           apiServer,
+		pgServer:            pgServer,
 	}
 	remotingServer.RegisterMessageHandler(remoting.ClusterMessageShutdownMessage, &shutdownMessageHandler{s: server})
 	return server, nil
@@ -305,6 +338,7 @@ type Server struct {
 	levelManagerService *levels.LevelManagerService
 	kafkaServer         *kafkaserver.Server
 	apiServer           *api.HTTPAPIServer
+	pgServer            *pgwire.Server
 	webuiServer         *admin.Server
 	parser              *parser.Parser
 	shutDownPhase       int
@@ -350,7 +384,7 @@ func (s *Server) Start() error {
 				time.Now().Sub(start).Milliseconds())
 		}
 	}
-	// We delay starting kafka and api server until replicators are ready
+	// We delay starting kafka, api and postgres servers until replicators are ready
 	if err := s.processorManager.EnsureReplicatorsReady(); err != nil {
 		return err
 	}
@@ -364,6 +398,11 @@ func (s *Server) Start() error {
 			return err
 		}
 	}
+	if s.pgServer != nil {
+		if err := s.pgServer.Activate(); err != nil {
+			return err
+		}
+	}
 
 	s.lifeCycleMgr.SetActive(true)
 
@@ -585,3 +624,8 @@ func (w *wasmFunctionChecker) FunctionExists(functionName string) bool {
 	_, ok, err := w.moduleManager.GetFunctionMetadata(functionName)
 	return ok && err == nil
 }
+
+func (w *wasmFunctionChecker) AggregateFunctionExists(functionName string) bool {
+	_, ok, err := w.moduleManager.GetAggregateFunctionMetadata(functionName)
+	return ok && err == nil
+}
@@ -15,10 +15,26 @@ type Client interface {
 
 	StreamExecuteQuery(query string) (chan StreamChunk, error)
 
+	// ExecuteSQL executes a SQL SELECT query
+	ExecuteSQL(sql string) (QueryResult, error)
+
+	StreamExecuteSQL(sql string) (chan StreamChunk, error)
+
+	// ExecuteSQLStatement executes a SQL statement that does not return results, e.g. a SQL PREPARE. The prepared query
+	// can then be executed with GetPreparedQuery.
+	ExecuteSQLStatement(statement string) error
+
 	RegisterWasmModule(modulePath string) error
 
 	UnregisterWasmModule(moduleName string) error
 
+	// RegisterProtobufDescriptors registers a protobuf FileDescriptorSet file, as produced by
+	// `protoc --include_imports --descriptor_set_out`. The set is registered with the name of the file without its
+	// extension.
+	RegisterProtobufDescriptors(descriptorSetPath string) error
+
+	UnregisterProtobufDescriptors(name string) error
+
 	Close()
 }
 
@@ -41,6 +57,10 @@ type PreparedQuery interface {
 
 	SetNullArg(index int)
 
+	// SetCursor sets the cursor returned by a previous execution of the query, so the next execution returns the next
+	// page of results. The cursor is only used if the query has a limit.
+	SetCursor(cursor string)
+
 	Execute() (QueryResult, error)
 
 	StreamExecute() (chan StreamChunk, error)
@@ -56,6 +76,10 @@ type QueryResult interface {
 	Column(colIndex int) Column
 
 	Row(rowIndex int) Row
+
+	// Cursor returns the cursor for the next page of results if the query has a limit and there may be more results,
+	// otherwise it returns the empty string
+	Cursor() string
 }
 
 type StreamChunk struct {
@@ -80,6 +104,10 @@ type Column interface {
 	BytesVal(rowIndex int) []byte
 
 	TimestampVal(rowIndex int) types.Timestamp
+
+	// NestedVal returns the value of an array, map or struct column. Arrays and structs are returned as []any and maps
+	// as map[string]any.
+	NestedVal(rowIndex int) any
 }
 
 type Row interface {
@@ -98,6 +126,10 @@ type Row interface {
 	BytesVal(colIndex int) []byte
 
 	TimestampVal(colIndex int) types.Timestamp
+
+	// NestedVal returns the value of an array, map or struct column. Arrays and structs are returned as []any and maps
+	// as map[string]any.
+	NestedVal(colIndex int) any
 }
 
 type Meta interface {
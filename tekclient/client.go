@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/spirit-labs/tektite/api"
 	"github.com/spirit-labs/tektite/common"
@@ -18,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -39,27 +41,33 @@ func NewClient(serverAddress string, tlsConfig TLSConfig) (Client, error) {
 		TLSClientConfig: tlsConf,
 	}
 	return &client{
-		serverAddress:     serverAddress,
-		statementURL:      fmt.Sprintf("https://%s/tektite/statement", serverAddress),
-		queryURL:          fmt.Sprintf("https://%s/tektite/query?col_headers=true", serverAddress),
-		execPSURL:         fmt.Sprintf("https://%s/tektite/exec?col_headers=true", serverAddress),
-		registerWasmURL:   fmt.Sprintf("https://%s/tektite/wasm-register", serverAddress),
-		unregisterWasmURL: fmt.Sprintf("https://%s/tektite/wasm-unregister", serverAddress),
-		tlsConfig:         tlsConfig,
-		httpCl:            httpCl,
+		serverAddress:         serverAddress,
+		statementURL:          fmt.Sprintf("https://%s/tektite/statement", serverAddress),
+		queryURL:              fmt.Sprintf("https://%s/tektite/query?col_headers=true", serverAddress),
+		sqlURL:                fmt.Sprintf("https://%s/tektite/sql?col_headers=true", serverAddress),
+		execPSURL:             fmt.Sprintf("https://%s/tektite/exec?col_headers=true", serverAddress),
+		registerWasmURL:       fmt.Sprintf("https://%s/tektite/wasm-register", serverAddress),
+		unregisterWasmURL:     fmt.Sprintf("https://%s/tektite/wasm-unregister", serverAddress),
+		registerProtobufURL:   fmt.Sprintf("https://%s/tektite/protobuf-register", serverAddress),
+		unregisterProtobufURL: fmt.Sprintf("https://%s/tektite/protobuf-unregister", serverAddress),
+		tlsConfig:             tlsConfig,
+		httpCl:                httpCl,
 	}, nil
 }
 
 type client struct {
-	serverAddress     string
-	statementURL      string
-	queryURL          string
-	execPSURL         string
-	registerWasmURL   string
-	unregisterWasmURL string
-	tlsConfig         TLSConfig
-	httpCl            *http.Client
-	stopped           atomic.Bool
+	serverAddress         string
+	statementURL          string
+	queryURL              string
+	sqlURL                string
+	execPSURL             string
+	registerWasmURL       string
+	unregisterWasmURL     string
+	registerProtobufURL   string
+	unregisterProtobufURL string
+	tlsConfig             TLSConfig
+	httpCl                *http.Client
+	stopped               atomic.Bool
 }
 
 func (c *client) Close() {
@@ -74,11 +82,22 @@ func (c *client) ExecuteStatement(statement string) error {
 	return err
 }
 
+func (c *client) ExecuteSQLStatement(statement string) error {
+	_, err := common.CallWithRetryOnUnavailableWithTimeout[int](func() (int, error) {
+		return 0, c.executeStatementAtURL(c.sqlURL, statement)
+	}, c.isStopped, queryRetryDelay, queryRetryTimeout, "")
+	return err
+}
+
 func (c *client) executeStatement(statement string) error {
+	return c.executeStatementAtURL(c.statementURL, statement)
+}
+
+func (c *client) executeStatementAtURL(url string, statement string) error {
 	if statement == "" {
 		return errors.NewTektiteErrorf(errors.StatementError, "statement is empty")
 	}
-	resp, err := c.sendPostRequest(c.statementURL, statement)
+	resp, err := c.sendPostRequest(url, statement)
 	if err != nil {
 		return err
 	}
@@ -145,6 +164,38 @@ func (c *client) UnregisterWasmModule(moduleName string) error {
 	return c.extractError(resp)
 }
 
+func (c *client) RegisterProtobufDescriptors(descriptorSetPath string) error {
+	setBytes, err := os.ReadFile(descriptorSetPath)
+	if err != nil {
+		return errors.NewTektiteErrorf(errors.ProtobufError, "failed to read descriptor set file '%s: %v",
+			descriptorSetPath, err)
+	}
+	name := filepath.Base(descriptorSetPath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	registration, err := json.Marshal(&api.ProtobufRegistration{
+		Name:          name,
+		DescriptorSet: base64.StdEncoding.EncodeToString(setBytes),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.sendPostRequest(c.registerProtobufURL, string(registration))
+	if err != nil {
+		return err
+	}
+	defer closeResponseBody(resp)
+	return c.extractError(resp)
+}
+
+func (c *client) UnregisterProtobufDescriptors(name string) error {
+	resp, err := c.sendPostRequest(c.unregisterProtobufURL, name)
+	if err != nil {
+		return err
+	}
+	defer closeResponseBody(resp)
+	return c.extractError(resp)
+}
+
 func maybeConvertConnectionError(err error) error {
 	if err != nil {
 		var urlErr *url.Error
@@ -164,6 +215,7 @@ type preparedQuery struct {
 	name     string
 	args     []any
 	maxIndex int
+	cursor   string
 }
 
 func newPreparedQuery(c *client, name string) *preparedQuery {
@@ -235,12 +287,16 @@ func (p *preparedQuery) SetNullArg(index int) {
 	p.args[index] = nil
 }
 
+func (p *preparedQuery) SetCursor(cursor string) {
+	p.cursor = cursor
+}
+
 func (p *preparedQuery) Execute() (QueryResult, error) {
-	return p.c.executePreparedQuery(p.name, p.getArgs()...)
+	return p.c.executePreparedQuery(p.name, p.cursor, p.getArgs()...)
 }
 
 func (p *preparedQuery) StreamExecute() (chan StreamChunk, error) {
-	return p.c.streamExecutePreparedQuery(p.name, p.getArgs()...)
+	return p.c.streamExecutePreparedQuery(p.name, p.cursor, p.getArgs()...)
 }
 
 func (c *client) GetPreparedQuery(queryName string) PreparedQuery {
@@ -251,11 +307,15 @@ func (c *client) ExecuteQuery(query string) (QueryResult, error) {
 	return c.executeQuery(c.queryURL, query)
 }
 
-func (c *client) executePreparedQuery(queryName string, args ...any) (QueryResult, error) {
-	return c.executeQuery(c.execPSURL, createExecutePSBody(queryName, args...))
+func (c *client) ExecuteSQL(sql string) (QueryResult, error) {
+	return c.executeQuery(c.sqlURL, sql)
+}
+
+func (c *client) executePreparedQuery(queryName string, cursor string, args ...any) (QueryResult, error) {
+	return c.executeQuery(c.execPSURL, createExecutePSBody(queryName, cursor, args...))
 }
 
-func createExecutePSBody(queryName string, args ...any) string {
+func createExecutePSBody(queryName string, cursor string, args ...any) string {
 	var builder strings.Builder
 	builder.WriteString(`{"QueryName":"`)
 	builder.WriteString(queryName)
@@ -294,7 +354,12 @@ func createExecutePSBody(queryName string, args ...any) string {
 			builder.WriteRune(',')
 		}
 	}
-	builder.WriteString(`]}`)
+	builder.WriteRune(']')
+	if cursor != "" {
+		builder.WriteString(`,"Cursor":`)
+		builder.WriteString(strconv.Quote(cursor))
+	}
+	builder.WriteRune('}')
 	return builder.String()
 }
 
@@ -338,7 +403,7 @@ func (c *client) executeQuery0(url string, query string) (QueryResult, error) {
 		bigBatch = batches[0]
 	}
 
-	return &arrowBasedQueryResult{batch: bigBatch}, nil
+	return &arrowBasedQueryResult{batch: bigBatch, cursor: resp.Header.Get(api.CursorHeaderName)}, nil
 }
 
 func combineBatches(schema *evbatch.EventSchema, batches []*evbatch.Batch) *evbatch.Batch {
@@ -358,8 +423,12 @@ func (c *client) StreamExecuteQuery(query string) (chan StreamChunk, error) {
 	return c.streamExecQueryWithRetry(c.queryURL, query)
 }
 
-func (c *client) streamExecutePreparedQuery(queryName string, args ...any) (chan StreamChunk, error) {
-	return c.streamExecQueryWithRetry(c.execPSURL, createExecutePSBody(queryName, args...))
+func (c *client) StreamExecuteSQL(sql string) (chan StreamChunk, error) {
+	return c.streamExecQueryWithRetry(c.sqlURL, sql)
+}
+
+func (c *client) streamExecutePreparedQuery(queryName string, cursor string, args ...any) (chan StreamChunk, error) {
+	return c.streamExecQueryWithRetry(c.execPSURL, createExecutePSBody(queryName, cursor, args...))
 }
 
 func (c *client) streamExecQueryWithRetry(url string, query string) (chan StreamChunk, error) {
@@ -378,7 +447,7 @@ func (c *client) streamExecQuery(url string, query string) (chan StreamChunk, er
 	}
 	ch := make(chan StreamChunk, 1000)
 	go func() {
-		c.streamQueryResults(resp.Body, ch)
+		c.streamQueryResults(resp.Body, resp.Header.Get(api.CursorHeaderName), ch)
 		closeResponseBody(resp)
 	}()
 	return ch, nil
@@ -404,7 +473,7 @@ func readLengthPrefixed(stream io.Reader, ch chan StreamChunk) ([]byte, bool) {
 	return buf, true
 }
 
-func (c *client) streamQueryResults(bodyStream io.Reader, ch chan StreamChunk) {
+func (c *client) streamQueryResults(bodyStream io.Reader, cursor string, ch chan StreamChunk) {
 	headersBuf, ok := readLengthPrefixed(bodyStream, ch)
 	if !ok {
 		return
@@ -421,7 +490,7 @@ func (c *client) streamQueryResults(bodyStream io.Reader, ch chan StreamChunk) {
 			return
 		}
 		batch := api.DecodeArrowBatch(schema, batchBuf)
-		ch <- StreamChunk{Chunk: &arrowBasedQueryResult{batch}}
+		ch <- StreamChunk{Chunk: &arrowBasedQueryResult{batch: batch, cursor: cursor}}
 	}
 }
 
@@ -456,7 +525,8 @@ func closeResponseBody(resp *http.Response) {
 }
 
 type arrowBasedQueryResult struct {
-	batch *evbatch.Batch
+	batch  *evbatch.Batch
+	cursor string
 }
 
 func (a *arrowBasedQueryResult) ColumnNames() []string {
@@ -471,6 +541,10 @@ func (a *arrowBasedQueryResult) Meta() Meta {
 	return a
 }
 
+func (a *arrowBasedQueryResult) Cursor() string {
+	return a.cursor
+}
+
 func (a *arrowBasedQueryResult) ColumnCount() int {
 	return len(a.batch.Schema.ColumnTypes())
 }
@@ -523,6 +597,10 @@ func (a *arrowBasedColumn) TimestampVal(rowIndex int) types.Timestamp {
 	return a.col.(*evbatch.TimestampColumn).Get(rowIndex)
 }
 
+func (a *arrowBasedColumn) NestedVal(rowIndex int) any {
+	return a.col.(*evbatch.NestedColumn).Get(rowIndex)
+}
+
 type arrowBasedRow struct {
 	rowIndex int
 	qr       *arrowBasedQueryResult
@@ -559,3 +637,7 @@ func (a *arrowBasedRow) BytesVal(colIndex int) []byte {
 func (a *arrowBasedRow) TimestampVal(colIndex int) types.Timestamp {
 	return a.qr.batch.Columns[colIndex].(*evbatch.TimestampColumn).Get(a.rowIndex)
 }
+
+func (a *arrowBasedRow) NestedVal(colIndex int) any {
+	return a.qr.batch.Columns[colIndex].(*evbatch.NestedColumn).Get(a.rowIndex)
+}
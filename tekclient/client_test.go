@@ -8,8 +8,10 @@ import (
 	"github.com/spirit-labs/tektite/command"
 	"github.com/spirit-labs/tektite/conf"
 	"github.com/spirit-labs/tektite/evbatch"
+	"github.com/spirit-labs/tektite/opers"
 	"github.com/spirit-labs/tektite/parser"
 	"github.com/spirit-labs/tektite/protos/v1/clustermsgs"
+	"github.com/spirit-labs/tektite/query"
 	"github.com/spirit-labs/tektite/remoting"
 	"github.com/spirit-labs/tektite/testutils"
 	"github.com/spirit-labs/tektite/types"
@@ -62,7 +64,7 @@ func testExecuteCommand(t *testing.T, command string) {
 func TestExecuteCommandError(t *testing.T) {
 	tsl := `test_stream := (broodge from test_topic partitions = 23) -> (store stream)`
 	testExecuteCommandError(t, tsl,
-		`expected one of: 'aggregate', 'backfill', 'bridge', 'filter', 'join', 'kafka', 'partition', 'producer', 'project', 'store', 'topic', 'union' (line 1 column 17):
+		`expected one of: 'aggregate', 'backfill', 'bridge', 'decode_json', 'dedup', 'filter', 'join', 'kafka', 'partition', 'producer', 'project', 'split', 'store', 'topic', 'topn', 'union', 'unnest' (line 1 column 17):
 test_stream := (broodge from test_topic partitions = 23) -> (store stream)
                 ^`)
 	testExecuteCommandError(t, "adasdasdasd", "reached end of statement")
@@ -109,7 +111,7 @@ func TestExecuteQueryError(t *testing.T) {
 qwdqwdqwdqwd
 ^`)
 	testExecuteQueryError(t, "(scran all from some_table)",
-		`expected one of: 'get', 'scan', 'project', 'filter', 'sort' but found 'scran' (line 1 column 2):
+		`expected one of: 'get', 'scan', 'project', 'filter', 'aggregate', 'join', 'sort', 'limit', 'unnest', 'decode_json' but found 'scran' (line 1 column 2):
 (scran all from some_table)
  ^`)
 }
@@ -167,7 +169,7 @@ func TestStreamExecuteQueryError(t *testing.T) {
 qwdqwdqwdqwd
 ^`)
 	testStreamExecuteQueryError(t, "(scran all from some_table)",
-		`expected one of: 'get', 'scan', 'project', 'filter', 'sort' but found 'scran' (line 1 column 2):
+		`expected one of: 'get', 'scan', 'project', 'filter', 'aggregate', 'join', 'sort', 'limit', 'unnest', 'decode_json' but found 'scran' (line 1 column 2):
 (scran all from some_table)
  ^`)
 }
@@ -313,7 +315,7 @@ func TestPreparedQueryWithNullArgs(t *testing.T) {
 
 func TestPrepareQueryTslError(t *testing.T) {
 	testPrepareQueryError(t, "test_query", "(scran range $start to $end from some_table)",
-		`expected one of: 'get', 'scan', 'project', 'filter', 'sort' but found 'scran' (line 1 column 24):
+		`expected one of: 'get', 'scan', 'project', 'filter', 'aggregate', 'join', 'sort', 'limit', 'unnest', 'decode_json' but found 'scran' (line 1 column 24):
 prepare test_query := (scran range $start to $end from some_table)
                        ^`)
 }
@@ -398,7 +400,33 @@ func TestExecuteRegisterUnregisterWasmModule(t *testing.T) {
 	require.True(t, moduleManager.unregisterCalled.Load())
 }
 
+func TestExecuteRegisterUnregisterProtobufDescriptors(t *testing.T) {
+	descriptorManager := &testProtobufDescriptorManager{}
+	server, _, _, _, cl := setupWithDescriptorManager(t, descriptorManager)
+	defer func() {
+		cl.Close()
+		err := server.Stop()
+		require.NoError(t, err)
+	}()
+	err := cl.RegisterProtobufDescriptors("testdata/protobuf/orders.desc")
+	require.NoError(t, err)
+	require.Equal(t, "orders", descriptorManager.registeredName.Load())
+
+	err = cl.UnregisterProtobufDescriptors("orders")
+	require.NoError(t, err)
+	require.Equal(t, "orders", descriptorManager.unregisteredName.Load())
+
+	err = cl.RegisterProtobufDescriptors("testdata/protobuf/missing.desc")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to read descriptor set file")
+}
+
 func setup(t *testing.T) (*api.HTTPAPIServer, *testQueryManager, *testCommandManager, *testWasmModuleManager, Client) {
+	return setupWithDescriptorManager(t, &testProtobufDescriptorManager{})
+}
+
+func setupWithDescriptorManager(t *testing.T, descriptorManager *testProtobufDescriptorManager) (*api.HTTPAPIServer,
+	*testQueryManager, *testCommandManager, *testWasmModuleManager, Client) {
 	queryMgr := &testQueryManager{}
 	commandMgr := &testCommandManager{}
 	tlsConf := conf.TLSConfig{
@@ -409,7 +437,7 @@ func setup(t *testing.T) (*api.HTTPAPIServer, *testQueryManager, *testCommandMan
 	moduleManager := &testWasmModuleManager{}
 	address := fmt.Sprintf("localhost:%d", testutils.PortProvider.GetPort(t))
 	server := api.NewHTTPAPIServer(address, "/tektite", queryMgr, commandMgr,
-		parser.NewParser(nil), moduleManager, tlsConf)
+		parser.NewParser(nil), moduleManager, descriptorManager, tlsConf)
 	err := server.Activate()
 	require.NoError(t, err)
 	clientTLSConfig := TLSConfig{
@@ -441,10 +469,50 @@ func (t *testQueryManager) GetLastFlushedVersion() int {
 	return 0
 }
 
+func (t *testQueryManager) ExecuteQueryDirectWithArgs(string, parser.PrepareQueryDesc, []any, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectWithHighestVersion(string, parser.QueryDesc, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectIfChanged(string, parser.QueryDesc, int64, int64, query.Limits,
+	func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectByKey(string, parser.QueryDesc, int64, query.Limits) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) ExecuteQueryDirectForChangedKeys(parser.QueryDesc, int64, int64) (*query.KeyedResults, bool, error) {
+	panic("not implemented")
+}
+
+func (t *testQueryManager) RegisterCompletedVersionListener(string, func(version int64)) {
+}
+
+func (t *testQueryManager) UnregisterCompletedVersionListener(string) {
+}
+
+func (t *testQueryManager) RunningQueries() []query.RunningQuery {
+	return nil
+}
+
+func (t *testQueryManager) CancelQuery(string) error {
+	return nil
+}
+
+func (t *testQueryManager) CancelRemoteQuery(*clustermsgs.QueryCancelMessage) {
+}
+
 func (t *testQueryManager) Activate() {
 }
 
-func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) error {
+func (t *testQueryManager) ExecuteQueryDirect(tsl string, _ parser.QueryDesc, _ query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	t.directQuerytsl = tsl
@@ -500,7 +568,7 @@ func (t *testQueryManager) PrepareQuery(parser.PrepareQueryDesc) error {
 	return nil
 }
 
-func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, _ query.Limits, outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	t.queryName = queryName
@@ -509,12 +577,12 @@ func (t *testQueryManager) ExecutePreparedQuery(queryName string, args []any, ou
 	return 0, nil
 }
 
-func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch) error) {
+func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) {
 	go func() {
 		t.lock.Lock()
 		defer t.lock.Unlock()
 		for _, info := range t.batches {
-			err := outputFunc(info.last, t.numLast, info.batch)
+			err := outputFunc(info.last, t.numLast, info.batch, nil)
 			if err != nil {
 				panic(err)
 			}
@@ -522,7 +590,7 @@ func (t *testQueryManager) sendBatches(outputFunc func(last bool, numLastBatches
 	}()
 }
 
-func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, func(last bool, numLastBatches int, batch *evbatch.Batch) error) (int, error) {
+func (t *testQueryManager) ExecutePreparedQueryWithHighestVersion(string, []any, int64, opers.PageStart, query.Limits, func(last bool, numLastBatches int, batch *evbatch.Batch, err error) error) (int, error) {
 	return 0, nil
 }
 
@@ -556,6 +624,22 @@ func (t *testQueryManager) GetPreparedQueryParamSchema(string) *evbatch.EventSch
 	return t.paramsSchema
 }
 
+func (t *testQueryManager) GetPreparedQueryLimit(string) int {
+	return 0
+}
+
+func (t *testQueryManager) GetPreparedQueryNextPage(string, opers.PageStart, *evbatch.Batch) *opers.PageStart {
+	return nil
+}
+
+func (t *testQueryManager) GetPreparedQueryResultSchema(string) *evbatch.EventSchema {
+	return nil
+}
+
+func (t *testQueryManager) GetQueryResultSchema(parser.QueryDesc, []parser.PreparedStatementParam) (*evbatch.EventSchema, error) {
+	return nil, nil
+}
+
 type testCommandManager struct {
 	lock sync.Mutex
 	tsl  string
@@ -743,3 +827,18 @@ func (t *testWasmModuleManager) UnregisterModule(string) error {
 	t.unregisterCalled.Store(true)
 	return nil
 }
+
+type testProtobufDescriptorManager struct {
+	registeredName   atomic.Value
+	unregisteredName atomic.Value
+}
+
+func (t *testProtobufDescriptorManager) RegisterDescriptors(name string, _ []byte) error {
+	t.registeredName.Store(name)
+	return nil
+}
+
+func (t *testProtobufDescriptorManager) UnregisterDescriptors(name string) error {
+	t.unregisteredName.Store(name)
+	return nil
+}
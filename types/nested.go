@@ -0,0 +1,385 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spirit-labs/tektite/errors"
+	"strings"
+	"time"
+)
+
+// Nested column types hold values made up of other column types. Values are represented as:
+//
+//	array<T>         - []any, with one entry per element
+//	map<string,T>    - map[string]any
+//	struct<a:T,...>  - []any, with one entry per field in the order the fields are declared
+//
+// Elements are represented as the Go type used for their column type (int64, float64, bool, Decimal, string, []byte,
+// Timestamp or a nested value), or nil if they are null.
+
+// ArrayType is the type of an ordered list of elements of the same type.
+type ArrayType struct {
+	ElemType ColumnType
+}
+
+func (a *ArrayType) ID() ColumnTypeID {
+	return ColumnTypeIDArray
+}
+
+func (a *ArrayType) String() string {
+	return "array<" + a.ElemType.String() + ">"
+}
+
+// MapType is the type of a map with string keys and values of the same type.
+type MapType struct {
+	ValueType ColumnType
+}
+
+func (m *MapType) ID() ColumnTypeID {
+	return ColumnTypeIDMap
+}
+
+func (m *MapType) String() string {
+	return "map<string," + m.ValueType.String() + ">"
+}
+
+// StructType is the type of a record with named fields, each of which has its own type.
+type StructType struct {
+	FieldNames []string
+	FieldTypes []ColumnType
+}
+
+func (s *StructType) ID() ColumnTypeID {
+	return ColumnTypeIDStruct
+}
+
+func (s *StructType) String() string {
+	var sb strings.Builder
+	sb.WriteString("struct<")
+	for i, fieldName := range s.FieldNames {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(fieldName)
+		sb.WriteString(":")
+		sb.WriteString(s.FieldTypes[i].String())
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
+
+// FieldIndex returns the index of the field with the name, or -1 if there is no such field.
+func (s *StructType) FieldIndex(fieldName string) int {
+	for i, name := range s.FieldNames {
+		if name == fieldName {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsNestedType returns true if the column type is an array, map or struct.
+func IsNestedType(columnType ColumnType) bool {
+	id := columnType.ID()
+	return id == ColumnTypeIDArray || id == ColumnTypeIDMap || id == ColumnTypeIDStruct
+}
+
+// ToJSONValue converts a value of the column type to the value used when it is encoded as JSON. Decimals and bytes
+// are converted to strings, timestamps to unix millis past epoch, arrays to JSON arrays and maps and structs to JSON
+// objects. Struct fields keep their declared order.
+func ToJSONValue(columnType ColumnType, val any) any {
+	if val == nil {
+		return nil
+	}
+	switch columnType.ID() {
+	case ColumnTypeIDDecimal:
+		d := val.(Decimal)
+		return d.String()
+	case ColumnTypeIDBytes:
+		return string(val.([]byte))
+	case ColumnTypeIDTimestamp:
+		return val.(Timestamp).Val
+	case ColumnTypeIDArray:
+		elemType := columnType.(*ArrayType).ElemType
+		arr := val.([]any)
+		res := make([]any, len(arr))
+		for i, elem := range arr {
+			res[i] = ToJSONValue(elemType, elem)
+		}
+		return res
+	case ColumnTypeIDMap:
+		valueType := columnType.(*MapType).ValueType
+		m := val.(map[string]any)
+		res := make(map[string]any, len(m))
+		for k, v := range m {
+			res[k] = ToJSONValue(valueType, v)
+		}
+		return res
+	case ColumnTypeIDStruct:
+		structType := columnType.(*StructType)
+		fields := val.([]any)
+		res := &jsonObject{names: structType.FieldNames, vals: make([]any, len(fields))}
+		for i, field := range fields {
+			res.vals[i] = ToJSONValue(structType.FieldTypes[i], field)
+		}
+		return res
+	default:
+		return val
+	}
+}
+
+// jsonObject is a JSON object whose fields are marshalled in order.
+type jsonObject struct {
+	names []string
+	vals  []any
+}
+
+func (j *jsonObject) MarshalJSON() ([]byte, error) {
+	var buff bytes.Buffer
+	buff.WriteByte('{')
+	for i, name := range j.names {
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		bName, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buff.Write(bName)
+		buff.WriteByte(':')
+		bVal, err := json.Marshal(j.vals[i])
+		if err != nil {
+			return nil, err
+		}
+		buff.Write(bVal)
+	}
+	buff.WriteByte('}')
+	return buff.Bytes(), nil
+}
+
+// FromJSONValue converts a value decoded from JSON, with numbers decoded as json.Number, to a value of the column type.
+// It is the inverse of ToJSONValue: decimals can be JSON numbers or strings, bytes are JSON strings, timestamps are
+// unix millis past epoch or RFC 3339 strings, arrays are JSON arrays and maps and structs are JSON objects. Struct
+// fields which are missing from the object are null, and object fields which are not in the struct are ignored. An
+// error is returned if the JSON value cannot be converted to the column type.
+func FromJSONValue(columnType ColumnType, val any) (any, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch columnType.ID() {
+	case ColumnTypeIDInt:
+		if num, ok := val.(json.Number); ok {
+			if i, err := num.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case ColumnTypeIDFloat:
+		if num, ok := val.(json.Number); ok {
+			if f, err := num.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case ColumnTypeIDBool:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+	case ColumnTypeIDDecimal:
+		var s string
+		switch v := val.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			s = v
+		default:
+			return nil, jsonConversionError(columnType, val)
+		}
+		decType := columnType.(*DecimalType)
+		d, err := NewDecimalFromString(s, decType.Precision, decType.Scale)
+		if err != nil {
+			return nil, jsonConversionError(columnType, val)
+		}
+		return d, nil
+	case ColumnTypeIDString:
+		if str, ok := val.(string); ok {
+			return str, nil
+		}
+	case ColumnTypeIDBytes:
+		if str, ok := val.(string); ok {
+			return []byte(str), nil
+		}
+	case ColumnTypeIDTimestamp:
+		switch v := val.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return NewTimestamp(i), nil
+			}
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return NewTimestamp(t.UnixMilli()), nil
+			}
+		}
+	case ColumnTypeIDArray:
+		if arr, ok := val.([]any); ok {
+			elemType := columnType.(*ArrayType).ElemType
+			res := make([]any, len(arr))
+			for i, elem := range arr {
+				v, err := FromJSONValue(elemType, elem)
+				if err != nil {
+					return nil, err
+				}
+				res[i] = v
+			}
+			return res, nil
+		}
+	case ColumnTypeIDMap:
+		if m, ok := val.(map[string]any); ok {
+			valueType := columnType.(*MapType).ValueType
+			res := make(map[string]any, len(m))
+			for k, elem := range m {
+				v, err := FromJSONValue(valueType, elem)
+				if err != nil {
+					return nil, err
+				}
+				res[k] = v
+			}
+			return res, nil
+		}
+	case ColumnTypeIDStruct:
+		if m, ok := val.(map[string]any); ok {
+			structType := columnType.(*StructType)
+			res := make([]any, len(structType.FieldNames))
+			for i, fieldName := range structType.FieldNames {
+				v, err := FromJSONValue(structType.FieldTypes[i], m[fieldName])
+				if err != nil {
+					return nil, errors.Errorf("field '%s': %v", fieldName, err)
+				}
+				res[i] = v
+			}
+			return res, nil
+		}
+	}
+	return nil, jsonConversionError(columnType, val)
+}
+
+func jsonConversionError(columnType ColumnType, val any) error {
+	var kind string
+	switch val.(type) {
+	case json.Number:
+		kind = "number"
+	case string:
+		kind = "string"
+	case bool:
+		kind = "bool"
+	case []any:
+		kind = "array"
+	case map[string]any:
+		kind = "object"
+	default:
+		kind = fmt.Sprintf("%T", val)
+	}
+	if kind == "number" || kind == "string" || kind == "bool" {
+		buff, _ := json.Marshal(val)
+		return errors.Errorf("cannot convert JSON %s %s to %s", kind, string(buff), columnType.String())
+	}
+	return errors.Errorf("cannot convert JSON %s to %s", kind, columnType.String())
+}
+
+func isNestedType(sColumnType string) bool {
+	return strings.HasPrefix(sColumnType, "array<") || strings.HasPrefix(sColumnType, "map<") ||
+		strings.HasPrefix(sColumnType, "struct<")
+}
+
+func parseNestedType(sColumnType string) (ColumnType, error) {
+	start := strings.IndexRune(sColumnType, '<')
+	if !strings.HasSuffix(sColumnType, ">") {
+		return nil, errors.Errorf("invalid type '%s'", sColumnType)
+	}
+	kind := sColumnType[:start]
+	args, err := splitTypeArgs(sColumnType[start+1 : len(sColumnType)-1])
+	if err != nil {
+		return nil, errors.Errorf("invalid type '%s'", sColumnType)
+	}
+	switch kind {
+	case "array":
+		if len(args) != 1 {
+			return nil, errors.Errorf("invalid type '%s', array must have a single element type", sColumnType)
+		}
+		elemType, err := StringToColumnType(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayType{ElemType: elemType}, nil
+	case "map":
+		if len(args) != 2 {
+			return nil, errors.Errorf("invalid type '%s', map must have a key type and a value type", sColumnType)
+		}
+		if strings.Trim(args[0], " \t") != "string" {
+			return nil, errors.Errorf("invalid type '%s', map key type must be string", sColumnType)
+		}
+		valueType, err := StringToColumnType(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &MapType{ValueType: valueType}, nil
+	default:
+		if len(args) == 0 {
+			return nil, errors.Errorf("invalid type '%s', struct must have at least one field", sColumnType)
+		}
+		structType := &StructType{}
+		for _, arg := range args {
+			colonIndex := strings.IndexRune(arg, ':')
+			if colonIndex == -1 {
+				return nil, errors.Errorf("invalid type '%s', struct fields must be of form 'name:type'", sColumnType)
+			}
+			fieldName := strings.Trim(arg[:colonIndex], " \t")
+			if fieldName == "" {
+				return nil, errors.Errorf("invalid type '%s', struct field name must be specified", sColumnType)
+			}
+			if structType.FieldIndex(fieldName) != -1 {
+				return nil, errors.Errorf("invalid type '%s', struct field '%s' is duplicated", sColumnType, fieldName)
+			}
+			fieldType, err := StringToColumnType(arg[colonIndex+1:])
+			if err != nil {
+				return nil, err
+			}
+			structType.FieldNames = append(structType.FieldNames, fieldName)
+			structType.FieldTypes = append(structType.FieldTypes, fieldType)
+		}
+		return structType, nil
+	}
+}
+
+// splitTypeArgs splits the arguments of a nested type on the commas that are not inside the arguments of another type.
+func splitTypeArgs(s string) ([]string, error) {
+	var args []string
+	depth := 0
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '<', '(':
+			depth++
+		case '>', ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced brackets")
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets")
+	}
+	last := s[start:]
+	if strings.Trim(last, " \t") == "" {
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return nil, errors.New("empty type argument")
+	}
+	return append(args, last), nil
+}
@@ -0,0 +1,134 @@
+package types
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestStringToNestedColumnType(t *testing.T) {
+	testStringToColumnType(t, "array<int>", &ArrayType{ElemType: ColumnTypeInt})
+	testStringToColumnType(t, "array<decimal(10,2)>", &ArrayType{ElemType: &DecimalType{Precision: 10, Scale: 2}})
+	testStringToColumnType(t, "array<array<string>>", &ArrayType{ElemType: &ArrayType{ElemType: ColumnTypeString}})
+	testStringToColumnType(t, "map<string,float>", &MapType{ValueType: ColumnTypeFloat})
+	testStringToColumnType(t, "map<string,map<string,bytes>>",
+		&MapType{ValueType: &MapType{ValueType: ColumnTypeBytes}})
+	testStringToColumnType(t, "struct<a:int,b:decimal(10,2),c:array<timestamp>,d:map<string,bool>>", &StructType{
+		FieldNames: []string{"a", "b", "c", "d"},
+		FieldTypes: []ColumnType{ColumnTypeInt, &DecimalType{Precision: 10, Scale: 2},
+			&ArrayType{ElemType: ColumnTypeTimestamp}, &MapType{ValueType: ColumnTypeBool}},
+	})
+}
+
+func TestStringToNestedColumnTypeWhitespace(t *testing.T) {
+	ct, err := StringToColumnType("struct< a : int, b: decimal(10, 2) , c:map<string, array<int>> >")
+	require.NoError(t, err)
+	require.Equal(t, "struct<a:int,b:decimal(10,2),c:map<string,array<int>>>", ct.String())
+}
+
+func testStringToColumnType(t *testing.T, s string, expected ColumnType) {
+	ct, err := StringToColumnType(s)
+	require.NoError(t, err)
+	require.True(t, ColumnTypesEqual(expected, ct))
+	require.Equal(t, s, ct.String())
+}
+
+func TestStringToNestedColumnTypeInvalid(t *testing.T) {
+	testStringToColumnTypeInvalid(t, "array<>", "invalid type 'array<>', array must have a single element type")
+	testStringToColumnTypeInvalid(t, "array<int,int>", "invalid type 'array<int,int>', array must have a single element type")
+	testStringToColumnTypeInvalid(t, "array<foo>", "invalid type 'foo'")
+	testStringToColumnTypeInvalid(t, "array<int", "invalid type 'array<int'")
+	testStringToColumnTypeInvalid(t, "array<array<int>", "invalid type 'array<array<int>'")
+	testStringToColumnTypeInvalid(t, "map<int,int>", "invalid type 'map<int,int>', map key type must be string")
+	testStringToColumnTypeInvalid(t, "map<string>", "invalid type 'map<string>', map must have a key type and a value type")
+	testStringToColumnTypeInvalid(t, "struct<>", "invalid type 'struct<>', struct must have at least one field")
+	testStringToColumnTypeInvalid(t, "struct<int>", "invalid type 'struct<int>', struct fields must be of form 'name:type'")
+	testStringToColumnTypeInvalid(t, "struct<:int>", "invalid type 'struct<:int>', struct field name must be specified")
+	testStringToColumnTypeInvalid(t, "struct<a:int,a:string>", "invalid type 'struct<a:int,a:string>', struct field 'a' is duplicated")
+	testStringToColumnTypeInvalid(t, "struct<a:int,>", "invalid type 'struct<a:int,>'")
+}
+
+func testStringToColumnTypeInvalid(t *testing.T, s string, expectedMsg string) {
+	_, err := StringToColumnType(s)
+	require.Error(t, err)
+	require.Equal(t, expectedMsg, err.Error())
+}
+
+func TestNestedColumnTypesEqual(t *testing.T) {
+	require.True(t, ColumnTypesEqual(&ArrayType{ElemType: ColumnTypeInt}, &ArrayType{ElemType: ColumnTypeInt}))
+	require.False(t, ColumnTypesEqual(&ArrayType{ElemType: ColumnTypeInt}, &ArrayType{ElemType: ColumnTypeFloat}))
+	require.False(t, ColumnTypesEqual(&ArrayType{ElemType: &DecimalType{Precision: 10, Scale: 2}},
+		&ArrayType{ElemType: &DecimalType{Precision: 10, Scale: 3}}))
+	require.False(t, ColumnTypesEqual(&ArrayType{ElemType: ColumnTypeInt}, &MapType{ValueType: ColumnTypeInt}))
+	require.True(t, ColumnTypesEqual(&MapType{ValueType: ColumnTypeString}, &MapType{ValueType: ColumnTypeString}))
+	require.False(t, ColumnTypesEqual(&MapType{ValueType: ColumnTypeString}, &MapType{ValueType: ColumnTypeBytes}))
+	s1 := &StructType{FieldNames: []string{"a", "b"}, FieldTypes: []ColumnType{ColumnTypeInt, ColumnTypeString}}
+	s2 := &StructType{FieldNames: []string{"a", "b"}, FieldTypes: []ColumnType{ColumnTypeInt, ColumnTypeString}}
+	s3 := &StructType{FieldNames: []string{"a", "c"}, FieldTypes: []ColumnType{ColumnTypeInt, ColumnTypeString}}
+	s4 := &StructType{FieldNames: []string{"a"}, FieldTypes: []ColumnType{ColumnTypeInt}}
+	s5 := &StructType{FieldNames: []string{"a", "b"}, FieldTypes: []ColumnType{ColumnTypeInt, ColumnTypeInt}}
+	require.True(t, ColumnTypesEqual(s1, s2))
+	require.False(t, ColumnTypesEqual(s1, s3))
+	require.False(t, ColumnTypesEqual(s1, s4))
+	require.False(t, ColumnTypesEqual(s1, s5))
+	require.Equal(t, 1, s1.FieldIndex("b"))
+	require.Equal(t, -1, s1.FieldIndex("c"))
+}
+
+func TestToJSONValue(t *testing.T) {
+	colType, err := StringToColumnType("struct<z:decimal(10,2),a:bytes,ts:timestamp,arr:array<int>,m:map<string,string>,n:int>")
+	require.NoError(t, err)
+	val := []any{NewDecimalFromInt64(12345, 10, 2), []byte("foo"), NewTimestamp(1234), []any{int64(1), nil},
+		map[string]any{"y": "b", "x": nil}, nil}
+	buff, err := json.Marshal(ToJSONValue(colType, val))
+	require.NoError(t, err)
+	require.Equal(t, `{"z":"12345.00","a":"foo","ts":1234,"arr":[1,null],"m":{"x":null,"y":"b"},"n":null}`, string(buff))
+	require.Nil(t, ToJSONValue(colType, nil))
+	require.Equal(t, 1.5, ToJSONValue(ColumnTypeFloat, 1.5))
+}
+
+func TestFromJSONValue(t *testing.T) {
+	colType, err := StringToColumnType("struct<z:decimal(10,2),a:bytes,ts:timestamp,arr:array<int>,m:map<string,string>,n:int,f:float,b:bool>")
+	require.NoError(t, err)
+	dec := json.NewDecoder(strings.NewReader(`{"z":"12345.00","a":"foo","ts":1234,"arr":[1,null],"m":{"x":null,"y":"b"},"f":1.5,"b":true,"extra":1}`))
+	dec.UseNumber()
+	var jv any
+	require.NoError(t, dec.Decode(&jv))
+	val, err := FromJSONValue(colType, jv)
+	require.NoError(t, err)
+	require.Equal(t, []any{NewDecimalFromInt64(12345, 10, 2), []byte("foo"), NewTimestamp(1234), []any{int64(1), nil},
+		map[string]any{"y": "b", "x": nil}, nil, 1.5, true}, val)
+
+	// And back again
+	buff, err := json.Marshal(ToJSONValue(colType, val))
+	require.NoError(t, err)
+	require.Equal(t, `{"z":"12345.00","a":"foo","ts":1234,"arr":[1,null],"m":{"x":null,"y":"b"},"n":null,"f":1.5,"b":true}`, string(buff))
+
+	val, err = FromJSONValue(&DecimalType{Precision: 10, Scale: 2}, json.Number("1.5"))
+	require.NoError(t, err)
+	d := val.(Decimal)
+	require.Equal(t, "1.50", d.String())
+	val, err = FromJSONValue(ColumnTypeTimestamp, "2024-01-02T03:04:05.006Z")
+	require.NoError(t, err)
+	require.Equal(t, NewTimestamp(1704164645006), val)
+	val, err = FromJSONValue(ColumnTypeInt, nil)
+	require.NoError(t, err)
+	require.Nil(t, val)
+}
+
+func TestFromJSONValueInvalid(t *testing.T) {
+	testFromJSONValueInvalid(t, ColumnTypeInt, json.Number("1.5"), "cannot convert JSON number 1.5 to int")
+	testFromJSONValueInvalid(t, ColumnTypeString, json.Number("1"), "cannot convert JSON number 1 to string")
+	testFromJSONValueInvalid(t, ColumnTypeBool, "true", `cannot convert JSON string "true" to bool`)
+	testFromJSONValueInvalid(t, &DecimalType{Precision: 10, Scale: 2}, "abc", `cannot convert JSON string "abc" to decimal(10,2)`)
+	testFromJSONValueInvalid(t, &ArrayType{ElemType: ColumnTypeInt}, map[string]any{}, "cannot convert JSON object to array<int>")
+	testFromJSONValueInvalid(t, &StructType{FieldNames: []string{"a"}, FieldTypes: []ColumnType{ColumnTypeInt}},
+		map[string]any{"a": "x"}, `field 'a': cannot convert JSON string "x" to int`)
+}
+
+func testFromJSONValueInvalid(t *testing.T, columnType ColumnType, val any, expectedMsg string) {
+	_, err := FromJSONValue(columnType, val)
+	require.Error(t, err)
+	require.Equal(t, expectedMsg, err.Error())
+}
@@ -25,6 +25,9 @@ const (
 	ColumnTypeIDString
 	ColumnTypeIDBytes
 	ColumnTypeIDTimestamp
+	ColumnTypeIDArray
+	ColumnTypeIDMap
+	ColumnTypeIDStruct
 )
 
 var ColumnTypeInt = &nonParameterizedType{id: ColumnTypeIDInt}
@@ -63,6 +66,7 @@ func (n nonParameterizedType) String() string {
 
 func StringToColumnType(sColumnType string) (ColumnType, error) {
 	var cType ColumnType
+	sColumnType = strings.Trim(sColumnType, " \t")
 	switch sColumnType {
 	case "int":
 		cType = ColumnTypeInt
@@ -83,6 +87,12 @@ func StringToColumnType(sColumnType string) (ColumnType, error) {
 				return nil, err
 			}
 			cType = decType
+		} else if isNestedType(sColumnType) {
+			nestedType, err := parseNestedType(sColumnType)
+			if err != nil {
+				return nil, err
+			}
+			cType = nestedType
 		} else {
 			return nil, errors.Errorf("invalid type '%s'", sColumnType)
 		}
@@ -145,6 +155,24 @@ func ColumnTypesEqual(ct1 ColumnType, ct2 ColumnType) bool {
 	if ct1.ID() != ct2.ID() {
 		return false
 	}
+	switch ct1.ID() {
+	case ColumnTypeIDArray:
+		return ColumnTypesEqual(ct1.(*ArrayType).ElemType, ct2.(*ArrayType).ElemType)
+	case ColumnTypeIDMap:
+		return ColumnTypesEqual(ct1.(*MapType).ValueType, ct2.(*MapType).ValueType)
+	case ColumnTypeIDStruct:
+		s1 := ct1.(*StructType)
+		s2 := ct2.(*StructType)
+		if len(s1.FieldNames) != len(s2.FieldNames) {
+			return false
+		}
+		for i, fieldName := range s1.FieldNames {
+			if fieldName != s2.FieldNames[i] || !ColumnTypesEqual(s1.FieldTypes[i], s2.FieldTypes[i]) {
+				return false
+			}
+		}
+		return true
+	}
 	d1, ok1 := ct1.(*DecimalType)
 	d2, ok2 := ct2.(*DecimalType)
 	if !ok1 && !ok2 {
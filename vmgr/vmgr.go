@@ -50,9 +50,18 @@ type VersionManager struct {
 	shutdownWg             *sync.WaitGroup
 	activateWg             sync.WaitGroup
 	stuckVersionTimer      *common.TimerHandle
+	lastTimedVersion       int   // The last version whose completion time was stored in the level manager
+	lastTimedVersionTime   int64 // The time in ms at which lastTimedVersion was stored
+	versionRetentions      versionRetentionsProvider
 	failureInfo
 }
 
+// versionRetentionsProvider tells the version manager whether any table keeps older versions of its rows - the times
+// that versions complete only need to be stored if one does
+type versionRetentionsProvider interface {
+	HasVersionRetentions() bool
+}
+
 type flushedEntry struct {
 	flushedVersion int
 	processorCount int
@@ -91,6 +100,7 @@ func NewVersionManager(seqMgr sequence.Manager, levelMgrClient levels.Client, cf
 		lastFlushedVersion:     -1,
 		flushingClusterVersion: -1,
 		shutdownFlushVersion:   -1,
+		lastTimedVersion:       -1,
 	}
 	vmgr.requiredProcessorCount = requiredProcessorCount
 	vmgr.lastFailureFlushedVersion = -1
@@ -670,10 +680,21 @@ func (v *VersionManager) checkStuckVersion(lastVersion int) {
 	v.scheduleStuckVersionCheck(false, v.currentVersion)
 }
 
+// SetVersionRetentionsProvider sets the provider of whether any table keeps older versions of its rows. It must be
+// called before the version manager is started.
+func (v *VersionManager) SetVersionRetentionsProvider(versionRetentions versionRetentionsProvider) {
+	v.versionRetentions = versionRetentions
+}
+
 func (v *VersionManager) storeLastFlushedVersion() {
+	// We check for version retentions before locking, as the provider has its own lock
+	hasVersionRetentions := v.versionRetentions != nil && v.versionRetentions.HasVersionRetentions()
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	defer v.scheduleLastFlushedVersion(false)
+	if hasVersionRetentions {
+		v.maybeStoreVersionTime()
+	}
 	lvf := v.lastVersionToFlush
 	log.Debugf("vmgr last version to flush is %d", lvf)
 	if lvf == -1 || lvf == v.lastFlushedVersion {
@@ -688,6 +709,25 @@ func (v *VersionManager) storeLastFlushedVersion() {
 	log.Debugf("vmgr: version %d has been flushed to level manager", lvf)
 }
 
+// maybeStoreVersionTime stores the time that the last completed version completed in the level manager, at most once
+// every VersionTimeIndexInterval. This allows time-travel queries to find the version to query as of a time. It is
+// only called when a table keeps older versions, so the master record is not written when there is no history.
+func (v *VersionManager) maybeStoreVersionTime() {
+	if v.lastCompletedVersion == -1 || v.lastCompletedVersion == v.lastTimedVersion {
+		return
+	}
+	now := time.Now().UTC().UnixMilli()
+	if now-v.lastTimedVersionTime < v.cfg.VersionTimeIndexInterval.Milliseconds() {
+		return
+	}
+	if err := v.levelMgrClient.StoreVersionTime(int64(v.lastCompletedVersion), now); err != nil {
+		log.Warnf("vmgr:%p failed to store version time: %v", v, err)
+		return
+	}
+	v.lastTimedVersion = v.lastCompletedVersion
+	v.lastTimedVersionTime = now
+}
+
 func (v *VersionManager) SetClusterMessageHandlers(remotingServer remoting.Server) {
 	remotingServer.RegisterBlockingMessageHandler(remoting.ClusterMessageGetVersionMessage, &getVersionHandler{v: v})
 	remotingServer.RegisterMessageHandler(remoting.ClusterMessageVersionCompleteMessage, &versionCompleteHandler{v: v})
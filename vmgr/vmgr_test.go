@@ -54,6 +54,61 @@ func TestCompleteVersion(t *testing.T) {
 	}
 }
 
+func TestStoreVersionTime(t *testing.T) {
+	cfg := &conf.Config{}
+	cfg.ApplyDefaults()
+	cfg.VersionManagerStoreFlushedInterval = 10 * time.Millisecond
+	cfg.VersionTimeIndexInterval = 1 * time.Hour
+	vmgr, remotingServer, vHandler, lmgrClient := setupWithSeqMgrWithActivate(t, sequence.NewInMemSequenceManager(),
+		cfg, true)
+	defer func() {
+		stopVmgr(t, vmgr)
+		err := remotingServer.Stop()
+		require.NoError(t, err)
+	}()
+	<-vHandler.ch
+	vmgr.versionRetentions.(*testVersionRetentions).hasRetentions.Store(true)
+
+	start := time.Now().UTC().UnixMilli()
+	for i := 0; i < 3; i++ {
+		currVersion := mustGetCurrentVersion(vmgr)
+		err := vmgr.VersionComplete(currVersion, 1, 0, false)
+		require.NoError(t, err)
+		// wait for the flushed version timer to fire a few times
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Only the first completed version is stored, as the next one can't be stored until the index interval has passed
+	versionTimes := lmgrClient.getVersionTimes()
+	require.Equal(t, 1, len(versionTimes))
+	timeMs, ok := versionTimes[0]
+	require.True(t, ok)
+	require.GreaterOrEqual(t, timeMs, start)
+}
+
+func TestNoVersionTimeStoredWithoutVersionRetentions(t *testing.T) {
+	cfg := &conf.Config{}
+	cfg.ApplyDefaults()
+	cfg.VersionManagerStoreFlushedInterval = 10 * time.Millisecond
+	cfg.VersionTimeIndexInterval = 1 * time.Millisecond
+	vmgr, remotingServer, vHandler, lmgrClient := setupWithSeqMgrWithActivate(t, sequence.NewInMemSequenceManager(),
+		cfg, true)
+	defer func() {
+		stopVmgr(t, vmgr)
+		err := remotingServer.Stop()
+		require.NoError(t, err)
+	}()
+	<-vHandler.ch
+
+	for i := 0; i < 3; i++ {
+		currVersion := mustGetCurrentVersion(vmgr)
+		err := vmgr.VersionComplete(currVersion, 1, 0, false)
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	// No table keeps older versions, so there is no need to map times to versions
+	require.Equal(t, 0, len(lmgrClient.getVersionTimes()))
+}
+
 func TestIgnoreOlderVersions(t *testing.T) {
 	cfg := &conf.Config{}
 	cfg.ApplyDefaults()
@@ -1329,6 +1384,7 @@ func setupWithSeqMgrWithActivate(t *testing.T, seqMgr sequence.Manager, cfg *con
 	lmgrClient := &testLevelMgrClient{lastFlushedVersion: -1}
 
 	vmgr := NewVersionManager(seqMgr, lmgrClient, cfg, "localhost:7888")
+	vmgr.SetVersionRetentionsProvider(&testVersionRetentions{})
 	err = vmgr.Start()
 	require.NoError(t, err)
 
@@ -1359,11 +1415,20 @@ func mustGetCurrentVersion(vMgr *VersionManager) int {
 	return ver
 }
 
+type testVersionRetentions struct {
+	hasRetentions atomic.Bool
+}
+
+func (t *testVersionRetentions) HasVersionRetentions() bool {
+	return t.hasRetentions.Load()
+}
+
 type testLevelMgrClient struct {
 	lock               sync.Mutex
 	lastFlushedVersion int64
 	unavailable        atomic.Bool
 	deadVersionRange   *levels.VersionRange
+	versionTimes       map[int64]int64
 }
 
 func (t *testLevelMgrClient) GetStats() (levels.Stats, error) {
@@ -1435,6 +1500,34 @@ func (t *testLevelMgrClient) StoreLastFlushedVersion(version int64) error {
 	return nil
 }
 
+func (t *testLevelMgrClient) RegisterVersionRetentions([]retention.PrefixRetention) error {
+	panic("not implemented")
+}
+
+func (t *testLevelMgrClient) StoreVersionTime(version int64, timeMs int64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.versionTimes == nil {
+		t.versionTimes = map[int64]int64{}
+	}
+	t.versionTimes[version] = timeMs
+	return nil
+}
+
+func (t *testLevelMgrClient) getVersionTimes() map[int64]int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	versionTimes := make(map[int64]int64, len(t.versionTimes))
+	for version, timeMs := range t.versionTimes {
+		versionTimes[version] = timeMs
+	}
+	return versionTimes
+}
+
+func (t *testLevelMgrClient) GetVersionForTime(int64) (int64, error) {
+	panic("not implemented")
+}
+
 func (t *testLevelMgrClient) LoadLastFlushedVersion() (int64, error) {
 	if t.unavailable.Load() {
 		return 0, errors.NewTektiteErrorf(errors.Unavailable, "unavailable")
@@ -49,6 +49,9 @@ type RegisteredModule struct {
 type ModuleMetadata struct {
 	ModuleName        string                           `json:"name"`
 	FunctionsMetadata map[string]expr.FunctionMetadata `json:"functions"`
+	// AggregatesMetadata describes user defined aggregate functions. An aggregate function 'foo' must be implemented
+	// by the module exports 'foo_init', 'foo_accumulate', 'foo_merge' and 'foo_finish'.
+	AggregatesMetadata map[string]expr.FunctionMetadata `json:"aggregates,omitempty"`
 }
 
 type modWrapper struct {
@@ -216,31 +219,48 @@ func (m *ModuleManager) getClusterWideLock() error {
 }
 
 func (m *ModuleManager) GetFunctionMetadata(fullFuncName string) (expr.FunctionMetadata, bool, error) {
+	registeredModule, funcName, err := m.getRegisteredModule(fullFuncName)
+	if err != nil || registeredModule == nil {
+		return expr.FunctionMetadata{}, false, err
+	}
+	meta, ok := registeredModule.metaData.FunctionsMetadata[funcName]
+	if !ok {
+		return expr.FunctionMetadata{}, false, nil
+	}
+	return meta, true, nil
+}
+
+func (m *ModuleManager) GetAggregateFunctionMetadata(fullFuncName string) (expr.FunctionMetadata, bool, error) {
+	registeredModule, funcName, err := m.getRegisteredModule(fullFuncName)
+	if err != nil || registeredModule == nil {
+		return expr.FunctionMetadata{}, false, err
+	}
+	meta, ok := registeredModule.metaData.AggregatesMetadata[funcName]
+	if !ok {
+		return expr.FunctionMetadata{}, false, nil
+	}
+	return meta, true, nil
+}
+
+func (m *ModuleManager) getRegisteredModule(fullFuncName string) (*RegisteredModule, string, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 	if !m.started {
-		return expr.FunctionMetadata{}, false, errors.New("not started")
+		return nil, "", errors.New("not started")
 	}
 	modName, funcName, err := extractModAndFuncName(fullFuncName)
 	if err != nil {
-		return expr.FunctionMetadata{}, false, err
+		return nil, "", err
 	}
 	registeredModule, ok := m.registeredModules[modName]
 	if !ok {
 		// Lazy load
 		registeredModule, err = m.maybeLoadModule(modName)
 		if err != nil {
-			return expr.FunctionMetadata{}, false, err
-		}
-		if registeredModule == nil {
-			return expr.FunctionMetadata{}, false, nil
+			return nil, "", err
 		}
 	}
-	meta, ok := registeredModule.metaData.FunctionsMetadata[funcName]
-	if !ok {
-		return expr.FunctionMetadata{}, false, nil
-	}
-	return meta, true, nil
+	return registeredModule, funcName, nil
 }
 
 func (m *ModuleManager) maybeLoadModule(moduleName string) (*RegisteredModule, error) {
@@ -289,6 +309,23 @@ func (m *ModuleManager) CreateInvoker(fullFuncName string) (*Invoker, error) {
 	return registeredModule.createInvoker(funcName)
 }
 
+func (m *ModuleManager) CreateAggregateInvoker(fullFuncName string) (*AggregateInvoker, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if !m.started {
+		return nil, errors.New("not started")
+	}
+	modName, funcName, err := extractModAndFuncName(fullFuncName)
+	if err != nil {
+		return nil, err
+	}
+	registeredModule, ok := m.registeredModules[modName]
+	if !ok {
+		return nil, errors.NewTektiteErrorf(errors.WasmError, "module '%s' is not registered", modName)
+	}
+	return registeredModule.createAggregateInvoker(funcName)
+}
+
 func extractModAndFuncName(fullFuncName string) (string, string, error) {
 	pos := strings.Index(fullFuncName, ".")
 	if pos < 1 {
@@ -300,7 +337,7 @@ func extractModAndFuncName(fullFuncName string) (string, string, error) {
 }
 
 func (r *RegisteredModule) Validate() error {
-	if len(r.metaData.FunctionsMetadata) == 0 {
+	if len(r.metaData.FunctionsMetadata) == 0 && len(r.metaData.AggregatesMetadata) == 0 {
 		return errors.NewTektiteErrorf(errors.WasmError, "module '%s' does not export any functions", r.metaData.ModuleName)
 	}
 	for funcName, funcMetaData := range r.metaData.FunctionsMetadata {
@@ -312,35 +349,92 @@ func (r *RegisteredModule) Validate() error {
 			return err
 		}
 	}
+	for aggName, aggMetaData := range r.metaData.AggregatesMetadata {
+		if _, ok := r.metaData.FunctionsMetadata[aggName]; ok {
+			return errors.NewTektiteErrorf(errors.WasmError, "module '%s' defines '%s' as both a function and an aggregate function",
+				r.metaData.ModuleName, aggName)
+		}
+		if len(aggMetaData.ParamTypes) != 1 {
+			return errors.NewTektiteErrorf(errors.WasmError, "aggregate function '%s' must have exactly one parameter type but it has %d",
+				aggName, len(aggMetaData.ParamTypes))
+		}
+		// The aggregate state is opaque to Tektite and is passed to and from the module in the same way as bytes
+		signatures := []struct {
+			suffix     string
+			paramTypes []types.ColumnType
+			returnType types.ColumnType
+		}{
+			{aggInitSuffix, nil, types.ColumnTypeBytes},
+			{aggAccumulateSuffix, []types.ColumnType{types.ColumnTypeBytes, aggMetaData.ParamTypes[0]}, types.ColumnTypeBytes},
+			{aggMergeSuffix, []types.ColumnType{types.ColumnTypeBytes, types.ColumnTypeBytes}, types.ColumnTypeBytes},
+			{aggFinishSuffix, []types.ColumnType{types.ColumnTypeBytes}, aggMetaData.ReturnType},
+		}
+		for _, sig := range signatures {
+			exportName := aggName + sig.suffix
+			f := r.moduleInstances[0].instance.ExportedFunction(exportName)
+			if f == nil {
+				return errors.NewTektiteErrorf(errors.WasmError, "module '%s' does not contain function '%s' required by aggregate function '%s'",
+					r.metaData.ModuleName, exportName, aggName)
+			}
+			if err := r.checkFunctionSignature(f, sig.paramTypes, sig.returnType); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 func (r *RegisteredModule) createInvoker(funcName string) (*Invoker, error) {
-	// choose a module instance round-robin (non-strict)
-	pos := int(atomic.AddInt64(&r.instancePos, 1)) % len(r.moduleInstances)
-	wrapper := r.moduleInstances[pos]
 	meta, ok := r.metaData.FunctionsMetadata[funcName]
 	if !ok {
 		return nil, errors.NewTektiteErrorf(errors.WasmError, "function '%s' not exported from module '%s'", funcName, r.metaData.ModuleName)
 	}
-	f := wrapper.instance.ExportedFunction(funcName)
+	mem, err := r.chooseInstance()
+	if err != nil {
+		return nil, err
+	}
+	invoker := &Invoker{
+		moduleMemory: mem,
+		meta:         meta,
+		f:            mem.mod.instance.ExportedFunction(funcName),
+	}
+	return invoker, nil
+}
+
+func (r *RegisteredModule) createAggregateInvoker(aggName string) (*AggregateInvoker, error) {
+	meta, ok := r.metaData.AggregatesMetadata[aggName]
+	if !ok {
+		return nil, errors.NewTektiteErrorf(errors.WasmError, "aggregate function '%s' not exported from module '%s'", aggName, r.metaData.ModuleName)
+	}
+	mem, err := r.chooseInstance()
+	if err != nil {
+		return nil, err
+	}
+	instance := mem.mod.instance
+	invoker := &AggregateInvoker{
+		moduleMemory: mem,
+		meta:         meta,
+		init:         instance.ExportedFunction(aggName + aggInitSuffix),
+		accumulate:   instance.ExportedFunction(aggName + aggAccumulateSuffix),
+		merge:        instance.ExportedFunction(aggName + aggMergeSuffix),
+		finish:       instance.ExportedFunction(aggName + aggFinishSuffix),
+	}
+	return invoker, nil
+}
 
+func (r *RegisteredModule) chooseInstance() (moduleMemory, error) {
+	// choose a module instance round-robin (non-strict)
+	pos := int(atomic.AddInt64(&r.instancePos, 1)) % len(r.moduleInstances)
+	wrapper := r.moduleInstances[pos]
 	malloc := wrapper.instance.ExportedFunction("malloc")
 	if malloc == nil {
-		return nil, errors.NewTektiteErrorf(errors.WasmError, "module '%s' must export a 'malloc' function", r.metaData.ModuleName)
+		return moduleMemory{}, errors.NewTektiteErrorf(errors.WasmError, "module '%s' must export a 'malloc' function", r.metaData.ModuleName)
 	}
 	free := wrapper.instance.ExportedFunction("free")
-	if malloc == nil {
-		return nil, errors.NewTektiteErrorf(errors.WasmError, "module '%s' must export a 'free' function", r.metaData.ModuleName)
-	}
-	invoker := &Invoker{
-		meta:   meta,
-		mod:    wrapper,
-		f:      f,
-		malloc: malloc,
-		free:   free,
+	if free == nil {
+		return moduleMemory{}, errors.NewTektiteErrorf(errors.WasmError, "module '%s' must export a 'free' function", r.metaData.ModuleName)
 	}
-	return invoker, nil
+	return moduleMemory{mod: wrapper, malloc: malloc, free: free}, nil
 }
 
 func wasmTypesToString(wasmTypes []api.ValueType) string {
@@ -403,12 +497,17 @@ func wasmTypeForTektiteType(tt types.ColumnType) api.ValueType {
 	}
 }
 
+const (
+	aggInitSuffix       = "_init"
+	aggAccumulateSuffix = "_accumulate"
+	aggMergeSuffix      = "_merge"
+	aggFinishSuffix     = "_finish"
+)
+
 type Invoker struct {
-	meta   expr.FunctionMetadata
-	mod    *modWrapper
-	f      api.Function
-	malloc api.Function
-	free   api.Function
+	moduleMemory
+	meta expr.FunctionMetadata
+	f    api.Function
 }
 
 func (ii *Invoker) Invoke(inArgs []any) (any, error) {
@@ -418,73 +517,168 @@ func (ii *Invoker) Invoke(inArgs []any) (any, error) {
 	ctx := context.Background()
 	args := make([]uint64, 0, len(inArgs))
 	for i, inArg := range inArgs {
-		argType := ii.meta.ParamTypes[i]
-		switch argType.ID() {
-		case types.ColumnTypeIDInt:
-			args = append(args, uint64(inArg.(int64)))
-		case types.ColumnTypeIDFloat:
-			v := math.Float64bits(inArg.(float64))
-			args = append(args, v)
-		case types.ColumnTypeIDBool:
-			b := inArg.(bool)
-			if b {
-				args = append(args, uint64(1))
-			} else {
-				args = append(args, uint64(0))
-			}
-		case types.ColumnTypeIDDecimal:
-			d := inArg.(types.Decimal)
-			// Currently we pass Decimals as strings
-			arg, freeFunc, err := ii.prepareBytesArg(common.StringToByteSliceZeroCopy(d.String()), ctx)
-			if err != nil {
-				return nil, err
-			}
-			if freeFunc != nil {
-				//goland:noinspection GoDeferInLoop
-				defer freeFunc()
-			}
-			args = append(args, arg)
-		case types.ColumnTypeIDString:
-			val := inArg.(string)
-			arg, freeFunc, err := ii.prepareBytesArg(common.StringToByteSliceZeroCopy(val), ctx)
-			if err != nil {
-				return nil, err
-			}
-			if freeFunc != nil {
-				//goland:noinspection GoDeferInLoop
-				defer freeFunc()
-			}
-			args = append(args, arg)
-		case types.ColumnTypeIDBytes:
-			var val []byte
-			if inArg != nil {
-				val = inArg.([]byte)
-			}
-			arg, freeFunc, err := ii.prepareBytesArg(val, ctx)
-			if err != nil {
-				return nil, err
-			}
-			if freeFunc != nil {
-				//goland:noinspection GoDeferInLoop
-				defer freeFunc()
-			}
-			args = append(args, arg)
-		case types.ColumnTypeIDTimestamp:
-			val := inArg.(types.Timestamp).Val
-			args = append(args, uint64(val))
-		default:
-			panic("unexpected type")
+		arg, freeFunc, err := ii.encodeArg(ii.meta.ParamTypes[i], inArg, ctx)
+		if err != nil {
+			return nil, err
 		}
+		if freeFunc != nil {
+			//goland:noinspection GoDeferInLoop
+			defer freeFunc()
+		}
+		args = append(args, arg)
 	}
-
 	resArr, err := ii.f.Call(ctx, args...)
 	if err != nil {
 		return nil, errors.NewTektiteErrorf(errors.WasmError, "failed to call wasm function %s : %v",
 			ii.f.Definition().Name(), err)
 	}
+	return ii.decodeResult(ii.meta.ReturnType, resArr[0], ctx)
+}
+
+// AggregateInvoker invokes the exports that implement a user defined aggregate function. The aggregate state is passed
+// to and returned from the module as bytes.
+type AggregateInvoker struct {
+	moduleMemory
+	meta       expr.FunctionMetadata
+	init       api.Function
+	accumulate api.Function
+	merge      api.Function
+	finish     api.Function
+}
+
+func (ai *AggregateInvoker) Init() ([]byte, error) {
+	ai.mod.lock.Lock()
+	defer ai.mod.lock.Unlock()
+	return ai.callForState(context.Background(), ai.init)
+}
+
+func (ai *AggregateInvoker) Accumulate(state []byte, inArg any) ([]byte, error) {
+	ai.mod.lock.Lock()
+	defer ai.mod.lock.Unlock()
+	ctx := context.Background()
+	stateArg, freeState, err := ai.prepareBytesArg(state, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeState != nil {
+		defer freeState()
+	}
+	arg, freeArg, err := ai.encodeArg(ai.meta.ParamTypes[0], inArg, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeArg != nil {
+		defer freeArg()
+	}
+	return ai.callForState(ctx, ai.accumulate, stateArg, arg)
+}
+
+func (ai *AggregateInvoker) Merge(state1 []byte, state2 []byte) ([]byte, error) {
+	ai.mod.lock.Lock()
+	defer ai.mod.lock.Unlock()
+	ctx := context.Background()
+	arg1, freeArg1, err := ai.prepareBytesArg(state1, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeArg1 != nil {
+		defer freeArg1()
+	}
+	arg2, freeArg2, err := ai.prepareBytesArg(state2, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeArg2 != nil {
+		defer freeArg2()
+	}
+	return ai.callForState(ctx, ai.merge, arg1, arg2)
+}
+
+func (ai *AggregateInvoker) Finish(state []byte) (any, error) {
+	ai.mod.lock.Lock()
+	defer ai.mod.lock.Unlock()
+	ctx := context.Background()
+	stateArg, freeState, err := ai.prepareBytesArg(state, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeState != nil {
+		defer freeState()
+	}
+	res, err := call(ctx, ai.finish, stateArg)
+	if err != nil {
+		return nil, err
+	}
+	return ai.decodeResult(ai.meta.ReturnType, res, ctx)
+}
+
+func (ai *AggregateInvoker) callForState(ctx context.Context, f api.Function, args ...uint64) ([]byte, error) {
+	res, err := call(ctx, f, args...)
+	if err != nil {
+		return nil, err
+	}
+	bytes, freeFunc, err := ai.decodeBytesReturn(res, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if freeFunc != nil {
+		defer freeFunc()
+	}
+	// The returned bytes point into module memory which is freed on return, so we must copy them
+	return common.CopyByteSlice(bytes), nil
+}
+
+func call(ctx context.Context, f api.Function, args ...uint64) (uint64, error) {
+	resArr, err := f.Call(ctx, args...)
+	if err != nil {
+		return 0, errors.NewTektiteErrorf(errors.WasmError, "failed to call wasm function %s : %v",
+			f.Definition().Name(), err)
+	}
+	return resArr[0], nil
+}
 
-	res := resArr[0]
-	switch ii.meta.ReturnType.ID() {
+// moduleMemory handles passing values to and from a module instance, allocating and freeing memory in the instance
+// where necessary.
+type moduleMemory struct {
+	mod    *modWrapper
+	malloc api.Function
+	free   api.Function
+}
+
+func (mm *moduleMemory) encodeArg(argType types.ColumnType, inArg any, ctx context.Context) (uint64, func(), error) {
+	switch argType.ID() {
+	case types.ColumnTypeIDInt:
+		return uint64(inArg.(int64)), nil, nil
+	case types.ColumnTypeIDFloat:
+		return math.Float64bits(inArg.(float64)), nil, nil
+	case types.ColumnTypeIDBool:
+		if inArg.(bool) {
+			return uint64(1), nil, nil
+		}
+		return uint64(0), nil, nil
+	case types.ColumnTypeIDDecimal:
+		d := inArg.(types.Decimal)
+		// Currently we pass Decimals as strings
+		return mm.prepareBytesArg(common.StringToByteSliceZeroCopy(d.String()), ctx)
+	case types.ColumnTypeIDString:
+		val := inArg.(string)
+		return mm.prepareBytesArg(common.StringToByteSliceZeroCopy(val), ctx)
+	case types.ColumnTypeIDBytes:
+		var val []byte
+		if inArg != nil {
+			val = inArg.([]byte)
+		}
+		return mm.prepareBytesArg(val, ctx)
+	case types.ColumnTypeIDTimestamp:
+		val := inArg.(types.Timestamp).Val
+		return uint64(val), nil, nil
+	default:
+		panic("unexpected type")
+	}
+}
+
+func (mm *moduleMemory) decodeResult(returnType types.ColumnType, res uint64, ctx context.Context) (any, error) {
+	switch returnType.ID() {
 	case types.ColumnTypeIDInt:
 		return int64(res), nil
 	case types.ColumnTypeIDFloat:
@@ -493,17 +687,17 @@ func (ii *Invoker) Invoke(inArgs []any) (any, error) {
 		return res == 1, nil
 	case types.ColumnTypeIDDecimal:
 		// Decimals are returned as strings
-		bytes, freeFunc, err := ii.decodeBytesReturn(res, ctx)
+		bytes, freeFunc, err := mm.decodeBytesReturn(res, ctx)
 		if err != nil {
 			return nil, err
 		}
 		if freeFunc != nil {
 			defer freeFunc()
 		}
-		decType := ii.meta.ReturnType.(*types.DecimalType)
+		decType := returnType.(*types.DecimalType)
 		return types.NewDecimalFromString(common.ByteSliceToStringZeroCopy(bytes), decType.Precision, decType.Scale)
 	case types.ColumnTypeIDString:
-		bytes, freeFunc, err := ii.decodeBytesReturn(res, ctx)
+		bytes, freeFunc, err := mm.decodeBytesReturn(res, ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -512,7 +706,7 @@ func (ii *Invoker) Invoke(inArgs []any) (any, error) {
 		}
 		return common.ByteSliceToStringZeroCopy(bytes), nil
 	case types.ColumnTypeIDBytes:
-		bytes, freeFunc, err := ii.decodeBytesReturn(res, ctx)
+		bytes, freeFunc, err := mm.decodeBytesReturn(res, ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -527,21 +721,21 @@ func (ii *Invoker) Invoke(inArgs []any) (any, error) {
 	}
 }
 
-func (ii *Invoker) prepareBytesArg(val []byte, ctx context.Context) (uint64, func(), error) {
+func (mm *moduleMemory) prepareBytesArg(val []byte, ctx context.Context) (uint64, func(), error) {
 	lv := len(val)
 	if lv > 0 {
-		results, err := ii.malloc.Call(ctx, uint64(lv))
+		results, err := mm.malloc.Call(ctx, uint64(lv))
 		if err != nil {
 			return 0, nil, err
 		}
 		memPtr := results[0]
-		if !ii.mod.instance.Memory().Write(uint32(memPtr), val) {
+		if !mm.mod.instance.Memory().Write(uint32(memPtr), val) {
 			return 0, nil, errors.Errorf("Memory.Write(%d, %d) out of range of memory size %d",
-				memPtr, lv, ii.mod.instance.Memory().Size())
+				memPtr, lv, mm.mod.instance.Memory().Size())
 		}
 		arg := memPtr<<32 | uint64(lv)
 		freeFunc := func() {
-			if _, err := ii.free.Call(ctx, memPtr); err != nil {
+			if _, err := mm.free.Call(ctx, memPtr); err != nil {
 				log.Warnf("failed to free memory: %v", err)
 			}
 		}
@@ -551,22 +745,22 @@ func (ii *Invoker) prepareBytesArg(val []byte, ctx context.Context) (uint64, fun
 	}
 }
 
-func (ii *Invoker) decodeBytesReturn(res uint64, ctx context.Context) ([]byte, func(), error) {
+func (mm *moduleMemory) decodeBytesReturn(res uint64, ctx context.Context) ([]byte, func(), error) {
 	resPtr := uint32(res >> 32)
 	resSize := uint32(res)
 	var bytes []byte
 	if resSize > 0 {
 		var ok bool
-		bytes, ok = ii.mod.instance.Memory().Read(resPtr, resSize)
+		bytes, ok = mm.mod.instance.Memory().Read(resPtr, resSize)
 		if !ok {
 			return nil, nil, errors.Errorf("Memory.Read(%d, %d) out of range of memory size %d",
-				resPtr, resSize, ii.mod.instance.Memory().Size())
+				resPtr, resSize, mm.mod.instance.Memory().Size())
 		}
 	}
 	var freeFunc func()
 	if resPtr != 0 {
 		freeFunc = func() {
-			_, err := ii.free.Call(ctx, uint64(resPtr))
+			_, err := mm.free.Call(ctx, uint64(resPtr))
 			if err != nil {
 				log.Warnf("failed to free memory: %v", err)
 			}
@@ -603,3 +797,15 @@ func (w *InvokerFactory) GetFunctionMetadata(functionName string) (expr.Function
 func (w *InvokerFactory) CreateExternalInvoker(fullFunctionName string) (expr.ExternalInvoker, error) {
 	return w.ModManager.CreateInvoker(fullFunctionName)
 }
+
+func (w *InvokerFactory) GetAggregateFunctionMetadata(functionName string) (expr.FunctionMetadata, bool) {
+	meta, ok, _ := w.ModManager.GetAggregateFunctionMetadata(functionName)
+	if ok {
+		return meta, true
+	}
+	return expr.FunctionMetadata{}, false
+}
+
+func (w *InvokerFactory) CreateExternalAggregateInvoker(fullFunctionName string) (expr.ExternalAggregateInvoker, error) {
+	return w.ModManager.CreateAggregateInvoker(fullFunctionName)
+}
@@ -401,6 +401,78 @@ func TestMetaWithNoFunctions(t *testing.T) {
 	require.Equal(t, "module 'test_mod1' does not export any functions", err.Error())
 }
 
+func TestMetaWithUnknownAggregateFunction(t *testing.T) {
+	mgr := createModuleManager(t)
+	defer func() {
+		err := mgr.Stop()
+		require.NoError(t, err)
+	}()
+
+	modBytes, err := os.ReadFile("langs/tinygo/testmod1/test_mod1.wasm")
+	require.NoError(t, err)
+	meta := ModuleMetadata{
+		ModuleName: "test_mod1",
+		AggregatesMetadata: map[string]expr.FunctionMetadata{
+			"foo": {
+				ParamTypes: []types.ColumnType{types.ColumnTypeInt},
+				ReturnType: types.ColumnTypeInt,
+			},
+		},
+	}
+	err = mgr.RegisterModule(meta, modBytes)
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.WasmError))
+	require.Equal(t, "module 'test_mod1' does not contain function 'foo_init' required by aggregate function 'foo'", err.Error())
+}
+
+func TestMetaWithAggregateFunctionIncorrectParamCount(t *testing.T) {
+	mgr := createModuleManager(t)
+	defer func() {
+		err := mgr.Stop()
+		require.NoError(t, err)
+	}()
+
+	modBytes, err := os.ReadFile("langs/tinygo/testmod1/test_mod1.wasm")
+	require.NoError(t, err)
+	meta := ModuleMetadata{
+		ModuleName: "test_mod1",
+		AggregatesMetadata: map[string]expr.FunctionMetadata{
+			"foo": {
+				ParamTypes: []types.ColumnType{types.ColumnTypeInt, types.ColumnTypeInt},
+				ReturnType: types.ColumnTypeInt,
+			},
+		},
+	}
+	err = mgr.RegisterModule(meta, modBytes)
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.WasmError))
+	require.Equal(t, "aggregate function 'foo' must have exactly one parameter type but it has 2", err.Error())
+}
+
+func TestMetaWithFunctionAndAggregateFunctionSameName(t *testing.T) {
+	mgr := createModuleManager(t)
+	defer func() {
+		err := mgr.Stop()
+		require.NoError(t, err)
+	}()
+
+	modBytes, err := os.ReadFile("langs/tinygo/testmod1/test_mod1.wasm")
+	require.NoError(t, err)
+	funcMeta := expr.FunctionMetadata{
+		ParamTypes: []types.ColumnType{types.ColumnTypeInt},
+		ReturnType: types.ColumnTypeInt,
+	}
+	meta := ModuleMetadata{
+		ModuleName:         "test_mod1",
+		FunctionsMetadata:  map[string]expr.FunctionMetadata{"funcIntReturn": funcMeta},
+		AggregatesMetadata: map[string]expr.FunctionMetadata{"funcIntReturn": funcMeta},
+	}
+	err = mgr.RegisterModule(meta, modBytes)
+	require.Error(t, err)
+	require.True(t, common.IsTektiteErrorWithCode(err, errors.WasmError))
+	require.Equal(t, "module 'test_mod1' defines 'funcIntReturn' as both a function and an aggregate function", err.Error())
+}
+
 func TestMetaWithIncorrectParamTypes(t *testing.T) {
 	mgr := createModuleManager(t)
 	defer func() {
@@ -514,6 +586,55 @@ func TestModuleMetadataFromJson(t *testing.T) {
 	require.Equal(t, &decType2, funcMeta3.ReturnType)
 }
 
+func TestModuleMetadataNestedTypeFromJson(t *testing.T) {
+	str := `
+{
+    "name": "my_mod_25",
+    "functions": {
+        "func1": {
+            "paramTypes": ["array<int>"],
+            "returnType": "float"
+        }
+    }
+}
+`
+	var meta ModuleMetadata
+	err := json.Unmarshal([]byte(str), &meta)
+	require.Error(t, err)
+	require.Equal(t, "external functions do not support type 'array<int>'", err.Error())
+}
+
+func TestModuleMetadataWithAggregatesFromJson(t *testing.T) {
+	str := `
+{
+    "name": "my_mod_24",
+    "functions": {},
+    "aggregates": {
+        "agg1": {
+            "paramTypes": ["float"],
+            "returnType": "string"
+        }
+    }
+}
+`
+	var meta ModuleMetadata
+	err := json.Unmarshal([]byte(str), &meta)
+	require.NoError(t, err)
+
+	require.Equal(t, "my_mod_24", meta.ModuleName)
+	require.Equal(t, 0, len(meta.FunctionsMetadata))
+	require.Equal(t, 1, len(meta.AggregatesMetadata))
+	aggMeta, ok := meta.AggregatesMetadata["agg1"]
+	require.True(t, ok)
+	require.Equal(t, []types.ColumnType{types.ColumnTypeFloat}, aggMeta.ParamTypes)
+	require.Equal(t, types.ColumnTypeString, aggMeta.ReturnType)
+
+	var meta2 ModuleMetadata
+	err = json.Unmarshal(meta.ToJsonBytes(), &meta2)
+	require.NoError(t, err)
+	require.Equal(t, meta, meta2)
+}
+
 func TestGetFunctionMetadata(t *testing.T) {
 	decType := &types.DecimalType{
 		Precision: types.DefaultDecimalPrecision,